@@ -0,0 +1,106 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressNodePolicy is a policy whose egress IP is homed on the node
+type EgressNodePolicy struct {
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	EgressIP string `json:"egressIP,omitempty"`
+
+	Provider string `json:"provider"`
+
+	// +kubebuilder:validation:Optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// Healthy is true when the egress IP is announced by the node and its claim is not
+	// stale
+	Healthy bool `json:"healthy"`
+
+	// Outcome is what happens to the policy when the node is lost: Moved, Stranded,
+	// Manual or Paused
+	Outcome string `json:"outcome"`
+
+	// To is the node where the egress IP likely lands when the node is lost
+	// +kubebuilder:validation:Optional
+	To string `json:"to,omitempty"`
+}
+
+// EgressNodeStatusStatus is the egress state of the node observed by the operator
+type EgressNodeStatusStatus struct {
+	Ready   bool `json:"ready"`
+	Drained bool `json:"drained"`
+
+	// Capacity is the maximum number of egress IPs of the node, from the
+	// cilium.angeloxx.ch/egress-capacity annotation of the Node, zero when not set
+	// +kubebuilder:validation:Optional
+	Capacity int32 `json:"capacity,omitempty"`
+
+	// EgressIPs is the number of egress IPs homed on the node, Stranded the ones without
+	// another eligible node
+	EgressIPs int32 `json:"egressIPs"`
+	Stranded  int32 `json:"stranded"`
+
+	// Policies are the policies whose egress IP is homed on the node, by priority
+	// +kubebuilder:validation:Optional
+	Policies []EgressNodePolicy `json:"policies,omitempty"`
+
+	// StandbyPolicies are the policies with the node as standby of their gateway group
+	// +kubebuilder:validation:Optional
+	StandbyPolicies []string `json:"standbyPolicies,omitempty"`
+
+	// LastUpdateTime is the time of the last change of the status
+	// +kubebuilder:validation:Optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+//+kubebuilder:printcolumn:name="Egress IPs",type=integer,JSONPath=`.status.egressIPs`
+//+kubebuilder:printcolumn:name="Capacity",type=integer,JSONPath=`.status.capacity`
+//+kubebuilder:printcolumn:name="Stranded",type=integer,JSONPath=`.status.stranded`
+//+kubebuilder:printcolumn:name="Drained",type=boolean,JSONPath=`.status.drained`,priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".status.lastUpdateTime",description="Time since last change"
+
+// EgressNodeStatus is the read-only view, maintained by the operator, of the egress IPs
+// homed on a node, named after its hostname
+type EgressNodeStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status EgressNodeStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// EgressNodeStatusList contains a list of EgressNodeStatus
+type EgressNodeStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressNodeStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressNodeStatus{}, &EgressNodeStatusList{})
+}
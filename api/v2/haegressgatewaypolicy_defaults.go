@@ -0,0 +1,71 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EligibleNodesDefault is the key of the default nodeSelector in the namespace defaults of
+// the status
+const EligibleNodesDefault = "eligibleNodes"
+
+// Annotation returns the annotation of the policy or, when it is unset, the default of its
+// Service namespace recorded in the status
+func (policy *HAEgressGatewayPolicy) Annotation(key string) string {
+	if value := policy.Annotations[key]; value != "" {
+		return value
+	}
+	return policy.Status.NamespaceDefaults[key]
+}
+
+// EgressNodeSelector returns the nodeSelector of the egress gateway of the policy or, when
+// it is empty, the default eligible nodes of its Service namespace recorded in the status
+func (policy *HAEgressGatewayPolicy) EgressNodeSelector() *slimv1.LabelSelector {
+	var nodeSelector *slimv1.LabelSelector
+	if policy.Spec.EgressGateway != nil {
+		nodeSelector = policy.Spec.EgressGateway.NodeSelector
+	}
+	if nodeSelector != nil && (len(nodeSelector.MatchLabels) > 0 || len(nodeSelector.MatchExpressions) > 0) {
+		return nodeSelector
+	}
+	value := policy.Status.NamespaceDefaults[EligibleNodesDefault]
+	if value == "" {
+		return nodeSelector
+	}
+	// The default was formatted from a valid selector by the operator
+	selector, err := metav1.ParseToLabelSelector(value)
+	if err != nil {
+		return nodeSelector
+	}
+	nodeSelector = &slimv1.LabelSelector{}
+	if len(selector.MatchLabels) > 0 {
+		nodeSelector.MatchLabels = map[string]slimv1.MatchLabelsValue{}
+		for label, labelValue := range selector.MatchLabels {
+			nodeSelector.MatchLabels[label] = labelValue
+		}
+	}
+	for _, expression := range selector.MatchExpressions {
+		nodeSelector.MatchExpressions = append(nodeSelector.MatchExpressions, slimv1.LabelSelectorRequirement{
+			Key:      expression.Key,
+			Operator: slimv1.LabelSelectorOperator(expression.Operator),
+			Values:   expression.Values,
+		})
+	}
+	return nodeSelector
+}
@@ -22,6 +22,39 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// HAEgressGatewayPolicySpec is the spec of the generated CiliumEgressGatewayPolicy, with
+// the destinations resolved by the operator
+type HAEgressGatewayPolicySpec struct {
+	ciliumv2.CiliumEgressGatewayPolicySpec `json:",inline"`
+
+	// DestinationFQDNs are DNS names resolved by the operator, as long as their records
+	// live, their IPv4 addresses are added to the destinationCIDRs of the
+	// CiliumEgressGatewayPolicy
+	// +kubebuilder:validation:Optional
+	DestinationFQDNs []string `json:"destinationFQDNs,omitempty"`
+
+	// DestinationProviders are ranges published by the providers, as feed:name, e.g.
+	// azure:AzureActiveDirectory, fetched by the operator from the configured feeds, their
+	// IPv4 CIDRs are added to the destinationCIDRs of the CiliumEgressGatewayPolicy
+	// +kubebuilder:validation:Optional
+	DestinationProviders []string `json:"destinationProviders,omitempty"`
+
+	// NamespaceSelector fans the policy out to the matched namespaces: the operator keeps
+	// one CiliumEgressGatewayPolicy per namespace, with the selectors restricted to its
+	// pods, behind the single egress IP of the policy, and follows the namespaces as they
+	// come and go
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Priority orders the work of the operator when many policies change together, e.g.
+	// when an exit node fails: the policies with a higher priority converge first, the
+	// policies with the same priority in name order
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=-1000
+	// +kubebuilder:validation:Maximum=1000
+	Priority int32 `json:"priority,omitempty"`
+}
+
 // HAEgressGatewayPolicy defines the observed state of haEgressGatewayPolicy
 type HAEgressGatewayPolicyStatus struct {
 	ServiceCreated bool `json:"serviceCreated"`
@@ -33,8 +66,62 @@ type HAEgressGatewayPolicyStatus struct {
 	// +kubebuilder:validation:Optional
 	IPAddress string `json:"ipAddress,omitempty"`
 
+	// IPv4Address and IPv6Address are the egress IPs of each family assigned to the Service,
+	// a dual-stack Service gets both
+	// +kubebuilder:validation:Optional
+	IPv4Address string `json:"ipv4Address,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	IPv6Address string `json:"ipv6Address,omitempty"`
+
+	// ServiceNamespace is the namespace of the Service of the policy
+	// +kubebuilder:validation:Optional
+	ServiceNamespace string `json:"serviceNamespace,omitempty"`
+
+	// RetargetedFrom is the previous namespace of the Service while the Service and the
+	// CiliumEgressGatewayPolicy of that namespace are replaced
+	// +kubebuilder:validation:Optional
+	RetargetedFrom string `json:"retargetedFrom,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	LastModifiedTime metav1.Time `json:"lastModifiedTime,omitempty"`
+
+	// NamespaceDefaults are the defaults of the Service namespace used by the policy, keyed
+	// by the annotation of the policy they stand for, or eligibleNodes for its nodeSelector.
+	// They are merged when the desired state is computed, never written into the policy
+	// +kubebuilder:validation:Optional
+	NamespaceDefaults map[string]string `json:"namespaceDefaults,omitempty"`
+
+	// Conditions are the observations of the operator on the policy, like Stale when the
+	// holder of the VIP stopped renewing its claim
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// SelectorPreview is what the selectors of the policy matched when its spec last
+	// changed
+	// +kubebuilder:validation:Optional
+	SelectorPreview *SelectorPreview `json:"selectorPreview,omitempty"`
+}
+
+// SelectorPreview lists the namespaces and counts the pods matched by the selectors, with
+// the warnings about the selectors too broad
+type SelectorPreview struct {
+	// ObservedGeneration is the generation of the policy previewed
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Namespaces are the first matched namespaces, NamespaceCount all of them
+	// +kubebuilder:validation:Optional
+	Namespaces     []string `json:"namespaces,omitempty"`
+	NamespaceCount int32    `json:"namespaceCount"`
+
+	// Pods is the number of matched pods, not set when the pods are not counted
+	// +kubebuilder:validation:Optional
+	Pods *int32 `json:"pods,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -42,6 +129,9 @@ type HAEgressGatewayPolicyStatus struct {
 //+kubebuilder:resource:scope=Cluster
 //+kubebuilder:printcolumn:name="IP Address",type=string,JSONPath=`.status.ipAddress`
 //+kubebuilder:printcolumn:name="Exit Node",type=string,JSONPath=`.status.exitNode`
+//+kubebuilder:printcolumn:name="IPv4",type=string,JSONPath=`.status.ipv4Address`,priority=1
+//+kubebuilder:printcolumn:name="IPv6",type=string,JSONPath=`.status.ipv6Address`,priority=1
+//+kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`,priority=1
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".status.lastModifiedTime",description="Time since last modification"
 
 // haEgressGatewayPolicy is the Schema for the haegressgatewaypolicies API
@@ -49,8 +139,8 @@ type HAEgressGatewayPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   ciliumv2.CiliumEgressGatewayPolicySpec `json:"spec,omitempty"`
-	Status HAEgressGatewayPolicyStatus            `json:"status,omitempty"`
+	Spec   HAEgressGatewayPolicySpec   `json:"spec,omitempty"`
+	Status HAEgressGatewayPolicyStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
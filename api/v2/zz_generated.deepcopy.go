@@ -22,16 +22,116 @@ limitations under the License.
 package v2
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNodePolicy) DeepCopyInto(out *EgressNodePolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodePolicy.
+func (in *EgressNodePolicy) DeepCopy() *EgressNodePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNodePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNodeStatus) DeepCopyInto(out *EgressNodeStatus) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeStatus.
+func (in *EgressNodeStatus) DeepCopy() *EgressNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressNodeStatus) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNodeStatusList) DeepCopyInto(out *EgressNodeStatusList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressNodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeStatusList.
+func (in *EgressNodeStatusList) DeepCopy() *EgressNodeStatusList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNodeStatusList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressNodeStatusList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNodeStatusStatus) DeepCopyInto(out *EgressNodeStatusStatus) {
+	*out = *in
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]EgressNodePolicy, len(*in))
+		copy(*out, *in)
+	}
+	if in.StandbyPolicies != nil {
+		in, out := &in.StandbyPolicies, &out.StandbyPolicies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeStatusStatus.
+func (in *EgressNodeStatusStatus) DeepCopy() *EgressNodeStatusStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNodeStatusStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HAEgressGatewayPolicy) DeepCopyInto(out *HAEgressGatewayPolicy) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicy.
@@ -84,9 +184,60 @@ func (in *HAEgressGatewayPolicyList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HAEgressGatewayPolicySpec) DeepCopyInto(out *HAEgressGatewayPolicySpec) {
+	*out = *in
+	in.CiliumEgressGatewayPolicySpec.DeepCopyInto(&out.CiliumEgressGatewayPolicySpec)
+	if in.DestinationFQDNs != nil {
+		in, out := &in.DestinationFQDNs, &out.DestinationFQDNs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DestinationProviders != nil {
+		in, out := &in.DestinationProviders, &out.DestinationProviders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicySpec.
+func (in *HAEgressGatewayPolicySpec) DeepCopy() *HAEgressGatewayPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HAEgressGatewayPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HAEgressGatewayPolicyStatus) DeepCopyInto(out *HAEgressGatewayPolicyStatus) {
 	*out = *in
+	in.LastModifiedTime.DeepCopyInto(&out.LastModifiedTime)
+	if in.NamespaceDefaults != nil {
+		in, out := &in.NamespaceDefaults, &out.NamespaceDefaults
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SelectorPreview != nil {
+		in, out := &in.SelectorPreview, &out.SelectorPreview
+		*out = new(SelectorPreview)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicyStatus.
@@ -98,3 +249,33 @@ func (in *HAEgressGatewayPolicyStatus) DeepCopy() *HAEgressGatewayPolicyStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelectorPreview) DeepCopyInto(out *SelectorPreview) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelectorPreview.
+func (in *SelectorPreview) DeepCopy() *SelectorPreview {
+	if in == nil {
+		return nil
+	}
+	out := new(SelectorPreview)
+	in.DeepCopyInto(out)
+	return out
+}
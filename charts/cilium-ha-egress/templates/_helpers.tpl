@@ -60,3 +60,53 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+The Service permissions are restricted to the Roles of the watched or of the service namespaces
+*/}}
+{{- define "cilium-haegress-operator.namespacedServices" -}}
+{{- if or .Values.watchNamespaces .Values.serviceNamespaces }}true{{- end }}
+{{- end }}
+
+{{/*
+The write verbs of the rules, none in read-only mode
+*/}}
+{{- define "cilium-haegress-operator.writeVerbs" -}}
+{{- if not .Values.readOnly }}, "create", "update", "patch", "delete"{{- end }}
+{{- end }}
+
+{{/*
+The feature gates as Feature=bool pairs separated by commas
+*/}}
+{{- define "cilium-haegress-operator.featureGates" -}}
+{{- $gates := list }}
+{{- range $feature, $enabled := . }}
+{{- $gates = append $gates (printf "%s=%t" $feature $enabled) }}
+{{- end }}
+{{- join "," $gates }}
+{{- end }}
+
+{{/*
+The labels as key=value pairs separated by commas
+*/}}
+{{- define "cilium-haegress-operator.labelList" -}}
+{{- $labels := list }}
+{{- range $key, $value := . }}
+{{- $labels = append $labels (printf "%s=%s" $key $value) }}
+{{- end }}
+{{- join "," $labels }}
+{{- end }}
+
+{{/*
+The Prometheus Operator objects created by the operator, separated by commas
+*/}}
+{{- define "cilium-haegress-operator.monitoringObjects" -}}
+{{- if and .serviceMonitor .podMonitor }}
+{{- fail "monitoring.serviceMonitor and monitoring.podMonitor scrape the same metrics, choose one" }}
+{{- end }}
+{{- $objects := list }}
+{{- if .serviceMonitor }}{{ $objects = append $objects "ServiceMonitor" }}{{ end }}
+{{- if .podMonitor }}{{ $objects = append $objects "PodMonitor" }}{{ end }}
+{{- if .prometheusRule }}{{ $objects = append $objects "PrometheusRule" }}{{ end }}
+{{- join "," $objects }}
+{{- end }}
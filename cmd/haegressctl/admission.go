@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/admission"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	commands["admission-policy"] = command{
+		usage:       "admission-policy [--allowed-cidrs <cidrs>] [--action Deny]",
+		description: "Print the ValidatingAdmissionPolicy enforcing the invariants of the operator",
+		run:         admissionPolicy,
+		offline:     true,
+	}
+}
+
+func admissionPolicy(_ context.Context, c *cli, args []string) error {
+	flags := commandFlags("admission-policy")
+	name := flags.String("name", admission.DefaultName, "The name of the ValidatingAdmissionPolicy and of its binding")
+	apiVersion := flags.String("api-version", admission.APIVersions[0], "The version of the admissionregistration.k8s.io API, v1beta1 before Kubernetes 1.30")
+	actions := flags.String("action", string(admissionregistrationv1beta1.Deny), "The comma separated validation actions of the binding: Deny, Warn or Audit")
+	serviceNamespaces := flags.String("service-namespaces", "", "The --service-namespaces of the operator")
+	excludeNamespaces := flags.String("exclude-namespaces", "", "The --exclude-namespaces of the operator")
+	allowedSourceNamespaces := flags.String("allowed-source-namespaces", "", "The --allowed-source-namespaces of the operator")
+	deniedSourceNamespaces := flags.String("denied-source-namespaces", "", "The --denied-source-namespaces of the operator")
+	allowedCIDRs := flags.String("allowed-cidrs", "", "The comma separated IPv4 CIDRs the destinationCIDRs of the policies must be part of, empty for every destination")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	options := admission.Options{
+		Name:                    *name,
+		APIVersion:              *apiVersion,
+		EgressNamespace:         c.EgressNamespace,
+		ServiceNamespaces:       splitList(*serviceNamespaces),
+		ExcludeNamespaces:       splitList(*excludeNamespaces),
+		AllowedSourceNamespaces: splitList(*allowedSourceNamespaces),
+		DeniedSourceNamespaces:  splitList(*deniedSourceNamespaces),
+		AllowedCIDRs:            splitList(*allowedCIDRs),
+	}
+	for _, action := range splitList(*actions) {
+		options.ValidationActions = append(options.ValidationActions, admissionregistrationv1beta1.ValidationAction(action))
+	}
+	objects, err := admission.Generate(options)
+	if err != nil {
+		return err
+	}
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(c.Out, "---")
+		}
+		if _, err := c.Out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList returns the items of a comma separated list, without the empty ones
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/bindings"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	commands["backup"] = command{
+		usage:       "backup [-o <file>]",
+		description: "Export the egress IP of every policy",
+		run:         backup,
+	}
+	commands["restore"] = command{
+		usage:       "restore <file> [--dry-run]",
+		description: "Restore the egress IPs of a backup, requested when the policies are created",
+		run:         restore,
+	}
+}
+
+func backup(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("backup")
+	output := flags.String("o", "", "The file the backup is written to, the standard output when empty")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	exported, err := bindings.Export(ctx, c.Client, c.EgressNamespace, c.DefaultProvider)
+	if err != nil {
+		return fmt.Errorf("unable to list the HAEgressGatewayPolicies: %w", err)
+	}
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err = c.Out.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "%d bindings written to %s\n", len(exported.Bindings), *output)
+	return nil
+}
+
+// Result of a restored binding
+const (
+	restoreBound    = "Bound"
+	restoreRestored = "Restored"
+	restoreConflict = "Conflict"
+)
+
+func restore(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("restore")
+	configMap := flags.String("configmap", bindings.DefaultConfigMapName, "The --bindings-configmap of the operator")
+	dryRun := flags.Bool("dry-run", false, "Only report what would be restored")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if positional[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(positional[0])
+	}
+	if err != nil {
+		return err
+	}
+	restored := &bindings.Backup{}
+	if err := yaml.Unmarshal(data, restored); err != nil {
+		return fmt.Errorf("invalid backup %s: %w", positional[0], err)
+	}
+	if err := restored.Validate(); err != nil {
+		return fmt.Errorf("invalid backup %s: %w", positional[0], err)
+	}
+
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return fmt.Errorf("unable to list the HAEgressGatewayPolicies: %w", err)
+	}
+	current := map[string]string{}
+	owners := map[string]string{}
+	for _, policy := range policies.Items {
+		current[policy.Name] = policy.Status.IPAddress
+		if policy.Status.IPAddress != "" {
+			owners[policy.Status.IPAddress] = policy.Name
+		}
+	}
+
+	// The bindings of the policies with a Service are requested only if the Service is
+	// created again, the ones already in place are not saved
+	var pending []bindings.Binding
+	conflicts := 0
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tIP\tRESULT\tMESSAGE")
+	for _, binding := range restored.Bindings {
+		result, message := restoreRestored, "requested when the policy is created"
+		ip, exists := current[binding.Policy]
+		owner, used := owners[binding.EgressIP]
+		switch {
+		case ip == binding.EgressIP:
+			result, message = restoreBound, "the policy already has the egress IP"
+		case used:
+			result, message = restoreConflict, fmt.Sprintf("the egress IP is bound to %s", owner)
+		case exists && ip != "":
+			result, message = restoreConflict, fmt.Sprintf("the policy is bound to %s, delete its Service to request the restored IP", ip)
+		case exists:
+			message = "requested if the Service of the policy is created again"
+		}
+		if result == restoreConflict {
+			conflicts++
+		}
+		if result != restoreBound && !used {
+			pending = append(pending, binding)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", binding.Policy, binding.EgressIP, result, message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !*dryRun && len(pending) > 0 {
+		store := &bindings.Store{Client: c.Client, Reader: c.Client, Namespace: c.EgressNamespace, ConfigMapName: *configMap}
+		if err := store.Save(ctx, pending); err != nil {
+			return fmt.Errorf("unable to save the bindings in the ConfigMap %s/%s: %w", c.EgressNamespace, *configMap, err)
+		}
+		fmt.Fprintf(c.Out, "%d bindings saved in the ConfigMap %s/%s\n", len(pending), c.EgressNamespace, *configMap)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d bindings can't be restored as they are", conflicts)
+	}
+	return nil
+}
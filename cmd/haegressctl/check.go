@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/preflight"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func init() {
+	commands["check"] = command{
+		usage:       "check [--operator-service-account <namespace/name>]",
+		description: "Verify the prerequisites of the operator and print a report",
+		run:         check,
+	}
+}
+
+func check(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("check")
+	ciliumNamespace := flags.String("cilium-namespace", "kube-system", "The namespace where Cilium is installed")
+	loadBalancerClass := flags.String("load-balancer-class", "kube-vip.io/kube-vip-class", "The LoadBalancer class of the kube-vip provider, as configured in the operator")
+	serviceAccount := flags.String("operator-service-account", "cilium-haegress-operator-system/cilium-haegress-operator-controller-manager",
+		"The namespace/name of the ServiceAccount of the operator whose permissions are verified, empty to skip the check")
+	ipamWebhookURL := flags.String("ipam-webhook-url", "", "The URL of the IPAM webhook, reachable from where the check runs, empty to skip the check")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+
+	prerequisites := &preflight.Prerequisites{
+		Client:            c.Client,
+		CiliumNamespace:   *ciliumNamespace,
+		EgressNamespace:   c.EgressNamespace,
+		Provider:          c.DefaultProvider,
+		LoadBalancerClass: *loadBalancerClass,
+		IPAMWebhookURL:    *ipamWebhookURL,
+	}
+	if *serviceAccount != "" {
+		namespace, name, ok := strings.Cut(*serviceAccount, "/")
+		if !ok {
+			return fmt.Errorf("invalid --operator-service-account %q, expected namespace/name", *serviceAccount)
+		}
+		prerequisites.ServiceAccount = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	report := prerequisites.Run(ctx)
+	if err := report.Print(c.Out); err != nil {
+		return err
+	}
+	if report.Failed() {
+		return errors.New("some prerequisites are not met")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func init() {
+	commands["describe"] = command{
+		usage:       "describe <policy>",
+		description: "Show the status, the generated objects and the events of a policy",
+		run:         describe,
+	}
+}
+
+func describe(ctx context.Context, c *cli, args []string) error {
+	positional, err := parseArgs(commandFlags("describe"), args, 1)
+	if err != nil {
+		return err
+	}
+	policy, err := c.getPolicy(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	nodes, err := c.nodesByHostname(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the nodes: %w", err)
+	}
+	state, err := c.state(ctx, policy, nodes)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", policy.Name)
+	fmt.Fprintf(w, "Provider:\t%s\n", state.Provider)
+	fmt.Fprintf(w, "Health:\t%s\n", state.Health)
+	if state.Reason != "" {
+		fmt.Fprintf(w, "Reason:\t%s\n", state.Reason)
+	}
+	fmt.Fprintf(w, "Egress IP:\t%s\n", orNone(policy.Status.IPAddress))
+	fmt.Fprintf(w, "Exit node:\t%s\n", orNone(policy.Status.ExitNode))
+	if !policy.Status.LastModifiedTime.IsZero() {
+		fmt.Fprintf(w, "Last modified:\t%s\n", policy.Status.LastModifiedTime.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+
+	var annotations []string
+	for key, value := range policy.Annotations {
+		if strings.HasPrefix(key, "cilium.angeloxx.ch/") {
+			annotations = append(annotations, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	sort.Strings(annotations)
+	fmt.Fprintf(w, "Annotations:\t%s\n", orNone(strings.Join(annotations, "\n\t")))
+
+	if state.Service != nil {
+		fmt.Fprintf(w, "Service:\t%s/%s\n", state.Service.Namespace, state.Service.Name)
+		fmt.Fprintf(w, "  Type:\t%s\n", state.Service.Spec.Type)
+		var ips []string
+		for _, ingress := range state.Service.Status.LoadBalancer.Ingress {
+			ips = append(ips, ingress.IP)
+		}
+		fmt.Fprintf(w, "  LoadBalancer IPs:\t%s\n", orNone(strings.Join(ips, ",")))
+	} else {
+		fmt.Fprintf(w, "Service:\t%s/%s (not found)\n", state.ServiceNamespace, policy.Name)
+	}
+
+	if cegp := state.CiliumEgressGatewayPolicy; cegp != nil {
+		fmt.Fprintf(w, "CiliumEgressGatewayPolicy:\t%s\n", cegp.Name)
+		if gateway := cegp.Spec.EgressGateway; gateway != nil {
+			fmt.Fprintf(w, "  Egress IP:\t%s\n", orNone(gateway.EgressIP))
+			if gateway.Interface != "" {
+				fmt.Fprintf(w, "  Interface:\t%s\n", gateway.Interface)
+			}
+			if gateway.NodeSelector != nil {
+				fmt.Fprintf(w, "  Node selector:\t%s\n", gateway.NodeSelector.String())
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "CiliumEgressGatewayPolicy:\t%s-%s (not found)\n", state.ServiceNamespace, policy.Name)
+	}
+
+	// Events of cluster scoped objects are recorded in the default namespace
+	events := &corev1.EventList{}
+	err = c.List(ctx, events, client.InNamespace(corev1.NamespaceDefault),
+		client.MatchingFields{"involvedObject.kind": "HAEgressGatewayPolicy", "involvedObject.name": policy.Name})
+	if err != nil {
+		return fmt.Errorf("unable to list the events: %w", err)
+	}
+	sort.Slice(events.Items, func(i, j int) bool {
+		return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+	})
+	if len(events.Items) == 0 {
+		fmt.Fprintf(w, "Events:\t<none>\n")
+	} else {
+		fmt.Fprintf(w, "Events:\n  TYPE\tREASON\tLAST SEEN\tMESSAGE\n")
+		for _, event := range events.Items {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", event.Type, event.Reason,
+				event.LastTimestamp.UTC().Format("2006-01-02T15:04:05Z"), event.Message)
+		}
+	}
+	return w.Flush()
+}
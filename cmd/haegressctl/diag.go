@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	commands["diag"] = command{
+		usage:       "diag [--output <file>]",
+		description: "Collect the logs, objects and metrics of the operator in an archive for a bug report",
+		run:         diag,
+	}
+}
+
+// secretPattern matches the credentials that may be logged, e.g. in the URL of a webhook
+var secretPattern = regexp.MustCompile(`(?i)((?:token|password|secret|apikey|api-key|authorization)["']?\s*[:=]\s*["']?(?:bearer\s+)?)[^\s"',}&]+`)
+
+// volatileAnnotations are removed from the collected objects
+var volatileAnnotations = []string{"kubectl.kubernetes.io/last-applied-configuration"}
+
+// bundle is the archive being written
+type bundle struct {
+	writer *tar.Writer
+	now    time.Time
+}
+
+func (b *bundle) add(name string, content []byte) error {
+	header := &tar.Header{Name: "haegress-diag/" + name, Mode: 0o644, Size: int64(len(content)), ModTime: b.now}
+	if err := b.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := b.writer.Write(content)
+	return err
+}
+
+// addObjects adds the objects as a YAML list, without the managed fields and the volatile
+// annotations
+func (b *bundle) addObjects(name string, objects []client.Object) error {
+	var content bytes.Buffer
+	for _, object := range objects {
+		object.SetManagedFields(nil)
+		annotations := object.GetAnnotations()
+		for _, annotation := range volatileAnnotations {
+			delete(annotations, annotation)
+		}
+		object.SetAnnotations(annotations)
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		content.WriteString("---\n")
+		content.Write(data)
+	}
+	return b.add(name, content.Bytes())
+}
+
+func diag(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("diag")
+	output := flags.String("output", "", "The archive written, haegress-diag-<timestamp>.tar.gz by default")
+	operatorNamespace := flags.String("operator-namespace", "cilium-haegress-operator-system", "The namespace of the operator")
+	operatorSelector := flags.String("operator-selector", "control-plane=controller-manager", "The label selector of the pods of the operator")
+	metricsPort := flags.String("metrics-port", "8080", "The port of the metrics endpoint of the operator")
+	logLines := flags.Int64("log-lines", 5000, "The number of lines collected from the end of the log of every container")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	selector, err := labels.Parse(*operatorSelector)
+	if err != nil {
+		return fmt.Errorf("invalid --operator-selector: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if *output == "" {
+		*output = fmt.Sprintf("haegress-diag-%s.tar.gz", now.Format("20060102-150405"))
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed := gzip.NewWriter(file)
+	b := &bundle{writer: tar.NewWriter(compressed), now: now}
+
+	// A part that can't be collected is reported in the archive, the rest is still useful
+	var problems []string
+	collect := func(part string, fn func() error) {
+		if err := fn(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", part, err))
+		}
+	}
+
+	collect("summary", func() error { return collectSummary(ctx, c, b) })
+	collect("objects", func() error { return collectObjects(ctx, c, b) })
+	collect("nodes", func() error { return collectNodes(ctx, c, b) })
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(*operatorNamespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		problems = append(problems, fmt.Sprintf("operator pods: %v", err))
+	}
+	if len(pods.Items) == 0 {
+		problems = append(problems, fmt.Sprintf("operator pods: none matching %s in %s", *operatorSelector, *operatorNamespace))
+	}
+	for _, pod := range pods.Items {
+		pod := pod
+		collect("logs of "+pod.Name, func() error { return collectLogs(ctx, clientset, b, &pod, *logLines) })
+		collect("metrics of "+pod.Name, func() error { return collectMetrics(ctx, clientset, b, &pod, *metricsPort) })
+	}
+	collect("pods", func() error {
+		objects := make([]client.Object, 0, len(pods.Items))
+		for i := range pods.Items {
+			objects = append(objects, &pods.Items[i])
+		}
+		return b.addObjects("operator/pods.yaml", objects)
+	})
+
+	if len(problems) > 0 {
+		if err := b.add("problems.txt", []byte(strings.Join(problems, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := b.writer.Close(); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(c.Out, "Not collected: %s\n", problem)
+	}
+	fmt.Fprintf(c.Out, "Diagnostics written to %s\n", *output)
+	return nil
+}
+
+// collectSummary adds the table of the list command
+func collectSummary(ctx context.Context, c *cli, b *bundle) error {
+	var content bytes.Buffer
+	summary := *c
+	summary.Out = &content
+	if err := list(ctx, &summary, nil); err != nil {
+		return err
+	}
+	return b.add("summary.txt", content.Bytes())
+}
+
+// collectObjects adds the policies and the Services and CiliumEgressGatewayPolicies
+// generated by the operator
+func collectObjects(ctx context.Context, c *cli, b *bundle) error {
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return err
+	}
+	objects := make([]client.Object, 0, len(policies.Items))
+	for i := range policies.Items {
+		objects = append(objects, &policies.Items[i])
+	}
+	if err := b.addObjects("objects/haegressgatewaypolicies.yaml", objects); err != nil {
+		return err
+	}
+
+	services := &corev1.ServiceList{}
+	if err := c.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return err
+	}
+	objects = make([]client.Object, 0, len(services.Items))
+	for i := range services.Items {
+		objects = append(objects, &services.Items[i])
+	}
+	if err := b.addObjects("objects/services.yaml", objects); err != nil {
+		return err
+	}
+
+	cegps := &ciliumv2.CiliumEgressGatewayPolicyList{}
+	if err := c.List(ctx, cegps); err != nil {
+		return err
+	}
+	objects = make([]client.Object, 0, len(cegps.Items))
+	for i := range cegps.Items {
+		for _, owner := range cegps.Items[i].OwnerReferences {
+			if owner.Kind == "HAEgressGatewayPolicy" {
+				objects = append(objects, &cegps.Items[i])
+				break
+			}
+		}
+	}
+	return b.addObjects("objects/ciliumegressgatewaypolicies.yaml", objects)
+}
+
+// collectNodes adds the labels, the readiness and the addresses of the nodes
+func collectNodes(ctx context.Context, c *cli, b *bundle) error {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return err
+	}
+	var content bytes.Buffer
+	w := tabwriter.NewWriter(&content, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tREADY\tPROVIDER ID\tADDRESSES\tLABELS")
+	for _, node := range nodes.Items {
+		var addresses []string
+		for _, address := range node.Status.Addresses {
+			addresses = append(addresses, address.Address)
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\n", node.Name, haegressiputil.IsNodeReady(&node), orNone(node.Spec.ProviderID),
+			strings.Join(addresses, ","), labels.Set(node.Labels).String())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return b.add("nodes.txt", content.Bytes())
+}
+
+// collectLogs adds the current and the previous logs of the containers of the pod, with
+// the credentials redacted
+func collectLogs(ctx context.Context, clientset kubernetes.Interface, b *bundle, pod *corev1.Pod, lines int64) error {
+	for _, container := range pod.Spec.Containers {
+		for _, previous := range []bool{false, true} {
+			options := &corev1.PodLogOptions{Container: container.Name, TailLines: &lines, Previous: previous}
+			data, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).DoRaw(ctx)
+			if err != nil {
+				if previous {
+					// There is no previous log until the container restarts
+					continue
+				}
+				return err
+			}
+			name := fmt.Sprintf("operator/logs/%s-%s.log", pod.Name, container.Name)
+			if previous {
+				name = fmt.Sprintf("operator/logs/%s-%s.previous.log", pod.Name, container.Name)
+			}
+			if err := b.add(name, secretPattern.ReplaceAll(data, []byte("${1}<redacted>"))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectMetrics adds the metrics of the operator, read through the API server proxy
+func collectMetrics(ctx context.Context, clientset kubernetes.Interface, b *bundle, pod *corev1.Pod, port string) error {
+	data, err := clientset.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, port, "/metrics", nil).DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+	// Only the metrics of the operator and of the controllers, not the ones of the runtime
+	var content bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if strings.HasPrefix(name, "haegress_") || strings.HasPrefix(name, "controller_runtime_") ||
+			strings.HasPrefix(name, "workqueue_") || strings.HasPrefix(name, "rest_client_") {
+			content.WriteString(line)
+			content.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return b.add(fmt.Sprintf("operator/metrics/%s.txt", pod.Name), content.Bytes())
+}
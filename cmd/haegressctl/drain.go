@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func init() {
+	commands["drain-node"] = command{
+		usage:       "drain-node <node> [--undo]",
+		description: "Move the egress IPs away from a node and keep them off it",
+		run:         drainNode,
+	}
+}
+
+func drainNode(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("drain-node")
+	undo := flags.Bool("undo", false, "Make the node eligible again as exit node")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: positional[0]}, node); err != nil {
+		return fmt.Errorf("unable to get the node %s: %w", positional[0], err)
+	}
+
+	if *undo {
+		if err := patchMetadata(ctx, c, node, "labels", map[string]interface{}{haegressip.EgressDrainedNodeLabel: nil}); err != nil {
+			return fmt.Errorf("unable to label the node %s: %w", node.Name, err)
+		}
+		fmt.Fprintf(c.Out, "Node %s eligible again as exit node\n", node.Name)
+		return nil
+	}
+	if err := patchMetadata(ctx, c, node, "labels", map[string]interface{}{haegressip.EgressDrainedNodeLabel: "true"}); err != nil {
+		return fmt.Errorf("unable to label the node %s: %w", node.Name, err)
+	}
+	fmt.Fprintf(c.Out, "Node %s labelled %s=true\n", node.Name, haegressip.EgressDrainedNodeLabel)
+
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return fmt.Errorf("unable to list the HAEgressGatewayPolicies: %w", err)
+	}
+	hostname := node.Labels[haegressip.NodeNameAnnotation]
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tPROVIDER\tACTION")
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if hostname == "" || policy.Status.ExitNode != hostname {
+			continue
+		}
+		name := c.providerOf(policy)
+		var action string
+		switch {
+		case name == provider.StaticName:
+			action = "set by the user, move it with failover"
+		case provider.IsCloud(name):
+			action = "moved by the operator"
+			// The preferred node would be kept only while eligible, drop it anyway
+			if policy.Annotations[haegressip.PreferredExitNodeAnnotation] == hostname {
+				if err := annotate(ctx, c, policy, map[string]interface{}{haegressip.PreferredExitNodeAnnotation: nil}); err != nil {
+					return fmt.Errorf("unable to annotate the HAEgressGatewayPolicy %s: %w", policy.Name, err)
+				}
+			}
+		default:
+			action = fmt.Sprintf("elected by the load balancer, exclude the %s label from the nodes of %s", haegressip.EgressDrainedNodeLabel, name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", policy.Name, name, action)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func init() {
+	commands["failover"] = command{
+		usage:       "failover <policy> --to <node>",
+		description: "Move the egress IP of a policy to another node",
+		run:         failover,
+	}
+}
+
+func failover(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("failover")
+	to := flags.String("to", "", "The hostname of the new exit node")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if *to == "" {
+		return fmt.Errorf("the new exit node must be set with --to")
+	}
+	if err := sanitize.NodeName(*to); err != nil {
+		return err
+	}
+	policy, err := c.getPolicy(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	if haegressiputil.IsPaused(policy) {
+		return fmt.Errorf("the HAEgressGatewayPolicy %s is paused, resume it first", policy.Name)
+	}
+
+	nodes, err := c.nodesByHostname(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the nodes: %w", err)
+	}
+	node, ok := nodes[*to]
+	if !ok {
+		return fmt.Errorf("no node with the %s=%s label", haegressip.NodeNameAnnotation, *to)
+	}
+	if !haegressiputil.IsNodeReady(node) {
+		return fmt.Errorf("the node %s is not Ready", *to)
+	}
+	if haegressiputil.IsNodeDrained(node) {
+		return fmt.Errorf("the node %s is drained", *to)
+	}
+
+	// The operator chooses the exit node only with the static and the cloud providers,
+	// the load balancers elect it on their own
+	var annotation string
+	switch name := c.providerOf(policy); {
+	case name == provider.StaticName:
+		annotation = haegressip.StaticExitNodeAnnotation
+	case provider.IsCloud(name):
+		annotation = haegressip.PreferredExitNodeAnnotation
+	default:
+		return fmt.Errorf("the exit node of the %s provider is elected by the load balancer and can't be chosen, "+
+			"drain the current exit node with drain-node instead", name)
+	}
+	if err := annotate(ctx, c, policy, map[string]interface{}{annotation: *to}); err != nil {
+		return fmt.Errorf("unable to annotate the HAEgressGatewayPolicy %s: %w", policy.Name, err)
+	}
+	fmt.Fprintf(c.Out, "HAEgressGatewayPolicy %s moving from %s to %s\n", policy.Name, orNone(policy.Status.ExitNode), *to)
+	return nil
+}
+
+// annotate sets the annotations of the object with a merge patch, the nil values remove
+// the annotation
+func annotate(ctx context.Context, c client.Client, object client.Object, annotations map[string]interface{}) error {
+	return patchMetadata(ctx, c, object, "annotations", annotations)
+}
+
+// patchMetadata patches the annotations or the labels of the object
+func patchMetadata(ctx context.Context, c client.Client, object client.Object, field string, values map[string]interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{field: values},
+	})
+	if err != nil {
+		return err
+	}
+	return c.Patch(ctx, object, client.RawPatch(types.MergePatchType, data))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/importer"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	commands["import"] = command{
+		usage:       "import [--apply [--adopt]]",
+		description: "Convert the hand-written CiliumEgressGatewayPolicies to HAEgressGatewayPolicies",
+		run:         importPolicies,
+	}
+}
+
+func importPolicies(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("import")
+	asProvider := flags.String("as-provider", "", "The provider of the converted policies, the --provider one when empty")
+	apply := flags.Bool("apply", false, "Create the converted policies instead of printing them")
+	adopt := flags.Bool("adopt", false, "With --apply, hand the originals over to the operator: in place when the names match, otherwise deleted once the generated policy exists")
+	timeout := flags.Duration("timeout", 2*time.Minute, "The time waited for the generated CiliumEgressGatewayPolicy before deleting an original")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if *adopt && !*apply {
+		return fmt.Errorf("--adopt requires --apply")
+	}
+	options := importer.Options{
+		EgressNamespace: c.EgressNamespace,
+		Provider:        c.DefaultProvider,
+		DefaultProvider: c.DefaultProvider,
+	}
+	if *asProvider != "" {
+		options.Provider = *asProvider
+	}
+
+	conversions, err := importer.List(ctx, c.Client, options)
+	if err != nil {
+		return fmt.Errorf("unable to list the CiliumEgressGatewayPolicies: %w", err)
+	}
+	converted, failed := 0, 0
+	for i := range conversions {
+		conversion := &conversions[i]
+		if conversion.Failed() {
+			failed++
+		} else {
+			converted++
+		}
+		if !*apply {
+			if err := printConversion(c, conversion); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, issue := range conversion.Issues {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", conversion.Original.Name, issue.Severity, issue.Message)
+		}
+		if conversion.Failed() {
+			continue
+		}
+		if err := applyConversion(ctx, c, conversion, *adopt, *timeout); err != nil {
+			failed++
+			converted--
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", conversion.Original.Name, importer.Error, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d CiliumEgressGatewayPolicies converted, %d not converted\n", converted, failed)
+	if failed > 0 {
+		return fmt.Errorf("some CiliumEgressGatewayPolicies were not converted")
+	}
+	return nil
+}
+
+// printConversion prints the converted policy, preceded by the issues as comments
+func printConversion(c *cli, conversion *importer.Conversion) error {
+	fmt.Fprintf(c.Out, "---\n# From CiliumEgressGatewayPolicy %s\n", conversion.Original.Name)
+	for _, issue := range conversion.Issues {
+		fmt.Fprintf(c.Out, "# %s: %s\n", issue.Severity, issue.Message)
+	}
+	if conversion.Failed() {
+		return nil
+	}
+	// The manifest has no status nor creationTimestamp, as written by hand
+	data, err := json.Marshal(conversion.Policy)
+	if err != nil {
+		return err
+	}
+	manifest := map[string]interface{}{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	delete(manifest, "status")
+	if metadata, ok := manifest["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	data, err = yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = c.Out.Write(data)
+	return err
+}
+
+// applyConversion creates the policy and, when requested, hands the original over to the
+// operator
+func applyConversion(ctx context.Context, c *cli, conversion *importer.Conversion, adopt bool, timeout time.Duration) error {
+	policy := conversion.Policy
+	if err := c.Create(ctx, policy); err != nil {
+		return fmt.Errorf("unable to create the HAEgressGatewayPolicy %s: %w", policy.Name, err)
+	}
+	fmt.Fprintf(c.Out, "HAEgressGatewayPolicy %s created from %s\n", policy.Name, conversion.Original.Name)
+	if !adopt {
+		return nil
+	}
+
+	if conversion.Adoptable {
+		if err := importer.Adopt(ctx, c.Client, c.Scheme(), conversion); err != nil {
+			return fmt.Errorf("unable to adopt the CiliumEgressGatewayPolicy: %w", err)
+		}
+		fmt.Fprintf(c.Out, "CiliumEgressGatewayPolicy %s adopted by %s\n", conversion.Original.Name, policy.Name)
+		return nil
+	}
+
+	// Both would select the same pods, the original is removed once the generated one exists
+	generated := fmt.Sprintf("%s-%s", c.serviceNamespace(policy), policy.Name)
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		err := c.Get(ctx, types.NamespacedName{Name: generated}, &ciliumv2.CiliumEgressGatewayPolicy{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("the CiliumEgressGatewayPolicy %s was not generated, keeping the original: %w", generated, err)
+	}
+	if err := c.Delete(ctx, conversion.Original); err != nil {
+		return fmt.Errorf("unable to delete the original: %w", err)
+	}
+	fmt.Fprintf(c.Out, "CiliumEgressGatewayPolicy %s replaced by %s\n", conversion.Original.Name, generated)
+	return nil
+}
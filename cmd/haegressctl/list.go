@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+)
+
+func init() {
+	commands["list"] = command{
+		usage:       "list [--unhealthy]",
+		description: "List the policies with their egress IP, exit node and health",
+		run:         list,
+	}
+}
+
+func list(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("list")
+	unhealthy := flags.Bool("unhealthy", false, "List only the policies that are not healthy")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return fmt.Errorf("unable to list the HAEgressGatewayPolicies: %w", err)
+	}
+	nodes, err := c.nodesByHostname(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the nodes: %w", err)
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tIP\tNODE\tHEALTH\tPROVIDER")
+	for i := range policies.Items {
+		state, err := c.state(ctx, &policies.Items[i], nodes)
+		if err != nil {
+			return err
+		}
+		if *unhealthy && state.Health == HealthHealthy {
+			continue
+		}
+		policy := state.Policy
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", policy.Name, orNone(policy.Status.IPAddress),
+			orNone(policy.Status.ExitNode), state.Health, state.Provider)
+	}
+	return w.Flush()
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
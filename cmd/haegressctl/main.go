@@ -0,0 +1,166 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// haegressctl inspects and operates the HAEgressGatewayPolicies of a cluster: it lists
+// their egress IP, exit node and health, moves them to another node, pauses their
+// reconciliation, drains the egress IPs of a node and verifies the prerequisites of the
+// operator.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	ciliumv2alpha1 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2alpha1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// command is a subcommand of haegressctl
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, cli *cli, args []string) error
+	// offline commands don't talk to the cluster, they run without a kubeconfig
+	offline bool
+}
+
+var commands = map[string]command{}
+
+// cli is the state shared by the subcommands
+type cli struct {
+	client.Client
+	// Config is the configuration of the client, for the APIs not served by it, e.g. the logs
+	Config *rest.Config
+	Out    io.Writer
+	// EgressNamespace is the namespace of the Services of the policies without the
+	// namespace annotation, as the --egress-default-namespace flag of the operator
+	EgressNamespace string
+	// DefaultProvider is the provider of the policies without the provider annotation, as
+	// the --provider flag of the operator
+	DefaultProvider string
+}
+
+func main() {
+	var egressNamespace string
+	var defaultProvider string
+
+	flag.StringVar(&egressNamespace, "egress-default-namespace", "egress-system", "The namespace of the Services of the policies without the namespace annotation, as configured in the operator")
+	flag.StringVar(&defaultProvider, "provider", provider.KubeVIPName, "The provider of the policies without the provider annotation, as configured in the operator")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	if cmd.offline {
+		run(ctx, cmd, &cli{Out: os.Stdout, EgressNamespace: egressNamespace, DefaultProvider: defaultProvider})
+		return
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2alpha1.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(haegressv2.AddToScheme(scheme))
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load the kubeconfig: %v\n", err)
+		os.Exit(1)
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create the client: %v\n", err)
+		os.Exit(1)
+	}
+
+	run(ctx, cmd, &cli{Client: c, Config: config, Out: os.Stdout, EgressNamespace: egressNamespace, DefaultProvider: defaultProvider})
+}
+
+// run runs the command with the arguments after its name, exiting on errors
+func run(ctx context.Context, cmd command, c *cli) {
+	if err := cmd.run(ctx, c, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: haegressctl [flags] <command> [command flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-40s %s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// commandFlags returns the flag set of the subcommand, printing its usage on errors
+func commandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: haegressctl %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses the flags of the subcommand and checks the number of its arguments
+func parseArgs(flags *flag.FlagSet, args []string, count int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	// The flags may follow the arguments, e.g. failover my-policy --to node-1
+	var positional []string
+	for flags.NArg() > 0 {
+		positional = append(positional, flags.Arg(0))
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return nil, err
+		}
+	}
+	if len(positional) != count {
+		flags.Usage()
+		return nil, fmt.Errorf("%s expects %d argument(s), got %d", flags.Name(), count, len(positional))
+	}
+	return positional, nil
+}
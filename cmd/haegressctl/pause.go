@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+)
+
+func init() {
+	commands["pause"] = command{
+		usage:       "pause <policy>",
+		description: "Stop the reconciliation of a policy, its egress IP and exit node are frozen",
+		run:         pause,
+	}
+	commands["resume"] = command{
+		usage:       "resume <policy>",
+		description: "Resume the reconciliation of a paused policy",
+		run:         resume,
+	}
+}
+
+func pause(ctx context.Context, c *cli, args []string) error {
+	return setPaused(ctx, c, "pause", args, "true")
+}
+
+func resume(ctx context.Context, c *cli, args []string) error {
+	return setPaused(ctx, c, "resume", args, nil)
+}
+
+func setPaused(ctx context.Context, c *cli, name string, args []string, value interface{}) error {
+	positional, err := parseArgs(commandFlags(name), args, 1)
+	if err != nil {
+		return err
+	}
+	policy, err := c.getPolicy(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := annotate(ctx, c, policy, map[string]interface{}{haegressip.PausedAnnotation: value}); err != nil {
+		return fmt.Errorf("unable to annotate the HAEgressGatewayPolicy %s: %w", policy.Name, err)
+	}
+	if value == nil {
+		fmt.Fprintf(c.Out, "HAEgressGatewayPolicy %s resumed\n", policy.Name)
+	} else {
+		fmt.Fprintf(c.Out, "HAEgressGatewayPolicy %s paused\n", policy.Name)
+	}
+	return nil
+}
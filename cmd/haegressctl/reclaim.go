@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/reclaim"
+)
+
+func init() {
+	commands["reclaimable"] = command{
+		usage:       "reclaimable",
+		description: "List the egress IPs held for the deleted policies before they are released",
+		run:         reclaimable,
+	}
+	commands["reclaim"] = command{
+		usage:       "reclaim <policy> [--forget]",
+		description: "Release the egress IP held for a deleted policy to its IPAM now",
+		run:         reclaimIP,
+	}
+}
+
+func reclaimable(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("reclaimable")
+	configMap := flags.String("configmap", reclaim.DefaultConfigMapName, "The --ipam-reclaim-configmap of the operator")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	store := &reclaim.Store{Client: c.Client, Reader: c.Client, Namespace: c.EgressNamespace, ConfigMapName: *configMap}
+	entries, err := store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read the ConfigMap %s/%s: %w", c.EgressNamespace, *configMap, err)
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tIP\tIPAM\tHELD\tRELEASE")
+	for _, entry := range entries {
+		release := "due"
+		if remaining := time.Until(entry.ReleaseAt.Time); remaining > 0 {
+			release = "in " + remaining.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Policy, entry.IP, entry.IPAM,
+			entry.HeldAt.UTC().Format(time.RFC3339), release)
+	}
+	return w.Flush()
+}
+
+func reclaimIP(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("reclaim")
+	configMap := flags.String("configmap", reclaim.DefaultConfigMapName, "The --ipam-reclaim-configmap of the operator")
+	forget := flags.Bool("forget", false, "Drop the held egress IP without releasing it to the IPAM, e.g. when it was already released there")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	store := &reclaim.Store{Client: c.Client, Reader: c.Client, Namespace: c.EgressNamespace, ConfigMapName: *configMap}
+	if *forget {
+		entry, ok, err := store.Get(ctx, positional[0])
+		if err != nil {
+			return fmt.Errorf("unable to read the ConfigMap %s/%s: %w", c.EgressNamespace, *configMap, err)
+		}
+		if !ok {
+			return fmt.Errorf("no egress IP is held for the policy %s", positional[0])
+		}
+		if err := store.Forget(ctx, entry.Policy); err != nil {
+			return fmt.Errorf("unable to update the ConfigMap %s/%s: %w", c.EgressNamespace, *configMap, err)
+		}
+		fmt.Fprintf(c.Out, "Egress IP %s of %s forgotten, it is not released to the %s IPAM\n", entry.IP, entry.Policy, entry.IPAM)
+		return nil
+	}
+	entry, err := store.ReleaseNow(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Egress IP %s of %s released to the %s IPAM at the next scan of the operator\n", entry.IP, entry.Policy, entry.IPAM)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Health of a policy
+const (
+	HealthHealthy      = "Healthy"
+	HealthPaused       = "Paused"
+	HealthPending      = "Pending"
+	HealthNodeNotReady = "NodeNotReady"
+	HealthNodeDrained  = "NodeDrained"
+	HealthOutOfSync    = "OutOfSync"
+)
+
+// policyState is a policy with the objects generated by the operator and its health
+type policyState struct {
+	Policy           *haegressv2.HAEgressGatewayPolicy
+	Provider         string
+	ServiceNamespace string
+	// Service and CiliumEgressGatewayPolicy are nil when they don't exist
+	Service                   *corev1.Service
+	CiliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy
+	// Node is the exit node, nil when the status has none or it doesn't exist
+	Node   *corev1.Node
+	Health string
+	Reason string
+}
+
+// providerOf returns the provider of the policy
+func (c *cli) providerOf(policy *haegressv2.HAEgressGatewayPolicy) string {
+	if name := policy.Annotation(haegressip.ProviderAnnotation); name != "" {
+		return name
+	}
+	return c.DefaultProvider
+}
+
+// serviceNamespace returns the namespace of the Service of the policy
+func (c *cli) serviceNamespace(policy *haegressv2.HAEgressGatewayPolicy) string {
+	return haegressiputil.ServiceNamespace(policy, c.EgressNamespace)
+}
+
+// nodesByHostname returns the nodes by their hostname label, the exit nodes of the status
+func (c *cli) nodesByHostname(ctx context.Context) (map[string]*corev1.Node, error) {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+	byHostname := make(map[string]*corev1.Node, len(nodes.Items))
+	for i := range nodes.Items {
+		if hostname := nodes.Items[i].Labels[haegressip.NodeNameAnnotation]; hostname != "" {
+			byHostname[hostname] = &nodes.Items[i]
+		}
+	}
+	return byHostname, nil
+}
+
+// state reads the objects of the policy and evaluates its health
+func (c *cli) state(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, nodes map[string]*corev1.Node) (*policyState, error) {
+	state := &policyState{
+		Policy:           policy,
+		Provider:         c.providerOf(policy),
+		ServiceNamespace: c.serviceNamespace(policy),
+		Node:             nodes[policy.Status.ExitNode],
+	}
+
+	service := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: state.ServiceNamespace, Name: policy.Name}, service)
+	if err == nil {
+		state.Service = service
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{}
+	err = c.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", state.ServiceNamespace, policy.Name)}, cegp)
+	if err == nil {
+		state.CiliumEgressGatewayPolicy = cegp
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	state.Health, state.Reason = health(state)
+	return state, nil
+}
+
+// health returns the health of the policy and the reason when it is not healthy
+func health(state *policyState) (string, string) {
+	policy := state.Policy
+	if haegressiputil.IsPaused(policy) {
+		return HealthPaused, "the reconciliation is paused with the " + haegressip.PausedAnnotation + " annotation"
+	}
+	if state.Service == nil || state.CiliumEgressGatewayPolicy == nil {
+		return HealthPending, "the Service or the CiliumEgressGatewayPolicy is not created yet"
+	}
+	if policy.Status.IPAddress == "" || policy.Status.ExitNode == "" {
+		return HealthPending, "the egress IP is not assigned to a node yet"
+	}
+	if state.Node == nil || !haegressiputil.IsNodeReady(state.Node) {
+		return HealthNodeNotReady, fmt.Sprintf("the exit node %s is not Ready", policy.Status.ExitNode)
+	}
+	if haegressiputil.IsNodeDrained(state.Node) {
+		return HealthNodeDrained, fmt.Sprintf("the exit node %s is drained", policy.Status.ExitNode)
+	}
+
+	gateway := state.CiliumEgressGatewayPolicy.Spec.EgressGateway
+	if gateway == nil || gateway.NodeSelector == nil {
+		return HealthOutOfSync, "the CiliumEgressGatewayPolicy has no nodeSelector"
+	}
+	selected := gateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation] == policy.Status.ExitNode ||
+		slices.Contains(haegressiputil.GatewayGroupFromSelector(gateway.NodeSelector), policy.Status.ExitNode)
+	if !selected {
+		return HealthOutOfSync, fmt.Sprintf("the CiliumEgressGatewayPolicy does not select the exit node %s", policy.Status.ExitNode)
+	}
+	if gateway.Interface == "" && gateway.EgressIP != policy.Status.IPAddress {
+		return HealthOutOfSync, fmt.Sprintf("the CiliumEgressGatewayPolicy uses the egress IP %q instead of %s", gateway.EgressIP, policy.Status.IPAddress)
+	}
+	return HealthHealthy, ""
+}
+
+// getPolicy returns the policy with the given name
+func (c *cli) getPolicy(ctx context.Context, name string) (*haegressv2.HAEgressGatewayPolicy, error) {
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, policy); err != nil {
+		return nil, fmt.Errorf("unable to get the HAEgressGatewayPolicy %s: %w", name, err)
+	}
+	return policy, nil
+}
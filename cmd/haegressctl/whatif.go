@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/whatif"
+)
+
+func init() {
+	commands["what-if"] = command{
+		usage:       "what-if <node> [--json]",
+		description: "Predict which egress IPs move, and where, if the node is lost, without changing anything",
+		run:         whatIf,
+	}
+}
+
+func whatIf(ctx context.Context, c *cli, args []string) error {
+	flags := commandFlags("what-if")
+	patchQPS := flags.Float64("patch-qps", 50, "The --patch-qps of the operator")
+	gracePeriod := flags.Duration("node-monitor-grace-period", whatif.DefaultNodeMonitorGracePeriod, "The --node-monitor-grace-period of the kube-controller-manager")
+	jsonOutput := flags.Bool("json", false, "Print the prediction in JSON")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	result, err := whatif.Simulate(ctx, c.Client, positional[0], whatif.Options{
+		DefaultProvider:        c.DefaultProvider,
+		DefaultNamespace:       c.EgressNamespace,
+		PatchQPS:               *patchQPS,
+		NodeMonitorGracePeriod: *gracePeriod,
+		Detection:              whatif.DefaultDetection(),
+	})
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(c.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	if len(result.Moves) == 0 {
+		fmt.Fprintf(c.Out, "No policy uses the node %s\n", result.Node)
+		return nil
+	}
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tIP\tPROVIDER\tOUTCOME\tTO\tCANDIDATES\tREASON")
+	for _, move := range result.Moves {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", move.Policy, orNone(move.EgressIP), move.Provider,
+			move.Outcome, orNone(move.To), move.Candidates, move.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	nodes := make([]string, 0, len(result.Load))
+	for node := range result.Load {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	fmt.Fprintf(c.Out, "\nEgress IPs per node after the failover:\n")
+	w = tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	for _, node := range nodes {
+		fmt.Fprintf(w, "  %s\t%d\n", node, result.Load[node])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "\nExpected convergence: %.0fs\n", result.ConvergenceSeconds)
+	return nil
+}
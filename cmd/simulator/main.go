@@ -0,0 +1,71 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The simulator runs the controllers against an envtest API server with thousands of
+// policies and reports their throughput, convergence time and API calls.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/simulator"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func main() {
+	var options simulator.Options
+	var qps float64
+	var jsonOutput bool
+
+	flag.IntVar(&options.Policies, "policies", 1000, "The number of HAEgressGatewayPolicies created")
+	flag.IntVar(&options.Nodes, "nodes", 10, "The number of simulated nodes announcing the egress IPs")
+	flag.IntVar(&options.Workers, "workers", 1, "The number of workers of every controller")
+	flag.Float64Var(&qps, "k8s-client-qps", 20, "The maximum QPS of the operator to the API server")
+	flag.IntVar(&options.Burst, "k8s-client-burst", 100, "The maximum burst of the operator to the API server")
+	flag.IntVar(&options.PatchWorkers, "patch-workers", 10, "The number of workers applying the patches in bulk, zero to apply them in the reconciliations")
+	flag.BoolVar(&options.Failover, "failover", true, "Move every egress IP to another node once converged and measure the convergence again")
+	flag.DurationVar(&options.Timeout, "timeout", 30*time.Minute, "The maximum duration of the simulation")
+	flag.StringVar(&options.CRDDirectory, "crd-directory", "config/crd/bases", "The directory with the CRD of the HAEgressGatewayPolicies")
+	flag.BoolVar(&jsonOutput, "json", false, "Print the report in JSON")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	log := ctrl.Log.WithName("simulator")
+	options.Log = log
+	options.QPS = float32(qps)
+
+	report, err := simulator.Run(ctrl.SetupSignalHandler(), options)
+	if err != nil {
+		log.Error(err, "Simulation failed")
+		os.Exit(1)
+	}
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Error(err, "unable to print the report")
+			os.Exit(1)
+		}
+		return
+	}
+	report.Print(os.Stdout)
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// ConditionCiliumFeaturesMissing is True when Cilium lacks a feature required by the
+	// policy, that is not synced until it is enabled
+	ConditionCiliumFeaturesMissing = "CiliumFeaturesMissing"
+	// ReasonFeaturesDisabled is the reason of the CiliumFeaturesMissing condition True
+	ReasonFeaturesDisabled = "FeaturesDisabled"
+	// ReasonFeaturesEnabled is the reason of the CiliumFeaturesMissing condition False
+	ReasonFeaturesEnabled = "FeaturesEnabled"
+
+	// ciliumFeaturesRequeueAfter is the delay of the next check of a gated policy
+	ciliumFeaturesRequeueAfter = time.Minute
+)
+
+// checkCiliumFeatures returns false when Cilium lacks a feature required by the policy,
+// with its provider, and records it in the CiliumFeaturesMissing condition
+func (r *HAEgressGatewayPolicyReconciler) checkCiliumFeatures(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) (bool, error) {
+	if r.Cilium == nil {
+		return true, nil
+	}
+	providerName := provider.KubeVIPName
+	if r.SyncOptions.Providers != nil {
+		vipProvider, err := r.SyncOptions.Providers.ForPolicy(haEgressGatewayPolicy)
+		if err != nil {
+			// The invalid provider is reported with the Service
+			return true, nil
+		}
+		providerName = vipProvider.Name()
+	}
+	missing := r.Cilium.Missing(providerName)
+
+	condition := metav1.Condition{
+		Type:               ConditionCiliumFeaturesMissing,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonFeaturesEnabled,
+		Message:            "Cilium has the features required by the policy",
+		ObservedGeneration: haEgressGatewayPolicy.Generation,
+	}
+	if len(missing) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonFeaturesDisabled
+		condition.Message = fmt.Sprintf("Cilium features required with the %s provider are disabled: %s",
+			providerName, strings.Join(missing, ", "))
+	}
+
+	changed, err := r.setCondition(ctx, haEgressGatewayPolicy, condition)
+	if err != nil {
+		return false, err
+	}
+	log := ctrl.LoggerFrom(ctx)
+	if changed && len(missing) > 0 {
+		log.Info("Cilium features required by HAEgressGatewayPolicy are disabled, skipping it", "missing", missing)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventCiliumFeatureMissingReason, condition.Message)
+	} else if changed {
+		log.Info("Cilium features required by HAEgressGatewayPolicy are enabled again")
+	}
+	return len(missing) == 0, nil
+}
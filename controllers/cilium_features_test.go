@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/preflight"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckCiliumFeatures(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// The egress gateway is enabled, the L2 announcements required by the Cilium LB IPAM
+	// provider are not
+	ciliumConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cilium-config", Namespace: "kube-system"},
+		Data:       map[string]string{"enable-ipv4-egress-gateway": "true", "kube-proxy-replacement": "true"},
+	}
+	kubeVIPPolicy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-web"}}
+	lbIPAMPolicy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{
+		Name:        "egress-db",
+		Annotations: map[string]string{haegressip.ProviderAnnotation: provider.CiliumLBIPAMName},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ciliumConfig, kubeVIPPolicy, lbIPAMPolicy).
+		WithStatusSubresource(&haegressv2.HAEgressGatewayPolicy{}).Build()
+
+	checker := &preflight.Checker{Reader: c, Log: logr.Discard(), CiliumNamespace: "kube-system"}
+	if err := checker.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	providers, err := provider.NewRegistry(provider.KubeVIPName, &provider.KubeVIP{}, &provider.CiliumLBIPAM{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &HAEgressGatewayPolicyReconciler{
+		Client:      c,
+		Recorder:    recorder,
+		Cilium:      checker,
+		SyncOptions: haegressiputil.SyncOptions{Providers: providers},
+	}
+
+	check := func(name string) (bool, *haegressv2.HAEgressGatewayPolicy) {
+		t.Helper()
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, policy); err != nil {
+			t.Fatal(err)
+		}
+		ok, err := r.checkCiliumFeatures(ctx, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, policy); err != nil {
+			t.Fatal(err)
+		}
+		return ok, policy
+	}
+
+	if ok, policy := check("egress-web"); !ok || len(policy.Status.Conditions) != 0 {
+		t.Errorf("the kube-vip policy was gated (%t) with the conditions %v", !ok, policy.Status.Conditions)
+	}
+	ok, policy := check("egress-db")
+	condition := meta.FindStatusCondition(policy.Status.Conditions, ConditionCiliumFeaturesMissing)
+	if ok || condition == nil || condition.Status != metav1.ConditionTrue || !strings.Contains(condition.Message, "enable-l2-announcements") {
+		t.Errorf("the Cilium LB IPAM policy was not gated (%t) with the condition %+v", !ok, condition)
+	}
+	// The event is recorded once
+	if ok, _ := check("egress-db"); ok {
+		t.Error("the Cilium LB IPAM policy was not gated again")
+	}
+	if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, haegressip.EventCiliumFeatureMissingReason) {
+		t.Errorf("%d events were recorded, expected one", len(recorder.Events))
+	}
+
+	// The unreadable configuration keeps the features detected before
+	if err := c.Delete(ctx, ciliumConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Refresh(ctx); err == nil {
+		t.Error("the missing cilium-config was not reported")
+	}
+	if ok, _ := check("egress-db"); ok {
+		t.Error("the Cilium LB IPAM policy was not gated after the read failure")
+	}
+
+	ciliumConfig.ResourceVersion = ""
+	ciliumConfig.Data["enable-l2-announcements"] = "true"
+	if err := c.Create(ctx, ciliumConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ok, policy = check("egress-db")
+	condition = meta.FindStatusCondition(policy.Status.Conditions, ConditionCiliumFeaturesMissing)
+	if !ok || condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("the Cilium LB IPAM policy was gated (%t) with the condition %+v after the features were enabled", !ok, condition)
+	}
+}
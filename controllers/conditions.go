@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"context"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setCondition records the condition in the status of the policy and returns true when
+// its status changed. A False condition is recorded only when the policy already has it,
+// so the policies never affected do not carry it.
+func (r *HAEgressGatewayPolicyReconciler) setCondition(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, condition metav1.Condition) (bool, error) {
+	current := meta.FindStatusCondition(haEgressGatewayPolicy.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		return false, nil
+	}
+	changed := current == nil || current.Status != condition.Status
+	patch := client.MergeFrom(haEgressGatewayPolicy.DeepCopy())
+	if !meta.SetStatusCondition(&haEgressGatewayPolicy.Status.Conditions, condition) {
+		return false, nil
+	}
+	return changed, r.Status().Patch(ctx, haEgressGatewayPolicy, patch)
+}
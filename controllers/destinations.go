@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// addDestinationFQDNs adds the addresses of the destinationFQDNs of the policy to the
+// destination CIDRs of the CiliumEgressGatewayPolicy. A name not resolved yet is reported
+// and the other destinations are applied.
+func (r *HAEgressGatewayPolicyReconciler) addDestinationFQDNs(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, ciliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy) {
+	if len(haEgressGatewayPolicy.Spec.DestinationFQDNs) == 0 {
+		if r.FQDNs != nil {
+			r.FQDNs.Forget(haEgressGatewayPolicy.Name)
+		}
+		return
+	}
+	if r.FQDNs == nil {
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventFQDNUnresolvedReason,
+			"Destination FQDNs not applied: the DestinationFQDNs feature is disabled")
+		return
+	}
+	cidrs, err := r.FQDNs.CIDRs(ctx, haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Spec.DestinationFQDNs)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Info("Destination FQDNs of HAEgressGatewayPolicy not resolved", "error", err.Error())
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventFQDNUnresolvedReason,
+			fmt.Sprintf("Destinations not applied: %s", err))
+	}
+	ciliumEgressGatewayPolicy.Spec.DestinationCIDRs = mergeCIDRs(ciliumEgressGatewayPolicy.Spec.DestinationCIDRs, cidrs)
+}
+
+// addDestinationProviders adds the CIDRs of the destinationProviders of the policy to the
+// destination CIDRs of the CiliumEgressGatewayPolicy. A range not fetched yet is reported
+// and the other destinations are applied.
+func (r *HAEgressGatewayPolicyReconciler) addDestinationProviders(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, ciliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy) {
+	if len(haEgressGatewayPolicy.Spec.DestinationProviders) == 0 {
+		if r.Feeds != nil {
+			r.Feeds.Forget(haEgressGatewayPolicy.Name)
+		}
+		return
+	}
+	if r.Feeds == nil {
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventProviderRangesUnresolvedReason,
+			"Destination providers not applied: no feed configured")
+		return
+	}
+	cidrs, err := r.Feeds.CIDRs(ctx, haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Spec.DestinationProviders)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Info("Destination providers of HAEgressGatewayPolicy not expanded", "error", err.Error())
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventProviderRangesUnresolvedReason,
+			fmt.Sprintf("Destinations not applied: %s", err))
+	}
+	ciliumEgressGatewayPolicy.Spec.DestinationCIDRs = mergeCIDRs(ciliumEgressGatewayPolicy.Spec.DestinationCIDRs, cidrs)
+}
+
+// excludeClusterCIDRs adds the cluster-internal CIDRs overlapping the destinations of the
+// CiliumEgressGatewayPolicy to its excluded CIDRs, so the east-west traffic never leaves
+// through the exit node
+func (r *HAEgressGatewayPolicyReconciler) excludeClusterCIDRs(ctx context.Context, ciliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy) {
+	if r.ClusterCIDRs == nil {
+		return
+	}
+	cidrs := r.ClusterCIDRs.Excluded(ctx, ciliumEgressGatewayPolicy.Spec.DestinationCIDRs)
+	ciliumEgressGatewayPolicy.Spec.ExcludedCIDRs = mergeCIDRs(ciliumEgressGatewayPolicy.Spec.ExcludedCIDRs, cidrs)
+}
+
+// mergeCIDRs returns the CIDRs followed by the added ones not already in the list
+func mergeCIDRs(cidrs []ciliumv2.IPv4CIDR, added []string) []ciliumv2.IPv4CIDR {
+	seen := make(map[ciliumv2.IPv4CIDR]bool, len(cidrs))
+	for _, cidr := range cidrs {
+		seen[cidr] = true
+	}
+	for _, cidr := range added {
+		if !seen[ciliumv2.IPv4CIDR(cidr)] {
+			seen[ciliumv2.IPv4CIDR(cidr)] = true
+			cidrs = append(cidrs, ciliumv2.IPv4CIDR(cidr))
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// When a policy is deleted, the garbage collector deletes its Service and its
+// CiliumEgressGatewayPolicies in no given order: the egress IP can disappear while the pods
+// are still selected, and their connections hang until the agents converge. With a drain
+// grace period the policy keeps the drain finalizer: its CiliumEgressGatewayPolicies first
+// stop selecting the pods, all at the same time, and the policy is released to the garbage
+// collector only once the grace period has passed.
+
+// addDrainFinalizer adds the drain finalizer to the policies whose children are managed
+// by the operator
+func (r *HAEgressGatewayPolicyReconciler) addDrainFinalizer(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	if r.DrainGrace <= 0 || haegressiputil.SkipsChildren(haEgressGatewayPolicy) {
+		return nil
+	}
+	if !controllerutil.AddFinalizer(haEgressGatewayPolicy, haegressip.DrainFinalizer) {
+		return nil
+	}
+	return r.Update(ctx, haEgressGatewayPolicy)
+}
+
+// drain stops the CiliumEgressGatewayPolicies of the deleted policy from selecting the pods
+// and removes the drain finalizer once the grace period has passed since they were drained.
+// The result requeues the policy until then.
+func (r *HAEgressGatewayPolicyReconciler) drain(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(haEgressGatewayPolicy, haegressip.DrainFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	logger := ctrl.LoggerFrom(ctx).WithValues("HAEgressGatewayPolicy", haEgressGatewayPolicy.Name)
+
+	// Without a grace period, e.g. when it was disabled after the finalizer was added, the
+	// policy is deleted at once
+	if r.DrainGrace > 0 && !haegressiputil.SkipsChildren(haEgressGatewayPolicy) {
+		policies := &ciliumv2.CiliumEgressGatewayPolicyList{}
+		if err := r.List(ctx, policies); err != nil {
+			return ctrl.Result{}, err
+		}
+		var since time.Time
+		for i := range policies.Items {
+			cegp := &policies.Items[i]
+			if !metav1.IsControlledBy(cegp, haEgressGatewayPolicy) {
+				continue
+			}
+			drainedAt, err := time.Parse(time.RFC3339, cegp.Annotations[haegressip.DrainingSinceAnnotation])
+			if err != nil {
+				if drainedAt, err = r.drainCiliumEgressGatewayPolicy(ctx, cegp); err != nil {
+					return ctrl.Result{}, err
+				}
+				logger.Info("Drained the CiliumEgressGatewayPolicy of the deleted HAEgressGatewayPolicy",
+					"CiliumEgressGatewayPolicy", cegp.Name, "grace", r.DrainGrace.String())
+				r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventDrainingReason,
+					fmt.Sprintf("CiliumEgressGatewayPolicy %q does not select the pods anymore, deleted after %s", cegp.Name, r.DrainGrace))
+			}
+			if drainedAt.After(since) {
+				since = drainedAt
+			}
+		}
+		if remaining := r.DrainGrace - time.Since(since); !since.IsZero() && remaining > 0 {
+			logger.V(1).Info("Waiting for the drain grace period before deleting the HAEgressGatewayPolicy", "remaining", remaining.String())
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	controllerutil.RemoveFinalizer(haEgressGatewayPolicy, haegressip.DrainFinalizer)
+	return ctrl.Result{}, r.Update(ctx, haEgressGatewayPolicy)
+}
+
+// drainCiliumEgressGatewayPolicy replaces the selectors of the CiliumEgressGatewayPolicy
+// with the placeholder matching no pod, keeping its egress gateway, and records when
+func (r *HAEgressGatewayPolicyReconciler) drainCiliumEgressGatewayPolicy(ctx context.Context, cegp *ciliumv2.CiliumEgressGatewayPolicy) (time.Time, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	patch := client.MergeFrom(cegp.DeepCopy())
+	cegp.Spec.Selectors = fanOutPlaceholderSelectors()
+	if cegp.Annotations == nil {
+		cegp.Annotations = map[string]string{}
+	}
+	cegp.Annotations[haegressip.DrainingSinceAnnotation] = now.Format(time.RFC3339)
+	return now, r.Patch(ctx, cegp, patch)
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ConditionNoEligibleNode is True when no node can be the exit node of the policy
+	ConditionNoEligibleNode = "NoEligibleNode"
+	// ReasonNoCandidateNode is the reason of the NoEligibleNode condition True
+	ReasonNoCandidateNode = "NoCandidateNode"
+	// ReasonCandidateNodesFound is the reason of the NoEligibleNode condition False
+	ReasonCandidateNodesFound = "CandidateNodesFound"
+)
+
+const (
+	// eligibilityMinBackoff and eligibilityMaxBackoff bound the checks of a policy without
+	// eligible nodes, the changes of the nodes are checked at once anyway
+	eligibilityMinBackoff = 5 * time.Second
+	eligibilityMaxBackoff = 5 * time.Minute
+)
+
+// EligibilityController counts the nodes eligible as exit node of every policy: the nodes
+// matching its nodeSelector, Ready, not drained, not excluded by a failover drill and
+// below their egress capacity. A policy left without any gets the NoEligibleNode
+// condition and a warning, instead of never converging silently, and is checked again
+// with an exponential backoff.
+type EligibilityController struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+
+	backoff workqueue.RateLimiter
+}
+
+func (r *EligibilityController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.backoff.Forget(req.Name)
+			haegressmetrics.ForgetEligibleNodes(req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !r.Sharder.Owns(policy.Name, policy.Labels) || !policy.DeletionTimestamp.IsZero() || haegressiputil.IsPaused(policy) {
+		r.backoff.Forget(req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	eligible, excluded, err := r.eligibleNodes(ctx, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	haegressmetrics.EligibleNodes(policy.Name, eligible)
+
+	condition := metav1.Condition{
+		Type:               ConditionNoEligibleNode,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonCandidateNodesFound,
+		Message:            fmt.Sprintf("%d nodes are eligible as exit node", eligible),
+		ObservedGeneration: policy.Generation,
+	}
+	result := ctrl.Result{}
+	if eligible == 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonNoCandidateNode
+		condition.Message = "No node is eligible as exit node"
+		if len(excluded) > 0 {
+			condition.Message += ": " + strings.Join(excluded, ", ")
+		} else {
+			condition.Message += ": no node matches the nodeSelector"
+		}
+		result.RequeueAfter = r.backoff.When(policy.Name)
+	} else {
+		r.backoff.Forget(policy.Name)
+	}
+
+	wasNoEligible := meta.IsStatusConditionTrue(policy.Status.Conditions, ConditionNoEligibleNode)
+	patch := client.MergeFrom(policy.DeepCopy())
+	if !meta.SetStatusCondition(&policy.Status.Conditions, condition) {
+		return result, nil
+	}
+	if eligible == 0 && !wasNoEligible {
+		r.Log.Info("No node is eligible as exit node of the policy", "HAEgressGatewayPolicy", policy.Name, "excluded", excluded)
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventNoEligibleNodeReason, condition.Message)
+	} else if eligible > 0 && wasNoEligible {
+		r.Log.Info("Nodes eligible as exit node of the policy again", "HAEgressGatewayPolicy", policy.Name, "nodes", eligible)
+	}
+	return result, r.Status().Patch(ctx, policy, patch)
+}
+
+// eligibleNodes returns the number of nodes eligible as exit node of the policy, and why
+// the other nodes matching its nodeSelector are not
+func (r *EligibilityController) eligibleNodes(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (int, []string, error) {
+	selector, err := haegressiputil.PolicyNodeSelector(policy)
+	if err != nil {
+		return 0, nil, err
+	}
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, nil, err
+	}
+	// The exit nodes of the other policies consume the capacity of the nodes
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		return 0, nil, err
+	}
+	hosted := map[string]int64{}
+	for _, other := range policies.Items {
+		if other.Name != policy.Name && other.Status.ExitNode != "" {
+			hosted[other.Status.ExitNode]++
+		}
+	}
+
+	avoided := policy.Annotations[haegressip.DrillAvoidNodeAnnotation]
+	eligible := 0
+	counts := map[string]int{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		hostname := node.Labels[haegressip.NodeNameAnnotation]
+		capacity, err := strconv.ParseInt(node.Annotations[haegressip.EgressCapacityAnnotation], 10, 64)
+		switch {
+		case sanitize.NodeName(hostname) != nil:
+			counts["without a valid hostname"]++
+		case !haegressiputil.IsNodeReady(node):
+			counts["not Ready"]++
+		case haegressiputil.IsNodeDrained(node):
+			counts["drained"]++
+		case hostname == avoided:
+			counts["avoided by a drill"]++
+		case err == nil && capacity > 0 && hosted[hostname] >= capacity:
+			counts["at egress capacity"]++
+		default:
+			eligible++
+		}
+	}
+	excluded := []string{}
+	for _, reason := range []string{"not Ready", "drained", "at egress capacity", "avoided by a drill", "without a valid hostname"} {
+		if counts[reason] > 0 {
+			excluded = append(excluded, fmt.Sprintf("%d %s", counts[reason], reason))
+		}
+	}
+	return eligible, excluded, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *EligibilityController) SetupWithManager(mgr ctrl.Manager) error {
+	r.backoff = workqueue.NewItemExponentialFailureRateLimiter(eligibilityMinBackoff, eligibilityMaxBackoff)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("eligibility").
+		For(&haegressv2.HAEgressGatewayPolicy{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.policiesForNode),
+			builder.WithPredicates(nodeEligibilityChanged),
+		).
+		WithOptions(controllerOptions(r.Sharder, 1)).
+		Complete(r)
+}
+
+// policiesForNode returns every policy, the node can be eligible for any of them
+func (r *EligibilityController) policiesForNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		r.Log.Error(err, "unable to list the policies of the Node", "Node", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(policies.Items))
+	for i := range policies.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+	}
+	return requests
+}
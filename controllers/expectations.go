@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// expectationTimeout bounds the wait for the cache, an object not observed in time is
+// looked up again
+const expectationTimeout = 30 * time.Second
+
+// expectations remembers the objects created by the reconciler and not yet observed in
+// the informer cache, so a reconciliation running before the cache is updated does not
+// try to create them again or fall back to a live read
+type expectations struct {
+	lock    sync.Mutex
+	created map[string]time.Time
+}
+
+// expectCreated records the creation of the object with the given key
+func (e *expectations) expectCreated(key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.created == nil {
+		e.created = make(map[string]time.Time)
+	}
+	e.created[key] = time.Now()
+}
+
+// pending returns true when the object was created and is still not in the cache
+func (e *expectations) pending(key string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	created, ok := e.created[key]
+	if ok && time.Since(created) > expectationTimeout {
+		delete(e.created, key)
+		return false
+	}
+	return ok
+}
+
+// observed records that the object is in the cache
+func (e *expectations) observed(key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.created, key)
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// A policy with a namespaceSelector is fanned out to the matched namespaces. Its
+// CiliumEgressGatewayPolicy keeps the egress gateway, synced with the Service as usual, but
+// selects no pod; one CiliumEgressGatewayPolicy per namespace, named after it and labeled
+// with the fan-out namespace, copies its egress gateway and destinations with the
+// selectors of the policy restricted to the pods of the namespace.
+
+// NamespaceFanOutController keeps the CiliumEgressGatewayPolicies of the namespaces matched
+// by the namespaceSelector of the policies
+type NamespaceFanOutController struct {
+	client.Client
+	Log             logr.Logger
+	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
+	EgressNamespace string
+	// SourceNamespaces are the namespaces whose pods can be selected, the others are never
+	// fanned out
+	SourceNamespaces haegressiputil.SourceNamespaces
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+}
+
+func (r *NamespaceFanOutController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// The CiliumEgressGatewayPolicies of a deleted policy are deleted with it
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !r.Sharder.Owns(policy.Name, policy.Labels) || !policy.DeletionTimestamp.IsZero() ||
+		haegressiputil.IsPaused(policy) || haegressiputil.SkipsChildren(policy) {
+		return ctrl.Result{}, nil
+	}
+	logger := r.Log.WithValues("HAEgressGatewayPolicy", policy.Name)
+
+	existing, err := r.fanOutPolicies(ctx, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	desired := map[string]*ciliumv2.CiliumEgressGatewayPolicy{}
+	if policy.Spec.NamespaceSelector != nil {
+		primary := &ciliumv2.CiliumEgressGatewayPolicy{}
+		err := r.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", r.serviceNamespace(policy), policy.Name)}, primary)
+		if apierrors.IsNotFound(err) {
+			// Fanned out once the CiliumEgressGatewayPolicy of the policy is created
+			return ctrl.Result{}, nil
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !metav1.IsControlledBy(primary, policy) {
+			return ctrl.Result{}, nil
+		}
+		namespaces, err := r.matchedNamespaces(ctx, policy)
+		if err != nil {
+			logger.Info("Invalid namespaceSelector of HAEgressGatewayPolicy, skipping the fan-out", "error", err.Error())
+			r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+			return ctrl.Result{}, nil
+		}
+		for _, namespace := range namespaces {
+			cegp, err := r.desiredFanOutPolicy(policy, primary, namespace)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			desired[cegp.Name] = cegp
+		}
+	}
+
+	// The CiliumEgressGatewayPolicies of the namespaces not matched anymore are deleted
+	// first, the pods must never match two policies
+	for i := range existing {
+		if _, ok := desired[existing[i].Name]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, &existing[i]); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		namespace := existing[i].Labels[haegressip.FanOutNamespaceLabel]
+		logger.Info("Deleted the CiliumEgressGatewayPolicy of a namespace not matched anymore",
+			"CiliumEgressGatewayPolicy", existing[i].Name, "namespace", namespace)
+		r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventFanOutRemovedReason,
+			fmt.Sprintf("CiliumEgressGatewayPolicy %q of the namespace %s deleted", existing[i].Name, namespace))
+	}
+
+	for _, name := range haegressiputil.SortedKeys(desired) {
+		if err := r.apply(ctx, logger, policy, desired[name]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// apply creates the CiliumEgressGatewayPolicy of a namespace, or corrects the existing one
+func (r *NamespaceFanOutController) apply(ctx context.Context, logger logr.Logger, policy *haegressv2.HAEgressGatewayPolicy, desired *ciliumv2.CiliumEgressGatewayPolicy) error {
+	existing := &ciliumv2.CiliumEgressGatewayPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name}, existing)
+	if apierrors.IsNotFound(err) {
+		logger.Info("Creating a new CiliumEgressGatewayPolicy for a namespace of HAEgressGatewayPolicy",
+			"CiliumEgressGatewayPolicy", desired.Name, "namespace", desired.Labels[haegressip.FanOutNamespaceLabel])
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventCreatedReason,
+			fmt.Sprintf("CiliumEgressGatewayPolicy %q created", desired.Name))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(existing, policy) {
+		logger.Error(nil, "CiliumEgressGatewayPolicy already exists and is not controlled by HAEgressGatewayPolicy",
+			"CiliumEgressGatewayPolicy", existing.Name)
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventConflictDetectedReason,
+			fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", existing.Name))
+		return nil
+	}
+	if equality.Semantic.DeepEqual(existing.Spec, desired.Spec) &&
+		equality.Semantic.DeepEqual(existing.Labels, desired.Labels) &&
+		equality.Semantic.DeepEqual(existing.Annotations, desired.Annotations) {
+		return nil
+	}
+	existing.Labels = desired.Labels
+	existing.Annotations = desired.Annotations
+	existing.Spec = desired.Spec
+	if err := r.Update(ctx, existing); err != nil {
+		return err
+	}
+	logger.V(1).Info("CiliumEgressGatewayPolicy of a namespace updated", "CiliumEgressGatewayPolicy", existing.Name)
+	return nil
+}
+
+// desiredFanOutPolicy returns the CiliumEgressGatewayPolicy of the namespace: the egress
+// gateway and the destinations of the CiliumEgressGatewayPolicy of the policy, with the
+// selectors of the policy restricted to the pods of the namespace
+func (r *NamespaceFanOutController) desiredFanOutPolicy(policy *haegressv2.HAEgressGatewayPolicy, primary *ciliumv2.CiliumEgressGatewayPolicy, namespace string) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%s", primary.Name, namespace),
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: *primary.Spec.DeepCopy(),
+	}
+	for key, value := range primary.Labels {
+		cegp.Labels[key] = value
+	}
+	cegp.Labels[haegressip.FanOutNamespaceLabel] = namespace
+	for key, value := range primary.Annotations {
+		cegp.Annotations[key] = value
+	}
+
+	selectors := policy.Spec.Selectors
+	if clusterName := primary.Annotations[haegressip.ClusterNameAnnotation]; clusterName != "" {
+		selectors = haegressiputil.RestrictSelectorsToCluster(selectors, clusterName)
+	}
+	cegp.Spec.Selectors = haegressiputil.RestrictSelectorsToNamespaces(selectors,
+		haegressiputil.SourceNamespaces{Allowed: []string{namespace}})
+
+	if err := controllerutil.SetControllerReference(policy, cegp, r.Scheme); err != nil {
+		return nil, err
+	}
+	return cegp, nil
+}
+
+// matchedNamespaces returns the namespaces matched by the namespaceSelector of the policy
+// and allowed as source, not terminating
+func (r *NamespaceFanOutController) matchedNamespaces(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespaceSelector: %w", err)
+	}
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, namespace := range namespaces.Items {
+		if namespace.DeletionTimestamp.IsZero() && r.SourceNamespaces.Allows(namespace.Name) {
+			matched = append(matched, namespace.Name)
+		}
+	}
+	return matched, nil
+}
+
+// fanOutPolicies returns the CiliumEgressGatewayPolicies of the namespaces of the policy
+func (r *NamespaceFanOutController) fanOutPolicies(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) ([]ciliumv2.CiliumEgressGatewayPolicy, error) {
+	var cegps ciliumv2.CiliumEgressGatewayPolicyList
+	if err := r.List(ctx, &cegps, client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: policy.Name},
+		client.HasLabels{haegressip.FanOutNamespaceLabel}); err != nil {
+		return nil, err
+	}
+	owned := []ciliumv2.CiliumEgressGatewayPolicy{}
+	for _, cegp := range cegps.Items {
+		if metav1.IsControlledBy(&cegp, policy) {
+			owned = append(owned, cegp)
+		}
+	}
+	return owned, nil
+}
+
+// serviceNamespace returns the namespace of the Service of the policy
+func (r *NamespaceFanOutController) serviceNamespace(policy *haegressv2.HAEgressGatewayPolicy) string {
+	return haegressiputil.ServiceNamespace(policy, r.EgressNamespace)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NamespaceFanOutController) SetupWithManager(mgr ctrl.Manager) error {
+	generated := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetLabels()[haegressip.HAEgressGatewayPolicyName] != ""
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespacefanout").
+		For(&haegressv2.HAEgressGatewayPolicy{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		// The egress gateway of the CiliumEgressGatewayPolicy of the policy is copied, the
+		// changes of the fanned out ones are corrected
+		Watches(&ciliumv2.CiliumEgressGatewayPolicy{},
+			handler.EnqueueRequestsFromMapFunc(policyForCiliumEgressGatewayPolicy),
+			builder.WithPredicates(generated)).
+		Watches(&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.fanOutPoliciesForNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		WithOptions(controllerOptions(r.Sharder, 1)).
+		Complete(r)
+}
+
+// policyForCiliumEgressGatewayPolicy returns the policy of the generated
+// CiliumEgressGatewayPolicy
+func policyForCiliumEgressGatewayPolicy(_ context.Context, object client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: object.GetLabels()[haegressip.HAEgressGatewayPolicyName]}}}
+}
+
+// fanOutPoliciesForNamespace returns the policies with a namespaceSelector, the namespace
+// can join or leave any of them
+func (r *NamespaceFanOutController) fanOutPoliciesForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		r.Log.Error(err, "unable to list the policies of the namespace", "namespace", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range policies.Items {
+		if policies.Items[i].Spec.NamespaceSelector != nil {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+		}
+	}
+	return requests
+}
+
+// fanOutPlaceholderSelectors are the selectors of the CiliumEgressGatewayPolicy of a policy
+// fanned out to namespaces: every pod has a namespace, so they select none
+func fanOutPlaceholderSelectors() []ciliumv2.EgressRule {
+	return []ciliumv2.EgressRule{{
+		PodSelector: &slimv1.LabelSelector{
+			MatchExpressions: []slimv1.LabelSelectorRequirement{{
+				Key:      haegressip.PodNamespaceLabel,
+				Operator: slimv1.LabelSelectorOpDoesNotExist,
+			}},
+		},
+	}}
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// ConditionGatewayGroupIgnored is True when the policy requests a gateway group
+	// without the interface mode, so only the exit node is selected
+	ConditionGatewayGroupIgnored = "GatewayGroupIgnored"
+	// ReasonInterfaceModeRequired is the reason of the GatewayGroupIgnored condition True
+	ReasonInterfaceModeRequired = "InterfaceModeRequired"
+	// ReasonGatewayGroupApplied is the reason of the GatewayGroupIgnored condition False
+	ReasonGatewayGroupApplied = "GatewayGroupApplied"
+)
+
+// checkGatewayGroup records in the GatewayGroupIgnored condition whether the gateway group
+// of the policy is ignored, with a warning when it starts to be
+func (r *HAEgressGatewayPolicyReconciler) checkGatewayGroup(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	condition := metav1.Condition{
+		Type:               ConditionGatewayGroupIgnored,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonGatewayGroupApplied,
+		Message:            "The gateway group, if any, is applied",
+		ObservedGeneration: haEgressGatewayPolicy.Generation,
+	}
+	ignored := haegressiputil.GatewayGroupIgnored(haEgressGatewayPolicy)
+	if ignored {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonInterfaceModeRequired
+		condition.Message = fmt.Sprintf("The %s annotation needs the %s annotation, only the exit node is selected",
+			haegressip.EgressGatewayGroupSizeAnnotation, haegressip.EgressInterfaceAnnotation)
+	}
+	changed, err := r.setCondition(ctx, haEgressGatewayPolicy, condition)
+	if err != nil {
+		return err
+	}
+	if changed && ignored {
+		ctrl.LoggerFrom(ctx).Info("Gateway group without the egress interface, selecting the exit node only")
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, condition.Message)
+	}
+	return nil
+}
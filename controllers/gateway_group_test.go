@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckGatewayGroup(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{
+		Name:        "egress-web",
+		Annotations: map[string]string{haegressip.EgressGatewayGroupSizeAnnotation: "2"},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).
+		WithStatusSubresource(&haegressv2.HAEgressGatewayPolicy{}).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &HAEgressGatewayPolicyReconciler{Client: c, Recorder: recorder}
+
+	check := func() *metav1.Condition {
+		t.Helper()
+		stored := &haegressv2.HAEgressGatewayPolicy{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(policy), stored); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.checkGatewayGroup(ctx, stored); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(policy), stored); err != nil {
+			t.Fatal(err)
+		}
+		return meta.FindStatusCondition(stored.Status.Conditions, ConditionGatewayGroupIgnored)
+	}
+
+	// The warning is recorded once, not at every sync
+	for i := 0; i < 3; i++ {
+		if condition := check(); condition == nil || condition.Status != metav1.ConditionTrue {
+			t.Fatalf("the gateway group without the interface has the condition %+v", condition)
+		}
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("%d events were recorded, expected one", len(recorder.Events))
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), policy); err != nil {
+		t.Fatal(err)
+	}
+	policy.Annotations[haegressip.EgressInterfaceAnnotation] = "auto"
+	if err := c.Update(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	if condition := check(); condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("the gateway group with the interface has the condition %+v", condition)
+	}
+}
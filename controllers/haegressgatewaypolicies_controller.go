@@ -21,24 +21,43 @@ import (
 	"fmt"
 	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/bindings"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/clustercidrs"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/coexistence"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/desired"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/feeds"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/fqdn"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/preflight"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/reclaim"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/startup"
 	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
-	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -50,12 +69,66 @@ type HAEgressGatewayPolicyReconciler struct {
 	Scheme                   *runtime.Scheme
 	Recorder                 record.EventRecorder
 	EgressNamespace          string
-	LoadBalancerClass        string
 	BackgroundCheckerSeconds int
-	lastServiceUpdate        atomic.Value
+	SyncOptions              haegressiputil.SyncOptions
+	ClusterName              string
+	LocalClusterOnly         bool
+	Allocators               *ipam.Registry
+	// SourceNamespaces restricts the namespaces of the pods selected by the policies
+	SourceNamespaces haegressiputil.SourceNamespaces
+	// SelectorPreview, if set, previews in the status what the selectors of the policies
+	// match
+	SelectorPreview *SelectorPreviewOptions
+	// Bindings, if set, holds the egress IPs restored from a backup, requested when the
+	// Service of the policy is created
+	Bindings *bindings.Store
+	// Reclaimable, if set, holds the egress IPs allocated from an IPAM to the deleted
+	// policies for a grace period before they are released
+	Reclaimable *reclaim.Store
+	// DrainGrace, if positive, is the time the CiliumEgressGatewayPolicies of a deleted
+	// policy stop selecting the pods before they are deleted with it
+	DrainGrace time.Duration
+	// ProtectServices adds to the Services the finalizer that keeps them while their
+	// namespace is deleted, see ServiceProtectionController
+	ProtectServices bool
+	// IPFamilies configures the ipFamilyPolicy and the ipFamilies of the Services
+	IPFamilies haegressiputil.IPFamilyOptions
+	// FQDNs resolves the destinationFQDNs of the policies
+	FQDNs *fqdn.Cache
+	// Feeds expands the destinationProviders of the policies, nil without configured feeds
+	Feeds *feeds.Cache
+	// ClusterCIDRs, if set, excludes the cluster-internal CIDRs from the destinations
+	ClusterCIDRs *clustercidrs.Cache
+	// Desired, if set, holds the Service and the CiliumEgressGatewayPolicy interpreted from
+	// every policy before they are applied
+	Desired *desired.Store
+	// SelectorlessServices creates the Services without the placeholder selector matching
+	// no pod, so the endpoint controllers have nothing to reconcile for them
+	SelectorlessServices bool
+	// NamespaceDefaults uses the defaults in the annotations of the Service namespaces for
+	// the values the policies do not set
+	NamespaceDefaults bool
+	// Metadata selects the labels and annotations of the policies copied on the generated
+	// objects
+	Metadata haegressiputil.MetadataOptions
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+	// MaxConcurrentReconciles is the number of workers of the controller
+	MaxConcurrentReconciles int
+	// Barrier, if set, follows the first reconciliation pass of the policies
+	Barrier *startup.Barrier
+	// Cilium, if set, gates the policies requiring Cilium features that are disabled
+	Cilium *preflight.Checker
+	// ListPageSize is the number of policies read in every page by the background checker
+	ListPageSize      int64
+	pager             *haegressiputil.Pager
+	lastServiceUpdate atomic.Value
+	expectations      expectations
+	// checkerPeriod is the period of the background checker, changed at runtime
+	checkerPeriod atomic.Int64
 }
 
-//+kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=haegressgatewaypolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=haegressgatewaypolicies,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=haegressgatewaypolicies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=haegressgatewaypolicies/finalizers,verbs=update
 
@@ -70,6 +143,7 @@ type HAEgressGatewayPolicyReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.3/pkg/reconcile
 func (r *HAEgressGatewayPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
+	defer r.Barrier.Reconciled(req.Name)
 
 	var haEgressGatewayPolicy haegressv2.HAEgressGatewayPolicy
 
@@ -79,52 +153,239 @@ func (r *HAEgressGatewayPolicyReconciler) Reconcile(ctx context.Context, req ctr
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
 			// requeue (we'll need to wait for a new notification), and we can get them
 			// on deleted requests.
+			haegressmetrics.PolicyDeleted(req.Name)
+			if r.FQDNs != nil {
+				r.FQDNs.Forget(req.Name)
+			}
+			if r.Feeds != nil {
+				r.Feeds.Forget(req.Name)
+			}
+			r.Desired.Delete(req.Name)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch HAEgressGatewayPolicy", "HAEgressGatewayPolicy", req.NamespacedName)
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "fetch_policy", err)
+		return ctrl.Result{}, err
+	}
+	// The policies of the shards owned by the other replicas are ignored, a shard taken
+	// later is checked by the background checker
+	if !r.Sharder.Owns(haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Labels) {
+		r.Desired.Delete(req.Name)
+		return ctrl.Result{}, nil
+	}
+	// A paused policy is left as it is until it is resumed
+	if haegressiputil.IsPaused(&haEgressGatewayPolicy) && haEgressGatewayPolicy.DeletionTimestamp.IsZero() {
+		log.V(1).Info("HAEgressGatewayPolicy paused, skipping it", "HAEgressGatewayPolicy", req.Name)
+		return ctrl.Result{}, nil
+	}
+	haegressmetrics.Reconciled(haegressmetrics.ControllerPolicies, req.Name)
+	haegressmetrics.PolicySeen(req.Name)
+
+	// Drain the CiliumEgressGatewayPolicies, then release the egress IP allocated from the
+	// external IPAM before the policy is removed
+	if !haEgressGatewayPolicy.DeletionTimestamp.IsZero() {
+		if result, err := r.drain(ctx, &haEgressGatewayPolicy); err != nil {
+			log.Error(err, "unable to drain the CiliumEgressGatewayPolicies of the deleted HAEgressGatewayPolicy")
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "drain", err)
+			return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
+		} else if !result.IsZero() {
+			return result, nil
+		}
+		if err := r.releaseEgressIP(ctx, &haEgressGatewayPolicy); err != nil {
+			log.Error(err, "unable to release the egress IP from the external IPAM")
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "ipam_release", err)
+			return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
+		}
+		haegressmetrics.PolicyDeleted(req.Name)
+		return ctrl.Result{}, nil
+	}
+	// The defaults of the namespace select the provider and the IPAM of the policy
+	if err := r.recordNamespaceDefaults(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to record the namespace defaults of HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "namespace_defaults", err)
+		return ctrl.Result{}, err
+	}
+	allocator, err := r.Allocators.ForPolicy(&haEgressGatewayPolicy)
+	if err != nil {
+		log.Error(err, "invalid IPAM configured for HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "ipam_config", err)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventIPAMFailedReason, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if allocator != nil && controllerutil.AddFinalizer(&haEgressGatewayPolicy, haegressip.IPAMReleaseFinalizer) {
+		if err := r.Update(ctx, &haEgressGatewayPolicy); err != nil {
+			log.Error(err, "unable to add the IPAM finalizer to HAEgressGatewayPolicy")
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "finalizer", err)
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.addDrainFinalizer(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to add the drain finalizer to HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "finalizer", err)
+		return ctrl.Result{}, err
+	}
+
+	// The annotations end up in the selectors and in the patches of the generated objects
+	if err := sanitize.PolicyAnnotations(haEgressGatewayPolicy.Annotations); err != nil {
+		log.Info("Invalid annotation of HAEgressGatewayPolicy, skipping it", "error", err.Error())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "invalid_value", err)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := sanitize.FQDNs(haEgressGatewayPolicy.Spec.DestinationFQDNs); err != nil {
+		log.Info("Invalid destination FQDN of HAEgressGatewayPolicy, skipping it", "error", err.Error())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "invalid_value", err)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := sanitize.ProviderReferences(haEgressGatewayPolicy.Spec.DestinationProviders); err != nil {
+		log.Info("Invalid destination provider of HAEgressGatewayPolicy, skipping it", "error", err.Error())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "invalid_value", err)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if ok, err := r.checkCiliumFeatures(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to update the Cilium features condition of HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "status_update", err)
+		return ctrl.Result{}, err
+	} else if !ok {
+		return ctrl.Result{RequeueAfter: ciliumFeaturesRequeueAfter}, nil
+	}
+
+	if err := r.checkGatewayGroup(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to update the gateway group condition of HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "status_update", err)
 		return ctrl.Result{}, err
 	}
 
+	// The Service could not be watched, and in namespaced mode not even created
+	serviceNamespace := haegressiputil.ServiceNamespace(&haEgressGatewayPolicy, r.EgressNamespace)
+	if !r.SyncOptions.Namespaces.Includes(serviceNamespace) {
+		log.Info("The Service namespace of the HAEgressGatewayPolicy is not watched by the operator, skipping it", "namespace", serviceNamespace)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventNamespaceNotWatchedReason,
+			fmt.Sprintf("The namespace %s of the Service is not watched by the operator", serviceNamespace))
+		return ctrl.Result{}, nil
+	}
+	if !r.SyncOptions.Namespaces.Writable(serviceNamespace) {
+		log.Info("The Service namespace of the HAEgressGatewayPolicy is not in --service-namespaces, skipping it", "namespace", serviceNamespace)
+		r.Recorder.Event(&haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventNamespaceNotAllowedReason,
+			fmt.Sprintf("The operator is not allowed to create the Service in the namespace %s", serviceNamespace))
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.checkSourceNamespaces(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to resolve the source namespaces of HAEgressGatewayPolicy")
+	}
+	if err := r.previewSelectors(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to preview the selectors of HAEgressGatewayPolicy")
+	}
+
+	// The children owned by Git are moved by the GitOps tool
+	if !haegressiputil.SkipsChildren(&haEgressGatewayPolicy) {
+		if err := r.updateRetargetStatus(ctx, &haEgressGatewayPolicy, serviceNamespace); err != nil {
+			log.Error(err, "unable to update the Service namespace in the status of HAEgressGatewayPolicy")
+		}
+	}
+
 	if err := r.UpdateOrCreateCiliumEgressGatewayPolicy(ctx, &haEgressGatewayPolicy); err != nil {
 		log.Error(err, "unable to create or update CiliumEgressGatewayPolicy, please check RBAC permissions")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "cegp", err)
 		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
 	}
 
 	// Check if a service generated by this controller already exists, if not create the service
 	if err := r.UpdateOrCreateService(ctx, &haEgressGatewayPolicy); err != nil {
 		log.Error(err, "unable to create or update Service, please check RBAC permissions")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, req.Name, "service", err)
 		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
 	}
+	haegressmetrics.PolicySynced(req.Name)
 
 	return ctrl.Result{}, nil
 }
 
+// UpdateOrCreateCiliumEgressGatewayPolicy interprets the CiliumEgressGatewayPolicy of the
+// policy, stores it in the desired state and applies it
 func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
-	log := ctrl.LoggerFrom(ctx)
-
 	// Save the last update date in order to delay the next background check
 	r.lastServiceUpdate.Store(time.Now())
 
-	logger := log.WithValues("HAEgressGatewayPolicy", haEgressGatewayPolicy.Name)
+	if haegressiputil.SkipsChildren(haEgressGatewayPolicy) {
+		return r.checkChildOwnedByGit(ctx, haEgressGatewayPolicy, &ciliumv2.CiliumEgressGatewayPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%s", r.serviceNamespace(haEgressGatewayPolicy), haEgressGatewayPolicy.Name)},
+		}, "CiliumEgressGatewayPolicy")
+	}
 
-	serviceNamespace := r.EgressNamespace
-	if haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace] != "" {
-		serviceNamespace = haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace]
+	ciliumEgressGatewayPolicyNew, err := r.desiredCiliumEgressGatewayPolicy(ctx, haEgressGatewayPolicy)
+	if err != nil {
+		return err
 	}
+	r.Desired.SetCiliumEgressGatewayPolicy(haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Generation, ciliumEgressGatewayPolicyNew)
+	return r.applyCiliumEgressGatewayPolicy(ctx, haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew)
+}
 
+// serviceNamespace returns the namespace of the Service of the policy
+func (r *HAEgressGatewayPolicyReconciler) serviceNamespace(haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) string {
+	return haegressiputil.ServiceNamespace(haEgressGatewayPolicy, r.EgressNamespace)
+}
+
+// desiredCiliumEgressGatewayPolicy interprets the policy into its CiliumEgressGatewayPolicy,
+// with the resolved destinations and the restrictions of the operator, without changing the
+// cluster
+func (r *HAEgressGatewayPolicyReconciler) desiredCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
+	labels, annotations := r.Metadata.GeneratedMetadata(haEgressGatewayPolicy)
 	ciliumEgressGatewayPolicyNew := &ciliumv2.CiliumEgressGatewayPolicy{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: fmt.Sprintf("%s-%s",
-				serviceNamespace,
+				r.serviceNamespace(haEgressGatewayPolicy),
 				haEgressGatewayPolicy.Name),
-			Labels:      haEgressGatewayPolicy.Labels,
-			Annotations: haEgressGatewayPolicy.Annotations,
+			Labels:      labels,
+			Annotations: annotations,
 		},
-		Spec: haEgressGatewayPolicy.Spec,
+		Spec: *haEgressGatewayPolicy.Spec.CiliumEgressGatewayPolicySpec.DeepCopy(),
+	}
+	// The default eligible nodes of the Service namespace stand for an empty nodeSelector
+	if ciliumEgressGatewayPolicyNew.Spec.EgressGateway != nil {
+		ciliumEgressGatewayPolicyNew.Spec.EgressGateway.NodeSelector = haEgressGatewayPolicy.EgressNodeSelector().DeepCopy()
+	}
+
+	r.addDestinationFQDNs(ctx, haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew)
+	r.addDestinationProviders(ctx, haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew)
+	r.excludeClusterCIDRs(ctx, ciliumEgressGatewayPolicyNew)
+
+	// In ClusterMesh setups, avoid selecting endpoints of the remote clusters
+	if r.ClusterName != "" && (r.LocalClusterOnly || haEgressGatewayPolicy.Annotations[haegressip.ClusterMeshLocalOnlyAnnotation] == "true") {
+		ciliumEgressGatewayPolicyNew.Spec.Selectors = haegressiputil.RestrictSelectorsToCluster(ciliumEgressGatewayPolicyNew.Spec.Selectors, r.ClusterName)
+		ciliumEgressGatewayPolicyNew.Annotations[haegressip.ClusterNameAnnotation] = r.ClusterName
+	}
+
+	// The pods of the namespaces not allowed are never selected, even when a namespace
+	// selector matches them later
+	ciliumEgressGatewayPolicyNew.Spec.Selectors = haegressiputil.RestrictSelectorsToNamespaces(ciliumEgressGatewayPolicyNew.Spec.Selectors, r.SourceNamespaces)
+
+	// With a namespaceSelector the pods are selected by the CiliumEgressGatewayPolicies of
+	// the namespaces, this one only keeps the egress gateway they copy
+	if haEgressGatewayPolicy.Spec.NamespaceSelector != nil {
+		ciliumEgressGatewayPolicyNew.Spec.Selectors = fanOutPlaceholderSelectors()
 	}
 
 	// Set HAEgressGatewayPolicy instance as the owner and controller
 	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew, r.Scheme); err != nil {
+		return nil, err
+	}
+	return ciliumEgressGatewayPolicyNew, nil
+}
+
+// applyCiliumEgressGatewayPolicy creates the desired CiliumEgressGatewayPolicy of the
+// policy, or corrects the drift of the existing one
+func (r *HAEgressGatewayPolicyReconciler) applyCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, ciliumEgressGatewayPolicyNew *ciliumv2.CiliumEgressGatewayPolicy) error {
+	logger := ctrl.LoggerFrom(ctx).WithValues("HAEgressGatewayPolicy", haEgressGatewayPolicy.Name)
+	serviceNamespace := r.serviceNamespace(haEgressGatewayPolicy)
+
+	// The CiliumEgressGatewayPolicy of the previous Service namespace is deleted first, the
+	// pods must never match two policies
+	if err := r.retireCiliumEgressGatewayPolicies(ctx, haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew); err != nil {
 		return err
 	}
 
@@ -133,27 +394,37 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 		Name: ciliumEgressGatewayPolicyNew.Name,
 	}, ciliumEgressGatewayPolicyExist)
 
-	if err != nil && apierrors.IsNotFound(err) {
+	expectationKey := "CiliumEgressGatewayPolicy/" + ciliumEgressGatewayPolicyNew.Name
+	if err != nil && apierrors.IsNotFound(err) && r.expectations.pending(expectationKey) {
+		logger.V(1).Info("CiliumEgressGatewayPolicy created and not yet in the cache, skipping",
+			"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyNew.Name)
+	} else if err != nil && apierrors.IsNotFound(err) {
 		logger.Info("Creating a new CiliumEgressGatewayPolicy for HAEgressGatewayPolicy",
 			"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyNew.Name)
 		err = r.Create(ctx, ciliumEgressGatewayPolicyNew)
 		r.Recorder.Event(haEgressGatewayPolicy,
 			corev1.EventTypeNormal,
-			"Created",
+			haegressip.EventCreatedReason,
 			fmt.Sprintf("CiliumEgressGatewayPolicy %q created", ciliumEgressGatewayPolicyNew.Name))
 		if err != nil {
 			return err
 		}
-		if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew, r.Scheme); err != nil {
-			return err
+		r.expectations.expectCreated(expectationKey)
+		haegressmetrics.UnmanagedConflictResolved(haEgressGatewayPolicy.Name, "CiliumEgressGatewayPolicy")
+		if haegressmetrics.DriftCorrected(ctx, haegressmetrics.DriftCEGPMissing) {
+			logger.Info("Drift corrected, the CiliumEgressGatewayPolicy was missing",
+				"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyNew.Name)
+			r.driftCorrectedEvent(haEgressGatewayPolicy, haegressmetrics.DriftCEGPMissing,
+				fmt.Sprintf("CiliumEgressGatewayPolicy %q was missing and has been created again", ciliumEgressGatewayPolicyNew.Name))
 		}
 
-		// If service already exists, reconcile
+		// If service already exists, reconcile with the created object, the cache is not
+		// updated yet
 		service := &corev1.Service{}
 		err = r.Get(ctx, types.NamespacedName{Name: haEgressGatewayPolicy.Name, Namespace: serviceNamespace}, service)
 		if err == nil {
 			// Call the services reconcile function
-			_, syncError := haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, *service, *ciliumEgressGatewayPolicyNew)
+			_, syncError := haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, r.SyncOptions, *service, *ciliumEgressGatewayPolicyNew)
 			if syncError != nil {
 				return syncError
 			}
@@ -162,25 +433,78 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 	} else if err != nil {
 		return err
 	} else {
+		r.expectations.observed(expectationKey)
 		// Update CiliumEgressGatewayPolicy if this policy is manged by the HA
 		if !metav1.IsControlledBy(ciliumEgressGatewayPolicyExist, haEgressGatewayPolicy) {
 			logger.Error(nil, "CiliumEgressGatewayPolicy already exists and is not controlled by HAEgressGatewayPolicy",
 				"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name)
+			if haegressmetrics.UnmanagedConflict(haEgressGatewayPolicy.Name, "CiliumEgressGatewayPolicy", serviceNamespace) {
+				r.Recorder.Event(ciliumEgressGatewayPolicyExist,
+					corev1.EventTypeWarning,
+					haegressip.EventConflictDetectedReason,
+					fmt.Sprintf("Name expected by HAEgressGatewayPolicy %q, the object is not managed by the operator", haEgressGatewayPolicy.Name))
+			}
 			r.Recorder.Event(haEgressGatewayPolicy,
 				corev1.EventTypeWarning,
-				"AlreadyExists",
+				haegressip.EventConflictDetectedReason,
 				fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name))
 			return nil
 		} else {
-			if !reflect.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.Selectors, ciliumEgressGatewayPolicyNew.Spec.Selectors) {
+			if haegressmetrics.UnmanagedConflictResolved(haEgressGatewayPolicy.Name, "CiliumEgressGatewayPolicy") {
+				logger.Info("CiliumEgressGatewayPolicy conflict resolved", "CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name)
+			}
+			// Another controller reverting the CiliumEgressGatewayPolicy is not fought, it is
+			// written again by the background checker once the backoff ends
+			verdict := r.SyncOptions.Guard.Check(ctx, ciliumEgressGatewayPolicyExist, coexistence.Selectors, coexistence.DestinationCIDRs, coexistence.ExcludedCIDRs)
+			haegressiputil.ReportConflict(ctx, r.Client, logger, r.Recorder, r.SyncOptions, haEgressGatewayPolicy, verdict)
+			if verdict.Wait > 0 {
+				logger.V(1).Info("CiliumEgressGatewayPolicy reverted by another controller, not written",
+					"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name, "after", verdict.Wait.String())
+				return nil
+			}
+			drift := []string{}
+			driftKind := haegressmetrics.DriftCEGPSelectors
+			// Nil and empty fields are equal, the API server drops the empty ones
+			if !equality.Semantic.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.Selectors, ciliumEgressGatewayPolicyNew.Spec.Selectors) {
+				drift = append(drift, fmt.Sprintf("selectors: %d -> %d selectors", len(ciliumEgressGatewayPolicyExist.Spec.Selectors), len(ciliumEgressGatewayPolicyNew.Spec.Selectors)))
 				ciliumEgressGatewayPolicyExist.Spec.Selectors = ciliumEgressGatewayPolicyNew.Spec.Selectors
+			}
+			if !equality.Semantic.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.DestinationCIDRs, ciliumEgressGatewayPolicyNew.Spec.DestinationCIDRs) ||
+				!equality.Semantic.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.ExcludedCIDRs, ciliumEgressGatewayPolicyNew.Spec.ExcludedCIDRs) {
+				drift = append(drift, fmt.Sprintf("destinationCIDRs: %v -> %v, excludedCIDRs: %v -> %v",
+					ciliumEgressGatewayPolicyExist.Spec.DestinationCIDRs, ciliumEgressGatewayPolicyNew.Spec.DestinationCIDRs,
+					ciliumEgressGatewayPolicyExist.Spec.ExcludedCIDRs, ciliumEgressGatewayPolicyNew.Spec.ExcludedCIDRs))
+				if len(drift) == 1 {
+					driftKind = haegressmetrics.DriftCEGPCIDRs
+				}
+				ciliumEgressGatewayPolicyExist.Spec.DestinationCIDRs = ciliumEgressGatewayPolicyNew.Spec.DestinationCIDRs
+				ciliumEgressGatewayPolicyExist.Spec.ExcludedCIDRs = ciliumEgressGatewayPolicyNew.Spec.ExcludedCIDRs
+			}
+			if metadata := haegressiputil.MetadataDrift(ciliumEgressGatewayPolicyExist, ciliumEgressGatewayPolicyNew); len(metadata) > 0 {
+				if len(drift) == 0 {
+					driftKind = haegressmetrics.DriftCEGPMetadata
+				}
+				drift = append(drift, metadata...)
+			}
+			if stale := r.Metadata.StaleMetadata(ciliumEgressGatewayPolicyExist); len(stale) > 0 {
+				drift = append(drift, "removed "+strings.Join(stale, ", "))
+			}
+			if len(drift) > 0 {
 				err = r.Update(ctx, ciliumEgressGatewayPolicyExist)
 				if err != nil {
 					return err
 				}
+				r.SyncOptions.Guard.Written(ciliumEgressGatewayPolicyExist, coexistence.Selectors, coexistence.DestinationCIDRs, coexistence.ExcludedCIDRs)
 				logger.Info("CiliumEgressGatewayPolicy updated",
-					"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name)
-				r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, "Updated",
+					"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name, "diff", strings.Join(drift, "; "))
+				haegressmetrics.CEGPPatched(haegressmetrics.FieldSelectors)
+				if haegressmetrics.DriftCorrected(ctx, driftKind) {
+					logger.Info("Drift corrected on the CiliumEgressGatewayPolicy",
+						"CiliumEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name, "kind", driftKind, "diff", strings.Join(drift, "; "))
+					r.driftCorrectedEvent(haEgressGatewayPolicy, driftKind,
+						fmt.Sprintf("CiliumEgressGatewayPolicy %q restored: %s", ciliumEgressGatewayPolicyExist.Name, strings.Join(drift, "; ")))
+				}
+				r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventUpdatedReason,
 					fmt.Sprintf("CiliumEgressGatewayPolicy %q updated", ciliumEgressGatewayPolicyExist.Name))
 			}
 		}
@@ -188,29 +512,62 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 	return nil
 }
 
+// UpdateOrCreateService interprets the Service of the policy, stores it in the desired
+// state and applies it
 func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
 	log := ctrl.LoggerFrom(ctx)
 
 	// Save the last update date in order to delay the next background check
 	r.lastServiceUpdate.Store(time.Now())
 
-	serviceNamespace := r.EgressNamespace
-	if haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace] != "" {
-		serviceNamespace = haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace]
-	}
+	serviceNamespace := r.serviceNamespace(haEgressGatewayPolicy)
 
 	// @TODO: check if target namespace exists
 
-	// Define the service and copy all annotations from the HAEgressGatewayPolicy instance
+	if haegressiputil.SkipsChildren(haEgressGatewayPolicy) {
+		return r.checkChildOwnedByGit(ctx, haEgressGatewayPolicy, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: haEgressGatewayPolicy.Name, Namespace: serviceNamespace},
+		}, "Service")
+	}
+
+	// A Service just created and not yet in the cache must not be created, nor get a new
+	// egress IP from the IPAM, again
+	expectationKey := fmt.Sprintf("Service/%s/%s", serviceNamespace, haEgressGatewayPolicy.Name)
+	if r.expectations.pending(expectationKey) {
+		cached := &corev1.Service{}
+		err := r.Get(ctx, types.NamespacedName{Name: haEgressGatewayPolicy.Name, Namespace: serviceNamespace}, cached)
+		if apierrors.IsNotFound(err) {
+			log.V(1).Info("Service created and not yet in the cache, skipping", "Service.Namespace", serviceNamespace, "Service.Name", haEgressGatewayPolicy.Name)
+			return nil
+		}
+		r.expectations.observed(expectationKey)
+	}
+
+	service, vipProvider, err := r.desiredService(ctx, haEgressGatewayPolicy)
+	if err != nil || service == nil {
+		return err
+	}
+	r.Desired.SetService(haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Generation, service)
+	return r.applyService(ctx, haEgressGatewayPolicy, service, vipProvider, expectationKey)
+}
+
+// desiredService interprets the policy into its Service, before the egress IP is
+// allocated or restored. The Service is nil when the policy can't get one.
+func (r *HAEgressGatewayPolicyReconciler) desiredService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) (*corev1.Service, provider.Provider, error) {
+	log := ctrl.LoggerFrom(ctx)
+	serviceNamespace := r.serviceNamespace(haEgressGatewayPolicy)
+
+	// Define the service and copy the annotations from the HAEgressGatewayPolicy instance,
+	// except the ones of the GitOps tools
+	labels, annotations := r.Metadata.GeneratedMetadata(haEgressGatewayPolicy)
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        haEgressGatewayPolicy.Name,
 			Namespace:   serviceNamespace,
-			Labels:      haEgressGatewayPolicy.Labels,
-			Annotations: haEgressGatewayPolicy.Annotations,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.ServiceSpec{
-			LoadBalancerClass: &r.LoadBalancerClass,
 			Ports: []corev1.ServicePort{
 				{
 					Name:     "nope",
@@ -218,44 +575,138 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Cont
 					Port:     65534,
 				},
 			},
-			Type: corev1.ServiceTypeLoadBalancer,
-			// Points nowhere, is a serviceless service used to create the IP object
-			Selector: map[string]string{
-				haegressip.HAEgressGatewayPolicyNamespace: serviceNamespace,
-				haegressip.HAEgressGatewayPolicyName:      haEgressGatewayPolicy.Name,
-			},
 		},
 	}
-
-	if service.Labels == nil {
-		service.Labels = make(map[string]string)
-	}
-	if service.Annotations == nil {
-		service.Annotations = make(map[string]string)
+	// Points nowhere, is a serviceless service used to create the IP object. Without the
+	// selector no EndpointSlice is written for it at all
+	if !r.SelectorlessServices {
+		service.Spec.Selector = map[string]string{
+			haegressip.HAEgressGatewayPolicyNamespace: serviceNamespace,
+			haegressip.HAEgressGatewayPolicyName:      haEgressGatewayPolicy.Name,
+		}
 	}
-	// Avoid L2 announcement by Cilium
-	service.Labels[haegressip.KubernetesServiceProxyNameAnnotation] = "kubevip-managed-by-cilium-haegess"
+
 	service.Labels[haegressip.HAEgressGatewayPolicyNamespace] = serviceNamespace
 	service.Labels[haegressip.HAEgressGatewayPolicyName] = haEgressGatewayPolicy.Name
+	if r.ProtectServices {
+		controllerutil.AddFinalizer(service, haegressip.ServiceProtectionFinalizer)
+	}
+
+	// The type, class and labels of the Service depend on the provider that assigns the IP
+	vipProvider, err := r.SyncOptions.Providers.ForPolicy(haEgressGatewayPolicy)
+	if err != nil {
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+		return nil, nil, err
+	}
+	vipProvider.ConfigureService(haEgressGatewayPolicy, service)
+
+	// A Service requesting a family the cluster lacks would be rejected by the API server
+	familyPolicy, families, err := r.IPFamilies.ForPolicy(haEgressGatewayPolicy)
+	if err != nil {
+		log.Info("Invalid IP families of HAEgressGatewayPolicy, skipping its Service", "error", err.Error())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerPolicies, haEgressGatewayPolicy.Name, "invalid_value", err)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+		return nil, nil, nil
+	}
+	service.Spec.IPFamilyPolicy = familyPolicy
+	service.Spec.IPFamilies = families
 
 	// Set HAEgressGatewayPolicy instance as the owner and controller
 	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, service, r.Scheme); err != nil {
+		return nil, nil, err
+	}
+	return service, vipProvider, nil
+}
+
+// applyService requests the egress IP of the desired Service, restored, migrated from the
+// previous namespace or allocated by the IPAM, then creates the Service or corrects the
+// drift of the existing one
+func (r *HAEgressGatewayPolicyReconciler) applyService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service, vipProvider provider.Provider, expectationKey string) error {
+	log := ctrl.LoggerFrom(ctx)
+	serviceNamespace := service.Namespace
+
+	// A policy restored from a backup gets its egress IP back when the Service is created
+	restored, err := r.restoredEgressIP(ctx, haEgressGatewayPolicy, service, vipProvider)
+	if err != nil {
+		return err
+	}
+
+	// Allocate the IP from the external IPAM and request exactly that IP to the provider
+	allocator, err := r.Allocators.ForPolicy(haEgressGatewayPolicy)
+	if err != nil {
 		return err
 	}
 
+	// A Service moved to another namespace keeps the egress IP of the previous one
+	previous, err := r.previousServices(ctx, haEgressGatewayPolicy, serviceNamespace)
+	if err != nil {
+		return err
+	}
+	migrated, err := r.migratedEgressIP(ctx, service, previous, vipProvider, allocator)
+	if err != nil {
+		return err
+	}
+
+	if allocator != nil {
+		ip := migrated
+		allocatorName := ""
+		if ip != "" {
+			allocatorName = ipamAllocatorName(&previous[0], allocator)
+		} else if ip, allocatorName, err = r.allocateEgressIP(ctx, allocator, haEgressGatewayPolicy, service, restored); err != nil {
+			r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventIPAMFailedReason,
+				fmt.Sprintf("Unable to allocate the egress IP from %s: %s", allocator.Name(), err))
+			return err
+		}
+		// The IP is released to the IPAM that allocated it, even if the policy selects another one later
+		service.Annotations[haegressip.IPAMAllocatedIPAnnotation] = ip
+		service.Annotations[haegressip.IPAMAllocatorAnnotation] = allocatorName
+		vipProvider.RequestIP(service, ip)
+	} else if restored != "" {
+		vipProvider.RequestIP(service, restored)
+	} else if migrated != "" {
+		vipProvider.RequestIP(service, migrated)
+	}
+
 	// Check if the service already exists, create if not exist, while if exist it will update the service
 	found := &corev1.Service{}
-	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	err = r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
 	if err != nil && apierrors.IsNotFound(err) {
 		log.Info("Creating a new Service for HAEgressGatewayPolicy", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
 		err = r.Create(ctx, service)
+		if apierrors.IsAlreadyExists(err) {
+			// The Service is not in the cache, it does not match the cache selector so it was
+			// not created by the operator
+			log.Error(nil, "Service already exists, outside of the cache, and is not controlled by HAEgressGatewayPolicy",
+				"Service.Namespace", service.Namespace, "Service.Name", service.Name)
+			haegressmetrics.UnmanagedConflict(haEgressGatewayPolicy.Name, "Service", serviceNamespace)
+			r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventConflictDetectedReason,
+				fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", service.Name))
+			return nil
+		}
 		r.Recorder.Event(haEgressGatewayPolicy,
 			corev1.EventTypeNormal,
-			"Created",
+			haegressip.EventCreatedReason,
 			fmt.Sprintf("Service %s/%s created", service.Namespace, service.Name))
 		if err != nil {
 			return err
 		}
+		r.expectations.expectCreated(expectationKey)
+		haegressmetrics.ServiceCreated()
+		if restored != "" {
+			r.forgetBinding(ctx, haEgressGatewayPolicy, restored)
+		}
+		haegressmetrics.UnmanagedConflictResolved(haEgressGatewayPolicy.Name, "Service")
+		if migrated != "" {
+			log.Info("Requested the egress IP of the Service of the previous namespace", "IP", migrated)
+		}
+		if err := r.deletePreviousServices(ctx, haEgressGatewayPolicy, service, previous); err != nil {
+			return err
+		}
+		if len(previous) == 0 && haegressmetrics.DriftCorrected(ctx, haegressmetrics.DriftServiceMissing) {
+			log.Info("Drift corrected, the Service was missing", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
+			r.driftCorrectedEvent(haEgressGatewayPolicy, haegressmetrics.DriftServiceMissing,
+				fmt.Sprintf("Service %s/%s was missing and has been created again", service.Namespace, service.Name))
+		}
 	} else if err != nil {
 		return err
 	} else {
@@ -263,17 +714,49 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Cont
 		if !metav1.IsControlledBy(found, haEgressGatewayPolicy) {
 			log.Error(nil, "Service already exists and is not controlled by HAEgressGatewayPolicy",
 				"Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			if haegressmetrics.UnmanagedConflict(haEgressGatewayPolicy.Name, "Service", serviceNamespace) {
+				r.Recorder.Event(found, corev1.EventTypeWarning, haegressip.EventConflictDetectedReason,
+					fmt.Sprintf("Name expected by HAEgressGatewayPolicy %q, the object is not managed by the operator", haEgressGatewayPolicy.Name))
+			}
 			// Generate an event to record this issue in haEgressGatewayPolicy
-			r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, "AlreadyExists", fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", found.Name))
+			r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventConflictDetectedReason, fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", found.Name))
 
 			return nil
 		} else {
-			if !reflect.DeepEqual(found.Spec.Selector, service.Spec.Selector) {
-				log.Info("Updating Service already controlled by HAEgressGatewayPolicy", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
-				err = r.Update(ctx, service)
+			if haegressmetrics.UnmanagedConflictResolved(haEgressGatewayPolicy.Name, "Service") {
+				log.Info("Service conflict resolved", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			}
+			if err := r.deletePreviousServices(ctx, haEgressGatewayPolicy, found, previous); err != nil {
+				return err
+			}
+			// A Service being deleted can't get new finalizers, it is created again once deleted
+			if !found.DeletionTimestamp.IsZero() {
+				log.V(1).Info("Service being deleted, waiting for its deletion", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+				return nil
+			}
+			// Apply the drift on the existing Service, keeping the fields set by the providers
+			updated := found.DeepCopy()
+			drift := serviceDrift(updated, service)
+			if stale := r.Metadata.StaleMetadata(updated); len(stale) > 0 {
+				drift = append(drift, "removed "+strings.Join(stale, ", "))
+			}
+			if len(drift) > 0 {
+				log.Info("Updating Service already controlled by HAEgressGatewayPolicy", "Service.Namespace", found.Namespace, "Service.Name", found.Name,
+					"diff", strings.Join(drift, "; "))
+				err = r.Update(ctx, updated)
 				if err != nil {
 					return err
 				}
+				driftKind := haegressmetrics.DriftServiceSpec
+				if len(drift) == 1 && strings.HasPrefix(drift[0], "selector:") {
+					driftKind = haegressmetrics.DriftServiceSelector
+				}
+				if haegressmetrics.DriftCorrected(ctx, driftKind) {
+					log.Info("Drift corrected on the Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name,
+						"kind", driftKind, "diff", strings.Join(drift, "; "))
+					r.driftCorrectedEvent(haEgressGatewayPolicy, driftKind,
+						fmt.Sprintf("Service %s/%s restored: %s", found.Namespace, found.Name, strings.Join(drift, "; ")))
+				}
 			}
 		}
 	}
@@ -281,6 +764,254 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Cont
 	return nil
 }
 
+// driftCorrectedEvent records on the policy a drift of the given kind corrected on one of
+// the generated objects, the kind is the field annotation of the event
+func (r *HAEgressGatewayPolicyReconciler) driftCorrectedEvent(haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, kind, message string) {
+	r.Recorder.AnnotatedEventf(haEgressGatewayPolicy,
+		haegressiputil.EventAnnotations(haEgressGatewayPolicy.Name, kind, "", ""),
+		corev1.EventTypeNormal, haegressip.EventDriftCorrectedReason, "%s", message)
+}
+
+// serviceDrift applies to found the fields of the desired Service that drifted, and
+// returns a summary of the differences, in the same order at every reconciliation. The
+// labels and annotations added by other controllers, like the providers, are kept, the
+// ones removed from the policy are removed.
+func serviceDrift(found, desired *corev1.Service) []string {
+	drift := []string{}
+	if !equality.Semantic.DeepEqual(found.Spec.Selector, desired.Spec.Selector) {
+		drift = append(drift, fmt.Sprintf("selector: %v -> %v", found.Spec.Selector, desired.Spec.Selector))
+		found.Spec.Selector = desired.Spec.Selector
+	}
+	if found.Spec.Type != desired.Spec.Type {
+		drift = append(drift, fmt.Sprintf("type: %s -> %s", found.Spec.Type, desired.Spec.Type))
+		found.Spec.Type = desired.Spec.Type
+	}
+	if desired.Spec.LoadBalancerClass != nil && (found.Spec.LoadBalancerClass == nil || *found.Spec.LoadBalancerClass != *desired.Spec.LoadBalancerClass) {
+		drift = append(drift, fmt.Sprintf("loadBalancerClass: -> %s", *desired.Spec.LoadBalancerClass))
+		found.Spec.LoadBalancerClass = desired.Spec.LoadBalancerClass
+	}
+	if desired.Spec.ExternalTrafficPolicy != "" && found.Spec.ExternalTrafficPolicy != desired.Spec.ExternalTrafficPolicy {
+		drift = append(drift, fmt.Sprintf("externalTrafficPolicy: %s -> %s", found.Spec.ExternalTrafficPolicy, desired.Spec.ExternalTrafficPolicy))
+		found.Spec.ExternalTrafficPolicy = desired.Spec.ExternalTrafficPolicy
+		// The health check port is allocated only with the Local policy
+		if desired.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyLocal {
+			found.Spec.HealthCheckNodePort = 0
+		}
+	}
+	if desired.Spec.IPFamilyPolicy != nil && (found.Spec.IPFamilyPolicy == nil || *found.Spec.IPFamilyPolicy != *desired.Spec.IPFamilyPolicy) {
+		drift = append(drift, fmt.Sprintf("ipFamilyPolicy: -> %s", *desired.Spec.IPFamilyPolicy))
+		found.Spec.IPFamilyPolicy = desired.Spec.IPFamilyPolicy
+	}
+	// The primary family of a Service can't be changed, only the secondary one
+	if len(desired.Spec.IPFamilies) > 0 && !equality.Semantic.DeepEqual(found.Spec.IPFamilies, desired.Spec.IPFamilies) &&
+		(len(found.Spec.IPFamilies) == 0 || found.Spec.IPFamilies[0] == desired.Spec.IPFamilies[0]) {
+		drift = append(drift, fmt.Sprintf("ipFamilies: %v -> %v", found.Spec.IPFamilies, desired.Spec.IPFamilies))
+		found.Spec.IPFamilies = desired.Spec.IPFamilies
+	}
+	drift = append(drift, haegressiputil.MetadataDrift(found, desired)...)
+	for _, finalizer := range desired.Finalizers {
+		if controllerutil.AddFinalizer(found, finalizer) {
+			drift = append(drift, fmt.Sprintf("finalizer: -> %s", finalizer))
+		}
+	}
+	return drift
+}
+
+// checkChildOwnedByGit warns when the child of a policy whose children are owned by Git
+// does not exist, the operator waits for the GitOps tool to create it
+func (r *HAEgressGatewayPolicyReconciler) checkChildOwnedByGit(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, child client.Object, kind string) error {
+	err := r.Get(ctx, client.ObjectKeyFromObject(child), child)
+	if apierrors.IsNotFound(err) {
+		ctrl.LoggerFrom(ctx).Info("Child of HAEgressGatewayPolicy owned by Git not found, waiting for its creation",
+			"kind", kind, "namespace", child.GetNamespace(), "name", child.GetName())
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventChildNotFoundReason,
+			fmt.Sprintf("%s %q not found, the children of the policy are owned by Git (%s)", kind, child.GetName(), haegressip.SkipChildrenAnnotation))
+		return nil
+	}
+	return err
+}
+
+func (r *HAEgressGatewayPolicyReconciler) ipamRequest(haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, serviceNamespace string) ipam.Request {
+	return ipam.Request{
+		Policy:      haEgressGatewayPolicy.Name,
+		Namespace:   serviceNamespace,
+		Cluster:     r.ClusterName,
+		Labels:      haEgressGatewayPolicy.Labels,
+		Annotations: haEgressGatewayPolicy.Annotations,
+	}
+}
+
+// ipamAllocatorName returns the IPAM that allocated the IP of the Service, the allocator of
+// the policy for the Services created before the IPAM was recorded
+func ipamAllocatorName(service *corev1.Service, allocator ipam.Allocator) string {
+	if name := service.Annotations[haegressip.IPAMAllocatorAnnotation]; name != "" {
+		return name
+	}
+	return allocator.Name()
+}
+
+// allocateEgressIP returns the IP already allocated to the Service or allocates a new one,
+// the restored IP when the IPAM can reserve it, and the name of the IPAM that allocated it
+func (r *HAEgressGatewayPolicyReconciler) allocateEgressIP(ctx context.Context, allocator ipam.Allocator, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service, restored string) (string, string, error) {
+	existing := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, existing)
+	if err == nil && existing.Annotations[haegressip.IPAMAllocatedIPAnnotation] != "" {
+		return existing.Annotations[haegressip.IPAMAllocatedIPAnnotation], ipamAllocatorName(existing, allocator), nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return "", "", err
+	}
+
+	// The IPAM can restrict the ranges of the policy by the namespaces of its pods
+	request := r.ipamRequest(haEgressGatewayPolicy, service.Namespace)
+	if request.SourceNamespaces, err = mapping.Namespaces(ctx, r.Client, haEgressGatewayPolicy); err != nil {
+		return "", "", fmt.Errorf("unable to resolve the source namespaces: %w", err)
+	}
+	if reserver, ok := allocator.(ipam.Reserver); ok && restored != "" {
+		if err := reserver.Reserve(ctx, request, restored); err != nil {
+			return "", "", fmt.Errorf("unable to reserve the restored egress IP %s: %w", restored, err)
+		}
+		ctrl.LoggerFrom(ctx).Info("Reserved the restored egress IP in the external IPAM", "IPAM", allocator.Name(), "IP", restored)
+		return restored, allocator.Name(), nil
+	}
+	ip, err := allocator.Allocate(ctx, request)
+	if err != nil {
+		return "", "", err
+	}
+	// The external IPAMs return the same IP to the same policy, a different one means that
+	// the IPAM lost the allocation
+	if restored != "" && ip != restored {
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventBindingNotRestoredReason,
+			fmt.Sprintf("%s allocated %s instead of the restored egress IP %s", allocator.Name(), ip, restored))
+	}
+	ctrl.LoggerFrom(ctx).Info("Allocated egress IP from the external IPAM", "IPAM", allocator.Name(), "IP", ip)
+	r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventIPAMAllocatedReason,
+		fmt.Sprintf("Egress IP %s allocated from %s", ip, allocator.Name()))
+	return ip, allocator.Name(), nil
+}
+
+// checkSourceNamespaces warns when the policy selects the pods of namespaces not allowed,
+// which are excluded from the generated CiliumEgressGatewayPolicy
+func (r *HAEgressGatewayPolicyReconciler) checkSourceNamespaces(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	if !r.SourceNamespaces.IsRestricted() {
+		return nil
+	}
+	namespaces, err := mapping.Namespaces(ctx, r.Client, haEgressGatewayPolicy)
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, namespace := range namespaces {
+		switch {
+		case namespace == mapping.AllNamespaces && len(r.SourceNamespaces.Allowed) > 0:
+			found[fmt.Sprintf("%s (only %s allowed)", namespace, strings.Join(r.SourceNamespaces.Allowed, ","))] = true
+		case namespace == mapping.AllNamespaces:
+			// Every namespace is selected, the denied ones too
+			for _, denied := range r.SourceNamespaces.Denied {
+				found[denied] = true
+			}
+		case !r.SourceNamespaces.Allows(namespace):
+			found[namespace] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	excluded := make([]string, 0, len(found))
+	for namespace := range found {
+		excluded = append(excluded, namespace)
+	}
+	sort.Strings(excluded)
+	ctrl.LoggerFrom(ctx).Info("HAEgressGatewayPolicy selects the pods of namespaces not allowed, they are excluded", "namespaces", excluded)
+	r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventSourceNamespaceNotAllowedReason,
+		fmt.Sprintf("The pods of the namespaces %s are not allowed to use an egress IP and are excluded", strings.Join(excluded, ",")))
+	return nil
+}
+
+// restoredEgressIP returns the egress IP restored from a backup for the policy, only when
+// its Service does not exist yet and the policy does not request an IP on its own
+func (r *HAEgressGatewayPolicyReconciler) restoredEgressIP(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service, vipProvider provider.Provider) (string, error) {
+	if r.Bindings == nil {
+		return "", nil
+	}
+	if requestsIP(service, vipProvider) {
+		return "", nil
+	}
+	existing := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, existing)
+	if err == nil {
+		return "", nil
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	ip, err := r.Bindings.Lookup(ctx, haEgressGatewayPolicy.Name)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the restored bindings: %w", err)
+	}
+	return ip, nil
+}
+
+// forgetBinding removes the restored binding once the Service requested the IP, a
+// failure is only logged as the binding is ignored when the Service exists
+func (r *HAEgressGatewayPolicyReconciler) forgetBinding(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, ip string) {
+	ctrl.LoggerFrom(ctx).Info("Requested the egress IP restored from the backup", "IP", ip)
+	r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventBindingRestoredReason,
+		fmt.Sprintf("Egress IP %s restored from the backup", ip))
+	if err := r.Bindings.Forget(ctx, haEgressGatewayPolicy.Name); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to remove the restored binding", "ConfigMap", r.Bindings.ConfigMapName)
+	}
+}
+
+// releaseEgressIP frees the IP allocated to the policy and removes the finalizer. The IP is
+// released to the IPAM recorded on the Service, the one of the policy for the Services
+// created before it was recorded. An IPAM not configured anymore can't release the IP: it
+// is reported and the finalizer removed, rather than blocking the deletion forever.
+func (r *HAEgressGatewayPolicyReconciler) releaseEgressIP(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	if !controllerutil.ContainsFinalizer(haEgressGatewayPolicy, haegressip.IPAMReleaseFinalizer) {
+		return nil
+	}
+
+	serviceNamespace := haegressiputil.ServiceNamespace(haEgressGatewayPolicy, r.EgressNamespace)
+	service := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: haEgressGatewayPolicy.Name, Namespace: serviceNamespace}, service)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	ip := service.Annotations[haegressip.IPAMAllocatedIPAnnotation]
+	if ip == "" {
+		ip = haEgressGatewayPolicy.Status.IPAddress
+	}
+
+	var allocator ipam.Allocator
+	if name := service.Annotations[haegressip.IPAMAllocatorAnnotation]; name != "" {
+		allocator, err = r.Allocators.Get(name)
+	} else {
+		allocator, err = r.Allocators.ForPolicy(haEgressGatewayPolicy)
+	}
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to release the egress IP, the IPAM is not configured", "IP", ip)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventIPAMFailedReason,
+			fmt.Sprintf("Egress IP %s not released, release it manually: %s", ip, err))
+	} else if allocator != nil && ip != "" && r.Reclaimable != nil {
+		// The IP is released once the grace period ends
+		entry, err := r.Reclaimable.Hold(ctx, allocator.Name(), r.ipamRequest(haEgressGatewayPolicy, serviceNamespace), ip)
+		if err != nil {
+			return err
+		}
+		ctrl.LoggerFrom(ctx).Info("Holding the egress IP before releasing it to the external IPAM", "IPAM", allocator.Name(), "IP", ip,
+			"releaseAt", entry.ReleaseAt.Format(time.RFC3339))
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventIPReclaimableReason,
+			fmt.Sprintf("Egress IP %s held until %s before it is released to the %s IPAM", ip, entry.ReleaseAt.Format(time.RFC3339), allocator.Name()))
+	} else if allocator != nil && ip != "" {
+		if err := allocator.Release(ctx, r.ipamRequest(haEgressGatewayPolicy, serviceNamespace), ip); err != nil {
+			return err
+		}
+		ctrl.LoggerFrom(ctx).Info("Released egress IP to the external IPAM", "IPAM", allocator.Name(), "IP", ip)
+	}
+
+	controllerutil.RemoveFinalizer(haEgressGatewayPolicy, haegressip.IPAMReleaseFinalizer)
+	return r.Update(ctx, haEgressGatewayPolicy)
+}
+
 func (r *HAEgressGatewayPolicyReconciler) findObjectsForHaegressGatewayPolicy(ctx context.Context, obj client.Object) []reconcile.Request {
 	ownerRefs := obj.GetOwnerReferences()
 	requests := []reconcile.Request{}
@@ -301,18 +1032,30 @@ func (r *HAEgressGatewayPolicyReconciler) findObjectsForHaegressGatewayPolicy(ct
 
 func (r *HAEgressGatewayPolicyReconciler) backgroundPeriodicalCheck(ctx context.Context) {
 	log := ctrl.LoggerFrom(ctx)
-	ticker := time.NewTicker(time.Duration(r.BackgroundCheckerSeconds) * time.Second)
+	// The changes made by the periodic check are drift corrections
+	ctx = haegressmetrics.WithBackgroundCheck(ctx)
+	period := time.Duration(r.checkerPeriod.Load())
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-r.Sharder.Changed():
+			// The policies of the shards just taken were ignored until now
+			log.Info("Shards taken, checking their HAEgressGatewayPolicies")
+			r.checkPolicies(ctx)
 		case <-ticker.C:
+			if changed := time.Duration(r.checkerPeriod.Load()); changed != period {
+				log.Info("Background checker period changed", "period", changed.String())
+				period = changed
+				ticker.Reset(period)
+			}
 			// Manage concurrency, avoid update if the latest change happened recently, less than
 			// half of the background checker period
 			if lastUpdate, ok := r.lastServiceUpdate.Load().(time.Time); ok {
-				if time.Since(lastUpdate) < (time.Duration(r.BackgroundCheckerSeconds/2) * time.Second) {
+				if time.Since(lastUpdate) < period/2 {
 					log.Info("Last object update too recent, skipping periodic check",
 						"lastUpdate", lastUpdate)
 					continue
@@ -322,42 +1065,86 @@ func (r *HAEgressGatewayPolicyReconciler) backgroundPeriodicalCheck(ctx context.
 				r.lastServiceUpdate.Store(time.Now())
 				continue
 			}
+			r.checkPolicies(ctx)
+		}
+	}
+}
 
-			var policies haegressv2.HAEgressGatewayPolicyList
-			if err := r.List(ctx, &policies); err != nil {
-				log.Error(err, "failed to list HAEgressGatewayPolicies")
-				continue
-			}
+// checkPolicies reconciles the CiliumEgressGatewayPolicy and the Service of every policy
+// owned by the replica
+func (r *HAEgressGatewayPolicyReconciler) checkPolicies(ctx context.Context) {
+	log := ctrl.LoggerFrom(ctx)
 
-			for _, policy := range policies.Items {
-				log.Info("Periodic check of HAEgressGatewayPolicy",
-					"Name", policy.Name,
-					"Namespace", policy.Namespace)
+	// The policies are read from the API server, so a policy missed by the cache is still
+	// checked, in pages to stay within the API priority and fairness budget. Every page is
+	// checked by priority.
+	var policies haegressv2.HAEgressGatewayPolicyList
+	err := r.pager.List(ctx, &policies, func() error {
+		haegressiputil.SortByPriority(policies.Items)
+		for _, policy := range policies.Items {
+			r.checkPolicy(ctx, &policy)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "failed to list HAEgressGatewayPolicies")
+	}
+}
 
-				if err := r.UpdateOrCreateCiliumEgressGatewayPolicy(ctx, &policy); err != nil {
-					log.Error(err, "failed to update CiliumEgressGatewayPolicy")
-				}
+// checkPolicy reconciles the CiliumEgressGatewayPolicy and the Service of the policy, if
+// owned by the replica
+func (r *HAEgressGatewayPolicyReconciler) checkPolicy(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) {
+	log := ctrl.LoggerFrom(ctx)
 
-				if err := r.UpdateOrCreateService(ctx, &policy); err != nil {
-					log.Error(err, "failed to update Service")
-				}
-			}
-		}
+	// A deleted policy is left to Reconcile, which drains its CiliumEgressGatewayPolicies
+	if !r.Sharder.Owns(policy.Name, policy.Labels) || haegressiputil.IsPaused(policy) || !policy.DeletionTimestamp.IsZero() {
+		return
+	}
+	log.Info("Periodic check of HAEgressGatewayPolicy",
+		"Name", policy.Name,
+		"Namespace", policy.Namespace)
+
+	synced := true
+	if err := r.UpdateOrCreateCiliumEgressGatewayPolicy(ctx, policy); err != nil {
+		log.Error(err, "failed to update CiliumEgressGatewayPolicy")
+		synced = false
+	}
+
+	if err := r.UpdateOrCreateService(ctx, policy); err != nil {
+		log.Error(err, "failed to update Service")
+		synced = false
+	}
+	if synced {
+		haegressmetrics.PolicySynced(policy.Name)
+	}
+}
+
+// SetBackgroundCheckerSeconds changes the period of the background checker from the next
+// check. The checker enabled at startup can't be disabled, zero is ignored.
+func (r *HAEgressGatewayPolicyReconciler) SetBackgroundCheckerSeconds(seconds int) {
+	if seconds > 0 {
+		r.checkerPeriod.Store(int64(time.Duration(seconds) * time.Second))
 	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HAEgressGatewayPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.BackgroundCheckerSeconds > 0 {
+		r.checkerPeriod.Store(int64(time.Duration(r.BackgroundCheckerSeconds) * time.Second))
+		r.pager = &haegressiputil.Pager{Reader: mgr.GetAPIReader(), PageSize: r.ListPageSize}
 		ctx := context.Background()
 		go func() {
-			<-mgr.Elected()
+			// In sharding mode every replica checks its own policies
+			if r.Sharder == nil {
+				<-mgr.Elected()
+			}
 			r.backgroundPeriodicalCheck(ctx)
 		}()
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	policies := ctrl.NewControllerManagedBy(mgr).
 		For(&haegressv2.HAEgressGatewayPolicy{}).
+		WithOptions(controllerOptions(r.Sharder, r.MaxConcurrentReconciles)).
 		Watches(
 			&corev1.Service{},
 			handler.EnqueueRequestsFromMapFunc(r.findObjectsForHaegressGatewayPolicy),
@@ -393,6 +1180,37 @@ func (r *HAEgressGatewayPolicyReconciler) SetupWithManager(mgr ctrl.Manager) err
 					return false
 				},
 			}),
-		).
-		Complete(r)
+		)
+	// The policies of the namespaces whose defaults changed
+	if r.NamespaceDefaults {
+		policies = policies.Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.policiesForNamespace),
+			builder.WithPredicates(namespaceDefaultsChanged),
+		)
+	}
+	// The policies whose destination FQDNs resolve to new addresses
+	if r.FQDNs != nil && r.FQDNs.Events != nil {
+		policies = policies.WatchesRawSource(&source.Channel{Source: r.FQDNs.Events}, &handler.EnqueueRequestForObject{})
+	}
+	// The policies whose provider ranges changed
+	if r.Feeds != nil && r.Feeds.Events != nil {
+		policies = policies.WatchesRawSource(&source.Channel{Source: r.Feeds.Events}, &handler.EnqueueRequestForObject{})
+	}
+	// The policies excluding the cluster CIDRs, when they changed
+	if r.ClusterCIDRs != nil && r.ClusterCIDRs.Events != nil {
+		policies = policies.WatchesRawSource(&source.Channel{Source: r.ClusterCIDRs.Events}, &handler.EnqueueRequestForObject{})
+	}
+	return policies.Complete(r)
+}
+
+// controllerOptions runs the controllers with the given workers on every replica in
+// sharding mode, otherwise only on the leader
+func controllerOptions(sharder *shard.Sharder, maxConcurrentReconciles int) controller.Options {
+	options := controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles}
+	if sharder != nil {
+		needLeaderElection := false
+		options.NeedLeaderElection = &needLeaderElection
+	}
+	return options
 }
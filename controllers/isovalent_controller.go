@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/isovalent"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// isovalentSpecFields are the fields of the spec of the IsovalentEgressGatewayPolicy
+// written by the operator, the others are left to the API server defaults
+var isovalentSpecFields = []string{"selectors", "destinationCIDRs", "excludedCIDRs", "egressGroups"}
+
+// IsovalentEgressGatewayPolicyController mirrors the generated CiliumEgressGatewayPolicies
+// of the policies targeting Cilium Enterprise into IsovalentEgressGatewayPolicies with
+// the same name, and deletes the mirror when a policy targets the CiliumEgressGatewayPolicy
+// again.
+type IsovalentEgressGatewayPolicyController struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Target   isovalent.Options
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+}
+
+func (r *IsovalentEgressGatewayPolicyController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// The IsovalentEgressGatewayPolicy of a deleted CiliumEgressGatewayPolicy is deleted
+	// with the policy owning both
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, cegp); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	policyName := cegp.Labels[haegressip.HAEgressGatewayPolicyName]
+	if policyName == "" || !r.Sharder.Owns(policyName, cegp.Labels) {
+		return ctrl.Result{}, nil
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, client.ObjectKey{Name: policyName}, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !policy.DeletionTimestamp.IsZero() || !metav1.IsControlledBy(cegp, policy) {
+		return ctrl.Result{}, nil
+	}
+	logger := r.Log.WithValues("HAEgressGatewayPolicy", policy.Name, "IsovalentEgressGatewayPolicy", cegp.Name)
+
+	existing := isovalent.New()
+	err := r.Get(ctx, req.NamespacedName, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	found := err == nil
+
+	if r.Target.ForPolicy(policy) != isovalent.TargetIsovalentEgressGatewayPolicy {
+		if !found || !metav1.IsControlledBy(existing, policy) {
+			return ctrl.Result{}, nil
+		}
+		logger.Info("Deleting the IsovalentEgressGatewayPolicy, the policy targets the CiliumEgressGatewayPolicy")
+		if err := r.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	desired, err := isovalent.FromCiliumEgressGatewayPolicy(cegp, isovalent.MaxGatewayNodes(policy))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !found {
+		logger.Info("Creating a new IsovalentEgressGatewayPolicy for HAEgressGatewayPolicy")
+		if err := r.Create(ctx, desired); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventCreatedReason,
+			fmt.Sprintf("IsovalentEgressGatewayPolicy %q created", desired.GetName()))
+		return ctrl.Result{}, nil
+	}
+	if !metav1.IsControlledBy(existing, policy) {
+		logger.Error(nil, "IsovalentEgressGatewayPolicy already exists and is not controlled by HAEgressGatewayPolicy")
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventConflictDetectedReason,
+			fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", existing.GetName()))
+		return ctrl.Result{}, nil
+	}
+
+	changed := !equality.Semantic.DeepEqual(existing.GetLabels(), desired.GetLabels()) ||
+		!equality.Semantic.DeepEqual(existing.GetAnnotations(), desired.GetAnnotations())
+	spec := unstructuredMap(existing.Object, "spec")
+	desiredSpec := unstructuredMap(desired.Object, "spec")
+	for _, field := range isovalentSpecFields {
+		if !equality.Semantic.DeepEqual(spec[field], desiredSpec[field]) {
+			changed = true
+			if value, ok := desiredSpec[field]; ok {
+				spec[field] = value
+			} else {
+				delete(spec, field)
+			}
+		}
+	}
+	if !changed {
+		return ctrl.Result{}, nil
+	}
+	existing.SetLabels(desired.GetLabels())
+	existing.SetAnnotations(desired.GetAnnotations())
+	existing.Object["spec"] = spec
+	if err := r.Update(ctx, existing); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("IsovalentEgressGatewayPolicy updated")
+	r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventUpdatedReason,
+		fmt.Sprintf("IsovalentEgressGatewayPolicy %q updated", existing.GetName()))
+	return ctrl.Result{}, nil
+}
+
+// unstructuredMap returns the map of the field of the object, an empty one when missing
+func unstructuredMap(object map[string]interface{}, field string) map[string]interface{} {
+	if value, ok := object[field].(map[string]interface{}); ok {
+		return value
+	}
+	return map[string]interface{}{}
+}
+
+// SetupWithManager sets up the controller with the Manager, the IsovalentEgressGatewayPolicy
+// CRD must be installed.
+func (r *IsovalentEgressGatewayPolicyController) SetupWithManager(mgr ctrl.Manager) error {
+	generated := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetLabels()[haegressip.HAEgressGatewayPolicyName] != ""
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("isovalentegressgatewaypolicy").
+		For(&ciliumv2.CiliumEgressGatewayPolicy{}, builder.WithPredicates(generated)).
+		// The mirror has the name of the CiliumEgressGatewayPolicy, its changes are corrected
+		Watches(isovalent.New(), &handler.EnqueueRequestForObject{}, builder.WithPredicates(generated)).
+		// The target-kind annotation of a policy is applied to its CiliumEgressGatewayPolicy
+		Watches(&haegressv2.HAEgressGatewayPolicy{},
+			handler.EnqueueRequestsFromMapFunc(r.ciliumEgressGatewayPoliciesForPolicy)).
+		WithOptions(controllerOptions(r.Sharder, 1)).
+		Complete(r)
+}
+
+// ciliumEgressGatewayPoliciesForPolicy returns the CiliumEgressGatewayPolicies generated
+// from the policy
+func (r *IsovalentEgressGatewayPolicyController) ciliumEgressGatewayPoliciesForPolicy(ctx context.Context, object client.Object) []ctrl.Request {
+	var cegps ciliumv2.CiliumEgressGatewayPolicyList
+	if err := r.List(ctx, &cegps, client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: object.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list the CiliumEgressGatewayPolicies of the policy", "HAEgressGatewayPolicy", object.GetName())
+		return nil
+	}
+	requests := make([]ctrl.Request, 0, len(cegps.Items))
+	for _, cegp := range cegps.Items {
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&cegp)})
+	}
+	return requests
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/disruption"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// With the Local traffic policy, kube-vip runs the per-Service leader election only on the
+// nodes with an endpoint of the Service. The Services of the kube-vip policies have no
+// selector, and their Endpoints list the eligible nodes of the policy: the Ready nodes
+// matching its nodeSelector and not drained, only the preferred exit node when it is one
+// of them. The VIP is never elected elsewhere, and leaves the nodes drained or not
+// preferred anymore.
+
+// KubeVIPAffinityController writes the Endpoints of the Services of the kube-vip policies
+// with the node affinity enabled
+type KubeVIPAffinityController struct {
+	client.Client
+	// Reader reads the Endpoints, not cached: the ones written by the endpoints controller
+	// before the selector was removed are adopted
+	Reader          client.Reader
+	Log             logr.Logger
+	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
+	Providers       *provider.Registry
+	EgressNamespace string
+	// Budget limits the moves away from the nodes still Ready, nil for no limit
+	Budget *disruption.Budget
+	// Sharder, in sharding mode, selects the Services of the policies owned by the replica
+	Sharder *shard.Sharder
+}
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=endpoints,verbs=get;create;update
+
+func (r *KubeVIPAffinityController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	service := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, service); err != nil {
+		// The Endpoints are deleted with the Service, their owner
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	policyName := service.Labels[haegressip.HAEgressGatewayPolicyName]
+	if policyName == "" || !r.Sharder.Owns(policyName, service.Labels) || !service.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	// The endpoints controller writes the Endpoints until the selector is removed
+	if service.Spec.Selector != nil {
+		return ctrl.Result{}, nil
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: policyName}, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if haegressiputil.IsPaused(policy) || !metav1.IsControlledBy(service, policy) {
+		return ctrl.Result{}, nil
+	}
+	vipProvider, err := r.Providers.ForPolicy(policy)
+	if err != nil {
+		return ctrl.Result{}, nil
+	}
+	if kubeVIP, ok := vipProvider.(*provider.KubeVIP); !ok || !kubeVIP.NodeAffinity {
+		return ctrl.Result{}, nil
+	}
+	logger := r.Log.WithValues("namespace", service.Namespace, "service", service.Name)
+
+	nodes, deferred, err := r.eligibleNodes(ctx, policy)
+	if err != nil {
+		logger.Error(err, "unable to list the eligible nodes of the HAEgressGatewayPolicy")
+		return ctrl.Result{}, err
+	}
+	// Without endpoints the VIP would not be announced at all
+	if len(nodes) == 0 {
+		logger.Info("No eligible node for the kube-vip election, the Endpoints are left as they are", "HAEgressGatewayPolicy", policy.Name)
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventNoEligibleNodeReason,
+			fmt.Sprintf("No Ready node matches the nodeSelector of the policy, the kube-vip election of the Service %s/%s is not restricted again", service.Namespace, service.Name))
+		return ctrl.Result{}, nil
+	}
+
+	// A move deferred by the disruption budget is retried later
+	result := ctrl.Result{}
+	if deferred {
+		result.RequeueAfter = haegressip.HAEgressGatewayPolicyChcekRequeueAfter
+	}
+
+	desired := endpointsSubsets(nodes)
+	endpoints := &corev1.Endpoints{}
+	err = r.Reader.Get(ctx, req.NamespacedName, endpoints)
+	if apierrors.IsNotFound(err) {
+		endpoints = &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace},
+		}
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	if metav1.IsControlledBy(endpoints, service) && equality.Semantic.DeepEqual(endpoints.Subsets, desired) {
+		return result, nil
+	}
+
+	endpoints.Subsets = desired
+	if endpoints.Labels == nil {
+		endpoints.Labels = make(map[string]string)
+	}
+	endpoints.Labels[haegressip.HAEgressGatewayPolicyNamespace] = service.Labels[haegressip.HAEgressGatewayPolicyNamespace]
+	endpoints.Labels[haegressip.HAEgressGatewayPolicyName] = policyName
+	if err := controllerutil.SetControllerReference(service, endpoints, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if endpoints.ResourceVersion == "" {
+		err = r.Create(ctx, endpoints)
+	} else {
+		err = r.Update(ctx, endpoints)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	logger.Info("kube-vip election restricted to the eligible nodes", "nodes", names)
+	return result, nil
+}
+
+// eligibleNodes returns the nodes where kube-vip can elect the VIP of the policy, in name
+// order. The current exit node is kept, and deferred is true, while the disruption budget
+// defers its move.
+func (r *KubeVIPAffinityController) eligibleNodes(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (eligible []corev1.Node, deferred bool, err error) {
+	selector, err := haegressiputil.PolicyNodeSelector(policy)
+	if err != nil {
+		return nil, false, err
+	}
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, false, err
+	}
+	// The node excluded by a failover drill is handled as a drained node
+	avoided := policy.Annotations[haegressip.DrillAvoidNodeAnnotation]
+	ready := []corev1.Node{}
+	for _, node := range nodes.Items {
+		if !haegressiputil.IsNodeReady(&node) || nodeInternalIP(&node) == "" {
+			continue
+		}
+		ready = append(ready, node)
+		if !haegressiputil.IsNodeDrained(&node) && (avoided == "" || node.Labels[haegressip.NodeNameAnnotation] != avoided) {
+			eligible = append(eligible, node)
+		}
+	}
+	if preferred := policy.Annotations[haegressip.PreferredExitNodeAnnotation]; preferred != "" {
+		for _, node := range eligible {
+			if node.Labels[haegressip.NodeNameAnnotation] == preferred {
+				eligible = []corev1.Node{node}
+				break
+			}
+		}
+	}
+
+	// Moving the VIP away from a node still Ready is voluntary
+	current := policy.Status.ExitNode
+	for _, node := range eligible {
+		if node.Labels[haegressip.NodeNameAnnotation] == current {
+			current = ""
+		}
+	}
+	for _, node := range ready {
+		if current != "" && node.Labels[haegressip.NodeNameAnnotation] == current && !r.Budget.Allow(policy) {
+			r.Log.Info("Disruption budget exhausted, the kube-vip election is restricted later",
+				"HAEgressGatewayPolicy", policy.Name, "node", current, "group", disruption.Group(policy))
+			eligible = append(eligible, node)
+			deferred = true
+		}
+	}
+	sort.Slice(eligible, func(i, j int) bool {
+		return eligible[i].Name < eligible[j].Name
+	})
+	return eligible, deferred, nil
+}
+
+// endpointsSubsets returns the Endpoints subsets with an address on every node, on the
+// port of the Service
+func endpointsSubsets(nodes []corev1.Node) []corev1.EndpointSubset {
+	subset := corev1.EndpointSubset{
+		Ports: []corev1.EndpointPort{{Name: "nope", Port: 65534, Protocol: corev1.ProtocolTCP}},
+	}
+	for _, node := range nodes {
+		nodeName := node.Name
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: nodeInternalIP(&node), NodeName: &nodeName})
+	}
+	return []corev1.EndpointSubset{subset}
+}
+
+// nodeInternalIP returns the first internal IP of the node
+func nodeInternalIP(node *corev1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// servicesForAffinityNode returns every Service of the policies by priority, the node can
+// be eligible for any of them
+func (r *KubeVIPAffinityController) servicesForAffinityNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		r.Log.Error(err, "unable to list the Services of the Node", "Node", obj.GetName())
+		return nil
+	}
+	// Without the policies the Services are queued in the order of the list
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		r.Log.V(1).Info("Unable to list the policies to order the Services of the Node", "Node", obj.GetName(), "error", err.Error())
+	}
+	byName := make(map[string]*haegressv2.HAEgressGatewayPolicy, len(policies.Items))
+	for i := range policies.Items {
+		byName[policies.Items[i].Name] = &policies.Items[i]
+	}
+	sort.SliceStable(services.Items, func(i, j int) bool {
+		a, b := byName[services.Items[i].Labels[haegressip.HAEgressGatewayPolicyName]], byName[services.Items[j].Labels[haegressip.HAEgressGatewayPolicyName]]
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return haegressiputil.HigherPriority(a, b)
+	})
+	requests := make([]reconcile.Request, 0, len(services.Items))
+	for _, service := range services.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&service)})
+	}
+	return requests
+}
+
+// serviceForPolicy returns the Service of the policy
+func (r *KubeVIPAffinityController) serviceForPolicy(_ context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetName(), Namespace: haegressiputil.ServiceNamespace(obj, r.EgressNamespace)}}}
+}
+
+// nodeEligibilityChanged filters the Node events that can change the eligible nodes
+var nodeEligibilityChanged = predicate.Funcs{
+	CreateFunc:  func(e event.CreateEvent) bool { return true },
+	DeleteFunc:  func(e event.DeleteEvent) bool { return true },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		newNode, ok := e.ObjectNew.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		return haegressiputil.IsNodeReady(oldNode) != haegressiputil.IsNodeReady(newNode) ||
+			!reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+			nodeInternalIP(oldNode) != nodeInternalIP(newNode)
+	},
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KubeVIPAffinityController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("kubevip-affinity").
+		For(&corev1.Service{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetLabels()[haegressip.HAEgressGatewayPolicyName] != ""
+		}))).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.servicesForAffinityNode),
+			builder.WithPredicates(nodeEligibilityChanged),
+		).
+		Watches(
+			&haegressv2.HAEgressGatewayPolicy{},
+			handler.EnqueueRequestsFromMapFunc(r.serviceForPolicy),
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})),
+		).
+		WithOptions(controllerOptions(r.Sharder, 1)).
+		Complete(r)
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// recordNamespaceDefaults records in the status of the policy the defaults of its Service
+// namespace it uses, read in place of its own values when the desired state is computed.
+// The policy spec and annotations are never written. Invalid defaults are reported with an
+// event and the ones recorded are kept.
+func (r *HAEgressGatewayPolicyReconciler) recordNamespaceDefaults(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	used := map[string]string{}
+	if r.NamespaceDefaults {
+		namespace := &corev1.Namespace{}
+		err := r.Get(ctx, client.ObjectKey{Name: r.serviceNamespace(haEgressGatewayPolicy)}, namespace)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			defaults, err := haegressiputil.NamespaceDefaults(namespace)
+			if err != nil {
+				ctrl.LoggerFrom(ctx).Info("Invalid namespace defaults of HAEgressGatewayPolicy, skipping them", "error", err.Error())
+				r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason, err.Error())
+				return nil
+			}
+			used = haegressiputil.UsedNamespaceDefaults(haEgressGatewayPolicy, defaults)
+		}
+	}
+	if reflect.DeepEqual(used, haEgressGatewayPolicy.Status.NamespaceDefaults) ||
+		(len(used) == 0 && len(haEgressGatewayPolicy.Status.NamespaceDefaults) == 0) {
+		return nil
+	}
+
+	// The defaults no longer used are removed from the merge patch
+	namespaceDefaults := map[string]interface{}{}
+	for key := range haEgressGatewayPolicy.Status.NamespaceDefaults {
+		namespaceDefaults[key] = nil
+	}
+	for key, value := range used {
+		namespaceDefaults[key] = value
+	}
+	data, err := json.Marshal(map[string]interface{}{"status": map[string]interface{}{"namespaceDefaults": namespaceDefaults}})
+	if err != nil {
+		return err
+	}
+	if err := r.Status().Patch(ctx, haEgressGatewayPolicy, client.RawPatch(types.MergePatchType, data)); err != nil {
+		return err
+	}
+	ctrl.LoggerFrom(ctx).Info("Namespace defaults of HAEgressGatewayPolicy changed",
+		"namespace", r.serviceNamespace(haEgressGatewayPolicy), "defaults", used)
+	return nil
+}
+
+// namespaceDefaultsChanged filters the Namespaces whose defaults of the policies changed
+var namespaceDefaultsChanged = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return hasNamespaceDefaults(e.Object)
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		for key, value := range e.ObjectOld.GetAnnotations() {
+			if haegressiputil.IsNamespaceDefault(key) && e.ObjectNew.GetAnnotations()[key] != value {
+				return true
+			}
+		}
+		for key, value := range e.ObjectNew.GetAnnotations() {
+			if haegressiputil.IsNamespaceDefault(key) && e.ObjectOld.GetAnnotations()[key] != value {
+				return true
+			}
+		}
+		return false
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return false
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+func hasNamespaceDefaults(object client.Object) bool {
+	for key := range object.GetAnnotations() {
+		if haegressiputil.IsNamespaceDefault(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesForNamespace returns the policies whose Service is placed in the namespace
+func (r *HAEgressGatewayPolicyReconciler) policiesForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to list the policies of the namespace", "namespace", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range policies.Items {
+		if r.serviceNamespace(&policies.Items[i]) == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestRecordNamespaceDefaults(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "egress-tenant-a", Annotations: map[string]string{
+		haegressip.DefaultProviderAnnotation:      "metallb",
+		haegressip.DefaultIPAMAnnotation:          "infoblox",
+		haegressip.DefaultEligibleNodesAnnotation: "node-role.kubernetes.io/egress=tenant-a",
+	}}}
+	policy := &haegressv2.HAEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "egress-web", Annotations: map[string]string{
+			haegressip.HAEgressGatewayPolicyNamespace: "egress-tenant-a",
+			haegressip.IPAMAnnotation:                 "netbox",
+		}},
+	}
+	policy.Spec.EgressGateway = &ciliumv2.EgressGateway{NodeSelector: &slimv1.LabelSelector{}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace, policy).
+		WithStatusSubresource(&haegressv2.HAEgressGatewayPolicy{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if _, ok := obj.(*haegressv2.HAEgressGatewayPolicy); ok {
+					t.Errorf("the policy was updated")
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if _, ok := obj.(*haegressv2.HAEgressGatewayPolicy); ok {
+					t.Errorf("the policy was patched")
+				}
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).Build()
+	r := &HAEgressGatewayPolicyReconciler{
+		Client:            c,
+		Recorder:          record.NewFakeRecorder(10),
+		EgressNamespace:   "egress-system",
+		NamespaceDefaults: true,
+	}
+
+	reconciled := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), reconciled); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.recordNamespaceDefaults(ctx, reconciled); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), stored); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		haegressip.ProviderAnnotation:   "metallb",
+		haegressv2.EligibleNodesDefault: "node-role.kubernetes.io/egress=tenant-a",
+	}
+	if !reflect.DeepEqual(stored.Status.NamespaceDefaults, expected) {
+		t.Errorf("the status records the defaults %v, expected %v", stored.Status.NamespaceDefaults, expected)
+	}
+	if !reflect.DeepEqual(stored.Annotations, policy.Annotations) || !reflect.DeepEqual(stored.Spec, policy.Spec) {
+		t.Errorf("the defaults were written into the policy: %v %+v", stored.Annotations, stored.Spec.EgressGateway)
+	}
+
+	// The defaults are read in place of the values the policy does not set
+	if provider := stored.Annotation(haegressip.ProviderAnnotation); provider != "metallb" {
+		t.Errorf("the provider is %q, expected the default of the namespace", provider)
+	}
+	if ipam := stored.Annotation(haegressip.IPAMAnnotation); ipam != "netbox" {
+		t.Errorf("the IPAM is %q, expected the one of the policy", ipam)
+	}
+	nodeSelector := stored.EgressNodeSelector()
+	if nodeSelector == nil || nodeSelector.MatchLabels["node-role.kubernetes.io/egress"] != "tenant-a" {
+		t.Errorf("the nodeSelector is %+v, expected the default eligible nodes", nodeSelector)
+	}
+
+	// The defaults removed from the namespace are removed from the status
+	delete(namespace.Annotations, haegressip.DefaultProviderAnnotation)
+	if err := c.Update(ctx, namespace); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.recordNamespaceDefaults(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Status.NamespaceDefaults[haegressip.ProviderAnnotation]; ok || len(stored.Status.NamespaceDefaults) != 1 {
+		t.Errorf("the status records the defaults %v after the removal of the provider", stored.Status.NamespaceDefaults)
+	}
+}
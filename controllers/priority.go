@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// The Services are reconciled by two controllers with their own queue and workers: the
+// failover controller gets only the events that can move an egress IP to another node,
+// the routine controller everything else, like the creations and the resyncs. During a
+// mass failover the patches of the CiliumEgressGatewayPolicies do not wait behind the
+// no-op reconciliations.
+
+// serviceFailoverUpdate returns true when the update of a Service can change its egress
+// IP or its exit node, reported by the providers in the annotations or in the status
+func serviceFailoverUpdate(e event.UpdateEvent) bool {
+	oldService, ok := e.ObjectOld.(*corev1.Service)
+	if !ok {
+		return false
+	}
+	newService, ok := e.ObjectNew.(*corev1.Service)
+	if !ok {
+		return false
+	}
+	return !reflect.DeepEqual(oldService.Annotations, newService.Annotations) ||
+		!reflect.DeepEqual(oldService.Status.LoadBalancer, newService.Status.LoadBalancer)
+}
+
+// routineServiceEvents filters the Service events left to the routine controller
+var routineServiceEvents = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !serviceFailoverUpdate(e)
+	},
+}
+
+// failoverServiceEvents filters the Service events handled by the failover controller
+var failoverServiceEvents = predicate.Funcs{
+	CreateFunc:  func(e event.CreateEvent) bool { return false },
+	DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc:  serviceFailoverUpdate,
+}
+
+// leaseHolderChanged filters the Lease updates moving the L2 announcement to another node
+var leaseHolderChanged = predicate.Funcs{
+	CreateFunc:  func(e event.CreateEvent) bool { return true },
+	DeleteFunc:  func(e event.DeleteEvent) bool { return true },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldLease, ok := e.ObjectOld.(*coordinationv1.Lease)
+		if !ok {
+			return false
+		}
+		newLease, ok := e.ObjectNew.(*coordinationv1.Lease)
+		if !ok {
+			return false
+		}
+		return !reflect.DeepEqual(oldLease.Spec.HolderIdentity, newLease.Spec.HolderIdentity)
+	},
+}
+
+// nodeReadinessChanged filters the Node updates changing its readiness
+var nodeReadinessChanged = predicate.Funcs{
+	CreateFunc:  func(e event.CreateEvent) bool { return false },
+	DeleteFunc:  func(e event.DeleteEvent) bool { return true },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		newNode, ok := e.ObjectNew.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		return haegressiputil.IsNodeReady(oldNode) != haegressiputil.IsNodeReady(newNode)
+	},
+}
+
+// servicesForLease returns the Service announced by the Cilium L2 announcement Lease, or
+// elected by the kube-vip per-Service leader election Lease, in the namespace of the Service
+func (r *ServicesController) servicesForLease(ctx context.Context, obj client.Object) []reconcile.Request {
+	if r.KubeVIPLeases && strings.HasPrefix(obj.GetName(), haegressip.KubeVIPServiceLeasePrefix) {
+		service := &corev1.Service{}
+		key := types.NamespacedName{Name: strings.TrimPrefix(obj.GetName(), haegressip.KubeVIPServiceLeasePrefix), Namespace: obj.GetNamespace()}
+		if err := r.Get(ctx, key, service); err != nil || service.Labels[haegressip.HAEgressGatewayPolicyName] == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: key}}
+	}
+	if obj.GetNamespace() != r.CiliumNamespace {
+		return nil
+	}
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to list the Services of the Lease", "Lease", obj.GetName())
+		return nil
+	}
+	for _, service := range services.Items {
+		if haegressip.CiliumL2AnnounceLeasePrefix+service.Namespace+"-"+service.Name == obj.GetName() {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: service.Name, Namespace: service.Namespace}}}
+		}
+	}
+	return nil
+}
+
+// servicesForNode returns the Services of the policies whose exit node is the Node, by
+// priority: the queue is FIFO, so the critical policies move first when the Node fails
+func (r *ServicesController) servicesForNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to list the HAEgressGatewayPolicies of the Node", "Node", obj.GetName())
+		return nil
+	}
+	haegressiputil.SortByPriority(policies.Items)
+	requests := []reconcile.Request{}
+	for _, policy := range policies.Items {
+		if policy.Status.ExitNode != obj.GetName() {
+			continue
+		}
+		serviceNamespace := haegressiputil.ServiceNamespace(&policy, r.EgressNamespace)
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: serviceNamespace}})
+	}
+	return requests
+}
+
+// keyLocks serializes the reconciliations of the same object by the two controllers
+type keyLocks struct {
+	locks sync.Map
+}
+
+// lock locks the key and returns the function unlocking it
+func (k *keyLocks) lock(key types.NamespacedName) func() {
+	value, _ := k.locks.LoadOrStore(key, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// protectionRecheckAfter is the period the Services kept by the protection are checked again
+const protectionRecheckAfter = time.Minute
+
+// ServiceProtectionController keeps the Services of the policies while their namespace is
+// deleted: the Services carry a finalizer, so the namespace stays Terminating and the
+// egress IPs are not released until the policy is deleted, or the namespace is annotated
+// to allow the deletion.
+type ServiceProtectionController struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// Protect, when false, removes the finalizer of every Service being deleted, so the
+	// finalizers set before disabling the protection don't block the deletions
+	Protect bool
+	// Sharder, in sharding mode, selects the Services of the policies owned by the replica
+	Sharder *shard.Sharder
+}
+
+func (r *ServiceProtectionController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	service := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, service); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if service.DeletionTimestamp.IsZero() || !controllerutil.ContainsFinalizer(service, haegressip.ServiceProtectionFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	policyName := service.Labels[haegressip.HAEgressGatewayPolicyName]
+	if !r.Sharder.Owns(policyName, service.Labels) {
+		return ctrl.Result{}, nil
+	}
+	logger := r.Log.WithValues("namespace", service.Namespace, "service", service.Name)
+
+	policy, keep, err := r.keepService(ctx, service)
+	if err != nil {
+		logger.Error(err, "unable to check the deletion of the Service")
+		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
+	}
+	if keep {
+		logger.Error(nil, "The namespace of the Service is being deleted, the Service and its egress IP are kept",
+			"HAEgressGatewayPolicy", policy.Name, "annotation", haegressip.AllowEgressDeletionAnnotation)
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, policy.Name, "namespace_terminating",
+			fmt.Errorf("namespace %s terminating", service.Namespace))
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventNamespaceTerminatingReason,
+			fmt.Sprintf("The namespace %s of the Service is being deleted: the Service and the egress IP %s are kept, "+
+				"delete the policy or annotate the namespace with %s=true to release them",
+				service.Namespace, policy.Status.IPAddress, haegressip.AllowEgressDeletionAnnotation))
+		return ctrl.Result{RequeueAfter: protectionRecheckAfter}, nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	controllerutil.RemoveFinalizer(service, haegressip.ServiceProtectionFinalizer)
+	if err := r.Patch(ctx, service, patch); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	logger.V(1).Info("Protection finalizer removed from the Service being deleted")
+	return ctrl.Result{}, nil
+}
+
+// keepService returns true, with the policy, when the Service must be kept: its namespace
+// is being deleted while the policy still exists
+func (r *ServiceProtectionController) keepService(ctx context.Context, service *corev1.Service) (*haegressv2.HAEgressGatewayPolicy, bool, error) {
+	if !r.Protect {
+		return nil, false, nil
+	}
+	// A Service deleted on its own is created again by the policy
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: service.Namespace}, namespace); err != nil {
+		return nil, false, client.IgnoreNotFound(err)
+	}
+	if namespace.DeletionTimestamp.IsZero() || namespace.Annotations[haegressip.AllowEgressDeletionAnnotation] == "true" {
+		return nil, false, nil
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+		return nil, false, client.IgnoreNotFound(err)
+	}
+	return policy, policy.DeletionTimestamp.IsZero(), nil
+}
+
+// servicesForNamespace returns the protected Services of a namespace being deleted, so
+// they are released as soon as the namespace is annotated
+func (r *ServiceProtectionController) servicesForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.InNamespace(obj.GetName()), client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		r.Log.Error(err, "unable to list the Services of the namespace", "namespace", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, service := range services.Items {
+		if isProtectedAndDeleted(&service) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&service)})
+		}
+	}
+	return requests
+}
+
+func isProtectedAndDeleted(obj client.Object) bool {
+	return !obj.GetDeletionTimestamp().IsZero() && controllerutil.ContainsFinalizer(obj, haegressip.ServiceProtectionFinalizer)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceProtectionController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("service-protection").
+		For(&corev1.Service{}, builder.WithPredicates(predicate.NewPredicateFuncs(isProtectedAndDeleted))).
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.servicesForNamespace),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				return !obj.GetDeletionTimestamp().IsZero()
+			})),
+		).
+		WithOptions(controllerOptions(r.Sharder, 1)).
+		Complete(r)
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// When the Service namespace of a policy changes, the Service and the
+// CiliumEgressGatewayPolicy, named after the namespace, are replaced: the previous
+// CiliumEgressGatewayPolicy is deleted before the new one is created, with the same egress
+// gateway, so the pods never match two policies, and the new Service requests the egress
+// IP of the previous one, deleted once the new one exists.
+
+// previousServices returns the Services of the policy left in another namespace
+func (r *HAEgressGatewayPolicyReconciler) previousServices(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, serviceNamespace string) ([]corev1.Service, error) {
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: haEgressGatewayPolicy.Name}); err != nil {
+		return nil, err
+	}
+	previous := []corev1.Service{}
+	for _, service := range services.Items {
+		if service.Namespace != serviceNamespace && metav1.IsControlledBy(&service, haEgressGatewayPolicy) {
+			previous = append(previous, service)
+		}
+	}
+	return previous, nil
+}
+
+// previousCiliumEgressGatewayPolicies returns the CiliumEgressGatewayPolicies of the policy
+// named after another namespace, the ones fanned out to the namespaces are left to their
+// controller
+func (r *HAEgressGatewayPolicyReconciler) previousCiliumEgressGatewayPolicies(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, name string) ([]ciliumv2.CiliumEgressGatewayPolicy, error) {
+	policies := &ciliumv2.CiliumEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	previous := []ciliumv2.CiliumEgressGatewayPolicy{}
+	for _, policy := range policies.Items {
+		if policy.Name != name && policy.Labels[haegressip.FanOutNamespaceLabel] == "" &&
+			metav1.IsControlledBy(&policy, haEgressGatewayPolicy) {
+			previous = append(previous, policy)
+		}
+	}
+	return previous, nil
+}
+
+// retireCiliumEgressGatewayPolicies deletes the CiliumEgressGatewayPolicies named after a
+// previous namespace. The egress gateway of the first one is copied to the desired policy,
+// so the traffic keeps its egress IP until the new Service is synced.
+func (r *HAEgressGatewayPolicyReconciler) retireCiliumEgressGatewayPolicies(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, desired *ciliumv2.CiliumEgressGatewayPolicy) error {
+	previous, err := r.previousCiliumEgressGatewayPolicies(ctx, haEgressGatewayPolicy, desired.Name)
+	if err != nil {
+		return err
+	}
+	for i, policy := range previous {
+		if i == 0 && policy.Spec.EgressGateway != nil {
+			desired.Spec.EgressGateway = policy.Spec.EgressGateway.DeepCopy()
+		}
+		if err := r.Delete(ctx, &previous[i]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		ctrl.LoggerFrom(ctx).Info("Deleted the CiliumEgressGatewayPolicy of the previous Service namespace",
+			"CiliumEgressGatewayPolicy", policy.Name, "replacement", desired.Name)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventRetargetedReason,
+			fmt.Sprintf("CiliumEgressGatewayPolicy %q replaced by %q", policy.Name, desired.Name))
+	}
+	return nil
+}
+
+// migratedEgressIP returns the egress IP of the previous Service, requested by the new
+// Service when it is created. With an external IPAM only the IP allocated to the policy is
+// moved, the provider IP is moved only when the policy does not request an IP on its own.
+func (r *HAEgressGatewayPolicyReconciler) migratedEgressIP(ctx context.Context, service *corev1.Service, previous []corev1.Service, vipProvider provider.Provider, allocator ipam.Allocator) (string, error) {
+	if len(previous) == 0 {
+		return "", nil
+	}
+	existing := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, existing)
+	if err == nil {
+		return "", nil
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	if allocator != nil {
+		return previous[0].Annotations[haegressip.IPAMAllocatedIPAnnotation], nil
+	}
+	if requestsIP(service, vipProvider) {
+		return "", nil
+	}
+	return vipProvider.EgressIP(ctx, &previous[0])
+}
+
+// deletePreviousServices deletes the Services left in the previous namespaces, once the
+// Service of the current namespace exists
+func (r *HAEgressGatewayPolicyReconciler) deletePreviousServices(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service, previous []corev1.Service) error {
+	for i := range previous {
+		if !previous[i].DeletionTimestamp.IsZero() {
+			continue
+		}
+		if err := r.Delete(ctx, &previous[i]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		ctrl.LoggerFrom(ctx).Info("Deleted the Service of the previous namespace",
+			"Service.Namespace", previous[i].Namespace, "Service.Name", previous[i].Name, "replacement", service.Namespace)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventRetargetedReason,
+			fmt.Sprintf("Service %s/%s replaced by %s/%s", previous[i].Namespace, previous[i].Name, service.Namespace, service.Name))
+	}
+	return nil
+}
+
+// updateRetargetStatus records the namespace of the Service in the status of the policy,
+// and the previous namespace while its Service still exists
+func (r *HAEgressGatewayPolicyReconciler) updateRetargetStatus(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, serviceNamespace string) error {
+	previous, err := r.previousServices(ctx, haEgressGatewayPolicy, serviceNamespace)
+	if err != nil {
+		return err
+	}
+	retargetedFrom := ""
+	if len(previous) > 0 {
+		retargetedFrom = previous[0].Namespace
+	}
+	if haEgressGatewayPolicy.Status.ServiceNamespace == serviceNamespace && haEgressGatewayPolicy.Status.RetargetedFrom == retargetedFrom {
+		return nil
+	}
+	if retargetedFrom != "" && haEgressGatewayPolicy.Status.RetargetedFrom != retargetedFrom {
+		ctrl.LoggerFrom(ctx).Info("Service namespace of HAEgressGatewayPolicy changed, moving its Service",
+			"from", retargetedFrom, "to", serviceNamespace)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeNormal, haegressip.EventRetargetedReason,
+			fmt.Sprintf("Moving the Service from the namespace %s to %s", retargetedFrom, serviceNamespace))
+	}
+	status := map[string]interface{}{"serviceNamespace": serviceNamespace, "retargetedFrom": nil}
+	if retargetedFrom != "" {
+		status["retargetedFrom"] = retargetedFrom
+	}
+	data, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	return r.Status().Patch(ctx, haEgressGatewayPolicy, client.RawPatch(types.MergePatchType, data))
+}
+
+// requestsIP returns true when the Service already requests an IP to the provider, like
+// with the static egress IP annotation of the policy
+func requestsIP(service *corev1.Service, vipProvider provider.Provider) bool {
+	requested := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	vipProvider.RequestIP(requested, "requested")
+	for key := range requested.Annotations {
+		if service.Annotations[key] != "" {
+			return true
+		}
+	}
+	return false
+}
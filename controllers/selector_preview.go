@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxPreviewNamespaces bounds the namespaces listed in the status of a policy
+const maxPreviewNamespaces = 20
+
+// SelectorPreviewOptions configures the preview of what the selectors of a policy match,
+// written in its status when its spec changes
+type SelectorPreviewOptions struct {
+	// Reader lists the metadata of the pods, which are not cached
+	Reader client.Reader
+	// CountPods counts the matched pods, it requires the permission to list them
+	CountPods bool
+	// SensitiveNamespaces are reported with a warning when their pods are selected
+	SensitiveNamespaces []string
+}
+
+// previewSelectors writes in the status the namespaces and the pods matched by the
+// selectors of the policy, once per generation, and warns when they match every
+// namespace or a sensitive one, so the selectors too broad are caught early
+func (r *HAEgressGatewayPolicyReconciler) previewSelectors(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	if r.SelectorPreview == nil {
+		return nil
+	}
+	if current := haEgressGatewayPolicy.Status.SelectorPreview; current != nil && current.ObservedGeneration == haEgressGatewayPolicy.Generation {
+		return nil
+	}
+	preview, err := mapping.PreviewSelectors(ctx, r.Client, r.SelectorPreview.Reader, haEgressGatewayPolicy, r.SelectorPreview.CountPods)
+	if err != nil {
+		return err
+	}
+
+	status := &haegressv2.SelectorPreview{
+		ObservedGeneration: haEgressGatewayPolicy.Generation,
+		Namespaces:         preview.Namespaces,
+		NamespaceCount:     int32(len(preview.Namespaces)),
+	}
+	if len(status.Namespaces) > maxPreviewNamespaces {
+		status.Namespaces = status.Namespaces[:maxPreviewNamespaces]
+	}
+	if preview.Pods >= 0 {
+		pods := int32(preview.Pods)
+		status.Pods = &pods
+	}
+	if preview.All {
+		status.Warnings = append(status.Warnings, "the selectors match the pods of every namespace")
+	}
+	for _, namespace := range r.SelectorPreview.SensitiveNamespaces {
+		for _, matched := range preview.Namespaces {
+			if matched == namespace {
+				status.Warnings = append(status.Warnings, fmt.Sprintf("the selectors match the pods of the namespace %s", namespace))
+				break
+			}
+		}
+	}
+	if len(status.Warnings) > 0 {
+		ctrl.LoggerFrom(ctx).Info("The selectors of HAEgressGatewayPolicy may be too broad", "warnings", status.Warnings)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventSelectorTooBroadReason,
+			fmt.Sprintf("The traffic of unexpected pods may leave through the exit node: %s", strings.Join(status.Warnings, "; ")))
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"status": map[string]interface{}{"selectorPreview": status}})
+	if err != nil {
+		return err
+	}
+	return r.Status().Patch(ctx, haEgressGatewayPolicy, client.RawPatch(types.MergePatchType, data))
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"fmt"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
 	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
 	"github.com/cilium/cilium/pkg/hubble/relay/defaults"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type ServicesController struct {
@@ -24,20 +31,44 @@ type ServicesController struct {
 	Recorder        record.EventRecorder
 	CiliumNamespace string
 	EgressNamespace string
+	SyncOptions     haegressiputil.SyncOptions
+	// KubeVIPLeases queues the Services when the holder of their kube-vip leader election
+	// Lease changes
+	KubeVIPLeases bool
+	// Sharder, in sharding mode, selects the Services of the policies owned by the replica
+	Sharder *shard.Sharder
+	// MaxConcurrentReconciles is the number of workers of each of the two controllers
+	MaxConcurrentReconciles int
+	// Recovery, when set, queues the Services to verify first in the failover controller
+	Recovery <-chan event.GenericEvent
+	locks    keyLocks
 }
 
 // Reconcile handles a reconciliation request for a Lease with the
 // cilium-haegress-operator annotation.
 // If the annotation is absent, then Reconcile will ignore the service.
 
-// +kubebuilder:rbac:groups=core,resources=leases,verbs=get;list;watch
-// +kubebuilder:rbac:groups=cilium.io,resources=ciliumegressgatewaypolicies,verbs=get;update;patch
-// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// The CiliumEgressGatewayPolicies are the only objects written cluster-wide, besides the
+// policies of the operator: the Services are written only in the egress namespaces,
+// egress-system is the default of --egress-default-namespace, and the events of the
+// cluster-scoped objects are recorded in the default namespace.
+
+// +kubebuilder:rbac:groups=cilium.io,resources=ciliumegressgatewaypolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cilium.io,resources=ciliumnodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",namespace=default,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch
 
 func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var service = corev1.Service{}
 	var log = r.Log
 
+	// The failover and the routine controllers reconcile the same Services
+	defer r.locks.lock(req.NamespacedName)()
+
 	if err := r.Get(ctx, req.NamespacedName, &service); err != nil {
 		if apierrors.IsNotFound(err) {
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
@@ -46,6 +77,7 @@ func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (c
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch the Service, check RBAC permissions")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, "", "fetch_service", err)
 		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
 	}
 
@@ -55,6 +87,11 @@ func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (c
 	if service.Labels[haegressip.HAEgressGatewayPolicyName] == "" || service.Labels[haegressip.HAEgressGatewayPolicyNamespace] == "" {
 		return ctrl.Result{}, nil
 	}
+	// The Service carries the labels of its policy, so it has the same shard
+	if !r.Sharder.Owns(service.Labels[haegressip.HAEgressGatewayPolicyName], service.Labels) {
+		return ctrl.Result{}, nil
+	}
+	haegressmetrics.Reconciled(haegressmetrics.ControllerServices, service.Labels[haegressip.HAEgressGatewayPolicyName])
 
 	// Update CiliumEgressGatewayPolicy with the LoadBalancerIP
 	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
@@ -62,22 +99,49 @@ func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (c
 		service.Namespace, service.Name)}, ciliumEgressGatewayPolicy)
 
 	if err != nil {
+		// The Service of a previous namespace is deleted after its CiliumEgressGatewayPolicy
+		if apierrors.IsNotFound(err) && !service.DeletionTimestamp.IsZero() {
+			return ctrl.Result{}, nil
+		}
 		if apierrors.IsNotFound(err) {
 			logger.Info(fmt.Sprintf("CiliumEgressGatewayPolicy %s-%s not found, we probably are waiting for automatic creation", service.Labels[haegressip.HAEgressGatewayPolicyNamespace], service.Labels[haegressip.HAEgressGatewayPolicyName]))
 			return ctrl.Result{RequeueAfter: defaults.HealthCheckInterval}, err
 		} else {
 			logger.Error(err, "unable to fetch the CiliumEgressGatewayPolicy, review RBAC permissions")
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, service.Labels[haegressip.HAEgressGatewayPolicyName], "fetch_cegp", err)
 			return ctrl.Result{}, err
 		}
 	}
 
-	return haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, service, *ciliumEgressGatewayPolicy)
+	return haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, r.SyncOptions, service, *ciliumEgressGatewayPolicy)
 
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the routine and the failover controllers with the Manager.
 func (r *ServicesController) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		Complete(r)
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(routineServiceEvents)).
+		WithOptions(controllerOptions(r.Sharder, r.MaxConcurrentReconciles)).
+		Complete(r); err != nil {
+		return err
+	}
+
+	failover := ctrl.NewControllerManagedBy(mgr).
+		Named("service-failover").
+		For(&corev1.Service{}, builder.WithPredicates(failoverServiceEvents)).
+		Watches(
+			&coordinationv1.Lease{},
+			handler.EnqueueRequestsFromMapFunc(r.servicesForLease),
+			builder.WithPredicates(leaseHolderChanged),
+		).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.servicesForNode),
+			builder.WithPredicates(nodeReadinessChanged),
+		).
+		WithOptions(controllerOptions(r.Sharder, r.MaxConcurrentReconciles))
+	if r.Recovery != nil {
+		failover = failover.WatchesRawSource(&source.Channel{Source: r.Recovery}, &handler.EnqueueRequestForObject{})
+	}
+	return failover.Complete(r)
 }
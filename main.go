@@ -17,26 +17,104 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	_ "embed"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	//log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	ciliumv1alpha1 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	"github.com/angeloxx/cilium-haegress-operator/controllers"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/allowlist"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/api"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/audit"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/batch"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/bindings"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/cloud"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/clustercidrs"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/clustermesh"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/coexistence"
+	haegressconfig "github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/consistency"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/crd"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/dampening"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/desired"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/disruption"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/drill"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/features"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/federation"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/feeds"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/fqdn"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/hubble"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/isovalent"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/loglevel"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/monitoring"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/nodestatus"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/orphans"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/plugin"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/preflight"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/publish"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/readonly"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/reclaim"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/recorder"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/routes"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/servicenow"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/snapshot"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/startup"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/statusz"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/stream"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/synchook"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/vipclaim"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/warmup"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/whatif"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
 	//+kubebuilder:scaffold:imports
 )
 
+// haEgressGatewayPolicyCRD is installed with --install-crds
+//
+//go:embed config/crd/bases/cilium.angeloxx.ch_haegressgatewaypolicies.yaml
+var haEgressGatewayPolicyCRD []byte
+
+// egressNodeStatusCRD is installed with --install-crds
+//
+//go:embed config/crd/bases/cilium.angeloxx.ch_egressnodestatuses.yaml
+var egressNodeStatusCRD []byte
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -52,6 +130,8 @@ func init() {
 	}
 
 	utilruntime.Must(ciliumv1alpha1.AddToScheme(scheme))
+	// The installed CRDs are compared with the types of the operator
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -61,23 +141,302 @@ func main() {
 	var probeAddr string
 	var haegressNamespace string
 	var loadBalancerClass string
+	var defaultProvider string
+	var ciliumLoadBalancerClass string
+	var metallbLoadBalancerClass string
 	var k8sClientQPS int
 	var k8sClientBurst int
 	var backgroundCheckerSeconds int
 	var leaderElectionNamespace string
+	var egressSubnetPrefixLength int
+	var ciliumNamespace string
+	var ciliumPreflightSeconds int
+	var clustermeshLocalOnly bool
+	var clustermeshConfigMap string
+	var clustermeshPublishSeconds int
+	var federationConfigMap string
+	var federationSeconds int
+	var notifyConfig string
+	var eventsGRPCBindAddress string
+	var eventsHistory int
+	var publishNATSURL string
+	var publishNATSSubject string
+	var publishNATSTokenFile string
+	var publishKafkaURL string
+	var publishKafkaTopic string
+	var publishKafkaTokenFile string
+	var mappingConfigMap string
+	var monitoringObjects string
+	var monitoringName string
+	var monitoringPodLabels string
+	var monitoringLabels string
+	var monitoringPort string
+	var syncHooksConfig string
+	var policyInfoMaxSeries int
+	var auditSyslogAddress string
+	var auditHTTPURL string
+	var auditHTTPTokenFile string
+	var auditHMACKeyFile string
+	var routesConfig string
+	var routesSyncSeconds int
+	var serviceNowURL string
+	var serviceNowUsername string
+	var serviceNowPasswordFile string
+	var serviceNowTable string
+	var serviceNowSyncSeconds int
+	var syncHooksSeconds int
+	var apiBindAddress string
+	var apiTokensFile string
+	var allowListBindAddress string
+	var allowListGroupLabel string
+	var allowListObjectGroupPrefix string
+	var mappingExportSeconds int
+	var hubbleRelayAddress string
+	var hubbleRelayCAFile string
+	var hubbleSampleSeconds int
+	var hubbleSampleFlows int
+	var awsRegion string
+	var azureSubscriptionID string
+	var azureResourceGroup string
+	var gcpProject string
+	var openstackNetworkID string
+	var pluginsConfig string
+	var exitNodeDiscovery string
+	var ipamName string
+	var ipamWebhookURL string
+	var ipamWebhookTokenFile string
+	var ipamPoolConfigMap string
+	var ipamPoolFile string
+	var netboxURL string
+	var netboxTokenFile string
+	var netboxPrefix string
+	var infobloxURL string
+	var infobloxUsername string
+	var infobloxPasswordFile string
+	var infobloxNetwork string
+	var infobloxNetworkView string
+	var eventBurst int
+	var eventAggregationSeconds int
+	var enableStatusz bool
+	var shards int
+	var shardLeaseSeconds int
+	var maxConcurrentReconciles int
+	var warmupSeconds int
+	var warmupInitialQPS float64
+	var listPageSize int64
+	var patchWorkers int
+	var watchNamespaces string
+	var excludeNamespaces string
+	var serviceNamespaces string
+	var allowedSourceNamespaces string
+	var deniedSourceNamespaces string
+	var selectorPreview bool
+	var selectorPreviewPods bool
+	var selectorWarningNamespaces string
+	var installCRDs bool
+	var readOnly bool
+	var crdTimeoutSeconds int
+	var crdCompatibility string
+	var configPath string
+	var configReloadSeconds int
+	var snapshotConfigMap string
+	var bindingsConfigMap string
+	var reclaimGraceSeconds int
+	var reclaimConfigMap string
+	var protectServices bool
+	var trackingLabels string
+	var serviceIPFamilyPolicy string
+	var serviceIPFamilies string
+	var trackingPassthrough bool
+	var fqdnDNSServer string
+	var fqdnMinTTLSeconds int
+	var fqdnMaxTTLSeconds int
+	var excludeClusterCIDRs bool
+	var clusterCIDRs string
+	var clusterCIDRsRefreshSeconds int
+	var targetKind string
+	var namespaceDefaults bool
+	var selectorlessServices bool
+	var eligibilityCheck bool
+	var destinationFeedsConfig string
+	var disruptionMaxMoves int
+	var disruptionWindowSeconds int
+	var patchMinIntervalSeconds int
+	var conflictReverts int
+	var conflictWindowSeconds int
+	var conflictBackoffSeconds int
+	var drainGraceSeconds int
+	var snapshotSeconds int
+	var consistencyCheckSeconds int
+	var consistencyGraceSeconds int
+	var consistencyAction string
+	var claimCheckSeconds int
+	var claimStaleSeconds int
+	var orphanCheckSeconds int
+	var recoveryTimeoutSeconds int
+	var drillIntervalMinutes int
+	var drillTimeoutSeconds int
+	var drillMaxPolicies int
+	var nodeStatusSeconds int
+	var cacheSyncSeconds int
+	var watchBackoffInitialSeconds int
+	var watchBackoffMaxSeconds int
+	var patchQPS float64
+	var logLevelConfigMap string
+	var serviceCacheSelector string
 
+	flag.Var(features.DefaultGates, "feature-gates", "A comma separated list of Feature=bool pairs enabling or disabling the features of the operator: "+features.DefaultGates.Known())
+	flag.StringVar(&configPath, haegressconfig.FlagName, "", "The YAML file setting the flags not set on the command line, by name without the dashes, reloaded when it changes, empty to use only the command line")
+	flag.IntVar(&configReloadSeconds, "config-reload-seconds", 10, "The time in seconds between two checks of the configuration file")
+	flag.BoolVar(&installCRDs, "install-crds", false, "Install or upgrade the HAEgressGatewayPolicy and EgressNodeStatus CRDs embedded in the operator before starting the controllers")
+	flag.BoolVar(&readOnly, "read-only", false, "Run with read-only permissions: the changes to the cluster, the clouds, the IPAMs, the routers and the external integrations are not applied but reported on /readonlyz of the metrics endpoint, in the metrics and in the logs")
+	flag.StringVar(&crdCompatibility, "crd-compatibility", crd.ActionDegrade, "The action taken when the installed HAEgressGatewayPolicy or CiliumEgressGatewayPolicy CRD does not serve the version or the fields used by the operator: degrade to log and export it, refuse to stop at startup and report not ready")
+	flag.IntVar(&crdTimeoutSeconds, "crd-established-timeout-seconds", 60, "The time in seconds to wait for the installed CRD to be established")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&haegressNamespace, "egress-default-namespace", "egress-system", "The namespace where the services will be created if no namespaces were specified")
-	flag.StringVar(&loadBalancerClass, "load-balancer-class", "kube-vip.io/kube-vip-class", "The LoadBalancer class to use for the services")
+	flag.StringVar(&loadBalancerClass, "load-balancer-class", "kube-vip.io/kube-vip-class", "The LoadBalancer class to use for the services managed by kube-vip")
+	flag.StringVar(&defaultProvider, "provider", provider.KubeVIPName, "The default provider that assigns the egress IPs, one of kube-vip, cilium-lbipam, metallb, static, aws, azure, gcp, openstack or a plugin, can be overridden per policy with the cilium.angeloxx.ch/provider annotation")
+	flag.StringVar(&ciliumLoadBalancerClass, "cilium-load-balancer-class", "io.cilium/l2-announcer", "The LoadBalancer class to use for the services managed by the Cilium LB IPAM")
+	flag.StringVar(&metallbLoadBalancerClass, "metallb-load-balancer-class", "", "The LoadBalancer class to use for the services managed by MetalLB, empty to use the default class")
 
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "The comma separated namespaces where the Services of the policies are watched and created, the operator needs only a Role in each of them, empty for every namespace")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", "", "The comma separated namespaces whose Services are never watched, the policies with the Service in them are skipped")
+	flag.StringVar(&serviceNamespaces, "service-namespaces", "", "The comma separated namespaces, besides --egress-default-namespace, where the Services of the policies can be created, so the operator needs the Service write permissions only in them, empty for every watched namespace")
+	flag.StringVar(&allowedSourceNamespaces, "allowed-source-namespaces", "", "The comma separated namespaces whose pods can be selected by the policies, the other pods are excluded from the generated CiliumEgressGatewayPolicies, empty for every namespace")
+	flag.StringVar(&deniedSourceNamespaces, "denied-source-namespaces", "", "The comma separated namespaces whose pods are never selected by the policies")
+	flag.BoolVar(&selectorPreview, "selector-preview", true, "Write in the status of the policies the namespaces matched by their selectors when their spec changes, with a SelectorTooBroad warning event when they match every namespace or one of --selector-warning-namespaces")
+	flag.BoolVar(&selectorPreviewPods, "selector-preview-pods", false, "Count the pods matched by the selectors in the preview, it requires the permission to list the pods of the cluster")
+	flag.StringVar(&selectorWarningNamespaces, "selector-warning-namespaces", "kube-system", "The comma separated namespaces whose pods selected by a policy are reported with a SelectorTooBroad warning event")
+	flag.StringVar(&serviceCacheSelector, "service-cache-selector", haegressip.HAEgressGatewayPolicyName, "The label selector of the Services cached by the operator, the Services created by the operator always match the default one, empty to cache every Service of the cluster")
+	flag.StringVar(&logLevelConfigMap, "log-level-configmap", "", "The name of the ConfigMap, in the default egress namespace, with the log verbosity of the single controllers, changed at runtime, empty to disable it")
+	flag.BoolVar(&enableStatusz, "statusz", true, "Serve the JSON dump of the in-memory view of the controllers on /statusz of the metrics endpoint")
+	flag.IntVar(&shards, "shards", 0, "The number of shards the policies are split into, every replica reconciles the policies of the shards it holds instead of waiting for the leader, 0 or 1 to disable sharding")
+	flag.IntVar(&shardLeaseSeconds, "shard-lease-seconds", 15, "The duration in seconds of the shard Leases, a shard of a failed replica is taken by the others after it expires")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of workers of every controller")
+	flag.IntVar(&warmupSeconds, "warmup-seconds", 30, "The time in seconds the write rate of the controllers is raised from --warmup-initial-qps to --k8s-client-qps after the leader election, zero to disable the warm-up")
+	flag.Float64Var(&warmupInitialQPS, "warmup-initial-qps", 2, "The maximum QPS of the writes of the controllers when the warm-up starts")
+	flag.IntVar(&patchWorkers, "patch-workers", batch.DefaultWorkers, "The number of workers applying in bulk the patches of the CiliumEgressGatewayPolicies and of the policy statuses, zero to apply them in the reconciliations")
+	flag.Float64Var(&patchQPS, "patch-qps", batch.DefaultQPS, "The maximum number of patches per second applied by the patch workers")
+	flag.IntVar(&cacheSyncSeconds, "cache-sync-period-seconds", 0, "The time in seconds between two resyncs of the cache, every resync reconciles every cached object again, zero for the controller-runtime default of 10 hours")
+	flag.IntVar(&watchBackoffInitialSeconds, "watch-backoff-initial-seconds", 0, "The delay in seconds added to the client-go backoff before restarting a failed watch, doubled at every consecutive failure, zero to use only the client-go backoff")
+	flag.IntVar(&watchBackoffMaxSeconds, "watch-backoff-max-seconds", 300, "The maximum delay in seconds added before restarting a failed watch")
+	flag.Int64Var(&listPageSize, "list-page-size", haegressiputil.DefaultListPageSize, "The number of objects read in every page by the full lists of the background checker")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.IntVar(&k8sClientQPS, "k8s-client-qps", 20, "The maximum QPS to the Kubernetes API server")
 	flag.IntVar(&k8sClientBurst, "k8s-client-burst", 100, "The maximum burst for throttle to the Kubernetes API server")
 	flag.IntVar(&backgroundCheckerSeconds, "background-checker-seconds", 60, "The time in seconds to check all the HAEgressGatewayPolicies in the background, zero to disable it")
+	flag.IntVar(&consistencyCheckSeconds, "consistency-check-seconds", 60, "The time in seconds between two checks of the consistency of the Services and the CiliumEgressGatewayPolicies, zero to disable it")
+	flag.IntVar(&consistencyGraceSeconds, "consistency-grace-seconds", 60, "The time in seconds an inconsistent state must last before it is alerted and handled, and between two actions while it lasts")
+	flag.StringVar(&consistencyAction, "consistency-action", consistency.ActionAlert, "The action taken on the inconsistent states besides the alert: alert, clear to remove the egressIP without a load balancer IP, reallocate to delete the Services without IP or exit node, both resync the Services out of sync")
+	flag.IntVar(&claimCheckSeconds, "kubevip-claim-check-seconds", 15, "The time in seconds between two reads of the Leases of the kube-vip per-Service leader election, whose age is exported with the KubeVIPLeaseFastPath feature gate, zero to disable it")
+	flag.IntVar(&orphanCheckSeconds, "orphan-node-check-seconds", 60, "The time in seconds between two scans of the CiliumEgressGatewayPolicies selecting deleted nodes, zero to disable it")
+	flag.IntVar(&recoveryTimeoutSeconds, "startup-recovery-timeout-seconds", 300, "The maximum time in seconds the replica serving the controllers reports not ready while it reconciles every policy a first time, zero to report ready once the caches are synced")
+	flag.IntVar(&claimStaleSeconds, "kubevip-claim-stale-seconds", 30, "The age in seconds of the Lease of the kube-vip per-Service leader election beyond which the policy gets the Stale condition")
 	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "The namespace where the leader election lease will be created, if empty it will try to find the namespace from the environment")
+	flag.IntVar(&egressSubnetPrefixLength, "egress-subnet-prefix-length", 0, "The prefix length used to derive the node subnets from the CiliumNode addresses when validating the egress IP, zero to use only the subnets reported by the cloud IPAM")
+	flag.StringVar(&ciliumNamespace, "cilium-namespace", "kube-system", "The namespace where Cilium is installed")
+	flag.IntVar(&ciliumPreflightSeconds, "cilium-preflight-seconds", 300, "The time in seconds between two checks of the Cilium configuration, zero to check only at startup")
+	flag.BoolVar(&clustermeshLocalOnly, "clustermesh-local-only", false, "Restrict the generated CiliumEgressGatewayPolicies to the endpoints of the local cluster when ClusterMesh is enabled")
+	flag.StringVar(&clustermeshConfigMap, "clustermesh-configmap", "", "The name of the ConfigMap, in the default egress namespace, where the egress IP mappings of this cluster are published for the peer clusters, empty to disable it")
+	flag.IntVar(&clustermeshPublishSeconds, "clustermesh-publish-seconds", 30, "The time in seconds between two updates of the ClusterMesh ConfigMap")
+	flag.StringVar(&federationConfigMap, "federation-configmap", "", "The name of the ConfigMap, in the default egress namespace, where the status of the member clusters is aggregated, enables the federation of the clusters whose kubeconfig Secret is labelled with "+federation.ClusterLabel+", empty to disable it")
+	flag.IntVar(&federationSeconds, "federation-seconds", 30, "The time in seconds between two checks of the member clusters and two updates of the federation ConfigMap")
+	flag.StringVar(&apiBindAddress, "api-bind-address", "", "The address the read-only egress assignments API binds to, empty to disable it")
+	flag.StringVar(&apiTokensFile, "api-tokens-file", "", "The file containing the bearer tokens accepted by the egress assignments API, one per line")
+	flag.StringVar(&allowListBindAddress, "allowlist-bind-address", "", "The address the egress IP allow-lists, in CSV, Cisco object-group and Palo Alto EDL formats, bind to, empty to disable them")
+	flag.StringVar(&allowListGroupLabel, "allowlist-group-label", haegressip.AllowListGroupLabel, "The label of the policies whose value groups their egress IPs in the allow-lists, the policies without it are in the "+allowlist.DefaultGroup+" group")
+	flag.StringVar(&allowListObjectGroupPrefix, "allowlist-object-group-prefix", "haegress-", "The prefix of the names of the Cisco object-groups of the allow-lists")
+	flag.StringVar(&mappingConfigMap, "mapping-configmap", "", "The name of the ConfigMap, in the default egress namespace, where the mapping of every policy to its egress IP, exit node and namespaces is exported, empty to disable it")
+	flag.StringVar(&monitoringObjects, "monitoring-objects", "", "The comma separated Prometheus Operator objects created in the default egress namespace: ServiceMonitor or PodMonitor, and PrometheusRule with the default alerts, empty to disable them")
+	flag.StringVar(&monitoringName, "monitoring-name", haegressip.FieldManager, "The name of the Prometheus Operator objects")
+	flag.StringVar(&monitoringPodLabels, "monitoring-pod-labels", "", "The comma separated labels, like key=value, of the operator pods selected by the PodMonitor, or of the Service of its metrics selected by the ServiceMonitor")
+	flag.StringVar(&monitoringLabels, "monitoring-labels", "", "The comma separated labels, like key=value, of the Prometheus Operator objects, to match the selectors of the Prometheus instance")
+	flag.StringVar(&monitoringPort, "monitoring-port", "metrics", "The name of the metrics port of the operator pods, or of the Service of its metrics")
+	flag.StringVar(&snapshotConfigMap, "snapshot-configmap", "", "The name of the ConfigMap, in the default egress namespace, where the assignments of the policies are persisted to verify first the stale ones after a restart, empty to disable it")
+	flag.BoolVar(&protectServices, "protect-egress-services", true, "Add a finalizer to the Services of the policies, so a namespace deleted while hosting them stays Terminating and the egress IPs are kept until the policies are deleted or the namespace is annotated with "+haegressip.AllowEgressDeletionAnnotation+"=true")
+	flag.StringVar(&serviceIPFamilyPolicy, "service-ip-family-policy", "", "The ipFamilyPolicy of the Services of the policies, SingleStack, PreferDualStack or RequireDualStack, overridden by the "+haegressip.IPFamilyPolicyAnnotation+" annotation, empty for the default of the cluster")
+	flag.StringVar(&serviceIPFamilies, "service-ip-families", "", "The comma separated IP families of the Services of the policies, IPv4 and IPv6, primary first, overridden by the "+haegressip.IPFamiliesAnnotation+" annotation, empty for the default of the cluster")
+	flag.StringVar(&trackingLabels, "gitops-tracking-labels", "app.kubernetes.io/instance", "The comma separated labels used by the GitOps tools to track their objects, not copied from the policies on the generated objects, besides the Argo CD tracking annotation and the Flux labels")
+	flag.StringVar(&fqdnDNSServer, "fqdn-dns-server", "", "The DNS server, host or host:port, resolving the destinationFQDNs of the policies, empty for the first nameserver of /etc/resolv.conf")
+	flag.IntVar(&fqdnMinTTLSeconds, "fqdn-min-ttl-seconds", 30, "The minimum time in seconds the addresses of a destination FQDN are kept before resolving it again, whatever the TTL of its records")
+	flag.IntVar(&fqdnMaxTTLSeconds, "fqdn-max-ttl-seconds", 3600, "The maximum time in seconds the addresses of a destination FQDN are kept before resolving it again, whatever the TTL of its records")
+	flag.BoolVar(&excludeClusterCIDRs, "exclude-cluster-cidrs", false, "Add the pod, service and node CIDRs of the cluster, discovered from the CiliumNodes, the Nodes, the Cilium and the kubeadm configurations, to the excludedCIDRs of the generated CiliumEgressGatewayPolicies")
+	flag.StringVar(&clusterCIDRs, "cluster-cidrs", "", "The comma separated CIDRs added to the discovered cluster CIDRs with --exclude-cluster-cidrs, e.g. the service CIDR of a cluster not installed with kubeadm")
+	flag.IntVar(&clusterCIDRsRefreshSeconds, "cluster-cidrs-refresh-seconds", 300, "The time in seconds between two discoveries of the cluster CIDRs")
+	flag.BoolVar(&namespaceDefaults, "namespace-defaults", true, "Use the defaults in the "+haegressip.DefaultProviderAnnotation+", "+haegressip.DefaultIPAMAnnotation+", "+haegressip.DefaultLoadBalancerClassAnnotation+" and "+haegressip.DefaultEligibleNodesAnnotation+" annotations of the Service namespaces for the policies without their own values")
+	flag.BoolVar(&selectorlessServices, "selectorless-services", false, "Create the Services of the policies without selector, instead of the placeholder selector matching no pod, so no EndpointSlice is reconciled for them")
+	flag.BoolVar(&eligibilityCheck, "eligibility-check", true, "Count the nodes eligible as exit node of every policy, and set the NoEligibleNode condition on the policies without any")
+	flag.StringVar(&targetKind, "target-kind", isovalent.TargetCiliumEgressGatewayPolicy, "The kind of egress gateway policy generated for the policies without the "+haegressip.TargetKindAnnotation+" annotation: CiliumEgressGatewayPolicy, IsovalentEgressGatewayPolicy to mirror it into the Cilium Enterprise policy, or auto to mirror it when the IsovalentEgressGatewayPolicy CRD is installed")
+	flag.IntVar(&disruptionMaxMoves, "disruption-budget-max-moves", 0, "The maximum number of policies of a disruption group whose egress IP is moved away from a Ready node, drained or not preferred, within --disruption-budget-window-seconds, zero for no limit")
+	flag.IntVar(&drillIntervalMinutes, "drill-interval-minutes", 0, "The time in minutes between two failover drills of the policies with the cilium.angeloxx.ch/drill annotation, zero to disable the drills")
+	flag.IntVar(&drillTimeoutSeconds, "drill-timeout-seconds", 120, "The time in seconds a drilled policy has to converge on another exit node before the drill fails")
+	flag.IntVar(&drillMaxPolicies, "drill-max-policies", 1, "The maximum number of policies drilled, one at a time, every --drill-interval-minutes")
+	flag.IntVar(&nodeStatusSeconds, "node-status-seconds", 0, "The time in seconds between two refreshes of the EgressNodeStatus of every node hosting egress IPs, zero to disable them")
+	flag.IntVar(&disruptionWindowSeconds, "disruption-budget-window-seconds", 60, "The time in seconds a voluntary move of an egress IP counts against the disruption budget of its group")
+	flag.IntVar(&patchMinIntervalSeconds, "patch-min-interval-seconds", 0, "The minimum time in seconds between two patches of the nodeSelector or of the egressIP of the CiliumEgressGatewayPolicy of a policy, the failovers away from nodes not Ready are never delayed, zero for no limit")
+	flag.IntVar(&conflictReverts, "conflict-reverts", 3, "The number of reverts by another controller of the fields of a CiliumEgressGatewayPolicy written by the operator, within --conflict-window-seconds, after which the operator stops writing it for a backoff, zero to disable the guard")
+	flag.IntVar(&conflictWindowSeconds, "conflict-window-seconds", 300, "The time in seconds the reverts of a CiliumEgressGatewayPolicy are counted, and after which a conflict without reverts ends")
+	flag.IntVar(&conflictBackoffSeconds, "conflict-backoff-seconds", 60, "The time in seconds the operator stops writing a CiliumEgressGatewayPolicy reverted by another controller, doubled while the conflict goes on, up to one hour")
+	flag.IntVar(&drainGraceSeconds, "drain-grace-seconds", 0, "The time in seconds the CiliumEgressGatewayPolicies of a deleted policy stop selecting its pods before they are deleted with it, zero to leave their deletion to the garbage collector")
+	flag.StringVar(&destinationFeedsConfig, "destination-feeds-config", "", "The YAML file with the feeds of the IP ranges published by the providers, expanded from the destinationProviders of the policies, empty to disable them")
+	flag.BoolVar(&trackingPassthrough, "gitops-tracking-passthrough", false, "Copy the GitOps tracking labels and annotations of the policies on the generated objects, marked so that Argo CD shows them in the application without pruning them")
+	flag.StringVar(&bindingsConfigMap, "bindings-configmap", bindings.DefaultConfigMapName, "The name of the ConfigMap, in the default egress namespace, with the egress IPs restored from a backup by haegressctl restore, requested when the Service of a policy is created, empty to disable it")
+	flag.IntVar(&snapshotSeconds, "snapshot-seconds", 30, "The time in seconds between two updates of the assignment snapshot")
+	flag.IntVar(&mappingExportSeconds, "mapping-export-seconds", 10, "The time in seconds between two updates of the mapping ConfigMap")
+	flag.StringVar(&eventsGRPCBindAddress, "events-grpc-bind-address", "", "The address the gRPC stream of the egress change events binds to, empty to disable it")
+	flag.IntVar(&eventsHistory, "events-history", 1000, "The number of egress change events retained to resume the gRPC streams")
+	flag.StringVar(&publishNATSURL, "publish-nats-url", "", "The NATS server, nats://host:port or tls://host:port, where the egress change events are published as CloudEvents, empty to disable it")
+	flag.StringVar(&publishNATSSubject, "publish-nats-subject", "haegress.events", "The NATS subject of the published CloudEvents")
+	flag.StringVar(&publishNATSTokenFile, "publish-nats-token-file", "", "The file containing the token used to authenticate to NATS")
+	flag.StringVar(&publishKafkaURL, "publish-kafka-url", "", "The URL of the Kafka REST Proxy v2 bridge, a Strimzi Kafka Bridge or a Confluent REST Proxy, required to publish the egress change events as CloudEvents to Kafka: the events are posted to <url>/topics/<topic>, the Kafka protocol is not spoken directly. Empty to disable it")
+	flag.StringVar(&publishKafkaTopic, "publish-kafka-topic", "haegress-events", "The Kafka topic of the published CloudEvents")
+	flag.StringVar(&publishKafkaTokenFile, "publish-kafka-token-file", "", "The file containing the bearer token sent to the Kafka REST bridge")
+	flag.StringVar(&routesConfig, "routes-config", "", "The YAML file with the upstream routers programmed, with gNMI or NETCONF, with a static route for every egress IP toward its exit node, empty to disable it")
+	flag.IntVar(&routesSyncSeconds, "routes-sync-seconds", 30, "The time in seconds between two checks of the static routes of the upstream routers")
+	flag.StringVar(&auditSyslogAddress, "audit-syslog-address", "", "The syslog server, udp://, tcp:// or tls://host:port, receiving the RFC 5424 audit records of the egressIP and nodeSelector changes, empty to disable it")
+	flag.StringVar(&auditHTTPURL, "audit-http-url", "", "The HTTPS endpoint receiving the JSON audit records of the egressIP and nodeSelector changes, empty to disable it")
+	flag.StringVar(&auditHTTPTokenFile, "audit-http-token-file", "", "The file containing the bearer token sent to the audit endpoint")
+	flag.StringVar(&auditHMACKeyFile, "audit-hmac-key-file", "", "The file containing the key used to sign the audit records chain with HMAC-SHA-256, empty to use plain SHA-256")
+	flag.IntVar(&policyInfoMaxSeries, "policy-info-max-series", 5000, "The maximum number of policies exported in the haegress_policy_info and haegress_policy_status metrics, 0 to disable them")
+	flag.StringVar(&syncHooksConfig, "sync-hooks-config", "", "The YAML file with the hooks run when the mapping of the egress IPs to the source identities changes, empty to disable them")
+	flag.IntVar(&syncHooksSeconds, "sync-hooks-seconds", 10, "The time in seconds between two checks of the egress IP mappings for the sync hooks")
+	flag.StringVar(&serviceNowURL, "servicenow-url", "", "The ServiceNow instance URL where every egress IP is recorded as a CI, empty to disable it")
+	flag.StringVar(&serviceNowUsername, "servicenow-username", "", "The ServiceNow user")
+	flag.StringVar(&serviceNowPasswordFile, "servicenow-password-file", "", "The file containing the password of the ServiceNow user")
+	flag.StringVar(&serviceNowTable, "servicenow-table", "cmdb_ci_ip_address", "The ServiceNow table of the egress IP CIs")
+	flag.IntVar(&serviceNowSyncSeconds, "servicenow-sync-seconds", 300, "The time in seconds between two synchronizations of the ServiceNow CIs")
+	flag.StringVar(&notifyConfig, "notify-config", "", "The YAML file with the endpoints notified when an egress IP is assigned, moves to a new node or is degraded, empty to disable the notifications")
+	flag.StringVar(&hubbleRelayAddress, "hubble-relay-address", "", "The address of the Hubble Relay used to observe the egress traffic, empty to disable the observer")
+	flag.StringVar(&hubbleRelayCAFile, "hubble-relay-ca-file", "", "The CA certificate used to connect to Hubble Relay over TLS, empty to use a plain-text connection")
+	flag.IntVar(&hubbleSampleSeconds, "hubble-sample-seconds", 60, "The time in seconds between two samplings of the Hubble flows")
+	flag.StringVar(&awsRegion, "aws-region", os.Getenv("AWS_REGION"), "The AWS region of the cluster, enables the aws provider that moves a secondary private IP, and optionally an Elastic IP, between the ENIs of the nodes")
+	flag.StringVar(&azureSubscriptionID, "azure-subscription-id", "", "The Azure subscription of the nodes, enables the azure provider that moves a secondary IP configuration between the NICs of the nodes")
+	flag.StringVar(&azureResourceGroup, "azure-resource-group", "", "The Azure resource group of the node NICs, the node resource group on AKS")
+	flag.StringVar(&gcpProject, "gcp-project", "", "The GCP project of the nodes, enables the gcp provider that moves an alias IP between the instances of the nodes")
+	flag.StringVar(&openstackNetworkID, "openstack-network-id", "", "The Neutron network of the nodes used by the openstack provider, enabled when OS_AUTH_URL is set, empty to search the ports in every network")
+	flag.StringVar(&pluginsConfig, "plugins-config", "", "The YAML file with the out-of-tree VIP and IPAM plugins, selected like the built-in ones with the cilium.angeloxx.ch/provider and cilium.angeloxx.ch/ipam annotations, empty to disable them")
+	flag.StringVar(&exitNodeDiscovery, "exit-node-discovery", "", "The ordered chains of sources of the exit node of the load balancer providers, as provider=source,source separated by semicolons, e.g. kube-vip=lease,annotation,plugin:garp-agent; the sources are annotation and lease for kube-vip, lease for cilium-lbipam, status for metallb and plugin:<name> for a plugin answering the holder operation, empty for the built-in signal")
+	flag.StringVar(&ipamName, "ipam", "", "The default external IPAM used to allocate the egress IPs before requesting them to the provider, one of pool, webhook, netbox, infoblox or a plugin, can be overridden per policy with the cilium.angeloxx.ch/ipam annotation, empty to let the provider choose the IP")
+	flag.StringVar(&ipamWebhookURL, "ipam-webhook-url", "", "The base URL of the IPAM webhook, the operator calls <url>/allocate and <url>/release")
+	flag.StringVar(&ipamWebhookTokenFile, "ipam-webhook-token-file", "", "The file containing the bearer token sent to the IPAM webhook")
+	flag.StringVar(&ipamPoolConfigMap, "ipam-pool-configmap", "haegress-ip-pool", "The ConfigMap, in the default egress namespace, where the pool IPAM persists the allocations and, with the cidrs key, defines the CIDRs of the pool")
+	flag.StringVar(&ipamPoolFile, "ipam-pool-file", "", "The file containing the CIDRs of the pool IPAM, one per line, empty to read them from the ConfigMap")
+	flag.IntVar(&reclaimGraceSeconds, "ipam-reclaim-grace-seconds", 0, "The time in seconds the egress IP allocated from an IPAM to a deleted policy is held before it is released, so it is not reused at once by another policy, zero to release it with the policy")
+	flag.StringVar(&reclaimConfigMap, "ipam-reclaim-configmap", reclaim.DefaultConfigMapName, "The name of the ConfigMap, in the default egress namespace, with the egress IPs held for the deleted policies")
+	flag.StringVar(&netboxURL, "netbox-url", "", "The URL of the NetBox instance used by the netbox IPAM")
+	flag.StringVar(&netboxTokenFile, "netbox-token-file", "", "The file containing the NetBox API token")
+	flag.StringVar(&netboxPrefix, "netbox-prefix", "", "The NetBox prefix, in CIDR notation, the egress IPs are allocated from")
+	flag.StringVar(&infobloxURL, "infoblox-url", "", "The WAPI URL of the Infoblox grid master used by the infoblox IPAM, e.g. https://gm.example.com/wapi/v2.12")
+	flag.StringVar(&infobloxUsername, "infoblox-username", "", "The user used to authenticate to Infoblox")
+	flag.StringVar(&infobloxPasswordFile, "infoblox-password-file", "", "The file containing the password of the Infoblox user")
+	flag.StringVar(&infobloxNetwork, "infoblox-network", "", "The Infoblox network, in CIDR notation, the egress IPs are allocated from, can be overridden per policy with the cilium.angeloxx.ch/infoblox-network annotation")
+	flag.StringVar(&infobloxNetworkView, "infoblox-network-view", "default", "The Infoblox network view of the network")
+	flag.IntVar(&eventBurst, "event-burst", 25, "The maximum number of Kubernetes events with the same reason emitted in every aggregation window, the others are summarized in one event")
+	flag.IntVar(&eventAggregationSeconds, "event-aggregation-seconds", 10, "The time in seconds of the Kubernetes events aggregation window, zero to disable the aggregation")
+	flag.IntVar(&hubbleSampleFlows, "hubble-sample-flows", 100, "The maximum number of flows sampled for each HAEgressGatewayPolicy")
 
 	opts := zap.Options{
 		Development: false,
@@ -85,13 +444,53 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	// The flags not set on the command line are read from the environment, then from the
+	// configuration file. The logger is configured by them too, so their errors are
+	// logged later
+	configErr := haegressconfig.FromEnvironment(flag.CommandLine)
+	configFile := &haegressconfig.File{Path: configPath, FlagSet: flag.CommandLine, IntervalSeconds: configReloadSeconds}
+	if configErr == nil && configPath != "" {
+		configErr = configFile.Load()
+	}
+
+	// The zap logger enables every verbosity, the loggers are filtered by the levels that
+	// can be changed at runtime
+	logLevels := loglevel.NewLevels(loglevel.Verbosity(opts.Level))
+	opts.Level = zapcore.Level(-loglevel.MaxVerbosity)
+	ctrl.SetLogger(logr.New(loglevel.NewSink(zap.New(zap.UseFlagOptions(&opts)).GetSink(), logLevels)))
 
 	ctrl.Log.V(1).Info("Test debug")
+	if configErr != nil {
+		setupLog.Error(configErr, "unable to load the configuration")
+		os.Exit(1)
+	}
+	configFile.Log = ctrl.Log.WithName("config")
+
+	features.DefaultGates.Export()
+	setupLog.Info("Feature gates", "features", features.DefaultGates.Features())
+	// The flags of the disabled features are ignored
+	for feature, values := range map[features.Feature]map[string]*string{
+		features.CloudProviders:       {"aws-region": &awsRegion, "azure-subscription-id": &azureSubscriptionID, "gcp-project": &gcpProject},
+		features.IPAMWebhook:          {"ipam-webhook-url": &ipamWebhookURL},
+		features.DestinationProviders: {"destination-feeds-config": &destinationFeedsConfig},
+	} {
+		if features.Enabled(feature) {
+			continue
+		}
+		for name, value := range values {
+			if *value != "" {
+				setupLog.Info("Feature disabled, ignoring the flag", "feature", feature, "flag", name)
+				*value = ""
+			}
+		}
+	}
 
 	config := ctrl.GetConfigOrDie()
 	config.QPS = float32(k8sClientQPS)
 	config.Burst = k8sClientBurst
+	// The API server names the field manager of the writes after the user agent, a stable
+	// name lets the GitOps tools ignore the fields managed by the operator
+	config.UserAgent = haegressip.FieldManager
 
 	if leaderElectionNamespace == "" {
 		var err error
@@ -101,10 +500,146 @@ func main() {
 		}
 	}
 
-	mgr, err := ctrl.NewManager(config, ctrl.Options{
+	if err := crd.ValidAction(crdCompatibility); err != nil {
+		setupLog.Error(err, "invalid --crd-compatibility")
+		os.Exit(1)
+	}
+	if err := consistency.ValidAction(consistencyAction); err != nil {
+		setupLog.Error(err, "invalid --consistency-action")
+		os.Exit(1)
+	}
+	if err := isovalent.ValidTarget(targetKind); err != nil {
+		setupLog.Error(err, "invalid --target-kind")
+		os.Exit(1)
+	}
+
+	discoveryChains, err := provider.ParseDiscoveryChains(exitNodeDiscovery)
+	if err != nil {
+		setupLog.Error(err, "invalid --exit-node-discovery")
+		os.Exit(1)
+	}
+
+	for _, namespaces := range []string{allowedSourceNamespaces, deniedSourceNamespaces, watchNamespaces, serviceNamespaces, selectorWarningNamespaces} {
+		if err := sanitize.NamespaceNames(splitList(namespaces)); err != nil {
+			setupLog.Error(err, "invalid namespaces")
+			os.Exit(1)
+		}
+	}
+
+	var ipFamilyOptions haegressiputil.IPFamilyOptions
+	if serviceIPFamilyPolicy != "" {
+		if err := sanitize.IPFamilyPolicy(serviceIPFamilyPolicy); err != nil {
+			setupLog.Error(err, "invalid --service-ip-family-policy")
+			os.Exit(1)
+		}
+		familyPolicy := corev1.IPFamilyPolicy(serviceIPFamilyPolicy)
+		ipFamilyOptions.Policy = &familyPolicy
+	}
+	if serviceIPFamilies != "" {
+		if err := sanitize.IPFamilies(serviceIPFamilies); err != nil {
+			setupLog.Error(err, "invalid --service-ip-families")
+			os.Exit(1)
+		}
+		ipFamilyOptions.Families = haegressiputil.ParseIPFamilies(serviceIPFamilies)
+	}
+
+	// In read-only mode every write of the controllers, and of the components changing
+	// systems outside the cluster, is recorded in the report
+	var readOnlyReport *readonly.Report
+	var readOnlyRecord func(verb, kind, namespace, name string, data []byte)
+	if readOnly {
+		readOnlyReport = &readonly.Report{Log: ctrl.Log.WithName("readonly")}
+		readOnlyRecord = readOnlyReport.Record
+		setupLog.Info("Read-only mode, the changes are reported and not applied", "report", readonly.Path)
+		if installCRDs {
+			setupLog.Info("Read-only mode, the CRD is not installed")
+			installCRDs = false
+		}
+		if warmupSeconds > 0 {
+			setupLog.Info("Read-only mode, the warm-up is disabled")
+			warmupSeconds = 0
+		}
+		if shards > 1 {
+			// The shard Leases can't be written, the leader reconciles every policy
+			setupLog.Info("Read-only mode, the sharding is disabled")
+			shards = 0
+		}
+		// The audit records would describe changes never applied
+		for name, value := range map[string]*string{
+			"audit-syslog-address": &auditSyslogAddress,
+			"audit-http-url":       &auditHTTPURL,
+			"monitoring-objects":   &monitoringObjects,
+		} {
+			if *value != "" {
+				setupLog.Info("Read-only mode, the integration is disabled", "flag", name)
+				*value = ""
+			}
+		}
+	}
+
+	// The CRD matches the types of this operator, before any policy is decoded
+	if installCRDs {
+		installer, err := crd.NewInstaller(config, ctrl.Log.WithName("crd"), time.Duration(crdTimeoutSeconds)*time.Second)
+		if err == nil {
+			err = installer.Install(context.Background(), haEgressGatewayPolicyCRD, egressNodeStatusCRD)
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to install the CRD")
+			os.Exit(1)
+		}
+	}
+
+	serviceSelector, err := labels.Parse(serviceCacheSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid Service cache selector")
+		os.Exit(1)
+	}
+
+	// The statusz handler is completed once the controllers are set up
+	statuszHandler := &statusz.Handler{}
+	metricsExtraHandlers := map[string]http.Handler{}
+	if enableStatusz {
+		metricsExtraHandlers[statusz.Path] = statuszHandler
+	}
+	if readOnlyReport != nil {
+		metricsExtraHandlers[readonly.Path] = readOnlyReport
+	}
+
+	namespaceScope := haegressiputil.NamespaceScope{
+		Watch:   splitList(watchNamespaces),
+		Exclude: splitList(excludeNamespaces),
+	}
+	if serviceNamespaces != "" {
+		namespaceScope.Services = append(splitList(serviceNamespaces), haegressNamespace)
+	}
+	if !namespaceScope.Includes(haegressNamespace) {
+		setupLog.Info("The default egress namespace is not watched, only the policies with the Service in a watched namespace are reconciled",
+			"namespace", haegressNamespace)
+	}
+	// The kube-vip leader election Leases are in the namespaces of the Services
+	var leaseNamespaces []string
+	if features.Enabled(features.KubeVIPLeaseFastPath) {
+		switch {
+		case len(namespaceScope.Services) > 0:
+			leaseNamespaces = namespaceScope.Services
+		case len(namespaceScope.Watch) > 0:
+			leaseNamespaces = append([]string{haegressNamespace}, namespaceScope.Watch...)
+		default:
+			leaseNamespaces = []string{cache.AllNamespaces}
+		}
+	}
+	cacheOptions := haegressiputil.CacheOptions(serviceSelector, ciliumNamespace, leaseNamespaces, namespaceScope)
+	if cacheSyncSeconds > 0 {
+		syncPeriod := time.Duration(cacheSyncSeconds) * time.Second
+		cacheOptions.SyncPeriod = &syncPeriod
+	}
+
+	managerOptions := ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
 		Metrics: metricsserver.Options{
-			BindAddress: metricsAddr,
+			BindAddress:   metricsAddr,
+			ExtraHandlers: metricsExtraHandlers,
 		},
 		HealthProbeBindAddress:  probeAddr,
 		LeaderElection:          enableLeaderElection,
@@ -122,34 +657,1147 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	if readOnlyReport != nil {
+		managerOptions.NewClient = readOnlyReport.NewClient
+	}
+	mgr, err := ctrl.NewManager(config, managerOptions)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.HAEgressGatewayPolicyReconciler{
-		Client:                   mgr.GetClient(),
+	if watchBackoffInitialSeconds > 0 {
+		watchBackoff := &haegressiputil.WatchBackoff{
+			Log:     ctrl.Log.WithName("cache"),
+			Initial: time.Duration(watchBackoffInitialSeconds) * time.Second,
+			Max:     time.Duration(watchBackoffMaxSeconds) * time.Second,
+		}
+		if err = watchBackoff.SetupWithManager(mgr, &ciliumv1alpha1.HAEgressGatewayPolicy{}, &ciliumv2.CiliumEgressGatewayPolicy{},
+			&corev1.Service{}, &corev1.Node{}, &coordinationv1.Lease{}); err != nil {
+			setupLog.Error(err, "unable to set up the watch backoff")
+			os.Exit(1)
+		}
+	}
+
+	ciliumChecker := &preflight.Checker{
+		Reader:          mgr.GetAPIReader(),
+		Log:             ctrl.Log.WithName("preflight").WithName("Cilium"),
+		CiliumNamespace: ciliumNamespace,
+		IntervalSeconds: ciliumPreflightSeconds,
+	}
+	if err := ciliumChecker.Refresh(context.Background()); err != nil {
+		setupLog.Error(err, "Cilium preflight check failed, the policies requiring the missing features are not synced until it is fixed")
+	}
+	if err = ciliumChecker.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up Cilium preflight check")
+		os.Exit(1)
+	}
+
+	// A CRD older than the operator, or a Cilium upgrade changing the CiliumEgressGatewayPolicy
+	// CRD, silently drops the fields the operator writes
+	crdRequirements := []crd.Requirement{
+		{Name: preflight.HAEgressGatewayPolicyCRD, Version: ciliumv1alpha1.GroupVersion.Version, Manifest: haEgressGatewayPolicyCRD},
+		{Name: preflight.CiliumEgressGatewayPolicyCRD, Version: ciliumv2.SchemeGroupVersion.Version, Fields: crd.CiliumEgressGatewayPolicyFields},
+	}
+	if nodeStatusSeconds > 0 {
+		crdRequirements = append(crdRequirements, crd.Requirement{Name: preflight.EgressNodeStatusCRD, Version: ciliumv1alpha1.GroupVersion.Version, Manifest: egressNodeStatusCRD})
+	}
+	crdChecker := &crd.CompatibilityChecker{
+		Reader:          mgr.GetAPIReader(),
+		Log:             ctrl.Log.WithName("crd"),
+		Requirements:    crdRequirements,
+		Action:          crdCompatibility,
+		IntervalSeconds: ciliumPreflightSeconds,
+	}
+	if found, err := crdChecker.Refresh(context.Background()); err != nil {
+		setupLog.Error(err, "unable to check the compatibility of the CRDs")
+	} else if len(found) > 0 && crdCompatibility == crd.ActionRefuse {
+		setupLog.Error(crd.Error(found), "installed CRDs incompatible with the operator, upgrade them or run with --crd-compatibility=degrade")
+		os.Exit(1)
+	}
+	if err = crdChecker.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up the CRD compatibility check")
+		os.Exit(1)
+	}
+
+	// The IP families of the Services are checked against the ones of the cluster, unknown
+	// when the dry-run creation fails, or is not sent in read-only mode
+	if readOnlyReport != nil {
+		setupLog.Info("The IP families of the Services are not discovered in read-only mode")
+	} else if families, err := preflight.ServiceIPFamilies(context.Background(), mgr.GetClient(), haegressNamespace); err != nil {
+		setupLog.Error(err, "unable to discover the IP families of the Services, the IP families of the policies are not checked")
+	} else {
+		setupLog.Info("Discovered the IP families of the Services", "families", families)
+		ipFamilyOptions.Supported = families
+	}
+	if err := ipFamilyOptions.Check(ipFamilyOptions.Policy, ipFamilyOptions.Families); err != nil {
+		setupLog.Error(err, "invalid --service-ip-family-policy or --service-ip-families")
+		os.Exit(1)
+	}
+
+	kubeVIP := &provider.KubeVIP{LoadBalancerClass: loadBalancerClass, NodeAffinity: features.Enabled(features.KubeVIPNodeAffinity)}
+	if features.Enabled(features.KubeVIPLeaseFastPath) {
+		kubeVIP.Leases = mgr.GetClient()
+	}
+	vipProviders := []provider.Provider{
+		kubeVIP,
+		&provider.CiliumLBIPAM{Client: mgr.GetClient(), CiliumNamespace: ciliumNamespace, LoadBalancerClass: ciliumLoadBalancerClass},
+		&provider.MetalLB{Client: mgr.GetClient(), LoadBalancerClass: metallbLoadBalancerClass},
+		&provider.Static{},
+	}
+	// The voluntary moves of every cloud provider share the same budget
+	disruptionBudget := &disruption.Budget{MaxMoves: disruptionMaxMoves, Window: time.Duration(disruptionWindowSeconds) * time.Second}
+	// The cloud providers are available as soon as their location is configured
+	if awsRegion != "" {
+		vipProviders = append(vipProviders, &provider.Cloud{Client: mgr.GetClient(), Mover: &cloud.AWS{Region: awsRegion}, Budget: disruptionBudget})
+	}
+	if azureSubscriptionID != "" {
+		vipProviders = append(vipProviders, &provider.Cloud{Client: mgr.GetClient(), Mover: &cloud.Azure{
+			SubscriptionID: azureSubscriptionID,
+			ResourceGroup:  azureResourceGroup,
+		}, Budget: disruptionBudget})
+	}
+	if gcpProject != "" {
+		vipProviders = append(vipProviders, &provider.Cloud{Client: mgr.GetClient(), Mover: &cloud.GCP{Project: gcpProject}, Budget: disruptionBudget})
+	}
+	if os.Getenv("OS_AUTH_URL") != "" && features.Enabled(features.CloudProviders) {
+		vipProviders = append(vipProviders, &provider.Cloud{Client: mgr.GetClient(), Mover: &cloud.OpenStack{NetworkID: openstackNetworkID}, Budget: disruptionBudget})
+	}
+	// The plugins move the IPs like the clouds, the operator chooses the exit node
+	var plugins []*plugin.Client
+	if pluginsConfig != "" {
+		if plugins, err = plugin.LoadConfig(pluginsConfig); err != nil {
+			setupLog.Error(err, "unable to load the plugins configuration")
+			os.Exit(1)
+		}
+		if err = (&plugin.Monitor{
+			Clients: plugins,
+			Log:     ctrl.Log.WithName("plugin"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the health checks of the plugins")
+			os.Exit(1)
+		}
+	}
+	for _, pluginClient := range plugins {
+		if !pluginClient.Definition.Provider {
+			continue
+		}
+		for _, vipProvider := range vipProviders {
+			if vipProvider.Name() == pluginClient.Name() {
+				setupLog.Error(nil, "the name of the plugin is already used by a provider", "plugin", pluginClient.Name())
+				os.Exit(1)
+			}
+		}
+		vipProviders = append(vipProviders, &provider.Cloud{Client: mgr.GetClient(), Mover: &provider.PluginMover{Plugin: pluginClient}, Budget: disruptionBudget, ByHostname: true})
+		setupLog.Info("Loaded the provider plugin", "plugin", pluginClient.Name(), "configHash", pluginClient.ConfigHash())
+	}
+	if err = provider.ConfigureDiscovery(discoveryChains, vipProviders, provider.DiscoveryOptions{
+		Client:          mgr.GetClient(),
+		CiliumNamespace: ciliumNamespace,
+		Plugins:         plugins,
+		Log:             ctrl.Log.WithName("discovery"),
+	}); err != nil {
+		setupLog.Error(err, "invalid --exit-node-discovery")
+		os.Exit(1)
+	}
+	if readOnlyReport != nil {
+		for _, vipProvider := range vipProviders {
+			if cloudProvider, ok := vipProvider.(*provider.Cloud); ok {
+				cloudProvider.Mover = readOnlyReport.Mover(cloudProvider.Mover)
+			}
+		}
+	}
+	providers, err := provider.NewRegistry(defaultProvider, vipProviders...)
+	if err != nil {
+		setupLog.Error(err, "unable to set up the VIP providers")
+		os.Exit(1)
+	}
+
+	// An allocator is available as soon as its endpoint is configured, --ipam selects the default one
+	allocators := []ipam.Allocator{
+		&ipam.Pool{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Namespace:     haegressNamespace,
+			ConfigMapName: ipamPoolConfigMap,
+			File:          ipamPoolFile,
+		},
+	}
+	if ipamWebhookURL != "" {
+		allocators = append(allocators, &ipam.Webhook{
+			URL:     ipamWebhookURL,
+			Token:   readSecretFile(ipamWebhookTokenFile),
+			Timeout: 10 * time.Second,
+		})
+	}
+	if netboxURL != "" {
+		// The prefix is looked up as it is, a host address or a typo would fail every allocation
+		prefix, err := netip.ParsePrefix(netboxPrefix)
+		if err != nil {
+			setupLog.Error(err, "invalid --netbox-prefix, expected a CIDR")
+			os.Exit(1)
+		}
+		if prefix != prefix.Masked() {
+			setupLog.Error(nil, "invalid --netbox-prefix, the host bits must be zero", "prefix", netboxPrefix, "expected", prefix.Masked().String())
+			os.Exit(1)
+		}
+		allocators = append(allocators, &ipam.NetBox{
+			URL:     netboxURL,
+			Token:   readSecretFile(netboxTokenFile),
+			Prefix:  netboxPrefix,
+			Timeout: 10 * time.Second,
+		})
+	}
+	if infobloxURL != "" {
+		allocators = append(allocators, &ipam.Infoblox{
+			URL:         infobloxURL,
+			Username:    infobloxUsername,
+			Password:    readSecretFile(infobloxPasswordFile),
+			Network:     infobloxNetwork,
+			NetworkView: infobloxNetworkView,
+			Timeout:     10 * time.Second,
+		})
+	}
+	for _, pluginClient := range plugins {
+		if !pluginClient.Definition.IPAM {
+			continue
+		}
+		for _, allocator := range allocators {
+			if allocator.Name() == pluginClient.Name() {
+				setupLog.Error(nil, "the name of the plugin is already used by an IPAM", "plugin", pluginClient.Name())
+				os.Exit(1)
+			}
+		}
+		allocators = append(allocators, &ipam.Plugin{Plugin: pluginClient})
+		setupLog.Info("Loaded the IPAM plugin", "plugin", pluginClient.Name(), "configHash", pluginClient.ConfigHash())
+	}
+	// The pool writes only its ConfigMap, with the client of the Manager
+	if readOnlyReport != nil {
+		for i, allocator := range allocators[1:] {
+			allocators[i+1] = readOnlyReport.Allocator(allocator)
+		}
+	}
+	allocatorRegistry, err := ipam.NewRegistry(ipamName, allocators...)
+	if err != nil {
+		setupLog.Error(err, "unable to set up the external IPAM")
+		os.Exit(1)
+	}
+
+	var notifyTargets *notify.Config
+	if notifyConfig != "" {
+		notifyTargets, err = notify.LoadConfig(notifyConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load the notification configuration")
+			os.Exit(1)
+		}
+	}
+	notifySinks := []notify.Sink{}
+	if eventsGRPCBindAddress != "" {
+		// The start time tells apart the tokens of the previous leaders and of a restarted container
+		broker := &stream.Broker{Epoch: strconv.FormatInt(time.Now().UnixMilli(), 36), History: eventsHistory, Buffer: 256}
+		if err = (&stream.Server{
+			Broker:      broker,
+			Log:         ctrl.Log.WithName("stream"),
+			BindAddress: eventsGRPCBindAddress,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the egress events stream")
+			os.Exit(1)
+		}
+		notifySinks = append(notifySinks, broker)
+	}
+	if routesConfig != "" {
+		config, err := routes.LoadConfig(routesConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load the upstream routers configuration")
+			os.Exit(1)
+		}
+		injector := &routes.Injector{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("routes"),
+			Config:           config,
+			DefaultNamespace: haegressNamespace,
+			IntervalSeconds:  routesSyncSeconds,
+			ReadOnly:         readOnlyRecord,
+		}
+		if err = injector.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the route injector")
+			os.Exit(1)
+		}
+		notifySinks = append(notifySinks, injector)
+	}
+	publishTransports := []publish.Transport{}
+	if publishNATSURL != "" {
+		publishTransports = append(publishTransports, &publish.NATS{
+			URL:     publishNATSURL,
+			Subject: publishNATSSubject,
+			Token:   readSecretFile(publishNATSTokenFile),
+		})
+	}
+	if publishKafkaURL != "" {
+		publishTransports = append(publishTransports, &publish.Kafka{
+			URL:   publishKafkaURL,
+			Topic: publishKafkaTopic,
+			Token: readSecretFile(publishKafkaTokenFile),
+		})
+	}
+	for _, transport := range publishTransports {
+		publisher := publish.NewPublisher(transport, ctrl.Log.WithName("publisher"),
+			publish.Source(ciliumChecker.Features().ClusterName), 1000)
+		publisher.ReadOnly = readOnlyRecord
+		if err = publisher.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the events publisher", "transport", transport.Name())
+			os.Exit(1)
+		}
+		notifySinks = append(notifySinks, publisher)
+	}
+
+	var notifier *notify.Notifier
+	if notifyTargets != nil || len(notifySinks) > 0 {
+		notifier = &notify.Notifier{
+			Config:   notifyTargets,
+			Sinks:    notifySinks,
+			Log:      ctrl.Log.WithName("notifier"),
+			Cluster:  ciliumChecker.Features().ClusterName,
+			ReadOnly: readOnlyRecord,
+		}
+	}
+
+	var auditor *audit.Auditor
+	auditSinks := []audit.Sink{}
+	if auditSyslogAddress != "" {
+		auditSinks = append(auditSinks, &audit.Syslog{Address: auditSyslogAddress})
+	}
+	if auditHTTPURL != "" {
+		auditSinks = append(auditSinks, &audit.HTTP{URL: auditHTTPURL, Token: readSecretFile(auditHTTPTokenFile)})
+	}
+	if len(auditSinks) > 0 {
+		hostname, _ := os.Hostname()
+		auditor = audit.NewAuditor(auditSinks, ctrl.Log.WithName("audit"), ciliumChecker.Features().ClusterName,
+			fmt.Sprintf("cilium-haegress-operator/%s/%s", haegressNamespace, hostname), []byte(readSecretFile(auditHMACKeyFile)), 10000)
+		if err = auditor.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the auditor")
+			os.Exit(1)
+		}
+	}
+
+	var eventSink record.EventRecorder = mgr.GetEventRecorderFor("cilium-haegress-operator")
+	if readOnlyReport != nil {
+		// The events can't be created, the changes are in the report
+		eventSink = &record.FakeRecorder{}
+	}
+	eventRecorder := &recorder.Aggregator{
+		Recorder: eventSink,
+		Log:      ctrl.Log.WithName("recorder"),
+		Burst:    eventBurst,
+		Window:   time.Duration(eventAggregationSeconds) * time.Second,
+	}
+	if err = eventRecorder.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create the events aggregator")
+		os.Exit(1)
+	}
+
+	// In sharding mode the controllers run on every replica, each reconciling the policies
+	// of its shards, while the other runnables still run only on the leader
+	var sharder *shard.Sharder
+	if shards > 1 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "unable to get the replica identity for sharding")
+			os.Exit(1)
+		}
+		sharder = &shard.Sharder{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Log:           ctrl.Log.WithName("shard"),
+			Shards:        shards,
+			Identity:      hostname,
+			Namespace:     leaderElectionNamespace,
+			LeaseDuration: time.Duration(shardLeaseSeconds) * time.Second,
+		}
+		if err = sharder.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+	}
+
+	// The writes of the first reconciliation of every policy are spread over the warm-up
+	var ramp *warmup.Ramp
+	if warmupSeconds > 0 {
+		ramp = &warmup.Ramp{
+			Log:         ctrl.Log.WithName("warmup"),
+			Duration:    time.Duration(warmupSeconds) * time.Second,
+			InitialQPS:  warmupInitialQPS,
+			MaxQPS:      float64(k8sClientQPS),
+			AllReplicas: sharder != nil,
+		}
+		if err = ramp.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the warm-up")
+			os.Exit(1)
+		}
+	}
+
+	// The patches of a mass failover are combined per object and applied by a pool of workers
+	var patcher *batch.Patcher
+	if patchWorkers > 0 {
+		patcher = &batch.Patcher{
+			Client:  ramp.Client(mgr.GetClient()),
+			Log:     ctrl.Log.WithName("batch"),
+			Workers: patchWorkers,
+			QPS:     patchQPS,
+			Burst:   patchWorkers,
+		}
+		if err = patcher.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the patch workers")
+			os.Exit(1)
+		}
+	}
+
+	syncOptions := haegressiputil.SyncOptions{
+		EgressSubnetPrefixLength: egressSubnetPrefixLength,
+		Providers:                providers,
+		Notifier:                 notifier,
+		Auditor:                  auditor,
+		Patcher:                  patcher,
+		Namespaces:               namespaceScope,
+		Dampener:                 &dampening.Dampener{MinInterval: time.Duration(patchMinIntervalSeconds) * time.Second},
+	}
+	if conflictReverts > 0 {
+		if conflictWindowSeconds <= 0 || conflictBackoffSeconds <= 0 {
+			setupLog.Error(fmt.Errorf("--conflict-window-seconds and --conflict-backoff-seconds must be positive"), "invalid conflict guard")
+			os.Exit(1)
+		}
+		syncOptions.Guard = &coexistence.Guard{
+			Reader:       mgr.GetAPIReader(),
+			FieldManager: haegressip.FieldManager,
+			Reverts:      conflictReverts,
+			Window:       time.Duration(conflictWindowSeconds) * time.Second,
+			Backoff:      time.Duration(conflictBackoffSeconds) * time.Second,
+		}
+	}
+
+	fqdnResolver := &fqdn.Resolver{Server: fqdnDNSServer}
+	newFQDNCache := func(log logr.Logger) *fqdn.Cache {
+		if !features.Enabled(features.DestinationFQDNs) {
+			return nil
+		}
+		return &fqdn.Cache{
+			Resolver:       fqdnResolver,
+			Log:            log,
+			MinTTL:         time.Duration(fqdnMinTTLSeconds) * time.Second,
+			MaxTTL:         time.Duration(fqdnMaxTTLSeconds) * time.Second,
+			Events:         make(chan event.GenericEvent),
+			LeaderElection: sharder == nil,
+		}
+	}
+	fqdnCache := newFQDNCache(ctrl.Log.WithName("fqdn"))
+	if fqdnCache != nil {
+		if err = fqdnCache.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the resolution of the destination FQDNs")
+			os.Exit(1)
+		}
+	}
+	newClusterCIDRs := func(m ctrl.Manager, log logr.Logger) *clustercidrs.Cache {
+		if !excludeClusterCIDRs {
+			return nil
+		}
+		return &clustercidrs.Cache{
+			Reader:          m.GetAPIReader(),
+			Log:             log,
+			CiliumNamespace: ciliumNamespace,
+			Static:          splitList(clusterCIDRs),
+			IntervalSeconds: clusterCIDRsRefreshSeconds,
+			Events:          make(chan event.GenericEvent),
+			LeaderElection:  sharder == nil,
+		}
+	}
+	clusterCIDRsCache := newClusterCIDRs(mgr, ctrl.Log.WithName("clustercidrs"))
+	if clusterCIDRsCache != nil {
+		if err = clusterCIDRsCache.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the discovery of the cluster CIDRs")
+			os.Exit(1)
+		}
+	}
+	// The desired state interpreted from the policies, served by the API
+	desiredStore := &desired.Store{}
+	var feedsConfig *feeds.Config
+	if destinationFeedsConfig != "" {
+		feedsConfig, err = feeds.LoadConfig(destinationFeedsConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load the destination feeds configuration")
+			os.Exit(1)
+		}
+	}
+	newFeedCache := func(log logr.Logger) *feeds.Cache {
+		if feedsConfig == nil {
+			return nil
+		}
+		return &feeds.Cache{
+			Config:         feedsConfig,
+			Log:            log,
+			Events:         make(chan event.GenericEvent),
+			LeaderElection: sharder == nil,
+		}
+	}
+	feedCache := newFeedCache(ctrl.Log.WithName("feeds"))
+	if feedCache != nil {
+		if err = feedCache.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the destination feeds")
+			os.Exit(1)
+		}
+	}
+	newSelectorPreview := func(m ctrl.Manager) *controllers.SelectorPreviewOptions {
+		if !selectorPreview {
+			return nil
+		}
+		return &controllers.SelectorPreviewOptions{
+			Reader:              m.GetAPIReader(),
+			CountPods:           selectorPreviewPods,
+			SensitiveNamespaces: splitList(selectorWarningNamespaces),
+		}
+	}
+	policyReconciler := &controllers.HAEgressGatewayPolicyReconciler{
+		Client:                   ramp.Client(mgr.GetClient()),
 		Log:                      ctrl.Log.WithName("controllers").WithName("HAEgressGatewayPolicy"),
 		Scheme:                   mgr.GetScheme(),
-		Recorder:                 mgr.GetEventRecorderFor("cilium-haegress-operator"),
+		Recorder:                 eventRecorder,
 		EgressNamespace:          haegressNamespace,
-		LoadBalancerClass:        loadBalancerClass,
 		BackgroundCheckerSeconds: backgroundCheckerSeconds,
-	}).SetupWithManager(mgr); err != nil {
+		SyncOptions:              syncOptions,
+		ClusterName:              ciliumChecker.Features().ClusterName,
+		LocalClusterOnly:         clustermeshLocalOnly,
+		Allocators:               allocatorRegistry,
+		Sharder:                  sharder,
+		Cilium:                   ciliumChecker,
+		MaxConcurrentReconciles:  maxConcurrentReconciles,
+		ListPageSize:             listPageSize,
+		ProtectServices:          protectServices,
+		IPFamilies:               ipFamilyOptions,
+		FQDNs:                    fqdnCache,
+		Feeds:                    feedCache,
+		ClusterCIDRs:             clusterCIDRsCache,
+		Desired:                  desiredStore,
+		NamespaceDefaults:        namespaceDefaults,
+		SelectorlessServices:     selectorlessServices,
+		Metadata: haegressiputil.MetadataOptions{
+			TrackingLabels:      splitList(trackingLabels),
+			TrackingPassthrough: trackingPassthrough,
+		},
+		SourceNamespaces: haegressiputil.SourceNamespaces{
+			Allowed: splitList(allowedSourceNamespaces),
+			Denied:  splitList(deniedSourceNamespaces),
+		},
+		SelectorPreview: newSelectorPreview(mgr),
+		DrainGrace:      time.Duration(drainGraceSeconds) * time.Second,
+	}
+	// The replica is ready once it caught up with the cluster
+	var barrier *startup.Barrier
+	if recoveryTimeoutSeconds > 0 {
+		barrier = &startup.Barrier{
+			Client:         mgr.GetClient(),
+			Log:            ctrl.Log.WithName("startup"),
+			Timeout:        time.Duration(recoveryTimeoutSeconds) * time.Second,
+			LeaderElection: enableLeaderElection && sharder == nil,
+		}
+		if err = barrier.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the startup recovery barrier")
+			os.Exit(1)
+		}
+		policyReconciler.Barrier = barrier
+	}
+	if bindingsConfigMap != "" {
+		policyReconciler.Bindings = &bindings.Store{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Namespace:     haegressNamespace,
+			ConfigMapName: bindingsConfigMap,
+		}
+	}
+	if reclaimGraceSeconds > 0 {
+		policyReconciler.Reclaimable = &reclaim.Store{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Namespace:     haegressNamespace,
+			ConfigMapName: reclaimConfigMap,
+			GracePeriod:   time.Duration(reclaimGraceSeconds) * time.Second,
+		}
+		if err = (&reclaim.Releaser{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("reclaim"),
+			Store:      policyReconciler.Reclaimable,
+			Allocators: allocatorRegistry,
+			Sharder:    sharder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the releaser of the held egress IPs")
+			os.Exit(1)
+		}
+	}
+	if err = policyReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HAEgressGatewayPolicy")
 		os.Exit(1)
 	}
+	// After a restart, the Services of the assignments changed since the last snapshot are
+	// queued in the failover controller, as the Services out of sync found by the
+	// consistency checker and the ones selecting deleted nodes
+	var recovery chan event.GenericEvent
+	if snapshotConfigMap != "" || consistencyCheckSeconds > 0 || orphanCheckSeconds > 0 {
+		recovery = make(chan event.GenericEvent)
+	}
+	if snapshotConfigMap != "" {
+		if err = (&snapshot.Snapshotter{
+			Client:          mgr.GetClient(),
+			Reader:          mgr.GetAPIReader(),
+			Log:             ctrl.Log.WithName("snapshot"),
+			Sharder:         sharder,
+			Namespace:       haegressNamespace,
+			ConfigMapName:   snapshotConfigMap,
+			IntervalSeconds: snapshotSeconds,
+			Recovery:        recovery,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the assignment snapshot")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.ServicesController{
-		Client:          mgr.GetClient(),
-		Log:             ctrl.Log.WithName("controllers").WithName("Services"),
-		Scheme:          mgr.GetScheme(),
-		Recorder:        mgr.GetEventRecorderFor("cilium-haegress-operator"),
-		EgressNamespace: haegressNamespace,
+		Client:                  ramp.Client(mgr.GetClient()),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Services"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                eventRecorder,
+		CiliumNamespace:         ciliumNamespace,
+		EgressNamespace:         haegressNamespace,
+		SyncOptions:             syncOptions,
+		KubeVIPLeases:           features.Enabled(features.KubeVIPLeaseFastPath),
+		Sharder:                 sharder,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		Recovery:                recovery,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Services")
 		os.Exit(1)
 	}
+	if eligibilityCheck {
+		if err = (&controllers.EligibilityController{
+			Client:   ramp.Client(mgr.GetClient()),
+			Log:      ctrl.Log.WithName("controllers").WithName("Eligibility"),
+			Recorder: eventRecorder,
+			Sharder:  sharder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Eligibility")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.NamespaceFanOutController{
+		Client:           ramp.Client(mgr.GetClient()),
+		Log:              ctrl.Log.WithName("controllers").WithName("NamespaceFanOut"),
+		Scheme:           mgr.GetScheme(),
+		Recorder:         eventRecorder,
+		EgressNamespace:  haegressNamespace,
+		SourceNamespaces: policyReconciler.SourceNamespaces,
+		Sharder:          sharder,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NamespaceFanOut")
+		os.Exit(1)
+	}
+	if features.Enabled(features.KubeVIPNodeAffinity) {
+		if err = (&controllers.KubeVIPAffinityController{
+			Client:          ramp.Client(mgr.GetClient()),
+			Reader:          mgr.GetAPIReader(),
+			Log:             ctrl.Log.WithName("controllers").WithName("KubeVIPAffinity"),
+			Scheme:          mgr.GetScheme(),
+			Recorder:        eventRecorder,
+			Providers:       providers,
+			EgressNamespace: haegressNamespace,
+			Budget:          disruptionBudget,
+			Sharder:         sharder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "KubeVIPAffinity")
+			os.Exit(1)
+		}
+	}
+	if consistencyCheckSeconds > 0 {
+		if err = (&consistency.Checker{
+			Client:          ramp.Client(mgr.GetClient()),
+			Log:             ctrl.Log.WithName("consistency"),
+			Recorder:        eventRecorder,
+			SyncOptions:     syncOptions,
+			Sharder:         sharder,
+			EgressNamespace: haegressNamespace,
+			IntervalSeconds: consistencyCheckSeconds,
+			GraceSeconds:    consistencyGraceSeconds,
+			Action:          consistencyAction,
+			Resync:          recovery,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the consistency checker")
+			os.Exit(1)
+		}
+	}
+	if drillIntervalMinutes > 0 {
+		if err = (&drill.Driller{
+			Client:          ramp.Client(mgr.GetClient()),
+			Log:             ctrl.Log.WithName("drill"),
+			Recorder:        eventRecorder,
+			Providers:       providers,
+			Budget:          disruptionBudget,
+			Sharder:         sharder,
+			EgressNamespace: haegressNamespace,
+			Interval:        time.Duration(drillIntervalMinutes) * time.Minute,
+			Timeout:         time.Duration(drillTimeoutSeconds) * time.Second,
+			MaxPolicies:     drillMaxPolicies,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the failover drills")
+			os.Exit(1)
+		}
+	}
+	if orphanCheckSeconds > 0 {
+		if err = (&orphans.Scanner{
+			Client:          ramp.Client(mgr.GetClient()),
+			Log:             ctrl.Log.WithName("orphans"),
+			Recorder:        eventRecorder,
+			Providers:       providers,
+			Sharder:         sharder,
+			EgressNamespace: haegressNamespace,
+			IntervalSeconds: orphanCheckSeconds,
+			Resync:          recovery,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the orphan nodeSelector scanner")
+			os.Exit(1)
+		}
+	}
+	// The Leases are read only with the fast path, that caches them
+	if claimCheckSeconds > 0 && features.Enabled(features.KubeVIPLeaseFastPath) {
+		if err = (&vipclaim.Monitor{
+			Client:          ramp.Client(mgr.GetClient()),
+			Log:             ctrl.Log.WithName("vipclaim"),
+			Recorder:        eventRecorder,
+			Providers:       providers,
+			Sharder:         sharder,
+			EgressNamespace: haegressNamespace,
+			IntervalSeconds: claimCheckSeconds,
+			StaleSeconds:    claimStaleSeconds,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the kube-vip claim monitor")
+			os.Exit(1)
+		}
+	}
+	// Also without the protection, to remove the finalizers set while it was enabled
+	if err = (&controllers.ServiceProtectionController{
+		Client:   ramp.Client(mgr.GetClient()),
+		Log:      ctrl.Log.WithName("controllers").WithName("ServiceProtection"),
+		Recorder: eventRecorder,
+		Protect:  protectServices,
+		Sharder:  sharder,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceProtection")
+		os.Exit(1)
+	}
+
+	// The IsovalentEgressGatewayPolicies of Cilium Enterprise are generated when their CRD is
+	// installed, for the policies with the target-kind annotation or for every policy
+	isovalentInstalled, err := isovalent.Detect(context.Background(), mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to detect the IsovalentEgressGatewayPolicy CRD")
+		os.Exit(1)
+	}
+	targetOptions := isovalent.Options{Default: targetKind}
+	if targetKind == isovalent.TargetAuto {
+		targetOptions.Default = isovalent.TargetCiliumEgressGatewayPolicy
+		if isovalentInstalled {
+			targetOptions.Default = isovalent.TargetIsovalentEgressGatewayPolicy
+		}
+	}
+	if targetOptions.Default == isovalent.TargetIsovalentEgressGatewayPolicy && !isovalentInstalled {
+		setupLog.Error(fmt.Errorf("the CRD %s is not installed", isovalent.CRD), "invalid --target-kind")
+		os.Exit(1)
+	}
+	if isovalentInstalled {
+		setupLog.Info("IsovalentEgressGatewayPolicy CRD detected", "defaultTargetKind", targetOptions.Default)
+		if err = (&controllers.IsovalentEgressGatewayPolicyController{
+			Client:   ramp.Client(mgr.GetClient()),
+			Log:      ctrl.Log.WithName("controllers").WithName("IsovalentEgressGatewayPolicy"),
+			Recorder: eventRecorder,
+			Target:   targetOptions,
+			Sharder:  sharder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IsovalentEgressGatewayPolicy")
+			os.Exit(1)
+		}
+	}
+
+	whatIfOptions := whatif.Options{
+		DefaultProvider:        defaultProvider,
+		DefaultNamespace:       haegressNamespace,
+		PatchQPS:               patchQPS,
+		NodeMonitorGracePeriod: whatif.DefaultNodeMonitorGracePeriod,
+		Detection:              whatif.DefaultDetection(),
+	}
+	if nodeStatusSeconds > 0 {
+		if err = (&nodestatus.Aggregator{
+			Client:          mgr.GetClient(),
+			Log:             ctrl.Log.WithName("nodestatus"),
+			WhatIf:          whatIfOptions,
+			IntervalSeconds: nodeStatusSeconds,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the EgressNodeStatus aggregator")
+			os.Exit(1)
+		}
+	}
+
+	if apiBindAddress != "" {
+		if apiTokensFile == "" {
+			setupLog.Error(fmt.Errorf("--api-tokens-file is required"), "unable to create the egress assignments API")
+			os.Exit(1)
+		}
+		if err = (&api.Server{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("api"),
+			BindAddress:      apiBindAddress,
+			TokensFile:       apiTokensFile,
+			DefaultNamespace: haegressNamespace,
+			WhatIf:           whatIfOptions,
+			Desired:          desiredStore,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the egress assignments API")
+			os.Exit(1)
+		}
+	}
+
+	if allowListBindAddress != "" {
+		if err = (&allowlist.Server{
+			Client:            mgr.GetClient(),
+			Log:               ctrl.Log.WithName("allowlist"),
+			BindAddress:       allowListBindAddress,
+			GroupLabel:        allowListGroupLabel,
+			ObjectGroupPrefix: allowListObjectGroupPrefix,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the egress IP allow-lists")
+			os.Exit(1)
+		}
+	}
+
+	if policyInfoMaxSeries > 0 {
+		policyServiceNamespace := func(policy *ciliumv1alpha1.HAEgressGatewayPolicy) string {
+			return haegressiputil.ServiceNamespace(policy, haegressNamespace)
+		}
+		metrics.Registry.MustRegister(&haegressmetrics.PolicyInfoCollector{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("metrics"),
+			ServiceNamespace: policyServiceNamespace,
+			MaxSeries:        policyInfoMaxSeries,
+		})
+		metrics.Registry.MustRegister(&haegressmetrics.PolicyStatusCollector{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("metrics"),
+			ServiceNamespace: policyServiceNamespace,
+			MaxSeries:        policyInfoMaxSeries,
+		})
+	}
+
+	if syncHooksConfig != "" {
+		config, err := synchook.LoadConfig(syncHooksConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load the sync hooks configuration")
+			os.Exit(1)
+		}
+		if err = (&synchook.Runner{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("synchook"),
+			Config:           config,
+			Cluster:          ciliumChecker.Features().ClusterName,
+			DefaultNamespace: haegressNamespace,
+			IntervalSeconds:  syncHooksSeconds,
+			ReadOnly:         readOnlyRecord,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the sync hooks runner")
+			os.Exit(1)
+		}
+	}
+
+	if serviceNowURL != "" {
+		if err = (&servicenow.Syncer{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("servicenow"),
+			URL:              serviceNowURL,
+			Username:         serviceNowUsername,
+			Password:         readSecretFile(serviceNowPasswordFile),
+			Table:            serviceNowTable,
+			Cluster:          ciliumChecker.Features().ClusterName,
+			DefaultNamespace: haegressNamespace,
+			IntervalSeconds:  serviceNowSyncSeconds,
+			ReadOnly:         readOnlyRecord,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the ServiceNow synchronization")
+			os.Exit(1)
+		}
+	}
+
+	if configPath != "" {
+		configFile.OnChange("background-checker-seconds", func() {
+			policyReconciler.SetBackgroundCheckerSeconds(backgroundCheckerSeconds)
+		})
+		if patcher != nil {
+			configFile.OnChange("patch-qps", func() {
+				patcher.SetQPS(patchQPS)
+			})
+		}
+		if err = configFile.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the configuration reload")
+			os.Exit(1)
+		}
+	}
+
+	if mappingConfigMap != "" {
+		if err = (&mapping.Exporter{
+			Client:          mgr.GetClient(),
+			Reader:          mgr.GetAPIReader(),
+			Log:             ctrl.Log.WithName("exporter").WithName("Mappings"),
+			Namespace:       haegressNamespace,
+			ConfigMapName:   mappingConfigMap,
+			IntervalSeconds: mappingExportSeconds,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create exporter", "exporter", "Mappings")
+			os.Exit(1)
+		}
+	}
+
+	if monitoringObjects != "" {
+		monitoringOptions := monitoring.Options{
+			Name:      monitoringName,
+			Namespace: haegressNamespace,
+			Port:      monitoringPort,
+		}
+		if monitoringOptions.Selector, err = labels.ConvertSelectorToLabelsMap(monitoringPodLabels); err != nil {
+			setupLog.Error(err, "invalid --monitoring-pod-labels")
+			os.Exit(1)
+		}
+		if monitoringOptions.Labels, err = labels.ConvertSelectorToLabelsMap(monitoringLabels); err != nil {
+			setupLog.Error(err, "invalid --monitoring-labels")
+			os.Exit(1)
+		}
+		if err = (&monitoring.Installer{
+			Client:  mgr.GetClient(),
+			Log:     ctrl.Log.WithName("monitoring"),
+			Kinds:   splitList(monitoringObjects),
+			Options: monitoringOptions,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the monitoring objects")
+			os.Exit(1)
+		}
+	}
+
+	if clustermeshConfigMap != "" {
+		if err = (&clustermesh.Publisher{
+			Client:          mgr.GetClient(),
+			Reader:          mgr.GetAPIReader(),
+			Log:             ctrl.Log.WithName("publisher").WithName("ClusterMesh"),
+			ClusterName:     ciliumChecker.Features().ClusterName,
+			Namespace:       haegressNamespace,
+			ConfigMapName:   clustermeshConfigMap,
+			IntervalSeconds: clustermeshPublishSeconds,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create publisher", "publisher", "ClusterMesh")
+			os.Exit(1)
+		}
+	}
+
+	if federationConfigMap != "" {
+		if err = (&federation.Federation{
+			Client:           mgr.GetClient(),
+			Reader:           mgr.GetAPIReader(),
+			Log:              ctrl.Log.WithName("federation"),
+			Namespace:        haegressNamespace,
+			ConfigMapName:    federationConfigMap,
+			DefaultNamespace: haegressNamespace,
+			IntervalSeconds:  federationSeconds,
+			Options:          managerOptions,
+			Setup: func(memberMgr ctrl.Manager, cluster string) error {
+				// The cloud providers move the IPs of the cloud account of the operator, the
+				// members use the in-cluster ones
+				memberKubeVIP := &provider.KubeVIP{LoadBalancerClass: loadBalancerClass, NodeAffinity: features.Enabled(features.KubeVIPNodeAffinity)}
+				if features.Enabled(features.KubeVIPLeaseFastPath) {
+					memberKubeVIP.Leases = memberMgr.GetClient()
+				}
+				memberVIPProviders := []provider.Provider{
+					memberKubeVIP,
+					&provider.CiliumLBIPAM{Client: memberMgr.GetClient(), CiliumNamespace: ciliumNamespace, LoadBalancerClass: ciliumLoadBalancerClass},
+					&provider.MetalLB{Client: memberMgr.GetClient(), LoadBalancerClass: metallbLoadBalancerClass},
+					&provider.Static{},
+				}
+				// The members have no cloud nor plugin provider, their chains are left out
+				memberChains := map[string][]string{}
+				for _, memberProvider := range memberVIPProviders {
+					if chain, ok := discoveryChains[memberProvider.Name()]; ok {
+						memberChains[memberProvider.Name()] = chain
+					}
+				}
+				if err := provider.ConfigureDiscovery(memberChains, memberVIPProviders, provider.DiscoveryOptions{
+					Client:          memberMgr.GetClient(),
+					CiliumNamespace: ciliumNamespace,
+					Plugins:         plugins,
+					Log:             ctrl.Log.WithName("federation").WithValues("cluster", cluster).WithName("discovery"),
+				}); err != nil {
+					return err
+				}
+				memberProviders, err := provider.NewRegistry(defaultProvider, memberVIPProviders...)
+				if err != nil {
+					return err
+				}
+				memberSyncOptions := syncOptions
+				memberSyncOptions.Providers = memberProviders
+				memberSyncOptions.Patcher = nil
+				// The policies of the members are dampened on their own, they can share the names
+				memberSyncOptions.Dampener = &dampening.Dampener{MinInterval: syncOptions.Dampener.MinInterval}
+				if syncOptions.Guard != nil {
+					memberSyncOptions.Guard = &coexistence.Guard{
+						Reader:       memberMgr.GetAPIReader(),
+						FieldManager: syncOptions.Guard.FieldManager,
+						Reverts:      syncOptions.Guard.Reverts,
+						Window:       syncOptions.Guard.Window,
+						Backoff:      syncOptions.Guard.Backoff,
+					}
+				}
+				memberRecorder := memberMgr.GetEventRecorderFor("cilium-haegress-operator")
+				memberLog := ctrl.Log.WithName("federation").WithValues("cluster", cluster)
+				// The IP families of the Services of the members are not discovered
+				memberIPFamilies := haegressiputil.IPFamilyOptions{Policy: ipFamilyOptions.Policy, Families: ipFamilyOptions.Families}
+				// The allocations of the IPAM pool are keyed by the name of the member
+				// The policies of every member are queued on their own Manager
+				memberFQDNs := newFQDNCache(memberLog.WithName("fqdn"))
+				if memberFQDNs != nil {
+					if err := memberFQDNs.SetupWithManager(memberMgr); err != nil {
+						return err
+					}
+				}
+				memberFeeds := newFeedCache(memberLog.WithName("feeds"))
+				if memberFeeds != nil {
+					if err := memberFeeds.SetupWithManager(memberMgr); err != nil {
+						return err
+					}
+				}
+				// The cluster CIDRs of every member are discovered in the member
+				memberClusterCIDRs := newClusterCIDRs(memberMgr, memberLog.WithName("clustercidrs"))
+				if memberClusterCIDRs != nil {
+					if err := memberClusterCIDRs.SetupWithManager(memberMgr); err != nil {
+						return err
+					}
+				}
+				if err := (&controllers.HAEgressGatewayPolicyReconciler{
+					Client:                   memberMgr.GetClient(),
+					Log:                      memberLog.WithName("HAEgressGatewayPolicy"),
+					Scheme:                   memberMgr.GetScheme(),
+					Recorder:                 memberRecorder,
+					EgressNamespace:          haegressNamespace,
+					BackgroundCheckerSeconds: backgroundCheckerSeconds,
+					SyncOptions:              memberSyncOptions,
+					ClusterName:              cluster,
+					Allocators:               allocatorRegistry,
+					MaxConcurrentReconciles:  maxConcurrentReconciles,
+					ListPageSize:             listPageSize,
+					ProtectServices:          protectServices,
+					IPFamilies:               memberIPFamilies,
+					FQDNs:                    memberFQDNs,
+					Feeds:                    memberFeeds,
+					ClusterCIDRs:             memberClusterCIDRs,
+					NamespaceDefaults:        namespaceDefaults,
+					SelectorlessServices:     selectorlessServices,
+					Metadata:                 policyReconciler.Metadata,
+					SourceNamespaces:         policyReconciler.SourceNamespaces,
+					SelectorPreview:          newSelectorPreview(memberMgr),
+					DrainGrace:               policyReconciler.DrainGrace,
+				}).SetupWithManager(memberMgr); err != nil {
+					return err
+				}
+				if err := (&controllers.ServicesController{
+					Client:                  memberMgr.GetClient(),
+					Log:                     memberLog.WithName("Services"),
+					Scheme:                  memberMgr.GetScheme(),
+					Recorder:                memberRecorder,
+					CiliumNamespace:         ciliumNamespace,
+					EgressNamespace:         haegressNamespace,
+					SyncOptions:             memberSyncOptions,
+					KubeVIPLeases:           features.Enabled(features.KubeVIPLeaseFastPath),
+					MaxConcurrentReconciles: maxConcurrentReconciles,
+				}).SetupWithManager(memberMgr); err != nil {
+					return err
+				}
+				if err := (&controllers.NamespaceFanOutController{
+					Client:           memberMgr.GetClient(),
+					Log:              memberLog.WithName("NamespaceFanOut"),
+					Scheme:           memberMgr.GetScheme(),
+					Recorder:         memberRecorder,
+					EgressNamespace:  haegressNamespace,
+					SourceNamespaces: policyReconciler.SourceNamespaces,
+				}).SetupWithManager(memberMgr); err != nil {
+					return err
+				}
+				if features.Enabled(features.KubeVIPNodeAffinity) {
+					if err := (&controllers.KubeVIPAffinityController{
+						Client:          memberMgr.GetClient(),
+						Reader:          memberMgr.GetAPIReader(),
+						Log:             memberLog.WithName("KubeVIPAffinity"),
+						Scheme:          memberMgr.GetScheme(),
+						Recorder:        memberRecorder,
+						Providers:       memberProviders,
+						EgressNamespace: haegressNamespace,
+					}).SetupWithManager(memberMgr); err != nil {
+						return err
+					}
+				}
+				return (&controllers.ServiceProtectionController{
+					Client:   memberMgr.GetClient(),
+					Log:      memberLog.WithName("ServiceProtection"),
+					Recorder: memberRecorder,
+					Protect:  protectServices,
+				}).SetupWithManager(memberMgr)
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up the federation of the member clusters")
+			os.Exit(1)
+		}
+	}
+
+	if hubbleRelayAddress != "" && !ciliumChecker.Features().Hubble {
+		setupLog.Info("Hubble is not enabled in Cilium, the egress observer is disabled")
+	} else if hubbleRelayAddress != "" {
+		if err = (&hubble.Observer{
+			Client:          mgr.GetClient(),
+			Log:             ctrl.Log.WithName("observer").WithName("Hubble"),
+			RelayAddress:    hubbleRelayAddress,
+			RelayCAFile:     hubbleRelayCAFile,
+			IntervalSeconds: hubbleSampleSeconds,
+			SampleFlows:     hubbleSampleFlows,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create observer", "observer", "Hubble")
+			os.Exit(1)
+		}
+	}
+
+	if logLevelConfigMap != "" {
+		if err = (&loglevel.Watcher{
+			Reader:          mgr.GetAPIReader(),
+			Log:             ctrl.Log.WithName("loglevel"),
+			Levels:          logLevels,
+			Namespace:       haegressNamespace,
+			ConfigMapName:   logLevelConfigMap,
+			IntervalSeconds: 10,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the log level watcher")
+			os.Exit(1)
+		}
+	}
+
+	leadership := &haegressmetrics.Leadership{Log: ctrl.Log.WithName("leader"), Recovered: barrier.Recovered}
+	if err = leadership.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up the leadership tracking")
+		os.Exit(1)
+	}
+
+	statuszHandler.Client = mgr.GetClient()
+	statuszHandler.Log = ctrl.Log.WithName("statusz")
+	statuszHandler.Providers = providers
+	statuszHandler.Leadership = leadership
+	statuszHandler.Sharder = sharder
+	statuszHandler.Cilium = ciliumChecker
+	statuszHandler.DefaultNamespace = haegressNamespace
 
 	//+kubebuilder:scaffold:builder
 
@@ -186,3 +1834,28 @@ func getInClusterNamespace() (string, error) {
 	}
 	return string(namespace), nil
 }
+
+// splitList returns the items of a comma separated list, without the empty ones
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readSecretFile returns the trimmed content of a file containing a credential, empty if
+// no file was given
+func readSecretFile(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		setupLog.Error(err, "unable to read the credentials file", "file", path)
+		os.Exit(1)
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admission generates the ValidatingAdmissionPolicy, and its binding, enforcing
+// the invariants of the operator on the HAEgressGatewayPolicies with CEL in the API
+// server: the naming limits of the generated objects, the namespaces allowed for the
+// Services and for the selected pods, and the destination ranges. The operator has no
+// admission webhook, so without them an invalid policy is only reported once applied.
+package admission
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultName is the default name of the ValidatingAdmissionPolicy and of its binding
+const DefaultName = "haegressgatewaypolicies.cilium.angeloxx.ch"
+
+// APIVersions are the versions of the admissionregistration API the objects can be
+// generated for, v1 from Kubernetes 1.30, v1beta1 from 1.28. Their schemas are the same.
+var APIVersions = []string{"v1", "v1beta1"}
+
+// Options are the invariants enforced, as the flags of the operator
+type Options struct {
+	// Name is the name of the policy and of the binding, DefaultName when empty
+	Name string
+	// APIVersion is the version of the admissionregistration API, v1 when empty
+	APIVersion string
+	// ValidationActions are the actions of the binding, Deny when empty
+	ValidationActions []admissionregistrationv1beta1.ValidationAction
+	// EgressNamespace is the namespace of the Services of the policies without the
+	// namespace annotation, as --egress-default-namespace
+	EgressNamespace string
+	// ServiceNamespaces are the namespaces of the namespace annotation besides
+	// EgressNamespace, every namespace when empty, as --service-namespaces
+	ServiceNamespaces []string
+	// ExcludeNamespaces are never allowed in the namespace annotation, as
+	// --exclude-namespaces
+	ExcludeNamespaces []string
+	// AllowedSourceNamespaces and DeniedSourceNamespaces restrict the namespaces the rules
+	// of the selectors can pin, as --allowed-source-namespaces and
+	// --denied-source-namespaces
+	AllowedSourceNamespaces []string
+	DeniedSourceNamespaces  []string
+	// AllowedCIDRs are the IPv4 ranges the destinationCIDRs must be part of, every
+	// destination when empty
+	AllowedCIDRs []string
+}
+
+// Generate returns the ValidatingAdmissionPolicy and its ValidatingAdmissionPolicyBinding
+func Generate(options Options) ([]client.Object, error) {
+	if options.Name == "" {
+		options.Name = DefaultName
+	}
+	if options.APIVersion == "" {
+		options.APIVersion = APIVersions[0]
+	}
+	validVersion := false
+	for _, version := range APIVersions {
+		validVersion = validVersion || version == options.APIVersion
+	}
+	if !validVersion {
+		return nil, fmt.Errorf("invalid API version %q, valid versions are %v", options.APIVersion, APIVersions)
+	}
+	if len(options.ValidationActions) == 0 {
+		options.ValidationActions = []admissionregistrationv1beta1.ValidationAction{admissionregistrationv1beta1.Deny}
+	}
+	for _, action := range options.ValidationActions {
+		switch action {
+		case admissionregistrationv1beta1.Deny, admissionregistrationv1beta1.Warn, admissionregistrationv1beta1.Audit:
+		default:
+			return nil, fmt.Errorf("invalid validation action %q, valid actions are Deny, Warn and Audit", action)
+		}
+	}
+	for _, namespaces := range [][]string{{options.EgressNamespace}, options.ServiceNamespaces, options.ExcludeNamespaces, options.AllowedSourceNamespaces, options.DeniedSourceNamespaces} {
+		if err := sanitize.NamespaceNames(namespaces); err != nil {
+			return nil, err
+		}
+	}
+	validations, err := validations(options)
+	if err != nil {
+		return nil, err
+	}
+
+	failurePolicy := admissionregistrationv1beta1.Fail
+	policy := &admissionregistrationv1beta1.ValidatingAdmissionPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/" + options.APIVersion,
+			Kind:       "ValidatingAdmissionPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name},
+		Spec: admissionregistrationv1beta1.ValidatingAdmissionPolicySpec{
+			FailurePolicy: &failurePolicy,
+			MatchConstraints: &admissionregistrationv1beta1.MatchResources{
+				ResourceRules: []admissionregistrationv1beta1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{haegressv2.GroupVersion.Group},
+							APIVersions: []string{haegressv2.GroupVersion.Version},
+							Resources:   []string{"haegressgatewaypolicies"},
+						},
+					},
+				}},
+			},
+			Validations: validations,
+		},
+	}
+	binding := &admissionregistrationv1beta1.ValidatingAdmissionPolicyBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/" + options.APIVersion,
+			Kind:       "ValidatingAdmissionPolicyBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name},
+		Spec: admissionregistrationv1beta1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        options.Name,
+			ValidationActions: options.ValidationActions,
+		},
+	}
+	return []client.Object{policy, binding}, nil
+}
+
+// validations returns the CEL rules of the options
+func validations(options Options) ([]admissionregistrationv1beta1.Validation, error) {
+	rules := []admissionregistrationv1beta1.Validation{
+		{
+			// The Service of the policy has its name, a DNS-1035 label
+			Expression: `object.metadata.name.size() <= 63 && object.metadata.name.matches('^[a-z]([-a-z0-9]*[a-z0-9])?$')`,
+			Message:    "the name of the policy is the name of its Service: at most 63 lowercase letters, digits and dashes, starting with a letter",
+		},
+		{
+			Expression: annotationRule(haegressip.EgressInterfaceAnnotation,
+				fmt.Sprintf(`v == '%s' || (v.size() <= 15 && v != '.' && v != '..' && v.matches('^[^/:"\\\\ ]+$'))`, haegressip.EgressInterfaceAuto)),
+			Message: fmt.Sprintf("the %s annotation must be auto or an interface name of at most 15 characters", haegressip.EgressInterfaceAnnotation),
+		},
+		{
+			// The egressIP is held only by the exit node, the other nodes of a group use the
+			// address of their interface
+			Expression: annotationRule(haegressip.EgressGatewayGroupSizeAnnotation,
+				fmt.Sprintf(`!v.matches('^[0-9]{1,9}$') || int(v) <= 1 || ('%[1]s' in object.metadata.annotations && object.metadata.annotations['%[1]s'] != '')`,
+					haegressip.EgressInterfaceAnnotation)),
+			Message: fmt.Sprintf("the %s annotation needs the %s annotation, the nodes of a gateway group must use their interface address",
+				haegressip.EgressGatewayGroupSizeAnnotation, haegressip.EgressInterfaceAnnotation),
+		},
+	}
+	for _, annotation := range []string{haegressip.PreferredExitNodeAnnotation, haegressip.StaticExitNodeAnnotation} {
+		rules = append(rules, admissionregistrationv1beta1.Validation{
+			Expression: annotationRule(annotation, `v.size() <= 63 && v.matches('^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$')`),
+			Message:    fmt.Sprintf("the %s annotation must be a node hostname usable as label value", annotation),
+		})
+	}
+
+	namespaceRule := `v.size() <= 63 && v.matches('^[a-z0-9]([-a-z0-9]*[a-z0-9])?$')`
+	if len(options.ServiceNamespaces) > 0 {
+		namespaceRule += " && v in " + celList(append([]string{options.EgressNamespace}, options.ServiceNamespaces...))
+	}
+	if len(options.ExcludeNamespaces) > 0 {
+		namespaceRule += " && !(v in " + celList(options.ExcludeNamespaces) + ")"
+	}
+	rules = append(rules, admissionregistrationv1beta1.Validation{
+		Expression: annotationRule(haegressip.HAEgressGatewayPolicyNamespace, namespaceRule),
+		Message:    fmt.Sprintf("the %s annotation must be a namespace where the Services of the policies are allowed", haegressip.HAEgressGatewayPolicyNamespace),
+	})
+
+	if len(options.AllowedSourceNamespaces) > 0 || len(options.DeniedSourceNamespaces) > 0 {
+		allowed := "true"
+		if len(options.AllowedSourceNamespaces) > 0 {
+			allowed = "n in " + celList(options.AllowedSourceNamespaces)
+		}
+		if len(options.DeniedSourceNamespaces) > 0 {
+			allowed += " && !(n in " + celList(options.DeniedSourceNamespaces) + ")"
+		}
+		// The namespaces pinned by the rules, the operator restricts the others anyway
+		rules = append(rules, admissionregistrationv1beta1.Validation{
+			Expression: fmt.Sprintf(`!has(object.spec) || !has(object.spec.selectors) || object.spec.selectors.all(r,
+  (!has(r.podSelector) || !has(r.podSelector.matchLabels) || !('%[1]s' in r.podSelector.matchLabels) ||
+    [r.podSelector.matchLabels['%[1]s']].all(n, %[3]s)) &&
+  (!has(r.namespaceSelector) || !has(r.namespaceSelector.matchLabels) || !('%[2]s' in r.namespaceSelector.matchLabels) ||
+    [r.namespaceSelector.matchLabels['%[2]s']].all(n, %[3]s)))`,
+				haegressip.PodNamespaceLabel, corev1.LabelMetadataName, allowed),
+			Message: "the selectors must select the pods of the allowed source namespaces only",
+		})
+	}
+
+	if len(options.AllowedCIDRs) > 0 {
+		// The IPv6 destinations are not part of any range
+		ranges := []string{}
+		for _, cidr := range options.AllowedCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil || !prefix.Addr().Is4() {
+				return nil, fmt.Errorf("invalid IPv4 CIDR %q", cidr)
+			}
+			prefix = prefix.Masked()
+			// The address of the destination and of the range, shifted out of the host bits
+			divisor := int64(1) << (32 - prefix.Bits())
+			ranges = append(ranges, fmt.Sprintf("(int(c.split('/')[1]) >= %d && %s / %d == %d)",
+				prefix.Bits(), celIPv4("c.split('/')[0]"), divisor, ipv4Int(prefix.Addr())/divisor))
+		}
+		rules = append(rules, admissionregistrationv1beta1.Validation{
+			Expression: "!has(object.spec) || !has(object.spec.destinationCIDRs) || object.spec.destinationCIDRs.all(c, !c.contains(':') && (\n  " + strings.Join(ranges, " ||\n  ") + "))",
+			Message:    "the destinationCIDRs must be part of the allowed ranges " + strings.Join(options.AllowedCIDRs, ", "),
+		})
+	}
+	return rules, nil
+}
+
+// annotationRule returns the rule checking the value v of the annotation, when set
+func annotationRule(annotation, rule string) string {
+	return fmt.Sprintf(`!has(object.metadata.annotations) || !('%[1]s' in object.metadata.annotations) ||
+  object.metadata.annotations['%[1]s'] == '' || [object.metadata.annotations['%[1]s']].all(v, %[2]s)`, annotation, rule)
+}
+
+// celList returns the CEL list of the strings, validated names without quotes
+func celList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, "'"+value+"'")
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// celIPv4 returns the CEL integer of the dotted IPv4 address of the expression, the
+// addresses are validated by the schema of the CRD
+func celIPv4(address string) string {
+	octets := make([]string, 0, 4)
+	for i, weight := range []int{16777216, 65536, 256, 1} {
+		octets = append(octets, fmt.Sprintf("int(%s.split('.')[%d]) * %d", address, i, weight))
+	}
+	return "(" + strings.Join(octets, " + ") + ")"
+}
+
+func ipv4Int(address netip.Addr) int64 {
+	bytes := address.As4()
+	return int64(bytes[0])<<24 | int64(bytes[1])<<16 | int64(bytes[2])<<8 | int64(bytes[3])
+}
@@ -0,0 +1,208 @@
+package admission
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+)
+
+// perCallLimit is the cost limit of an expression in the API server
+const perCallLimit = 1000000
+
+// newEnvironment returns the CEL environment of the validations of the
+// ValidatingAdmissionPolicies in the API server of Kubernetes 1.28 and later, with its
+// options and the extension libraries the expressions can use. The object is dynamic,
+// as in the API server when the schema of the resource is not resolved.
+func newEnvironment(t *testing.T) *cel.Env {
+	t.Helper()
+	env, err := cel.NewEnv(
+		cel.HomogeneousAggregateLiterals(),
+		cel.EagerlyValidateDeclarations(true),
+		cel.DefaultUTCTimeZone(true),
+		cel.CrossTypeNumericComparisons(true),
+		cel.OptionalTypes(),
+		ext.Strings(ext.StringsVersion(2)),
+		ext.Sets(),
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		cel.Variable("params", cel.DynType),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+// compiled is a validation ready to be evaluated
+type compiled struct {
+	validation admissionregistrationv1beta1.Validation
+	program    cel.Program
+}
+
+func compile(t *testing.T, options Options) []compiled {
+	t.Helper()
+	rules, err := validations(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newEnvironment(t)
+	programs := []compiled{}
+	for _, rule := range rules {
+		ast, issues := env.Compile(rule.Expression)
+		if issues.Err() != nil {
+			t.Fatalf("the expression does not compile: %v\n%s", issues.Err(), rule.Expression)
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			t.Fatalf("the expression returns %v instead of a bool\n%s", ast.OutputType(), rule.Expression)
+		}
+		program, err := env.Program(ast, cel.CostLimit(perCallLimit))
+		if err != nil {
+			t.Fatalf("unable to plan the expression: %v\n%s", err, rule.Expression)
+		}
+		programs = append(programs, compiled{validation: rule, program: program})
+	}
+	return programs
+}
+
+// denied returns the messages of the validations the policy fails
+func denied(t *testing.T, programs []compiled, policy string) []string {
+	t.Helper()
+	object := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(policy), &object); err != nil {
+		t.Fatalf("invalid policy %s: %v", policy, err)
+	}
+	messages := []string{}
+	for _, program := range programs {
+		value, _, err := program.program.Eval(map[string]interface{}{"object": object})
+		if err != nil {
+			t.Fatalf("the expression failed on %s: %v\n%s", policy, err, program.validation.Expression)
+		}
+		if allowed, ok := value.Value().(bool); !ok {
+			t.Fatalf("the expression returned %v\n%s", value, program.validation.Expression)
+		} else if !allowed {
+			messages = append(messages, program.validation.Message)
+		}
+	}
+	return messages
+}
+
+func TestValidations(t *testing.T) {
+	programs := compile(t, Options{
+		EgressNamespace:         "egress-system",
+		ServiceNamespaces:       []string{"tenant-a"},
+		ExcludeNamespaces:       []string{"kube-system"},
+		AllowedSourceNamespaces: []string{"team-a", "team-b"},
+		DeniedSourceNamespaces:  []string{"team-b"},
+		AllowedCIDRs:            []string{"10.0.0.0/8", "192.168.10.0/24", "203.0.113.7/32"},
+	})
+
+	annotated := func(annotation, value string) string {
+		return `{"metadata":{"name":"egress","annotations":{"` + annotation + `":"` + value + `"}}}`
+	}
+	destinations := func(cidrs ...string) string {
+		data, _ := json.Marshal(append([]string{}, cidrs...))
+		return `{"metadata":{"name":"egress"},"spec":{"destinationCIDRs":` + string(data) + `}}`
+	}
+	tests := []struct {
+		name   string
+		policy string
+		// denied is part of the message of the only failing validation, empty when the
+		// policy is allowed
+		denied string
+	}{
+		{name: "minimal policy", policy: `{"metadata":{"name":"egress"}}`},
+		{name: "empty annotations", policy: `{"metadata":{"name":"egress","annotations":{}}}`},
+		{name: "name of 63 characters", policy: `{"metadata":{"name":"` + strings.Repeat("a", 63) + `"}}`},
+		{name: "name of 64 characters", policy: `{"metadata":{"name":"` + strings.Repeat("a", 64) + `"}}`, denied: "name of the policy"},
+		{name: "name with a dot", policy: `{"metadata":{"name":"egress.web"}}`, denied: "name of the policy"},
+		{name: "name starting with a digit", policy: `{"metadata":{"name":"1egress"}}`, denied: "name of the policy"},
+
+		{name: "interface", policy: annotated(haegressip.EgressInterfaceAnnotation, "eth0.100")},
+		{name: "automatic interface", policy: annotated(haegressip.EgressInterfaceAnnotation, haegressip.EgressInterfaceAuto)},
+		{name: "empty interface", policy: annotated(haegressip.EgressInterfaceAnnotation, "")},
+		{name: "interface of 16 characters", policy: annotated(haegressip.EgressInterfaceAnnotation, strings.Repeat("e", 16)), denied: haegressip.EgressInterfaceAnnotation},
+		{name: "interface with a slash", policy: annotated(haegressip.EgressInterfaceAnnotation, "eth0/1"), denied: haegressip.EgressInterfaceAnnotation},
+		{name: "interface ..", policy: annotated(haegressip.EgressInterfaceAnnotation, ".."), denied: haegressip.EgressInterfaceAnnotation},
+
+		{name: "gateway group in interface mode", policy: `{"metadata":{"name":"egress","annotations":{"` + haegressip.EgressGatewayGroupSizeAnnotation + `":"2","` + haegressip.EgressInterfaceAnnotation + `":"eth1"}}}`},
+		{name: "single gateway", policy: annotated(haegressip.EgressGatewayGroupSizeAnnotation, "1")},
+		{name: "gateway group with the egressIP", policy: annotated(haegressip.EgressGatewayGroupSizeAnnotation, "2"), denied: haegressip.EgressGatewayGroupSizeAnnotation},
+		{
+			name:   "gateway group with an empty interface",
+			policy: `{"metadata":{"name":"egress","annotations":{"` + haegressip.EgressGatewayGroupSizeAnnotation + `":"3","` + haegressip.EgressInterfaceAnnotation + `":""}}}`,
+			denied: haegressip.EgressGatewayGroupSizeAnnotation,
+		},
+
+		{name: "preferred exit node", policy: annotated(haegressip.PreferredExitNodeAnnotation, "worker-1.example.com")},
+		{name: "invalid preferred exit node", policy: annotated(haegressip.PreferredExitNodeAnnotation, "Worker_1"), denied: haegressip.PreferredExitNodeAnnotation},
+		{name: "invalid static exit node", policy: annotated(haegressip.StaticExitNodeAnnotation, "-worker"), denied: haegressip.StaticExitNodeAnnotation},
+
+		{name: "default service namespace", policy: annotated(haegressip.HAEgressGatewayPolicyNamespace, "egress-system")},
+		{name: "allowed service namespace", policy: annotated(haegressip.HAEgressGatewayPolicyNamespace, "tenant-a")},
+		{name: "other service namespace", policy: annotated(haegressip.HAEgressGatewayPolicyNamespace, "tenant-b"), denied: haegressip.HAEgressGatewayPolicyNamespace},
+		{name: "excluded service namespace", policy: annotated(haegressip.HAEgressGatewayPolicyNamespace, "kube-system"), denied: haegressip.HAEgressGatewayPolicyNamespace},
+
+		{
+			name:   "allowed source namespace",
+			policy: `{"metadata":{"name":"egress"},"spec":{"selectors":[{"podSelector":{"matchLabels":{"app":"web","` + haegressip.PodNamespaceLabel + `":"team-a"}}}]}}`,
+		},
+		{
+			name:   "unpinned source namespace",
+			policy: `{"metadata":{"name":"egress"},"spec":{"selectors":[{"podSelector":{"matchLabels":{"app":"web"}}},{"namespaceSelector":{"matchExpressions":[]}}]}}`,
+		},
+		{
+			name:   "denied source namespace",
+			policy: `{"metadata":{"name":"egress"},"spec":{"selectors":[{"podSelector":{"matchLabels":{"` + haegressip.PodNamespaceLabel + `":"team-a"}}},{"podSelector":{"matchLabels":{"` + haegressip.PodNamespaceLabel + `":"team-b"}}}]}}`,
+			denied: "allowed source namespaces",
+		},
+		{
+			name:   "source namespace not allowed",
+			policy: `{"metadata":{"name":"egress"},"spec":{"selectors":[{"namespaceSelector":{"matchLabels":{"kubernetes.io/metadata.name":"team-c"}}}]}}`,
+			denied: "allowed source namespaces",
+		},
+
+		{name: "no destinations", policy: destinations()},
+		{name: "subnet of an allowed range", policy: destinations("10.1.2.0/24", "10.255.255.255/32")},
+		{name: "allowed range", policy: destinations("10.0.0.0/8", "192.168.10.0/24")},
+		{name: "half of an allowed range", policy: destinations("192.168.10.128/25")},
+		{name: "allowed address", policy: destinations("203.0.113.7/32")},
+		{name: "supernet of an allowed range", policy: destinations("10.0.0.0/7"), denied: "destinationCIDRs"},
+		{name: "next range", policy: destinations("11.0.0.0/8"), denied: "destinationCIDRs"},
+		{name: "adjacent subnet", policy: destinations("192.168.11.0/24"), denied: "destinationCIDRs"},
+		{name: "previous address", policy: destinations("203.0.113.6/32"), denied: "destinationCIDRs"},
+		{name: "network of an allowed address", policy: destinations("203.0.113.7/31"), denied: "destinationCIDRs"},
+		{name: "default route", policy: destinations("0.0.0.0/0"), denied: "destinationCIDRs"},
+		{name: "one destination outside", policy: destinations("10.0.0.0/16", "172.16.0.0/12"), denied: "destinationCIDRs"},
+		{name: "IPv6 destination", policy: destinations("fd00::/64"), denied: "destinationCIDRs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := denied(t, programs, test.policy)
+			if test.denied == "" {
+				if len(messages) > 0 {
+					t.Errorf("the policy is denied: %v", messages)
+				}
+				return
+			}
+			if len(messages) != 1 || !strings.Contains(messages[0], test.denied) {
+				t.Errorf("the policy is denied with %v, expected only %q", messages, test.denied)
+			}
+		})
+	}
+}
+
+func TestValidationsEveryDestination(t *testing.T) {
+	programs := compile(t, Options{EgressNamespace: "egress-system", AllowedCIDRs: []string{"0.0.0.0/0"}})
+	for _, cidr := range []string{"0.0.0.0/0", "255.255.255.255/32", "128.0.0.0/1"} {
+		policy := `{"metadata":{"name":"egress"},"spec":{"destinationCIDRs":["` + cidr + `"]}}`
+		if messages := denied(t, programs, policy); len(messages) > 0 {
+			t.Errorf("the destination %s is denied: %v", cidr, messages)
+		}
+	}
+}
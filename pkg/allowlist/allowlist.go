@@ -0,0 +1,193 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package allowlist renders the current egress IPs of the policies, grouped by the value
+// of a label, in the formats consumed by the firewalls: CSV, Cisco object-groups and
+// Palo Alto External Dynamic Lists.
+package allowlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Formats of the allow-lists
+const (
+	FormatCSV   = "csv"
+	FormatCisco = "cisco"
+	FormatEDL   = "edl"
+)
+
+// Formats are the supported formats, in the order they are documented
+var Formats = []string{FormatCSV, FormatCisco, FormatEDL}
+
+// DefaultGroup is the group of the policies without the group label
+const DefaultGroup = "default"
+
+// invalidObjectName matches the characters not allowed in the names of the Cisco objects
+var invalidObjectName = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Entry is an egress IP of a policy
+type Entry struct {
+	Policy   string
+	EgressIP string
+}
+
+// Group are the egress IPs of the policies with the same value of the group label
+type Group struct {
+	Name    string
+	Entries []Entry
+}
+
+// IPs returns the sorted egress IPs of the group, without duplicates
+func (g Group) IPs() []string {
+	found := map[string]bool{}
+	ips := []string{}
+	for _, entry := range g.Entries {
+		if !found[entry.EgressIP] {
+			found[entry.EgressIP] = true
+			ips = append(ips, entry.EgressIP)
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Groups returns the egress IPs of the policies grouped by the value of the label, sorted by
+// group and policy. The policies without an egress IP are skipped, the ones without the
+// label are part of DefaultGroup.
+func Groups(ctx context.Context, c client.Reader, label string) ([]Group, error) {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Group{}
+	for _, policy := range policies.Items {
+		if policy.Status.IPAddress == "" {
+			continue
+		}
+		name := policy.Labels[label]
+		if label == "" || name == "" {
+			name = DefaultGroup
+		}
+		group, ok := byName[name]
+		if !ok {
+			group = &Group{Name: name}
+			byName[name] = group
+		}
+		group.Entries = append(group.Entries, Entry{Policy: policy.Name, EgressIP: policy.Status.IPAddress})
+	}
+
+	groups := make([]Group, 0, len(byName))
+	for _, group := range byName {
+		sort.Slice(group.Entries, func(i, j int) bool {
+			return group.Entries[i].Policy < group.Entries[j].Policy
+		})
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// Render returns the groups in the format. The Cisco object-groups are named with the
+// prefix followed by the name of the group.
+func Render(format string, groups []Group, prefix string) ([]byte, error) {
+	switch format {
+	case FormatCSV:
+		return renderCSV(groups)
+	case FormatCisco:
+		return renderCisco(groups, prefix), nil
+	case FormatEDL:
+		return renderEDL(groups), nil
+	}
+	return nil, fmt.Errorf("unknown allow-list format %q, valid formats are %v", format, Formats)
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// renderCSV writes a group,policy,egressIP row for every policy
+func renderCSV(groups []Group) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write([]string{"group", "policy", "egressIP"}); err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		for _, entry := range group.Entries {
+			if err := writer.Write([]string{group.Name, entry.Policy, entry.EgressIP}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// renderCisco writes a network object-group for every group, as accepted by ASA, IOS and
+// NX-OS
+func renderCisco(groups []Group, prefix string) []byte {
+	var buffer bytes.Buffer
+	for _, group := range groups {
+		fmt.Fprintf(&buffer, "object-group network %s\n", invalidObjectName.ReplaceAllString(prefix+group.Name, "_"))
+		fmt.Fprintf(&buffer, " description Egress IPs of the %s policies\n", group.Name)
+		for _, ip := range group.IPs() {
+			fmt.Fprintf(&buffer, " network-object host %s\n", ip)
+		}
+	}
+	return buffer.Bytes()
+}
+
+// renderEDL writes the IP list of a Palo Alto External Dynamic List, one egress IP per
+// line, with the prefix length so the IPv4 and IPv6 addresses are never read as ranges
+func renderEDL(groups []Group) []byte {
+	var buffer bytes.Buffer
+	found := map[string]bool{}
+	ips := []string{}
+	for _, group := range groups {
+		for _, ip := range group.IPs() {
+			if !found[ip] {
+				found[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		length := 128
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+			length = 32
+		}
+		fmt.Fprintf(&buffer, "%s/%d\n", ip, length)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,192 @@
+package allowlist
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var egressIPs = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "haegress_allowlist_egress_ips",
+		Help: "Egress IPs served in the allow-lists, by group",
+	},
+	[]string{"group"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(egressIPs)
+}
+
+// Server serves the allow-lists of the egress IPs on a dedicated address. The groups are
+// rebuilt from the cache of the Manager when a policy changes, and served with an ETag so
+// the firewalls polling the lists download them only when they changed.
+type Server struct {
+	client.Client
+	Log logr.Logger
+
+	BindAddress string
+	// GroupLabel is the label of the policies whose value is the group of their egress IP
+	GroupLabel string
+	// ObjectGroupPrefix is prepended to the name of the groups in the Cisco object-groups
+	ObjectGroupPrefix string
+
+	changed  chan struct{}
+	lock     sync.RWMutex
+	groups   []Group
+	modified time.Time
+}
+
+// SetupWithManager registers the handler of the policy changes on the cache and the
+// server as a runnable of the Manager.
+func (s *Server) SetupWithManager(mgr ctrl.Manager) error {
+	s.changed = make(chan struct{}, 1)
+	informer, err := mgr.GetCache().GetInformer(context.Background(), &haegressv2.HAEgressGatewayPolicy{})
+	if err != nil {
+		return err
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { s.notify() },
+		UpdateFunc: func(interface{}, interface{}) { s.notify() },
+		DeleteFunc: func(interface{}) { s.notify() },
+	}); err != nil {
+		return err
+	}
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica serves
+// the allow-lists from its own cache.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// notify schedules a refresh of the groups, the changes received during a refresh are
+// coalesced in the next one
+func (s *Server) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/allowlist/", s.handle)
+
+	server := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go s.refreshLoop(ctx)
+
+	s.Log.Info("Starting the egress IP allow-lists", "address", s.BindAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// refreshLoop rebuilds the groups at start and on every change of the policies
+func (s *Server) refreshLoop(ctx context.Context) {
+	s.notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.changed:
+			if err := s.refresh(ctx); err != nil {
+				s.Log.Error(err, "unable to refresh the egress IP allow-lists")
+			}
+		}
+	}
+}
+
+// refresh rebuilds the groups, the modification time changes only when they differ
+func (s *Server) refresh(ctx context.Context) error {
+	groups, err := Groups(ctx, s.Client, s.GroupLabel)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.modified.IsZero() || !reflect.DeepEqual(groups, s.groups) {
+		s.Log.V(1).Info("Refreshing the egress IP allow-lists", "groups", len(groups))
+		s.groups = groups
+		s.modified = time.Now()
+		egressIPs.Reset()
+		for _, group := range groups {
+			egressIPs.WithLabelValues(group.Name).Set(float64(len(group.IPs())))
+		}
+	}
+	return nil
+}
+
+// handle serves /allowlist/{format} with every group and /allowlist/{format}/{group} with
+// a single group. A group without egress IPs is an empty list rather than not found, so
+// a firewall does not keep the last egress IPs of a group whose policies were deleted.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/allowlist/"), "/")
+	if strings.Contains(name, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	s.lock.RLock()
+	groups, modified := s.groups, s.modified
+	s.lock.RUnlock()
+	if modified.IsZero() {
+		http.Error(w, "the allow-lists are not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if name != "" {
+		selected := []Group{}
+		for _, group := range groups {
+			if group.Name == name {
+				selected = append(selected, group)
+			}
+		}
+		if len(selected) == 0 {
+			selected = append(selected, Group{Name: name})
+		}
+		groups = selected
+	}
+
+	body, err := Render(format, groups, s.ObjectGroupPrefix)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api serves a read-only HTTP/JSON API with the current egress assignments,
+// for the automation outside the cluster.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/desired"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/whatif"
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Health of an assignment
+const (
+	HealthReady   = "Ready"
+	HealthPending = "Pending"
+)
+
+// Assignment is the mapping of a policy with its health
+type Assignment struct {
+	mapping.Mapping
+	// Health is Ready when the egress IP is assigned to an exit node, Pending otherwise
+	Health string `json:"health"`
+}
+
+// Server serves the egress assignments, read from the cache of the Manager, on a
+// dedicated address. Every request must carry one of the bearer tokens.
+type Server struct {
+	client.Client
+	Log logr.Logger
+
+	BindAddress string
+	// TokensFile contains the accepted bearer tokens, one per line. It is read on every
+	// request so the tokens can be rotated without restarting the operator.
+	TokensFile       string
+	DefaultNamespace string
+	// WhatIf are the options of the failover predictions
+	WhatIf whatif.Options
+	// Desired holds the desired state of the policies interpreted by the replica, empty on
+	// the replicas that are not reconciling
+	Desired *desired.Store
+}
+
+// SetupWithManager registers the server as a runnable of the Manager.
+func (s *Server) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica serves
+// the API from its own cache.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/policies", s.handle(s.policies))
+	mux.HandleFunc("/v1/policies/", s.handle(s.policies))
+	mux.HandleFunc("/v1/ips/", s.handle(s.ips))
+	mux.HandleFunc("/v1/nodes/", s.handle(s.nodes))
+	mux.HandleFunc("/v1/whatif/nodes/", s.whatIf)
+	mux.HandleFunc("/v1/desired/policies", s.desired)
+	mux.HandleFunc("/v1/desired/policies/", s.desired)
+
+	server := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	s.Log.Info("Starting the egress assignments API", "address", s.BindAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// policies serves /v1/policies and /v1/policies/{name}
+func (s *Server) policies(assignments []Assignment, name string) (any, bool) {
+	if name == "" {
+		return assignments, true
+	}
+	for _, assignment := range assignments {
+		if assignment.Policy == name {
+			return assignment, true
+		}
+	}
+	return nil, false
+}
+
+// ips serves /v1/ips/{ip}
+func (s *Server) ips(assignments []Assignment, ip string) (any, bool) {
+	for _, assignment := range assignments {
+		if assignment.EgressIP == ip {
+			return assignment, true
+		}
+	}
+	return nil, false
+}
+
+// nodes serves /v1/nodes/{node}, the assignments whose egress IP leaves from the node
+func (s *Server) nodes(assignments []Assignment, node string) (any, bool) {
+	found := []Assignment{}
+	for _, assignment := range assignments {
+		if assignment.ExitNode == node {
+			found = append(found, assignment)
+		}
+	}
+	return found, true
+}
+
+// handle authenticates the request, loads the assignments and writes the JSON response
+func (s *Server) handle(lookup func([]Assignment, string) (any, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mappings, err := mapping.List(r.Context(), s.Client, s.DefaultNamespace)
+		if err != nil {
+			s.Log.Error(err, "unable to list the egress mappings")
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		assignments := make([]Assignment, 0, len(mappings))
+		for _, m := range mappings {
+			health := HealthPending
+			if m.EgressIP != "" && m.ExitNode != "" {
+				health = HealthReady
+			}
+			assignments = append(assignments, Assignment{Mapping: m, Health: health})
+		}
+
+		// The key is the last element of the path, empty for the collections
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/"), "/", 2)
+		key := ""
+		if len(parts) == 2 {
+			key = parts[1]
+		}
+		response, found := lookup(assignments, key)
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// whatIf serves /v1/whatif/nodes/{node}, the predicted effect of the loss of the node
+func (s *Server) whatIf(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	node := strings.TrimPrefix(r.URL.Path, "/v1/whatif/nodes/")
+	if node == "" || strings.Contains(node, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := whatif.Simulate(r.Context(), s.Client, node, s.WhatIf)
+	if errors.Is(err, whatif.ErrNodeNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.Log.Error(err, "unable to predict the failover", "node", node)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// desired serves /v1/desired/policies and /v1/desired/policies/{name}, the Service and
+// the CiliumEgressGatewayPolicy interpreted from the policies by the replica
+func (s *Server) desired(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/desired/policies"), "/")
+	if strings.Contains(name, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var response any
+	if name == "" {
+		states := s.Desired.List()
+		if states == nil {
+			states = []desired.State{}
+		}
+		response = states
+	} else {
+		state, found := s.Desired.Get(name)
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		response = state
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// authorized checks the bearer token of the request against the tokens file
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	data, err := os.ReadFile(s.TokensFile)
+	if err != nil {
+		s.Log.Error(err, "unable to read the API tokens")
+		return false
+	}
+	for _, accepted := range strings.Split(string(data), "\n") {
+		accepted = strings.TrimSpace(accepted)
+		if accepted != "" && subtle.ConstantTimeCompare([]byte(accepted), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
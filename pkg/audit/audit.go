@@ -0,0 +1,177 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records every change of the egress IP and of the nodeSelector of the
+// CiliumEgressGatewayPolicies in a long-term audit stream, RFC 5424 syslog or HTTPS,
+// independent of the Kubernetes events that expire.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Audited actions
+const (
+	ActionEgressIP     = "UpdateEgressIP"
+	ActionNodeSelector = "UpdateNodeSelector"
+	ActionStart        = "AuditStarted"
+)
+
+// Record is an audited change. The records are chained: every record carries the hash
+// of the previous one, so a removed or modified record breaks the chain, and the
+// sequence has no gaps unless a record is dropped.
+type Record struct {
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Cluster  string    `json:"cluster,omitempty"`
+	// Actor is the operator replica that made the change
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	// Resource is the changed object, kind/namespace/name
+	Resource string `json:"resource,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	// Reason explains why the change was made
+	Reason       string `json:"reason"`
+	PreviousHash string `json:"previousHash"`
+	// Hash is the SHA-256, or the HMAC-SHA-256 when a key is configured, of the record
+	// without the hash
+	Hash string `json:"hash"`
+}
+
+// Sink stores the audit records
+type Sink interface {
+	// Name identifies the sink in the logs
+	Name() string
+	Write(ctx context.Context, record *Record) error
+}
+
+// Auditor chains the records and sends them, in order, to the sinks. Recording never
+// blocks: the records are dropped when the queue is full, leaving a gap in the sequence.
+type Auditor struct {
+	Sinks   []Sink
+	Log     logr.Logger
+	Cluster string
+	Actor   string
+	// Key, if set, signs the chain with HMAC-SHA-256
+	Key []byte
+
+	lock         sync.Mutex
+	sequence     uint64
+	previousHash string
+	queue        chan *Record
+}
+
+// NewAuditor returns an Auditor with a queue of the given size
+func NewAuditor(sinks []Sink, log logr.Logger, cluster, actor string, key []byte, queueSize int) *Auditor {
+	return &Auditor{
+		Sinks:   sinks,
+		Log:     log,
+		Cluster: cluster,
+		Actor:   actor,
+		Key:     key,
+		queue:   make(chan *Record, queueSize),
+	}
+}
+
+// SetupWithManager registers the auditor as a leader-only runnable of the Manager, the
+// changes are made by the leader.
+func (a *Auditor) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(a)
+}
+
+// Record chains and queues the record. A nil Auditor ignores the records.
+func (a *Auditor) Record(record Record) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.sequence++
+	record.Sequence = a.sequence
+	record.Time = time.Now().UTC()
+	record.Cluster = a.Cluster
+	record.Actor = a.Actor
+	record.PreviousHash = a.previousHash
+	record.Hash = ""
+	record.Hash = a.hash(&record)
+	a.previousHash = record.Hash
+
+	select {
+	case a.queue <- &record:
+	default:
+		a.Log.Error(nil, "Audit queue is full, dropping the record", "sequence", record.Sequence, "action", record.Action, "policy", record.Policy)
+	}
+}
+
+func (a *Auditor) hash(record *Record) string {
+	data, _ := json.Marshal(record)
+	if len(a.Key) > 0 {
+		mac := hmac.New(sha256.New, a.Key)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Start implements manager.Runnable and writes the queued records until the context is
+// cancelled. The first record of every chain marks the start of the auditor.
+func (a *Auditor) Start(ctx context.Context) error {
+	a.Record(Record{Action: ActionStart, Reason: "the operator started auditing the egress changes"})
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case record := <-a.queue:
+			for _, sink := range a.Sinks {
+				a.write(ctx, sink, record)
+			}
+		}
+	}
+}
+
+func (a *Auditor) write(ctx context.Context, sink Sink, record *Record) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := sink.Write(writeCtx, record)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= 5 || ctx.Err() != nil {
+			a.Log.Error(err, "unable to write the audit record", "sink", sink.Name(), "sequence", record.Sequence)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
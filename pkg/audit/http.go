@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HTTP posts every record, as JSON, to an HTTPS endpoint
+type HTTP struct {
+	URL string
+	// Token, if set, is sent as bearer token
+	Token  string
+	Client *http.Client
+}
+
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+func (h *HTTP) Write(ctx context.Context, record *Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	httpClient := h.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("audit endpoint failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogPriority is the facility log audit (13) with the severity notice (5)
+const syslogPriority = 13*8 + 5
+
+// syslogSDID is the structured data ID, 32473 is the enterprise number reserved for
+// documentation
+const syslogSDID = "haegress@32473"
+
+// Syslog writes the records as RFC 5424 messages, with the record as JSON message and
+// the main fields as structured data. The address is udp://, tcp:// or tls://host:port,
+// TCP and TLS use the octet counting framing of RFC 6587.
+type Syslog struct {
+	Address string
+
+	lock     sync.Mutex
+	conn     net.Conn
+	hostname string
+}
+
+func (s *Syslog) Name() string {
+	return "syslog"
+}
+
+func (s *Syslog) Write(ctx context.Context, record *Record) error {
+	message, err := s.format(record)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	server, err := url.Parse(s.Address)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		if s.conn, err = s.dial(ctx, server); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+	if server.Scheme != "udp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	if _, err := s.conn.Write([]byte(message)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *Syslog) dial(ctx context.Context, server *url.URL) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch server.Scheme {
+	case "udp", "tcp":
+		return dialer.DialContext(ctx, server.Scheme, server.Host)
+	case "tls":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: server.Hostname(), MinVersion: tls.VersionTLS12}}
+		return tlsDialer.DialContext(ctx, "tcp", server.Host)
+	default:
+		return nil, fmt.Errorf("unsupported syslog address %q, use udp://, tcp:// or tls://", s.Address)
+	}
+}
+
+// format returns the RFC 5424 message of the record
+func (s *Syslog) format(record *Record) (string, error) {
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+		if s.hostname == "" {
+			s.hostname = "-"
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	structuredData := fmt.Sprintf(`[%s sequence="%d" policy="%s" old="%s" new="%s" hash="%s"]`, syslogSDID,
+		record.Sequence, sdEscape(record.Policy), sdEscape(record.Old), sdEscape(record.New), record.Hash)
+	return fmt.Sprintf("<%d>1 %s %s cilium-haegress %d %s %s %s\n", syslogPriority,
+		record.Time.Format(time.RFC3339Nano), s.hostname, os.Getpid(), record.Action, structuredData, data), nil
+}
+
+// sdEscape escapes a structured data parameter value
+func sdEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
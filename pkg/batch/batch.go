@@ -0,0 +1,244 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package batch applies the patches of the controllers in bulk. When a node failure moves
+// hundreds of egress IPs, the patches of the CiliumEgressGatewayPolicies and of the
+// policy statuses are queued instead of being applied one by one by the reconciliations,
+// combined per object and applied by a bounded pool of workers sharing a rate limiter.
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxRetries is the number of attempts of a patch before giving up, the next
+	// reconciliation of the object submits it again
+	maxRetries = 5
+
+	// DefaultWorkers and DefaultQPS apply the two patches, of the CiliumEgressGatewayPolicy
+	// and of the status, of 1000 moved policies in 40 seconds
+	DefaultWorkers = 10
+	DefaultQPS     = 50
+)
+
+// Change is a JSON merge patch of an object, or of its status. The changes are partial,
+// e.g. only the egress IP, so they are not server-side applies: an apply without a field
+// applied before by the same field manager would remove it.
+type Change struct {
+	// Object is the patched object, only its kind and name are used
+	Object client.Object
+	Status bool
+	Patch  map[string]interface{}
+	// Done is called once the patch is applied, with the error of the last attempt
+	// when it failed
+	Done func(error)
+}
+
+// pending is the combination of the changes of the same object not yet applied
+type pending struct {
+	object client.Object
+	status bool
+	patch  map[string]interface{}
+	done   []func(error)
+}
+
+// Patcher applies the submitted changes with Workers concurrent workers, at most QPS
+// patches per second. The changes of the same object submitted before it is patched are
+// merged in one patch, the later ones winning.
+type Patcher struct {
+	Client  client.Client
+	Log     logr.Logger
+	Workers int
+	QPS     float64
+	Burst   int
+
+	lock    sync.Mutex
+	pending map[string]*pending
+	queue   workqueue.RateLimitingInterface
+	limiter *rate.Limiter
+}
+
+// SetupWithManager registers the patcher as a runnable of the Manager, started with the
+// controllers
+func (p *Patcher) SetupWithManager(mgr ctrl.Manager) error {
+	p.pending = make(map[string]*pending)
+	p.queue = workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(),
+		workqueue.RateLimitingQueueConfig{Name: "batch-patcher"})
+	p.limiter = rate.NewLimiter(rate.Limit(p.QPS), p.Burst)
+	return mgr.Add(p)
+}
+
+// SetQPS changes the maximum number of patches per second
+func (p *Patcher) SetQPS(qps float64) {
+	p.limiter.SetLimit(rate.Limit(qps))
+}
+
+// NeedLeaderElection returns false, the changes are submitted only by the controllers
+// running on the replica
+func (p *Patcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Submit queues the change
+func (p *Patcher) Submit(change Change) {
+	key := changeKey(change.Object, change.Status)
+	p.lock.Lock()
+	item, ok := p.pending[key]
+	if !ok {
+		// The object is written by the worker, it must not be shared with the caller
+		item = &pending{object: change.Object.DeepCopyObject().(client.Object), status: change.Status, patch: map[string]interface{}{}}
+		p.pending[key] = item
+	}
+	mergePatch(item.patch, change.Patch)
+	if change.Done != nil {
+		item.done = append(item.done, change.Done)
+	}
+	p.lock.Unlock()
+	p.queue.Add(key)
+}
+
+// Start implements manager.Runnable and applies the changes until the context is
+// cancelled
+func (p *Patcher) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for worker := 0; worker < p.Workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p.next(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	p.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// next applies the next change, returns false when the queue is shut down
+func (p *Patcher) next(ctx context.Context) bool {
+	value, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+	key := value.(string)
+	defer p.queue.Done(key)
+
+	p.lock.Lock()
+	item, ok := p.pending[key]
+	delete(p.pending, key)
+	p.lock.Unlock()
+	if !ok {
+		return true
+	}
+
+	if err := p.limiter.Wait(ctx); err != nil {
+		return false
+	}
+	err := p.apply(ctx, item)
+	if err != nil && p.queue.NumRequeues(key) < maxRetries {
+		p.Log.V(1).Info("Patch failed, retrying", "object", key, "error", err.Error())
+		// Put the change back, unless a newer one was submitted in the meantime
+		p.lock.Lock()
+		if newer, ok := p.pending[key]; ok {
+			mergePatch(item.patch, newer.patch)
+			item.done = append(item.done, newer.done...)
+		}
+		p.pending[key] = item
+		p.lock.Unlock()
+		p.queue.AddRateLimited(key)
+		return true
+	}
+	p.queue.Forget(key)
+	if err != nil {
+		p.Log.Error(err, "unable to patch, giving up", "object", key)
+	}
+	for _, done := range item.done {
+		done(err)
+	}
+	return true
+}
+
+func (p *Patcher) apply(ctx context.Context, item *pending) error {
+	data, err := json.Marshal(item.patch)
+	if err != nil {
+		return err
+	}
+	patch := client.RawPatch(types.MergePatchType, data)
+	if item.status {
+		return p.Client.Status().Patch(ctx, item.object, patch)
+	}
+	return p.Client.Patch(ctx, item.object, patch)
+}
+
+// Apply applies the change with the patcher when not nil, otherwise immediately with
+// the client. The error is returned only when the change is applied immediately.
+func Apply(ctx context.Context, p *Patcher, c client.Client, change Change) error {
+	if p != nil {
+		p.Submit(change)
+		return nil
+	}
+	data, err := json.Marshal(change.Patch)
+	if err == nil {
+		patch := client.RawPatch(types.MergePatchType, data)
+		if change.Status {
+			err = c.Status().Patch(ctx, change.Object, patch)
+		} else {
+			err = c.Patch(ctx, change.Object, patch)
+		}
+	}
+	if change.Done != nil {
+		change.Done(err)
+	}
+	return err
+}
+
+func changeKey(object client.Object, status bool) string {
+	kind := fmt.Sprintf("%T", object)
+	if status {
+		kind += "/status"
+	}
+	return fmt.Sprintf("%s/%s/%s", kind, object.GetNamespace(), object.GetName())
+}
+
+// mergePatch merges the JSON merge patch src in dst, the values of src winning
+func mergePatch(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergePatch(dstMap, srcMap)
+			continue
+		}
+		if srcIsMap {
+			copied := map[string]interface{}{}
+			mergePatch(copied, srcMap)
+			value = copied
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,376 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// The patches are the ones of egressGatewayPatch in util: the egressIP, the nodeSelector
+// of the exit node or of the gateway group, and the interface replacing the egressIP
+const (
+	egressIPPatch      = `{"spec":{"egressGateway":{"egressIP":"10.0.0.7"}}}`
+	exitNodePatch      = `{"spec":{"egressGateway":{"nodeSelector":{"matchLabels":{"kubernetes.io/hostname":"worker-2"}}}}}`
+	interfacePatch     = `{"spec":{"egressGateway":{"egressIP":null,"interface":"eth1"}}}`
+	gatewayGroupPatch  = `{"spec":{"egressGateway":{"nodeSelector":{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["worker-1","worker-2"]}],"matchLabels":{"kubernetes.io/hostname":null}}}}}`
+	gatewayGroupPatch2 = `{"spec":{"egressGateway":{"nodeSelector":{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["worker-2","worker-3"]}]}}}}`
+)
+
+// cegp is the CiliumEgressGatewayPolicy the patches are applied to
+const cegp = `{"spec":{"egressGateway":{"egressIP":"10.0.0.1","nodeSelector":{"matchLabels":{"kubernetes.io/hostname":"worker-1","egress":"true"}}},"destinationCIDRs":["0.0.0.0/0"]}}`
+
+func decode(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return decoded
+}
+
+func encode(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// applyMergePatch applies the JSON merge patch to the document as defined by RFC 7386
+func applyMergePatch(document interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	documentMap, ok := document.(map[string]interface{})
+	if !ok {
+		documentMap = map[string]interface{}{}
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(documentMap, key)
+			continue
+		}
+		documentMap[key] = applyMergePatch(documentMap[key], value)
+	}
+	return documentMap
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patches  []string
+		expected string
+	}{
+		{
+			name:     "the later value wins",
+			patches:  []string{egressIPPatch, `{"spec":{"egressGateway":{"egressIP":"10.0.0.8"}}}`},
+			expected: `{"spec":{"egressGateway":{"egressIP":"10.0.0.8"}}}`,
+		},
+		{
+			name:     "the fields of the same object are combined",
+			patches:  []string{exitNodePatch, egressIPPatch},
+			expected: `{"spec":{"egressGateway":{"egressIP":"10.0.0.7","nodeSelector":{"matchLabels":{"kubernetes.io/hostname":"worker-2"}}}}}`,
+		},
+		{
+			name:     "a deletion replaces a value",
+			patches:  []string{egressIPPatch, interfacePatch},
+			expected: `{"spec":{"egressGateway":{"egressIP":null,"interface":"eth1"}}}`,
+		},
+		{
+			name:     "a value replaces a deletion",
+			patches:  []string{interfacePatch, egressIPPatch},
+			expected: `{"spec":{"egressGateway":{"egressIP":"10.0.0.7","interface":"eth1"}}}`,
+		},
+		{
+			name:     "the deleted label of a gateway group is kept deleted",
+			patches:  []string{exitNodePatch, gatewayGroupPatch},
+			expected: `{"spec":{"egressGateway":{"nodeSelector":{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["worker-1","worker-2"]}],"matchLabels":{"kubernetes.io/hostname":null}}}}}`,
+		},
+		{
+			name:     "the lists are replaced",
+			patches:  []string{gatewayGroupPatch, gatewayGroupPatch2},
+			expected: `{"spec":{"egressGateway":{"nodeSelector":{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["worker-2","worker-3"]}],"matchLabels":{"kubernetes.io/hostname":null}}}}}`,
+		},
+		{
+			name:     "three patches",
+			patches:  []string{gatewayGroupPatch, egressIPPatch, interfacePatch},
+			expected: `{"spec":{"egressGateway":{"egressIP":null,"interface":"eth1","nodeSelector":{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["worker-1","worker-2"]}],"matchLabels":{"kubernetes.io/hostname":null}}}}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := map[string]interface{}{}
+			sequential := interface{}(decode(t, cegp))
+			for _, patch := range test.patches {
+				mergePatch(merged, decode(t, patch))
+				sequential = applyMergePatch(sequential, decode(t, patch))
+			}
+			if actual := encode(t, merged); actual != test.expected {
+				t.Errorf("merged patch\n%s\nexpected\n%s", actual, test.expected)
+			}
+			// The merged patch must have the effect of the patches applied one after the other
+			if actual, expected := encode(t, applyMergePatch(decode(t, cegp), merged)), encode(t, sequential); actual != expected {
+				t.Errorf("the merged patch gives\n%s\nthe patches in sequence\n%s", actual, expected)
+			}
+		})
+	}
+}
+
+func TestMergePatchCopiesTheSource(t *testing.T) {
+	merged := map[string]interface{}{}
+	src := decode(t, exitNodePatch)
+	mergePatch(merged, src)
+	src["spec"].(map[string]interface{})["egressGateway"].(map[string]interface{})["egressIP"] = "10.0.0.9"
+	if actual := encode(t, merged); actual != exitNodePatch {
+		t.Errorf("the merged patch changed with the source: %s", actual)
+	}
+}
+
+func TestPatcherRetryMergesNewerPatch(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := ciliumv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	policy := &ciliumv2.CiliumEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-web"}}
+	if err := json.Unmarshal([]byte(cegp), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	var p *Patcher
+	applied := []string{}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			data, err := patch.Data(obj)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, string(data))
+			if len(applied) == 1 {
+				// The interface is configured while the first patch is failing
+				p.Submit(Change{Object: policy, Patch: decode(t, interfacePatch), Done: func(err error) {
+					if err != nil {
+						t.Errorf("the newer patch failed: %v", err)
+					}
+				}})
+				return errors.New("the server is currently unable to handle the request")
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+	p = &Patcher{
+		Client:  c,
+		Log:     logr.Discard(),
+		pending: map[string]*pending{},
+		queue:   workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+	defer p.queue.ShutDown()
+
+	done := 0
+	p.Submit(Change{Object: policy, Patch: decode(t, egressIPPatch), Done: func(err error) {
+		if err != nil {
+			t.Errorf("the first patch failed: %v", err)
+		}
+		done++
+	}})
+	for i := 0; i < 2; i++ {
+		if !p.next(ctx) {
+			t.Fatal("the queue was shut down")
+		}
+	}
+
+	expected := []string{egressIPPatch, interfacePatch}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("applied the patches\n%v\nexpected\n%v", applied, expected)
+	}
+	if done != 1 {
+		t.Errorf("the first change was completed %d times", done)
+	}
+	if p.queue.Len() != 0 || len(p.pending) != 0 {
+		t.Errorf("changes left after the retry: %d queued, %d pending", p.queue.Len(), len(p.pending))
+	}
+
+	patched := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: policy.Name}, patched); err != nil {
+		t.Fatal(err)
+	}
+	egressGateway := patched.Spec.EgressGateway
+	if egressGateway.EgressIP != "" || egressGateway.Interface != "eth1" || egressGateway.NodeSelector.MatchLabels["kubernetes.io/hostname"] != "worker-1" {
+		t.Errorf("unexpected egress gateway after the retry: %+v", egressGateway)
+	}
+}
+
+// massFailover submits the changes of a failover moving the policies to worker-2: the
+// egressIP and the nodeSelector of every CiliumEgressGatewayPolicy, the exit node and the
+// egress IP of every policy status, queued by different reconciliations
+func massFailover(p *Patcher, cegps []*ciliumv2.CiliumEgressGatewayPolicy, policies []*haegressv2.HAEgressGatewayPolicy, done func(error)) {
+	for i := range cegps {
+		ip := fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+		p.Submit(Change{Object: cegps[i], Patch: map[string]interface{}{
+			"spec": map[string]interface{}{"egressGateway": map[string]interface{}{"egressIP": ip}},
+		}, Done: done})
+		p.Submit(Change{Object: policies[i], Status: true, Patch: map[string]interface{}{
+			"status": map[string]interface{}{"exitNode": "worker-2"},
+		}, Done: done})
+		p.Submit(Change{Object: cegps[i], Patch: map[string]interface{}{
+			"spec": map[string]interface{}{"egressGateway": map[string]interface{}{
+				"nodeSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/hostname": "worker-2"}},
+			}},
+		}, Done: done})
+		p.Submit(Change{Object: policies[i], Status: true, Patch: map[string]interface{}{
+			"status": map[string]interface{}{"ipAddress": ip},
+		}, Done: done})
+	}
+}
+
+// newMassFailoverPatcher returns a patcher of the given number of policies, counting the
+// patches of the client
+func newMassFailoverPatcher(tb testing.TB, count int, qps float64) (*Patcher, client.Client, []*ciliumv2.CiliumEgressGatewayPolicy, []*haegressv2.HAEgressGatewayPolicy, *atomic.Int64) {
+	tb.Helper()
+	scheme := runtime.NewScheme()
+	if err := ciliumv2.AddToScheme(scheme); err != nil {
+		tb.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		tb.Fatal(err)
+	}
+	cegps := make([]*ciliumv2.CiliumEgressGatewayPolicy, count)
+	policies := make([]*haegressv2.HAEgressGatewayPolicy, count)
+	objects := make([]client.Object, 0, 2*count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("egress-%04d", i)
+		cegps[i] = &ciliumv2.CiliumEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-system-" + name}}
+		if err := json.Unmarshal([]byte(cegp), cegps[i]); err != nil {
+			tb.Fatal(err)
+		}
+		policies[i] = &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		policies[i].Status.ExitNode = "worker-1"
+		objects = append(objects, cegps[i], policies[i])
+	}
+	patches := &atomic.Int64{}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).
+		WithStatusSubresource(&haegressv2.HAEgressGatewayPolicy{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patches.Add(1)
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+			SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+				patches.Add(1)
+				return c.Status().Patch(ctx, obj, patch, opts...)
+			},
+		}).Build()
+	p := &Patcher{
+		Client:  c,
+		Log:     logr.Discard(),
+		Workers: DefaultWorkers,
+		pending: map[string]*pending{},
+		queue:   workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		limiter: rate.NewLimiter(rate.Limit(qps), DefaultWorkers),
+	}
+	return p, c, cegps, policies, patches
+}
+
+// runMassFailover applies the changes of the failover and returns once every change is done
+func runMassFailover(tb testing.TB, p *Patcher, cegps []*ciliumv2.CiliumEgressGatewayPolicy, policies []*haegressv2.HAEgressGatewayPolicy) {
+	tb.Helper()
+	var wg sync.WaitGroup
+	wg.Add(4 * len(cegps))
+	failed := atomic.Int64{}
+	massFailover(p, cegps, policies, func(err error) {
+		if err != nil {
+			failed.Add(1)
+		}
+		wg.Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = p.Start(ctx)
+		close(stopped)
+	}()
+	wg.Wait()
+	cancel()
+	<-stopped
+	if failed.Load() > 0 {
+		tb.Errorf("%d changes failed", failed.Load())
+	}
+}
+
+func TestPatcherMassFailover(t *testing.T) {
+	const count = 1200
+	// The rate limiter is lifted, the time of the failover at the default rate is computed
+	// from the number of patches
+	p, c, cegps, policies, patches := newMassFailoverPatcher(t, count, float64(rate.Inf))
+	runMassFailover(t, p, cegps, policies)
+
+	// The two changes of every object are combined in one patch
+	if patches.Load() != 2*count {
+		t.Errorf("%d patches were applied, expected %d", patches.Load(), 2*count)
+	}
+	if duration := time.Duration(float64(patches.Load()) / DefaultQPS * float64(time.Second)); duration >= time.Minute {
+		t.Errorf("the %d policies converge in %s at the default rate, expected less than a minute", count, duration)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < count; i += 199 {
+		patched := &ciliumv2.CiliumEgressGatewayPolicy{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(cegps[i]), patched); err != nil {
+			t.Fatal(err)
+		}
+		ip := fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+		egressGateway := patched.Spec.EgressGateway
+		if egressGateway.EgressIP != ip || egressGateway.NodeSelector.MatchLabels["kubernetes.io/hostname"] != "worker-2" ||
+			egressGateway.NodeSelector.MatchLabels["egress"] != "true" {
+			t.Errorf("unexpected egress gateway of %s: %+v", patched.Name, egressGateway)
+		}
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(policies[i]), policy); err != nil {
+			t.Fatal(err)
+		}
+		if policy.Status.ExitNode != "worker-2" || policy.Status.IPAddress != ip {
+			t.Errorf("unexpected status of %s: %+v", policy.Name, policy.Status)
+		}
+	}
+}
+
+func TestPatcherRateLimit(t *testing.T) {
+	// 40 policies at 400 patches per second take at least 80 patches / 400 = 200ms, minus
+	// the burst
+	p, _, cegps, policies, patches := newMassFailoverPatcher(t, 40, 400)
+	start := time.Now()
+	runMassFailover(t, p, cegps, policies)
+	if elapsed, minimum := time.Since(start), time.Duration(float64(80-DefaultWorkers)/400*float64(time.Second)); elapsed < minimum {
+		t.Errorf("%d patches were applied in %s, the rate limit allows them in %s", patches.Load(), elapsed, minimum)
+	}
+}
+
+func BenchmarkPatcherMassFailover(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		p, _, cegps, policies, _ := newMassFailoverPatcher(b, 1000, float64(rate.Inf))
+		b.StartTimer()
+		runMassFailover(b, p, cegps, policies)
+	}
+}
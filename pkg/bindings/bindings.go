@@ -0,0 +1,176 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bindings exports the egress IPs bound to the policies and restores them in a
+// rebuilt cluster: the restored bindings are kept in a ConfigMap and the operator requests
+// the restored IP when it creates the Service of a policy, so the egress IPs allowed by the
+// external firewalls don't change.
+package bindings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultConfigMapName is the default name of the ConfigMap of the restored bindings
+const DefaultConfigMapName = "haegress-ip-bindings"
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;patch
+
+// Binding is the egress IP of a policy
+type Binding struct {
+	Policy   string `json:"policy"`
+	EgressIP string `json:"egressIP"`
+	// ServiceNamespace and Provider are informative, the restored IP is requested to the
+	// provider of the policy in the restored cluster
+	ServiceNamespace string `json:"serviceNamespace,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+}
+
+// Backup is the exported set of bindings
+type Backup struct {
+	Time     metav1.Time `json:"time"`
+	Bindings []Binding   `json:"bindings"`
+}
+
+// Export returns the bindings of the policies with an egress IP, in policy order
+func Export(ctx context.Context, c client.Reader, defaultNamespace, defaultProvider string) (*Backup, error) {
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	backup := &Backup{Time: metav1.NewTime(time.Now().UTC()), Bindings: []Binding{}}
+	for _, policy := range policies.Items {
+		if policy.Status.IPAddress == "" {
+			continue
+		}
+		binding := Binding{
+			Policy:           policy.Name,
+			EgressIP:         policy.Status.IPAddress,
+			ServiceNamespace: haegressiputil.ServiceNamespace(&policy, defaultNamespace),
+			Provider:         policy.Annotation(haegressip.ProviderAnnotation),
+		}
+		if binding.Provider == "" {
+			binding.Provider = defaultProvider
+		}
+		backup.Bindings = append(backup.Bindings, binding)
+	}
+	sort.Slice(backup.Bindings, func(i, j int) bool {
+		return backup.Bindings[i].Policy < backup.Bindings[j].Policy
+	})
+	return backup, nil
+}
+
+// Validate returns an error when a binding has an invalid IP, or when a policy or an IP
+// is bound twice
+func (b *Backup) Validate() error {
+	policies := map[string]bool{}
+	ips := map[string]string{}
+	for _, binding := range b.Bindings {
+		if binding.Policy == "" {
+			return fmt.Errorf("binding without policy")
+		}
+		if _, err := netip.ParseAddr(binding.EgressIP); err != nil {
+			return fmt.Errorf("invalid egress IP %q of the policy %s", binding.EgressIP, binding.Policy)
+		}
+		if policies[binding.Policy] {
+			return fmt.Errorf("the policy %s is bound twice", binding.Policy)
+		}
+		if other, ok := ips[binding.EgressIP]; ok {
+			return fmt.Errorf("the egress IP %s is bound to %s and %s", binding.EgressIP, other, binding.Policy)
+		}
+		policies[binding.Policy] = true
+		ips[binding.EgressIP] = binding.Policy
+	}
+	return nil
+}
+
+// Store keeps the restored bindings in a ConfigMap, one key per policy with the IP as
+// value, until the Service of the policy is created
+type Store struct {
+	client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader        client.Reader
+	Namespace     string
+	ConfigMapName string
+}
+
+// Lookup returns the restored egress IP of the policy, empty when not restored
+func (s *Store) Lookup(ctx context.Context, policy string) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Name: s.ConfigMapName, Namespace: s.Namespace}, configMap)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return configMap.Data[policy], nil
+}
+
+// Save adds the bindings to the ConfigMap, replacing the ones of the same policies
+func (s *Store) Save(ctx context.Context, bindings []Binding) error {
+	data := make(map[string]interface{}, len(bindings))
+	for _, binding := range bindings {
+		data[binding.Policy] = binding.EgressIP
+	}
+	return s.patch(ctx, data)
+}
+
+// Forget removes the binding of the policy, once its Service requested the IP
+func (s *Store) Forget(ctx context.Context, policy string) error {
+	err := s.patch(ctx, map[string]interface{}{policy: nil})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// patch merges the data in the ConfigMap, created when missing
+func (s *Store) patch(ctx context.Context, data map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.ConfigMapName, Namespace: s.Namespace}}
+	err = s.Patch(ctx, configMap, client.RawPatch(types.MergePatchType, patch))
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	configMap.Data = make(map[string]string, len(data))
+	for key, value := range data {
+		if ip, ok := value.(string); ok {
+			configMap.Data[key] = ip
+		}
+	}
+	if len(configMap.Data) == 0 {
+		// Nothing to add, e.g. a binding forgotten without ConfigMap
+		return err
+	}
+	return s.Create(ctx, configMap)
+}
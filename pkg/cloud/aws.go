@@ -0,0 +1,201 @@
+package cloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AWSName is the name of the AWS provider
+const AWSName = "aws"
+
+const ec2APIVersion = "2016-11-15"
+
+// awsCredentials are the static or temporary credentials used to sign the requests
+type awsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// AWS moves the egress IP, a secondary private IP, to the primary ENI of the node instance.
+// When the policy has the cilium.angeloxx.ch/aws-eip-allocation-id annotation, the Elastic
+// IP is associated with the private IP as well. The credentials are read from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY variables or, on EKS, exchanged for the IRSA
+// web identity token.
+type AWS struct {
+	Region string
+	Client *http.Client
+
+	lock        sync.Mutex
+	credentials *awsCredentials
+}
+
+func (a *AWS) Name() string {
+	return AWSName
+}
+
+type ec2NetworkInterface struct {
+	NetworkInterfaceID string `xml:"networkInterfaceId"`
+	Attachment         struct {
+		InstanceID  string `xml:"instanceId"`
+		DeviceIndex int    `xml:"deviceIndex"`
+	} `xml:"attachment"`
+}
+
+type ec2DescribeNetworkInterfacesResponse struct {
+	NetworkInterfaces []ec2NetworkInterface `xml:"networkInterfaceSet>item"`
+}
+
+func (a *AWS) Holder(ctx context.Context, _ *corev1.Service, ip string) (string, error) {
+	var response ec2DescribeNetworkInterfacesResponse
+	if err := a.ec2(ctx, "DescribeNetworkInterfaces", url.Values{
+		"Filter.1.Name":    {"addresses.private-ip-address"},
+		"Filter.1.Value.1": {ip},
+	}, &response); err != nil {
+		return "", err
+	}
+	for _, networkInterface := range response.NetworkInterfaces {
+		if networkInterface.Attachment.InstanceID != "" {
+			return networkInterface.Attachment.InstanceID, nil
+		}
+	}
+	return "", nil
+}
+
+func (a *AWS) Attach(ctx context.Context, service *corev1.Service, ip string, node *corev1.Node) error {
+	instanceID := node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+	var response ec2DescribeNetworkInterfacesResponse
+	if err := a.ec2(ctx, "DescribeNetworkInterfaces", url.Values{
+		"Filter.1.Name":    {"attachment.instance-id"},
+		"Filter.1.Value.1": {instanceID},
+		"Filter.2.Name":    {"attachment.device-index"},
+		"Filter.2.Value.1": {"0"},
+	}, &response); err != nil {
+		return err
+	}
+	if len(response.NetworkInterfaces) == 0 {
+		return fmt.Errorf("primary network interface of instance %s not found", instanceID)
+	}
+	networkInterfaceID := response.NetworkInterfaces[0].NetworkInterfaceID
+
+	// AllowReassignment moves the IP from the ENI of the previous node
+	if err := a.ec2(ctx, "AssignPrivateIpAddresses", url.Values{
+		"NetworkInterfaceId": {networkInterfaceID},
+		"PrivateIpAddress.1": {ip},
+		"AllowReassignment":  {"true"},
+	}, nil); err != nil {
+		return err
+	}
+
+	if allocationID := service.Annotations[haegressip.AWSEIPAllocationIDAnnotation]; allocationID != "" {
+		if err := a.ec2(ctx, "AssociateAddress", url.Values{
+			"AllocationId":       {allocationID},
+			"NetworkInterfaceId": {networkInterfaceID},
+			"PrivateIpAddress":   {ip},
+			"AllowReassociation": {"true"},
+		}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ec2 calls an action of the EC2 Query API and decodes the XML response
+func (a *AWS) ec2(ctx context.Context, action string, parameters url.Values, response any) error {
+	credentials, err := a.getCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	parameters.Set("Action", action)
+	parameters.Set("Version", ec2APIVersion)
+	body := parameters.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://ec2.%s.amazonaws.com/", a.Region), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if err := signV4(req, body, credentials, a.Region, "ec2", time.Now().UTC()); err != nil {
+		return err
+	}
+
+	data, err := do(a.Client, req)
+	if err != nil {
+		return fmt.Errorf("EC2 %s: %w", action, err)
+	}
+	if response == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, response)
+}
+
+// getCredentials returns the static credentials or the cached IRSA ones, refreshed before they expire
+func (a *AWS) getCredentials(ctx context.Context) (*awsCredentials, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return &awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.credentials != nil && time.Until(a.credentials.Expiration) > 5*time.Minute {
+		return a.credentials, nil
+	}
+
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	token, err := os.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if roleARN == "" || err != nil {
+		return nil, fmt.Errorf("no AWS credentials found, set AWS_ACCESS_KEY_ID or configure IRSA")
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {"cilium-haegress-operator"},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://sts.%s.amazonaws.com/?%s", a.Region, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := do(a.Client, req)
+	if err != nil {
+		return nil, fmt.Errorf("STS AssumeRoleWithWebIdentity: %w", err)
+	}
+	var response struct {
+		Credentials awsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	a.credentials = &response.Credentials
+	return a.credentials, nil
+}
+
+// signV4 signs the request with the AWS Signature Version 4 signer of the AWS SDK
+func signV4(req *http.Request, body string, credentials *awsCredentials, region string, service string, now time.Time) error {
+	payloadHash := sha256.Sum256([]byte(body))
+	return v4.NewSigner().SignHTTP(req.Context(), aws.Credentials{
+		AccessKeyID:     credentials.AccessKeyID,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+	}, req, hex.EncodeToString(payloadHash[:]), service, region, now)
+}
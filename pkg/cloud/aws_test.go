@@ -0,0 +1,100 @@
+package cloud
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4 checks the signatures of the AWS Signature Version 4 test suite, signed with
+// its credentials for the us-east-1 region and the service named "service"
+func TestSignV4(t *testing.T) {
+	credentials := &awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			// The requests of the test suite have no Content-Length, signed by the SDK when set
+			req.ContentLength = 0
+			if err := signV4(req, test.body, credentials, "us-east-1", "service", now); err != nil {
+				t.Fatal(err)
+			}
+			expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" +
+				test.signedHeaders + ", Signature=" + test.signature
+			if authorization := req.Header.Get("Authorization"); authorization != expected {
+				t.Errorf("Authorization is\n%s\nexpected\n%s", authorization, expected)
+			}
+			if date := req.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date is %s", date)
+			}
+		})
+	}
+}
+
+func TestSignV4SessionToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://ec2.eu-west-1.amazonaws.com/", strings.NewReader("Action=DescribeNetworkInterfaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := &awsCredentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	if err := signV4(req, "Action=DescribeNetworkInterfaces", credentials, "eu-west-1", "ec2", time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if token := req.Header.Get("X-Amz-Security-Token"); token != "token" {
+		t.Errorf("X-Amz-Security-Token is %q", token)
+	}
+	if authorization := req.Header.Get("Authorization"); !strings.Contains(authorization, "x-amz-security-token") {
+		t.Errorf("the session token is not signed: %s", authorization)
+	}
+}
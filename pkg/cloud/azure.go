@@ -0,0 +1,309 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AzureName is the name of the Azure provider
+const AzureName = "azure"
+
+const (
+	azureManagementURL = "https://management.azure.com"
+	azureNetworkAPI    = "2023-09-01"
+	azureComputeAPI    = "2023-09-01"
+	// azureIPConfigurationPrefix names the secondary IP configurations managed by the operator
+	azureIPConfigurationPrefix = "haegress-"
+)
+
+// Azure moves the egress IP as a secondary IP configuration between the primary NICs of
+// the node virtual machines or scale set instances. The token is obtained with the AKS
+// workload identity, from the AZURE_CLIENT_ID, AZURE_TENANT_ID and
+// AZURE_FEDERATED_TOKEN_FILE variables. The NICs are written with the ETag they were read
+// with, so a concurrent change of a NIC fails the move instead of being overwritten.
+type Azure struct {
+	SubscriptionID string
+	// ResourceGroup is the resource group of the node NICs, the node resource group on AKS
+	ResourceGroup string
+	Client        *http.Client
+
+	lock        sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func (a *Azure) Name() string {
+	return AzureName
+}
+
+func (a *Azure) Holder(ctx context.Context, _ *corev1.Service, ip string) (string, error) {
+	nic, err := a.findNIC(ctx, ip)
+	if err != nil || nic == nil {
+		return "", err
+	}
+	properties, _ := nic["properties"].(map[string]any)
+	virtualMachine, _ := properties["virtualMachine"].(map[string]any)
+	id, _ := virtualMachine["id"].(string)
+	// The instance IDs of the scale sets are unique only in their scale set
+	if index := strings.Index(strings.ToLower(id), "/virtualmachinescalesets/"); index >= 0 {
+		return id[index+1:], nil
+	}
+	return id[strings.LastIndex(id, "/")+1:], nil
+}
+
+func (a *Azure) Attach(ctx context.Context, _ *corev1.Service, ip string, node *corev1.Node) error {
+	configurationName := azureIPConfigurationPrefix + strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+
+	// Detach the IP from the NIC of the previous node
+	previous, err := a.findNIC(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if previousID, _ := previous["id"].(string); previousID != "" {
+		removeIPConfiguration(previous, configurationName)
+		if err := a.putNIC(ctx, previousID, previous); err != nil {
+			return err
+		}
+	}
+
+	nicID, err := a.primaryNIC(ctx, node)
+	if err != nil {
+		return err
+	}
+	nic := map[string]any{}
+	if err := a.arm(ctx, http.MethodGet, nicID, azureNetworkAPI, "", nil, &nic); err != nil {
+		return err
+	}
+	properties, _ := nic["properties"].(map[string]any)
+	configurations, _ := properties["ipConfigurations"].([]any)
+	if len(configurations) == 0 {
+		return fmt.Errorf("network interface %s has no IP configuration", nicID)
+	}
+	primary, _ := configurations[0].(map[string]any)
+	primaryProperties, _ := primary["properties"].(map[string]any)
+	properties["ipConfigurations"] = append(configurations, map[string]any{
+		"name": configurationName,
+		"properties": map[string]any{
+			"privateIPAllocationMethod": "Static",
+			"privateIPAddress":          ip,
+			"subnet":                    primaryProperties["subnet"],
+			"primary":                   false,
+		},
+	})
+	return a.putNIC(ctx, nicID, nic)
+}
+
+// primaryNIC returns the ID of the primary NIC of the virtual machine or of the scale set
+// instance of the node
+func (a *Azure) primaryNIC(ctx context.Context, node *corev1.Node) (string, error) {
+	index := strings.Index(node.Spec.ProviderID, "/subscriptions/")
+	if index < 0 {
+		return "", fmt.Errorf("node %s has an invalid Azure providerID %q", node.Name, node.Spec.ProviderID)
+	}
+	vmID := node.Spec.ProviderID[index:]
+
+	// The NICs of a scale set instance are listed under the instance
+	if strings.Contains(strings.ToLower(vmID), "/virtualmachinescalesets/") {
+		var nics struct {
+			Value []struct {
+				ID         string `json:"id"`
+				Properties struct {
+					Primary bool `json:"primary"`
+				} `json:"properties"`
+			} `json:"value"`
+		}
+		if err := a.arm(ctx, http.MethodGet, vmID+"/networkInterfaces", azureNetworkAPI, "", nil, &nics); err != nil {
+			return "", err
+		}
+		nicID := ""
+		for _, nic := range nics.Value {
+			if nicID == "" || nic.Properties.Primary {
+				nicID = nic.ID
+			}
+		}
+		if nicID == "" {
+			return "", fmt.Errorf("no network interface found for node %s", node.Name)
+		}
+		return nicID, nil
+	}
+
+	var vm struct {
+		Properties struct {
+			NetworkProfile struct {
+				NetworkInterfaces []struct {
+					ID         string `json:"id"`
+					Properties struct {
+						Primary bool `json:"primary"`
+					} `json:"properties"`
+				} `json:"networkInterfaces"`
+			} `json:"networkProfile"`
+		} `json:"properties"`
+	}
+	if err := a.arm(ctx, http.MethodGet, vmID, azureComputeAPI, "", nil, &vm); err != nil {
+		return "", err
+	}
+	nicID := ""
+	for _, networkInterface := range vm.Properties.NetworkProfile.NetworkInterfaces {
+		if nicID == "" || networkInterface.Properties.Primary {
+			nicID = networkInterface.ID
+		}
+	}
+	if nicID == "" {
+		return "", fmt.Errorf("no network interface found for node %s", node.Name)
+	}
+	return nicID, nil
+}
+
+// putNIC writes the NIC read with its ETag, the write fails when the NIC changed since
+func (a *Azure) putNIC(ctx context.Context, nicID string, nic map[string]any) error {
+	etag, _ := nic["etag"].(string)
+	return a.arm(ctx, http.MethodPut, nicID, azureNetworkAPI, etag, nic, nil)
+}
+
+// findNIC returns the NIC of the resource group, of a virtual machine or of a scale set
+// instance, that has the IP, nil if not found
+func (a *Azure) findNIC(ctx context.Context, ip string) (map[string]any, error) {
+	var nics struct {
+		Value []map[string]any `json:"value"`
+	}
+	path := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkInterfaces", a.SubscriptionID, a.ResourceGroup)
+	if err := a.arm(ctx, http.MethodGet, path, azureNetworkAPI, "", nil, &nics); err != nil {
+		return nil, err
+	}
+
+	// The NICs of the scale set instances are listed by scale set
+	var scaleSets struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	path = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets", a.SubscriptionID, a.ResourceGroup)
+	if err := a.arm(ctx, http.MethodGet, path, azureComputeAPI, "", nil, &scaleSets); err != nil {
+		return nil, err
+	}
+	for _, scaleSet := range scaleSets.Value {
+		var scaleSetNICs struct {
+			Value []map[string]any `json:"value"`
+		}
+		if err := a.arm(ctx, http.MethodGet, scaleSet.ID+"/networkInterfaces", azureNetworkAPI, "", nil, &scaleSetNICs); err != nil {
+			return nil, err
+		}
+		nics.Value = append(nics.Value, scaleSetNICs.Value...)
+	}
+
+	for _, nic := range nics.Value {
+		properties, _ := nic["properties"].(map[string]any)
+		configurations, _ := properties["ipConfigurations"].([]any)
+		for _, configuration := range configurations {
+			configurationProperties, _ := configuration.(map[string]any)["properties"].(map[string]any)
+			if configurationProperties["privateIPAddress"] == ip {
+				return nic, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func removeIPConfiguration(nic map[string]any, name string) {
+	properties, _ := nic["properties"].(map[string]any)
+	configurations, _ := properties["ipConfigurations"].([]any)
+	kept := []any{}
+	for _, configuration := range configurations {
+		if configuration.(map[string]any)["name"] != name {
+			kept = append(kept, configuration)
+		}
+	}
+	properties["ipConfigurations"] = kept
+}
+
+// arm calls the Azure Resource Manager API, with the If-Match header when the etag is set
+func (a *Azure) arm(ctx context.Context, method string, path string, apiVersion string, etag string, payload any, response any) error {
+	token, err := a.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	var body *bytes.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, azureManagementURL+path+"?api-version="+apiVersion, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	data, err := do(a.Client, req)
+	if err != nil {
+		return fmt.Errorf("Azure %w", err)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+// getToken exchanges the federated token of the workload identity for a management token
+func (a *Azure) getToken(ctx context.Context) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.token != "" && time.Until(a.tokenExpiry) > 5*time.Minute {
+		return a.token, nil
+	}
+
+	assertion, err := os.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+	if err != nil {
+		return "", fmt.Errorf("no Azure workload identity found: %w", err)
+	}
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com/"
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {os.Getenv("AZURE_CLIENT_ID")},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+		"scope":                 {azureManagementURL + "/.default"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(authority, "/")+"/"+os.Getenv("AZURE_TENANT_ID")+"/oauth2/v2.0/token",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data, err := do(a.Client, req)
+	if err != nil {
+		return "", fmt.Errorf("Azure token exchange: %w", err)
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", err
+	}
+	a.token = response.AccessToken
+	a.tokenExpiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return a.token, nil
+}
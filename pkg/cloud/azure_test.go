@@ -0,0 +1,203 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testResourceGroup = "/subscriptions/sub/resourceGroups/mc-egress"
+	testScaleSet      = testResourceGroup + "/providers/Microsoft.Compute/virtualMachineScaleSets/aks-egress-vmss"
+	testVMNIC         = testResourceGroup + "/providers/Microsoft.Network/networkInterfaces/worker-1-nic"
+	testScaleSetNIC   = testScaleSet + "/virtualMachines/3/networkInterfaces/aks-egress-vmss"
+)
+
+// testARM is an Azure Resource Manager with a NIC of a virtual machine and a NIC of a
+// scale set instance, whose writes require the current ETag
+type testARM struct {
+	lock sync.Mutex
+	nics map[string]map[string]any
+	// ifMatch are the If-Match headers of the writes of the NICs
+	ifMatch map[string]string
+}
+
+func newTestNIC(id, vmID, etag string, ips ...string) map[string]any {
+	configurations := []any{}
+	for i, ip := range ips {
+		// The secondary IPs are the ones moved by the operator
+		name := "ipconfig1"
+		if i > 0 {
+			name = azureIPConfigurationPrefix + strings.ReplaceAll(ip, ".", "-")
+		}
+		configurations = append(configurations, map[string]any{
+			"name": name,
+			"properties": map[string]any{
+				"privateIPAddress": ip,
+				"subnet":           map[string]any{"id": testResourceGroup + "/subnets/egress"},
+				"primary":          i == 0,
+			},
+		})
+	}
+	return map[string]any{
+		"id":   id,
+		"etag": etag,
+		"properties": map[string]any{
+			"primary":          true,
+			"virtualMachine":   map[string]any{"id": vmID},
+			"ipConfigurations": configurations,
+		},
+	}
+}
+
+func (arm *testARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	arm.lock.Lock()
+	defer arm.lock.Unlock()
+	list := func(ids ...string) {
+		value := []any{}
+		for _, id := range ids {
+			value = append(value, arm.nics[id])
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"value": value})
+	}
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodGet && path == testResourceGroup+"/providers/Microsoft.Network/networkInterfaces":
+		list(testVMNIC)
+	case r.Method == http.MethodGet && path == testResourceGroup+"/providers/Microsoft.Compute/virtualMachineScaleSets":
+		_ = json.NewEncoder(w).Encode(map[string]any{"value": []any{map[string]any{"id": testScaleSet}}})
+	case r.Method == http.MethodGet && path == testScaleSet+"/networkInterfaces",
+		r.Method == http.MethodGet && path == testScaleSet+"/virtualMachines/3/networkInterfaces":
+		list(testScaleSetNIC)
+	case r.Method == http.MethodGet && arm.nics[path] != nil:
+		_ = json.NewEncoder(w).Encode(arm.nics[path])
+	case r.Method == http.MethodPut && arm.nics[path] != nil:
+		arm.ifMatch[path] = r.Header.Get("If-Match")
+		if r.Header.Get("If-Match") != arm.nics[path]["etag"] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		nic := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&nic); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		nic["etag"] = arm.nics[path]["etag"].(string) + "+"
+		arm.nics[path] = nic
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// ips returns the private IPs of the NIC
+func (arm *testARM) ips(id string) []string {
+	arm.lock.Lock()
+	defer arm.lock.Unlock()
+	ips := []string{}
+	properties := arm.nics[id]["properties"].(map[string]any)
+	for _, configuration := range properties["ipConfigurations"].([]any) {
+		ips = append(ips, configuration.(map[string]any)["properties"].(map[string]any)["privateIPAddress"].(string))
+	}
+	return ips
+}
+
+// redirect sends the requests of the management API to the test server
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestAzure(t *testing.T, arm *testARM) *Azure {
+	t.Helper()
+	server := httptest.NewServer(arm)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Azure{
+		SubscriptionID: "sub",
+		ResourceGroup:  "mc-egress",
+		Client:         &http.Client{Transport: redirect{target: target}},
+		token:          "token",
+		tokenExpiry:    time.Now().Add(time.Hour),
+	}
+}
+
+func TestAzureScaleSetInstance(t *testing.T) {
+	ctx := context.Background()
+	arm := &testARM{
+		nics: map[string]map[string]any{
+			testVMNIC:       newTestNIC(testVMNIC, testResourceGroup+"/providers/Microsoft.Compute/virtualMachines/worker-1", `W/"1"`, "10.0.0.4", "10.0.0.100"),
+			testScaleSetNIC: newTestNIC(testScaleSetNIC, testScaleSet+"/virtualMachines/3", `W/"7"`, "10.0.0.5"),
+		},
+		ifMatch: map[string]string{},
+	}
+	azure := newTestAzure(t, arm)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "aks-egress-vmss000003"},
+		Spec:       corev1.NodeSpec{ProviderID: "azure://" + testScaleSet + "/virtualMachines/3"},
+	}
+
+	if holder, err := azure.Holder(ctx, nil, "10.0.0.100"); err != nil || holder != "worker-1" {
+		t.Fatalf("the holder is %q, error %v", holder, err)
+	}
+	if err := azure.Attach(ctx, nil, "10.0.0.100", node); err != nil {
+		t.Fatal(err)
+	}
+	if ips := arm.ips(testVMNIC); strings.Join(ips, ",") != "10.0.0.4" {
+		t.Errorf("the previous NIC has the IPs %v", ips)
+	}
+	if ips := arm.ips(testScaleSetNIC); strings.Join(ips, ",") != "10.0.0.5,10.0.0.100" {
+		t.Errorf("the NIC of the scale set instance has the IPs %v", ips)
+	}
+	if arm.ifMatch[testVMNIC] != `W/"1"` || arm.ifMatch[testScaleSetNIC] != `W/"7"` {
+		t.Errorf("the NICs were written with the ETags %v", arm.ifMatch)
+	}
+
+	// The instance ID is returned with its scale set, the instance IDs are not unique
+	holder, err := azure.Holder(ctx, nil, "10.0.0.100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "virtualMachineScaleSets/aks-egress-vmss/virtualMachines/3" {
+		t.Errorf("the holder is %q", holder)
+	}
+}
+
+func TestAzureConcurrentChange(t *testing.T) {
+	arm := &testARM{
+		nics: map[string]map[string]any{
+			testVMNIC:       newTestNIC(testVMNIC, testResourceGroup+"/providers/Microsoft.Compute/virtualMachines/worker-1", `W/"1"`, "10.0.0.4"),
+			testScaleSetNIC: newTestNIC(testScaleSetNIC, testScaleSet+"/virtualMachines/3", `W/"7"`, "10.0.0.5"),
+		},
+		ifMatch: map[string]string{},
+	}
+	azure := newTestAzure(t, arm)
+
+	// The NIC changes between the read and the write of the operator
+	nic := map[string]any{}
+	if err := azure.arm(context.Background(), http.MethodGet, testScaleSetNIC, azureNetworkAPI, "", nil, &nic); err != nil {
+		t.Fatal(err)
+	}
+	arm.lock.Lock()
+	arm.nics[testScaleSetNIC]["etag"] = `W/"8"`
+	arm.lock.Unlock()
+	if err := azure.putNIC(context.Background(), testScaleSetNIC, nic); err == nil || !strings.Contains(err.Error(), "412") {
+		t.Errorf("the NIC changed meanwhile was overwritten, error %v", err)
+	}
+}
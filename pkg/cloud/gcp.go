@@ -0,0 +1,235 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// GCPName is the name of the GCP provider
+const GCPName = "gcp"
+
+const (
+	gcpComputeURL = "https://compute.googleapis.com/compute/v1"
+	gcpTokenURL   = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+type gcpAliasIPRange struct {
+	IPCidrRange         string `json:"ipCidrRange"`
+	SubnetworkRangeName string `json:"subnetworkRangeName,omitempty"`
+}
+
+type gcpNetworkInterface struct {
+	Name          string            `json:"name"`
+	Fingerprint   string            `json:"fingerprint"`
+	AliasIPRanges []gcpAliasIPRange `json:"aliasIpRanges"`
+}
+
+type gcpInstance struct {
+	Name              string                `json:"name"`
+	Zone              string                `json:"zone"`
+	NetworkInterfaces []gcpNetworkInterface `json:"networkInterfaces"`
+}
+
+type gcpOperation struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  *struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// GCP moves the egress IP as a /32 alias IP range between the first network interface
+// of the node instances. The token is read from the metadata server, so the GKE workload
+// identity can be used.
+type GCP struct {
+	Project string
+	Client  *http.Client
+
+	lock        sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func (g *GCP) Name() string {
+	return GCPName
+}
+
+func (g *GCP) Holder(ctx context.Context, _ *corev1.Service, ip string) (string, error) {
+	instance, err := g.findInstance(ctx, ip)
+	if err != nil || instance == nil {
+		return "", err
+	}
+	return instance.Name, nil
+}
+
+func (g *GCP) Attach(ctx context.Context, _ *corev1.Service, ip string, node *corev1.Node) error {
+	// gce://<project>/<zone>/<instance>
+	parts := strings.Split(strings.TrimPrefix(node.Spec.ProviderID, "gce://"), "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("node %s has an invalid GCE providerID %q", node.Name, node.Spec.ProviderID)
+	}
+	zone, name := parts[1], parts[2]
+
+	// The alias IP must be removed from the previous instance before it can be added
+	previous, err := g.findInstance(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		networkInterface := previous.NetworkInterfaces[0]
+		ranges := []gcpAliasIPRange{}
+		for _, aliasIPRange := range networkInterface.AliasIPRanges {
+			if !gcpRangeMatches(aliasIPRange, ip) {
+				ranges = append(ranges, aliasIPRange)
+			}
+		}
+		networkInterface.AliasIPRanges = ranges
+		previousZone := previous.Zone[strings.LastIndex(previous.Zone, "/")+1:]
+		if err := g.updateNetworkInterface(ctx, previousZone, previous.Name, networkInterface); err != nil {
+			return err
+		}
+	}
+
+	var instance gcpInstance
+	if err := g.compute(ctx, http.MethodGet, fmt.Sprintf("/projects/%s/zones/%s/instances/%s", g.Project, zone, name), nil, &instance); err != nil {
+		return err
+	}
+	if len(instance.NetworkInterfaces) == 0 {
+		return fmt.Errorf("instance %s has no network interface", name)
+	}
+	networkInterface := instance.NetworkInterfaces[0]
+	networkInterface.AliasIPRanges = append(networkInterface.AliasIPRanges, gcpAliasIPRange{IPCidrRange: ip + "/32"})
+	return g.updateNetworkInterface(ctx, zone, name, networkInterface)
+}
+
+// findInstance returns the instance of the project that has the alias IP, nil if not found
+func (g *GCP) findInstance(ctx context.Context, ip string) (*gcpInstance, error) {
+	pageToken := ""
+	for {
+		var response struct {
+			Items map[string]struct {
+				Instances []gcpInstance `json:"instances"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		query := url.Values{"returnPartialSuccess": {"true"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		if err := g.compute(ctx, http.MethodGet, fmt.Sprintf("/projects/%s/aggregated/instances?%s", g.Project, query.Encode()), nil, &response); err != nil {
+			return nil, err
+		}
+		for _, scope := range response.Items {
+			for _, instance := range scope.Instances {
+				if len(instance.NetworkInterfaces) == 0 {
+					continue
+				}
+				for _, aliasIPRange := range instance.NetworkInterfaces[0].AliasIPRanges {
+					if gcpRangeMatches(aliasIPRange, ip) {
+						return &instance, nil
+					}
+				}
+			}
+		}
+		if response.NextPageToken == "" {
+			return nil, nil
+		}
+		pageToken = response.NextPageToken
+	}
+}
+
+// updateNetworkInterface replaces the alias IP ranges of the interface and waits for the operation
+func (g *GCP) updateNetworkInterface(ctx context.Context, zone string, instance string, networkInterface gcpNetworkInterface) error {
+	var operation gcpOperation
+	if err := g.compute(ctx, http.MethodPatch, fmt.Sprintf("/projects/%s/zones/%s/instances/%s/updateNetworkInterface?networkInterface=%s",
+		g.Project, zone, instance, url.QueryEscape(networkInterface.Name)), map[string]any{
+		"aliasIpRanges": networkInterface.AliasIPRanges,
+		"fingerprint":   networkInterface.Fingerprint,
+	}, &operation); err != nil {
+		return err
+	}
+	for operation.Status != "DONE" {
+		if err := g.compute(ctx, http.MethodPost, fmt.Sprintf("/projects/%s/zones/%s/operations/%s/wait", g.Project, zone, operation.Name), nil, &operation); err != nil {
+			return err
+		}
+	}
+	if operation.Error != nil && len(operation.Error.Errors) > 0 {
+		return fmt.Errorf("update of the network interface of %s failed: %s", instance, operation.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+func gcpRangeMatches(aliasIPRange gcpAliasIPRange, ip string) bool {
+	return aliasIPRange.IPCidrRange == ip || aliasIPRange.IPCidrRange == ip+"/32"
+}
+
+// compute calls the Compute Engine API
+func (g *GCP) compute(ctx context.Context, method string, path string, payload any, response any) error {
+	token, err := g.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	body := bytes.NewReader(nil)
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, gcpComputeURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	data, err := do(g.Client, req)
+	if err != nil {
+		return fmt.Errorf("GCP %w", err)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+// getToken returns the access token of the service account, read from the metadata server
+func (g *GCP) getToken(ctx context.Context) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.token != "" && time.Until(g.tokenExpiry) > 5*time.Minute {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	data, err := do(g.Client, req)
+	if err != nil {
+		return "", fmt.Errorf("GCP metadata token: %w", err)
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", err
+	}
+	g.token = response.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return g.token, nil
+}
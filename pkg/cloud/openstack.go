@@ -0,0 +1,269 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// OpenStackName is the name of the OpenStack provider
+const OpenStackName = "openstack"
+
+type neutronAddressPair struct {
+	IPAddress  string `json:"ip_address"`
+	MACAddress string `json:"mac_address,omitempty"`
+}
+
+type neutronPort struct {
+	ID                  string               `json:"id"`
+	DeviceID            string               `json:"device_id"`
+	AllowedAddressPairs []neutronAddressPair `json:"allowed_address_pairs"`
+}
+
+// OpenStack moves the egress IP between the Neutron ports of the node servers, as an
+// allowed address pair, so the port security accepts the traffic sourced by the IP.
+// When the policy has the cilium.angeloxx.ch/openstack-floating-ip annotation, the
+// floating IP is associated with the egress IP on the port of the node as well. The
+// credentials are read from the OS_AUTH_URL, OS_REGION_NAME and either the
+// OS_APPLICATION_CREDENTIAL_ID/OS_APPLICATION_CREDENTIAL_SECRET or the
+// OS_USERNAME/OS_PASSWORD/OS_PROJECT_ID/OS_USER_DOMAIN_NAME variables.
+type OpenStack struct {
+	// NetworkID limits the ports to the network of the nodes
+	NetworkID string
+	Client    *http.Client
+
+	lock        sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	endpoint    string
+}
+
+func (o *OpenStack) Name() string {
+	return OpenStackName
+}
+
+func (o *OpenStack) Holder(ctx context.Context, _ *corev1.Service, ip string) (string, error) {
+	port, err := o.findPort(ctx, ip)
+	if err != nil || port == nil {
+		return "", err
+	}
+	return port.DeviceID, nil
+}
+
+func (o *OpenStack) Attach(ctx context.Context, service *corev1.Service, ip string, node *corev1.Node) error {
+	serverID := node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+
+	// Remove the address pair from the port of the previous node
+	previous, err := o.findPort(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		pairs := []neutronAddressPair{}
+		for _, pair := range previous.AllowedAddressPairs {
+			if pair.IPAddress != ip {
+				pairs = append(pairs, pair)
+			}
+		}
+		if err := o.updateAddressPairs(ctx, previous.ID, pairs); err != nil {
+			return err
+		}
+	}
+
+	ports, err := o.listPorts(ctx, url.Values{"device_id": {serverID}})
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("no Neutron port found for server %s", serverID)
+	}
+	port := ports[0]
+	if err := o.updateAddressPairs(ctx, port.ID, append(port.AllowedAddressPairs, neutronAddressPair{IPAddress: ip})); err != nil {
+		return err
+	}
+
+	if floatingIP := service.Annotations[haegressip.OpenStackFloatingIPAnnotation]; floatingIP != "" {
+		return o.neutron(ctx, http.MethodPut, "/v2.0/floatingips/"+url.PathEscape(floatingIP), map[string]any{
+			"floatingip": map[string]any{
+				"port_id":          port.ID,
+				"fixed_ip_address": ip,
+			},
+		}, nil)
+	}
+	return nil
+}
+
+// findPort returns the port that has the IP as allowed address pair, nil if not found
+func (o *OpenStack) findPort(ctx context.Context, ip string) (*neutronPort, error) {
+	query := url.Values{}
+	if o.NetworkID != "" {
+		query.Set("network_id", o.NetworkID)
+	}
+	ports, err := o.listPorts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ports {
+		for _, pair := range ports[i].AllowedAddressPairs {
+			if pair.IPAddress == ip || pair.IPAddress == ip+"/32" {
+				return &ports[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (o *OpenStack) listPorts(ctx context.Context, query url.Values) ([]neutronPort, error) {
+	var response struct {
+		Ports []neutronPort `json:"ports"`
+	}
+	if err := o.neutron(ctx, http.MethodGet, "/v2.0/ports?"+query.Encode(), nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Ports, nil
+}
+
+func (o *OpenStack) updateAddressPairs(ctx context.Context, portID string, pairs []neutronAddressPair) error {
+	return o.neutron(ctx, http.MethodPut, "/v2.0/ports/"+url.PathEscape(portID), map[string]any{
+		"port": map[string]any{
+			"allowed_address_pairs": pairs,
+		},
+	}, nil)
+}
+
+// neutron calls the Neutron API
+func (o *OpenStack) neutron(ctx context.Context, method string, path string, payload any, response any) error {
+	token, endpoint, err := o.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	body := bytes.NewReader(nil)
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(endpoint, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", token)
+
+	data, err := do(o.Client, req)
+	if err != nil {
+		return fmt.Errorf("Neutron %w", err)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+// authenticate returns a Keystone token and the Neutron endpoint of the region
+func (o *OpenStack) authenticate(ctx context.Context) (string, string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.token != "" && time.Until(o.tokenExpiry) > 5*time.Minute {
+		return o.token, o.endpoint, nil
+	}
+
+	identity := map[string]any{}
+	scope := map[string]any(nil)
+	if os.Getenv("OS_APPLICATION_CREDENTIAL_ID") != "" {
+		identity["methods"] = []string{"application_credential"}
+		identity["application_credential"] = map[string]any{
+			"id":     os.Getenv("OS_APPLICATION_CREDENTIAL_ID"),
+			"secret": os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET"),
+		}
+	} else {
+		identity["methods"] = []string{"password"}
+		identity["password"] = map[string]any{
+			"user": map[string]any{
+				"name":     os.Getenv("OS_USERNAME"),
+				"password": os.Getenv("OS_PASSWORD"),
+				"domain":   map[string]any{"name": os.Getenv("OS_USER_DOMAIN_NAME")},
+			},
+		}
+		scope = map[string]any{"project": map[string]any{"id": os.Getenv("OS_PROJECT_ID")}}
+	}
+	auth := map[string]any{"identity": identity}
+	if scope != nil {
+		auth["scope"] = scope
+	}
+	data, err := json.Marshal(map[string]any{"auth": auth})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(os.Getenv("OS_AUTH_URL"), "/")+"/auth/tokens", bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := o.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("Keystone authentication failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", "", err
+	}
+	endpoint := ""
+	for _, service := range response.Token.Catalog {
+		if service.Type != "network" {
+			continue
+		}
+		for _, candidate := range service.Endpoints {
+			if candidate.Interface == "public" && (os.Getenv("OS_REGION_NAME") == "" || candidate.Region == os.Getenv("OS_REGION_NAME")) {
+				endpoint = candidate.URL
+			}
+		}
+	}
+	if endpoint == "" {
+		return "", "", fmt.Errorf("no Neutron endpoint found in the Keystone catalog")
+	}
+
+	o.token = resp.Header.Get("X-Subject-Token")
+	o.tokenExpiry = response.Token.ExpiresAt
+	o.endpoint = endpoint
+	return o.token, o.endpoint, nil
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clustercidrs discovers the IPv4 ranges internal to the cluster, the pod, service
+// and node CIDRs, added to the excludedCIDRs of the generated CiliumEgressGatewayPolicies
+// so the east-west traffic is never hair-pinned through the exit node. The ranges are
+// read from the CiliumNodes, the Nodes, the Cilium configuration and the kubeadm
+// configuration, and discovered again on a schedule: the policies are queued when they
+// change.
+package clustercidrs
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ciliumConfigMapName  = "cilium-config"
+	kubeadmConfigMapName = "kubeadm-config"
+	kubeadmNamespace     = "kube-system"
+)
+
+var discovered = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "haegress_cluster_cidrs",
+		Help: "Number of the cluster-internal IPv4 CIDRs excluded from the CiliumEgressGatewayPolicies",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(discovered)
+}
+
+// +kubebuilder:rbac:groups=cilium.io,resources=ciliumnodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",namespace=kube-system,resources=configmaps,verbs=get
+
+// Cache holds the cluster-internal CIDRs, discovered again every IntervalSeconds
+type Cache struct {
+	Reader client.Reader
+	Log    logr.Logger
+	// CiliumNamespace holds the cilium-config ConfigMap
+	CiliumNamespace string
+	// Static are added to the discovered CIDRs, e.g. the service CIDR of a managed
+	// cluster without kubeadm configuration
+	Static          []string
+	IntervalSeconds int
+	// Events receives every policy when the CIDRs change
+	Events chan event.GenericEvent
+	// LeaderElection is false in sharding mode, where every replica reconciles its own
+	// policies
+	LeaderElection bool
+
+	mu         sync.Mutex
+	discovered bool
+	cidrs      []netip.Prefix
+}
+
+// SetupWithManager registers the cache as a runnable of the Manager.
+func (c *Cache) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false in sharding mode
+func (c *Cache) NeedLeaderElection() bool {
+	return c.LeaderElection
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (c *Cache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(c.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if c.refresh(ctx) {
+				c.queue(ctx)
+			}
+		}
+	}
+}
+
+// Excluded returns the cluster-internal CIDRs overlapping the destinations, sorted, so
+// the CiliumEgressGatewayPolicy carries only the exclusions applied by Cilium. The CIDRs
+// are discovered now when never discovered.
+func (c *Cache) Excluded(ctx context.Context, destinations []ciliumv2.IPv4CIDR) []string {
+	c.mu.Lock()
+	done := c.discovered
+	c.mu.Unlock()
+	if !done {
+		c.refresh(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	excluded := []string{}
+	for _, cidr := range c.cidrs {
+		for _, destination := range destinations {
+			prefix, err := netip.ParsePrefix(string(destination))
+			if err == nil && prefix.Overlaps(cidr) {
+				excluded = append(excluded, cidr.String())
+				break
+			}
+		}
+	}
+	return excluded
+}
+
+// refresh discovers the CIDRs and returns true when they changed. A source that can't be
+// read is logged and the others are applied, the static CIDRs are always kept.
+func (c *Cache) refresh(ctx context.Context) bool {
+	found := []netip.Prefix{}
+	found = append(found, parse(c.Static)...)
+	for _, discover := range []func(context.Context) ([]string, error){c.ciliumNodes, c.nodes, c.ciliumConfig, c.kubeadmConfig} {
+		cidrs, err := discover(ctx)
+		if err != nil {
+			c.Log.V(1).Info("Unable to discover the cluster CIDRs", "error", err.Error())
+			continue
+		}
+		found = append(found, parse(cidrs)...)
+	}
+	found = collapse(found)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.discovered && !equal(c.cidrs, found)
+	if !c.discovered || changed {
+		c.Log.Info("Cluster CIDRs discovered", "cidrs", found)
+	}
+	c.discovered = true
+	c.cidrs = found
+	discovered.Set(float64(len(found)))
+	return changed
+}
+
+// queue sends every policy to the Events channel
+func (c *Cache) queue(ctx context.Context) {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.Reader.List(ctx, &policies); err != nil {
+		c.Log.Error(err, "unable to list the policies to queue after the change of the cluster CIDRs")
+		return
+	}
+	for _, policy := range policies.Items {
+		object := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: policy.Name}}
+		select {
+		case c.Events <- event.GenericEvent{Object: object}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ciliumNodes returns the pod CIDRs and the internal addresses of the CiliumNodes
+func (c *Cache) ciliumNodes(ctx context.Context) ([]string, error) {
+	var nodes ciliumv2.CiliumNodeList
+	if err := c.Reader.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("unable to list the CiliumNodes: %w", err)
+	}
+	cidrs := []string{}
+	for _, node := range nodes.Items {
+		cidrs = append(cidrs, node.Spec.IPAM.PodCIDRs...)
+		for _, address := range node.Spec.Addresses {
+			if address.Type == addressing.NodeInternalIP {
+				cidrs = append(cidrs, address.IP)
+			}
+		}
+	}
+	return cidrs, nil
+}
+
+// nodes returns the pod CIDRs and the internal addresses of the Nodes
+func (c *Cache) nodes(ctx context.Context) ([]string, error) {
+	var nodes corev1.NodeList
+	if err := c.Reader.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("unable to list the Nodes: %w", err)
+	}
+	cidrs := []string{}
+	for _, node := range nodes.Items {
+		cidrs = append(cidrs, node.Spec.PodCIDRs...)
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				cidrs = append(cidrs, address.Address)
+			}
+		}
+	}
+	return cidrs, nil
+}
+
+// ciliumConfig returns the pod CIDRs of the cluster-pool IPAM of the Cilium configuration.
+// The native routing CIDR is not used, it often covers the whole datacenter network.
+func (c *Cache) ciliumConfig(ctx context.Context) ([]string, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := c.Reader.Get(ctx, types.NamespacedName{Name: ciliumConfigMapName, Namespace: c.CiliumNamespace}, configMap); err != nil {
+		return nil, fmt.Errorf("unable to read %s/%s: %w", c.CiliumNamespace, ciliumConfigMapName, err)
+	}
+	return strings.Fields(configMap.Data["cluster-pool-ipv4-cidr"]), nil
+}
+
+// kubeadmConfig returns the pod and service subnets of the kubeadm ClusterConfiguration,
+// nothing when the cluster is not installed with kubeadm
+func (c *Cache) kubeadmConfig(ctx context.Context) ([]string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Reader.Get(ctx, types.NamespacedName{Name: kubeadmConfigMapName, Namespace: kubeadmNamespace}, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read %s/%s: %w", kubeadmNamespace, kubeadmConfigMapName, err)
+	}
+	config := struct {
+		Networking struct {
+			PodSubnet     string `json:"podSubnet"`
+			ServiceSubnet string `json:"serviceSubnet"`
+		} `json:"networking"`
+	}{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["ClusterConfiguration"]), &config); err != nil {
+		return nil, fmt.Errorf("invalid ClusterConfiguration in %s/%s: %w", kubeadmNamespace, kubeadmConfigMapName, err)
+	}
+	cidrs := strings.Split(config.Networking.PodSubnet, ",")
+	return append(cidrs, strings.Split(config.Networking.ServiceSubnet, ",")...), nil
+}
+
+// parse returns the IPv4 prefixes of the CIDRs and of the addresses, as /32, skipping the
+// IPv6 and the invalid ones
+func parse(values []string) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			address, err := netip.ParseAddr(value)
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(address, address.BitLen())
+		}
+		if prefix.Addr().Is4() {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes
+}
+
+// collapse returns the prefixes sorted, without the ones contained in another one
+func collapse(prefixes []netip.Prefix) []netip.Prefix {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Bits() != prefixes[j].Bits() {
+			return prefixes[i].Bits() < prefixes[j].Bits()
+		}
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+	collapsed := []netip.Prefix{}
+	for _, prefix := range prefixes {
+		contained := false
+		for _, kept := range collapsed {
+			if kept.Contains(prefix.Addr()) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			collapsed = append(collapsed, prefix)
+		}
+	}
+	sort.Slice(collapsed, func(i, j int) bool {
+		return collapsed[i].Addr().Less(collapsed[j].Addr())
+	})
+	return collapsed
+}
+
+// equal returns true when the two sorted lists have the same prefixes
+func equal(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clustermesh publishes the egress IP mappings of the local cluster so that
+// they can be shared with the peer clusters of a Cilium ClusterMesh.
+package clustermesh
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;update
+
+// Mapping is the egress IP assigned to a HAEgressGatewayPolicy in a cluster
+type Mapping struct {
+	Policy   string `json:"policy"`
+	EgressIP string `json:"egressIP"`
+	ExitNode string `json:"exitNode,omitempty"`
+}
+
+// Publisher periodically writes the egress IP mappings of the cluster in a ConfigMap,
+// under a key named after the cluster.
+type Publisher struct {
+	client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader client.Reader
+	Log    logr.Logger
+
+	ClusterName     string
+	Namespace       string
+	ConfigMapName   string
+	IntervalSeconds int
+}
+
+// SetupWithManager registers the publisher as a leader-only runnable of the Manager.
+func (p *Publisher) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(p)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (p *Publisher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(p.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := p.publish(ctx); err != nil {
+				p.Log.Error(err, "unable to publish the egress IP mappings", "ConfigMap", p.ConfigMapName)
+			}
+		}
+	}
+}
+
+func (p *Publisher) publish(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := p.List(ctx, &policies); err != nil {
+		return err
+	}
+
+	mappings := []Mapping{}
+	for _, policy := range policies.Items {
+		if policy.Status.IPAddress == "" {
+			continue
+		}
+		mappings = append(mappings, Mapping{
+			Policy:   policy.Name,
+			EgressIP: policy.Status.IPAddress,
+			ExitNode: policy.Status.ExitNode,
+		})
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Policy < mappings[j].Policy
+	})
+	data, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+
+	key := p.ClusterName
+	if key == "" {
+		key = "default"
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = p.Reader.Get(ctx, types.NamespacedName{Name: p.ConfigMapName, Namespace: p.Namespace}, configMap)
+	if err != nil && apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.ConfigMapName,
+				Namespace: p.Namespace,
+			},
+			Data: map[string]string{key: string(data)},
+		}
+		p.Log.Info("Creating the ClusterMesh egress IP mappings ConfigMap", "ConfigMap", p.ConfigMapName)
+		return p.Create(ctx, configMap)
+	} else if err != nil {
+		return err
+	}
+
+	if configMap.Data[key] == string(data) {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[key] = string(data)
+	p.Log.V(1).Info("Updating the ClusterMesh egress IP mappings", "ConfigMap", p.ConfigMapName, "mappings", len(mappings))
+	return p.Update(ctx, configMap)
+}
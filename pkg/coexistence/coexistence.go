@@ -0,0 +1,450 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package coexistence detects the other controllers fighting with the operator over the
+// fields of the CiliumEgressGatewayPolicies it writes, e.g. two releases of the operator
+// running at the same time, or a GitOps tool applying its own version of the objects.
+// Every value written by the operator is remembered for a while: when it is replaced by
+// another writer, read from the API server with the managedFields, the field is reverted.
+// After too many reverts within a window the operator stops writing the object for a
+// backoff, doubled while the fight goes on, instead of entering an update war.
+package coexistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ConditionConflictingController is True while another controller reverts the fields
+	// of the objects of the policy written by the operator
+	ConditionConflictingController = "ConflictingController"
+	// ReasonFieldsReverted is the reason of the ConflictingController condition True
+	ReasonFieldsReverted = "FieldsReverted"
+	// ReasonNoConflict is the reason of the ConflictingController condition False
+	ReasonNoConflict = "NoConflict"
+)
+
+// MaxBackoff is the longest pause of the writes of a conflicting object
+const MaxBackoff = time.Hour
+
+var (
+	reverts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_conflicting_writes_total",
+			Help: "Fields written by the operator and reverted by another controller by kind of object and field manager of the other controller",
+		},
+		[]string{"kind", "manager"},
+	)
+
+	conflicting = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_conflicting_objects",
+			Help: "Objects whose writes are paused, or were recently, because another controller reverts them",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(reverts, conflicting)
+}
+
+// Field is a field of the objects written by the operator
+type Field struct {
+	// Path is the path of the field in the object, as in the managedFields
+	Path []string
+	// Value returns the value of the field of the object
+	Value func(client.Object) interface{}
+}
+
+func (f Field) String() string {
+	return strings.Join(f.Path, ".")
+}
+
+// The fields of the CiliumEgressGatewayPolicies written by the operator
+var (
+	EgressIP = Field{
+		Path:  []string{"spec", "egressGateway", "egressIP"},
+		Value: egressGatewayField(func(g *ciliumv2.EgressGateway) interface{} { return g.EgressIP }),
+	}
+	NodeSelector = Field{
+		Path:  []string{"spec", "egressGateway", "nodeSelector"},
+		Value: egressGatewayField(func(g *ciliumv2.EgressGateway) interface{} { return g.NodeSelector }),
+	}
+	Interface = Field{
+		Path:  []string{"spec", "egressGateway", "interface"},
+		Value: egressGatewayField(func(g *ciliumv2.EgressGateway) interface{} { return g.Interface }),
+	}
+	Selectors = Field{
+		Path:  []string{"spec", "selectors"},
+		Value: specField(func(s *ciliumv2.CiliumEgressGatewayPolicySpec) interface{} { return s.Selectors }),
+	}
+	DestinationCIDRs = Field{
+		Path:  []string{"spec", "destinationCIDRs"},
+		Value: specField(func(s *ciliumv2.CiliumEgressGatewayPolicySpec) interface{} { return s.DestinationCIDRs }),
+	}
+	ExcludedCIDRs = Field{
+		Path:  []string{"spec", "excludedCIDRs"},
+		Value: specField(func(s *ciliumv2.CiliumEgressGatewayPolicySpec) interface{} { return s.ExcludedCIDRs }),
+	}
+)
+
+func specField(value func(*ciliumv2.CiliumEgressGatewayPolicySpec) interface{}) func(client.Object) interface{} {
+	return func(object client.Object) interface{} {
+		policy, ok := object.(*ciliumv2.CiliumEgressGatewayPolicy)
+		if !ok {
+			return nil
+		}
+		return value(&policy.Spec)
+	}
+}
+
+func egressGatewayField(value func(*ciliumv2.EgressGateway) interface{}) func(client.Object) interface{} {
+	return specField(func(spec *ciliumv2.CiliumEgressGatewayPolicySpec) interface{} {
+		if spec.EgressGateway == nil {
+			return nil
+		}
+		return value(spec.EgressGateway)
+	})
+}
+
+// Conflict is another controller reverting the fields of an object written by the
+// operator
+type Conflict struct {
+	Kind string
+	Name string
+	// Manager is the field manager of the last revert, the one of the operator when the
+	// other controller is another instance of the operator, then Operator is true
+	Manager  string
+	Operator bool
+	Fields   []string
+	// Reverts is the number of reverts within the window that started the backoff
+	Reverts int
+	Backoff time.Duration
+	Until   time.Time
+}
+
+// Message describes the conflict
+func (c *Conflict) Message() string {
+	manager := fmt.Sprintf("the field manager %s", c.Manager)
+	if c.Operator {
+		manager = fmt.Sprintf("another instance of the operator (field manager %s)", c.Manager)
+	} else if c.Manager == "" {
+		manager = "an unknown field manager"
+	}
+	return fmt.Sprintf("%s %s reverted %d times by %s (%s), the operator stops writing it for %s",
+		c.Kind, c.Name, c.Reverts, manager, strings.Join(c.Fields, ", "), c.Backoff)
+}
+
+// Verdict is the outcome of the check of an object before it is written
+type Verdict struct {
+	// Wait is how long the operator must not write the object, zero when it can write it
+	Wait time.Duration
+	// Recheck is when the conflict can be resolved, once the object is not reverted
+	// anymore, zero without a conflict
+	Recheck time.Duration
+	// Conflict is the conflict of the object, nil when there is none
+	Conflict *Conflict
+	// Changed is true when the conflict started, was renewed or ended with this check
+	Changed bool
+}
+
+// Condition returns the ConflictingController condition of the verdict
+func (v Verdict) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionConflictingController,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonNoConflict,
+		Message:            "No other controller reverts the objects of the policy",
+		ObservedGeneration: generation,
+	}
+	if v.Conflict != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonFieldsReverted
+		condition.Message = v.Conflict.Message()
+	}
+	return condition
+}
+
+// Guard counts the reverts of the fields written by the operator and pauses the writes
+// of the objects reverted at least Reverts times within Window. A nil Guard, or one with
+// Reverts zero, allows every write.
+type Guard struct {
+	// Reader reads the objects from the API server, the managedFields are stripped from
+	// the cache
+	Reader client.Reader
+	// FieldManager is the field manager of the operator
+	FieldManager string
+	Reverts      int
+	Window       time.Duration
+	// Backoff is the first pause of the writes of a conflicting object, doubled when the
+	// object is reverted again after it, up to MaxBackoff
+	Backoff time.Duration
+
+	mu sync.Mutex
+	// objects holds the values recently written and the conflicts by object
+	objects map[string]*tracked
+}
+
+// tracked is the state of an object written by the operator
+type tracked struct {
+	// written holds the fingerprint of the value of every field written within the window
+	written   map[string]string
+	writtenAt time.Time
+	reverts   []time.Time
+	conflict  *Conflict
+	backoff   time.Duration
+}
+
+func (g *Guard) enabled() bool {
+	return g != nil && g.Reverts > 0
+}
+
+// Written records the values of the fields of the object written by the operator, the
+// object holds the written values
+func (g *Guard) Written(object client.Object, fields ...Field) {
+	if !g.enabled() {
+		return
+	}
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.objects == nil {
+		g.objects = make(map[string]*tracked)
+	}
+	// The objects not written within the window can't be in an update war
+	for key, t := range g.objects {
+		if t.conflict == nil && now.Sub(t.writtenAt) >= g.Window {
+			delete(g.objects, key)
+		}
+	}
+	key := objectKey(object)
+	t, ok := g.objects[key]
+	if !ok {
+		t = &tracked{written: make(map[string]string)}
+		g.objects[key] = t
+	}
+	for _, field := range fields {
+		t.written[field.String()] = fingerprint(field.Value(object))
+	}
+	t.writtenAt = now
+}
+
+// Check returns whether the operator can write the fields of the object, observed in the
+// cache. A field observed with a value other than the one written by the operator is read
+// again from the API server, and counted as reverted when it still differs.
+func (g *Guard) Check(ctx context.Context, object client.Object, fields ...Field) Verdict {
+	if !g.enabled() {
+		return Verdict{}
+	}
+	key := objectKey(object)
+	g.mu.Lock()
+	suspect := false
+	if t, ok := g.objects[key]; ok {
+		for _, field := range fields {
+			if written, ok := t.written[field.String()]; ok && written != fingerprint(field.Value(object)) {
+				suspect = true
+			}
+		}
+	}
+	g.mu.Unlock()
+
+	// The cache can be older than the last write, the managedFields are read too
+	var live client.Object
+	if suspect {
+		live = object.DeepCopyObject().(client.Object)
+		if err := g.Reader.Get(ctx, client.ObjectKeyFromObject(object), live); err != nil {
+			if apierrors.IsNotFound(err) {
+				g.forget(key)
+			}
+			return g.verdict(key, nil, nil, "")
+		}
+	}
+
+	g.mu.Lock()
+	var reverted []string
+	if t, ok := g.objects[key]; ok && live != nil {
+		for _, field := range fields {
+			value := fingerprint(field.Value(live))
+			if written, ok := t.written[field.String()]; ok && written != value {
+				reverted = append(reverted, field.String())
+				// The field is tracked again once the operator writes it, so the same revert is
+				// counted once, and not the changes of the other controller meanwhile
+				delete(t.written, field.String())
+			}
+		}
+	}
+	g.mu.Unlock()
+	if len(reverted) == 0 {
+		return g.verdict(key, nil, nil, "")
+	}
+	manager := lastManager(live, fields, reverted)
+	reverts.WithLabelValues(kindOf(object), manager).Inc()
+	return g.verdict(key, object, reverted, manager)
+}
+
+// verdict records the reverted fields of the object, if any, and returns its verdict
+func (g *Guard) verdict(key string, object client.Object, reverted []string, manager string) Verdict {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t, ok := g.objects[key]
+	if !ok {
+		return Verdict{}
+	}
+	verdict := Verdict{}
+	if len(reverted) > 0 {
+		t.reverts = append(t.reverts, now)
+		recent := t.reverts[:0]
+		for _, at := range t.reverts {
+			if now.Sub(at) < g.Window {
+				recent = append(recent, at)
+			}
+		}
+		t.reverts = recent
+		switch {
+		case t.conflict == nil && len(t.reverts) >= g.Reverts:
+			t.backoff = g.Backoff
+			conflicting.Inc()
+		case t.conflict != nil && !now.Before(t.conflict.Until):
+			// Reverted again once the writes resumed, the other controller is still there
+			t.backoff *= 2
+			if t.backoff > MaxBackoff {
+				t.backoff = MaxBackoff
+			}
+		}
+		if t.backoff > 0 && (t.conflict == nil || !now.Before(t.conflict.Until)) {
+			t.conflict = &Conflict{
+				Kind:     kindOf(object),
+				Name:     object.GetName(),
+				Manager:  manager,
+				Operator: manager != "" && manager == g.FieldManager,
+				Fields:   reverted,
+				Reverts:  len(t.reverts),
+				Backoff:  t.backoff,
+				Until:    now.Add(t.backoff),
+			}
+			verdict.Changed = true
+		}
+	}
+	if t.conflict == nil {
+		return verdict
+	}
+	// The conflict ends a window after the last revert and the end of the backoff
+	end := t.conflict.Until
+	if last := t.reverts[len(t.reverts)-1]; last.After(end) {
+		end = last
+	}
+	if len(reverted) == 0 && now.Sub(end) >= g.Window {
+		t.conflict = nil
+		t.backoff = 0
+		t.reverts = nil
+		conflicting.Dec()
+		verdict.Changed = true
+		return verdict
+	}
+	verdict.Conflict = t.conflict
+	if now.Before(t.conflict.Until) {
+		verdict.Wait = t.conflict.Until.Sub(now)
+	}
+	verdict.Recheck = end.Add(g.Window).Sub(now)
+	return verdict
+}
+
+// forget drops the state of a deleted object
+func (g *Guard) forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.objects[key]; ok {
+		if t.conflict != nil {
+			conflicting.Dec()
+		}
+		delete(g.objects, key)
+	}
+}
+
+// lastManager returns the field manager that most recently wrote the reverted fields
+func lastManager(object client.Object, fields []Field, reverted []string) string {
+	manager := ""
+	var at time.Time
+	for _, entry := range object.GetManagedFields() {
+		if entry.FieldsV1 == nil || entry.Subresource != "" {
+			continue
+		}
+		owned := map[string]interface{}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &owned); err != nil {
+			continue
+		}
+		for _, field := range fields {
+			if !slices.Contains(reverted, field.String()) || !ownsPath(owned, field.Path) {
+				continue
+			}
+			if manager == "" || (entry.Time != nil && entry.Time.After(at)) {
+				manager = entry.Manager
+				if entry.Time != nil {
+					at = entry.Time.Time
+				}
+			}
+		}
+	}
+	return manager
+}
+
+// ownsPath returns true when the fields of a managedFields entry include the path, or one
+// of its children
+func ownsPath(owned map[string]interface{}, path []string) bool {
+	for _, name := range path {
+		child, ok := owned["f:"+name].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		owned = child
+	}
+	return true
+}
+
+// fingerprint returns the JSON of the value, with the keys of the maps sorted
+func fingerprint(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func objectKey(object client.Object) string {
+	return kindOf(object) + "/" + client.ObjectKeyFromObject(object).String()
+}
+
+func kindOf(object client.Object) string {
+	if kind := object.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind
+	}
+	return reflect.TypeOf(object).Elem().Name()
+}
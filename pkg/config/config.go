@@ -0,0 +1,253 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config sets the flags of the operator from the environment variables and from
+// a YAML file, reloaded when it changes, e.g. when its ConfigMap is updated. The command
+// line takes precedence over the environment, and the environment over the file.
+package config
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// FlagName is the flag with the path of the configuration file, ignored in the file
+const FlagName = "config"
+
+// EnvPrefix is the prefix of the environment variables setting the flags
+const EnvPrefix = "HAEGRESS_"
+
+// EnvName returns the environment variable of the flag, e.g. HAEGRESS_PATCH_QPS for
+// patch-qps
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// FromEnvironment sets the flags not set on the command line from their environment
+// variables. It must be called after the flags are parsed and before Load, the flags set
+// by the environment are not read from the file.
+func FromEnvironment(flagSet *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	flagSet.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+	var err error
+	flagSet.VisitAll(func(fl *flag.Flag) {
+		value, ok := os.LookupEnv(EnvName(fl.Name))
+		if !ok || explicit[fl.Name] || err != nil {
+			return
+		}
+		if setErr := flagSet.Set(fl.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", EnvName(fl.Name), setErr)
+		}
+	})
+	return err
+}
+
+// File sets the flags not set on the command line or by the environment from a YAML
+// file, whose keys are the flag names without the dashes:
+//
+//	background-checker-seconds: 120
+//	load-balancer-class: kube-vip.io/kube-vip-class
+//
+// The file is read again every IntervalSeconds: the changed flags registered with
+// OnChange are applied at runtime, the others are logged and applied at the next start.
+type File struct {
+	Path            string
+	FlagSet         *flag.FlagSet
+	Log             logr.Logger
+	IntervalSeconds int
+
+	content  []byte
+	values   map[string]string
+	explicit map[string]bool
+	reload   map[string]func()
+}
+
+// Load reads the file and sets the flags not set on the command line or by the
+// environment, it must be called after FromEnvironment
+func (f *File) Load() error {
+	f.explicit = make(map[string]bool)
+	f.FlagSet.Visit(func(fl *flag.Flag) {
+		f.explicit[fl.Name] = true
+	})
+	content, values, err := f.read()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if f.explicit[name] {
+			continue
+		}
+		if err := f.FlagSet.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", name, f.Path, err)
+		}
+	}
+	f.content = content
+	f.values = values
+	return nil
+}
+
+// OnChange registers the function applying at runtime the new value of the flag
+func (f *File) OnChange(name string, apply func()) {
+	if f.reload == nil {
+		f.reload = make(map[string]func())
+	}
+	f.reload[name] = apply
+}
+
+// SetupWithManager registers the file as a runnable of the Manager, to reload it.
+func (f *File) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(f)
+}
+
+// NeedLeaderElection returns false, every replica reloads its configuration
+func (f *File) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and reloads the file until the context is cancelled
+func (f *File) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(f.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := f.refresh(); err != nil {
+				f.Log.Error(err, "unable to reload the configuration, keeping the current one", "file", f.Path)
+			}
+		}
+	}
+}
+
+// refresh applies the values changed since the last read
+func (f *File) refresh() error {
+	content, values, err := f.read()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, f.content) {
+		return nil
+	}
+
+	var changed []string
+	for name := range values {
+		if values[name] != f.values[name] {
+			changed = append(changed, name)
+		}
+	}
+	for name := range f.values {
+		if _, ok := values[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	var restart []string
+	for _, name := range changed {
+		if f.explicit[name] {
+			f.Log.Info("Flag set on the command line or by the environment, ignoring the configuration file", "flag", name)
+			continue
+		}
+		apply, ok := f.reload[name]
+		if !ok {
+			restart = append(restart, name)
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			// Removed from the file, back to the default
+			value = f.FlagSet.Lookup(name).DefValue
+		}
+		if err := f.FlagSet.Set(name, value); err != nil {
+			f.Log.Error(err, "invalid value in the configuration file, ignoring it", "flag", name, "value", value)
+			continue
+		}
+		f.Log.Info("Configuration changed", "flag", name, "value", value)
+		apply()
+	}
+	if len(restart) > 0 {
+		f.Log.Info("Configuration changed, applied at the next start of the operator", "flags", strings.Join(restart, ","))
+	}
+	f.content = content
+	f.values = values
+	return nil
+}
+
+// read returns the content of the file and its values by flag name
+func (f *File) read() ([]byte, map[string]string, error) {
+	content, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration file %s: %w", f.Path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == FlagName {
+			continue
+		}
+		if f.FlagSet.Lookup(name) == nil {
+			return nil, nil, fmt.Errorf("unknown flag %s in %s", name, f.Path)
+		}
+		values[name] = flagValue(value)
+	}
+	return content, values, nil
+}
+
+// flagValue formats a YAML value as a command line value, the lists are joined with commas
+// and the maps are key=value pairs joined with commas, in key order, like --feature-gates
+func flagValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, flagValue(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(value))
+		for key, item := range value {
+			pairs = append(pairs, key+"="+flagValue(item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	case float64:
+		// Numbers are decoded as float64, keep the integers without the exponent
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// testFlags are the flags of the tests, as the flags of the operator
+type testFlags struct {
+	flagSet        *flag.FlagSet
+	patchQPS       *float64
+	class          *string
+	checkerSeconds *int
+	namespaces     *string
+	featureGates   *string
+	restartOnly    *string
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	t.Helper()
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := &testFlags{
+		flagSet:        flagSet,
+		patchQPS:       flagSet.Float64("patch-qps", 20, ""),
+		class:          flagSet.String("load-balancer-class", "", ""),
+		checkerSeconds: flagSet.Int("background-checker-seconds", 60, ""),
+		namespaces:     flagSet.String("service-namespaces", "", ""),
+		featureGates:   flagSet.String("feature-gates", "", ""),
+		restartOnly:    flagSet.String("metrics-bind-address", ":8080", ""),
+	}
+	flagSet.String(FlagName, "", "")
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, `config: /ignored.yaml
+patch-qps: 50
+load-balancer-class: from-file
+background-checker-seconds: 120
+service-namespaces: [egress-a, egress-b]
+feature-gates: {Sharding: true, Fanout: false}
+`)
+	t.Setenv(EnvName("patch-qps"), "40")
+	t.Setenv(EnvName("load-balancer-class"), "from-env")
+
+	flags := newTestFlags(t, "--patch-qps=30")
+	if err := FromEnvironment(flags.flagSet); err != nil {
+		t.Fatal(err)
+	}
+	file := &File{Path: path, FlagSet: flags.flagSet, Log: logr.Discard()}
+	if err := file.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if *flags.patchQPS != 30 {
+		t.Errorf("patch-qps is %v, expected the command line value 30", *flags.patchQPS)
+	}
+	if *flags.class != "from-env" {
+		t.Errorf("load-balancer-class is %q, expected the environment value", *flags.class)
+	}
+	if *flags.checkerSeconds != 120 {
+		t.Errorf("background-checker-seconds is %d, expected the file value 120", *flags.checkerSeconds)
+	}
+	if *flags.namespaces != "egress-a,egress-b" {
+		t.Errorf("service-namespaces is %q, expected the list of the file joined with commas", *flags.namespaces)
+	}
+	if *flags.featureGates != "Fanout=false,Sharding=true" {
+		t.Errorf("feature-gates is %q, expected the map of the file as sorted pairs", *flags.featureGates)
+	}
+	if *flags.restartOnly != ":8080" {
+		t.Errorf("metrics-bind-address is %q, expected the default", *flags.restartOnly)
+	}
+}
+
+func TestEnvironmentInvalidValue(t *testing.T) {
+	t.Setenv(EnvName("background-checker-seconds"), "often")
+	flags := newTestFlags(t)
+	if err := FromEnvironment(flags.flagSet); err == nil {
+		t.Error("the invalid value of the environment was accepted")
+	}
+}
+
+func TestFileUnknownFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, "patch-qsp: 50\n")
+	flags := newTestFlags(t)
+	if err := (&File{Path: path, FlagSet: flags.flagSet, Log: logr.Discard()}).Load(); err == nil {
+		t.Error("the unknown flag of the file was accepted")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, `patch-qps: 50
+load-balancer-class: from-file
+background-checker-seconds: 120
+`)
+	t.Setenv(EnvName("load-balancer-class"), "from-env")
+
+	flags := newTestFlags(t, "--patch-qps=30")
+	if err := FromEnvironment(flags.flagSet); err != nil {
+		t.Fatal(err)
+	}
+	file := &File{Path: path, FlagSet: flags.flagSet, Log: logr.Discard()}
+	if err := file.Load(); err != nil {
+		t.Fatal(err)
+	}
+	applied := map[string]int{}
+	for _, name := range []string{"patch-qps", "load-balancer-class", "background-checker-seconds"} {
+		name := name
+		file.OnChange(name, func() { applied[name]++ })
+	}
+
+	// The flags set on the command line or by the environment are never reloaded, the
+	// ones without OnChange are applied at the next start
+	writeFile(t, path, `patch-qps: 60
+load-balancer-class: changed
+background-checker-seconds: 90
+metrics-bind-address: ":9090"
+`)
+	if err := file.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if *flags.patchQPS != 30 || applied["patch-qps"] != 0 {
+		t.Errorf("patch-qps set on the command line was reloaded to %v", *flags.patchQPS)
+	}
+	if *flags.class != "from-env" || applied["load-balancer-class"] != 0 {
+		t.Errorf("load-balancer-class set by the environment was reloaded to %q", *flags.class)
+	}
+	if *flags.checkerSeconds != 90 || applied["background-checker-seconds"] != 1 {
+		t.Errorf("background-checker-seconds is %d, applied %d times, expected 90 applied once", *flags.checkerSeconds, applied["background-checker-seconds"])
+	}
+	if *flags.restartOnly != ":8080" {
+		t.Errorf("metrics-bind-address was applied at runtime: %q", *flags.restartOnly)
+	}
+
+	// The same content is not applied again
+	if err := file.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if applied["background-checker-seconds"] != 1 {
+		t.Errorf("background-checker-seconds applied %d times without changes", applied["background-checker-seconds"])
+	}
+
+	// A flag removed from the file is back to its default
+	writeFile(t, path, "patch-qps: 60\n")
+	if err := file.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if *flags.checkerSeconds != 60 || applied["background-checker-seconds"] != 2 {
+		t.Errorf("background-checker-seconds is %d, applied %d times, expected the default 60 applied twice", *flags.checkerSeconds, applied["background-checker-seconds"])
+	}
+
+	// An invalid file keeps the current configuration
+	writeFile(t, path, "background-checker-seconds: [\n")
+	if err := file.refresh(); err == nil {
+		t.Error("the invalid file was accepted")
+	}
+	if *flags.checkerSeconds != 60 {
+		t.Errorf("background-checker-seconds changed to %d with an invalid file", *flags.checkerSeconds)
+	}
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package consistency detects the policies whose Service and CiliumEgressGatewayPolicy
+// disagree for longer than a grace period, like an egress IP still configured after the
+// Service lost its load balancer IP, and alerts or repairs them. The events reconciled by
+// the controllers can't see these states, that otherwise persist until the traffic breaks.
+package consistency
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/audit"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/coexistence"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Kinds of inconsistent states
+const (
+	// EgressIPWithoutIngress is a CiliumEgressGatewayPolicy with an egressIP while the
+	// provider reports no IP for the Service, e.g. the Service lost its load balancer IP
+	EgressIPWithoutIngress = "egress_ip_without_ingress"
+	// ExitNodeMissing is a Service with its IP and without the node announcing it, e.g. the
+	// kube-vip.io/vipHost annotation removed, while the CiliumEgressGatewayPolicy still
+	// selects a node
+	ExitNodeMissing = "exit_node_missing"
+	// OutOfSync is an exit node or an egress IP reported by the provider different from the
+	// CiliumEgressGatewayPolicy, a change the failover controller did not apply
+	OutOfSync = "out_of_sync"
+)
+
+// Actions taken on the inconsistent states, besides the alert
+const (
+	// ActionAlert only records the events, the metrics and the notifications
+	ActionAlert = "alert"
+	// ActionClear removes the egressIP without a load balancer IP from the
+	// CiliumEgressGatewayPolicy and queues the Services out of sync
+	ActionClear = "clear"
+	// ActionReallocate deletes the Services without IP or exit node, created again by the
+	// policy so the provider allocates and announces the IP again, and queues the Services
+	// out of sync
+	ActionReallocate = "reallocate"
+)
+
+var (
+	inconsistencies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_inconsistencies",
+			Help: "Policies whose Service and CiliumEgressGatewayPolicy disagree at the last consistency check, by kind",
+		},
+		[]string{"kind"},
+	)
+
+	actions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_inconsistency_actions_total",
+			Help: "Actions taken on the inconsistent states that outlasted the grace period, by kind and action",
+		},
+		[]string{"kind", "action"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(inconsistencies, actions)
+}
+
+// ValidAction returns an error when the action is unknown
+func ValidAction(action string) error {
+	switch action {
+	case ActionAlert, ActionClear, ActionReallocate:
+		return nil
+	}
+	return fmt.Errorf("unknown consistency action %q, valid actions are %s, %s and %s", action, ActionAlert, ActionClear, ActionReallocate)
+}
+
+// Checker compares every IntervalSeconds the Service and the CiliumEgressGatewayPolicy
+// of the policies owned by the replica. A state that lasts GraceSeconds is alerted and
+// handled with Action, again after every GraceSeconds while it lasts, so the transient
+// states of a failover are never touched.
+type Checker struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// SyncOptions are the providers, the notifier and the auditor of the Services controller
+	SyncOptions haegressiputil.SyncOptions
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+	// EgressNamespace is the default namespace of the Services
+	EgressNamespace string
+	IntervalSeconds int
+	GraceSeconds    int
+	Action          string
+	// Resync queues the Services out of sync in the failover controller
+	Resync chan<- event.GenericEvent
+
+	// detected holds, by policy, the inconsistency found at the previous check
+	detected map[string]detection
+}
+
+// detection is the kind of inconsistency of a policy, and the time it was first detected
+// or last handled
+type detection struct {
+	kind  string
+	since time.Time
+}
+
+// state is what the provider reports for the Service of a policy
+type state struct {
+	policy   *haegressv2.HAEgressGatewayPolicy
+	service  *corev1.Service
+	cegp     *ciliumv2.CiliumEgressGatewayPolicy
+	egressIP string
+	exitNode string
+}
+
+// SetupWithManager registers the checker as a runnable of the Manager.
+func (c *Checker) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica checks its own
+// policies
+func (c *Checker) NeedLeaderElection() bool {
+	return c.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (c *Checker) Start(ctx context.Context) error {
+	c.detected = make(map[string]detection)
+	ticker := time.NewTicker(time.Duration(c.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.checkAll(ctx); err != nil {
+				c.Log.Error(err, "unable to check the consistency of the policies")
+			}
+		}
+	}
+}
+
+// checkAll checks every policy and handles the states older than the grace period
+func (c *Checker) checkAll(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return err
+	}
+	haegressiputil.SortByPriority(policies.Items)
+	now := time.Now()
+	grace := time.Duration(c.GraceSeconds) * time.Second
+	counts := map[string]int{EgressIPWithoutIngress: 0, ExitNodeMissing: 0, OutOfSync: 0}
+	detected := make(map[string]detection)
+	for i := range policies.Items {
+		current, kind, err := c.check(ctx, &policies.Items[i])
+		if err != nil {
+			c.Log.V(1).Info("Unable to check the consistency of the policy", "policy", policies.Items[i].Name, "error", err.Error())
+		}
+		if kind == "" {
+			continue
+		}
+		counts[kind]++
+		previous, ok := c.detected[current.policy.Name]
+		if !ok || previous.kind != kind {
+			previous = detection{kind: kind, since: now}
+			c.Log.V(1).Info("Inconsistent state detected, waiting for the grace period", "policy", current.policy.Name, "kind", kind)
+		} else if now.Sub(previous.since) >= grace {
+			c.handle(ctx, current, kind)
+			previous.since = now
+		}
+		detected[current.policy.Name] = previous
+	}
+	c.detected = detected
+	for kind, count := range counts {
+		inconsistencies.WithLabelValues(kind).Set(float64(count))
+	}
+	return nil
+}
+
+// check returns the state of the policy and the kind of its inconsistency, empty when the
+// policy is consistent or can't be checked
+func (c *Checker) check(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (*state, string, error) {
+	if !c.Sharder.Owns(policy.Name, policy.Labels) || !policy.DeletionTimestamp.IsZero() ||
+		haegressiputil.IsPaused(policy) || haegressiputil.SkipsChildren(policy) {
+		return nil, "", nil
+	}
+	// The invalid annotations are reported by the controllers
+	if sanitize.PolicyAnnotations(policy.Annotations) != nil {
+		return nil, "", nil
+	}
+	serviceNamespace := haegressiputil.ServiceNamespace(policy, c.EgressNamespace)
+	if !c.SyncOptions.Namespaces.Includes(serviceNamespace) {
+		return nil, "", nil
+	}
+
+	// The missing objects are created by the policy controller
+	current := &state{policy: policy, service: &corev1.Service{}, cegp: &ciliumv2.CiliumEgressGatewayPolicy{}}
+	if err := c.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: serviceNamespace}, current.service); err != nil {
+		return nil, "", client.IgnoreNotFound(err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", serviceNamespace, policy.Name)}, current.cegp); err != nil {
+		return nil, "", client.IgnoreNotFound(err)
+	}
+	if !current.service.DeletionTimestamp.IsZero() || !metav1.IsControlledBy(current.service, policy) ||
+		!metav1.IsControlledBy(current.cegp, policy) || current.cegp.Spec.EgressGateway == nil {
+		return nil, "", nil
+	}
+
+	vipProvider, err := c.SyncOptions.Providers.ForPolicy(policy)
+	if err != nil {
+		return nil, "", err
+	}
+	if current.egressIP, err = vipProvider.EgressIP(ctx, current.service); err != nil {
+		return nil, "", err
+	}
+	if current.exitNode, err = vipProvider.ExitNode(ctx, current.service); err != nil {
+		return nil, "", err
+	}
+
+	gateway := current.cegp.Spec.EgressGateway
+	nodes := haegressiputil.GatewayGroupFromSelector(gateway.NodeSelector)
+	if gateway.NodeSelector != nil && gateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation] != "" {
+		nodes = []string{string(gateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation])}
+	}
+	// In interface mode the egressIP of the CiliumEgressGatewayPolicy is empty
+	interfaceMode := policy.Annotations[haegressip.EgressInterfaceAnnotation] != ""
+	switch {
+	case !interfaceMode && gateway.EgressIP != "" && current.egressIP == "":
+		return current, EgressIPWithoutIngress, nil
+	case current.egressIP != "" && current.exitNode == "" && len(nodes) > 0:
+		return current, ExitNodeMissing, nil
+	case current.exitNode != "" && !slices.Contains(nodes, current.exitNode),
+		!interfaceMode && current.egressIP != "" && gateway.EgressIP != current.egressIP:
+		return current, OutOfSync, nil
+	}
+	return current, "", nil
+}
+
+// handle alerts the inconsistent state and takes the configured action
+func (c *Checker) handle(ctx context.Context, current *state, kind string) {
+	policy := current.policy
+	gateway := current.cegp.Spec.EgressGateway
+	message := fmt.Sprintf("Service %s/%s and CiliumEgressGatewayPolicy %s inconsistent (%s): the provider reports egress IP %q on node %q, the CiliumEgressGatewayPolicy has egress IP %q",
+		current.service.Namespace, current.service.Name, current.cegp.Name, kind, current.egressIP, current.exitNode, gateway.EgressIP)
+	c.Log.Info("Inconsistent state outlasted the grace period", "policy", policy.Name, "kind", kind, "action", c.Action,
+		"egressIP", current.egressIP, "exitNode", current.exitNode, "policyEgressIP", gateway.EgressIP)
+	c.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventInconsistentStateReason, message)
+	c.SyncOptions.Notifier.Notify(notify.Event{
+		Type:     notify.Degraded,
+		Policy:   policy.Name,
+		EgressIP: gateway.EgressIP,
+		ExitNode: policy.Status.ExitNode,
+		Message:  message,
+	})
+
+	action := ActionAlert
+	var err error
+	switch {
+	case c.Action == ActionAlert:
+	case kind == OutOfSync:
+		action = "resync"
+		err = c.resync(ctx, current)
+	case kind == EgressIPWithoutIngress && c.Action == ActionClear:
+		action = ActionClear
+		err = c.clearEgressIP(ctx, current)
+	case c.Action == ActionReallocate:
+		action = ActionReallocate
+		err = c.reallocate(ctx, current)
+	}
+	actions.WithLabelValues(kind, action).Inc()
+	if err != nil {
+		c.Log.Error(err, "unable to repair the inconsistent state", "policy", policy.Name, "kind", kind, "action", action)
+		return
+	}
+	if action != ActionAlert {
+		c.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventInconsistencyRepairedReason,
+			fmt.Sprintf("Inconsistent state %s handled with the %s action", kind, action))
+	}
+}
+
+// resync queues the Service in the failover controller, that applies the state reported
+// by the provider
+func (c *Checker) resync(ctx context.Context, current *state) error {
+	if c.Resync == nil {
+		return nil
+	}
+	object := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: current.service.Namespace, Name: current.service.Name}}
+	select {
+	case c.Resync <- event.GenericEvent{Object: object}:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// clearEgressIP removes the egressIP of the CiliumEgressGatewayPolicy, set again by the
+// Services controller when the provider assigns an IP to the Service
+func (c *Checker) clearEgressIP(ctx context.Context, current *state) error {
+	previous := current.cegp.Spec.EgressGateway.EgressIP
+	patch := client.MergeFrom(current.cegp.DeepCopy())
+	current.cegp.Spec.EgressGateway.EgressIP = ""
+	if err := c.Patch(ctx, current.cegp, patch); err != nil {
+		return err
+	}
+	c.SyncOptions.Guard.Written(current.cegp, coexistence.EgressIP)
+	c.SyncOptions.Auditor.Record(audit.Record{
+		Action:   audit.ActionEgressIP,
+		Resource: "CiliumEgressGatewayPolicy/" + current.cegp.Name,
+		Policy:   current.policy.Name,
+		Old:      previous,
+		Reason: fmt.Sprintf("egress IP cleared by the consistency checker, the Service %s/%s has no IP",
+			current.service.Namespace, current.service.Name),
+	})
+	return nil
+}
+
+// reallocate deletes the Service, created again by the policy controller, so the provider
+// allocates and announces the IP of a new Service. The IP allocated by an external IPAM is
+// requested again, the one chosen by the provider can change.
+func (c *Checker) reallocate(ctx context.Context, current *state) error {
+	err := c.Delete(ctx, current.service, client.Preconditions{UID: &current.service.UID})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package crd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of incompatibilities between the installed CRDs and the types of the operator
+const (
+	// KindMissing is a CRD not installed
+	KindMissing = "crd_missing"
+	// KindVersionNotServed is a CRD not serving the version decoded by the operator
+	KindVersionNotServed = "version_not_served"
+	// KindUnknownStorageVersion is a CRD storing a version unknown to the operator,
+	// installed by a newer release
+	KindUnknownStorageVersion = "unknown_storage_version"
+	// KindFieldMissing is a field written by the operator missing from the schema of the
+	// CRD, the API server silently drops it
+	KindFieldMissing = "field_missing"
+)
+
+// Actions taken on the incompatibilities
+const (
+	// ActionDegrade logs and exports the incompatibilities, the operator keeps running
+	ActionDegrade = "degrade"
+	// ActionRefuse stops the operator at startup and fails the "crd" readiness check
+	// when an incompatibility appears later
+	ActionRefuse = "refuse"
+)
+
+// CiliumEgressGatewayPolicyFields are the fields of the CiliumEgressGatewayPolicies
+// written by the operator
+var CiliumEgressGatewayPolicyFields = []string{
+	"spec.selectors.namespaceSelector",
+	"spec.selectors.podSelector",
+	"spec.destinationCIDRs",
+	"spec.excludedCIDRs",
+	"spec.egressGateway.nodeSelector",
+	"spec.egressGateway.egressIP",
+	"spec.egressGateway.interface",
+}
+
+var incompatibilities = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "haegress_crd_incompatibilities",
+		Help: "Incompatibilities between the installed CRDs and the types of the operator at the last check, by CRD and kind",
+	},
+	[]string{"crd", "kind"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(incompatibilities)
+}
+
+// ValidAction returns an error when the action is unknown
+func ValidAction(action string) error {
+	switch action {
+	case ActionDegrade, ActionRefuse:
+		return nil
+	}
+	return fmt.Errorf("unknown CRD compatibility action %q, valid actions are %s and %s", action, ActionDegrade, ActionRefuse)
+}
+
+// Requirement is what the operator expects from an installed CRD
+type Requirement struct {
+	// Name is the name of the CRD
+	Name string
+	// Version is the version decoded and written by the operator, it must be served
+	Version string
+	// Manifest, if set, is the CRD the operator is built with: every field of its Version
+	// must be in the installed schema
+	Manifest []byte
+	// Fields are the dotted paths of the fields written by the operator, for the CRDs
+	// without a manifest
+	Fields []string
+}
+
+// Incompatibility is a difference between an installed CRD and a Requirement
+type Incompatibility struct {
+	CRD     string
+	Kind    string
+	Message string
+}
+
+// CompatibilityChecker compares at startup and every IntervalSeconds the installed CRDs
+// with the Requirements, e.g. after an upgrade of Cilium with the operator running
+type CompatibilityChecker struct {
+	// Reader should be a non-cached reader knowing the apiextensions types
+	Reader          client.Reader
+	Log             logr.Logger
+	Requirements    []Requirement
+	Action          string
+	IntervalSeconds int
+
+	mu    sync.RWMutex
+	found []Incompatibility
+}
+
+// SetupWithManager registers the periodic check and the "crd" readiness check with the
+// Manager.
+func (c *CompatibilityChecker) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.AddReadyzCheck("crd", c.Check); err != nil {
+		return err
+	}
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false because every replica must report its readiness
+func (c *CompatibilityChecker) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and checks the CRDs until the context is cancelled.
+func (c *CompatibilityChecker) Start(ctx context.Context) error {
+	if c.IntervalSeconds <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Duration(c.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := c.Refresh(ctx); err != nil {
+				c.Log.Error(err, "unable to check the compatibility of the CRDs")
+			}
+		}
+	}
+}
+
+// Refresh checks every Requirement, logs the incompatibilities not found by the previous
+// check and exports them
+func (c *CompatibilityChecker) Refresh(ctx context.Context) ([]Incompatibility, error) {
+	var found []Incompatibility
+	for _, requirement := range c.Requirements {
+		result, err := c.check(ctx, requirement)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, result...)
+	}
+
+	c.mu.Lock()
+	previous := c.found
+	c.found = found
+	c.mu.Unlock()
+
+	known := map[Incompatibility]bool{}
+	for _, incompatibility := range previous {
+		known[incompatibility] = true
+	}
+	incompatibilities.Reset()
+	for _, requirement := range c.Requirements {
+		for _, kind := range []string{KindMissing, KindVersionNotServed, KindUnknownStorageVersion, KindFieldMissing} {
+			incompatibilities.WithLabelValues(requirement.Name, kind).Set(0)
+		}
+	}
+	for _, incompatibility := range found {
+		incompatibilities.WithLabelValues(incompatibility.CRD, incompatibility.Kind).Inc()
+		if !known[incompatibility] {
+			c.Log.Error(nil, "Installed CRD incompatible with the operator", "crd", incompatibility.CRD,
+				"kind", incompatibility.Kind, "message", incompatibility.Message, "action", c.Action)
+		}
+	}
+	return found, nil
+}
+
+// Check implements healthz.Checker and, with the refuse action, fails while a CRD is
+// incompatible
+func (c *CompatibilityChecker) Check(_ *http.Request) error {
+	if c.Action != ActionRefuse {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Error(c.found)
+}
+
+// Error returns the incompatibilities as an error, nil without incompatibilities
+func Error(found []Incompatibility) error {
+	var errs []error
+	for _, incompatibility := range found {
+		errs = append(errs, fmt.Errorf("%s: %s", incompatibility.CRD, incompatibility.Message))
+	}
+	return errors.Join(errs...)
+}
+
+// check compares the installed CRD with the requirement
+func (c *CompatibilityChecker) check(ctx context.Context, requirement Requirement) ([]Incompatibility, error) {
+	installed := &apiextensionsv1.CustomResourceDefinition{}
+	if err := c.Reader.Get(ctx, types.NamespacedName{Name: requirement.Name}, installed); apierrors.IsNotFound(err) {
+		return []Incompatibility{{CRD: requirement.Name, Kind: KindMissing, Message: "the CRD is not installed"}}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read the CRD %s: %w", requirement.Name, err)
+	}
+
+	var found []Incompatibility
+	var version *apiextensionsv1.CustomResourceDefinitionVersion
+	for i := range installed.Spec.Versions {
+		if installed.Spec.Versions[i].Name == requirement.Version {
+			version = &installed.Spec.Versions[i]
+		}
+	}
+	if version == nil || !version.Served {
+		return []Incompatibility{{CRD: requirement.Name, Kind: KindVersionNotServed,
+			Message: fmt.Sprintf("the version %s is not served, served versions: %s", requirement.Version,
+				strings.Join(servedVersions(installed), ", "))}}, nil
+	}
+
+	var desired *apiextensionsv1.JSONSchemaProps
+	if requirement.Manifest != nil {
+		manifest := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(requirement.Manifest, manifest); err != nil {
+			return nil, fmt.Errorf("invalid embedded CRD: %w", err)
+		}
+		if storage := storageVersion(installed); !hasVersion(manifest, storage) {
+			found = append(found, Incompatibility{CRD: requirement.Name, Kind: KindUnknownStorageVersion,
+				Message: fmt.Sprintf("the CRD stores the version %s, unknown to the operator", storage)})
+		}
+		for _, manifestVersion := range manifest.Spec.Versions {
+			if manifestVersion.Name == requirement.Version && manifestVersion.Schema != nil {
+				desired = manifestVersion.Schema.OpenAPIV3Schema
+			}
+		}
+	}
+
+	var schema *apiextensionsv1.JSONSchemaProps
+	if version.Schema != nil {
+		schema = version.Schema.OpenAPIV3Schema
+	}
+	var missing []string
+	if desired != nil {
+		missingFields(desired, schema, "", &missing)
+	}
+	for _, field := range requirement.Fields {
+		if !hasField(schema, strings.Split(field, ".")) {
+			missing = append(missing, field)
+		}
+	}
+	sort.Strings(missing)
+	for _, field := range missing {
+		found = append(found, Incompatibility{CRD: requirement.Name, Kind: KindFieldMissing,
+			Message: fmt.Sprintf("the field %s of the version %s is missing from the schema and is dropped by the API server", field, requirement.Version)})
+	}
+	return found, nil
+}
+
+// missingFields appends the paths of the fields of desired that installed drops
+func missingFields(desired, installed *apiextensionsv1.JSONSchemaProps, path string, missing *[]string) {
+	if installed == nil {
+		*missing = append(*missing, path)
+		return
+	}
+	if preservesUnknownFields(installed) {
+		return
+	}
+	for name := range desired.Properties {
+		property := desired.Properties[name]
+		child := strings.TrimPrefix(path+"."+name, ".")
+		if installedProperty, ok := installed.Properties[name]; ok {
+			missingFields(&property, &installedProperty, child, missing)
+		} else if installed.AdditionalProperties != nil && installed.AdditionalProperties.Schema != nil {
+			missingFields(&property, installed.AdditionalProperties.Schema, child, missing)
+		} else if installed.AdditionalProperties == nil || !installed.AdditionalProperties.Allows {
+			*missing = append(*missing, child)
+		}
+	}
+	if desired.Items != nil && desired.Items.Schema != nil {
+		var items *apiextensionsv1.JSONSchemaProps
+		if installed.Items != nil {
+			items = installed.Items.Schema
+		}
+		missingFields(desired.Items.Schema, items, path+"[]", missing)
+	}
+	if desired.AdditionalProperties != nil && desired.AdditionalProperties.Schema != nil {
+		var values *apiextensionsv1.JSONSchemaProps
+		if installed.AdditionalProperties != nil {
+			values = installed.AdditionalProperties.Schema
+			if values == nil && installed.AdditionalProperties.Allows {
+				return
+			}
+		}
+		missingFields(desired.AdditionalProperties.Schema, values, path+"{}", missing)
+	}
+}
+
+// hasField returns true when the schema keeps the field of the path, the arrays are
+// crossed through their items
+func hasField(schema *apiextensionsv1.JSONSchemaProps, path []string) bool {
+	for schema != nil && schema.Items != nil && schema.Items.Schema != nil {
+		schema = schema.Items.Schema
+	}
+	switch {
+	case schema == nil:
+		return false
+	case len(path) == 0, preservesUnknownFields(schema):
+		return true
+	}
+	if property, ok := schema.Properties[path[0]]; ok {
+		return hasField(&property, path[1:])
+	}
+	return false
+}
+
+func preservesUnknownFields(schema *apiextensionsv1.JSONSchemaProps) bool {
+	return schema.XPreserveUnknownFields != nil && *schema.XPreserveUnknownFields
+}
+
+func servedVersions(crd *apiextensionsv1.CustomResourceDefinition) []string {
+	var versions []string
+	for _, version := range crd.Spec.Versions {
+		if version.Served {
+			versions = append(versions, version.Name)
+		}
+	}
+	return versions
+}
+
+func storageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crd installs or upgrades the CustomResourceDefinitions of the operator at
+// startup, so the deployed CRDs always match the types the operator decodes.
+package crd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;create;update
+
+// Installer applies the CRD manifests and waits for them to be established
+type Installer struct {
+	Client  client.Client
+	Log     logr.Logger
+	Timeout time.Duration
+}
+
+// NewInstaller returns an installer with its own client, usable before the Manager is
+// created
+func NewInstaller(config *rest.Config, log logr.Logger, timeout time.Duration) (*Installer, error) {
+	scheme := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return &Installer{Client: c, Log: log, Timeout: timeout}, nil
+}
+
+// Install creates or updates the CRDs of the manifests, then waits until they are
+// established
+func (i *Installer) Install(ctx context.Context, manifests ...[]byte) error {
+	var names []string
+	for _, manifest := range manifests {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(manifest, crd); err != nil {
+			return fmt.Errorf("invalid embedded CRD: %w", err)
+		}
+		if err := i.apply(ctx, crd); err != nil {
+			return fmt.Errorf("unable to apply the CRD %s: %w", crd.Name, err)
+		}
+		names = append(names, crd.Name)
+	}
+	for _, name := range names {
+		if err := i.waitEstablished(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Installer) apply(ctx context.Context, crd *apiextensionsv1.CustomResourceDefinition) error {
+	existing := &apiextensionsv1.CustomResourceDefinition{}
+	err := i.Client.Get(ctx, types.NamespacedName{Name: crd.Name}, existing)
+	if apierrors.IsNotFound(err) {
+		i.Log.Info("Installing the CRD", "crd", crd.Name)
+		return i.Client.Create(ctx, crd)
+	} else if err != nil {
+		return err
+	}
+
+	// A newer CRD, with versions unknown to the operator, is not downgraded: the stored
+	// objects of those versions would become unreadable
+	for _, version := range existing.Spec.Versions {
+		if version.Storage && !hasVersion(crd, version.Name) {
+			i.Log.Info("The installed CRD stores a version unknown to the operator, not downgrading it",
+				"crd", crd.Name, "version", version.Name)
+			return nil
+		}
+	}
+
+	if equality.Semantic.DeepEqual(existing.Spec, crd.Spec) {
+		return nil
+	}
+	existing.Spec = crd.Spec
+	i.Log.Info("Updating the CRD", "crd", crd.Name)
+	return i.Client.Update(ctx, existing)
+}
+
+// waitEstablished waits until the API server serves the CRD
+func (i *Installer) waitEstablished(ctx context.Context, name string) error {
+	err := wait.PollUntilContextTimeout(ctx, time.Second, i.Timeout, true, func(ctx context.Context) (bool, error) {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := i.Client.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+			return false, err
+		}
+		for _, condition := range crd.Status.Conditions {
+			if condition.Type == apiextensionsv1.NamesAccepted && condition.Status == apiextensionsv1.ConditionFalse {
+				return false, fmt.Errorf("names of the CRD %s not accepted: %s", name, condition.Message)
+			}
+		}
+		for _, condition := range crd.Status.Conditions {
+			if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("CRD %s not established: %w", name, err)
+	}
+	i.Log.Info("CRD established", "crd", name)
+	return nil
+}
+
+func hasVersion(crd *apiextensionsv1.CustomResourceDefinition, name string) bool {
+	for _, version := range crd.Spec.Versions {
+		if version.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dampening limits how often the nodeSelector and the egressIP of the
+// CiliumEgressGatewayPolicy of a policy are patched. When the load balancer layer flaps
+// between two nodes, every patch reprograms the datapath of Cilium on every node and resets
+// the connections of the selected pods; the patches following another one within the
+// minimum interval are delayed, and only the last state is applied. The genuine failovers,
+// away from nodes that are not Ready anymore, are never delayed.
+package dampening
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var delayed = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "haegress_cegp_patches_delayed_total",
+		Help: "Patches of the CiliumEgressGatewayPolicies delayed by the minimum interval between two patches of a policy by field",
+	},
+	[]string{"field"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(delayed)
+}
+
+// Dampener allows a patch of the CiliumEgressGatewayPolicy of a policy once every
+// MinInterval. A nil Dampener, or one with MinInterval zero, allows every patch.
+type Dampener struct {
+	MinInterval time.Duration
+
+	mu sync.Mutex
+	// patched holds the time of the last patch of every policy within the interval
+	patched map[string]time.Time
+}
+
+// Wait returns how long the patch of the field of the policy must be delayed, zero when it
+// can be applied now. A forced patch is never delayed.
+func (d *Dampener) Wait(policy, field string, force bool) time.Duration {
+	if d == nil || d.MinInterval <= 0 || force {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.patched[policy]
+	if !ok {
+		return 0
+	}
+	wait := d.MinInterval - time.Since(at)
+	if wait <= 0 {
+		return 0
+	}
+	delayed.WithLabelValues(field).Inc()
+	return wait
+}
+
+// Patched records a patch of the policy, forced or not, the next one is delayed
+func (d *Dampener) Patched(policy string) {
+	if d == nil || d.MinInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.patched == nil {
+		d.patched = make(map[string]time.Time)
+	}
+	for name, at := range d.patched {
+		if now.Sub(at) >= d.MinInterval {
+			delete(d.patched, name)
+		}
+	}
+	d.patched[policy] = now
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package desired holds the state interpreted from every policy, its Service and its
+// CiliumEgressGatewayPolicy, between the interpretation of the policy and the actuation
+// on the cluster and on the providers. The stages of the reconciliation meet here: the
+// interpretation is a function of the policy and of the resolved destinations only, the
+// actuation reads the desired objects, so they can be previewed, diffed and applied on
+// their own.
+package desired
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// State is the desired state of a policy. The Service is interpreted before the egress IP
+// is allocated or restored, which is up to the actuation.
+type State struct {
+	Policy string `json:"policy"`
+	// Generation is the generation of the policy interpreted
+	Generation int64 `json:"generation"`
+	// InterpretedAt is the time of the last interpretation
+	InterpretedAt time.Time `json:"interpretedAt"`
+
+	Service                   *corev1.Service                     `json:"service,omitempty"`
+	CiliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy `json:"ciliumEgressGatewayPolicy,omitempty"`
+}
+
+// Store holds the desired state of the policies interpreted by the replica, the zero value
+// is ready to use
+type Store struct {
+	mu     sync.RWMutex
+	states map[string]*State
+}
+
+// SetService stores a copy of the desired Service of the policy
+func (s *Store) SetService(policy string, generation int64, service *corev1.Service) {
+	if s == nil {
+		return
+	}
+	s.update(policy, generation, func(state *State) {
+		state.Service = service.DeepCopy()
+	})
+}
+
+// SetCiliumEgressGatewayPolicy stores a copy of the desired CiliumEgressGatewayPolicy of
+// the policy
+func (s *Store) SetCiliumEgressGatewayPolicy(policy string, generation int64, cegp *ciliumv2.CiliumEgressGatewayPolicy) {
+	if s == nil {
+		return
+	}
+	s.update(policy, generation, func(state *State) {
+		state.CiliumEgressGatewayPolicy = cegp.DeepCopy()
+	})
+}
+
+func (s *Store) update(policy string, generation int64, set func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string]*State{}
+	}
+	state := s.states[policy]
+	if state == nil {
+		state = &State{Policy: policy}
+		s.states[policy] = state
+	}
+	state.Generation = generation
+	state.InterpretedAt = time.Now()
+	set(state)
+}
+
+// Get returns a copy of the desired state of the policy, false when the policy was not
+// interpreted by the replica
+func (s *Store) Get(policy string) (State, bool) {
+	if s == nil {
+		return State{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := s.states[policy]
+	if state == nil {
+		return State{}, false
+	}
+	return state.copy(), true
+}
+
+// List returns a copy of the desired states, sorted by policy
+func (s *Store) List() []State {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make([]State, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state.copy())
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Policy < states[j].Policy
+	})
+	return states
+}
+
+// Delete forgets a deleted policy
+func (s *Store) Delete(policy string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, policy)
+}
+
+func (s *State) copy() State {
+	copied := *s
+	copied.Service = s.Service.DeepCopy()
+	copied.CiliumEgressGatewayPolicy = s.CiliumEgressGatewayPolicy.DeepCopy()
+	return copied
+}
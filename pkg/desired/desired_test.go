@@ -0,0 +1,112 @@
+package desired
+
+import (
+	"reflect"
+	"testing"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStore(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "egress-web", Namespace: "egress-system"}}
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-web"}}
+	tests := []struct {
+		name     string
+		update   func(store *Store)
+		policies []string
+		// generation of the egress-web policy, zero when it is not stored
+		generation int64
+		service    bool
+		cegp       bool
+	}{
+		{
+			name:   "empty store",
+			update: func(store *Store) {},
+		},
+		{
+			name: "service interpreted",
+			update: func(store *Store) {
+				store.SetService("egress-web", 1, service)
+			},
+			policies:   []string{"egress-web"},
+			generation: 1,
+			service:    true,
+		},
+		{
+			name: "both objects interpreted",
+			update: func(store *Store) {
+				store.SetService("egress-web", 1, service)
+				store.SetCiliumEgressGatewayPolicy("egress-web", 2, cegp)
+				store.SetCiliumEgressGatewayPolicy("egress-db", 1, cegp)
+			},
+			policies:   []string{"egress-db", "egress-web"},
+			generation: 2,
+			service:    true,
+			cegp:       true,
+		},
+		{
+			name: "deleted policy",
+			update: func(store *Store) {
+				store.SetService("egress-web", 1, service)
+				store.SetService("egress-db", 1, service)
+				store.Delete("egress-web")
+			},
+			policies: []string{"egress-db"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &Store{}
+			test.update(store)
+			policies := []string{}
+			for _, state := range store.List() {
+				policies = append(policies, state.Policy)
+			}
+			if test.policies == nil {
+				test.policies = []string{}
+			}
+			if !reflect.DeepEqual(policies, test.policies) {
+				t.Errorf("the store has the policies %v, expected %v", policies, test.policies)
+			}
+			state, ok := store.Get("egress-web")
+			if ok != (test.generation != 0) || state.Generation != test.generation {
+				t.Fatalf("the store has the generation %d of egress-web (%t), expected %d", state.Generation, ok, test.generation)
+			}
+			if (state.Service != nil) != test.service || (state.CiliumEgressGatewayPolicy != nil) != test.cegp {
+				t.Errorf("egress-web has the Service %v and the CiliumEgressGatewayPolicy %v", state.Service, state.CiliumEgressGatewayPolicy)
+			}
+			if ok && state.InterpretedAt.IsZero() {
+				t.Errorf("egress-web has no interpretation time")
+			}
+		})
+	}
+}
+
+func TestStoreCopies(t *testing.T) {
+	store := &Store{}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "egress-web", Labels: map[string]string{"tier": "web"}}}
+	store.SetService("egress-web", 1, service)
+
+	// Neither the stored object nor the returned one share the changes of the callers
+	service.Labels["tier"] = "db"
+	state, _ := store.Get("egress-web")
+	if state.Service.Labels["tier"] != "web" {
+		t.Errorf("the stored Service was changed by the caller")
+	}
+	state.Service.Labels["tier"] = "api"
+	if state, _ := store.Get("egress-web"); state.Service.Labels["tier"] != "web" {
+		t.Errorf("the stored Service was changed through Get")
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	store.SetService("egress-web", 1, &corev1.Service{})
+	store.SetCiliumEgressGatewayPolicy("egress-web", 1, &ciliumv2.CiliumEgressGatewayPolicy{})
+	store.Delete("egress-web")
+	if _, ok := store.Get("egress-web"); ok || store.List() != nil {
+		t.Errorf("the nil store holds a desired state")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package disruption limits the voluntary moves of the egress IPs, like the ones off the
+// drained nodes or back to the preferred exit node, as the PodDisruptionBudgets limit the
+// evictions: every move resets the sessions of the upstream stateful firewalls, and many
+// moves at once can overflow their session tables. The failovers of the nodes that can't
+// be used anymore are never limited.
+package disruption
+
+import (
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultGroup is the group of the policies without the disruption group annotation
+const DefaultGroup = "default"
+
+var (
+	moves = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_disruption_budget_moves",
+			Help: "Voluntary moves of the egress IPs within the disruption budget window by group",
+		},
+		[]string{"group"},
+	)
+
+	deferred = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_disruption_budget_deferred_total",
+			Help: "Voluntary moves of the egress IPs deferred because the disruption budget of the group was exhausted",
+		},
+		[]string{"group"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(moves, deferred)
+}
+
+// Budget allows at most MaxMoves voluntary moves of the policies of a group within
+// Window. A nil Budget, or one with MaxMoves zero, allows every move.
+type Budget struct {
+	MaxMoves int
+	Window   time.Duration
+
+	mu sync.Mutex
+	// started holds, by group, the time the policies moved within the window
+	started map[string]map[string]time.Time
+}
+
+// Group returns the disruption group of the policy
+func Group(policy *haegressv2.HAEgressGatewayPolicy) string {
+	if group := policy.Annotations[haegressip.DisruptionGroupAnnotation]; group != "" {
+		return group
+	}
+	return DefaultGroup
+}
+
+// Allow returns true, and counts the move, when the budget of the group of the policy
+// allows another voluntary move. A policy already moved within the window is allowed
+// again without being counted twice.
+func (b *Budget) Allow(policy *haegressv2.HAEgressGatewayPolicy) bool {
+	if b == nil || b.MaxMoves <= 0 {
+		return true
+	}
+	group := Group(policy)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started == nil {
+		b.started = make(map[string]map[string]time.Time)
+	}
+	b.expire(now)
+	started := b.started[group]
+	if _, ok := started[policy.Name]; ok {
+		return true
+	}
+	if len(started) >= b.MaxMoves {
+		deferred.WithLabelValues(group).Inc()
+		return false
+	}
+	if started == nil {
+		started = make(map[string]time.Time)
+		b.started[group] = started
+	}
+	started[policy.Name] = now
+	moves.WithLabelValues(group).Set(float64(len(started)))
+	return true
+}
+
+// expire forgets the moves older than the window, with the lock held
+func (b *Budget) expire(now time.Time) {
+	for group, started := range b.started {
+		for policy, at := range started {
+			if now.Sub(at) >= b.Window {
+				delete(started, policy)
+			}
+		}
+		moves.WithLabelValues(group).Set(float64(len(started)))
+		if len(started) == 0 {
+			delete(b.started, group)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package drill exercises the failover of the policies opted in with the drill annotation
+// on a schedule, so the egress high availability is validated continuously and not only
+// during the incidents. A drill excludes the current exit node of the policy, like a
+// drained node, and verifies that the egress IP and the CiliumEgressGatewayPolicy converge
+// on another node within a timeout. The drills are voluntary moves, limited by the
+// disruption budget.
+package drill
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/disruption"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ConditionDrillSucceeded is the result of the last drill of the policy
+	ConditionDrillSucceeded = "DrillSucceeded"
+	// ReasonConverged is the reason of the DrillSucceeded condition True
+	ReasonConverged = "Converged"
+	// ReasonNotConverged is the reason of the DrillSucceeded condition False
+	ReasonNotConverged = "NotConverged"
+
+	// pollInterval is the period the convergence of a drill is checked with
+	pollInterval = 2 * time.Second
+	// fieldExitNode is the field of the annotations of the drill events
+	fieldExitNode = "exitNode"
+)
+
+// Results of the drills
+const (
+	ResultPassed = "passed"
+	ResultFailed = "failed"
+)
+
+var (
+	drills = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_drills_total",
+			Help: "Failover drills run on the policies, by result",
+		},
+		[]string{"result"},
+	)
+
+	convergence = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "haegress_drill_convergence_seconds",
+			Help:    "Time for the egress IP and the CiliumEgressGatewayPolicy of a drilled policy to converge on another node",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+		},
+	)
+
+	lastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_drill_last_success_timestamp_seconds",
+			Help: "Time of the last passed failover drill",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(drills, convergence, lastSuccess)
+}
+
+// Driller runs every Interval the drill of at most MaxPolicies policies opted in, owned by
+// the replica, whose exit node the operator can steer, the least recently drilled first
+type Driller struct {
+	client.Client
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	Providers *provider.Registry
+	// Budget limits the drills with the other voluntary moves, nil for no limit
+	Budget *disruption.Budget
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+	// EgressNamespace is the default namespace of the Services
+	EgressNamespace string
+	Interval        time.Duration
+	// Timeout is the time the drilled policy has to converge on another node
+	Timeout     time.Duration
+	MaxPolicies int
+
+	// drilled holds the time of the last drill of every policy
+	drilled map[string]time.Time
+}
+
+// SetupWithManager registers the driller as a runnable of the Manager.
+func (d *Driller) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(d)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica drills its own
+// policies
+func (d *Driller) NeedLeaderElection() bool {
+	return d.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (d *Driller) Start(ctx context.Context) error {
+	d.drilled = make(map[string]time.Time)
+	// A drill interrupted by a restart leaves the exit node excluded
+	if err := d.cleanup(ctx); err != nil {
+		d.Log.Error(err, "unable to remove the drill exclusions left by a previous run")
+	}
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.runAll(ctx); err != nil {
+				d.Log.Error(err, "unable to run the failover drills")
+			}
+		}
+	}
+}
+
+// cleanup removes the exclusions of the drills not completed
+func (d *Driller) cleanup(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := d.List(ctx, &policies); err != nil {
+		return err
+	}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.Annotations[haegressip.DrillAvoidNodeAnnotation] != "" && d.Sharder.Owns(policy.Name, policy.Labels) {
+			if err := d.release(ctx, policy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runAll drills the candidates, one at a time
+func (d *Driller) runAll(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := d.List(ctx, &policies); err != nil {
+		return err
+	}
+	candidates := []*haegressv2.HAEgressGatewayPolicy{}
+	for i := range policies.Items {
+		if d.eligible(&policies.Items[i]) {
+			candidates = append(candidates, &policies.Items[i])
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return d.drilled[candidates[i].Name].Before(d.drilled[candidates[j].Name])
+	})
+	run := 0
+	for _, policy := range candidates {
+		if run >= d.MaxPolicies {
+			break
+		}
+		// The drill is a voluntary move, counted in the budget of the group
+		if !d.Budget.Allow(policy) {
+			d.Log.V(1).Info("Disruption budget exhausted, the drill of the policy is skipped", "policy", policy.Name,
+				"group", disruption.Group(policy))
+			continue
+		}
+		run++
+		d.drilled[policy.Name] = time.Now()
+		if err := d.drill(ctx, policy); err != nil {
+			d.Log.Error(err, "unable to run the failover drill", "policy", policy.Name)
+		}
+	}
+	return nil
+}
+
+// eligible returns true when the policy is opted in and its exit node can be steered by
+// the operator: the cloud providers, and kube-vip with the node affinity
+func (d *Driller) eligible(policy *haegressv2.HAEgressGatewayPolicy) bool {
+	if policy.Annotations[haegressip.DrillAnnotation] != "true" || !d.Sharder.Owns(policy.Name, policy.Labels) ||
+		!policy.DeletionTimestamp.IsZero() || haegressiputil.IsPaused(policy) || haegressiputil.SkipsChildren(policy) ||
+		policy.Status.ExitNode == "" || policy.Annotations[haegressip.DrillAvoidNodeAnnotation] != "" {
+		return false
+	}
+	vipProvider, err := d.Providers.ForPolicy(policy)
+	if err != nil {
+		return false
+	}
+	if kubeVIP, ok := vipProvider.(*provider.KubeVIP); ok {
+		return kubeVIP.NodeAffinity
+	}
+	return provider.IsCloud(vipProvider.Name())
+}
+
+// drill excludes the exit node of the policy, waits for the convergence on another node
+// and records the result
+func (d *Driller) drill(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) error {
+	avoided := policy.Status.ExitNode
+	d.Log.Info("Failover drill started", "policy", policy.Name, "node", avoided)
+	d.Recorder.AnnotatedEventf(policy,
+		haegressiputil.EventAnnotations(policy.Name, fieldExitNode, avoided, ""),
+		corev1.EventTypeNormal, haegressip.EventDrillStartedReason,
+		"Failover drill started, the exit node %s is excluded", avoided)
+
+	patch := client.MergeFrom(policy.DeepCopy())
+	if policy.Annotations == nil {
+		policy.Annotations = map[string]string{}
+	}
+	policy.Annotations[haegressip.DrillAvoidNodeAnnotation] = avoided
+	if err := d.Patch(ctx, policy, patch); err != nil {
+		return err
+	}
+	start := time.Now()
+	exitNode, converged := d.waitConverged(ctx, policy, avoided)
+	elapsed := time.Since(start)
+
+	// The exclusion is removed in any case, the new exit node is kept while it is eligible
+	if err := d.release(ctx, policy); err != nil {
+		d.Log.Error(err, "unable to remove the drill exclusion, removed at the next start", "policy", policy.Name)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionDrillSucceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonConverged,
+		Message:            fmt.Sprintf("The failover from %s converged on %s in %s", avoided, exitNode, elapsed.Round(time.Second)),
+		ObservedGeneration: policy.Generation,
+	}
+	if converged {
+		drills.WithLabelValues(ResultPassed).Inc()
+		convergence.Observe(elapsed.Seconds())
+		lastSuccess.SetToCurrentTime()
+		d.Log.Info("Failover drill passed", "policy", policy.Name, "from", avoided, "to", exitNode, "duration", elapsed.Round(time.Millisecond).String())
+		d.Recorder.AnnotatedEventf(policy,
+			haegressiputil.EventAnnotations(policy.Name, fieldExitNode, avoided, exitNode),
+			corev1.EventTypeNormal, haegressip.EventDrillPassedReason, "%s", condition.Message)
+	} else {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonNotConverged
+		condition.Message = fmt.Sprintf("The failover from %s did not converge on another node in %s", avoided, d.Timeout)
+		drills.WithLabelValues(ResultFailed).Inc()
+		d.Log.Info("Failover drill failed", "policy", policy.Name, "from", avoided, "exitNode", exitNode, "timeout", d.Timeout.String())
+		d.Recorder.AnnotatedEventf(policy,
+			haegressiputil.EventAnnotations(policy.Name, fieldExitNode, avoided, exitNode),
+			corev1.EventTypeWarning, haegressip.EventDrillFailedReason, "%s", condition.Message)
+	}
+	return d.setResult(ctx, policy, condition)
+}
+
+// waitConverged waits until the policy reports an exit node other than the avoided one,
+// selected by its CiliumEgressGatewayPolicy, and returns the last exit node seen
+func (d *Driller) waitConverged(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, avoided string) (string, bool) {
+	timeout := time.NewTimer(d.Timeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	exitNode := avoided
+	for {
+		select {
+		case <-ctx.Done():
+			return exitNode, false
+		case <-timeout.C:
+			return exitNode, false
+		case <-ticker.C:
+		}
+		current := &haegressv2.HAEgressGatewayPolicy{}
+		if err := d.Get(ctx, types.NamespacedName{Name: policy.Name}, current); err != nil {
+			d.Log.V(1).Info("Unable to read the drilled policy", "policy", policy.Name, "error", err.Error())
+			continue
+		}
+		exitNode = current.Status.ExitNode
+		if exitNode == "" || exitNode == avoided {
+			continue
+		}
+		selected, err := d.selected(ctx, current, exitNode)
+		if err != nil {
+			d.Log.V(1).Info("Unable to read the CiliumEgressGatewayPolicy of the drilled policy", "policy", policy.Name, "error", err.Error())
+			continue
+		}
+		if selected {
+			return exitNode, true
+		}
+	}
+}
+
+// selected returns true when the CiliumEgressGatewayPolicy of the policy selects the node
+func (d *Driller) selected(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, node string) (bool, error) {
+	serviceNamespace := haegressiputil.ServiceNamespace(policy, d.EgressNamespace)
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := d.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", serviceNamespace, policy.Name)}, cegp); err != nil {
+		return false, err
+	}
+	if cegp.Spec.EgressGateway == nil {
+		return false, nil
+	}
+	nodeSelector := cegp.Spec.EgressGateway.NodeSelector
+	nodes := haegressiputil.GatewayGroupFromSelector(nodeSelector)
+	if nodeSelector != nil && nodeSelector.MatchLabels[haegressip.NodeNameAnnotation] != "" {
+		nodes = []string{string(nodeSelector.MatchLabels[haegressip.NodeNameAnnotation])}
+	}
+	return slices.Contains(nodes, node), nil
+}
+
+// release removes the exclusion of the drill from the policy
+func (d *Driller) release(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) error {
+	// Also when the drill is interrupted by the shutdown
+	ctx = context.WithoutCancel(ctx)
+	current := &haegressv2.HAEgressGatewayPolicy{}
+	if err := d.Get(ctx, types.NamespacedName{Name: policy.Name}, current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if _, ok := current.Annotations[haegressip.DrillAvoidNodeAnnotation]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(current.DeepCopy())
+	delete(current.Annotations, haegressip.DrillAvoidNodeAnnotation)
+	return d.Patch(ctx, current, patch)
+}
+
+// setResult records the result of the drill in the DrillSucceeded condition of the policy
+func (d *Driller) setResult(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, condition metav1.Condition) error {
+	current := &haegressv2.HAEgressGatewayPolicy{}
+	if err := d.Get(ctx, types.NamespacedName{Name: policy.Name}, current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	patch := client.MergeFrom(current.DeepCopy())
+	// The message changes at every drill, the transition time tells when it was run
+	meta.RemoveStatusCondition(&current.Status.Conditions, ConditionDrillSucceeded)
+	meta.SetStatusCondition(&current.Status.Conditions, condition)
+	return d.Status().Patch(ctx, current, patch)
+}
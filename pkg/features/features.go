@@ -0,0 +1,214 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features holds the feature gates of the operator, set with --feature-gates, so
+// the new capabilities can ship disabled by default and be enabled cluster by cluster.
+// An alpha feature is disabled by default, a beta one enabled, a GA one can't be disabled
+// anymore and its gate is removed in a later release.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Feature is the name of a feature gate
+type Feature string
+
+// Stage is the maturity of a feature
+type Stage string
+
+const (
+	Alpha Stage = "ALPHA"
+	Beta  Stage = "BETA"
+	GA    Stage = "GA"
+)
+
+// Spec is the default and the maturity of a feature
+type Spec struct {
+	Default bool
+	Stage   Stage
+}
+
+// The features of the operator, add a new one with its spec in specs
+const (
+	// CloudProviders enables the aws, azure, gcp and openstack providers
+	CloudProviders Feature = "CloudProviders"
+	// IPAMWebhook enables the allocation of the egress IPs by the IPAM webhook
+	IPAMWebhook Feature = "IPAMWebhook"
+	// DestinationFQDNs enables the resolution of the destinationFQDNs of the policies
+	DestinationFQDNs Feature = "DestinationFQDNs"
+	// DestinationProviders enables the provider feeds of the destinationProviders
+	DestinationProviders Feature = "DestinationProviders"
+	// KubeVIPLeaseFastPath reads the exit node of the kube-vip provider from the Leases of
+	// the kube-vip per-Service leader election, watched to repatch at once on a failover
+	KubeVIPLeaseFastPath Feature = "KubeVIPLeaseFastPath"
+	// KubeVIPNodeAffinity restricts the kube-vip leader election of the Services to the
+	// eligible nodes of their policies, with the Endpoints of the Services
+	KubeVIPNodeAffinity Feature = "KubeVIPNodeAffinity"
+)
+
+var specs = map[Feature]Spec{
+	CloudProviders:       {Default: true, Stage: Beta},
+	IPAMWebhook:          {Default: true, Stage: Beta},
+	DestinationFQDNs:     {Default: true, Stage: Beta},
+	DestinationProviders: {Default: true, Stage: Beta},
+	KubeVIPLeaseFastPath: {Default: false, Stage: Alpha},
+	KubeVIPNodeAffinity:  {Default: false, Stage: Alpha},
+}
+
+var enabledFeatures = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "haegress_feature_enabled",
+		Help: "Feature gates of the operator, 1 when enabled",
+	},
+	[]string{"name", "stage"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(enabledFeatures)
+}
+
+// Gates holds the features enabled or disabled by the user, the others have their
+// default. It implements flag.Value, as a comma separated list of Feature=bool.
+type Gates struct {
+	mu    sync.RWMutex
+	specs map[Feature]Spec
+	set   map[Feature]bool
+}
+
+// DefaultGates are the gates of the operator, set by --feature-gates
+var DefaultGates = NewGates(specs)
+
+// Enabled returns true when the feature is enabled in DefaultGates
+func Enabled(feature Feature) bool {
+	return DefaultGates.Enabled(feature)
+}
+
+// NewGates returns the gates of the features, with their default
+func NewGates(specs map[Feature]Spec) *Gates {
+	return &Gates{specs: specs, set: map[Feature]bool{}}
+}
+
+// Enabled returns true when the feature is enabled, it panics for an unknown feature,
+// a programming error
+func (g *Gates) Enabled(feature Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	spec, ok := g.specs[feature]
+	if !ok {
+		panic(fmt.Sprintf("unknown feature %q", feature))
+	}
+	if enabled, ok := g.set[feature]; ok {
+		return enabled
+	}
+	return spec.Default
+}
+
+// Set parses Feature=bool pairs separated by commas, the features not listed keep their
+// value. An unknown feature, or a GA one disabled, is an error.
+func (g *Gates) Set(value string) error {
+	set := map[Feature]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, raw, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("missing bool value for %s", pair)
+		}
+		feature := Feature(strings.TrimSpace(name))
+		spec, ok := g.specs[feature]
+		if !ok {
+			return fmt.Errorf("unknown feature gate %s, known gates are %s", feature, g.known())
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid value of %s: %s", feature, raw)
+		}
+		if spec.Stage == GA && !enabled {
+			return fmt.Errorf("the feature %s is GA and can't be disabled", feature)
+		}
+		set[feature] = enabled
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for feature, enabled := range set {
+		g.set[feature] = enabled
+	}
+	return nil
+}
+
+// String returns the features set by the user, in name order
+func (g *Gates) String() string {
+	if g == nil {
+		return ""
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	pairs := make([]string, 0, len(g.set))
+	for feature, enabled := range g.set {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", feature, enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Known returns the description of every feature, for the flag usage
+func (g *Gates) Known() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.known()
+}
+
+// Export sets the haegress_feature_enabled metric of every feature
+func (g *Gates) Export() {
+	for feature, spec := range g.specs {
+		value := 0.0
+		if g.Enabled(feature) {
+			value = 1
+		}
+		enabledFeatures.WithLabelValues(string(feature), string(spec.Stage)).Set(value)
+	}
+}
+
+// Features returns the features and whether they are enabled, in name order, to be logged
+func (g *Gates) Features() []string {
+	features := make([]string, 0, len(g.specs))
+	for feature := range g.specs {
+		features = append(features, fmt.Sprintf("%s=%t", feature, g.Enabled(feature)))
+	}
+	sort.Strings(features)
+	return features
+}
+
+// known returns the features with their stage and default, with the lock held
+func (g *Gates) known() string {
+	features := make([]string, 0, len(g.specs))
+	for feature, spec := range g.specs {
+		features = append(features, fmt.Sprintf("%s=true|false (%s - default=%t)", feature, spec.Stage, spec.Default))
+	}
+	sort.Strings(features)
+	return strings.Join(features, ", ")
+}
@@ -0,0 +1,343 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package federation runs the controllers of the operator against member clusters: every
+// cluster is registered with a Secret holding its kubeconfig, Cluster API style, and gets
+// its own Manager in the process of the operator. The egress IP mappings of the members
+// are aggregated in a ConfigMap of the cluster running the operator.
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=secrets,verbs=get;list
+
+// ClusterLabel selects the kubeconfig Secrets of the member clusters, its value is the
+// name of the cluster
+const ClusterLabel = "cilium.angeloxx.ch/federation-cluster"
+
+// kubeconfigKeys are the keys of the kubeconfig in the Secrets, the first one is the key of
+// the Secrets generated by Cluster API
+var kubeconfigKeys = []string{"value", "kubeconfig"}
+
+// States of the member clusters
+const (
+	StateRunning     = "Running"
+	StateUnavailable = "Unavailable"
+	StateFailed      = "Failed"
+)
+
+var (
+	members = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_federation_member_up",
+			Help: "1 when the controllers of the member cluster are running and its policies can be read",
+		},
+		[]string{"cluster"},
+	)
+
+	memberPolicies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_federation_member_policies",
+			Help: "Number of HAEgressGatewayPolicies of the member cluster",
+		},
+		[]string{"cluster"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(members, memberPolicies)
+}
+
+// SetupFunc registers the controllers of a member cluster on its Manager
+type SetupFunc func(mgr ctrl.Manager, cluster string) error
+
+// ClusterStatus is the aggregated status of a member cluster
+type ClusterStatus struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// Mappings are the egress IP mappings of the policies of the cluster
+	Mappings []mapping.Mapping `json:"mappings"`
+}
+
+// Federation starts a Manager for every member cluster and aggregates their mappings
+type Federation struct {
+	client.Client
+	// Reader is used to read the Secrets without caching every Secret of the cluster
+	Reader client.Reader
+	Log    logr.Logger
+
+	// Namespace holds the kubeconfig Secrets and the status ConfigMap
+	Namespace     string
+	ConfigMapName string
+	// DefaultNamespace is the namespace of the Services of the policies without annotation
+	DefaultNamespace string
+	IntervalSeconds  int
+	// Options are the options of the Managers of the members, the leader election and the
+	// servers are disabled
+	Options ctrl.Options
+	Setup   SetupFunc
+
+	mu      sync.Mutex
+	members map[string]*member
+}
+
+type member struct {
+	name   string
+	hash   string
+	mgr    ctrl.Manager
+	cancel context.CancelFunc
+	// err is the error the Manager stopped with
+	err error
+}
+
+// SetupWithManager registers the federation as a leader-only runnable of the Manager.
+func (f *Federation) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(f)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled, the
+// Managers of the members are stopped with it.
+func (f *Federation) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(f.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := f.syncMembers(ctx); err != nil {
+			f.Log.Error(err, "unable to read the member clusters")
+		}
+		if err := f.aggregate(ctx); err != nil {
+			f.Log.Error(err, "unable to write the status of the member clusters", "ConfigMap", f.ConfigMapName)
+		}
+		select {
+		case <-ctx.Done():
+			f.stopAll()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncMembers starts the Managers of the new members, restarts the ones whose kubeconfig
+// changed and stops the ones removed
+func (f *Federation) syncMembers(ctx context.Context) error {
+	secrets := &corev1.SecretList{}
+	if err := f.Reader.List(ctx, secrets, client.InNamespace(f.Namespace), client.HasLabels{ClusterLabel}); err != nil {
+		return err
+	}
+	kubeconfigs := map[string][]byte{}
+	for _, secret := range secrets.Items {
+		name := secret.Labels[ClusterLabel]
+		for _, key := range kubeconfigKeys {
+			if data := secret.Data[key]; len(data) > 0 {
+				kubeconfigs[name] = data
+				break
+			}
+		}
+		if kubeconfigs[name] == nil {
+			f.Log.Info("Secret of the member cluster without kubeconfig, ignoring it", "Secret", secret.Name, "cluster", name)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.members == nil {
+		f.members = make(map[string]*member)
+	}
+	for name, m := range f.members {
+		if _, ok := kubeconfigs[name]; !ok {
+			f.Log.Info("Member cluster removed, stopping its controllers", "cluster", name)
+			m.cancel()
+			delete(f.members, name)
+			members.DeleteLabelValues(name)
+			memberPolicies.DeleteLabelValues(name)
+		}
+	}
+	for name, kubeconfig := range kubeconfigs {
+		sum := sha256.Sum256(kubeconfig)
+		hash := hex.EncodeToString(sum[:])
+		if m, ok := f.members[name]; ok {
+			if m.hash == hash && m.err == nil {
+				continue
+			}
+			f.Log.Info("Kubeconfig of the member cluster changed, restarting its controllers", "cluster", name)
+			m.cancel()
+			delete(f.members, name)
+		}
+		m, err := f.start(ctx, name, hash, kubeconfig)
+		if err != nil {
+			f.Log.Error(err, "unable to start the controllers of the member cluster", "cluster", name)
+			members.WithLabelValues(name).Set(0)
+			f.members[name] = &member{name: name, hash: hash, cancel: func() {}, err: err}
+			continue
+		}
+		f.Log.Info("Controllers of the member cluster started", "cluster", name)
+		f.members[name] = m
+	}
+	return nil
+}
+
+// start creates the Manager of the member and starts it in the background
+func (f *Federation) start(ctx context.Context, name, hash string, kubeconfig []byte) (*member, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	config.UserAgent = haegressip.FieldManager
+	options := f.Options
+	options.LeaderElection = false
+	options.Metrics = metricsserver.Options{BindAddress: "0"}
+	options.HealthProbeBindAddress = "0"
+	options.Logger = f.Log.WithValues("cluster", name)
+	mgr, err := ctrl.NewManager(config, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Setup(mgr, name); err != nil {
+		return nil, err
+	}
+
+	memberCtx, cancel := context.WithCancel(ctx)
+	m := &member{name: name, hash: hash, mgr: mgr, cancel: cancel}
+	go func() {
+		err := mgr.Start(memberCtx)
+		if err != nil {
+			f.Log.Error(err, "controllers of the member cluster stopped", "cluster", name)
+		}
+		f.mu.Lock()
+		m.err = err
+		f.mu.Unlock()
+	}()
+	return m, nil
+}
+
+func (f *Federation) stopAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.members {
+		m.cancel()
+	}
+}
+
+// Status returns the status of every member cluster
+func (f *Federation) Status(ctx context.Context) map[string]ClusterStatus {
+	f.mu.Lock()
+	running := make([]*member, 0, len(f.members))
+	status := make(map[string]ClusterStatus, len(f.members))
+	for name, m := range f.members {
+		if m.err != nil {
+			status[name] = ClusterStatus{State: StateFailed, Error: m.err.Error(), Mappings: []mapping.Mapping{}}
+			continue
+		}
+		running = append(running, m)
+	}
+	f.mu.Unlock()
+
+	for _, m := range running {
+		mappings, err := mapping.List(ctx, m.mgr.GetClient(), f.DefaultNamespace)
+		if err != nil {
+			status[m.name] = ClusterStatus{State: StateUnavailable, Error: err.Error(), Mappings: []mapping.Mapping{}}
+			continue
+		}
+		status[m.name] = ClusterStatus{State: StateRunning, Mappings: mappings}
+	}
+	return status
+}
+
+// aggregate writes the status of the members in the ConfigMap, under a key named after
+// the cluster
+func (f *Federation) aggregate(ctx context.Context) error {
+	data := map[string]string{}
+	for name, status := range f.Status(ctx) {
+		up := 0.0
+		if status.State == StateRunning {
+			up = 1
+		}
+		members.WithLabelValues(name).Set(up)
+		memberPolicies.WithLabelValues(name).Set(float64(len(status.Mappings)))
+		encoded, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		data[name] = string(encoded)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := f.Reader.Get(ctx, types.NamespacedName{Name: f.ConfigMapName, Namespace: f.Namespace}, configMap)
+	if err != nil && apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      f.ConfigMapName,
+				Namespace: f.Namespace,
+			},
+			Data: data,
+		}
+		f.Log.Info("Creating the federation status ConfigMap", "ConfigMap", f.ConfigMapName)
+		return f.Create(ctx, configMap)
+	} else if err != nil {
+		return err
+	}
+
+	if equalData(configMap.Data, data) {
+		return nil
+	}
+	configMap.Data = data
+	f.Log.V(1).Info("Updating the federation status", "ConfigMap", f.ConfigMapName, "clusters", sortedNames(data))
+	return f.Update(ctx, configMap)
+}
+
+func equalData(current, desired map[string]string) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for key, value := range desired {
+		if current[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedNames(data map[string]string) []string {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
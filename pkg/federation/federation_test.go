@@ -0,0 +1,149 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: member
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: member
+  context:
+    cluster: member
+    user: member
+current-context: member
+users:
+- name: member
+  user:
+    token: secret
+`
+
+func kubeconfigSecret(name, cluster, key, kubeconfig string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "egress-system", Labels: map[string]string{ClusterLabel: cluster}},
+		Data:       map[string][]byte{key: []byte(kubeconfig)},
+	}
+}
+
+func TestSyncMembers(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithObjects(
+		kubeconfigSecret("eu-1-kubeconfig", "eu-1", "value", testKubeconfig),
+		kubeconfigSecret("us-1-kubeconfig", "us-1", "kubeconfig", testKubeconfig),
+		kubeconfigSecret("ap-1-kubeconfig", "ap-1", "value", "server: ["),
+		kubeconfigSecret("sa-1-kubeconfig", "sa-1", "token", "secret"),
+		// Not a member cluster
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "egress-system"}},
+	).Build()
+	setups := []string{}
+	federation := &Federation{
+		Client:        c,
+		Reader:        c,
+		Log:           logr.Discard(),
+		Namespace:     "egress-system",
+		ConfigMapName: "haegress-federation",
+		// The controllers of the members are not started
+		Setup: func(_ ctrl.Manager, cluster string) error {
+			setups = append(setups, cluster)
+			return errors.New("no controllers in the test")
+		},
+	}
+
+	if err := federation.syncMembers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(setups)
+	if expected := []string{"eu-1", "us-1"}; !reflect.DeepEqual(setups, expected) {
+		t.Errorf("the controllers were set up for %v, expected %v", setups, expected)
+	}
+	tests := []struct {
+		cluster string
+		error   string
+	}{
+		{cluster: "eu-1", error: "no controllers in the test"},
+		{cluster: "us-1", error: "no controllers in the test"},
+		{cluster: "ap-1", error: "invalid kubeconfig: "},
+	}
+	status := federation.Status(ctx)
+	if len(status) != len(tests) {
+		t.Errorf("the status has %d clusters, expected %d", len(status), len(tests))
+	}
+	for _, test := range tests {
+		cluster := status[test.cluster]
+		if cluster.State != StateFailed || !strings.HasPrefix(cluster.Error, test.error) {
+			t.Errorf("the cluster %s has the state %s and the error %q, expected %s and %q", test.cluster, cluster.State, cluster.Error, StateFailed, test.error)
+		}
+	}
+
+	// The status is aggregated in the ConfigMap, a key per cluster
+	if err := federation.aggregate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "haegress-federation", Namespace: "egress-system"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if names := sortedNames(configMap.Data); !reflect.DeepEqual(names, []string{"ap-1", "eu-1", "us-1"}) {
+		t.Errorf("the ConfigMap has the clusters %v", names)
+	}
+	aggregated := ClusterStatus{}
+	if err := json.Unmarshal([]byte(configMap.Data["eu-1"]), &aggregated); err != nil {
+		t.Fatal(err)
+	}
+	if aggregated.State != StateFailed || aggregated.Mappings == nil {
+		t.Errorf("the ConfigMap has the status %+v of eu-1", aggregated)
+	}
+
+	// The failed members are started again, the removed ones are forgotten
+	if err := c.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "us-1-kubeconfig", Namespace: "egress-system"}}); err != nil {
+		t.Fatal(err)
+	}
+	setups = nil
+	if err := federation.syncMembers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"eu-1"}; !reflect.DeepEqual(setups, expected) {
+		t.Errorf("the controllers were set up again for %v, expected %v", setups, expected)
+	}
+	if _, ok := federation.Status(ctx)["us-1"]; ok {
+		t.Errorf("the removed cluster us-1 is still a member")
+	}
+}
+
+func TestEqualData(t *testing.T) {
+	tests := []struct {
+		name             string
+		current, desired map[string]string
+		equal            bool
+	}{
+		{name: "same data", current: map[string]string{"eu-1": "{}"}, desired: map[string]string{"eu-1": "{}"}, equal: true},
+		{name: "no data", current: nil, desired: map[string]string{}, equal: true},
+		{name: "changed status", current: map[string]string{"eu-1": "{}"}, desired: map[string]string{"eu-1": `{"state":"Running"}`}},
+		{name: "new cluster", current: map[string]string{"eu-1": "{}"}, desired: map[string]string{"eu-1": "{}", "us-1": "{}"}},
+		{name: "removed cluster", current: map[string]string{"eu-1": "{}", "us-1": "{}"}, desired: map[string]string{"eu-1": "{}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if equal := equalData(test.current, test.desired); equal != test.equal {
+				t.Errorf("the data are equal: %t, expected %t", equal, test.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package feeds fetches the IP ranges published by the providers, like the cloud provider
+// JSON files or an internal URL, and expands the destinationProviders of the policies,
+// e.g. azure:AzureActiveDirectory, into destination CIDRs. Every feed is fetched again on
+// its schedule, and the policies using a range whose CIDRs changed are queued.
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// checkInterval is the period the feeds to fetch again are looked for
+const checkInterval = 10 * time.Second
+
+// retryInterval is the delay before fetching again a feed that failed, unless its
+// refresh interval is shorter
+const retryInterval = 5 * time.Minute
+
+// maxFeedSize bounds the size of a feed, the Service Tags of Azure are a few MB
+const maxFeedSize = 64 << 20
+
+// Results of the fetches
+const (
+	resultChanged   = "changed"
+	resultUnchanged = "unchanged"
+	resultFailed    = "failed"
+)
+
+var (
+	fetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_feed_fetches_total",
+			Help: "Fetches of the provider feeds by feed and result: changed, unchanged or failed",
+		},
+		[]string{"feed", "result"},
+	)
+
+	lastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_feed_last_success_timestamp_seconds",
+			Help: "Time of the last successful fetch of every provider feed",
+		},
+		[]string{"feed"},
+	)
+
+	rangeCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_feed_ranges",
+			Help: "Number of ranges with IPv4 CIDRs of every provider feed",
+		},
+		[]string{"feed"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(fetches, lastSuccess, rangeCount)
+}
+
+// Feed is a published list of IP ranges
+type Feed struct {
+	// Name is the feed of the references, e.g. azure for azure:AzureActiveDirectory
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Format string `json:"format"`
+	// Headers are sent with the requests, e.g. the authorization of an internal URL
+	Headers map[string]string `json:"headers,omitempty"`
+	// RefreshSeconds is the period the feed is fetched again, 3600 when zero
+	RefreshSeconds int `json:"refreshSeconds,omitempty"`
+	// TimeoutSeconds limits a single fetch, 60 when zero
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// Config is the content of the feeds configuration file
+type Config struct {
+	Feeds []Feed `json:"feeds"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the feeds
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i := range config.Feeds {
+		feed := &config.Feeds[i]
+		if problems := validation.IsDNS1123Label(feed.Name); len(problems) > 0 {
+			return nil, fmt.Errorf("invalid feed name %q: %s", feed.Name, strings.Join(problems, "; "))
+		}
+		if seen[feed.Name] {
+			return nil, fmt.Errorf("the feed %q is repeated", feed.Name)
+		}
+		seen[feed.Name] = true
+		if parsers[feed.Format] == nil {
+			return nil, fmt.Errorf("invalid format %q of the feed %q: must be %s, %s, %s or %s",
+				feed.Format, feed.Name, FormatAWS, FormatAzure, FormatGCP, FormatJSON)
+		}
+		if parsed, err := url.Parse(feed.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q of the feed %q", feed.URL, feed.Name)
+		}
+		if feed.RefreshSeconds == 0 {
+			feed.RefreshSeconds = 3600
+		}
+		if feed.TimeoutSeconds == 0 {
+			feed.TimeoutSeconds = 60
+		}
+	}
+	return config, nil
+}
+
+// Cache holds the ranges of the feeds, fetched again every RefreshSeconds
+type Cache struct {
+	Config *Config
+	Log    logr.Logger
+	Client *http.Client
+	// Events receives the policies using a range whose CIDRs changed
+	Events chan event.GenericEvent
+	// LeaderElection is false in sharding mode, where every replica reconciles its own
+	// policies
+	LeaderElection bool
+
+	mu       sync.Mutex
+	states   map[string]*state
+	policies map[string][]string
+}
+
+// state is the last fetch of a feed
+type state struct {
+	feed *Feed
+	// fetching serializes the fetches of the feed
+	fetching  sync.Mutex
+	attempted bool
+	ranges    map[string][]string
+	etag      string
+	next      time.Time
+	err       error
+}
+
+// SetupWithManager registers the cache as a runnable of the Manager.
+func (c *Cache) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false in sharding mode
+func (c *Cache) NeedLeaderElection() bool {
+	return c.LeaderElection
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (c *Cache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		c.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// CIDRs returns the CIDRs of the ranges referenced by the policy, sorted, and remembers
+// the references so the policy is queued when they change. The feeds never fetched are
+// fetched now. A feed that can't be fetched keeps its last ranges, the error is returned
+// for the references without CIDRs.
+func (c *Cache) CIDRs(ctx context.Context, policy string, references []string) ([]string, error) {
+	c.mu.Lock()
+	c.init()
+	c.policies[policy] = references
+	missing := []*state{}
+	for _, reference := range references {
+		feed, _ := split(reference)
+		if current := c.states[feed]; current != nil && !current.attempted {
+			missing = append(missing, current)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, current := range missing {
+		c.fetch(ctx, current)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := map[string]bool{}
+	cidrs := []string{}
+	var errs []string
+	for _, reference := range references {
+		feed, name := split(reference)
+		current := c.states[feed]
+		switch {
+		case current == nil:
+			errs = append(errs, fmt.Sprintf("%s: feed %q not configured", reference, feed))
+			continue
+		case current.ranges == nil && current.err != nil:
+			errs = append(errs, fmt.Sprintf("%s: %s", reference, current.err))
+			continue
+		case current.ranges[name] == nil:
+			errs = append(errs, fmt.Sprintf("%s: no IPv4 range %q in the feed", reference, name))
+			continue
+		}
+		for _, cidr := range current.ranges[name] {
+			if !seen[cidr] {
+				seen[cidr] = true
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+	sort.Strings(cidrs)
+	if len(errs) > 0 {
+		return cidrs, fmt.Errorf("unable to expand %s", strings.Join(errs, ", "))
+	}
+	return cidrs, nil
+}
+
+// Forget removes the references of a deleted policy, or of a policy without
+// destinationProviders
+func (c *Cache) Forget(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.policies, policy)
+}
+
+// init creates the state of every feed, with the lock held
+func (c *Cache) init() {
+	if c.states != nil {
+		return
+	}
+	c.states = make(map[string]*state)
+	c.policies = make(map[string][]string)
+	for i := range c.Config.Feeds {
+		c.states[c.Config.Feeds[i].Name] = &state{feed: &c.Config.Feeds[i]}
+	}
+}
+
+// refresh fetches the feeds on their schedule and queues the policies of the ranges whose
+// CIDRs changed
+func (c *Cache) refresh(ctx context.Context) {
+	now := time.Now()
+	c.mu.Lock()
+	c.init()
+	due := []*state{}
+	for i := range c.Config.Feeds {
+		current := c.states[c.Config.Feeds[i].Name]
+		if !current.attempted || now.After(current.next) {
+			due = append(due, current)
+		}
+	}
+	c.mu.Unlock()
+
+	changed := map[string]bool{}
+	for _, current := range due {
+		for reference := range c.fetch(ctx, current) {
+			changed[reference] = true
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	queue := []string{}
+	for policy, references := range c.policies {
+		for _, reference := range references {
+			if changed[reference] {
+				queue = append(queue, policy)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(queue)
+
+	for _, policy := range queue {
+		object := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: policy}}
+		select {
+		case c.Events <- event.GenericEvent{Object: object}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fetch downloads the feed and returns the references whose CIDRs changed. A failure
+// keeps the last ranges, and the feed is fetched again after retryInterval.
+func (c *Cache) fetch(ctx context.Context, current *state) map[string]bool {
+	current.fetching.Lock()
+	defer current.fetching.Unlock()
+
+	feed := current.feed
+	c.mu.Lock()
+	// Another fetch completed while waiting
+	if current.attempted && time.Now().Before(current.next) {
+		c.mu.Unlock()
+		return nil
+	}
+	etag := current.etag
+	c.mu.Unlock()
+
+	refresh := time.Duration(feed.RefreshSeconds) * time.Second
+	ranges, newETag, err := c.download(ctx, feed, etag)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current.attempted = true
+	if err != nil {
+		fetches.WithLabelValues(feed.Name, resultFailed).Inc()
+		c.Log.Error(err, "unable to fetch the feed, keeping its last ranges", "feed", feed.Name, "url", feed.URL)
+		current.err = err
+		current.next = time.Now().Add(min(retryInterval, refresh))
+		return nil
+	}
+	current.err = nil
+	current.next = time.Now().Add(refresh)
+	lastSuccess.WithLabelValues(feed.Name).SetToCurrentTime()
+	// Not modified since the last fetch
+	if ranges == nil {
+		fetches.WithLabelValues(feed.Name, resultUnchanged).Inc()
+		return nil
+	}
+
+	changed := map[string]bool{}
+	for name, cidrs := range ranges {
+		if !equal(current.ranges[name], cidrs) {
+			changed[feed.Name+":"+name] = true
+		}
+	}
+	for name := range current.ranges {
+		if ranges[name] == nil {
+			changed[feed.Name+":"+name] = true
+		}
+	}
+	current.ranges = ranges
+	current.etag = newETag
+	rangeCount.WithLabelValues(feed.Name).Set(float64(len(ranges)))
+	if len(changed) == 0 {
+		fetches.WithLabelValues(feed.Name, resultUnchanged).Inc()
+		return nil
+	}
+	fetches.WithLabelValues(feed.Name, resultChanged).Inc()
+	c.Log.Info("Ranges of the feed changed", "feed", feed.Name, "ranges", len(ranges), "changed", len(changed))
+	return changed
+}
+
+// download returns the ranges of the feed and its ETag, nil ranges when the feed is not
+// modified since the ETag
+func (c *Cache) download(ctx context.Context, feed *Feed, etag string) (map[string][]string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(feed.TimeoutSeconds)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range feed.Headers {
+		req.Header.Set(name, value)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	httpClient := c.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", fmt.Errorf("feed fetch failed with status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxFeedSize {
+		return nil, "", fmt.Errorf("feed larger than %d bytes", maxFeedSize)
+	}
+	ranges, err := parsers[feed.Format](data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s feed: %w", feed.Format, err)
+	}
+	return ranges, resp.Header.Get("ETag"), nil
+}
+
+// split returns the feed and the range name of a reference, feed:name
+func split(reference string) (string, string) {
+	feed, name, _ := strings.Cut(reference, ":")
+	return feed, name
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package feeds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// Formats of the feeds
+const (
+	// FormatAWS is the ip-ranges.json of AWS, the ranges are named after the service, e.g.
+	// S3, and after the service and the region, e.g. S3/eu-west-1
+	FormatAWS = "aws"
+	// FormatAzure is the Service Tags file of Azure, the ranges are named after the tag,
+	// e.g. AzureActiveDirectory or Storage.WestEurope
+	FormatAzure = "azure"
+	// FormatGCP is the cloud.json of Google Cloud, the ranges are named after the service
+	// and after the service and the scope, e.g. Google Cloud/europe-west1
+	FormatGCP = "gcp"
+	// FormatJSON is an object with the CIDRs of every range, for the internal feeds:
+	// {"office": ["192.0.2.0/24"]}
+	FormatJSON = "json"
+)
+
+// parsers extract the IPv4 CIDRs of the ranges of a feed, the IPv6 ones are skipped
+var parsers = map[string]func([]byte) (map[string][]string, error){
+	FormatAWS:   parseAWS,
+	FormatAzure: parseAzure,
+	FormatGCP:   parseGCP,
+	FormatJSON:  parseJSON,
+}
+
+func parseAWS(data []byte) (map[string][]string, error) {
+	feed := struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+	}{}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	ranges := newRanges()
+	for _, prefix := range feed.Prefixes {
+		ranges.add(prefix.Service, prefix.IPPrefix)
+		if prefix.Region != "" {
+			ranges.add(prefix.Service+"/"+prefix.Region, prefix.IPPrefix)
+		}
+	}
+	return ranges.sorted()
+}
+
+func parseAzure(data []byte) (map[string][]string, error) {
+	feed := struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}{}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	ranges := newRanges()
+	for _, value := range feed.Values {
+		for _, prefix := range value.Properties.AddressPrefixes {
+			ranges.add(value.Name, prefix)
+		}
+	}
+	return ranges.sorted()
+}
+
+func parseGCP(data []byte) (map[string][]string, error) {
+	feed := struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}{}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	ranges := newRanges()
+	for _, prefix := range feed.Prefixes {
+		if prefix.IPv4Prefix == "" {
+			continue
+		}
+		ranges.add(prefix.Service, prefix.IPv4Prefix)
+		if prefix.Scope != "" {
+			ranges.add(prefix.Service+"/"+prefix.Scope, prefix.IPv4Prefix)
+		}
+	}
+	return ranges.sorted()
+}
+
+func parseJSON(data []byte) (map[string][]string, error) {
+	feed := map[string][]string{}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	ranges := newRanges()
+	for name, prefixes := range feed {
+		for _, prefix := range prefixes {
+			ranges.add(name, prefix)
+		}
+	}
+	return ranges.sorted()
+}
+
+// ranges collects the CIDRs of every range, without duplicates
+type ranges struct {
+	cidrs map[string]map[string]bool
+	err   error
+}
+
+func newRanges() *ranges {
+	return &ranges{cidrs: map[string]map[string]bool{}}
+}
+
+// add adds the prefix to the range when it is IPv4, a host address is a /32
+func (r *ranges) add(name, prefix string) {
+	if name == "" || r.err != nil {
+		return
+	}
+	parsed, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		address, addrErr := netip.ParseAddr(prefix)
+		if addrErr != nil {
+			r.err = fmt.Errorf("invalid CIDR %q of the range %q", prefix, name)
+			return
+		}
+		parsed = netip.PrefixFrom(address, address.BitLen())
+	}
+	if !parsed.Addr().Is4() {
+		return
+	}
+	if r.cidrs[name] == nil {
+		r.cidrs[name] = map[string]bool{}
+	}
+	r.cidrs[name][parsed.Masked().String()] = true
+}
+
+// sorted returns the sorted CIDRs of every range with at least one IPv4 CIDR
+func (r *ranges) sorted() (map[string][]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	sorted := make(map[string][]string, len(r.cidrs))
+	for name, cidrs := range r.cidrs {
+		list := make([]string, 0, len(cidrs))
+		for cidr := range cidrs {
+			list = append(list, cidr)
+		}
+		sort.Strings(list)
+		sorted[name] = list
+	}
+	return sorted, nil
+}
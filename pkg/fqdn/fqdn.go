@@ -0,0 +1,320 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fqdn resolves the destinationFQDNs of the policies: every name is resolved again
+// when its records expire, and the policies using a name whose addresses changed are
+// queued, so their CiliumEgressGatewayPolicies get the new destination CIDRs.
+package fqdn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// checkInterval is the period the expired names are looked for
+const checkInterval = time.Second
+
+// Results of the resolutions
+const (
+	resultChanged   = "changed"
+	resultUnchanged = "unchanged"
+	resultFailed    = "failed"
+)
+
+var (
+	resolutions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_fqdn_resolutions_total",
+			Help: "Resolutions of the destination FQDNs of the policies by result: changed, unchanged or failed",
+		},
+		[]string{"result"},
+	)
+
+	names = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_fqdn_names",
+			Help: "Number of destination FQDNs resolved for the policies",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(resolutions, names)
+}
+
+// Cache holds the addresses of the destination FQDNs of the policies, each one until its
+// TTL, bounded by MinTTL and MaxTTL, expires
+type Cache struct {
+	Resolver *Resolver
+	Log      logr.Logger
+	// MinTTL avoids resolving again and again the names with a short TTL, like the ones
+	// of the load balanced SaaS endpoints, MaxTTL bounds the age of the addresses
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// Events receives the policies using a name whose addresses changed
+	Events chan event.GenericEvent
+	// LeaderElection is false in sharding mode, where every replica reconciles its own
+	// policies
+	LeaderElection bool
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	policies map[string][]string
+}
+
+type entry struct {
+	addresses []string
+	expires   time.Time
+	err       error
+}
+
+// SetupWithManager registers the cache as a runnable of the Manager.
+func (c *Cache) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false in sharding mode
+func (c *Cache) NeedLeaderElection() bool {
+	return c.LeaderElection
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (c *Cache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.refresh(ctx)
+		}
+	}
+}
+
+// CIDRs returns the /32 CIDRs of the addresses of the names of the policy, sorted, and
+// remembers the names so they are resolved again when they expire. The names never
+// resolved are resolved now. A name that can't be resolved keeps its last addresses, the
+// error is returned for the names without addresses.
+func (c *Cache) CIDRs(ctx context.Context, policy string, fqdns []string) ([]string, error) {
+	normalized := make([]string, 0, len(fqdns))
+	for _, name := range fqdns {
+		normalized = append(normalized, normalize(name))
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*entry)
+		c.policies = make(map[string][]string)
+	}
+	c.policies[policy] = normalized
+	missing := []string{}
+	for _, name := range normalized {
+		if _, ok := c.entries[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, name := range missing {
+		c.resolve(ctx, name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	seen := map[string]bool{}
+	cidrs := []string{}
+	var errs []string
+	for _, name := range normalized {
+		current := c.entries[name]
+		if current == nil {
+			continue
+		}
+		if len(current.addresses) == 0 && current.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, current.err))
+		}
+		for _, address := range current.addresses {
+			if cidr := address + "/32"; !seen[cidr] {
+				seen[cidr] = true
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+	sort.Strings(cidrs)
+	if len(errs) > 0 {
+		return cidrs, fmt.Errorf("unable to resolve %s", strings.Join(errs, ", "))
+	}
+	return cidrs, nil
+}
+
+// Forget removes the names of a deleted policy, or of a policy without destinationFQDNs
+func (c *Cache) Forget(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.policies[policy]; !ok {
+		return
+	}
+	delete(c.policies, policy)
+	c.prune()
+}
+
+// refresh resolves the expired names and queues the policies of the names whose addresses
+// changed
+func (c *Cache) refresh(ctx context.Context) {
+	now := time.Now()
+	c.mu.Lock()
+	expired := []string{}
+	for name, current := range c.entries {
+		if now.After(current.expires) {
+			expired = append(expired, name)
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(expired)
+
+	changed := map[string]bool{}
+	for _, name := range expired {
+		if c.resolve(ctx, name) {
+			changed[name] = true
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	queue := []string{}
+	for policy, fqdns := range c.policies {
+		for _, name := range fqdns {
+			if changed[name] {
+				queue = append(queue, policy)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(queue)
+
+	for _, policy := range queue {
+		object := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: policy}}
+		select {
+		case c.Events <- event.GenericEvent{Object: object}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolve looks up the name and returns true when its addresses changed. A failure keeps
+// the last addresses, and the name is resolved again after MinTTL.
+func (c *Cache) resolve(ctx context.Context, name string) bool {
+	addresses, ttl, err := c.Resolver.LookupIPv4(ctx, name)
+	resolved := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		resolved = append(resolved, address.String())
+	}
+	sort.Strings(resolved)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The policies may have dropped the name during the lookup
+	if !c.used()[name] {
+		return false
+	}
+	previous := c.entries[name]
+	if err != nil {
+		resolutions.WithLabelValues(resultFailed).Inc()
+		c.Log.Error(err, "unable to resolve the destination FQDN, keeping its last addresses", "fqdn", name)
+		current := &entry{expires: time.Now().Add(c.MinTTL), err: err}
+		if previous != nil {
+			current.addresses = previous.addresses
+		}
+		c.entries[name] = current
+		return false
+	}
+
+	c.entries[name] = &entry{addresses: resolved, expires: time.Now().Add(c.bound(ttl))}
+	if previous != nil && equal(previous.addresses, resolved) {
+		resolutions.WithLabelValues(resultUnchanged).Inc()
+		return false
+	}
+	resolutions.WithLabelValues(resultChanged).Inc()
+	c.Log.Info("Addresses of the destination FQDN changed", "fqdn", name, "addresses", resolved, "ttl", ttl.String())
+	return previous != nil
+}
+
+// bound returns the TTL within MinTTL and MaxTTL
+func (c *Cache) bound(ttl time.Duration) time.Duration {
+	if ttl < c.MinTTL {
+		return c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return ttl
+}
+
+// prune removes the names no longer used by a policy, with the lock held
+func (c *Cache) prune() {
+	used := c.used()
+	for name := range c.entries {
+		if !used[name] {
+			delete(c.entries, name)
+		}
+	}
+	names.Set(float64(len(c.entries)))
+}
+
+// used returns the names of the policies, with the lock held
+func (c *Cache) used() map[string]bool {
+	used := map[string]bool{}
+	for _, fqdns := range c.policies {
+		for _, name := range fqdns {
+			used[name] = true
+		}
+	}
+	return used
+}
+
+// normalize returns the name in lower case without the trailing dot
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package fqdn
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is read for the DNS server when none is configured
+const resolvConf = "/etc/resolv.conf"
+
+// maxUDPSize is the size of the DNS messages over UDP without EDNS, a longer answer is
+// truncated and asked again over TCP
+const maxUDPSize = 512
+
+// errNoSuchHost is returned for the names without records
+var errNoSuchHost = errors.New("no such host")
+
+// Resolver queries the A records of the names, with their TTL that the resolver of the
+// standard library does not return
+type Resolver struct {
+	// Server is the address of the DNS server, host:port, the first nameserver of
+	// /etc/resolv.conf when empty
+	Server  string
+	Timeout time.Duration
+}
+
+// LookupIPv4 returns the IPv4 addresses of the name and the lowest TTL of the records of
+// the answer, the CNAMEs included
+func (r *Resolver) LookupIPv4(ctx context.Context, name string) ([]netip.Addr, time.Duration, error) {
+	server, err := r.server()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	question, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid name %q: %w", name, err)
+	}
+	id := uint16(rand.Uint32())
+	query := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	query.EnableCompression()
+	if err := query.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := query.Question(dnsmessage.Question{Name: question, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	message, err := query.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	answer, err := exchange(ctx, "udp", server, message)
+	if err == nil && truncated(answer) {
+		answer, err = exchange(ctx, "tcp", server, message)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return parse(answer, id)
+}
+
+// server returns the configured server, or the first nameserver of /etc/resolv.conf
+func (r *Resolver) server() (string, error) {
+	if r.Server != "" {
+		if _, _, err := net.SplitHostPort(r.Server); err != nil {
+			return net.JoinHostPort(r.Server, "53"), nil
+		}
+		return r.Server, nil
+	}
+	file, err := os.Open(resolvConf)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the DNS server: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in %s", resolvConf)
+}
+
+// exchange sends the query and returns the answer, over TCP the messages are prefixed by
+// their length
+func exchange(ctx context.Context, network, server string, message []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(message); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, maxUDPSize)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		return answer[:n], nil
+	}
+
+	framed := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(framed, uint16(len(message)))
+	copy(framed[2:], message)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+func truncated(answer []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(answer)
+	return err == nil && header.Truncated
+}
+
+// parse returns the A records of the answer and their lowest TTL
+func parse(answer []byte, id uint16) ([]netip.Addr, time.Duration, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(answer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.ID != id || !header.Response {
+		return nil, 0, fmt.Errorf("unexpected DNS answer")
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, errNoSuchHost
+	default:
+		return nil, 0, fmt.Errorf("DNS server failure: %s", header.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	addresses := []netip.Addr{}
+	var ttl uint32
+	for first := true; ; first = false {
+		record, err := parser.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if first || record.TTL < ttl {
+			ttl = record.TTL
+		}
+		if record.Type != dnsmessage.TypeA || record.Class != dnsmessage.ClassINET {
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		a, err := parser.AResource()
+		if err != nil {
+			return nil, 0, err
+		}
+		addresses = append(addresses, netip.AddrFrom4(a.A))
+	}
+	if len(addresses) == 0 {
+		return nil, 0, errNoSuchHost
+	}
+	return addresses, time.Duration(ttl) * time.Second, nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hubble
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	resultCorrect = "correct"
+	resultWrong   = "wrong"
+)
+
+var (
+	egressCorrectness = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_observed_egress_correctness_ratio",
+			Help: "Ratio of sampled egress flows leaving through the expected exit node",
+		},
+		[]string{"policy"},
+	)
+
+	observedFlows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_observed_egress_flows_total",
+			Help: "Number of egress flows sampled from Hubble Relay, by result",
+		},
+		[]string{"policy", "result"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(egressCorrectness, observedFlows)
+}
+
+// forgetPolicy removes the metrics of a deleted policy
+func forgetPolicy(name string) {
+	egressCorrectness.DeleteLabelValues(name)
+	observedFlows.DeleteLabelValues(name, resultCorrect)
+	observedFlows.DeleteLabelValues(name, resultWrong)
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hubble samples flows from Hubble Relay and checks that the traffic
+// of the pods selected by a HAEgressGatewayPolicy leaves the cluster through
+// the expected exit node.
+package hubble
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	flowpb "github.com/cilium/cilium/api/v1/flow"
+	observerpb "github.com/cilium/cilium/api/v1/observer"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// worldLabel is the identity label Cilium assigns to destinations outside the cluster
+	worldLabel = "reserved:world"
+	// namespaceLabelPrefix is the prefix used by Cilium to expose namespace labels on pod identities
+	namespaceLabelPrefix = "io.cilium.k8s.namespace.labels."
+)
+
+// Observer periodically samples Hubble flows for every HAEgressGatewayPolicy and
+// exports the observed egress correctness as a metric.
+type Observer struct {
+	client.Client
+	Log logr.Logger
+
+	// RelayAddress is the host:port of the Hubble Relay gRPC endpoint
+	RelayAddress string
+	// RelayCAFile enables TLS towards Hubble Relay when set
+	RelayCAFile string
+	// IntervalSeconds is the time between two sampling rounds
+	IntervalSeconds int
+	// SampleFlows is the maximum number of flows fetched per policy and round
+	SampleFlows int
+
+	// observed are the policies with metrics, removed once the policy is deleted
+	observed map[string]bool
+}
+
+// SetupWithManager registers the observer as a leader-only runnable of the Manager.
+func (o *Observer) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(o)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (o *Observer) Start(ctx context.Context) error {
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	observerClient := observerpb.NewObserverClient(conn)
+	ticker := time.NewTicker(time.Duration(o.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			o.sampleAll(ctx, observerClient)
+		}
+	}
+}
+
+func (o *Observer) dial() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if o.RelayCAFile != "" {
+		ca, err := os.ReadFile(o.RelayCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Hubble Relay CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate found in %s", o.RelayCAFile)
+		}
+		creds = credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	conn, err := grpc.Dial(o.RelayAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to Hubble Relay %s: %w", o.RelayAddress, err)
+	}
+	return conn, nil
+}
+
+func (o *Observer) sampleAll(ctx context.Context, observerClient observerpb.ObserverClient) {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := o.List(ctx, &policies); err != nil {
+		o.Log.Error(err, "failed to list HAEgressGatewayPolicies")
+		return
+	}
+
+	// The metrics of the deleted policies are removed
+	if o.observed == nil {
+		o.observed = map[string]bool{}
+	}
+	existing := make(map[string]bool, len(policies.Items))
+	for _, policy := range policies.Items {
+		existing[policy.Name] = true
+	}
+	for name := range o.observed {
+		if !existing[name] {
+			forgetPolicy(name)
+			delete(o.observed, name)
+		}
+	}
+
+	for _, policy := range policies.Items {
+		if policy.Status.ExitNode == "" {
+			continue
+		}
+		logger := o.Log.WithValues("HAEgressGatewayPolicy", policy.Name)
+
+		correct, wrong, err := o.samplePolicy(ctx, observerClient, &policy)
+		if err != nil {
+			logger.Error(err, "unable to sample flows from Hubble Relay")
+			continue
+		}
+
+		o.observed[policy.Name] = true
+		observedFlows.WithLabelValues(policy.Name, resultCorrect).Add(float64(correct))
+		observedFlows.WithLabelValues(policy.Name, resultWrong).Add(float64(wrong))
+		if correct+wrong == 0 {
+			logger.V(1).Info("No egress flows observed for policy")
+			continue
+		}
+		ratio := float64(correct) / float64(correct+wrong)
+		egressCorrectness.WithLabelValues(policy.Name).Set(ratio)
+		if wrong > 0 {
+			logger.Info("Egress traffic observed outside the expected exit node",
+				"ExitNode", policy.Status.ExitNode, "correct", correct, "wrong", wrong)
+		}
+	}
+}
+
+// samplePolicy fetches the latest flows towards the world generated by the pods
+// selected by the policy and classifies them with classifyFlow.
+func (o *Observer) samplePolicy(ctx context.Context, observerClient observerpb.ObserverClient, policy *haegressv2.HAEgressGatewayPolicy) (int, int, error) {
+	sourceLabels := sourceLabelFilters(policy.Spec.Selectors)
+	if len(sourceLabels) == 0 {
+		return 0, 0, nil
+	}
+
+	stream, err := observerClient.GetFlows(ctx, &observerpb.GetFlowsRequest{
+		Number: uint64(o.SampleFlows),
+		Whitelist: []*flowpb.FlowFilter{
+			{
+				SourceLabel:      sourceLabels,
+				DestinationLabel: []string{worldLabel},
+				Verdict:          []flowpb.Verdict{flowpb.Verdict_FORWARDED},
+			},
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	correct, wrong := 0, 0
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return correct, wrong, err
+		}
+		flow := response.GetFlow()
+		if flow == nil || flow.GetIsReply().GetValue() {
+			continue
+		}
+
+		switch classifyFlow(flow, policy.Status.ExitNode) {
+		case resultCorrect:
+			correct++
+		case resultWrong:
+			wrong++
+		}
+	}
+	return correct, wrong, nil
+}
+
+// classifyFlow returns whether the flow left the cluster through the exit node. Only the
+// flows leaving a node, to the network or to its stack, are classified: they are correct
+// on the exit node and wrong on any other node. The flows sent to the overlay do not
+// report the node they are tunneled to, they are not classified and the same packet is
+// classified when it leaves the gateway.
+func classifyFlow(flow *flowpb.Flow, exitNode string) string {
+	switch flow.GetTraceObservationPoint() {
+	case flowpb.TraceObservationPoint_TO_NETWORK, flowpb.TraceObservationPoint_TO_STACK:
+		if nodeName(flow.GetNodeName()) == exitNode {
+			return resultCorrect
+		}
+		return resultWrong
+	}
+	return ""
+}
+
+// sourceLabelFilters converts the selectors of a CiliumEgressGatewayPolicy in Hubble
+// label filters: each selector becomes a filter and the filters are evaluated in OR.
+func sourceLabelFilters(selectors []ciliumv2.EgressRule) []string {
+	filters := []string{}
+	for _, selector := range selectors {
+		terms := selectorTerms(selector.PodSelector, "")
+		terms = append(terms, selectorTerms(selector.NamespaceSelector, namespaceLabelPrefix)...)
+		if len(terms) == 0 {
+			continue
+		}
+		filters = append(filters, strings.Join(terms, ","))
+	}
+	return filters
+}
+
+func selectorTerms(selector *slimv1.LabelSelector, prefix string) []string {
+	if selector == nil {
+		return nil
+	}
+
+	terms := []string{}
+	keys := make([]string, 0, len(selector.MatchLabels))
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		terms = append(terms, fmt.Sprintf("k8s:%s%s=%s", prefix, key, selector.MatchLabels[key]))
+	}
+
+	for _, expression := range selector.MatchExpressions {
+		key := fmt.Sprintf("k8s:%s%s", prefix, expression.Key)
+		switch expression.Operator {
+		case slimv1.LabelSelectorOpIn:
+			terms = append(terms, fmt.Sprintf("%s in (%s)", key, strings.Join(expression.Values, ",")))
+		case slimv1.LabelSelectorOpNotIn:
+			terms = append(terms, fmt.Sprintf("%s notin (%s)", key, strings.Join(expression.Values, ",")))
+		case slimv1.LabelSelectorOpExists:
+			terms = append(terms, key)
+		case slimv1.LabelSelectorOpDoesNotExist:
+			terms = append(terms, "!"+key)
+		}
+	}
+	return terms
+}
+
+// nodeName strips the cluster prefix added by Hubble Relay to the node names
+func nodeName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,159 @@
+package hubble
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	flowpb "github.com/cilium/cilium/api/v1/flow"
+	observerpb "github.com/cilium/cilium/api/v1/observer"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testObserverClient returns the flows of the node names and observation points
+type testObserverClient struct {
+	observerpb.ObserverClient
+	flows    []*flowpb.Flow
+	requests []*observerpb.GetFlowsRequest
+}
+
+func (c *testObserverClient) GetFlows(_ context.Context, in *observerpb.GetFlowsRequest, _ ...grpc.CallOption) (observerpb.Observer_GetFlowsClient, error) {
+	c.requests = append(c.requests, in)
+	return &testFlowsStream{flows: c.flows}, nil
+}
+
+type testFlowsStream struct {
+	grpc.ClientStream
+	flows []*flowpb.Flow
+}
+
+func (s *testFlowsStream) Recv() (*observerpb.GetFlowsResponse, error) {
+	if len(s.flows) == 0 {
+		return nil, io.EOF
+	}
+	flow := s.flows[0]
+	s.flows = s.flows[1:]
+	return &observerpb.GetFlowsResponse{ResponseTypes: &observerpb.GetFlowsResponse_Flow{Flow: flow}}, nil
+}
+
+func testFlow(node string, point flowpb.TraceObservationPoint) *flowpb.Flow {
+	return &flowpb.Flow{NodeName: node, TraceObservationPoint: point}
+}
+
+func TestClassifyFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		flow     *flowpb.Flow
+		expected string
+	}{
+		{
+			name:     "left through the exit node",
+			flow:     testFlow("default/worker-1", flowpb.TraceObservationPoint_TO_NETWORK),
+			expected: resultCorrect,
+		},
+		{
+			name:     "left through the stack of the exit node",
+			flow:     testFlow("worker-1", flowpb.TraceObservationPoint_TO_STACK),
+			expected: resultCorrect,
+		},
+		{
+			name:     "left through another node",
+			flow:     testFlow("default/worker-2", flowpb.TraceObservationPoint_TO_NETWORK),
+			expected: resultWrong,
+		},
+		{
+			name: "sent to the overlay towards an unknown node",
+			flow: testFlow("default/worker-2", flowpb.TraceObservationPoint_TO_OVERLAY),
+		},
+		{
+			name: "sent by the pod on the exit node",
+			flow: testFlow("default/worker-1", flowpb.TraceObservationPoint_FROM_ENDPOINT),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := classifyFlow(test.flow, "worker-1"); result != test.expected {
+				t.Errorf("the flow is %q, expected %q", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestSourceLabelFilters(t *testing.T) {
+	selectors := []ciliumv2.EgressRule{
+		{
+			PodSelector: &slimv1.LabelSelector{
+				MatchLabels: map[string]string{"app": "web", "tier": "frontend"},
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					{Key: "canary", Operator: slimv1.LabelSelectorOpDoesNotExist},
+				},
+			},
+			NamespaceSelector: &slimv1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		},
+		{
+			PodSelector: &slimv1.LabelSelector{MatchExpressions: []slimv1.LabelSelectorRequirement{
+				{Key: "app", Operator: slimv1.LabelSelectorOpIn, Values: []string{"api", "worker"}},
+			}},
+		},
+		{},
+	}
+	expected := []string{
+		"k8s:app=web,k8s:tier=frontend,!k8s:canary,k8s:io.cilium.k8s.namespace.labels.team=payments",
+		"k8s:app in (api,worker)",
+	}
+	if filters := sourceLabelFilters(selectors); !reflect.DeepEqual(filters, expected) {
+		t.Errorf("the filters are %q, expected %q", filters, expected)
+	}
+}
+
+func TestSampleAll(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-observed"}}
+	policy.Spec.Selectors = []ciliumv2.EgressRule{{PodSelector: &slimv1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}}
+	policy.Status.ExitNode = "worker-1"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).Build()
+
+	observerClient := &testObserverClient{flows: []*flowpb.Flow{
+		testFlow("default/worker-2", flowpb.TraceObservationPoint_TO_OVERLAY),
+		testFlow("default/worker-1", flowpb.TraceObservationPoint_TO_NETWORK),
+		testFlow("default/worker-1", flowpb.TraceObservationPoint_TO_NETWORK),
+		testFlow("default/worker-1", flowpb.TraceObservationPoint_TO_NETWORK),
+		testFlow("default/worker-3", flowpb.TraceObservationPoint_TO_NETWORK),
+		{NodeName: "default/worker-3", TraceObservationPoint: flowpb.TraceObservationPoint_TO_NETWORK, IsReply: wrapperspb.Bool(true)},
+	}}
+	observer := &Observer{Client: c, Log: logr.Discard(), SampleFlows: 10}
+	observer.sampleAll(ctx, observerClient)
+
+	if len(observerClient.requests) != 1 || observerClient.requests[0].Number != 10 {
+		t.Fatalf("the flows were requested with %v", observerClient.requests)
+	}
+	if ratio := testutil.ToFloat64(egressCorrectness.WithLabelValues("egress-observed")); ratio != 0.75 {
+		t.Errorf("the correctness ratio is %v, expected 0.75", ratio)
+	}
+	if wrong := testutil.ToFloat64(observedFlows.WithLabelValues("egress-observed", resultWrong)); wrong != 1 {
+		t.Errorf("%v wrong flows were counted, expected 1", wrong)
+	}
+
+	// The series of the deleted policy are removed
+	if err := c.Delete(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	observer.sampleAll(ctx, observerClient)
+	if count := testutil.CollectAndCount(egressCorrectness) + testutil.CollectAndCount(observedFlows); count != 0 {
+		t.Errorf("%d series are left after the deletion of the policy", count)
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package importer converts the hand-written CiliumEgressGatewayPolicies of a cluster to
+// equivalent HAEgressGatewayPolicies, reporting what can't be translated, and adopts the
+// originals once the policies are created.
+package importer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Severity of an issue of the conversion
+const (
+	// Warning issues change the behavior of the policy once managed by the operator
+	Warning = "Warning"
+	// Error issues prevent the conversion
+	Error = "Error"
+)
+
+// Options are the settings of the operator the policies are converted for
+type Options struct {
+	// EgressNamespace is the --egress-default-namespace of the operator
+	EgressNamespace string
+	// Provider is the provider of the converted policies, set with the annotation when it
+	// is not DefaultProvider
+	Provider        string
+	DefaultProvider string
+}
+
+// Issue is something of the original that can't be translated as it is
+type Issue struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Conversion is the HAEgressGatewayPolicy equivalent to a CiliumEgressGatewayPolicy
+type Conversion struct {
+	Original *ciliumv2.CiliumEgressGatewayPolicy
+	// Policy is nil when the original can't be converted
+	Policy *haegressv2.HAEgressGatewayPolicy
+	// Adoptable is true when the CiliumEgressGatewayPolicy generated for the policy has the
+	// name of the original, which the operator then manages in place
+	Adoptable bool
+	Issues    []Issue
+}
+
+// Failed returns true when the original can't be converted
+func (c *Conversion) Failed() bool {
+	return c.Policy == nil
+}
+
+func (c *Conversion) issue(severity, format string, args ...interface{}) {
+	c.Issues = append(c.Issues, Issue{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// ignoredAnnotations are not copied from the originals
+var ignoredAnnotations = []string{"kubectl.kubernetes.io/last-applied-configuration"}
+
+// List returns the conversions of the CiliumEgressGatewayPolicies not generated by the
+// operator, in name order
+func List(ctx context.Context, c client.Reader, options Options) ([]Conversion, error) {
+	cegps := &ciliumv2.CiliumEgressGatewayPolicyList{}
+	if err := c.List(ctx, cegps); err != nil {
+		return nil, err
+	}
+	sort.Slice(cegps.Items, func(i, j int) bool {
+		return cegps.Items[i].Name < cegps.Items[j].Name
+	})
+	conversions := []Conversion{}
+	for i := range cegps.Items {
+		if owner := metav1.GetControllerOf(&cegps.Items[i]); owner != nil && owner.Kind == "HAEgressGatewayPolicy" {
+			continue
+		}
+		conversions = append(conversions, Convert(&cegps.Items[i], options))
+	}
+	return conversions, nil
+}
+
+// Convert returns the HAEgressGatewayPolicy equivalent to the CiliumEgressGatewayPolicy
+func Convert(original *ciliumv2.CiliumEgressGatewayPolicy, options Options) Conversion {
+	conversion := Conversion{Original: original}
+	if owner := metav1.GetControllerOf(original); owner != nil {
+		conversion.issue(Error, "managed by the %s %s", owner.Kind, owner.Name)
+		return conversion
+	}
+	gateway := original.Spec.EgressGateway
+	if gateway == nil || gateway.NodeSelector == nil {
+		conversion.issue(Error, "no egressGateway nodeSelector")
+		return conversion
+	}
+
+	// The operator names the generated policy <namespace>-<policy>, so the original is
+	// adopted in place when its name has the prefix of the egress namespace
+	name := original.Name
+	if trimmed, ok := strings.CutPrefix(original.Name, options.EgressNamespace+"-"); ok && trimmed != "" {
+		name = trimmed
+		conversion.Adoptable = true
+	} else {
+		conversion.issue(Warning, "the operator generates the CiliumEgressGatewayPolicy %s-%s, the original must be deleted once it exists",
+			options.EgressNamespace, name)
+	}
+
+	policy := &haegressv2.HAEgressGatewayPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: haegressv2.GroupVersion.String(), Kind: "HAEgressGatewayPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      original.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: haegressv2.HAEgressGatewayPolicySpec{CiliumEgressGatewayPolicySpec: *original.Spec.DeepCopy()},
+	}
+	for key, value := range original.Annotations {
+		if !contains(ignoredAnnotations, key) {
+			policy.Annotations[key] = value
+		}
+	}
+	if options.Provider != options.DefaultProvider {
+		policy.Annotations[haegressip.ProviderAnnotation] = options.Provider
+	}
+
+	// The exit node is chosen by the provider, the hostname is set by the operator
+	nodes, selector := splitHostname(gateway.NodeSelector)
+	policy.Spec.EgressGateway.NodeSelector = selector
+	switch {
+	case len(nodes) == 1 && options.Provider == provider.StaticName:
+		policy.Annotations[haegressip.StaticExitNodeAnnotation] = nodes[0]
+	case len(nodes) > 0:
+		conversion.issue(Warning, "the exit node %s is no longer pinned, any Ready node matching %s can be elected",
+			strings.Join(nodes, ","), describeSelector(selector))
+	}
+	if len(nodes) == 0 && options.Provider == provider.StaticName {
+		conversion.issue(Warning, "no node pinned, set the %s annotation", haegressip.StaticExitNodeAnnotation)
+	}
+
+	// In interface mode the operator sets the interface from the annotation
+	if gateway.Interface != "" {
+		policy.Annotations[haegressip.EgressInterfaceAnnotation] = gateway.Interface
+		policy.Spec.EgressGateway.Interface = ""
+	}
+	if gateway.EgressIP != "" {
+		for key, value := range requestIP(options.Provider, gateway.EgressIP) {
+			policy.Annotations[key] = value
+		}
+		policy.Spec.EgressGateway.EgressIP = ""
+	} else if gateway.Interface == "" {
+		conversion.issue(Warning, "the traffic leaves with the address of the default interface of the node, the egress IP will be assigned by %s",
+			options.Provider)
+	}
+	if len(policy.Annotations) == 0 {
+		policy.Annotations = nil
+	}
+
+	conversion.Policy = policy
+	return conversion
+}
+
+// Adopt makes the original controlled by the created policy, so the operator updates it
+// instead of reporting a conflict. The policy must have been created, with its UID.
+func Adopt(ctx context.Context, c client.Client, scheme *runtime.Scheme, conversion *Conversion) error {
+	if !conversion.Adoptable {
+		return fmt.Errorf("the CiliumEgressGatewayPolicy %s can't be adopted in place", conversion.Original.Name)
+	}
+	original := conversion.Original.DeepCopy()
+	if err := controllerutil.SetControllerReference(conversion.Policy, original, scheme); err != nil {
+		return err
+	}
+	return c.Update(ctx, original)
+}
+
+// splitHostname returns the nodes pinned by the hostname label and the rest of the selector
+func splitHostname(selector *slimv1.LabelSelector) ([]string, *slimv1.LabelSelector) {
+	var nodes []string
+	rest := &slimv1.LabelSelector{}
+	for key, value := range selector.MatchLabels {
+		if key == haegressip.NodeNameAnnotation {
+			nodes = append(nodes, value)
+			continue
+		}
+		if rest.MatchLabels == nil {
+			rest.MatchLabels = map[string]slimv1.MatchLabelsValue{}
+		}
+		rest.MatchLabels[key] = value
+	}
+	for _, expression := range selector.MatchExpressions {
+		if expression.Key == haegressip.NodeNameAnnotation && expression.Operator == slimv1.LabelSelectorOpIn {
+			nodes = append(nodes, expression.Values...)
+			continue
+		}
+		rest.MatchExpressions = append(rest.MatchExpressions, expression)
+	}
+	return nodes, rest
+}
+
+// requestIP returns the annotations requesting the IP to the provider, copied by the
+// operator from the policy to its Service
+func requestIP(name, ip string) map[string]string {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	var vipProvider provider.Provider
+	switch name {
+	case provider.KubeVIPName:
+		vipProvider = &provider.KubeVIP{}
+	case provider.CiliumLBIPAMName:
+		vipProvider = &provider.CiliumLBIPAM{}
+	case provider.MetalLBName:
+		vipProvider = &provider.MetalLB{}
+	default:
+		// The static and the cloud providers read the IP from the same annotation
+		vipProvider = &provider.Static{}
+	}
+	vipProvider.RequestIP(service, ip)
+	return service.Annotations
+}
+
+// describeSelector returns the selector in the kubectl syntax
+func describeSelector(selector *slimv1.LabelSelector) string {
+	var requirements []string
+	for key, value := range selector.MatchLabels {
+		requirements = append(requirements, key+"="+value)
+	}
+	sort.Strings(requirements)
+	for _, expression := range selector.MatchExpressions {
+		requirement := fmt.Sprintf("%s %s", expression.Key, strings.ToLower(string(expression.Operator)))
+		if len(expression.Values) > 0 {
+			requirement += " (" + strings.Join(expression.Values, ",") + ")"
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 {
+		return "any node"
+	}
+	return strings.Join(requirements, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
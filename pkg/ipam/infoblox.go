@@ -0,0 +1,174 @@
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+)
+
+// InfobloxName is the name of the Infoblox allocator
+const InfobloxName = "infoblox"
+
+// Extensible attributes set on the Infoblox reservations, they must be defined in the grid
+const (
+	infobloxPolicyAttribute    = "HAEgress Policy"
+	infobloxNamespaceAttribute = "HAEgress Namespace"
+	infobloxClusterAttribute   = "HAEgress Cluster"
+)
+
+// infobloxReservationMAC reserves the fixed address without binding it to a client
+const infobloxReservationMAC = "00:00:00:00:00:00"
+
+type infobloxAttribute struct {
+	Value string `json:"value"`
+}
+
+type infobloxFixedAddress struct {
+	Ref         string                       `json:"_ref,omitempty"`
+	IPv4Addr    string                       `json:"ipv4addr"`
+	MAC         string                       `json:"mac,omitempty"`
+	NetworkView string                       `json:"network_view,omitempty"`
+	Comment     string                       `json:"comment,omitempty"`
+	ExtAttrs    map[string]infobloxAttribute `json:"extattrs,omitempty"`
+}
+
+// Infoblox allocates the egress IPs as fixed address reservations in an Infoblox network
+// through the WAPI. The reservations are tagged with extensible attributes identifying
+// the policy, so a policy allocated twice gets the same IP.
+type Infoblox struct {
+	// URL is the WAPI base URL, including the version
+	URL      string
+	Username string
+	Password string
+	// Network is the CIDR of the network the egress IPs are allocated from, it can be
+	// overridden by the policy annotation
+	Network     string
+	NetworkView string
+	Timeout     time.Duration
+	Client      *http.Client
+}
+
+func (i *Infoblox) Name() string {
+	return InfobloxName
+}
+
+func (i *Infoblox) Allocate(ctx context.Context, request Request) (string, error) {
+	existing, err := i.findReservation(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return infobloxIP(existing.IPv4Addr)
+	}
+
+	network := i.Network
+	if request.Annotations[haegressip.InfobloxNetworkAnnotation] != "" {
+		network = request.Annotations[haegressip.InfobloxNetworkAnnotation]
+	}
+	if network == "" {
+		return "", fmt.Errorf("no Infoblox network configured")
+	}
+
+	var allocated infobloxFixedAddress
+	if err := i.do(ctx, http.MethodPost, "/fixedaddress?_return_fields=ipv4addr", infobloxFixedAddress{
+		IPv4Addr:    fmt.Sprintf("func:nextavailableip:%s,%s", network, i.NetworkView),
+		MAC:         infobloxReservationMAC,
+		NetworkView: i.NetworkView,
+		Comment:     fmt.Sprintf("Egress IP of HAEgressGatewayPolicy %s", request.Policy),
+		ExtAttrs: map[string]infobloxAttribute{
+			infobloxPolicyAttribute:    {Value: request.Policy},
+			infobloxNamespaceAttribute: {Value: request.Namespace},
+			infobloxClusterAttribute:   {Value: request.Cluster},
+		},
+	}, &allocated); err != nil {
+		return "", err
+	}
+	return infobloxIP(allocated.IPv4Addr)
+}
+
+func (i *Infoblox) Release(ctx context.Context, request Request, _ string) error {
+	existing, err := i.findReservation(ctx, request)
+	if err != nil || existing == nil {
+		return err
+	}
+	return i.do(ctx, http.MethodDelete, "/"+existing.Ref, nil, nil)
+}
+
+// findReservation returns the fixed address reserved for the policy, nil if not reserved
+func (i *Infoblox) findReservation(ctx context.Context, request Request) (*infobloxFixedAddress, error) {
+	var reservations []infobloxFixedAddress
+	query := url.Values{
+		"network_view":                 {i.NetworkView},
+		"*" + infobloxPolicyAttribute:  {request.Policy},
+		"*" + infobloxClusterAttribute: {request.Cluster},
+		"_return_fields":               {"ipv4addr"},
+	}
+	if err := i.do(ctx, http.MethodGet, "/fixedaddress?"+query.Encode(), nil, &reservations); err != nil {
+		return nil, err
+	}
+	if len(reservations) == 0 {
+		return nil, nil
+	}
+	return &reservations[0], nil
+}
+
+func (i *Infoblox) do(ctx context.Context, method string, path string, payload any, response any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	if i.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(i.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(i.Username, i.Password)
+
+	httpClient := i.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Infoblox %s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+func infobloxIP(address string) (string, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q returned by Infoblox: %w", address, err)
+	}
+	return addr.String(), nil
+}
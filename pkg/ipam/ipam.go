@@ -0,0 +1,128 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ipam allocates the egress IPs from an IP address management system
+// external to the cluster, before they are requested to the VIP provider.
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+)
+
+// AllNamespaces is the source namespace of the policies selecting the pods of every namespace
+const AllNamespaces = "*"
+
+// Request describes the HAEgressGatewayPolicy an egress IP is allocated for
+type Request struct {
+	Policy    string            `json:"policy"`
+	Namespace string            `json:"namespace"`
+	Cluster   string            `json:"cluster,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// SourceNamespaces are the namespaces of the pods selected by the policy, AllNamespaces
+	// when a selector does not restrict the namespace
+	SourceNamespaces []string `json:"sourceNamespaces,omitempty"`
+	// Annotations of the policy, used by the allocators to read per-policy settings
+	Annotations map[string]string `json:"-"`
+}
+
+// Allocator is implemented by every external IPAM supported by the operator
+type Allocator interface {
+	// Name returns the name used to select the allocator
+	Name() string
+	// Allocate reserves an egress IP for the policy, allocating the same policy twice
+	// should return the same IP
+	Allocate(ctx context.Context, request Request) (string, error)
+	// Release frees the egress IP previously allocated for the policy
+	Release(ctx context.Context, request Request, ip string) error
+}
+
+// Reserver is implemented by the allocators that can reserve a given IP for the policy,
+// used to restore the egress IP of a policy from a backup
+type Reserver interface {
+	// Reserve allocates exactly the given IP to the policy, it fails if the IP is
+	// allocated to another policy
+	Reserve(ctx context.Context, request Request, ip string) error
+}
+
+// Registry holds the configured allocators and selects the one used by a policy
+type Registry struct {
+	allocators       map[string]Allocator
+	defaultAllocator string
+}
+
+// NewRegistry returns a registry with the given allocators, defaultAllocator is used
+// by the policies without the IPAM annotation and can be empty to disable the
+// allocation by default
+func NewRegistry(defaultAllocator string, allocators ...Allocator) (*Registry, error) {
+	registry := &Registry{
+		allocators:       make(map[string]Allocator),
+		defaultAllocator: defaultAllocator,
+	}
+	for _, allocator := range allocators {
+		registry.allocators[allocator.Name()] = allocator
+	}
+	if _, ok := registry.allocators[defaultAllocator]; defaultAllocator != "" && !ok {
+		return nil, fmt.Errorf("unknown IPAM %q, configured IPAMs are %v", defaultAllocator, registry.Names())
+	}
+	return registry, nil
+}
+
+// ForPolicy returns the allocator selected by the policy annotation or the default of its
+// Service namespace, or the default one. It returns nil when the egress IP of the policy
+// is chosen by the provider.
+func (r *Registry) ForPolicy(policy *haegressv2.HAEgressGatewayPolicy) (Allocator, error) {
+	if r == nil {
+		return nil, nil
+	}
+	name, ok := policy.Annotations[haegressip.IPAMAnnotation]
+	if !ok {
+		name, ok = policy.Status.NamespaceDefaults[haegressip.IPAMAnnotation]
+	}
+	if !ok {
+		name = r.defaultAllocator
+	}
+	if name == "" || name == haegressip.IPAMNone {
+		return nil, nil
+	}
+	return r.Get(name)
+}
+
+// Get returns the allocator with the name
+func (r *Registry) Get(name string) (Allocator, error) {
+	if r == nil {
+		return nil, fmt.Errorf("unknown IPAM %q, no IPAM is configured", name)
+	}
+	allocator, ok := r.allocators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown IPAM %q, configured IPAMs are %v", name, r.Names())
+	}
+	return allocator, nil
+}
+
+// Names returns the names of the registered allocators
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.allocators))
+	for name := range r.allocators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
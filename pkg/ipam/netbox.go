@@ -0,0 +1,170 @@
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NetBoxName is the name of the NetBox allocator
+const NetBoxName = "netbox"
+
+// netboxIPAddress is the subset of the NetBox IP address object used by the operator
+type netboxIPAddress struct {
+	ID          int    `json:"id,omitempty"`
+	Address     string `json:"address,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Description string `json:"description,omitempty"`
+	Comments    string `json:"comments,omitempty"`
+}
+
+type netboxList[T any] struct {
+	Count   int `json:"count"`
+	Results []T `json:"results"`
+}
+
+type netboxPrefix struct {
+	ID     int    `json:"id"`
+	Prefix string `json:"prefix"`
+}
+
+// NetBox allocates the egress IPs as IP addresses of a NetBox prefix. The description
+// of the address identifies the policy, so a policy allocated twice gets the same IP,
+// while the comments record the policy, namespace and cluster metadata.
+type NetBox struct {
+	URL   string
+	Token string
+	// Prefix is the CIDR of the NetBox prefix the egress IPs are allocated from
+	Prefix  string
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+func (n *NetBox) Name() string {
+	return NetBoxName
+}
+
+func (n *NetBox) Allocate(ctx context.Context, request Request) (string, error) {
+	existing, err := n.findAddress(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return addressIP(existing.Address)
+	}
+
+	var prefixes netboxList[netboxPrefix]
+	if err := n.do(ctx, http.MethodGet, "/api/ipam/prefixes/?prefix="+url.QueryEscape(n.Prefix), nil, &prefixes); err != nil {
+		return "", err
+	}
+	if len(prefixes.Results) != 1 {
+		return "", fmt.Errorf("found %d NetBox prefixes matching %s, expected one", len(prefixes.Results), n.Prefix)
+	}
+
+	var allocated netboxIPAddress
+	if err := n.do(ctx, http.MethodPost, fmt.Sprintf("/api/ipam/prefixes/%d/available-ips/", prefixes.Results[0].ID), netboxIPAddress{
+		Status:      "active",
+		Description: netboxDescription(request),
+		Comments:    netboxComments(request),
+	}, &allocated); err != nil {
+		return "", err
+	}
+	return addressIP(allocated.Address)
+}
+
+func (n *NetBox) Release(ctx context.Context, request Request, _ string) error {
+	existing, err := n.findAddress(ctx, request)
+	if err != nil || existing == nil {
+		return err
+	}
+	return n.do(ctx, http.MethodDelete, fmt.Sprintf("/api/ipam/ip-addresses/%d/", existing.ID), nil, nil)
+}
+
+// findAddress returns the IP address allocated to the policy, nil if not allocated
+func (n *NetBox) findAddress(ctx context.Context, request Request) (*netboxIPAddress, error) {
+	var addresses netboxList[netboxIPAddress]
+	query := url.Values{
+		"parent":      {n.Prefix},
+		"description": {netboxDescription(request)},
+	}
+	if err := n.do(ctx, http.MethodGet, "/api/ipam/ip-addresses/?"+query.Encode(), nil, &addresses); err != nil {
+		return nil, err
+	}
+	if len(addresses.Results) == 0 {
+		return nil, nil
+	}
+	return &addresses.Results[0], nil
+}
+
+func (n *NetBox) do(ctx context.Context, method string, path string, payload any, response any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	if n.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(n.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Token "+n.Token)
+
+	httpClient := n.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("NetBox %s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+// netboxDescription identifies the IP address allocated to a policy
+func netboxDescription(request Request) string {
+	if request.Cluster == "" {
+		return "haegress/" + request.Policy
+	}
+	return fmt.Sprintf("haegress/%s/%s", request.Cluster, request.Policy)
+}
+
+func netboxComments(request Request) string {
+	return fmt.Sprintf("Egress IP of HAEgressGatewayPolicy %s, service namespace %s, cluster %s", request.Policy, request.Namespace, request.Cluster)
+}
+
+// addressIP strips the prefix length from a NetBox address
+func addressIP(address string) (string, error) {
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q returned by the IPAM: %w", address, err)
+	}
+	return prefix.Addr().String(), nil
+}
@@ -0,0 +1,52 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/plugin"
+)
+
+// Plugin allocates the egress IPs with an out-of-tree plugin
+type Plugin struct {
+	Plugin *plugin.Client
+}
+
+func (p *Plugin) Name() string {
+	return p.Plugin.Name()
+}
+
+func (p *Plugin) Allocate(ctx context.Context, request Request) (string, error) {
+	response, err := p.Plugin.Call(ctx, &plugin.Request{
+		Operation:  plugin.OperationAllocate,
+		Allocation: pluginAllocation(request),
+	})
+	if err != nil {
+		return "", err
+	}
+	addr, err := netip.ParseAddr(response.IP)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP %q returned by the IPAM plugin %s: %w", response.IP, p.Name(), err)
+	}
+	return addr.String(), nil
+}
+
+func (p *Plugin) Release(ctx context.Context, request Request, ip string) error {
+	_, err := p.Plugin.Call(ctx, &plugin.Request{
+		Operation:  plugin.OperationRelease,
+		Allocation: pluginAllocation(request),
+		IP:         ip,
+	})
+	return err
+}
+
+func pluginAllocation(request Request) *plugin.Allocation {
+	return &plugin.Allocation{
+		Policy:           request.Policy,
+		Namespace:        request.Namespace,
+		Cluster:          request.Cluster,
+		Labels:           request.Labels,
+		SourceNamespaces: request.SourceNamespaces,
+	}
+}
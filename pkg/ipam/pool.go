@@ -0,0 +1,371 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net/netip"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// PoolName is the name of the built-in pool allocator
+const PoolName = "pool"
+
+const (
+	// poolCIDRsKey holds the CIDRs of the pool, one per line or comma separated
+	poolCIDRsKey = "cidrs"
+	// poolAllocationsKey holds the JSON map of the allocations, by policy
+	poolAllocationsKey = "allocations"
+	// poolTenantsKey holds the YAML list of the tenants of the pool
+	poolTenantsKey = "tenants"
+	// poolMaxPrefixSize limits the addresses considered in a single CIDR
+	poolMaxPrefixSize = 1 << 20
+)
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;update
+
+// poolTenant is a range of the pool reserved to the policies whose source namespaces all
+// match the selector, the other policies never get its addresses
+type poolTenant struct {
+	Name              string                `json:"name"`
+	CIDRs             []string              `json:"cidrs"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+
+	selector labels.Selector
+	prefixes []netip.Prefix
+}
+
+// Pool allocates the egress IPs from a list of CIDRs without an external IPAM. The
+// address of a policy is chosen hashing its name, so it is stable across reinstalls,
+// moving to the next free address on collisions. The allocations are persisted in
+// a ConfigMap, that can also define the CIDRs of the pool when no file is given.
+type Pool struct {
+	Client client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader        client.Reader
+	Namespace     string
+	ConfigMapName string
+	// File, if set, contains the CIDRs of the pool
+	File string
+}
+
+func (p *Pool) Name() string {
+	return PoolName
+}
+
+func (p *Pool) Allocate(ctx context.Context, request Request) (string, error) {
+	configMap, allocations, err := p.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	key := poolKey(request)
+	if ip, ok := allocations[key]; ok {
+		return ip, nil
+	}
+
+	prefixes, err := p.prefixes(configMap)
+	if err != nil {
+		return "", err
+	}
+	tenants, err := p.tenants(configMap)
+	if err != nil {
+		return "", err
+	}
+	owned, err := p.ownedPrefixes(ctx, tenants, request)
+	if err != nil {
+		return "", err
+	}
+	if len(prefixes)+len(owned) == 0 {
+		return "", fmt.Errorf("the IP pool is empty")
+	}
+	used := make(map[string]bool, len(allocations))
+	for _, ip := range allocations {
+		used[ip] = true
+	}
+
+	// The ranges of the tenants of the policy first, then the shared ones without the
+	// ranges of every tenant
+	var reserved []netip.Prefix
+	for _, tenant := range tenants {
+		reserved = append(reserved, tenant.prefixes...)
+	}
+	ip := pick(owned, key, used, nil)
+	if ip == "" {
+		ip = pick(prefixes, key, used, reserved)
+	}
+	if ip == "" {
+		return "", fmt.Errorf("the IP pool is exhausted")
+	}
+	allocations[key] = ip
+	return ip, p.save(ctx, configMap, allocations)
+}
+
+func (p *Pool) Reserve(ctx context.Context, request Request, ip string) error {
+	configMap, allocations, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+	key := poolKey(request)
+	if allocations[key] == ip {
+		return nil
+	}
+	tenants, err := p.tenants(configMap)
+	if err != nil {
+		return err
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		for _, tenant := range tenants {
+			if !containsAddr(tenant.prefixes, addr) {
+				continue
+			}
+			matches, err := p.matches(ctx, tenant, request.SourceNamespaces)
+			if err != nil {
+				return err
+			}
+			if !matches {
+				return fmt.Errorf("the IP %s is reserved to the tenant %s", ip, tenant.Name)
+			}
+		}
+	}
+	for other, allocated := range allocations {
+		if allocated == ip {
+			return fmt.Errorf("the IP %s is allocated to %s", ip, other)
+		}
+	}
+	allocations[key] = ip
+	return p.save(ctx, configMap, allocations)
+}
+
+func (p *Pool) Release(ctx context.Context, request Request, _ string) error {
+	configMap, allocations, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+	key := poolKey(request)
+	if _, ok := allocations[key]; !ok {
+		return nil
+	}
+	delete(allocations, key)
+	return p.save(ctx, configMap, allocations)
+}
+
+// load returns the ConfigMap of the pool, a new one if not found, and its allocations
+func (p *Pool) load(ctx context.Context) (*corev1.ConfigMap, map[string]string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := p.Reader.Get(ctx, types.NamespacedName{Name: p.ConfigMapName, Namespace: p.Namespace}, configMap)
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.ConfigMapName,
+				Namespace: p.Namespace,
+			},
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	allocations := map[string]string{}
+	if data := configMap.Data[poolAllocationsKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &allocations); err != nil {
+			return nil, nil, fmt.Errorf("invalid allocations in ConfigMap %s: %w", p.ConfigMapName, err)
+		}
+	}
+	return configMap, allocations, nil
+}
+
+// save persists the allocations, the update fails if the ConfigMap was changed meanwhile
+func (p *Pool) save(ctx context.Context, configMap *corev1.ConfigMap, allocations map[string]string) error {
+	data, err := json.Marshal(allocations)
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[poolAllocationsKey] = string(data)
+	if configMap.ResourceVersion == "" {
+		return p.Client.Create(ctx, configMap)
+	}
+	return p.Client.Update(ctx, configMap)
+}
+
+// prefixes returns the CIDRs of the pool, read from the file or from the ConfigMap
+func (p *Pool) prefixes(configMap *corev1.ConfigMap) ([]netip.Prefix, error) {
+	data := configMap.Data[poolCIDRsKey]
+	if p.File != "" {
+		content, err := os.ReadFile(p.File)
+		if err != nil {
+			return nil, err
+		}
+		data = string(content)
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, field := range strings.FieldsFunc(data, func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' '
+	}) {
+		if strings.HasPrefix(field, "#") {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q in the IP pool: %w", field, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// tenants returns the tenants of the pool, read from the ConfigMap
+func (p *Pool) tenants(configMap *corev1.ConfigMap) ([]poolTenant, error) {
+	data := configMap.Data[poolTenantsKey]
+	if data == "" {
+		return nil, nil
+	}
+	var tenants []poolTenant
+	if err := yaml.Unmarshal([]byte(data), &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants in ConfigMap %s: %w", p.ConfigMapName, err)
+	}
+	for i := range tenants {
+		tenant := &tenants[i]
+		selector, err := metav1.LabelSelectorAsSelector(tenant.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector of the tenant %s: %w", tenant.Name, err)
+		}
+		// A missing selector would match every namespace, so it matches none
+		if tenant.NamespaceSelector == nil {
+			selector = labels.Nothing()
+		}
+		tenant.selector = selector
+		for _, cidr := range tenant.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q of the tenant %s: %w", cidr, tenant.Name, err)
+			}
+			tenant.prefixes = append(tenant.prefixes, prefix.Masked())
+		}
+	}
+	return tenants, nil
+}
+
+// ownedPrefixes returns the ranges of the tenants whose selector matches every source
+// namespace of the policy
+func (p *Pool) ownedPrefixes(ctx context.Context, tenants []poolTenant, request Request) ([]netip.Prefix, error) {
+	var owned []netip.Prefix
+	for _, tenant := range tenants {
+		matches, err := p.matches(ctx, tenant, request.SourceNamespaces)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			owned = append(owned, tenant.prefixes...)
+		}
+	}
+	return owned, nil
+}
+
+// matches returns true when the namespaces, and so the policy, belong to the tenant. The
+// policies selecting every namespace belong to no tenant.
+func (p *Pool) matches(ctx context.Context, tenant poolTenant, namespaces []string) (bool, error) {
+	if len(namespaces) == 0 {
+		return false, nil
+	}
+	for _, name := range namespaces {
+		if name == AllNamespaces {
+			return false, nil
+		}
+		namespace := &corev1.Namespace{}
+		if err := p.Reader.Get(ctx, types.NamespacedName{Name: name}, namespace); apierrors.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if !tenant.selector.Matches(labels.Set(namespace.Labels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pick returns the first free address of the prefixes starting from the hash of the key,
+// skipping the addresses of the excluded prefixes, empty when none is free
+func pick(prefixes []netip.Prefix, key string, used map[string]bool, excluded []netip.Prefix) string {
+	size := uint64(0)
+	for _, prefix := range prefixes {
+		size += prefixSize(prefix)
+	}
+	if size == 0 {
+		return ""
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	start := hash.Sum64() % size
+	for i := uint64(0); i < size; i++ {
+		addr := poolAddress(prefixes, (start+i)%size)
+		if !used[addr.String()] && !containsAddr(excluded, addr) {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// poolKey identifies the allocation of a policy
+func poolKey(request Request) string {
+	if request.Cluster == "" {
+		return request.Policy
+	}
+	return request.Cluster + "/" + request.Policy
+}
+
+// prefixSize returns the number of usable addresses of the prefix, the network and
+// broadcast addresses of the IPv4 subnets are excluded
+func prefixSize(prefix netip.Prefix) uint64 {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 20 {
+		return poolMaxPrefixSize
+	}
+	size := uint64(1) << hostBits
+	if prefix.Addr().Is4() && hostBits > 1 {
+		size -= 2
+	}
+	return size
+}
+
+// poolAddress returns the address at the given offset of the pool
+func poolAddress(prefixes []netip.Prefix, offset uint64) netip.Addr {
+	for _, prefix := range prefixes {
+		size := prefixSize(prefix)
+		if offset >= size {
+			offset -= size
+			continue
+		}
+		if prefix.Addr().Is4() && prefix.Addr().BitLen()-prefix.Bits() > 1 {
+			offset++
+		}
+		base := prefix.Addr().AsSlice()
+		value := new(big.Int).Add(new(big.Int).SetBytes(base), new(big.Int).SetUint64(offset))
+		addr, _ := netip.AddrFromSlice(value.FillBytes(make([]byte, len(base))))
+		return addr
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,189 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestPool returns a pool whose ConfigMap, in a fake client, defines the CIDRs
+func newTestPool(t *testing.T, cidrs string) *Pool {
+	t.Helper()
+	c := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "haegress-ipam-pool", Namespace: "egress-system"},
+		Data:       map[string]string{poolCIDRsKey: cidrs},
+	}).Build()
+	return &Pool{Client: c, Reader: c, Namespace: "egress-system", ConfigMapName: "haegress-ipam-pool"}
+}
+
+func TestPoolAllocate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs string
+		// expected are the addresses of the pool, every one is allocated once before the
+		// pool is exhausted
+		expected []string
+	}{
+		{
+			name:     "/32 is a single address",
+			cidrs:    "10.0.0.5/32",
+			expected: []string{"10.0.0.5"},
+		},
+		{
+			name:     "/31 has no network and broadcast addresses",
+			cidrs:    "10.0.0.4/31",
+			expected: []string{"10.0.0.4", "10.0.0.5"},
+		},
+		{
+			name:     "/30 skips the network and broadcast addresses",
+			cidrs:    "10.0.0.8/30",
+			expected: []string{"10.0.0.9", "10.0.0.10"},
+		},
+		{
+			name:     "the host bits of the CIDR are ignored",
+			cidrs:    "10.0.0.9/30",
+			expected: []string{"10.0.0.9", "10.0.0.10"},
+		},
+		{
+			name:     "IPv6 uses every address",
+			cidrs:    "fd00::10/126",
+			expected: []string{"fd00::10", "fd00::11", "fd00::12", "fd00::13"},
+		},
+		{
+			name:     "IPv6 /128 is a single address",
+			cidrs:    "fd00::1/128",
+			expected: []string{"fd00::1"},
+		},
+		{
+			name:  "multiple CIDRs are concatenated",
+			cidrs: "10.0.0.1/32,10.0.1.0/30\nfd00::/127",
+			expected: []string{
+				"10.0.0.1", "10.0.1.1", "10.0.1.2", "fd00::", "fd00::1",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			pool := newTestPool(t, test.cidrs)
+
+			allocated := []string{}
+			for i := range test.expected {
+				ip, err := pool.Allocate(ctx, Request{Policy: fmt.Sprintf("policy-%d", i)})
+				if err != nil {
+					t.Fatalf("allocation %d failed: %v", i, err)
+				}
+				allocated = append(allocated, ip)
+			}
+			sort.Strings(allocated)
+			expected := append([]string{}, test.expected...)
+			sort.Strings(expected)
+			if strings.Join(allocated, ",") != strings.Join(expected, ",") {
+				t.Errorf("allocated %v, expected %v", allocated, expected)
+			}
+
+			_, err := pool.Allocate(ctx, Request{Policy: "one-too-many"})
+			if err == nil || !strings.Contains(err.Error(), "exhausted") {
+				t.Errorf("expected the pool to be exhausted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPoolAllocateStable(t *testing.T) {
+	ctx := context.Background()
+	request := Request{Policy: "egress-web", Cluster: "prod"}
+
+	pool := newTestPool(t, "10.0.0.0/24")
+	first, err := pool.Allocate(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Allocate(ctx, Request{Policy: "egress-db", Cluster: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	again, err := pool.Allocate(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("the policy was allocated %s and then %s", first, again)
+	}
+
+	// A reinstall, with the allocations lost, hashes the policy to the same address
+	reinstalled, err := newTestPool(t, "10.0.0.0/24").Allocate(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reinstalled != first {
+		t.Errorf("the policy was allocated %s after the reinstall, %s before", reinstalled, first)
+	}
+
+	// The cluster is part of the key
+	other, err := pool.Allocate(ctx, Request{Policy: "egress-web", Cluster: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Errorf("the policies of two clusters were both allocated %s", first)
+	}
+
+	if err := pool.Release(ctx, request, first); err != nil {
+		t.Fatal(err)
+	}
+	released, err := pool.Allocate(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != first {
+		t.Errorf("the policy was allocated %s after the release, %s before", released, first)
+	}
+}
+
+func TestPickProbesCollisions(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/30"),
+		netip.MustParsePrefix("10.0.1.7/32"),
+		netip.MustParsePrefix("fd00::/127"),
+	}
+	size := uint64(0)
+	for _, prefix := range prefixes {
+		size += prefixSize(prefix)
+	}
+	if size != 5 {
+		t.Fatalf("the pool has %d addresses, expected 5", size)
+	}
+	offsets := map[string]uint64{}
+	for offset := uint64(0); offset < size; offset++ {
+		offsets[poolAddress(prefixes, offset).String()] = offset
+	}
+
+	// Every collision moves to the next address of the pool, wrapping at the end
+	used := map[string]bool{}
+	previous := pick(prefixes, "egress-web", used, nil)
+	for i := uint64(1); i < size; i++ {
+		used[previous] = true
+		next := pick(prefixes, "egress-web", used, nil)
+		if expected := poolAddress(prefixes, (offsets[previous]+1)%size).String(); next != expected {
+			t.Fatalf("after %s the probe picked %s, expected %s", previous, next, expected)
+		}
+		previous = next
+	}
+	used[previous] = true
+	if ip := pick(prefixes, "egress-web", used, nil); ip != "" {
+		t.Errorf("picked %s from an exhausted pool", ip)
+	}
+
+	// The excluded prefixes are skipped like the used addresses
+	excluded := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24"), netip.MustParsePrefix("fd00::/64")}
+	if ip := pick(prefixes, "egress-web", map[string]bool{}, excluded); ip != "10.0.1.7" {
+		t.Errorf("picked %s, expected the only address not excluded", ip)
+	}
+}
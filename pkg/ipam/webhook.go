@@ -0,0 +1,95 @@
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// WebhookName is the name of the webhook allocator
+const WebhookName = "webhook"
+
+// webhookPayload is the body exchanged with the IPAM webhook
+type webhookPayload struct {
+	Request
+	IP string `json:"ip,omitempty"`
+}
+
+// Webhook allocates the egress IPs calling an HTTP endpoint: a POST to <URL>/allocate
+// with the Request as JSON body must return {"ip": "<address>"}, a POST to <URL>/release
+// with the Request and the ip frees the address.
+type Webhook struct {
+	URL string
+	// Token, if set, is sent as bearer token
+	Token   string
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+func (w *Webhook) Name() string {
+	return WebhookName
+}
+
+func (w *Webhook) Allocate(ctx context.Context, request Request) (string, error) {
+	var response webhookPayload
+	if err := w.call(ctx, "allocate", webhookPayload{Request: request}, &response); err != nil {
+		return "", err
+	}
+	addr, err := netip.ParseAddr(response.IP)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP %q returned by the IPAM webhook: %w", response.IP, err)
+	}
+	return addr.String(), nil
+}
+
+func (w *Webhook) Release(ctx context.Context, request Request, ip string) error {
+	return w.call(ctx, "release", webhookPayload{Request: request, IP: ip}, nil)
+}
+
+func (w *Webhook) call(ctx context.Context, operation string, payload webhookPayload, response any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(w.URL, "/")+"/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("IPAM webhook %s failed with status %d: %s", operation, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package isovalent generates the IsovalentEgressGatewayPolicy of Cilium Enterprise from
+// the CiliumEgressGatewayPolicy of a policy. The CiliumEgressGatewayPolicy stays the
+// working copy of the operator, the exit node and the egress IP follow the provider as
+// usual, and the IsovalentEgressGatewayPolicy carries them in its egress group. The
+// type is not vendored, the objects are unstructured.
+package isovalent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Target kinds of the policies
+const (
+	TargetCiliumEgressGatewayPolicy    = "CiliumEgressGatewayPolicy"
+	TargetIsovalentEgressGatewayPolicy = "IsovalentEgressGatewayPolicy"
+	// TargetAuto selects the IsovalentEgressGatewayPolicy when its CRD is installed
+	TargetAuto = "auto"
+)
+
+// CRD is the name of the IsovalentEgressGatewayPolicy CRD
+const CRD = "isovalentegressgatewaypolicies.isovalent.com"
+
+// GroupVersionKind of the IsovalentEgressGatewayPolicy
+var GroupVersionKind = schema.GroupVersionKind{Group: "isovalent.com", Version: "v1", Kind: TargetIsovalentEgressGatewayPolicy}
+
+// +kubebuilder:rbac:groups=isovalent.com,resources=isovalentegressgatewaypolicies,verbs=get;list;watch;create;update;patch;delete
+
+// ValidTarget returns an error when the target kind is unknown
+func ValidTarget(target string) error {
+	switch target {
+	case TargetCiliumEgressGatewayPolicy, TargetIsovalentEgressGatewayPolicy, TargetAuto:
+		return nil
+	}
+	return fmt.Errorf("unknown target kind %q, valid values: %s, %s, %s", target,
+		TargetCiliumEgressGatewayPolicy, TargetIsovalentEgressGatewayPolicy, TargetAuto)
+}
+
+// Detect returns true when the IsovalentEgressGatewayPolicy CRD is installed
+func Detect(ctx context.Context, reader client.Reader) (bool, error) {
+	err := reader.Get(ctx, types.NamespacedName{Name: CRD}, &apiextensionsv1.CustomResourceDefinition{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Options select the target kind of the policies
+type Options struct {
+	// Default is the target kind of the policies without the target-kind annotation,
+	// auto is resolved at startup
+	Default string
+}
+
+// ForPolicy returns the target kind of the policy, its target-kind annotation or the
+// default
+func (o Options) ForPolicy(policy *haegressv2.HAEgressGatewayPolicy) string {
+	switch target := policy.Annotations[haegressip.TargetKindAnnotation]; target {
+	case TargetCiliumEgressGatewayPolicy, TargetIsovalentEgressGatewayPolicy:
+		return target
+	}
+	if o.Default == TargetIsovalentEgressGatewayPolicy {
+		return TargetIsovalentEgressGatewayPolicy
+	}
+	return TargetCiliumEgressGatewayPolicy
+}
+
+// New returns an empty IsovalentEgressGatewayPolicy
+func New() *unstructured.Unstructured {
+	policy := &unstructured.Unstructured{}
+	policy.SetGroupVersionKind(GroupVersionKind)
+	return policy
+}
+
+// FromCiliumEgressGatewayPolicy returns the IsovalentEgressGatewayPolicy with the name,
+// the metadata, the owner and the spec of the CiliumEgressGatewayPolicy. The egress
+// gateway becomes the only egress group, with up to maxGatewayNodes active gateways
+// among the nodes it selects.
+func FromCiliumEgressGatewayPolicy(cegp *ciliumv2.CiliumEgressGatewayPolicy, maxGatewayNodes int64) (*unstructured.Unstructured, error) {
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cegp.Spec.DeepCopy())
+	if err != nil {
+		return nil, err
+	}
+	delete(spec, "egressGateway")
+	for field, value := range spec {
+		if value == nil {
+			delete(spec, field)
+		}
+	}
+	if cegp.Spec.EgressGateway != nil {
+		group, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cegp.Spec.EgressGateway.DeepCopy())
+		if err != nil {
+			return nil, err
+		}
+		group["maxGatewayNodes"] = maxGatewayNodes
+		spec["egressGroups"] = []interface{}{group}
+	}
+
+	policy := New()
+	policy.SetName(cegp.Name)
+	policy.SetLabels(cegp.Labels)
+	policy.SetAnnotations(cegp.Annotations)
+	policy.SetOwnerReferences(cegp.OwnerReferences)
+	policy.Object["spec"] = spec
+	return policy, nil
+}
+
+// MaxGatewayNodes returns the active gateways of the egress group of the policy, from its
+// max-gateway-nodes annotation, 1 by default: the egress IP is homed on a single node
+func MaxGatewayNodes(policy *haegressv2.HAEgressGatewayPolicy) int64 {
+	value, err := strconv.ParseInt(policy.Annotations[haegressip.MaxGatewayNodesAnnotation], 10, 64)
+	if err != nil || value < 1 {
+		return 1
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package loglevel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultKey is the ConfigMap key with the default verbosity
+const DefaultKey = "default"
+
+// Watcher reads the verbosity of the loggers from a ConfigMap. Every key is a
+// controller name, like "service", or a logger name prefix, like "controllers.Services",
+// and the value is the verbosity; the "default" key replaces the verbosity configured
+// by --zap-log-level. When the ConfigMap is deleted the initial verbosity is restored.
+type Watcher struct {
+	// Reader should be a non-cached reader, the operator does not need to watch every
+	// ConfigMap of the cluster
+	Reader          client.Reader
+	Log             logr.Logger
+	Levels          *Levels
+	Namespace       string
+	ConfigMapName   string
+	IntervalSeconds int
+
+	initialLevel int
+	applied      map[string]string
+}
+
+// SetupWithManager registers the watcher as a runnable of the Manager.
+func (w *Watcher) SetupWithManager(mgr ctrl.Manager) error {
+	w.initialLevel = w.Levels.For("", "")
+	return mgr.Add(w)
+}
+
+// NeedLeaderElection returns false, the standby replicas log too
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and refreshes the verbosity until the context is
+// cancelled
+func (w *Watcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(w.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	w.refresh(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.refresh(ctx)
+		}
+	}
+}
+
+func (w *Watcher) refresh(ctx context.Context) {
+	configMap := &corev1.ConfigMap{}
+	err := w.Reader.Get(ctx, types.NamespacedName{Name: w.ConfigMapName, Namespace: w.Namespace}, configMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		w.Log.Error(err, "unable to read the log levels", "ConfigMap", w.ConfigMapName)
+		return
+	}
+	if reflect.DeepEqual(configMap.Data, w.applied) {
+		return
+	}
+
+	defaultLevel, levels, err := parse(configMap.Data, w.initialLevel)
+	if err != nil {
+		w.Log.Error(err, "invalid log levels, keeping the previous ones", "ConfigMap", w.ConfigMapName)
+		return
+	}
+	w.Levels.Set(defaultLevel, levels)
+	w.applied = configMap.Data
+	w.Log.Info("Log levels updated", "default", defaultLevel, "levels", levels)
+}
+
+// parse returns the default verbosity and the verbosity of the loggers in data
+func parse(data map[string]string, defaultLevel int) (int, map[string]int, error) {
+	levels := map[string]int{}
+	for key, value := range data {
+		level, err := strconv.Atoi(value)
+		if err != nil || level < -1 || level > MaxVerbosity {
+			return 0, nil, fmt.Errorf("invalid verbosity %q of %s, must be between -1 and %d", value, key, MaxVerbosity)
+		}
+		if key == DefaultKey {
+			defaultLevel = level
+		} else {
+			levels[key] = level
+		}
+	}
+	return defaultLevel, levels, nil
+}
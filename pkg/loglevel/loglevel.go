@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loglevel changes the log verbosity of the single controllers at runtime,
+// without restarting the leader and triggering a full resync.
+package loglevel
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+)
+
+// MaxVerbosity is the highest verbosity that can be enabled at runtime
+const MaxVerbosity = 10
+
+// Levels holds the verbosity of the loggers. A logger is matched by the value of its
+// "controller" key, set by controller-runtime, or by the longest prefix of its name,
+// e.g. "controllers.Services"; the other loggers use the default verbosity. A verbosity
+// of -1 disables the info logs, the errors are always logged.
+type Levels struct {
+	lock         sync.RWMutex
+	defaultLevel int
+	levels       map[string]int
+}
+
+// NewLevels returns the Levels with the given default verbosity
+func NewLevels(defaultLevel int) *Levels {
+	return &Levels{defaultLevel: defaultLevel, levels: map[string]int{}}
+}
+
+// Verbosity returns the verbosity of zap level enabler, as configured by --zap-log-level:
+// the highest V level enabled, -1 when even the info logs are disabled
+func Verbosity(enabler zapcore.LevelEnabler) int {
+	if enabler == nil {
+		return 0
+	}
+	verbosity := -1
+	for level := 0; level <= MaxVerbosity && enabler.Enabled(zapcore.Level(-level)); level++ {
+		verbosity = level
+	}
+	return verbosity
+}
+
+// Set replaces the default verbosity and the verbosity of the loggers
+func (l *Levels) Set(defaultLevel int, levels map[string]int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.defaultLevel = defaultLevel
+	l.levels = levels
+}
+
+// For returns the verbosity of the logger with the given name and controller
+func (l *Levels) For(name, controller string) int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if controller != "" {
+		if level, ok := l.levels[controller]; ok {
+			return level
+		}
+	}
+	level, matched := l.defaultLevel, -1
+	for prefix, prefixLevel := range l.levels {
+		if len(prefix) > matched && (name == prefix || strings.HasPrefix(name, prefix+".")) {
+			level, matched = prefixLevel, len(prefix)
+		}
+	}
+	return level
+}
+
+// NewSink wraps the sink of a logger whose level is MaxVerbosity, filtering the info
+// logs with the Levels
+func NewSink(base logr.LogSink, levels *Levels) logr.LogSink {
+	return &sink{base: base, levels: levels}
+}
+
+type sink struct {
+	base       logr.LogSink
+	levels     *Levels
+	name       string
+	controller string
+}
+
+// Init implements logr.LogSink, skipping the frame of this sink in the reported caller
+func (s *sink) Init(info logr.RuntimeInfo) {
+	info.CallDepth++
+	s.base.Init(info)
+}
+
+func (s *sink) Enabled(level int) bool {
+	return level <= s.levels.For(s.name, s.controller) && s.base.Enabled(level)
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.base.Info(level, msg, keysAndValues...)
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.base.Error(err, msg, keysAndValues...)
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	child := *s
+	child.base = s.base.WithValues(keysAndValues...)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if key, ok := keysAndValues[i].(string); ok && key == "controller" {
+			if controller, ok := keysAndValues[i+1].(string); ok {
+				child.controller = controller
+			}
+		}
+	}
+	return &child
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	child := *s
+	child.base = s.base.WithName(name)
+	if s.name == "" {
+		child.name = name
+	} else {
+		child.name = s.name + "." + name
+	}
+	return &child
+}
+
+// WithCallDepth implements logr.CallDepthLogSink, so the caller reported by zap is the
+// caller of the logger and not this sink
+func (s *sink) WithCallDepth(depth int) logr.LogSink {
+	child := *s
+	if withCallDepth, ok := s.base.(logr.CallDepthLogSink); ok {
+		child.base = withCallDepth.WithCallDepth(depth)
+	}
+	return &child
+}
@@ -0,0 +1,90 @@
+package mapping
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigMapKey is the key of the exported ConfigMap that contains the mappings
+const ConfigMapKey = "mappings.json"
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;update
+
+// Exporter periodically writes the mappings of every policy, as a JSON list, in a single
+// ConfigMap. The ConfigMap is replaced with one update, so the watchers always see a
+// consistent mapping.
+type Exporter struct {
+	client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader client.Reader
+	Log    logr.Logger
+
+	Namespace       string
+	ConfigMapName   string
+	IntervalSeconds int
+}
+
+// SetupWithManager registers the exporter as a leader-only runnable of the Manager.
+func (e *Exporter) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(e)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (e *Exporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(e.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.export(ctx); err != nil {
+				e.Log.Error(err, "unable to export the egress mappings", "ConfigMap", e.ConfigMapName)
+			}
+		}
+	}
+}
+
+func (e *Exporter) export(ctx context.Context) error {
+	mappings, err := List(ctx, e.Client, e.Namespace)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = e.Reader.Get(ctx, types.NamespacedName{Name: e.ConfigMapName, Namespace: e.Namespace}, configMap)
+	if err != nil && apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      e.ConfigMapName,
+				Namespace: e.Namespace,
+			},
+			Data: map[string]string{ConfigMapKey: string(data)},
+		}
+		e.Log.Info("Creating the egress mappings ConfigMap", "ConfigMap", e.ConfigMapName)
+		return e.Create(ctx, configMap)
+	} else if err != nil {
+		return err
+	}
+
+	if configMap.Data[ConfigMapKey] == string(data) {
+		return nil
+	}
+	configMap.Data = map[string]string{ConfigMapKey: string(data)}
+	e.Log.V(1).Info("Updating the egress mappings", "ConfigMap", e.ConfigMapName, "mappings", len(mappings))
+	return e.Update(ctx, configMap)
+}
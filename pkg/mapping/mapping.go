@@ -0,0 +1,153 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mapping builds the current mapping of every HAEgressGatewayPolicy to its
+// egress IP, exit node and source namespaces, and exports it for the tools outside
+// the operator.
+package mapping
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AllNamespaces is reported when a selector of the policy matches the pods of every namespace
+const AllNamespaces = "*"
+
+// podNamespaceLabel is the label Cilium uses for the namespace of the pod identities
+const podNamespaceLabel = "io.kubernetes.pod.namespace"
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// Mapping is the current egress configuration of a HAEgressGatewayPolicy
+type Mapping struct {
+	Policy   string `json:"policy"`
+	EgressIP string `json:"egressIP,omitempty"`
+	ExitNode string `json:"exitNode,omitempty"`
+	// Namespaces are the namespaces of the pods whose traffic leaves with the egress IP
+	Namespaces       []string    `json:"namespaces"`
+	ServiceNamespace string      `json:"serviceNamespace"`
+	LastModifiedTime metav1.Time `json:"lastModifiedTime,omitempty"`
+}
+
+// List returns the mappings of every HAEgressGatewayPolicy, sorted by policy name
+func List(ctx context.Context, c client.Client, defaultNamespace string) ([]Mapping, error) {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return nil, err
+	}
+
+	mappings := make([]Mapping, 0, len(policies.Items))
+	for _, policy := range policies.Items {
+		namespaces, err := Namespaces(ctx, c, &policy)
+		if err != nil {
+			return nil, err
+		}
+		serviceNamespace := haegressiputil.ServiceNamespace(&policy, defaultNamespace)
+		mappings = append(mappings, Mapping{
+			Policy:           policy.Name,
+			EgressIP:         policy.Status.IPAddress,
+			ExitNode:         policy.Status.ExitNode,
+			Namespaces:       namespaces,
+			ServiceNamespace: serviceNamespace,
+			LastModifiedTime: policy.Status.LastModifiedTime,
+		})
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Policy < mappings[j].Policy
+	})
+	return mappings, nil
+}
+
+// Namespaces returns the sorted namespaces selected by the policy, AllNamespaces when a
+// selector does not restrict the namespace of the pods
+func Namespaces(ctx context.Context, c client.Client, policy *haegressv2.HAEgressGatewayPolicy) ([]string, error) {
+	found := map[string]bool{}
+	for _, selector := range policy.Spec.Selectors {
+		if namespaces := podSelectorNamespaces(selector.PodSelector); namespaces != nil {
+			for _, namespace := range namespaces {
+				found[namespace] = true
+			}
+			continue
+		}
+		if selector.NamespaceSelector == nil {
+			found[AllNamespaces] = true
+			continue
+		}
+
+		labelSelector, err := metav1.LabelSelectorAsSelector(toLabelSelector(selector.NamespaceSelector))
+		if err != nil {
+			return nil, err
+		}
+		namespaceList := &corev1.NamespaceList{}
+		if err := c.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+			return nil, err
+		}
+		for _, namespace := range namespaceList.Items {
+			found[namespace.Name] = true
+		}
+	}
+
+	namespaces := make([]string, 0, len(found))
+	for namespace := range found {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// podSelectorNamespaces returns the namespaces set in the pod selector, nil if not restricted
+func podSelectorNamespaces(selector *slimv1.LabelSelector) []string {
+	if selector == nil {
+		return nil
+	}
+	for key, value := range selector.MatchLabels {
+		if strings.TrimPrefix(key, "k8s:") == podNamespaceLabel {
+			return []string{value}
+		}
+	}
+	for _, expression := range selector.MatchExpressions {
+		if strings.TrimPrefix(expression.Key, "k8s:") == podNamespaceLabel && expression.Operator == slimv1.LabelSelectorOpIn {
+			return expression.Values
+		}
+	}
+	return nil
+}
+
+func toLabelSelector(selector *slimv1.LabelSelector) *metav1.LabelSelector {
+	labelSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{},
+	}
+	for key, value := range selector.MatchLabels {
+		labelSelector.MatchLabels[key] = value
+	}
+	for _, expression := range selector.MatchExpressions {
+		labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      expression.Key,
+			Operator: metav1.LabelSelectorOperator(expression.Operator),
+			Values:   expression.Values,
+		})
+	}
+	return labelSelector
+}
@@ -0,0 +1,120 @@
+package mapping
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+
+// Preview is what the selectors of a policy currently match
+type Preview struct {
+	// Namespaces are the sorted namespaces whose pods can be selected, every existing
+	// namespace when All is true
+	Namespaces []string
+	All        bool
+	// Pods is the number of pods selected, -1 when they are not counted
+	Pods int
+}
+
+// PreviewSelectors returns the namespaces, and the pods when countPods is true, matched by
+// the selectors of the policy. The pods are read with reader, only their metadata.
+func PreviewSelectors(ctx context.Context, c client.Client, reader client.Reader, policy *haegressv2.HAEgressGatewayPolicy, countPods bool) (*Preview, error) {
+	var existing []string
+	allNamespaces := func() ([]string, error) {
+		if existing != nil {
+			return existing, nil
+		}
+		namespaceList := &corev1.NamespaceList{}
+		if err := c.List(ctx, namespaceList); err != nil {
+			return nil, err
+		}
+		existing = make([]string, 0, len(namespaceList.Items))
+		for _, namespace := range namespaceList.Items {
+			existing = append(existing, namespace.Name)
+		}
+		return existing, nil
+	}
+
+	preview := &Preview{Pods: -1}
+	found := map[string]bool{}
+	pods := map[string]bool{}
+	for _, selector := range policy.Spec.Selectors {
+		single := &haegressv2.HAEgressGatewayPolicy{}
+		single.Spec.Selectors = append(single.Spec.Selectors, selector)
+		namespaces, err := Namespaces(ctx, c, single)
+		if err != nil {
+			return nil, err
+		}
+		if len(namespaces) == 1 && namespaces[0] == AllNamespaces {
+			preview.All = true
+			if namespaces, err = allNamespaces(); err != nil {
+				return nil, err
+			}
+		}
+		for _, namespace := range namespaces {
+			found[namespace] = true
+		}
+		if !countPods {
+			continue
+		}
+		podSelector, err := metav1.LabelSelectorAsSelector(podLabelSelector(selector.PodSelector))
+		if err != nil {
+			return nil, err
+		}
+		for _, namespace := range namespaces {
+			podList := &metav1.PartialObjectMetadataList{}
+			podList.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+			if err := reader.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: podSelector}); err != nil {
+				return nil, err
+			}
+			for _, pod := range podList.Items {
+				pods[pod.Namespace+"/"+pod.Name] = true
+			}
+		}
+	}
+
+	preview.Namespaces = make([]string, 0, len(found))
+	for namespace := range found {
+		preview.Namespaces = append(preview.Namespaces, namespace)
+	}
+	sort.Strings(preview.Namespaces)
+	if countPods {
+		preview.Pods = len(pods)
+	}
+	return preview, nil
+}
+
+// podLabelSelector returns the pod selector of a rule without the k8s: source prefix and
+// without the namespace label, already applied by listing the namespace
+func podLabelSelector(selector *slimv1.LabelSelector) *metav1.LabelSelector {
+	labelSelector := &metav1.LabelSelector{}
+	if selector == nil {
+		return labelSelector
+	}
+	for key, value := range selector.MatchLabels {
+		if key = strings.TrimPrefix(key, "k8s:"); key != podNamespaceLabel {
+			if labelSelector.MatchLabels == nil {
+				labelSelector.MatchLabels = map[string]string{}
+			}
+			labelSelector.MatchLabels[key] = value
+		}
+	}
+	for _, expression := range selector.MatchExpressions {
+		if key := strings.TrimPrefix(expression.Key, "k8s:"); key != podNamespaceLabel {
+			labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      key,
+				Operator: metav1.LabelSelectorOperator(expression.Operator),
+				Values:   expression.Values,
+			})
+		}
+	}
+	return labelSelector
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	unmanagedConflictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_unmanaged_conflicts_total",
+			Help: "Number of objects found with the name expected by a policy and not controlled by the operator, by namespace and resource",
+		},
+		[]string{"namespace", "resource"},
+	)
+
+	unmanagedConflicts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_unmanaged_conflicts",
+			Help: "Current number of objects with the name expected by a policy and not controlled by the operator, by namespace and resource",
+		},
+		[]string{"namespace", "resource"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(unmanagedConflictsTotal, unmanagedConflicts)
+}
+
+// conflictSet holds the current conflicts of every policy
+type conflictSet struct {
+	lock      sync.Mutex
+	conflicts map[conflictKey]string
+}
+
+type conflictKey struct {
+	policy   string
+	resource string
+}
+
+// update refreshes the gauge from the current conflicts, the lock must be held
+func (c *conflictSet) update() {
+	unmanagedConflicts.Reset()
+	for key, namespace := range c.conflicts {
+		unmanagedConflicts.WithLabelValues(namespace, key.resource).Inc()
+	}
+}
+
+var currentConflicts = &conflictSet{conflicts: make(map[conflictKey]string)}
+
+// UnmanagedConflict records that the object of the given resource, expected by the
+// policy in the namespace, exists and is not controlled by the operator. It returns
+// true when the conflict is new.
+func UnmanagedConflict(policy, resource, namespace string) bool {
+	conflicts.WithLabelValues(ConflictNotOwned).Inc()
+
+	currentConflicts.lock.Lock()
+	defer currentConflicts.lock.Unlock()
+	key := conflictKey{policy: policy, resource: resource}
+	if _, ok := currentConflicts.conflicts[key]; ok {
+		return false
+	}
+	currentConflicts.conflicts[key] = namespace
+	currentConflicts.update()
+	unmanagedConflictsTotal.WithLabelValues(namespace, resource).Inc()
+	return true
+}
+
+// UnmanagedConflictResolved records that the object of the given resource is now
+// controlled by the policy. It returns true when a conflict was resolved.
+func UnmanagedConflictResolved(policy, resource string) bool {
+	currentConflicts.lock.Lock()
+	defer currentConflicts.lock.Unlock()
+	key := conflictKey{policy: policy, resource: resource}
+	if _, ok := currentConflicts.conflicts[key]; !ok {
+		return false
+	}
+	delete(currentConflicts.conflicts, key)
+	currentConflicts.update()
+	return true
+}
+
+// forgetConflicts drops the conflicts of a deleted policy
+func forgetConflicts(policy string) {
+	currentConflicts.lock.Lock()
+	defer currentConflicts.lock.Unlock()
+	for key := range currentConflicts.conflicts {
+		if key.policy == policy {
+			delete(currentConflicts.conflicts, key)
+		}
+	}
+	currentConflicts.update()
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var convergenceBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	assignmentDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "haegress_assignment_duration_seconds",
+			Help:    "Time from the egress IP assigned to the Service to the CiliumEgressGatewayPolicy updated, by provider",
+			Buckets: convergenceBuckets,
+		},
+		[]string{"provider"},
+	)
+
+	failoverDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "haegress_failover_duration_seconds",
+			Help:    "Time from the exit node change detected to the CiliumEgressGatewayPolicy patched, by provider",
+			Buckets: convergenceBuckets,
+		},
+		[]string{"provider"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(assignmentDuration, failoverDuration)
+}
+
+// tracker remembers when a change was first detected for a policy, until it is applied
+type tracker struct {
+	lock    sync.Mutex
+	pending map[string]pendingChange
+}
+
+type pendingChange struct {
+	target   string
+	detected time.Time
+}
+
+// detected records the first detection of the target, a new target restarts the timer
+func (t *tracker) detected(policy, target string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]pendingChange)
+	}
+	if change, ok := t.pending[policy]; ok && change.target == target {
+		return
+	}
+	t.pending[policy] = pendingChange{target: target, detected: time.Now()}
+}
+
+// applied returns the time since the target was detected, false when it was not
+func (t *tracker) applied(policy, target string) (time.Duration, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	change, ok := t.pending[policy]
+	if !ok || change.target != target {
+		return 0, false
+	}
+	delete(t.pending, policy)
+	return time.Since(change.detected), true
+}
+
+// targets returns the pending target of every policy
+func (t *tracker) targets() map[string]string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	targets := make(map[string]string, len(t.pending))
+	for policy, change := range t.pending {
+		targets[policy] = change.target
+	}
+	return targets
+}
+
+var (
+	assignments tracker
+	failovers   tracker
+)
+
+// AssignmentDetected records that the Service of the policy got the egress IP, still
+// not configured in the CiliumEgressGatewayPolicy
+func AssignmentDetected(policy, egressIP string) {
+	assignments.detected(policy, egressIP)
+}
+
+// AssignmentApplied observes the time since the egress IP was detected
+func AssignmentApplied(provider, policy, egressIP string) {
+	if duration, ok := assignments.applied(policy, egressIP); ok {
+		assignmentDuration.WithLabelValues(provider).Observe(duration.Seconds())
+	}
+}
+
+// FailoverDetected records that the egress IP of the policy moved to the node, still
+// not configured in the CiliumEgressGatewayPolicy
+func FailoverDetected(policy, node string) {
+	failovers.detected(policy, node)
+}
+
+// FailoverApplied observes the time since the exit node change was detected
+func FailoverApplied(provider, policy, node string) {
+	if duration, ok := failovers.applied(policy, node); ok {
+		failoverDuration.WithLabelValues(provider).Observe(duration.Seconds())
+	}
+}
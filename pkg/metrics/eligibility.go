@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var eligibleNodes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "haegress_policy_eligible_nodes",
+		Help: "Nodes eligible as exit node of the policy: matching its nodeSelector, Ready, not drained and below their egress capacity",
+	},
+	[]string{"policy"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(eligibleNodes)
+}
+
+// EligibleNodes records the number of nodes eligible as exit node of the policy
+func EligibleNodes(policy string, count int) {
+	eligibleNodes.WithLabelValues(policy).Set(float64(count))
+}
+
+// ForgetEligibleNodes stops exporting the eligible nodes of a deleted policy
+func ForgetEligibleNodes(policy string) {
+	eligibleNodes.DeleteLabelValues(policy)
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	policyInfoDesc = prometheus.NewDesc(
+		"haegress_policy_info",
+		"Egress IP and exit node of each policy, always 1",
+		[]string{"policy", "namespace", "egress_ip", "exit_node"}, nil,
+	)
+	policyInfoTruncatedDesc = prometheus.NewDesc(
+		"haegress_policy_info_truncated",
+		"1 when some policies are not exported in haegress_policy_info because of the series limit",
+		nil, nil,
+	)
+)
+
+// PolicyInfoCollector exports the haegress_policy_info series of the policies read
+// from the cache at every scrape, so the series of a moved or deleted policy disappear
+// immediately. At most MaxSeries policies, in name order, are exported.
+type PolicyInfoCollector struct {
+	Client client.Reader
+	Log    logr.Logger
+	// ServiceNamespace returns the namespace of the Service of a policy, util.ServiceNamespace
+	// with the default egress namespace: util depends on this package
+	ServiceNamespace func(policy *haegressv2.HAEgressGatewayPolicy) string
+	MaxSeries        int
+}
+
+// Describe implements prometheus.Collector
+func (c *PolicyInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- policyInfoDesc
+	ch <- policyInfoTruncatedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *PolicyInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.Client.List(ctx, &policies); err != nil {
+		c.Log.Error(err, "unable to list the HAEgressGatewayPolicies for the info metric")
+		return
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	truncated := 0.0
+	for i, policy := range policies.Items {
+		if i >= c.MaxSeries {
+			truncated = 1
+			break
+		}
+		ch <- prometheus.MustNewConstMetric(policyInfoDesc, prometheus.GaugeValue, 1,
+			policy.Name, c.ServiceNamespace(&policy), policy.Status.IPAddress, policy.Status.ExitNode)
+	}
+	ch <- prometheus.MustNewConstMetric(policyInfoTruncatedDesc, prometheus.GaugeValue, truncated)
+}
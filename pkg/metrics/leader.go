@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_leader",
+			Help: "Whether this replica is the leader serving the controllers (1) or a standby (0)",
+		},
+	)
+
+	leaderTransitions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "haegress_leader_transitions_total",
+			Help: "Number of times this replica acquired the leadership",
+		},
+	)
+
+	leaderTransitionTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_leader_last_transition_timestamp_seconds",
+			Help: "Time this replica started as standby or acquired the leadership",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(leader, leaderTransitions, leaderTransitionTime)
+}
+
+// Leadership tracks the leader election state of the replica. It exports the state as
+// metrics and as the "leader" readiness check, that fails on the standby replicas: the
+// readiness probe excludes it (/readyz?exclude=leader) while /readyz/leader answers
+// 200 only on the leader that has synced its caches.
+type Leadership struct {
+	Log logr.Logger
+	// Recovered, if set, keeps the check failing until the leader completed its first
+	// reconciliation pass
+	Recovered func() bool
+
+	elected <-chan struct{}
+	synced  func(ctx context.Context) bool
+	serving atomic.Bool
+}
+
+// SetupWithManager registers the readiness check and the runnable that waits for the
+// election, on every replica.
+func (l *Leadership) SetupWithManager(mgr ctrl.Manager) error {
+	l.elected = mgr.Elected()
+	l.synced = mgr.GetCache().WaitForCacheSync
+	if err := mgr.AddReadyzCheck("leader", l.Check); err != nil {
+		return err
+	}
+	return mgr.Add(l)
+}
+
+// NeedLeaderElection returns false because the standby replicas report their state too
+func (l *Leadership) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and waits for the leadership. The manager exits
+// when the leadership is lost, so the replica never goes back to standby.
+func (l *Leadership) Start(ctx context.Context) error {
+	leader.Set(0)
+	leaderTransitionTime.SetToCurrentTime()
+	l.Log.Info("Waiting for the leader election")
+	start := time.Now()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-l.elected:
+	}
+	if !l.synced(ctx) {
+		return nil
+	}
+	l.serving.Store(true)
+	leader.Set(1)
+	leaderTransitions.Inc()
+	leaderTransitionTime.SetToCurrentTime()
+	l.Log.Info("Acquired the leadership, serving the controllers", "standby", time.Since(start).Round(time.Second).String())
+	return nil
+}
+
+// Check implements healthz.Checker and fails until this replica is the leader
+func (l *Leadership) Check(_ *http.Request) error {
+	if !l.serving.Load() {
+		return errors.New("standby, waiting for the leader election")
+	}
+	if l.Recovered != nil && !l.Recovered() {
+		return errors.New("leader, first reconciliation pass in progress")
+	}
+	return nil
+}
+
+// Serving returns true when this replica is the leader serving the controllers
+func (l *Leadership) Serving() bool {
+	return l.serving.Load()
+}
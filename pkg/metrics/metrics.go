@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the egress specific metrics of the controllers, in addition to
+// the generic controller-runtime ones.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Controller names
+const (
+	ControllerPolicies = "haegressgatewaypolicy"
+	ControllerServices = "services"
+)
+
+// Conflict kinds
+const (
+	// ConflictUpdate is an update rejected because the object changed in the meantime
+	ConflictUpdate = "update"
+	// ConflictNotOwned is an object with the expected name not controlled by the operator
+	ConflictNotOwned = "not_owned"
+)
+
+// CiliumEgressGatewayPolicy patched fields
+const (
+	FieldEgressIP     = "egressIP"
+	FieldNodeSelector = "nodeSelector"
+	FieldSelectors    = "selectors"
+)
+
+// Drift kinds found by the background checker
+const (
+	DriftCEGPMissing     = "cegp_missing"
+	DriftCEGPSelectors   = "cegp_selectors"
+	DriftCEGPCIDRs       = "cegp_cidrs"
+	DriftCEGPMetadata    = "cegp_metadata"
+	DriftServiceMissing  = "service_missing"
+	DriftServiceSelector = "service_selector"
+	DriftServiceSpec     = "service_spec"
+)
+
+var (
+	reconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_reconciles_total",
+			Help: "Number of reconciliations, by controller and policy",
+		},
+		[]string{"controller", "policy"},
+	)
+
+	reconcileErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_reconcile_errors_total",
+			Help: "Number of failed reconciliation steps, by controller and reason",
+		},
+		[]string{"controller", "reason"},
+	)
+
+	cegpPatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_cegp_patches_total",
+			Help: "Number of changes applied to the CiliumEgressGatewayPolicies, by field",
+		},
+		[]string{"field"},
+	)
+
+	servicesCreated = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "haegress_services_created_total",
+			Help: "Number of egress Services created",
+		},
+	)
+
+	conflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_conflicts_total",
+			Help: "Number of conflicts detected, update conflicts and objects not controlled by the operator",
+		},
+		[]string{"kind"},
+	)
+
+	driftCorrections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_drift_corrections_total",
+			Help: "Number of drifts corrected by the background checker, by kind",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconciles, reconcileErrors, cegpPatches, servicesCreated, conflicts, driftCorrections)
+}
+
+// Reconciled counts a reconciliation of the policy
+func Reconciled(controller, policy string) {
+	reconciles.WithLabelValues(controller, policy).Inc()
+}
+
+// ReconcileError counts a failed step of the reconciliation, and the update conflicts.
+// The error is also remembered as the last one of the policy, when known.
+func ReconcileError(controller, policy, reason string, err error) {
+	reconcileErrors.WithLabelValues(controller, reason).Inc()
+	if policy != "" {
+		policyError := &PolicyError{Controller: controller, Reason: reason, Time: time.Now()}
+		if err != nil {
+			policyError.Message = err.Error()
+		}
+		syncTimes.failed(policy, policyError)
+	}
+	if apierrors.IsConflict(err) {
+		conflicts.WithLabelValues(ConflictUpdate).Inc()
+	}
+}
+
+// Conflict counts a conflict of the given kind
+func Conflict(kind string) {
+	conflicts.WithLabelValues(kind).Inc()
+}
+
+// CEGPPatched counts a change of the field of a CiliumEgressGatewayPolicy
+func CEGPPatched(field string) {
+	cegpPatches.WithLabelValues(field).Inc()
+}
+
+// ServiceCreated counts a created egress Service
+func ServiceCreated() {
+	servicesCreated.Inc()
+}
+
+type backgroundCheckKey struct{}
+
+// WithBackgroundCheck marks the context of the background checker, the changes made
+// with this context are counted as drift corrections
+func WithBackgroundCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundCheckKey{}, true)
+}
+
+// DriftCorrected counts the drift of the given kind, when corrected by the background
+// checker. It returns true when the context is the background checker one.
+func DriftCorrected(ctx context.Context, kind string) bool {
+	if background, _ := ctx.Value(backgroundCheckKey{}).(bool); !background {
+		return false
+	}
+	driftCorrections.WithLabelValues(kind).Inc()
+	return true
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Phases of a policy, derived from its status
+const (
+	// PhasePending is a policy still waiting for the egress IP
+	PhasePending = "Pending"
+	// PhaseAssigned is a policy with the egress IP not yet announced by a node
+	PhaseAssigned = "Assigned"
+	// PhaseActive is a policy with the egress IP announced by the exit node
+	PhaseActive = "Active"
+)
+
+var policyPhases = []string{PhasePending, PhaseAssigned, PhaseActive}
+
+var (
+	statusPhaseDesc = prometheus.NewDesc(
+		"haegress_policy_status_phase",
+		"Phase of the policy, 1 for the current phase and 0 for the others",
+		[]string{"policy", "namespace", "phase"}, nil,
+	)
+	statusConditionDesc = prometheus.NewDesc(
+		"haegress_policy_status_condition",
+		"Conditions of the policy, 1 for the current status of the condition and 0 for the other",
+		[]string{"policy", "namespace", "condition", "status"}, nil,
+	)
+	statusExitNodeDesc = prometheus.NewDesc(
+		"haegress_policy_status_exit_node",
+		"Exit node of the policy, always 1",
+		[]string{"policy", "namespace", "exit_node"}, nil,
+	)
+	statusIPFamilyDesc = prometheus.NewDesc(
+		"haegress_policy_status_ip_family",
+		"IP family of the egress IP of the policy, always 1",
+		[]string{"policy", "namespace", "ip_family"}, nil,
+	)
+	statusFamilyAddressDesc = prometheus.NewDesc(
+		"haegress_policy_status_family_address",
+		"Egress IP of each family of the policy, always 1",
+		[]string{"policy", "namespace", "ip_family", "address"}, nil,
+	)
+	statusLastModifiedDesc = prometheus.NewDesc(
+		"haegress_policy_status_last_modified_timestamp_seconds",
+		"Time of the last change of the egress IP or of the exit node of the policy",
+		[]string{"policy", "namespace"}, nil,
+	)
+	policyCreatedDesc = prometheus.NewDesc(
+		"haegress_policy_created",
+		"Creation time of the policy",
+		[]string{"policy", "namespace"}, nil,
+	)
+)
+
+// PolicyStatusCollector translates the status of the policies, read from the cache at
+// every scrape, in kube-state-metrics like series, so the clusters without a
+// kube-state-metrics custom resource configuration can alert on them. At most MaxSeries
+// policies, in name order, are exported.
+type PolicyStatusCollector struct {
+	Client client.Reader
+	Log    logr.Logger
+	// ServiceNamespace returns the namespace of the Service of a policy, util.ServiceNamespace
+	// with the default egress namespace: util depends on this package
+	ServiceNamespace func(policy *haegressv2.HAEgressGatewayPolicy) string
+	MaxSeries        int
+}
+
+// Describe implements prometheus.Collector
+func (c *PolicyStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- statusPhaseDesc
+	ch <- statusConditionDesc
+	ch <- statusExitNodeDesc
+	ch <- statusIPFamilyDesc
+	ch <- statusFamilyAddressDesc
+	ch <- statusLastModifiedDesc
+	ch <- policyCreatedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *PolicyStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.Client.List(ctx, &policies); err != nil {
+		c.Log.Error(err, "unable to list the HAEgressGatewayPolicies for the status metrics")
+		return
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	for i, policy := range policies.Items {
+		if i >= c.MaxSeries {
+			break
+		}
+		namespace := c.ServiceNamespace(&policy)
+		status := policy.Status
+
+		phase := PolicyPhase(&policy)
+		for _, candidate := range policyPhases {
+			ch <- prometheus.MustNewConstMetric(statusPhaseDesc, prometheus.GaugeValue,
+				boolToFloat(candidate == phase), policy.Name, namespace, candidate)
+		}
+		for condition, value := range map[string]bool{
+			"ServiceCreated": status.ServiceCreated,
+			"PolicyCreated":  status.PolicyCreated,
+		} {
+			ch <- prometheus.MustNewConstMetric(statusConditionDesc, prometheus.GaugeValue,
+				boolToFloat(value), policy.Name, namespace, condition, "true")
+			ch <- prometheus.MustNewConstMetric(statusConditionDesc, prometheus.GaugeValue,
+				boolToFloat(!value), policy.Name, namespace, condition, "false")
+		}
+		if status.ExitNode != "" {
+			ch <- prometheus.MustNewConstMetric(statusExitNodeDesc, prometheus.GaugeValue, 1,
+				policy.Name, namespace, status.ExitNode)
+		}
+		for _, address := range familyAddresses(status) {
+			family := ipFamily(address)
+			ch <- prometheus.MustNewConstMetric(statusIPFamilyDesc, prometheus.GaugeValue, 1,
+				policy.Name, namespace, family)
+			ch <- prometheus.MustNewConstMetric(statusFamilyAddressDesc, prometheus.GaugeValue, 1,
+				policy.Name, namespace, family, address)
+		}
+		if !status.LastModifiedTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(statusLastModifiedDesc, prometheus.GaugeValue,
+				float64(status.LastModifiedTime.Unix()), policy.Name, namespace)
+		}
+		ch <- prometheus.MustNewConstMetric(policyCreatedDesc, prometheus.GaugeValue,
+			float64(policy.CreationTimestamp.Unix()), policy.Name, namespace)
+	}
+}
+
+// PolicyPhase returns the phase of the policy derived from its status
+func PolicyPhase(policy *haegressv2.HAEgressGatewayPolicy) string {
+	switch {
+	case policy.Status.IPAddress == "":
+		return PhasePending
+	case policy.Status.ExitNode == "":
+		return PhaseAssigned
+	default:
+		return PhaseActive
+	}
+}
+
+// familyAddresses returns the valid egress IPs of each family of the status, the egress IP
+// of the policies reconciled before the addresses were reported by family
+func familyAddresses(status haegressv2.HAEgressGatewayPolicyStatus) []string {
+	addresses := []string{}
+	for _, address := range []string{status.IPv4Address, status.IPv6Address} {
+		if ipFamily(address) != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 && ipFamily(status.IPAddress) != "" {
+		addresses = append(addresses, status.IPAddress)
+	}
+	return addresses
+}
+
+// ipFamily returns ipv4 or ipv6, empty when the address is not valid
+func ipFamily(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
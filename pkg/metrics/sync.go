@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	sinceLastSyncDesc = prometheus.NewDesc(
+		"haegress_policy_seconds_since_last_sync",
+		"Seconds since the policy was last reconciled successfully, or since it was first seen when it never was",
+		[]string{"policy"}, nil,
+	)
+	sinceLastSyncMaxDesc = prometheus.NewDesc(
+		"haegress_policy_seconds_since_last_sync_max",
+		"Maximum of haegress_policy_seconds_since_last_sync over all the policies",
+		nil, nil,
+	)
+)
+
+// syncCollector remembers the last successful reconciliation and the last error of
+// every policy, and computes the elapsed time at every scrape, so a stuck policy keeps
+// growing
+type syncCollector struct {
+	lock     sync.Mutex
+	policies map[string]*policySync
+}
+
+type policySync struct {
+	lastSync  time.Time
+	synced    bool
+	lastError *PolicyError
+}
+
+// PolicyError is the last failed reconciliation step of a policy
+type PolicyError struct {
+	Controller string    `json:"controller"`
+	Reason     string    `json:"reason"`
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time"`
+}
+
+// seen starts the timer of a policy never reconciled successfully, the lock must be held
+func (c *syncCollector) seen(policy string) *policySync {
+	state, ok := c.policies[policy]
+	if !ok {
+		state = &policySync{lastSync: time.Now()}
+		c.policies[policy] = state
+	}
+	return state
+}
+
+func (c *syncCollector) synced(policy string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	state := c.seen(policy)
+	state.lastSync = time.Now()
+	state.synced = true
+}
+
+func (c *syncCollector) failed(policy string, policyError *PolicyError) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seen(policy).lastError = policyError
+}
+
+func (c *syncCollector) forget(policy string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.policies, policy)
+}
+
+// Describe implements prometheus.Collector
+func (c *syncCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sinceLastSyncDesc
+	ch <- sinceLastSyncMaxDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *syncCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	maxSeconds := 0.0
+	for policy, state := range c.policies {
+		seconds := time.Since(state.lastSync).Seconds()
+		if seconds > maxSeconds {
+			maxSeconds = seconds
+		}
+		ch <- prometheus.MustNewConstMetric(sinceLastSyncDesc, prometheus.GaugeValue, seconds, policy)
+	}
+	ch <- prometheus.MustNewConstMetric(sinceLastSyncMaxDesc, prometheus.GaugeValue, maxSeconds)
+}
+
+var syncTimes = &syncCollector{policies: make(map[string]*policySync)}
+
+func init() {
+	metrics.Registry.MustRegister(syncTimes)
+}
+
+// PolicySeen starts tracking a policy, a policy that never syncs successfully is
+// reported since the first time it was seen
+func PolicySeen(policy string) {
+	syncTimes.lock.Lock()
+	defer syncTimes.lock.Unlock()
+	syncTimes.seen(policy)
+}
+
+// PolicySynced records a successful reconciliation of the policy
+func PolicySynced(policy string) {
+	syncTimes.synced(policy)
+}
+
+// PolicyDeleted stops tracking a deleted policy, its conflicts and its eligible nodes
+func PolicyDeleted(policy string) {
+	syncTimes.forget(policy)
+	forgetConflicts(policy)
+	ForgetEligibleNodes(policy)
+}
+
+// PolicyState is the in-memory view of a policy kept by the controllers
+type PolicyState struct {
+	// LastSync is the last successful reconciliation, or the first time the policy was
+	// seen when Synced is false
+	LastSync  time.Time    `json:"lastSync"`
+	Synced    bool         `json:"synced"`
+	LastError *PolicyError `json:"lastError,omitempty"`
+	// PendingEgressIP and PendingExitNode are the changes detected and still not applied
+	// to the CiliumEgressGatewayPolicy
+	PendingEgressIP string `json:"pendingEgressIP,omitempty"`
+	PendingExitNode string `json:"pendingExitNode,omitempty"`
+}
+
+// PolicyStates returns the in-memory state of the tracked policies
+func PolicyStates() map[string]PolicyState {
+	states := make(map[string]PolicyState)
+	syncTimes.lock.Lock()
+	for policy, state := range syncTimes.policies {
+		states[policy] = PolicyState{LastSync: state.lastSync, Synced: state.synced, LastError: state.lastError}
+	}
+	syncTimes.lock.Unlock()
+
+	for policy, target := range assignments.targets() {
+		state := states[policy]
+		state.PendingEgressIP = target
+		states[policy] = state
+	}
+	for policy, target := range failovers.targets() {
+		state := states[policy]
+		state.PendingExitNode = target
+		states[policy] = state
+	}
+	return states
+}
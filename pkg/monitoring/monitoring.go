@@ -0,0 +1,189 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package monitoring creates the ServiceMonitor or PodMonitor scraping the operator and the
+// PrometheusRule with its default alerts, so the monitoring shipped with a release always
+// matches the names of its metrics.
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of the Prometheus Operator objects
+const (
+	ServiceMonitor = "ServiceMonitor"
+	PodMonitor     = "PodMonitor"
+	PrometheusRule = "PrometheusRule"
+)
+
+// Kinds are the supported kinds
+var Kinds = []string{ServiceMonitor, PodMonitor, PrometheusRule}
+
+// templates are the manifests of the kinds, with [[ ]] delimiters so the {{ }} of the
+// Prometheus templates in the alerts are kept as they are
+//
+//go:embed templates/*.yaml
+var templates embed.FS
+
+// retryInterval is the delay before applying again the objects after a failure, e.g. before
+// the CRDs of the Prometheus Operator are installed
+const retryInterval = time.Minute
+
+// +kubebuilder:rbac:groups=monitoring.coreos.com,namespace=egress-system,resources=servicemonitors;podmonitors;prometheusrules,verbs=get;create;patch
+
+// Options are the values of the templates
+type Options struct {
+	Name      string
+	Namespace string
+	// Labels are set on every object, e.g. to match the selectors of the Prometheus instance
+	Labels map[string]string
+	// Selector matches the labels of the operator pods, or of the Service of its metrics
+	Selector map[string]string
+	// Port is the name of the metrics port of the pods or of the Service
+	Port string
+}
+
+// ValidKinds returns an error when a kind is not supported or both the ServiceMonitor and
+// the PodMonitor are requested
+func ValidKinds(kinds []string) error {
+	found := map[string]bool{}
+	for _, kind := range kinds {
+		if !contains(Kinds, kind) {
+			return fmt.Errorf("unknown monitoring object %q, valid objects are %v", kind, Kinds)
+		}
+		found[kind] = true
+	}
+	if found[ServiceMonitor] && found[PodMonitor] {
+		return fmt.Errorf("the %s and the %s scrape the same metrics, choose one", ServiceMonitor, PodMonitor)
+	}
+	return nil
+}
+
+// Render returns the objects of the kinds
+func Render(kinds []string, options Options) ([]*unstructured.Unstructured, error) {
+	if err := ValidKinds(kinds); err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"app.kubernetes.io/managed-by": haegressip.FieldManager}
+	for key, value := range options.Labels {
+		labels[key] = value
+	}
+	options.Labels = labels
+
+	objects := []*unstructured.Unstructured{}
+	for _, kind := range kinds {
+		if kind != PrometheusRule && len(options.Selector) == 0 {
+			return nil, fmt.Errorf("the %s requires the labels of the operator pods", kind)
+		}
+		manifest, err := templates.ReadFile("templates/" + strings.ToLower(kind) + ".yaml")
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := template.New(kind).Delims("[[", "]]").Parse(string(manifest))
+		if err != nil {
+			return nil, err
+		}
+		var rendered bytes.Buffer
+		if err := parsed.Execute(&rendered, options); err != nil {
+			return nil, err
+		}
+		object := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(rendered.Bytes(), &object.Object); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", kind, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// Installer applies the monitoring objects with server-side apply when the replica becomes
+// the leader, so an upgrade of the operator upgrades its alerts too
+type Installer struct {
+	client.Client
+	Log     logr.Logger
+	Kinds   []string
+	Options Options
+}
+
+// SetupWithManager registers the installer as a leader-only runnable of the Manager.
+func (i *Installer) SetupWithManager(mgr ctrl.Manager) error {
+	if _, err := Render(i.Kinds, i.Options); err != nil {
+		return err
+	}
+	return mgr.Add(i)
+}
+
+// Start implements manager.Runnable, it applies the objects and retries until they are
+// applied or the context is cancelled.
+func (i *Installer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	for {
+		err := i.apply(ctx)
+		if err == nil {
+			return nil
+		}
+		if meta.IsNoMatchError(err) {
+			i.Log.Info("The CRDs of the Prometheus Operator are not installed, retrying", "error", err.Error())
+		} else {
+			i.Log.Error(err, "unable to apply the monitoring objects, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (i *Installer) apply(ctx context.Context) error {
+	objects, err := Render(i.Kinds, i.Options)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := i.Patch(ctx, object, client.Apply, client.FieldOwner(haegressip.FieldManager), client.ForceOwnership); err != nil {
+			return fmt.Errorf("unable to apply the %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+		i.Log.Info("Applied the monitoring object", "kind", object.GetKind(), "name", object.GetName(), "namespace", object.GetNamespace())
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
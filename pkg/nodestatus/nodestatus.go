@@ -0,0 +1,213 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodestatus maintains an EgressNodeStatus for every node hosting egress IPs, as
+// exit node or as standby of a gateway group: the policies homed on the node, their
+// health, the consumption of the capacity of the node and what happens to them when the
+// node is lost, so "what breaks if I reboot the node" is a single kubectl get.
+package nodestatus
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/vipclaim"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/whatif"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=egressnodestatuses,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=egressnodestatuses/status,verbs=get;update
+
+// Aggregator refreshes the EgressNodeStatuses every IntervalSeconds, only the changed
+// ones are written
+type Aggregator struct {
+	client.Client
+	Log logr.Logger
+	// WhatIf predicts the outcome of the loss of every node
+	WhatIf          whatif.Options
+	IntervalSeconds int
+}
+
+// SetupWithManager registers the aggregator as a leader-only runnable of the Manager.
+func (a *Aggregator) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(a)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (a *Aggregator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(a.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := a.refresh(ctx); err != nil {
+				a.Log.Error(err, "unable to refresh the EgressNodeStatuses")
+			}
+		}
+	}
+}
+
+// refresh writes the status of every node hosting egress IPs and deletes the others
+func (a *Aggregator) refresh(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := a.List(ctx, &policies); err != nil {
+		return err
+	}
+	byName := make(map[string]*haegressv2.HAEgressGatewayPolicy, len(policies.Items))
+	hosting := map[string]bool{}
+	for i := range policies.Items {
+		byName[policies.Items[i].Name] = &policies.Items[i]
+		if policies.Items[i].Status.ExitNode != "" {
+			hosting[policies.Items[i].Status.ExitNode] = true
+		}
+	}
+	var cegps ciliumv2.CiliumEgressGatewayPolicyList
+	if err := a.List(ctx, &cegps, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return err
+	}
+	for _, cegp := range cegps.Items {
+		if cegp.Spec.EgressGateway == nil {
+			continue
+		}
+		for _, member := range haegressiputil.GatewayGroupFromSelector(cegp.Spec.EgressGateway.NodeSelector) {
+			hosting[member] = true
+		}
+	}
+
+	var nodes corev1.NodeList
+	if err := a.List(ctx, &nodes); err != nil {
+		return err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		hostname := node.Labels[haegressip.NodeNameAnnotation]
+		if !hosting[hostname] {
+			continue
+		}
+		result, err := whatif.Simulate(ctx, a.Client, hostname, a.WhatIf)
+		if err != nil {
+			// The status of the node is kept until the next refresh
+			a.Log.V(1).Info("Unable to predict the loss of the node", "node", hostname, "error", err.Error())
+			continue
+		}
+		if err := a.write(ctx, node, status(node, result, byName)); err != nil {
+			a.Log.Error(err, "unable to write the EgressNodeStatus", "node", hostname)
+		}
+	}
+
+	var statuses haegressv2.EgressNodeStatusList
+	if err := a.List(ctx, &statuses); err != nil {
+		return err
+	}
+	for i := range statuses.Items {
+		if hosting[statuses.Items[i].Name] {
+			continue
+		}
+		if err := a.Delete(ctx, &statuses.Items[i]); client.IgnoreNotFound(err) != nil {
+			a.Log.Error(err, "unable to delete the EgressNodeStatus", "node", statuses.Items[i].Name)
+		}
+	}
+	return nil
+}
+
+// status returns the status of the node from the prediction of its loss
+func status(node *corev1.Node, result *whatif.Result, policies map[string]*haegressv2.HAEgressGatewayPolicy) haegressv2.EgressNodeStatusStatus {
+	current := haegressv2.EgressNodeStatusStatus{
+		Ready:   haegressiputil.IsNodeReady(node),
+		Drained: haegressiputil.IsNodeDrained(node),
+	}
+	if capacity, err := strconv.ParseInt(node.Annotations[haegressip.EgressCapacityAnnotation], 10, 32); err == nil && capacity > 0 {
+		current.Capacity = int32(capacity)
+	}
+	for _, move := range result.Moves {
+		policy := policies[move.Policy]
+		if policy == nil {
+			continue
+		}
+		if move.Outcome == whatif.OutcomeStandbyReplaced {
+			current.StandbyPolicies = append(current.StandbyPolicies, move.Policy)
+			continue
+		}
+		current.Policies = append(current.Policies, haegressv2.EgressNodePolicy{
+			Name:     move.Policy,
+			EgressIP: move.EgressIP,
+			Provider: move.Provider,
+			Priority: policy.Spec.Priority,
+			Healthy: metrics.PolicyPhase(policy) == metrics.PhaseActive &&
+				!meta.IsStatusConditionTrue(policy.Status.Conditions, vipclaim.ConditionStale),
+			Outcome: move.Outcome,
+			To:      move.To,
+		})
+		if move.Outcome == whatif.OutcomeStranded {
+			current.Stranded++
+		}
+	}
+	current.EgressIPs = int32(len(current.Policies))
+	sort.SliceStable(current.Policies, func(i, j int) bool {
+		return haegressiputil.HigherPriority(policies[current.Policies[i].Name], policies[current.Policies[j].Name])
+	})
+	return current
+}
+
+// write creates or updates the EgressNodeStatus of the node when its status changed,
+// owned by the Node so it is deleted with it
+func (a *Aggregator) write(ctx context.Context, node *corev1.Node, desired haegressv2.EgressNodeStatusStatus) error {
+	hostname := node.Labels[haegressip.NodeNameAnnotation]
+	current := &haegressv2.EgressNodeStatus{}
+	err := a.Get(ctx, client.ObjectKey{Name: hostname}, current)
+	if apierrors.IsNotFound(err) {
+		current = &haegressv2.EgressNodeStatus{ObjectMeta: metav1.ObjectMeta{
+			Name: hostname,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       node.Name,
+				UID:        node.UID,
+			}},
+		}}
+		if err := a.Create(ctx, current); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	previous := current.Status
+	previous.LastUpdateTime = metav1.Time{}
+	if !current.Status.LastUpdateTime.IsZero() && reflect.DeepEqual(previous, desired) {
+		return nil
+	}
+	desired.LastUpdateTime = metav1.Now()
+	current.Status = desired
+	return a.Status().Update(ctx, current)
+}
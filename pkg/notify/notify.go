@@ -0,0 +1,259 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify sends the changes of the egress IPs to external endpoints, like a
+// generic webhook, Slack or Microsoft Teams.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/yaml"
+)
+
+// EventType is the kind of change notified
+type EventType string
+
+const (
+	// EgressIPAssigned is sent when an egress IP is assigned to a policy
+	EgressIPAssigned EventType = "EgressIPAssigned"
+	// ExitNodeChanged is sent when the egress IP moves to a new node
+	ExitNodeChanged EventType = "ExitNodeChanged"
+	// Degraded is sent when the egress traffic of a policy is not working as expected
+	Degraded EventType = "Degraded"
+	// ConflictingController is sent when another controller keeps reverting the objects of
+	// a policy and the operator stops writing them
+	ConflictingController EventType = "ConflictingController"
+)
+
+// Target formats
+const (
+	FormatWebhook = "webhook"
+	FormatSlack   = "slack"
+	FormatTeams   = "teams"
+)
+
+// Event is the payload of a notification
+type Event struct {
+	Type             EventType `json:"type"`
+	Policy           string    `json:"policy"`
+	Cluster          string    `json:"cluster,omitempty"`
+	EgressIP         string    `json:"egressIP,omitempty"`
+	ExitNode         string    `json:"exitNode,omitempty"`
+	PreviousExitNode string    `json:"previousExitNode,omitempty"`
+	Message          string    `json:"message"`
+	Time             time.Time `json:"time"`
+}
+
+// Target is an endpoint receiving the notifications
+type Target struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Format is one of webhook (the default), slack or teams
+	Format string `json:"format,omitempty"`
+	// Template, if set, is a text/template executed with the Event. It renders the whole
+	// body for the webhook format and the message text for Slack and Teams.
+	Template string `json:"template,omitempty"`
+	// Events limits the notified event types, all of them when empty
+	Events  []EventType       `json:"events,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	template *template.Template
+}
+
+// Config is the content of the notification configuration file
+type Config struct {
+	Targets []Target `json:"targets"`
+	// Retries is the number of additional attempts for a failed notification
+	Retries int `json:"retries,omitempty"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the notifier
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{Retries: 3}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid notification configuration %s: %w", path, err)
+	}
+	for i := range config.Targets {
+		target := &config.Targets[i]
+		if target.Format == "" {
+			target.Format = FormatWebhook
+		}
+		if target.Format != FormatWebhook && target.Format != FormatSlack && target.Format != FormatTeams {
+			return nil, fmt.Errorf("invalid format %q of notification target %s", target.Format, target.Name)
+		}
+		if target.Template != "" {
+			if target.template, err = template.New(target.Name).Parse(target.Template); err != nil {
+				return nil, fmt.Errorf("invalid template of notification target %s: %w", target.Name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Sink receives every event in process, it must not block
+type Sink interface {
+	Publish(event Event)
+}
+
+// Notifier sends the events to the configured targets and sinks. A nil Notifier
+// ignores the events.
+type Notifier struct {
+	// Config holds the HTTP targets, nil when only the sinks are used
+	Config *Config
+	Sinks  []Sink
+	Log    logr.Logger
+	Client *http.Client
+	// Cluster is added to the events without a cluster
+	Cluster string
+	// ReadOnly, when set, receives the notifications instead of the targets, like
+	// readonly.Report.Record
+	ReadOnly func(verb, kind, namespace, name string, data []byte)
+}
+
+// Notify sends the event to every interested target in background, retrying the
+// failed deliveries with an exponential backoff
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Cluster == "" {
+		event.Cluster = n.Cluster
+	}
+	for _, sink := range n.Sinks {
+		sink.Publish(event)
+	}
+	if n.Config == nil {
+		return
+	}
+	for i := range n.Config.Targets {
+		target := &n.Config.Targets[i]
+		if !target.accepts(event.Type) {
+			continue
+		}
+		if n.ReadOnly != nil {
+			body, _ := target.body(event)
+			n.ReadOnly("Notify", "Notification", target.Name, event.Policy, body)
+			continue
+		}
+		go func() {
+			backoff := time.Second
+			for attempt := 0; ; attempt++ {
+				err := n.send(target, event)
+				if err == nil {
+					return
+				}
+				if attempt >= n.Config.Retries {
+					n.Log.Error(err, "unable to send the notification", "target", target.Name, "event", event.Type, "policy", event.Policy)
+					return
+				}
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}()
+	}
+}
+
+func (t *Target) accepts(eventType EventType) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, accepted := range t.Events {
+		if accepted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// body renders the payload of the event for the target
+func (t *Target) body(event Event) ([]byte, error) {
+	text := event.Message
+	if t.template != nil {
+		var rendered bytes.Buffer
+		if err := t.template.Execute(&rendered, event); err != nil {
+			return nil, err
+		}
+		if t.Format == FormatWebhook {
+			return rendered.Bytes(), nil
+		}
+		text = rendered.String()
+	}
+
+	switch t.Format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": text})
+	case FormatTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  string(event.Type),
+			"title":    fmt.Sprintf("%s: %s", event.Type, event.Policy),
+			"text":     text,
+		})
+	default:
+		return json.Marshal(event)
+	}
+}
+
+func (n *Notifier) send(target *Target, event Event) error {
+	body, err := target.body(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+
+	httpClient := n.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("notification failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// testSink records the events published in process
+type testSink struct {
+	events []Event
+}
+
+func (s *testSink) Publish(event Event) {
+	s.events = append(s.events, event)
+}
+
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestTargetBody(t *testing.T) {
+	event := Event{Type: ExitNodeChanged, Policy: "egress-web", ExitNode: "worker-2", Message: "moved to worker-2"}
+	tests := []struct {
+		name     string
+		target   string
+		expected map[string]any
+	}{
+		{
+			name:     "webhook",
+			target:   "{name: hook, url: http://hook}",
+			expected: map[string]any{"type": "ExitNodeChanged", "policy": "egress-web", "exitNode": "worker-2", "message": "moved to worker-2", "time": "0001-01-01T00:00:00Z"},
+		},
+		{
+			name:     "slack template",
+			target:   "{name: slack, url: http://slack, format: slack, template: '{{.Policy}} on {{.ExitNode}}'}",
+			expected: map[string]any{"text": "egress-web on worker-2"},
+		},
+		{
+			name:   "teams",
+			target: "{name: teams, url: http://teams, format: teams}",
+			expected: map[string]any{"@type": "MessageCard", "@context": "http://schema.org/extensions", "summary": "ExitNodeChanged",
+				"title": "ExitNodeChanged: egress-web", "text": "moved to worker-2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := loadTestConfig(t, "targets: ["+test.target+"]")
+			body, err := config.Targets[0].body(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := map[string]any{}
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := json.Marshal(decoded); string(data) != mustMarshal(t, test.expected) {
+				t.Errorf("the body is %s, expected %s", data, mustMarshal(t, test.expected))
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, value any) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNotifyTargets(t *testing.T) {
+	received := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- body
+	}))
+	defer server.Close()
+
+	config := loadTestConfig(t, "targets: [{name: hook, url: "+server.URL+", events: [ExitNodeChanged], headers: {Authorization: Bearer token}}]")
+	sink := &testSink{}
+	notifier := &Notifier{Config: config, Sinks: []Sink{sink}, Log: logr.Discard(), Cluster: "cluster-a"}
+
+	notifier.Notify(Event{Type: EgressIPAssigned, Policy: "egress-web", EgressIP: "10.0.0.7"})
+	notifier.Notify(Event{Type: ExitNodeChanged, Policy: "egress-web", ExitNode: "worker-2"})
+
+	// The sinks receive every event, the target only the accepted ones
+	if len(sink.events) != 2 || sink.events[0].Cluster != "cluster-a" || sink.events[1].Time.IsZero() {
+		t.Errorf("the sink received %+v", sink.events)
+	}
+	select {
+	case body := <-received:
+		event := Event{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != ExitNodeChanged || event.Cluster != "cluster-a" {
+			t.Errorf("the target received %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target received no notification")
+	}
+	select {
+	case body := <-received:
+		t.Errorf("the target received the event not accepted %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotifyReadOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		t.Error("the target was called in read-only mode")
+	}))
+	defer server.Close()
+
+	type change struct {
+		verb, kind, namespace, name string
+	}
+	changes := []change{}
+	sink := &testSink{}
+	notifier := &Notifier{
+		Config: loadTestConfig(t, "targets: [{name: hook, url: "+server.URL+"}]"),
+		Sinks:  []Sink{sink},
+		Log:    logr.Discard(),
+		ReadOnly: func(verb, kind, namespace, name string, _ []byte) {
+			changes = append(changes, change{verb, kind, namespace, name})
+		},
+	}
+	notifier.Notify(Event{Type: ExitNodeChanged, Policy: "egress-web", ExitNode: "worker-2"})
+	time.Sleep(100 * time.Millisecond)
+
+	if len(changes) != 1 || changes[0] != (change{"Notify", "Notification", "hook", "egress-web"}) {
+		t.Errorf("the report received %+v", changes)
+	}
+	if len(sink.events) != 1 {
+		t.Errorf("the in process sinks received %+v", sink.events)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package orphans finds the CiliumEgressGatewayPolicies whose nodeSelector references
+// nodes that don't exist anymore, e.g. after a node pool is scaled down without a failover
+// event reaching the Services controller. Their Services are queued in the failover
+// controller, that resolves the exit node again from the current holder of the VIP; the
+// policies that can't be resolved, without a holder or with a holder deleted too, are
+// exported in an alertable metric.
+package orphans
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	orphaned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_orphan_node_selectors",
+			Help: "Policies whose CiliumEgressGatewayPolicy selects deleted nodes and whose VIP holder can't replace them, at the last scan",
+		},
+	)
+
+	resyncs = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "haegress_orphan_node_selector_resyncs_total",
+			Help: "Services queued in the failover controller because their CiliumEgressGatewayPolicy selects deleted nodes",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphaned, resyncs)
+}
+
+// Scanner looks every IntervalSeconds for the deleted nodes selected by the
+// CiliumEgressGatewayPolicies of the policies owned by the replica
+type Scanner struct {
+	client.Client
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	Providers *provider.Registry
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+	// EgressNamespace is the default namespace of the Services
+	EgressNamespace string
+	IntervalSeconds int
+	// Resync queues the Services in the failover controller
+	Resync chan<- event.GenericEvent
+
+	// unresolved holds the policies that could not be resolved at the previous scan, so the
+	// warning is recorded once
+	unresolved map[string]bool
+}
+
+// SetupWithManager registers the scanner as a runnable of the Manager.
+func (s *Scanner) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica scans its own
+// policies
+func (s *Scanner) NeedLeaderElection() bool {
+	return s.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Scanner) Start(ctx context.Context) error {
+	s.unresolved = make(map[string]bool)
+	ticker := time.NewTicker(time.Duration(s.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.scanAll(ctx); err != nil {
+				s.Log.Error(err, "unable to scan the nodeSelectors of the CiliumEgressGatewayPolicies")
+			}
+		}
+	}
+}
+
+// scanAll resyncs the policies selecting deleted nodes, and exports the ones that can't
+// be resolved
+func (s *Scanner) scanAll(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := s.List(ctx, &policies); err != nil {
+		return err
+	}
+	haegressiputil.SortByPriority(policies.Items)
+	unresolved := make(map[string]bool)
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		resolved, err := s.scan(ctx, policy)
+		if err != nil {
+			s.Log.V(1).Info("Unable to scan the nodeSelector of the policy", "policy", policy.Name, "error", err.Error())
+			// The previous state is kept until the policy can be scanned again
+			if s.unresolved[policy.Name] {
+				unresolved[policy.Name] = true
+			}
+			continue
+		}
+		if !resolved {
+			unresolved[policy.Name] = true
+		}
+	}
+	s.unresolved = unresolved
+	orphaned.Set(float64(len(unresolved)))
+	return nil
+}
+
+// scan checks the nodeSelector of the CiliumEgressGatewayPolicy of the policy, and returns
+// false when it selects deleted nodes and the VIP holder can't replace them
+func (s *Scanner) scan(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (bool, error) {
+	if !s.Sharder.Owns(policy.Name, policy.Labels) || !policy.DeletionTimestamp.IsZero() ||
+		haegressiputil.IsPaused(policy) || haegressiputil.SkipsChildren(policy) {
+		return true, nil
+	}
+	serviceNamespace := haegressiputil.ServiceNamespace(policy, s.EgressNamespace)
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := s.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", serviceNamespace, policy.Name)}, cegp); err != nil {
+		return true, client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(cegp, policy) || cegp.Spec.EgressGateway == nil {
+		return true, nil
+	}
+
+	nodeSelector := cegp.Spec.EgressGateway.NodeSelector
+	nodes := haegressiputil.GatewayGroupFromSelector(nodeSelector)
+	if nodeSelector != nil && nodeSelector.MatchLabels[haegressip.NodeNameAnnotation] != "" {
+		nodes = []string{string(nodeSelector.MatchLabels[haegressip.NodeNameAnnotation])}
+	}
+	deleted := []string{}
+	for _, node := range nodes {
+		exists, err := s.nodeExists(ctx, node)
+		if err != nil {
+			return true, err
+		}
+		if !exists {
+			deleted = append(deleted, node)
+		}
+	}
+	if len(deleted) == 0 {
+		return true, nil
+	}
+
+	service := &corev1.Service{}
+	if err := s.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: serviceNamespace}, service); apierrors.IsNotFound(err) {
+		return s.unresolvable(policy, cegp, deleted, "the Service does not exist"), nil
+	} else if err != nil {
+		return true, err
+	}
+	vipProvider, err := s.Providers.ForPolicy(policy)
+	if err != nil {
+		return true, err
+	}
+	holder, err := vipProvider.ExitNode(ctx, service)
+	if err != nil {
+		return true, err
+	}
+	if holder == "" {
+		return s.unresolvable(policy, cegp, deleted, fmt.Sprintf("the %s provider reports no holder of the VIP", vipProvider.Name())), nil
+	}
+	if exists, err := s.nodeExists(ctx, holder); err != nil {
+		return true, err
+	} else if !exists {
+		return s.unresolvable(policy, cegp, deleted, fmt.Sprintf("the holder of the VIP %s is deleted too", holder)), nil
+	}
+
+	s.Log.Info("CiliumEgressGatewayPolicy selects deleted nodes, resolving the exit node again from the holder of the VIP",
+		"policy", policy.Name, "CiliumEgressGatewayPolicy", cegp.Name, "nodes", deleted, "holder", holder)
+	resyncs.Inc()
+	if s.Resync != nil {
+		object := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: service.Namespace, Name: service.Name}}
+		select {
+		case s.Resync <- event.GenericEvent{Object: object}:
+		case <-ctx.Done():
+		}
+	}
+	return true, nil
+}
+
+// unresolvable records the policy whose deleted nodes can't be replaced, with a warning
+// the first time, and returns false
+func (s *Scanner) unresolvable(policy *haegressv2.HAEgressGatewayPolicy, cegp *ciliumv2.CiliumEgressGatewayPolicy, deleted []string, cause string) bool {
+	if !s.unresolved[policy.Name] {
+		s.Log.Info("CiliumEgressGatewayPolicy selects deleted nodes and can't be resolved", "policy", policy.Name,
+			"CiliumEgressGatewayPolicy", cegp.Name, "nodes", deleted, "cause", cause)
+		s.Recorder.AnnotatedEventf(policy,
+			haegressiputil.EventAnnotations(policy.Name, haegressmetrics.FieldNodeSelector, strings.Join(deleted, ","), ""),
+			corev1.EventTypeWarning, haegressip.EventOrphanNodeSelectorReason,
+			"CiliumEgressGatewayPolicy %s selects the deleted nodes %s and %s", cegp.Name, strings.Join(deleted, ", "), cause)
+	}
+	return false
+}
+
+// nodeExists returns true when a node has the hostname
+func (s *Scanner) nodeExists(ctx context.Context, hostname string) (bool, error) {
+	var nodeList corev1.NodeList
+	if err := s.List(ctx, &nodeList, client.MatchingLabels{haegressip.NodeNameAnnotation: hostname}); err != nil {
+		return false, err
+	}
+	return len(nodeList.Items) > 0, nil
+}
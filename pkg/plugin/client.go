@@ -0,0 +1,322 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	calls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_plugin_calls_total",
+			Help: "Operations sent to the plugins, by plugin, operation and result",
+		},
+		[]string{"plugin", "operation", "result"},
+	)
+	configInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_plugin_info",
+			Help: "The plugins loaded by the operator, with the hash of their configuration",
+		},
+		[]string{"plugin", "config_hash"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(calls, configInfo)
+}
+
+// Definition is a plugin in the configuration of the operator, exactly one of Exec, HTTP
+// and GRPC must be set
+type Definition struct {
+	Name string `json:"name"`
+	// Provider registers the plugin as a VIP provider, selected with the
+	// cilium.angeloxx.ch/provider annotation, IPAM as an IPAM, selected with the
+	// cilium.angeloxx.ch/ipam annotation
+	Provider bool `json:"provider,omitempty"`
+	IPAM     bool `json:"ipam,omitempty"`
+
+	Exec *ExecTransport `json:"exec,omitempty"`
+	HTTP *HTTPTransport `json:"http,omitempty"`
+	GRPC *GRPCTransport `json:"grpc,omitempty"`
+
+	// Config is passed as is to the plugin in every Request
+	Config json.RawMessage `json:"config,omitempty"`
+	// TimeoutSeconds limits a single operation, 30 seconds when zero
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// HealthSeconds is the time between two health checks of the plugin, 30 seconds when
+	// zero, negative to disable them
+	HealthSeconds int `json:"healthSeconds,omitempty"`
+}
+
+// Config is the content of the plugins configuration file
+type Config struct {
+	Plugins []Definition `json:"plugins"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the plugins and returns their clients
+func LoadConfig(path string) ([]*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	clients := make([]*Client, 0, len(config.Plugins))
+	names := map[string]bool{}
+	for i := range config.Plugins {
+		definition := config.Plugins[i]
+		if definition.Name == "" || names[definition.Name] {
+			return nil, fmt.Errorf("every plugin must have a unique name, %q is empty or duplicated", definition.Name)
+		}
+		names[definition.Name] = true
+		if !definition.Provider && !definition.IPAM {
+			return nil, fmt.Errorf("the plugin %q must be a provider, an IPAM or both", definition.Name)
+		}
+		client := &Client{Definition: definition}
+		kinds := 0
+		if definition.Exec != nil {
+			kinds++
+			client.transport = definition.Exec
+		}
+		if definition.HTTP != nil {
+			kinds++
+			client.transport = definition.HTTP
+		}
+		if definition.GRPC != nil {
+			kinds++
+			client.transport = definition.GRPC
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("the plugin %q must set exactly one of exec, http and grpc", definition.Name)
+		}
+		if client.Definition.TimeoutSeconds == 0 {
+			client.Definition.TimeoutSeconds = 30
+		}
+		if client.Definition.HealthSeconds == 0 {
+			client.Definition.HealthSeconds = 30
+		}
+		client.hash = Hash(definition.Config)
+		configInfo.WithLabelValues(definition.Name, client.hash).Set(1)
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// Hash returns the SHA-256 of the configuration of a plugin, with the keys of the JSON
+// objects sorted, so the same configuration always has the same hash
+func Hash(config json.RawMessage) string {
+	var value any
+	canonical := []byte(config)
+	if json.Unmarshal(config, &value) == nil {
+		canonical, _ = json.Marshal(value)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// transport sends a Request to the plugin
+type transport interface {
+	call(ctx context.Context, request *Request) (*Response, error)
+}
+
+// Client sends the operations to a plugin
+type Client struct {
+	Definition Definition
+
+	transport transport
+	hash      string
+}
+
+// Name returns the name of the plugin
+func (c *Client) Name() string {
+	return c.Definition.Name
+}
+
+// ConfigHash returns the SHA-256 of the configuration of the plugin
+func (c *Client) ConfigHash() string {
+	return c.hash
+}
+
+// Call sends the operation of the request, filling the version and the configuration,
+// and returns the Response or the error of the plugin
+func (c *Client) Call(ctx context.Context, request *Request) (*Response, error) {
+	request.APIVersion = APIVersion
+	request.Plugin = c.Definition.Name
+	request.Config = c.Definition.Config
+	request.ConfigHash = c.hash
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.Definition.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	response, err := c.transport.call(ctx, request)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("plugin %s %s failed: %w", c.Definition.Name, request.Operation, err)
+	case response.Error == ErrNotImplemented.Error():
+		err = fmt.Errorf("plugin %s %s failed: %w", c.Definition.Name, request.Operation, ErrNotImplemented)
+	case response.Error != "":
+		err = fmt.Errorf("plugin %s %s failed: %s", c.Definition.Name, request.Operation, response.Error)
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	calls.WithLabelValues(c.Definition.Name, request.Operation, result).Inc()
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ExecTransport runs the plugin for every operation with the Request, as JSON, on its
+// standard input, and reads the Response from its standard output
+type ExecTransport struct {
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+func (t *ExecTransport) call(ctx context.Context, request *Request) (*Response, error) {
+	if len(t.Command) == 0 {
+		return nil, fmt.Errorf("no command configured")
+	}
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = os.Environ()
+	for name, value := range t.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	response := &Response{}
+	if err := json.Unmarshal(output, response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// HTTPTransport sends every operation with a POST of the Request, as JSON, to URL
+type HTTPTransport struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (t *HTTPTransport) call(ctx context.Context, request *Request) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	response := &Response{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// GRPCTransport calls the ProviderPlugin service at Address, e.g. a sidecar listening on
+// unix:///var/run/plugin.sock, over TLS when CAFile is set
+type GRPCTransport struct {
+	Address string `json:"address"`
+	CAFile  string `json:"caFile,omitempty"`
+
+	lock sync.Mutex
+	conn *grpc.ClientConn
+}
+
+func (t *GRPCTransport) call(ctx context.Context, request *Request) (*Response, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	in, err := toStruct(request)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := conn.Invoke(ctx, grpcMethod, in, out); err != nil {
+		return nil, err
+	}
+	response := &Response{}
+	if err := fromStruct(out, response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// dial connects to the plugin once, the connection reconnects on its own
+func (t *GRPCTransport) dial() (*grpc.ClientConn, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	creds := insecure.NewCredentials()
+	if t.CAFile != "" {
+		ca, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA file of the plugin: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate found in %s", t.CAFile)
+		}
+		creds = credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(t.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the plugin %s: %w", t.Address, err)
+	}
+	t.conn = conn
+	return conn, nil
+}
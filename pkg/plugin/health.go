@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var healthy = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "haegress_plugin_healthy",
+		Help: "1 when the last health check of the plugin succeeded, 0 otherwise",
+	},
+	[]string{"plugin"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(healthy)
+}
+
+// Monitor runs the health check of every plugin each HealthSeconds, logs the changes of
+// their health and exports it
+type Monitor struct {
+	Clients []*Client
+	Log     logr.Logger
+}
+
+// SetupWithManager registers the monitor with the Manager.
+func (m *Monitor) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(m)
+}
+
+// NeedLeaderElection returns false because every replica calls the plugins, the standby
+// ones as soon as they take over
+func (m *Monitor) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and checks the plugins until the context is cancelled.
+func (m *Monitor) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, client := range m.Clients {
+		if client.Definition.HealthSeconds < 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			m.monitor(ctx, client)
+		}(client)
+	}
+	wg.Wait()
+	return nil
+}
+
+// monitor checks the plugin at once and then every HealthSeconds
+func (m *Monitor) monitor(ctx context.Context, client *Client) {
+	ticker := time.NewTicker(time.Duration(client.Definition.HealthSeconds) * time.Second)
+	defer ticker.Stop()
+	wasHealthy := true
+	for {
+		_, err := client.Call(ctx, &Request{Operation: OperationHealth})
+		switch {
+		case err != nil && wasHealthy:
+			m.Log.Error(err, "Plugin unhealthy", "plugin", client.Name())
+		case err == nil && !wasHealthy:
+			m.Log.Info("Plugin healthy again", "plugin", client.Name())
+		}
+		wasHealthy = err == nil
+		if wasHealthy {
+			healthy.WithLabelValues(client.Name()).Set(1)
+		} else {
+			healthy.WithLabelValues(client.Name()).Set(0)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
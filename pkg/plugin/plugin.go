@@ -0,0 +1,178 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin is the SDK of the out-of-tree VIP and IPAM providers, like the
+// proprietary load balancers or a custom keepalived, run by the operator as an external
+// program, an HTTP endpoint or a gRPC service. The operator sends a Request, as JSON or
+// as a google.protobuf.Struct, and expects a Response: a plugin implements Mover,
+// Allocator and HealthChecker, the ones it supports, and serves them with ServeExec,
+// HTTPHandler or RegisterGRPC. The operator side is in client.go and health.go.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// APIVersion is the version of the protocol, sent in every Request
+const APIVersion = "plugin.cilium.angeloxx.ch/v1"
+
+// Operations of the protocol
+const (
+	// OperationHolder returns in Response.Node the hostname of the node holding
+	// Request.IP, empty when the IP is detached
+	OperationHolder = "holder"
+	// OperationAttach moves Request.IP to Request.Node, detaching it from the previous node
+	OperationAttach = "attach"
+	// OperationAllocate returns in Response.IP the egress IP reserved for
+	// Request.Allocation, allocating the same policy twice should return the same IP
+	OperationAllocate = "allocate"
+	// OperationRelease frees Request.IP, previously allocated for Request.Allocation
+	OperationRelease = "release"
+	// OperationHealth checks the plugin and the system it manages
+	OperationHealth = "health"
+)
+
+// ErrNotImplemented is returned for the operations a plugin does not implement
+var ErrNotImplemented = errors.New("operation not implemented by the plugin")
+
+// Request is sent by the operator for every operation
+type Request struct {
+	APIVersion string `json:"apiVersion"`
+	Operation  string `json:"operation"`
+	// Plugin is the name of the plugin in the configuration of the operator
+	Plugin string `json:"plugin"`
+	// Config is the configuration of the plugin, as written in the configuration of the
+	// operator, and ConfigHash its SHA-256: a plugin can keep its clients while the hash
+	// does not change
+	Config     json.RawMessage `json:"config,omitempty"`
+	ConfigHash string          `json:"configHash"`
+
+	// Service is the placeholder Service of the policy, for the holder and the attach
+	// operations
+	Service *Object `json:"service,omitempty"`
+	// IP is the egress IP to find, to move or to release
+	IP string `json:"ip,omitempty"`
+	// Node is the destination node of the attach operation
+	Node *Node `json:"node,omitempty"`
+	// Allocation describes the policy of the allocate and the release operations
+	Allocation *Allocation `json:"allocation,omitempty"`
+}
+
+// Response is returned by the plugin, Error is set when the operation failed
+type Response struct {
+	IP    string `json:"ip,omitempty"`
+	Node  string `json:"node,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Object is the metadata of a Kubernetes object
+type Object struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Node is a node of the cluster
+type Node struct {
+	// Name is the name of the Node object, Hostname the value of its
+	// kubernetes.io/hostname label, used in the nodeSelector of the policies
+	Name       string            `json:"name"`
+	Hostname   string            `json:"hostname"`
+	ProviderID string            `json:"providerID,omitempty"`
+	InternalIP string            `json:"internalIP,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// Allocation is the HAEgressGatewayPolicy an egress IP is allocated for, with the fields
+// of the IPAM webhook
+type Allocation struct {
+	Policy           string            `json:"policy"`
+	Namespace        string            `json:"namespace"`
+	Cluster          string            `json:"cluster,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	SourceNamespaces []string          `json:"sourceNamespaces,omitempty"`
+}
+
+// Mover is implemented by the VIP plugins, the operator chooses the exit node among the
+// eligible nodes of the policy and the plugin moves the egress IP there
+type Mover interface {
+	Holder(ctx context.Context, request *Request) (string, error)
+	Attach(ctx context.Context, request *Request) error
+}
+
+// Allocator is implemented by the IPAM plugins
+type Allocator interface {
+	Allocate(ctx context.Context, request *Request) (string, error)
+	Release(ctx context.Context, request *Request) error
+}
+
+// HealthChecker is implemented by the plugins that can check the system they manage, the
+// plugins without it are always healthy
+type HealthChecker interface {
+	Health(ctx context.Context, request *Request) error
+}
+
+// Handle runs the operation of the request on impl, a Mover, an Allocator, a
+// HealthChecker or any combination of them
+func Handle(ctx context.Context, impl any, request *Request) *Response {
+	response := &Response{}
+	if err := handle(ctx, impl, request, response); err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+func handle(ctx context.Context, impl any, request *Request, response *Response) error {
+	if request.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, the plugin implements %s", request.APIVersion, APIVersion)
+	}
+	var err error
+	mover, isMover := impl.(Mover)
+	allocator, isAllocator := impl.(Allocator)
+	switch request.Operation {
+	case OperationHolder:
+		if !isMover {
+			return ErrNotImplemented
+		}
+		response.Node, err = mover.Holder(ctx, request)
+	case OperationAttach:
+		if !isMover {
+			return ErrNotImplemented
+		}
+		err = mover.Attach(ctx, request)
+	case OperationAllocate:
+		if !isAllocator {
+			return ErrNotImplemented
+		}
+		response.IP, err = allocator.Allocate(ctx, request)
+	case OperationRelease:
+		if !isAllocator {
+			return ErrNotImplemented
+		}
+		err = allocator.Release(ctx, request)
+	case OperationHealth:
+		if checker, ok := impl.(HealthChecker); ok {
+			err = checker.Health(ctx, request)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", request.Operation)
+	}
+	return err
+}
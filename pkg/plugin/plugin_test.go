@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// testVIP is a VIP plugin holding the egress IPs in memory, it is not an Allocator
+type testVIP struct {
+	holders map[string]string
+	health  error
+}
+
+func (p *testVIP) Holder(_ context.Context, request *Request) (string, error) {
+	return p.holders[request.IP], nil
+}
+
+func (p *testVIP) Attach(_ context.Context, request *Request) error {
+	if request.Node == nil {
+		return errors.New("no destination node")
+	}
+	p.holders[request.IP] = request.Node.Hostname
+	return nil
+}
+
+func (p *testVIP) Health(_ context.Context, _ *Request) error {
+	return p.health
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  Request
+		expected Response
+	}{
+		{
+			name:     "holder",
+			request:  Request{APIVersion: APIVersion, Operation: OperationHolder, IP: "10.0.0.7"},
+			expected: Response{Node: "worker-1"},
+		},
+		{
+			name:    "attach",
+			request: Request{APIVersion: APIVersion, Operation: OperationAttach, IP: "10.0.0.8", Node: &Node{Hostname: "worker-2"}},
+		},
+		{
+			name:     "attach without a node",
+			request:  Request{APIVersion: APIVersion, Operation: OperationAttach, IP: "10.0.0.8"},
+			expected: Response{Error: "no destination node"},
+		},
+		{
+			name:     "operation of another interface",
+			request:  Request{APIVersion: APIVersion, Operation: OperationAllocate},
+			expected: Response{Error: ErrNotImplemented.Error()},
+		},
+		{
+			name:     "unhealthy",
+			request:  Request{APIVersion: APIVersion, Operation: OperationHealth},
+			expected: Response{Error: "load balancer unreachable"},
+		},
+		{
+			name:     "unknown operation",
+			request:  Request{APIVersion: APIVersion, Operation: "resize"},
+			expected: Response{Error: `unknown operation "resize"`},
+		},
+		{
+			name:     "unsupported version",
+			request:  Request{APIVersion: "plugin.cilium.angeloxx.ch/v0", Operation: OperationHolder},
+			expected: Response{Error: `unsupported apiVersion "plugin.cilium.angeloxx.ch/v0", the plugin implements ` + APIVersion},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			impl := &testVIP{holders: map[string]string{"10.0.0.7": "worker-1"}, health: errors.New("load balancer unreachable")}
+			if response := Handle(context.Background(), impl, &test.request); *response != test.expected {
+				t.Errorf("the response is %+v, expected %+v", response, test.expected)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name         string
+		config, same string
+	}{
+		{name: "key order", config: `{"vip":"10.0.0.7","pool":"egress"}`, same: `{"pool":"egress","vip":"10.0.0.7"}`},
+		{name: "white space", config: `{"pool": "egress"}`, same: "{\n  \"pool\":\"egress\"\n}"},
+		{name: "no configuration", config: "", same: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if Hash([]byte(test.config)) != Hash([]byte(test.same)) {
+				t.Errorf("%q and %q have different hashes", test.config, test.same)
+			}
+		})
+	}
+	if Hash([]byte(`{"pool":"egress"}`)) == Hash([]byte(`{"pool":"egress-2"}`)) {
+		t.Errorf("different configurations have the same hash")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		fails  string
+	}{
+		{
+			name:   "exec provider",
+			config: "plugins:\n- name: keepalived\n  provider: true\n  exec:\n    command: [/bin/keepalived-plugin]\n",
+		},
+		{
+			name:   "gRPC IPAM",
+			config: "plugins:\n- name: infoblox\n  ipam: true\n  grpc:\n    address: unix:///var/run/plugin.sock\n",
+		},
+		{
+			name:   "duplicated name",
+			config: "plugins:\n- name: f5\n  provider: true\n  http:\n    url: http://f5\n- name: f5\n  ipam: true\n  http:\n    url: http://f5\n",
+			fails:  `"f5" is empty or duplicated`,
+		},
+		{
+			name:   "neither provider nor IPAM",
+			config: "plugins:\n- name: f5\n  http:\n    url: http://f5\n",
+			fails:  "must be a provider, an IPAM or both",
+		},
+		{
+			name:   "two transports",
+			config: "plugins:\n- name: f5\n  provider: true\n  http:\n    url: http://f5\n  grpc:\n    address: f5:9000\n",
+			fails:  "must set exactly one of exec, http and grpc",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plugins.yaml")
+			if err := os.WriteFile(path, []byte(test.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			clients, err := LoadConfig(path)
+			if test.fails != "" {
+				if err == nil || !strings.Contains(err.Error(), test.fails) {
+					t.Errorf("the configuration returned %v, expected %q", err, test.fails)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			definition := clients[0].Definition
+			if clients[0].transport == nil || definition.TimeoutSeconds != 30 || definition.HealthSeconds != 30 {
+				t.Errorf("the plugin has the transport %v and the timeouts %d and %d", clients[0].transport, definition.TimeoutSeconds, definition.HealthSeconds)
+			}
+			if clients[0].ConfigHash() != Hash(nil) {
+				t.Errorf("the plugin has the configuration hash %s", clients[0].ConfigHash())
+			}
+		})
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	impl := &testVIP{holders: map[string]string{"10.0.0.7": "worker-1"}}
+	httpServer := httptest.NewServer(HTTPHandler(impl))
+	defer httpServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	RegisterGRPC(grpcServer, impl)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	tests := []struct {
+		name      string
+		transport transport
+		// handled is set when the plugin serves the requests with Handle
+		handled bool
+	}{
+		{name: "exec", transport: &ExecTransport{
+			Command: []string{"sh", "-c", `grep -q '"configHash":"` + Hash([]byte(`{"pool":"egress"}`)) + `"' && echo "{\"node\":\"$HOLDER\"}"`},
+			Env:     map[string]string{"HOLDER": "worker-1"},
+		}},
+		{name: "HTTP", transport: &HTTPTransport{URL: httpServer.URL}, handled: true},
+		{name: "gRPC", transport: &GRPCTransport{Address: listener.Addr().String()}, handled: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &Client{
+				Definition: Definition{Name: "vip", Config: []byte(`{"pool":"egress"}`), TimeoutSeconds: 10},
+				transport:  test.transport,
+				hash:       Hash([]byte(`{"pool":"egress"}`)),
+			}
+			response, err := client.Call(context.Background(), &Request{Operation: OperationHolder, IP: "10.0.0.7"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Node != "worker-1" {
+				t.Errorf("the holder is %q, expected worker-1", response.Node)
+			}
+			if !test.handled {
+				return
+			}
+			// The errors of the plugin are returned, ErrNotImplemented can be matched
+			_, err = client.Call(context.Background(), &Request{Operation: OperationRelease, IP: "10.0.0.7"})
+			if !errors.Is(err, ErrNotImplemented) {
+				t.Errorf("the release returned %v, expected %v", err, ErrNotImplemented)
+			}
+			_, err = client.Call(context.Background(), &Request{Operation: OperationAttach, IP: "10.0.0.7"})
+			if expected := fmt.Sprintf("plugin vip %s failed: no destination node", OperationAttach); err == nil || err.Error() != expected {
+				t.Errorf("the attach returned %v, expected %q", err, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The gRPC service is defined with the well-known protobuf types, the Struct holds the
+// JSON of the Request and of the Response:
+//
+//	service ProviderPlugin {
+//	  rpc Call(google.protobuf.Struct) returns (google.protobuf.Struct);
+//	}
+const grpcMethod = "/haegress.v1.ProviderPlugin/Call"
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "haegress.v1.ProviderPlugin",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Call",
+			Handler:    callHandler,
+		},
+	},
+	Metadata: "haegress/v1/plugin.proto",
+}
+
+// ServeExec runs the exec plugins: it reads the Request from the standard input, writes
+// the Response to the standard output and exits. The operator runs the plugin for
+// every operation, the plugin should persist nothing but in the system it manages.
+func ServeExec(impl any) {
+	request := &Request{}
+	if err := json.NewDecoder(os.Stdin).Decode(request); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+		os.Exit(1)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(Handle(context.Background(), impl, request)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write the response: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// HTTPHandler serves the HTTP plugins: a POST with the Request as JSON body returns the
+// Response, with the 200 status also when the operation failed
+func HTTPHandler(impl any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		request := &Request{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Handle(r.Context(), impl, request))
+	})
+}
+
+// RegisterGRPC registers the ProviderPlugin service of impl on the gRPC server
+func RegisterGRPC(server *grpc.Server, impl any) {
+	server.RegisterService(&serviceDesc, &grpcServer{impl: impl})
+}
+
+type grpcServer struct {
+	impl any
+}
+
+func callHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := &structpb.Struct{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, req any) (any, error) {
+		request := &Request{}
+		if err := fromStruct(req.(*structpb.Struct), request); err != nil {
+			return nil, err
+		}
+		return toStruct(Handle(ctx, srv.(*grpcServer).impl, request))
+	}
+	if interceptor == nil {
+		return call(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: grpcMethod}, call)
+}
+
+// toStruct converts a Request or a Response to a Struct through its JSON
+func toStruct(value any) (*structpb.Struct, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// fromStruct converts a Struct to a Request or a Response through its JSON
+func fromStruct(in *structpb.Struct, value any) error {
+	data, err := in.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,37 @@
+package preflight
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ServiceIPFamilies returns the IP families the Services of the cluster can use, from the
+// dry-run creation of a PreferDualStack Service in the namespace: the Service of a
+// dual-stack cluster gets both families. The list is empty when the API server does not
+// report them.
+func ServiceIPFamilies(ctx context.Context, c client.Client, namespace string) ([]corev1.IPFamily, error) {
+	familyPolicy := corev1.IPFamilyPolicyPreferDualStack
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "haegress-ip-families-",
+			Namespace:    namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			IPFamilyPolicy: &familyPolicy,
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "nope",
+					Protocol: corev1.ProtocolTCP,
+					Port:     65534,
+				},
+			},
+		},
+	}
+	if err := c.Create(ctx, service, client.DryRunAll); err != nil {
+		return nil, err
+	}
+	return service.Spec.IPFamilies, nil
+}
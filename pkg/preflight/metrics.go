@@ -0,0 +1,70 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	ciliumFeature = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_cilium_feature_enabled",
+			Help: "Cilium features detected by the preflight check (1 enabled, 0 disabled)",
+		},
+		[]string{"feature"},
+	)
+
+	ciliumInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_cilium_info",
+			Help: "Cilium version detected by the preflight check",
+		},
+		[]string{"version"},
+	)
+
+	preflightReady = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_cilium_preflight_ready",
+			Help: "Whether the Cilium preflight check is passing (1) or not (0)",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(ciliumFeature, ciliumInfo, preflightReady)
+}
+
+func recordFeatures(features Features, err error) {
+	ciliumFeature.WithLabelValues("egress-gateway").Set(boolToFloat(features.EgressGateway))
+	ciliumFeature.WithLabelValues("kube-proxy-replacement").Set(boolToFloat(features.KubeProxyReplacement))
+	ciliumFeature.WithLabelValues("l2-announcements").Set(boolToFloat(features.L2Announcements))
+	ciliumFeature.WithLabelValues("lb-ipam").Set(boolToFloat(features.LBIPAM))
+	ciliumFeature.WithLabelValues("hubble").Set(boolToFloat(features.Hubble))
+
+	ciliumInfo.Reset()
+	ciliumInfo.WithLabelValues(features.Version).Set(1)
+	preflightReady.Set(boolToFloat(err == nil))
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
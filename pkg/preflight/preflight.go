@@ -0,0 +1,257 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package preflight detects the installed Cilium version and the features the
+// operator relies on, so that a misconfigured cluster is reported clearly instead
+// of failing later during the reconciliation.
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ciliumConfigMapName = "cilium-config"
+	ciliumDaemonSetName = "cilium"
+	// refreshTimeout bounds the reads of a refresh, the startup one runs before the
+	// manager and its probes are started
+	refreshTimeout = 30 * time.Second
+)
+
+// +kubebuilder:rbac:groups="",namespace=kube-system,resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups=apps,namespace=kube-system,resources=daemonsets,verbs=get
+
+// Features is the set of Cilium capabilities detected in the cluster
+type Features struct {
+	Version              string
+	ClusterName          string
+	EgressGateway        bool
+	KubeProxyReplacement bool
+	L2Announcements      bool
+	LBIPAM               bool
+	Hubble               bool
+}
+
+// Missing returns the features, by their cilium-config key, required by the policies
+// of the given provider and not enabled
+func (f Features) Missing(providerName string) []string {
+	missing := []string{}
+	if !f.EgressGateway {
+		missing = append(missing, "enable-ipv4-egress-gateway")
+	}
+	if !f.KubeProxyReplacement {
+		missing = append(missing, "kube-proxy-replacement")
+	}
+	if providerName == provider.CiliumLBIPAMName {
+		if !f.LBIPAM {
+			missing = append(missing, "enable-lb-ipam")
+		}
+		if !f.L2Announcements {
+			missing = append(missing, "enable-l2-announcements")
+		}
+	}
+	return missing
+}
+
+// Checker periodically reads the Cilium configuration and exposes the result
+// through a set of metrics. The missing features gate the policies that need them,
+// they do not change the readiness of the operator.
+type Checker struct {
+	// Reader should be a non-cached reader, the operator does not need to watch
+	// every ConfigMap and DaemonSet of the cluster
+	Reader client.Reader
+	Log    logr.Logger
+
+	CiliumNamespace string
+	IntervalSeconds int
+
+	mu       sync.RWMutex
+	features Features
+	// detected is set once the Cilium configuration was read
+	detected bool
+	err      error
+}
+
+// SetupWithManager registers the periodic check with the Manager.
+func (c *Checker) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection returns false because every replica gates its own reconciliations
+func (c *Checker) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and refreshes the detected features until the
+// context is cancelled.
+func (c *Checker) Start(ctx context.Context) error {
+	if c.IntervalSeconds <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Duration(c.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				c.Log.Error(err, "Cilium preflight check failed")
+			}
+		}
+	}
+}
+
+// Refresh reads the Cilium configuration and stores the detected features. The
+// returned error reports both read failures and missing mandatory features. When the
+// configuration cannot be read, the features detected before are kept.
+func (c *Checker) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	features, err := c.detect(ctx)
+	readable := err == nil
+	if readable {
+		err = features.Validate()
+	}
+
+	c.mu.Lock()
+	previous := c.features
+	if readable {
+		c.features = features
+		c.detected = true
+	} else {
+		features = c.features
+	}
+	c.err = err
+	c.mu.Unlock()
+
+	if previous != features {
+		c.Log.Info("Detected Cilium features",
+			"version", features.Version,
+			"clusterName", features.ClusterName,
+			"egressGateway", features.EgressGateway,
+			"kubeProxyReplacement", features.KubeProxyReplacement,
+			"l2Announcements", features.L2Announcements,
+			"lbIPAM", features.LBIPAM,
+			"hubble", features.Hubble)
+	}
+	recordFeatures(features, err)
+	return err
+}
+
+// Features returns the last detected set of features
+func (c *Checker) Features() Features {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.features
+}
+
+// Missing returns the features required by the policies of the given provider and
+// not enabled, none until the Cilium configuration was read once
+func (c *Checker) Missing(providerName string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.detected {
+		return nil
+	}
+	return c.features.Missing(providerName)
+}
+
+// Err returns the error of the last check, a read failure or the missing mandatory
+// features
+func (c *Checker) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+func (c *Checker) detect(ctx context.Context) (Features, error) {
+	features := Features{}
+
+	configMap := &corev1.ConfigMap{}
+	if err := c.Reader.Get(ctx, types.NamespacedName{Name: ciliumConfigMapName, Namespace: c.CiliumNamespace}, configMap); err != nil {
+		return features, fmt.Errorf("unable to read %s/%s: %w", c.CiliumNamespace, ciliumConfigMapName, err)
+	}
+	features.EgressGateway = isEnabled(configMap.Data["enable-ipv4-egress-gateway"], false)
+	features.L2Announcements = isEnabled(configMap.Data["enable-l2-announcements"], false)
+	features.LBIPAM = isEnabled(configMap.Data["enable-lb-ipam"], true)
+	features.Hubble = isEnabled(configMap.Data["enable-hubble"], false)
+	features.ClusterName = configMap.Data["cluster-name"]
+	switch strings.ToLower(configMap.Data["kube-proxy-replacement"]) {
+	case "true", "strict":
+		features.KubeProxyReplacement = true
+	}
+
+	daemonSet := &appsv1.DaemonSet{}
+	err := c.Reader.Get(ctx, types.NamespacedName{Name: ciliumDaemonSetName, Namespace: c.CiliumNamespace}, daemonSet)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return features, fmt.Errorf("unable to read %s/%s: %w", c.CiliumNamespace, ciliumDaemonSetName, err)
+	}
+	if err == nil {
+		for _, container := range daemonSet.Spec.Template.Spec.Containers {
+			if container.Name == "cilium-agent" {
+				features.Version = imageVersion(container.Image)
+			}
+		}
+	}
+	return features, nil
+}
+
+// Validate returns an error when a feature required by the operator is missing
+func (f Features) Validate() error {
+	var errs []error
+	if !f.EgressGateway {
+		errs = append(errs, errors.New("Cilium egress gateway is disabled (enable-ipv4-egress-gateway)"))
+	}
+	if !f.KubeProxyReplacement {
+		errs = append(errs, errors.New("Cilium egress gateway requires kube-proxy-replacement"))
+	}
+	return errors.Join(errs...)
+}
+
+func isEnabled(value string, defaultValue bool) bool {
+	switch strings.ToLower(value) {
+	case "true", "enabled":
+		return true
+	case "false", "disabled":
+		return false
+	}
+	return defaultValue
+}
+
+// imageVersion extracts the tag from an image reference, ignoring the digest
+func imageVersion(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		return image[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,370 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2alpha1 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Result of a check of the report
+const (
+	ResultOK      = "OK"
+	ResultWarning = "WARNING"
+	ResultFailed  = "FAILED"
+	ResultSkipped = "SKIPPED"
+)
+
+// CRDs required by the operator
+const (
+	HAEgressGatewayPolicyCRD     = "haegressgatewaypolicies.cilium.angeloxx.ch"
+	CiliumEgressGatewayPolicyCRD = "ciliumegressgatewaypolicies.cilium.io"
+	EgressNodeStatusCRD          = "egressnodestatuses.cilium.angeloxx.ch"
+	metalLBIPAddressPoolCRD      = "ipaddresspools.metallb.io"
+)
+
+// Check is the outcome of a verified prerequisite
+type Check struct {
+	Name    string
+	Result  string
+	Message string
+}
+
+// Report is the list of the verified prerequisites
+type Report struct {
+	Checks []Check
+}
+
+// Failed returns true when at least one prerequisite is not met
+func (r *Report) Failed() bool {
+	for _, check := range r.Checks {
+		if check.Result == ResultFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the report as a table
+func (r *Report) Print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tMESSAGE")
+	for _, check := range r.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, check.Result, check.Message)
+	}
+	return w.Flush()
+}
+
+func (r *Report) add(name, result, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Result: result, Message: fmt.Sprintf(format, args...)})
+}
+
+// Prerequisites verifies, before the operator is installed or when it does not behave,
+// everything it needs from the cluster. Unlike the Checker, that runs in the operator,
+// the failures are reported together instead of one failed reconciliation at a time.
+type Prerequisites struct {
+	// Client must know the core, apps, apiextensions, authorization and Cilium v2alpha1 types
+	Client client.Client
+
+	CiliumNamespace string
+	// EgressNamespace is the namespace of the Services of the policies
+	EgressNamespace string
+	// Provider is the default provider of the operator and LoadBalancerClass its class
+	Provider          string
+	LoadBalancerClass string
+	// ServiceAccount of the operator whose permissions are verified, skipped when empty
+	ServiceAccount types.NamespacedName
+	// IPAMWebhookURL is the URL of the IPAM webhook, skipped when empty
+	IPAMWebhookURL string
+}
+
+// Run verifies every prerequisite
+func (p *Prerequisites) Run(ctx context.Context) *Report {
+	report := &Report{}
+	p.checkCRDs(ctx, report)
+	features := p.checkCilium(ctx, report)
+	p.checkProvider(ctx, report, features)
+	p.checkNamespace(ctx, report)
+	p.checkPermissions(ctx, report)
+	p.checkIPAMWebhook(ctx, report)
+	return report
+}
+
+func (p *Prerequisites) checkCRDs(ctx context.Context, report *Report) {
+	for _, name := range []string{HAEgressGatewayPolicyCRD, CiliumEgressGatewayPolicyCRD} {
+		established, err := p.crdEstablished(ctx, name)
+		switch {
+		case err != nil:
+			report.add("crd", ResultFailed, "%s: %v", name, err)
+		case !established:
+			report.add("crd", ResultFailed, "%s is not established", name)
+		default:
+			report.add("crd", ResultOK, "%s is established", name)
+		}
+	}
+}
+
+func (p *Prerequisites) crdEstablished(ctx context.Context, name string) (bool, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+		return false, err
+	}
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == apiextensionsv1.Established {
+			return condition.Status == apiextensionsv1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *Prerequisites) checkCilium(ctx context.Context, report *Report) Features {
+	checker := &Checker{Reader: p.Client, CiliumNamespace: p.CiliumNamespace}
+	features, err := checker.detect(ctx)
+	if err != nil {
+		report.add("cilium", ResultFailed, "%v", err)
+		return features
+	}
+	if err := features.Validate(); err != nil {
+		report.add("cilium", ResultFailed, "%s", strings.ReplaceAll(err.Error(), "\n", "; "))
+		return features
+	}
+	report.add("cilium", ResultOK, "version %s with the egress gateway and the kube-proxy replacement enabled", orUnknown(features.Version))
+	return features
+}
+
+// checkProvider verifies that something serves the Services of the default provider
+func (p *Prerequisites) checkProvider(ctx context.Context, report *Report, features Features) {
+	name := "provider " + p.Provider
+	switch p.Provider {
+	case provider.StaticName:
+		report.add(name, ResultOK, "the egress IPs and the exit nodes are set with the annotations of the policies")
+	case provider.KubeVIPName:
+		images, classes, err := p.kubeVIPWorkloads(ctx)
+		if err != nil {
+			report.add(name, ResultFailed, "unable to list the workloads: %v", err)
+		} else if len(images) == 0 {
+			report.add(name, ResultFailed, "no kube-vip DaemonSet or Deployment found")
+		} else if p.LoadBalancerClass != "" && !contains(classes, p.LoadBalancerClass) {
+			report.add(name, ResultWarning, "kube-vip found (%s) but none serves the load balancer class %s with lb_class_name",
+				strings.Join(images, ","), p.LoadBalancerClass)
+		} else {
+			report.add(name, ResultOK, "kube-vip found (%s)", strings.Join(images, ","))
+		}
+	case provider.CiliumLBIPAMName:
+		if !features.LBIPAM || !features.L2Announcements {
+			report.add(name, ResultFailed, "Cilium LB IPAM and L2 announcements must be enabled (enable-lb-ipam, enable-l2-announcements)")
+			return
+		}
+		pools := &ciliumv2alpha1.CiliumLoadBalancerIPPoolList{}
+		announcements := &ciliumv2alpha1.CiliumL2AnnouncementPolicyList{}
+		if err := p.Client.List(ctx, pools); err != nil {
+			report.add(name, ResultFailed, "unable to list the CiliumLoadBalancerIPPools: %v", err)
+		} else if err := p.Client.List(ctx, announcements); err != nil {
+			report.add(name, ResultFailed, "unable to list the CiliumL2AnnouncementPolicies: %v", err)
+		} else if len(pools.Items) == 0 || len(announcements.Items) == 0 {
+			report.add(name, ResultFailed, "%d CiliumLoadBalancerIPPools and %d CiliumL2AnnouncementPolicies found, both are needed",
+				len(pools.Items), len(announcements.Items))
+		} else {
+			report.add(name, ResultOK, "%d CiliumLoadBalancerIPPools and %d CiliumL2AnnouncementPolicies found",
+				len(pools.Items), len(announcements.Items))
+		}
+	case provider.MetalLBName:
+		if established, err := p.crdEstablished(ctx, metalLBIPAddressPoolCRD); err != nil || !established {
+			report.add(name, ResultFailed, "MetalLB is not installed, the %s CRD is missing", metalLBIPAddressPoolCRD)
+		} else {
+			report.add(name, ResultOK, "MetalLB is installed")
+		}
+	default:
+		// The cloud providers find the instance of the node from its providerID
+		nodes := &corev1.NodeList{}
+		if err := p.Client.List(ctx, nodes); err != nil {
+			report.add(name, ResultFailed, "unable to list the nodes: %v", err)
+			return
+		}
+		var missing []string
+		for _, node := range nodes.Items {
+			if node.Spec.ProviderID == "" {
+				missing = append(missing, node.Name)
+			}
+		}
+		if len(missing) > 0 {
+			report.add(name, ResultWarning, "the nodes %s have no providerID and can't be exit nodes", strings.Join(missing, ","))
+		} else {
+			report.add(name, ResultOK, "every node has a providerID")
+		}
+	}
+}
+
+// kubeVIPWorkloads returns the images of the kube-vip workloads and their load balancer classes
+func (p *Prerequisites) kubeVIPWorkloads(ctx context.Context) ([]string, []string, error) {
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := p.Client.List(ctx, daemonSets); err != nil {
+		return nil, nil, err
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := p.Client.List(ctx, deployments); err != nil {
+		return nil, nil, err
+	}
+	templates := []corev1.PodTemplateSpec{}
+	for _, daemonSet := range daemonSets.Items {
+		templates = append(templates, daemonSet.Spec.Template)
+	}
+	for _, deployment := range deployments.Items {
+		templates = append(templates, deployment.Spec.Template)
+	}
+
+	var images, classes []string
+	for _, template := range templates {
+		for _, container := range template.Spec.Containers {
+			if !strings.Contains(container.Image, "kube-vip") || strings.Contains(container.Image, "cloud-provider") {
+				continue
+			}
+			images = append(images, container.Image)
+			for _, env := range container.Env {
+				if env.Name == "lb_class_name" {
+					classes = append(classes, env.Value)
+				}
+			}
+		}
+	}
+	return images, classes, nil
+}
+
+func (p *Prerequisites) checkNamespace(ctx context.Context, report *Report) {
+	namespace := &corev1.Namespace{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: p.EgressNamespace}, namespace); err != nil {
+		report.add("egress namespace", ResultFailed, "%s: %v", p.EgressNamespace, err)
+		return
+	}
+	report.add("egress namespace", ResultOK, "%s exists", p.EgressNamespace)
+}
+
+// permission is a permission required by the operator
+type permission struct {
+	group, resource, subresource, verb string
+	// namespace is the namespace of the namespaced resources
+	namespace func(p *Prerequisites) string
+}
+
+func egressNamespace(p *Prerequisites) string { return p.EgressNamespace }
+func ciliumNamespace(p *Prerequisites) string { return p.CiliumNamespace }
+func ownNamespace(p *Prerequisites) string    { return p.ServiceAccount.Namespace }
+
+// The events of the cluster-scoped policies are recorded in the default namespace
+func defaultNamespace(_ *Prerequisites) string { return metav1.NamespaceDefault }
+
+var permissions = []permission{
+	{group: "cilium.angeloxx.ch", resource: "haegressgatewaypolicies", verb: "watch"},
+	{group: "cilium.angeloxx.ch", resource: "haegressgatewaypolicies", verb: "update"},
+	{group: "cilium.angeloxx.ch", resource: "haegressgatewaypolicies", subresource: "status", verb: "patch"},
+	{group: "cilium.io", resource: "ciliumegressgatewaypolicies", verb: "create"},
+	{group: "cilium.io", resource: "ciliumegressgatewaypolicies", verb: "patch"},
+	{group: "cilium.io", resource: "ciliumnodes", verb: "list"},
+	{resource: "services", verb: "watch", namespace: egressNamespace},
+	{resource: "services", verb: "create", namespace: egressNamespace},
+	{resource: "services", verb: "update", namespace: egressNamespace},
+	{resource: "nodes", verb: "watch"},
+	{resource: "events", verb: "create", namespace: egressNamespace},
+	{resource: "events", verb: "create", namespace: defaultNamespace},
+	{resource: "configmaps", verb: "get", namespace: ciliumNamespace},
+	{group: "apps", resource: "daemonsets", verb: "get", namespace: ciliumNamespace},
+	{group: "coordination.k8s.io", resource: "leases", verb: "update", namespace: ownNamespace},
+}
+
+// checkPermissions verifies the permissions of the ServiceAccount of the operator with
+// SubjectAccessReviews
+func (p *Prerequisites) checkPermissions(ctx context.Context, report *Report) {
+	if p.ServiceAccount.Name == "" {
+		report.add("rbac", ResultSkipped, "no ServiceAccount of the operator given")
+		return
+	}
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", p.ServiceAccount.Namespace, p.ServiceAccount.Name)
+	var denied []string
+	for _, required := range permissions {
+		attributes := &authorizationv1.ResourceAttributes{
+			Group:       required.group,
+			Resource:    required.resource,
+			Subresource: required.subresource,
+			Verb:        required.verb,
+		}
+		if required.namespace != nil {
+			attributes.Namespace = required.namespace(p)
+		}
+		review := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{User: user, ResourceAttributes: attributes},
+		}
+		if err := p.Client.Create(ctx, review); err != nil {
+			report.add("rbac", ResultFailed, "unable to review the permissions of %s: %v", user, err)
+			return
+		}
+		if !review.Status.Allowed {
+			resource := attributes.Resource
+			if attributes.Subresource != "" {
+				resource += "/" + attributes.Subresource
+			}
+			if attributes.Group != "" {
+				resource += "." + attributes.Group
+			}
+			if attributes.Namespace != "" {
+				resource += " in " + attributes.Namespace
+			}
+			denied = append(denied, attributes.Verb+" "+resource)
+		}
+	}
+	if len(denied) > 0 {
+		report.add("rbac", ResultFailed, "%s can't %s", user, strings.Join(denied, ", "))
+		return
+	}
+	report.add("rbac", ResultOK, "%s has the required permissions", user)
+}
+
+// checkIPAMWebhook verifies that the IPAM webhook answers, whatever the status code
+func (p *Prerequisites) checkIPAMWebhook(ctx context.Context, report *Report) {
+	if p.IPAMWebhookURL == "" {
+		report.add("ipam webhook", ResultSkipped, "no IPAM webhook configured")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.IPAMWebhookURL, nil)
+	if err != nil {
+		report.add("ipam webhook", ResultFailed, "invalid URL %s: %v", p.IPAMWebhookURL, err)
+		return
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		report.add("ipam webhook", ResultFailed, "%s is not reachable: %v", p.IPAMWebhookURL, err)
+		return
+	}
+	response.Body.Close()
+	report.add("ipam webhook", ResultOK, "%s answered with %s", p.IPAMWebhookURL, response.Status)
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CiliumLBIPAMName is the name of the Cilium LB IPAM provider
+const CiliumLBIPAMName = "cilium-lbipam"
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,namespace=kube-system,resources=leases,verbs=get;list;watch
+
+// CiliumLBIPAM assigns the egress IP with the Cilium LB IPAM and announces it with the
+// Cilium L2 announcements. The announcing node is the holder of the lease that Cilium
+// creates for every announced Service.
+type CiliumLBIPAM struct {
+	Client            client.Client
+	CiliumNamespace   string
+	LoadBalancerClass string
+	// Discovery, if set, reads the exit node from its chain of sources
+	Discovery *Discovery
+}
+
+func (p *CiliumLBIPAM) Name() string {
+	return CiliumLBIPAMName
+}
+
+func (p *CiliumLBIPAM) ConfigureService(policy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service) {
+	configureLoadBalancer(service, loadBalancerClass(policy, p.LoadBalancerClass))
+}
+
+func (p *CiliumLBIPAM) RequestIP(service *corev1.Service, ip string) {
+	service.Annotations[haegressip.CiliumLBIPAMIPsAnnotation] = ip
+}
+
+func (p *CiliumLBIPAM) EgressIP(_ context.Context, service *corev1.Service) (string, error) {
+	return loadBalancerIP(service), nil
+}
+
+func (p *CiliumLBIPAM) ExitNode(ctx context.Context, service *corev1.Service) (string, error) {
+	if p.Discovery != nil {
+		return p.Discovery.ExitNode(ctx, service)
+	}
+	lease := &coordinationv1.Lease{}
+	err := p.Client.Get(ctx, types.NamespacedName{
+		Name:      fmt.Sprintf("%s%s-%s", haegressip.CiliumL2AnnounceLeasePrefix, service.Namespace, service.Name),
+		Namespace: p.CiliumNamespace,
+	}, lease)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return "", nil
+	}
+	return *lease.Spec.HolderIdentity, nil
+}
+
+// PollInterval implements Poller, lease changes are not reflected on the Service
+func (p *CiliumLBIPAM) PollInterval() time.Duration {
+	return haegressip.LeaseCheckRequeueAfter
+}
@@ -0,0 +1,277 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/disruption"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IPMover attaches a cloud IP address to the network interface of a node instance
+type IPMover interface {
+	// Name returns the name used to select the provider
+	Name() string
+	// Holder returns the ID of the instance the IP is attached to, empty if detached.
+	// The ID is matched against the last element of the node providerID, an ID with a
+	// slash against the end of the providerID.
+	Holder(ctx context.Context, service *corev1.Service, ip string) (string, error)
+	// Attach moves the IP to the instance of the node, detaching it from the previous one
+	Attach(ctx context.Context, service *corev1.Service, ip string, node *corev1.Node) error
+}
+
+// Cloud moves the egress IP between the nodes with the API of a cloud provider, for the
+// environments where an ARP based VIP cannot work. Unlike the load balancer providers,
+// the operator chooses the exit node: the node holding the IP is kept while it is Ready
+// and eligible, otherwise the IP is moved to the first Ready node, in name order,
+// matching the nodeSelector of the HAEgressGatewayPolicy. The nodes labelled as drained
+// are not eligible, and the node of the preferred-exit-node annotation is chosen
+// whenever it is eligible. Leaving a node still Ready is subject to the disruption budget.
+// The IP is moved only by Ensure, in the reconciliation of the Service, ExitNode returns
+// the holder it found.
+type Cloud struct {
+	Client client.Client
+	Mover  IPMover
+	// Budget limits the moves away from a node that is still Ready, the drained or not
+	// preferred one, nil to move the IPs at once
+	Budget *disruption.Budget
+	// ByHostname matches the holder returned by the Mover with the hostname of the nodes,
+	// instead of their instance ID, so the nodes don't need a providerID
+	ByHostname bool
+
+	lock    sync.Mutex
+	holders map[types.NamespacedName]heldIP
+}
+
+// heldIP is the node holding the IP of a Service when it was last checked
+type heldIP struct {
+	ip      string
+	node    string
+	checked time.Time
+}
+
+func (p *Cloud) Name() string {
+	return p.Mover.Name()
+}
+
+func (p *Cloud) ConfigureService(_ *haegressv2.HAEgressGatewayPolicy, service *corev1.Service) {
+	// A headless Service does not consume a ClusterIP nor an external IP
+	service.Spec.Type = corev1.ServiceTypeClusterIP
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+}
+
+func (p *Cloud) RequestIP(service *corev1.Service, ip string) {
+	service.Annotations[haegressip.StaticEgressIPAnnotation] = ip
+}
+
+func (p *Cloud) EgressIP(_ context.Context, service *corev1.Service) (string, error) {
+	return service.Annotations[haegressip.StaticEgressIPAnnotation], nil
+}
+
+// ExitNode returns the node holding the IP found by the last Ensure, or by the last check
+// of the holder, without moving the IP. The holder is checked again once per poll interval.
+func (p *Cloud) ExitNode(ctx context.Context, service *corev1.Service) (string, error) {
+	ip := service.Annotations[haegressip.StaticEgressIPAnnotation]
+	if ip == "" {
+		return "", nil
+	}
+	if node, ok := p.cached(service, ip); ok {
+		return node, nil
+	}
+
+	holder, err := p.Mover.Holder(ctx, service, ip)
+	if err != nil {
+		return "", err
+	}
+	nodes := &corev1.NodeList{}
+	if err := p.Client.List(ctx, nodes); err != nil {
+		return "", err
+	}
+	node := ""
+	for i := range nodes.Items {
+		if p.holds(&nodes.Items[i], holder) {
+			node = nodes.Items[i].Labels[haegressip.NodeNameAnnotation]
+			break
+		}
+	}
+	p.remember(service, ip, node)
+	return node, nil
+}
+
+// Ensure implements Ensurer: it keeps the IP on the node holding it while the node is
+// eligible, otherwise it moves the IP to the exit node, and returns the exit node
+func (p *Cloud) Ensure(ctx context.Context, service *corev1.Service) (string, error) {
+	ip := service.Annotations[haegressip.StaticEgressIPAnnotation]
+	if ip == "" {
+		return "", nil
+	}
+
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+		return "", err
+	}
+	ready, err := readyNodes(ctx, p.Client, policy, !p.ByHostname)
+	if err != nil {
+		return "", err
+	}
+	// The node excluded by a failover drill is handled as a drained node
+	avoided := policy.Annotations[haegressip.DrillAvoidNodeAnnotation]
+	nodes := []corev1.Node{}
+	for _, node := range ready {
+		if node.Labels[haegressip.EgressDrainedNodeLabel] != "true" && (avoided == "" || node.Labels[haegressip.NodeNameAnnotation] != avoided) {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		p.remember(service, ip, "")
+		return "", nil
+	}
+
+	// An eligible preferred node is the only acceptable one, the IP is moved there
+	if preferred := policy.Annotations[haegressip.PreferredExitNodeAnnotation]; preferred != "" {
+		for _, node := range nodes {
+			if node.Labels[haegressip.NodeNameAnnotation] == preferred {
+				nodes = []corev1.Node{node}
+				break
+			}
+		}
+	}
+
+	holder, err := p.Mover.Holder(ctx, service, ip)
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if p.holds(&node, holder) {
+			p.remember(service, ip, node.Labels[haegressip.NodeNameAnnotation])
+			return node.Labels[haegressip.NodeNameAnnotation], nil
+		}
+	}
+	// The node holding the IP is still Ready, the move is voluntary
+	for _, node := range ready {
+		if p.holds(&node, holder) && !p.Budget.Allow(policy) {
+			ctrl.LoggerFrom(ctx).Info("Disruption budget exhausted, the egress IP is moved later",
+				"node", node.Labels[haegressip.NodeNameAnnotation], "group", disruption.Group(policy))
+			p.remember(service, ip, node.Labels[haegressip.NodeNameAnnotation])
+			return node.Labels[haegressip.NodeNameAnnotation], nil
+		}
+	}
+
+	// The IP is detached or attached to a node that cannot be used anymore
+	if err := p.Mover.Attach(ctx, service, ip, &nodes[0]); err != nil {
+		p.forget(service)
+		return "", err
+	}
+	p.remember(service, ip, nodes[0].Labels[haegressip.NodeNameAnnotation])
+	return nodes[0].Labels[haegressip.NodeNameAnnotation], nil
+}
+
+// PollInterval implements Poller, the node failures are not reflected on the Service
+func (p *Cloud) PollInterval() time.Duration {
+	return haegressip.LeaseCheckRequeueAfter
+}
+
+// cached returns the node holding the IP of the Service when it was checked within the
+// poll interval
+func (p *Cloud) cached(service *corev1.Service, ip string) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	held, ok := p.holders[client.ObjectKeyFromObject(service)]
+	if !ok || held.ip != ip || time.Since(held.checked) > p.PollInterval() {
+		return "", false
+	}
+	return held.node, true
+}
+
+// remember records the node holding the IP of the Service, empty when none holds it
+func (p *Cloud) remember(service *corev1.Service, ip, node string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.holders == nil {
+		p.holders = map[types.NamespacedName]heldIP{}
+	}
+	p.holders[client.ObjectKeyFromObject(service)] = heldIP{ip: ip, node: node, checked: time.Now()}
+}
+
+// forget drops the holder of the IP of the Service, checked again at the next call
+func (p *Cloud) forget(service *corev1.Service) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.holders, client.ObjectKeyFromObject(service))
+}
+
+// holds returns true when the node is the holder returned by the Mover
+func (p *Cloud) holds(node *corev1.Node, holder string) bool {
+	switch {
+	case holder == "":
+		return false
+	case p.ByHostname:
+		return node.Labels[haegressip.NodeNameAnnotation] == holder
+	case strings.Contains(holder, "/"):
+		// The instances whose last element is not unique, like the scale set instances
+		return strings.HasSuffix(strings.ToLower(node.Spec.ProviderID), "/"+strings.ToLower(holder))
+	}
+	return instanceID(node) == holder
+}
+
+// readyNodes returns the Ready nodes matching the nodeSelector of the policy, in name
+// order, only the ones with a providerID when requested
+func readyNodes(ctx context.Context, c client.Client, policy *haegressv2.HAEgressGatewayPolicy, withProviderID bool) ([]corev1.Node, error) {
+	listOptions := []client.ListOption{}
+	if nodeSelector := policy.EgressNodeSelector(); nodeSelector != nil {
+		labelSelector := &metav1.LabelSelector{MatchLabels: map[string]string{}}
+		for key, value := range nodeSelector.MatchLabels {
+			labelSelector.MatchLabels[key] = value
+		}
+		for _, expression := range nodeSelector.MatchExpressions {
+			labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      expression.Key,
+				Operator: metav1.LabelSelectorOperator(expression.Operator),
+				Values:   expression.Values,
+			})
+		}
+		selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		listOptions = append(listOptions, client.MatchingLabelsSelector{Selector: selector})
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes, listOptions...); err != nil {
+		return nil, err
+	}
+	ready := []corev1.Node{}
+	for _, node := range nodes.Items {
+		if node.Labels[haegressip.NodeNameAnnotation] != "" && (node.Spec.ProviderID != "" || !withProviderID) && isNodeReady(&node) {
+			ready = append(ready, node)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].Name < ready[j].Name
+	})
+	return ready, nil
+}
+
+// instanceID returns the last element of the node providerID, the instance ID on most clouds
+func instanceID(node *corev1.Node) string {
+	return node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
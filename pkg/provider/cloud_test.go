@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testMover holds the IP on the instance it was last attached to
+type testMover struct {
+	holder   string
+	holders  int
+	attaches int
+}
+
+func (m *testMover) Name() string {
+	return "test"
+}
+
+func (m *testMover) Holder(_ context.Context, _ *corev1.Service, _ string) (string, error) {
+	m.holders++
+	return m.holder, nil
+}
+
+func (m *testMover) Attach(_ context.Context, _ *corev1.Service, _ string, node *corev1.Node) error {
+	m.attaches++
+	m.holder = node.Labels[haegressip.NodeNameAnnotation]
+	return nil
+}
+
+func testNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{haegressip.NodeNameAnnotation: name}},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}},
+	}
+}
+
+func TestCloudExitNodeWithoutSideEffects(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-web"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy, testNode("worker-1", true), testNode("worker-2", false)).Build()
+	mover := &testMover{holder: "worker-2"}
+	cloud := &Cloud{Client: c, Mover: mover, ByHostname: true}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:        "egress-web",
+		Namespace:   "egress-system",
+		Labels:      map[string]string{haegressip.HAEgressGatewayPolicyName: "egress-web"},
+		Annotations: map[string]string{haegressip.StaticEgressIPAnnotation: "10.0.0.7"},
+	}}
+
+	// The holder is reported as it is, even when it is not Ready, and checked once per
+	// poll interval
+	for i := 0; i < 3; i++ {
+		node, err := cloud.ExitNode(ctx, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != "worker-2" {
+			t.Errorf("the exit node is %q, expected the holder worker-2", node)
+		}
+	}
+	if mover.attaches != 0 || mover.holders != 1 {
+		t.Errorf("ExitNode attached the IP %d times and read the holder %d times", mover.attaches, mover.holders)
+	}
+
+	// Ensure moves the IP away from the node not Ready, ExitNode returns the new holder
+	node, err := cloud.Ensure(ctx, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node != "worker-1" || mover.attaches != 1 {
+		t.Errorf("Ensure returned %q after %d attachments, expected worker-1 after one", node, mover.attaches)
+	}
+	if node, err := cloud.ExitNode(ctx, service); err != nil || node != "worker-1" {
+		t.Errorf("the exit node is %q after Ensure, error %v", node, err)
+	}
+	if mover.holders != 2 {
+		t.Errorf("the holder was read %d times, expected once by ExitNode and once by Ensure", mover.holders)
+	}
+}
@@ -0,0 +1,416 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/plugin"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The load balancer providers read the exit node from a single signal by default. With a
+// discovery chain they read it from an ordered list of sources instead: the first source
+// with a fresh answer wins, a stale answer is cross-checked with the next sources rather
+// than trusted, and a source reporting another node than the winner is a disagreement.
+
+// Sources of the discovery chains
+const (
+	// SourceAnnotation is the kube-vip.io/vipHost annotation of the Service, kube-vip only
+	SourceAnnotation = "annotation"
+	// SourceLease is the holder of the Lease of the VIP, the per-Service leader election of
+	// kube-vip or the L2 announcement of Cilium, stale once expired
+	SourceLease = "lease"
+	// SourceStatus is the ServiceL2Status published by MetalLB
+	SourceStatus = "status"
+	// SourcePluginPrefix is followed by the name of a plugin answering the holder
+	// operation, e.g. an agent detecting the gratuitous ARPs announcing the egress IP
+	SourcePluginPrefix = "plugin:"
+)
+
+// discoverySources are the built-in sources of every provider
+var discoverySources = map[string][]string{
+	KubeVIPName:      {SourceAnnotation, SourceLease},
+	CiliumLBIPAMName: {SourceLease},
+	MetalLBName:      {SourceStatus},
+}
+
+// defaultLeaseDuration is the duration of the Leases without leaseDurationSeconds
+const defaultLeaseDuration = 15 * time.Second
+
+var (
+	discoveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_exit_node_discovery_total",
+			Help: "Exit nodes read with a discovery chain, by provider and source of the answer, none when no source answered",
+		},
+		[]string{"provider", "source"},
+	)
+
+	disagreements = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_exit_node_discovery_disagreements_total",
+			Help: "Exit nodes read with a discovery chain where a source reported another node than the answer, by provider and disagreeing source",
+		},
+		[]string{"provider", "source"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(discoveries, disagreements)
+}
+
+// Observation is the exit node reported by a source
+type Observation struct {
+	Node string
+	// Stale is true when the source can't tell the node still announces the egress IP,
+	// Reason tells why
+	Stale  bool
+	Reason string
+}
+
+// Source reports the node announcing the egress IP of a Service
+type Source interface {
+	Name() string
+	// Observe returns the node announcing the egress IP, empty when the source does not
+	// know it
+	Observe(ctx context.Context, service *corev1.Service) (Observation, error)
+}
+
+// Discovery reads the exit node of the Services of a provider from an ordered chain of
+// sources
+type Discovery struct {
+	Provider string
+	Sources  []Source
+	// Nodes reads the Nodes, the answer of a source naming a node deleted or not Ready is
+	// stale
+	Nodes client.Reader
+	Log   logr.Logger
+
+	// reported are the last disagreements logged, by Service
+	reported sync.Map
+}
+
+// DiscoveryOptions are the dependencies of the sources of the discovery chains
+type DiscoveryOptions struct {
+	Client          client.Client
+	CiliumNamespace string
+	Plugins         []*plugin.Client
+	Log             logr.Logger
+}
+
+// ParseDiscoveryChains parses the chains of the --exit-node-discovery flag, e.g.
+// kube-vip=lease,annotation,plugin:garp-agent;metallb=status,plugin:garp-agent
+func ParseDiscoveryChains(value string) (map[string][]string, error) {
+	chains := map[string][]string{}
+	for _, chain := range strings.Split(value, ";") {
+		chain = strings.TrimSpace(chain)
+		if chain == "" {
+			continue
+		}
+		name, sources, ok := strings.Cut(chain, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid discovery chain %q, expected provider=source,source", chain)
+		}
+		if _, ok := chains[name]; ok {
+			return nil, fmt.Errorf("duplicated discovery chain of the provider %s", name)
+		}
+		for _, source := range strings.Split(sources, ",") {
+			if source = strings.TrimSpace(source); source != "" {
+				chains[name] = append(chains[name], source)
+			}
+		}
+		if len(chains[name]) == 0 {
+			return nil, fmt.Errorf("empty discovery chain of the provider %s", name)
+		}
+	}
+	return chains, nil
+}
+
+// ConfigureDiscovery sets the discovery chains on the providers, a chain of a provider
+// not configured or without discovery support is an error
+func ConfigureDiscovery(chains map[string][]string, providers []Provider, options DiscoveryOptions) error {
+	configured := map[string]bool{}
+	for _, vipProvider := range providers {
+		names, ok := chains[vipProvider.Name()]
+		if !ok {
+			continue
+		}
+		discovery, err := NewDiscovery(vipProvider.Name(), names, options)
+		if err != nil {
+			return err
+		}
+		switch p := vipProvider.(type) {
+		case *KubeVIP:
+			p.Discovery = discovery
+		case *CiliumLBIPAM:
+			p.Discovery = discovery
+		case *MetalLB:
+			p.Discovery = discovery
+		default:
+			return fmt.Errorf("the provider %s chooses the exit node, it has no discovery chain", vipProvider.Name())
+		}
+		configured[vipProvider.Name()] = true
+	}
+	for name := range chains {
+		if !configured[name] {
+			return fmt.Errorf("discovery chain of the unknown provider %s", name)
+		}
+	}
+	return nil
+}
+
+// NewDiscovery returns the discovery chain of the provider with the named sources, in
+// order
+func NewDiscovery(providerName string, names []string, options DiscoveryOptions) (*Discovery, error) {
+	builtin, ok := discoverySources[providerName]
+	if !ok {
+		return nil, fmt.Errorf("the provider %s chooses the exit node, it has no discovery chain", providerName)
+	}
+	discovery := &Discovery{
+		Provider: providerName,
+		Nodes:    options.Client,
+		Log:      options.Log.WithValues("provider", providerName),
+	}
+	for _, name := range names {
+		if pluginName, ok := strings.CutPrefix(name, SourcePluginPrefix); ok {
+			source, err := newPluginSource(pluginName, options.Plugins)
+			if err != nil {
+				return nil, err
+			}
+			discovery.Sources = append(discovery.Sources, source)
+			continue
+		}
+		if !contains(builtin, name) {
+			return nil, fmt.Errorf("unknown discovery source %q of the provider %s, valid sources are %v and %s<name>",
+				name, providerName, builtin, SourcePluginPrefix)
+		}
+		switch {
+		case name == SourceAnnotation:
+			discovery.Sources = append(discovery.Sources, annotationSource{})
+		case name == SourceLease && providerName == KubeVIPName:
+			discovery.Sources = append(discovery.Sources, &leaseSource{
+				Reader: options.Client,
+				key: func(service *corev1.Service) types.NamespacedName {
+					return types.NamespacedName{Name: haegressip.KubeVIPServiceLeasePrefix + service.Name, Namespace: service.Namespace}
+				},
+			})
+		case name == SourceLease:
+			discovery.Sources = append(discovery.Sources, &leaseSource{
+				Reader: options.Client,
+				key: func(service *corev1.Service) types.NamespacedName {
+					return types.NamespacedName{
+						Name:      fmt.Sprintf("%s%s-%s", haegressip.CiliumL2AnnounceLeasePrefix, service.Namespace, service.Name),
+						Namespace: options.CiliumNamespace,
+					}
+				},
+			})
+		case name == SourceStatus:
+			discovery.Sources = append(discovery.Sources, &statusSource{MetalLB: &MetalLB{Client: options.Client}})
+		}
+	}
+	return discovery, nil
+}
+
+// ExitNode returns the node of the first fresh answer of the sources, or of the first
+// stale one when none is fresh
+func (d *Discovery) ExitNode(ctx context.Context, service *corev1.Service) (string, error) {
+	var answer *Observation
+	answerSource := "none"
+	stale := map[string]Observation{}
+	var lastErr error
+	for _, source := range d.Sources {
+		observation, err := source.Observe(ctx, service)
+		if err != nil {
+			// The next sources are the fallback
+			d.Log.V(1).Info("Exit node discovery source failed", "source", source.Name(),
+				"Service.Namespace", service.Namespace, "Service.Name", service.Name, "error", err.Error())
+			lastErr = err
+			continue
+		}
+		if observation.Node == "" {
+			continue
+		}
+		if !observation.Stale {
+			if observation.Stale, observation.Reason, err = d.nodeStale(ctx, observation.Node); err != nil {
+				return "", err
+			}
+		}
+		if observation.Stale {
+			stale[source.Name()] = observation
+			if answer == nil {
+				first := observation
+				answer, answerSource = &first, source.Name()
+			}
+			continue
+		}
+		answer, answerSource = &observation, source.Name()
+		break
+	}
+	discoveries.WithLabelValues(d.Provider, answerSource).Inc()
+	if answer == nil {
+		if len(stale) == 0 && lastErr != nil {
+			return "", lastErr
+		}
+		return "", nil
+	}
+
+	// The stale answers are cross-checked with the answer
+	disagreeing := []string{}
+	for _, name := range sortedNames(stale) {
+		if stale[name].Node != answer.Node {
+			disagreements.WithLabelValues(d.Provider, name).Inc()
+			disagreeing = append(disagreeing, fmt.Sprintf("%s reports %s (%s)", name, stale[name].Node, stale[name].Reason))
+		}
+	}
+	key := service.Namespace + "/" + service.Name
+	summary := strings.Join(disagreeing, ", ")
+	if previous, _ := d.reported.Load(key); previous != summary {
+		if summary != "" {
+			d.Log.Info("Exit node discovery sources disagree, trusting the fresh answer",
+				"Service.Namespace", service.Namespace, "Service.Name", service.Name,
+				"source", answerSource, "node", answer.Node, "disagreements", summary)
+		}
+		d.reported.Store(key, summary)
+	}
+	return answer.Node, nil
+}
+
+// nodeStale returns true when the node is deleted or not Ready
+func (d *Discovery) nodeStale(ctx context.Context, hostname string) (bool, string, error) {
+	if d.Nodes == nil {
+		return false, "", nil
+	}
+	var nodes corev1.NodeList
+	if err := d.Nodes.List(ctx, &nodes, client.MatchingLabels{haegressip.NodeNameAnnotation: hostname}); err != nil {
+		return false, "", err
+	}
+	if len(nodes.Items) == 0 {
+		return true, "node not found", nil
+	}
+	if !isNodeReady(&nodes.Items[0]) {
+		return true, "node not Ready", nil
+	}
+	return false, "", nil
+}
+
+// annotationSource reads the kube-vip.io/vipHost annotation, written only on the elections
+// of kube-vip: it's stale only when its node is
+type annotationSource struct{}
+
+func (annotationSource) Name() string {
+	return SourceAnnotation
+}
+
+func (annotationSource) Observe(_ context.Context, service *corev1.Service) (Observation, error) {
+	return Observation{Node: service.Annotations[haegressip.KubeVIPVipHostAnnotation]}, nil
+}
+
+// leaseSource reads the holder of the Lease of the VIP, stale once the Lease expired
+type leaseSource struct {
+	Reader client.Reader
+	key    func(service *corev1.Service) types.NamespacedName
+}
+
+func (s *leaseSource) Name() string {
+	return SourceLease
+}
+
+func (s *leaseSource) Observe(ctx context.Context, service *corev1.Service) (Observation, error) {
+	lease := &coordinationv1.Lease{}
+	err := s.Reader.Get(ctx, s.key(service), lease)
+	if apierrors.IsNotFound(err) {
+		return Observation{}, nil
+	} else if err != nil {
+		return Observation{}, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return Observation{}, nil
+	}
+	observation := Observation{Node: *lease.Spec.HolderIdentity}
+	renewed := lease.Spec.RenewTime
+	if renewed == nil {
+		renewed = lease.Spec.AcquireTime
+	}
+	duration := defaultLeaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	if renewed == nil || time.Since(renewed.Time) > duration {
+		observation.Stale = true
+		observation.Reason = "Lease expired"
+	}
+	return observation, nil
+}
+
+// statusSource reads the ServiceL2Status of MetalLB
+type statusSource struct {
+	MetalLB *MetalLB
+}
+
+func (s *statusSource) Name() string {
+	return SourceStatus
+}
+
+func (s *statusSource) Observe(ctx context.Context, service *corev1.Service) (Observation, error) {
+	node, err := s.MetalLB.announcer(ctx, service)
+	return Observation{Node: node}, err
+}
+
+// pluginSource asks the holder of the egress IP to a plugin, e.g. an agent on the nodes
+// detecting the gratuitous ARPs
+type pluginSource struct {
+	Mover *PluginMover
+}
+
+func newPluginSource(name string, plugins []*plugin.Client) (*pluginSource, error) {
+	for _, pluginClient := range plugins {
+		if pluginClient.Name() == name {
+			return &pluginSource{Mover: &PluginMover{Plugin: pluginClient}}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown plugin %q of the discovery source %s%s", name, SourcePluginPrefix, name)
+}
+
+func (s *pluginSource) Name() string {
+	return SourcePluginPrefix + s.Mover.Name()
+}
+
+func (s *pluginSource) Observe(ctx context.Context, service *corev1.Service) (Observation, error) {
+	ip := loadBalancerIP(service)
+	if ip == "" {
+		return Observation{}, nil
+	}
+	node, err := s.Mover.Holder(ctx, service, ip)
+	return Observation{Node: node}, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames(observations map[string]Observation) []string {
+	names := make([]string, 0, len(observations))
+	for name := range observations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
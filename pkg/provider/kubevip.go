@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KubeVIPName is the name of the kube-vip provider
+const KubeVIPName = "kube-vip"
+
+// KubeVIP assigns the egress IP with kube-vip, which reports the node holding the
+// VIP with the kube-vip.io/vipHost annotation on the Service
+type KubeVIP struct {
+	LoadBalancerClass string
+	// Leases, if set, reads the Lease of the kube-vip per-Service leader election, whose
+	// holder is the node announcing the VIP as soon as it is elected, before the
+	// annotation is updated
+	Leases client.Reader
+	// NodeAffinity creates the Services without selector and with the Local traffic
+	// policy, so with the per-Service leader election only the nodes of their Endpoints,
+	// written by the operator, announce the VIP
+	NodeAffinity bool
+	// Discovery, if set, reads the exit node from its chain of sources instead of the
+	// Lease and the annotation
+	Discovery *Discovery
+}
+
+func (p *KubeVIP) Name() string {
+	return KubeVIPName
+}
+
+func (p *KubeVIP) ConfigureService(policy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service) {
+	configureLoadBalancer(service, loadBalancerClass(policy, p.LoadBalancerClass))
+	service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyCluster
+	if p.NodeAffinity {
+		service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyLocal
+		service.Spec.Selector = nil
+	}
+	// Avoid L2 announcement by Cilium
+	service.Labels[haegressip.KubernetesServiceProxyNameAnnotation] = "kubevip-managed-by-cilium-haegess"
+}
+
+func (p *KubeVIP) RequestIP(service *corev1.Service, ip string) {
+	service.Annotations[haegressip.KubeVIPLoadBalancerIPsAnnotation] = ip
+}
+
+func (p *KubeVIP) EgressIP(_ context.Context, service *corev1.Service) (string, error) {
+	return loadBalancerIP(service), nil
+}
+
+func (p *KubeVIP) ExitNode(ctx context.Context, service *corev1.Service) (string, error) {
+	if p.Discovery != nil {
+		return p.Discovery.ExitNode(ctx, service)
+	}
+	if p.Leases != nil {
+		lease := &coordinationv1.Lease{}
+		err := p.Leases.Get(ctx, types.NamespacedName{
+			Name:      haegressip.KubeVIPServiceLeasePrefix + service.Name,
+			Namespace: service.Namespace,
+		}, lease)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", err
+		}
+		// Without the per-Service leader election kube-vip does not create the Lease
+		if err == nil && lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+			return *lease.Spec.HolderIdentity, nil
+		}
+	}
+	return service.Annotations[haegressip.KubeVIPVipHostAnnotation], nil
+}
+
+// PollInterval implements Poller, the sources of a discovery chain other than the
+// annotation are not reflected on the Service
+func (p *KubeVIP) PollInterval() time.Duration {
+	if p.Discovery == nil {
+		return 0
+	}
+	return haegressip.LeaseCheckRequeueAfter
+}
+
+// RenewTime returns the last renewal of the Lease of the per-Service leader election of
+// the Service by its holder, false without the Leases reader or without the Lease. The
+// kube-vip.io/vipHost annotation is written only on the elections, it can't tell a holder
+// still renewing its claim from a dead one.
+func (p *KubeVIP) RenewTime(ctx context.Context, service *corev1.Service) (time.Time, bool, error) {
+	if p.Leases == nil {
+		return time.Time{}, false, nil
+	}
+	lease := &coordinationv1.Lease{}
+	err := p.Leases.Get(ctx, types.NamespacedName{
+		Name:      haegressip.KubeVIPServiceLeasePrefix + service.Name,
+		Namespace: service.Namespace,
+	}, lease)
+	if apierrors.IsNotFound(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	switch {
+	case lease.Spec.RenewTime != nil:
+		return lease.Spec.RenewTime.Time, true, nil
+	case lease.Spec.AcquireTime != nil:
+		return lease.Spec.AcquireTime.Time, true, nil
+	}
+	return time.Time{}, false, nil
+}
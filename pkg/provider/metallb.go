@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MetalLBName is the name of the MetalLB provider
+const MetalLBName = "metallb"
+
+// +kubebuilder:rbac:groups=metallb.io,resources=servicel2statuses,verbs=get;list;watch
+
+var serviceL2StatusGVK = schema.GroupVersionKind{
+	Group:   "metallb.io",
+	Version: "v1beta1",
+	Kind:    "ServiceL2StatusList",
+}
+
+// MetalLB assigns the egress IP with MetalLB in L2 mode. The announcing node is read
+// from the ServiceL2Status resource published by MetalLB for every announced Service.
+type MetalLB struct {
+	Client            client.Client
+	LoadBalancerClass string
+	// Discovery, if set, reads the exit node from its chain of sources
+	Discovery *Discovery
+}
+
+func (p *MetalLB) Name() string {
+	return MetalLBName
+}
+
+func (p *MetalLB) ConfigureService(policy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service) {
+	configureLoadBalancer(service, loadBalancerClass(policy, p.LoadBalancerClass))
+}
+
+func (p *MetalLB) RequestIP(service *corev1.Service, ip string) {
+	service.Annotations[haegressip.MetalLBLoadBalancerIPsAnnotation] = ip
+}
+
+func (p *MetalLB) EgressIP(_ context.Context, service *corev1.Service) (string, error) {
+	return loadBalancerIP(service), nil
+}
+
+func (p *MetalLB) ExitNode(ctx context.Context, service *corev1.Service) (string, error) {
+	if p.Discovery != nil {
+		return p.Discovery.ExitNode(ctx, service)
+	}
+	return p.announcer(ctx, service)
+}
+
+// announcer returns the node of the ServiceL2Status of the Service
+func (p *MetalLB) announcer(ctx context.Context, service *corev1.Service) (string, error) {
+	statuses := &unstructured.UnstructuredList{}
+	statuses.SetGroupVersionKind(serviceL2StatusGVK)
+	if err := p.Client.List(ctx, statuses, client.MatchingLabels{
+		haegressip.MetalLBServiceNameLabel:      service.Name,
+		haegressip.MetalLBServiceNamespaceLabel: service.Namespace,
+	}); err != nil {
+		return "", err
+	}
+	for _, status := range statuses.Items {
+		node, _, err := unstructured.NestedString(status.Object, "status", "node")
+		if err == nil && node != "" {
+			return node, nil
+		}
+	}
+	return "", nil
+}
+
+// PollInterval implements Poller, the ServiceL2Status is not reflected on the Service
+func (p *MetalLB) PollInterval() time.Duration {
+	return haegressip.LeaseCheckRequeueAfter
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"context"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/plugin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PluginMover moves the egress IPs with an out-of-tree plugin, behind a Cloud provider
+// with ByHostname set: the plugin identifies the nodes by their hostname
+type PluginMover struct {
+	Plugin *plugin.Client
+}
+
+func (m *PluginMover) Name() string {
+	return m.Plugin.Name()
+}
+
+// Holder returns the hostname of the node holding the IP, empty if detached
+func (m *PluginMover) Holder(ctx context.Context, service *corev1.Service, ip string) (string, error) {
+	response, err := m.Plugin.Call(ctx, &plugin.Request{
+		Operation: plugin.OperationHolder,
+		Service:   pluginObject(service),
+		IP:        ip,
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.Node, nil
+}
+
+func (m *PluginMover) Attach(ctx context.Context, service *corev1.Service, ip string, node *corev1.Node) error {
+	request := &plugin.Request{
+		Operation: plugin.OperationAttach,
+		Service:   pluginObject(service),
+		IP:        ip,
+		Node: &plugin.Node{
+			Name:       node.Name,
+			Hostname:   node.Labels[haegressip.NodeNameAnnotation],
+			ProviderID: node.Spec.ProviderID,
+			Labels:     node.Labels,
+		},
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			request.Node.InternalIP = address.Address
+			break
+		}
+	}
+	_, err := m.Plugin.Call(ctx, request)
+	return err
+}
+
+func pluginObject(service *corev1.Service) *plugin.Object {
+	return &plugin.Object{
+		Name:        service.Name,
+		Namespace:   service.Namespace,
+		Labels:      service.Labels,
+		Annotations: service.Annotations,
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provider abstracts the component that assigns the egress IP to the
+// placeholder Service and moves it between the nodes.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider is implemented by every VIP backend supported by the operator
+type Provider interface {
+	// Name returns the name used to select the provider
+	Name() string
+	// ConfigureService sets the provider specific fields of the Service used to
+	// request the egress IP of the policy
+	ConfigureService(policy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service)
+	// RequestIP asks the provider to assign exactly the given IP to the Service
+	RequestIP(service *corev1.Service, ip string)
+	// EgressIP returns the IP assigned to the Service, empty until it is assigned
+	EgressIP(ctx context.Context, service *corev1.Service) (string, error)
+	// ExitNode returns the node that currently holds the egress IP, empty until the
+	// IP is announced by a node
+	ExitNode(ctx context.Context, service *corev1.Service) (string, error)
+}
+
+// Poller is implemented by the providers whose exit node is not reflected on the
+// Service, so the Service must be checked again periodically, a zero interval disables
+// the polling
+type Poller interface {
+	PollInterval() time.Duration
+}
+
+// Ensurer is implemented by the providers where the operator chooses the exit node and
+// moves the egress IP to it. Ensure is called only by the reconciliation of the Service
+// and returns the exit node, ExitNode never moves the IP.
+type Ensurer interface {
+	Ensure(ctx context.Context, service *corev1.Service) (string, error)
+}
+
+// Registry holds the configured providers and selects the one used by a policy
+type Registry struct {
+	providers       map[string]Provider
+	defaultProvider string
+}
+
+// NewRegistry returns a registry with the given providers, defaultProvider is used
+// by the policies without the provider annotation
+func NewRegistry(defaultProvider string, providers ...Provider) (*Registry, error) {
+	registry := &Registry{
+		providers:       make(map[string]Provider),
+		defaultProvider: defaultProvider,
+	}
+	for _, provider := range providers {
+		registry.providers[provider.Name()] = provider
+	}
+	if _, ok := registry.providers[defaultProvider]; !ok {
+		return nil, fmt.Errorf("unknown provider %q, valid providers are %v", defaultProvider, registry.Names())
+	}
+	return registry, nil
+}
+
+// ForPolicy returns the provider selected by the policy annotation or the default of its
+// Service namespace, or the default one
+func (r *Registry) ForPolicy(policy *haegressv2.HAEgressGatewayPolicy) (Provider, error) {
+	name := policy.Annotation(haegressip.ProviderAnnotation)
+	if name == "" {
+		name = r.defaultProvider
+	}
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, valid providers are %v", name, r.Names())
+	}
+	return provider, nil
+}
+
+// Default returns the name of the provider of the policies without the annotation
+func (r *Registry) Default() string {
+	return r.defaultProvider
+}
+
+// Names returns the names of the registered providers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsCloud returns true for the providers moving the IP with the API of a cloud, the only
+// ones, with the static provider, where the operator chooses the exit node
+func IsCloud(name string) bool {
+	switch name {
+	case KubeVIPName, CiliumLBIPAMName, MetalLBName, StaticName:
+		return false
+	}
+	return true
+}
+
+// loadBalancerIP returns the first IP assigned by the load balancer to the Service
+func loadBalancerIP(service *corev1.Service) string {
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+	}
+	return ""
+}
+
+// FamilyIPs returns the IPv4 and the IPv6 egress IPs of the Service: the egress IP
+// reported by the provider for its family, the first load balancer ingress IP for the
+// other one, empty when the Service has no IP of the family
+func FamilyIPs(service *corev1.Service, egressIP string) (string, string) {
+	var ipv4, ipv6 string
+	addresses := []string{egressIP}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		addresses = append(addresses, ingress.IP)
+	}
+	for _, address := range addresses {
+		ip, err := netip.ParseAddr(address)
+		switch {
+		case err != nil:
+			continue
+		case ip.Unmap().Is4() && ipv4 == "":
+			ipv4 = address
+		case !ip.Unmap().Is4() && ipv6 == "":
+			ipv6 = address
+		}
+	}
+	return ipv4, ipv6
+}
+
+// loadBalancerClass returns the load balancer class of the load-balancer-class annotation
+// of the policy, or the class of the provider
+func loadBalancerClass(policy *haegressv2.HAEgressGatewayPolicy, class string) string {
+	if value := policy.Annotation(haegressip.LoadBalancerClassAnnotation); value != "" {
+		return value
+	}
+	return class
+}
+
+// configureLoadBalancer turns the Service in a LoadBalancer with the given class, nil
+// class means the default load balancer of the cluster
+func configureLoadBalancer(service *corev1.Service, loadBalancerClass string) {
+	service.Spec.Type = corev1.ServiceTypeLoadBalancer
+	if loadBalancerClass != "" {
+		class := loadBalancerClass
+		service.Spec.LoadBalancerClass = &class
+	}
+}
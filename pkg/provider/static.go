@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// StaticName is the name of the static provider
+const StaticName = "static"
+
+// Static does not rely on any load balancer: the egress IP and the exit node are
+// set by the user with annotations on the HAEgressGatewayPolicy, copied to the
+// Service. It is meant for environments where the IP is moved by external tooling.
+type Static struct{}
+
+func (p *Static) Name() string {
+	return StaticName
+}
+
+func (p *Static) ConfigureService(_ *haegressv2.HAEgressGatewayPolicy, service *corev1.Service) {
+	// A headless Service does not consume a ClusterIP nor an external IP
+	service.Spec.Type = corev1.ServiceTypeClusterIP
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+}
+
+func (p *Static) RequestIP(service *corev1.Service, ip string) {
+	service.Annotations[haegressip.StaticEgressIPAnnotation] = ip
+}
+
+func (p *Static) EgressIP(_ context.Context, service *corev1.Service) (string, error) {
+	return service.Annotations[haegressip.StaticEgressIPAnnotation], nil
+}
+
+func (p *Static) ExitNode(_ context.Context, service *corev1.Service) (string, error) {
+	return service.Annotations[haegressip.StaticExitNodeAnnotation], nil
+}
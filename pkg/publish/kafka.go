@@ -0,0 +1,65 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Kafka sends the events to a Kafka topic through an HTTP bridge implementing the
+// Kafka REST Proxy v2 API, like the Strimzi Kafka Bridge or the Confluent REST Proxy.
+// The record key is the policy name, so the events of a policy keep their order.
+type Kafka struct {
+	URL   string
+	Topic string
+	// Token, if set, is sent as bearer token
+	Token  string
+	Client *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string     `json:"key"`
+	Value CloudEvent `json:"value"`
+}
+
+func (k *Kafka) Name() string {
+	return "kafka"
+}
+
+func (k *Kafka) Send(ctx context.Context, event CloudEvent) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {{Key: event.Subject, Value: event}},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/topics/%s", strings.TrimSuffix(k.URL, "/"), url.PathEscape(k.Topic))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	if k.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	httpClient := k.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("kafka bridge failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKafka(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		fails  bool
+	}{
+		{name: "record accepted", status: http.StatusOK},
+		{name: "unknown topic", status: http.StatusNotFound, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var records map[string][]struct {
+				Key   string     `json:"key"`
+				Value CloudEvent `json:"value"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/topics/haegress-events" ||
+					r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" || r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("unexpected request %s %s %v", r.Method, r.URL.Path, r.Header)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(`{"error_code":40401,"message":"Topic not found"}`))
+			}))
+			defer server.Close()
+
+			transport := &Kafka{URL: server.URL + "/", Topic: "haegress-events", Token: "secret"}
+			err := transport.Send(context.Background(), testCloudEvent("egress-web"))
+			if test.fails {
+				if err == nil || !strings.Contains(err.Error(), "Topic not found") {
+					t.Errorf("the failed request returned %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The policy is the record key, so the events of a policy keep their order
+			if len(records["records"]) != 1 || records["records"][0].Key != "egress-web" || records["records"][0].Value.Subject != "egress-web" {
+				t.Errorf("the bridge received %+v", records)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// natsMinBackoff and natsMaxBackoff bound the delay between two connection attempts
+	natsMinBackoff = time.Second
+	natsMaxBackoff = 30 * time.Second
+)
+
+// NATS sends the events to a NATS subject with the NATS client protocol. Every event is
+// flushed with a PING, so a delivery is reported as failed when the server does not
+// acknowledge it. A lost connection is opened again by the next event, the failed
+// connection attempts are delayed with an exponential backoff.
+type NATS struct {
+	// URL is nats://host:port or tls://host:port, user and password can be set in the URL
+	URL     string
+	Subject string
+	// Token, if set, is used to authenticate instead of the URL credentials
+	Token string
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	// backoff is the delay after the last failed connection attempt, retryAt the time of
+	// the next attempt
+	backoff time.Duration
+	retryAt time.Time
+}
+
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	AuthToken string `json:"auth_token,omitempty"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+}
+
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+func (n *NATS) Send(ctx context.Context, event CloudEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.conn == nil {
+		if err := n.reconnect(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = n.conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\nPING\r\n", n.Subject, len(payload), payload); err != nil {
+		n.close()
+		return err
+	}
+	if err := n.waitPong(); err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+// reconnect opens the connection once the backoff of the last failed attempt expired
+func (n *NATS) reconnect(ctx context.Context) error {
+	if wait := time.Until(n.retryAt); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("NATS server %s unavailable, next connection attempt in %s", n.URL, wait.Round(time.Second))
+		case <-timer.C:
+		}
+	}
+	if err := n.connect(ctx); err != nil {
+		n.backoff = min(max(2*n.backoff, natsMinBackoff), natsMaxBackoff)
+		n.retryAt = time.Now().Add(n.backoff)
+		return err
+	}
+	n.backoff = 0
+	n.retryAt = time.Time{}
+	return nil
+}
+
+// connect opens the connection and authenticates, the server sends its INFO first
+func (n *NATS) connect(ctx context.Context) error {
+	server, err := url.Parse(n.URL)
+	if err != nil {
+		return err
+	}
+	host := server.Host
+	if server.Port() == "" {
+		host = net.JoinHostPort(server.Hostname(), "4222")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(info))
+	}
+	if server.Scheme == "tls" || strings.Contains(info, `"tls_required":true`) {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: server.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connect := natsConnect{
+		Name:      "cilium-haegress-operator",
+		Lang:      "go",
+		Version:   "1.0.0",
+		Protocol:  1,
+		AuthToken: n.Token,
+	}
+	if server.User != nil && n.Token == "" {
+		connect.User = server.User.Username()
+		connect.Pass, _ = server.User.Password()
+	}
+	data, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn = conn
+	n.reader = reader
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		n.close()
+		return err
+	}
+	if err := n.waitPong(); err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+// waitPong reads the server messages until the PONG, answering the server PINGs
+func (n *NATS) waitPong() error {
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := fmt.Fprint(n.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (n *NATS) close() {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.conn = nil
+	n.reader = nil
+}
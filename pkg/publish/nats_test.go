@@ -0,0 +1,242 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+)
+
+// testNATSServer speaks the server side of the NATS client protocol and keeps the
+// published messages
+type testNATSServer struct {
+	// token is the expected auth_token of the CONNECT
+	token string
+	// ping is sent by the server before the PONG of the first PING
+	ping bool
+
+	lock     sync.Mutex
+	accepts  int
+	connects []natsConnect
+	messages []string
+	conns    []net.Conn
+}
+
+func (s *testNATSServer) start(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.accepts++
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func (s *testNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, `INFO {"server_id":"test","version":"2.10.0","max_payload":1048576}`+"\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		operation, arguments, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch operation {
+		case "CONNECT":
+			connect := natsConnect{}
+			if err := json.Unmarshal([]byte(arguments), &connect); err != nil {
+				fmt.Fprint(conn, "-ERR 'Invalid CONNECT'\r\n")
+				return
+			}
+			s.lock.Lock()
+			s.connects = append(s.connects, connect)
+			s.lock.Unlock()
+			if connect.AuthToken != s.token {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PUB":
+			fields := strings.Fields(arguments)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.messages = append(s.messages, fields[0]+" "+string(payload[:size]))
+			s.lock.Unlock()
+		case "PING":
+			if s.ping {
+				fmt.Fprint(conn, "PING\r\n")
+			}
+			fmt.Fprint(conn, "PONG\r\n")
+		}
+	}
+}
+
+// disconnect closes the connections of the clients
+func (s *testNATSServer) disconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func testCloudEvent(policy string) CloudEvent {
+	return NewCloudEvent(Source("cluster-1"), notify.Event{Type: notify.ExitNodeChanged, Policy: policy})
+}
+
+func TestNATS(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		ping  bool
+	}{
+		{name: "anonymous"},
+		{name: "token", token: "secret"},
+		{name: "server ping", ping: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &testNATSServer{token: test.token, ping: test.ping}
+			transport := &NATS{URL: server.start(t), Subject: "haegress.events", Token: test.token}
+			for _, policy := range []string{"egress-web", "egress-db"} {
+				if err := transport.Send(context.Background(), testCloudEvent(policy)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			server.lock.Lock()
+			defer server.lock.Unlock()
+			if server.accepts != 1 || len(server.connects) != 1 {
+				t.Errorf("the client connected %d times, expected once", server.accepts)
+			}
+			if connect := server.connects[0]; connect.AuthToken != test.token || connect.Verbose || connect.Name == "" {
+				t.Errorf("the client connected with %+v", connect)
+			}
+			if len(server.messages) != 2 {
+				t.Fatalf("the server received %q", server.messages)
+			}
+			for i, policy := range []string{"egress-web", "egress-db"} {
+				subject, payload, _ := strings.Cut(server.messages[i], " ")
+				event := CloudEvent{}
+				if err := json.Unmarshal([]byte(payload), &event); err != nil {
+					t.Fatal(err)
+				}
+				if subject != "haegress.events" || event.Subject != policy || event.Type != EventTypePrefix+string(notify.ExitNodeChanged) {
+					t.Errorf("the message %d is %s %+v", i, subject, event)
+				}
+			}
+		})
+	}
+}
+
+func TestNATSAuthorizationViolation(t *testing.T) {
+	server := &testNATSServer{token: "secret"}
+	transport := &NATS{URL: server.start(t), Subject: "haegress.events", Token: "wrong"}
+	err := transport.Send(context.Background(), testCloudEvent("egress-web"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("the wrong token returned %v", err)
+	}
+}
+
+func TestNATSReconnect(t *testing.T) {
+	server := &testNATSServer{}
+	url := server.start(t)
+	transport := &NATS{URL: url, Subject: "haegress.events"}
+	if err := transport.Send(context.Background(), testCloudEvent("egress-web")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The event sent on the lost connection fails, the next one opens a new connection
+	server.disconnect()
+	if err := transport.Send(context.Background(), testCloudEvent("egress-db")); err == nil {
+		t.Error("the event sent on the closed connection did not fail")
+	}
+	if err := transport.Send(context.Background(), testCloudEvent("egress-db")); err != nil {
+		t.Fatal(err)
+	}
+	server.lock.Lock()
+	if server.accepts != 2 || len(server.messages) != 2 {
+		t.Errorf("the client connected %d times and sent %q", server.accepts, server.messages)
+	}
+	server.lock.Unlock()
+}
+
+func TestNATSBackoff(t *testing.T) {
+	// A server closing every connection before its INFO
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var lock sync.Mutex
+	accepts := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			accepts++
+			lock.Unlock()
+			conn.Close()
+		}
+	}()
+
+	transport := &NATS{URL: "nats://" + listener.Addr().String(), Subject: "haegress.events"}
+	if err := transport.Send(context.Background(), testCloudEvent("egress-web")); err == nil {
+		t.Fatal("the event was sent to the failing server")
+	}
+	if transport.backoff != natsMinBackoff {
+		t.Errorf("the backoff after the first failure is %s, expected %s", transport.backoff, natsMinBackoff)
+	}
+	// The next attempt waits for the backoff, the event fails when its context expires first
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := transport.Send(ctx, testCloudEvent("egress-web")); err == nil || !strings.Contains(err.Error(), "next connection attempt") {
+		t.Errorf("the event sent during the backoff returned %v", err)
+	}
+	lock.Lock()
+	if accepts != 1 {
+		t.Errorf("the client connected %d times during the backoff, expected once", accepts)
+	}
+	lock.Unlock()
+
+	// The backoff doubles up to its maximum
+	for i := 0; i < 10; i++ {
+		transport.retryAt = time.Time{}
+		_ = transport.Send(context.Background(), testCloudEvent("egress-web"))
+	}
+	if transport.backoff != natsMaxBackoff {
+		t.Errorf("the backoff is %s, expected %s", transport.backoff, natsMaxBackoff)
+	}
+}
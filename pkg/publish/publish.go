@@ -0,0 +1,158 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package publish emits the egress change events as CloudEvents to a message bus, like
+// a NATS subject or a Kafka topic, for the event-driven automation pipelines.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// EventTypePrefix is prepended to the notify.EventType in the CloudEvent type
+const EventTypePrefix = "ch.angeloxx.cilium.haegress."
+
+// CloudEvent is an event in the CloudEvents 1.0 JSON structured format
+type CloudEvent struct {
+	SpecVersion     string       `json:"specversion"`
+	ID              string       `json:"id"`
+	Source          string       `json:"source"`
+	Type            string       `json:"type"`
+	Subject         string       `json:"subject"`
+	Time            time.Time    `json:"time"`
+	DataContentType string       `json:"datacontenttype"`
+	Data            notify.Event `json:"data"`
+}
+
+// NewCloudEvent wraps the event, the subject is the policy name
+func NewCloudEvent(source string, event notify.Event) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              string(uuid.NewUUID()),
+		Source:          source,
+		Type:            EventTypePrefix + string(event.Type),
+		Subject:         event.Policy,
+		Time:            event.Time.UTC(),
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
+
+// Transport delivers the CloudEvents to a message bus
+type Transport interface {
+	// Name identifies the transport in the logs
+	Name() string
+	Send(ctx context.Context, event CloudEvent) error
+}
+
+// Publisher queues the events received as notify.Sink and sends them, in order, with
+// the Transport. The events are dropped when the queue is full, so a message bus
+// outage never slows down the reconciliation.
+type Publisher struct {
+	Transport Transport
+	Log       logr.Logger
+	// Source is the CloudEvents source, usually identifying the cluster
+	Source string
+	// Retries is the number of additional attempts for a failed delivery
+	Retries int
+	// ReadOnly, when set, receives the events instead of the Transport, like
+	// readonly.Report.Record
+	ReadOnly func(verb, kind, namespace, name string, data []byte)
+
+	queue chan CloudEvent
+}
+
+// NewPublisher returns a Publisher with a queue of the given size
+func NewPublisher(transport Transport, log logr.Logger, source string, queueSize int) *Publisher {
+	return &Publisher{
+		Transport: transport,
+		Log:       log,
+		Source:    source,
+		Retries:   3,
+		queue:     make(chan CloudEvent, queueSize),
+	}
+}
+
+// SetupWithManager registers the publisher as a leader-only runnable of the Manager,
+// the events are generated by the leader.
+func (p *Publisher) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(p)
+}
+
+// Publish implements notify.Sink
+func (p *Publisher) Publish(event notify.Event) {
+	select {
+	case p.queue <- NewCloudEvent(p.Source, event):
+	default:
+		p.Log.Info("Publishing queue is full, dropping the event", "transport", p.Transport.Name(), "event", event.Type, "policy", event.Policy)
+	}
+}
+
+// Start implements manager.Runnable and sends the queued events until the context is
+// cancelled.
+func (p *Publisher) Start(ctx context.Context) error {
+	p.Log.Info("Starting the events publisher", "transport", p.Transport.Name())
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-p.queue:
+			p.send(ctx, event)
+		}
+	}
+}
+
+func (p *Publisher) send(ctx context.Context, event CloudEvent) {
+	if p.ReadOnly != nil {
+		data, _ := json.Marshal(event)
+		p.ReadOnly("Publish", "CloudEvent", p.Transport.Name(), event.Subject, data)
+		return
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := p.Transport.Send(sendCtx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= p.Retries || ctx.Err() != nil {
+			p.Log.Error(err, "unable to publish the event", "transport", p.Transport.Name(), "type", event.Type, "policy", event.Subject)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Source returns the CloudEvents source of the operator running in the cluster
+func Source(cluster string) string {
+	if cluster == "" {
+		cluster = "default"
+	}
+	return fmt.Sprintf("/cilium-haegress-operator/%s", cluster)
+}
@@ -0,0 +1,120 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/go-logr/logr"
+)
+
+// testTransport records the sent events, failing the first failures attempts
+type testTransport struct {
+	failures int
+	sent     []CloudEvent
+	attempts int
+}
+
+func (t *testTransport) Name() string {
+	return "test"
+}
+
+func (t *testTransport) Send(_ context.Context, event CloudEvent) error {
+	t.attempts++
+	if t.attempts <= t.failures {
+		return errors.New("broker unavailable")
+	}
+	t.sent = append(t.sent, event)
+	return nil
+}
+
+func TestNewCloudEvent(t *testing.T) {
+	when := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	event := NewCloudEvent(Source(""), notify.Event{Type: notify.ExitNodeChanged, Policy: "egress-web", Time: when})
+	if event.SpecVersion != "1.0" || event.ID == "" || event.DataContentType != "application/json" {
+		t.Errorf("the CloudEvent has the attributes %+v", event)
+	}
+	if event.Source != "/cilium-haegress-operator/default" || event.Subject != "egress-web" {
+		t.Errorf("the CloudEvent has the source %q and the subject %q", event.Source, event.Subject)
+	}
+	if event.Type != "ch.angeloxx.cilium.haegress.ExitNodeChanged" {
+		t.Errorf("the CloudEvent has the type %q", event.Type)
+	}
+	if !event.Time.Equal(when) || event.Time.Location() != time.UTC {
+		t.Errorf("the CloudEvent has the time %v, expected %v in UTC", event.Time, when)
+	}
+	if other := NewCloudEvent(Source(""), event.Data); other.ID == event.ID {
+		t.Errorf("two CloudEvents have the same ID %q", event.ID)
+	}
+}
+
+func TestPublisherSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		cancel   bool
+		attempts int
+		sent     int
+	}{
+		{name: "delivered", retries: 3, attempts: 1, sent: 1},
+		{name: "delivered after a retry", retries: 1, failures: 1, attempts: 2, sent: 1},
+		{name: "no retries", retries: 0, failures: 1, attempts: 1},
+		{name: "cancelled context", retries: 3, failures: 1, cancel: true, attempts: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+			transport := &testTransport{failures: test.failures}
+			publisher := NewPublisher(transport, logr.Discard(), Source("cluster-1"), 1)
+			publisher.Retries = test.retries
+			publisher.send(ctx, testCloudEvent("egress-web"))
+			if transport.attempts != test.attempts || len(transport.sent) != test.sent {
+				t.Errorf("the event was sent %d times and delivered %d times, expected %d and %d",
+					transport.attempts, len(transport.sent), test.attempts, test.sent)
+			}
+		})
+	}
+}
+
+func TestPublisherReadOnly(t *testing.T) {
+	transport := &testTransport{}
+	publisher := NewPublisher(transport, logr.Discard(), Source("cluster-1"), 1)
+	var reported *CloudEvent
+	publisher.ReadOnly = func(verb, kind, namespace, name string, data []byte) {
+		if verb != "Publish" || kind != "CloudEvent" || namespace != "test" || name != "egress-web" {
+			t.Errorf("the report received %s %s %s/%s", verb, kind, namespace, name)
+		}
+		reported = &CloudEvent{}
+		if err := json.Unmarshal(data, reported); err != nil {
+			t.Error(err)
+		}
+	}
+	publisher.send(context.Background(), testCloudEvent("egress-web"))
+	if transport.attempts != 0 {
+		t.Errorf("the event was sent in read-only mode")
+	}
+	if reported == nil || reported.Data.Policy != "egress-web" {
+		t.Errorf("the report received %v", reported)
+	}
+}
+
+func TestPublisherQueueFull(t *testing.T) {
+	publisher := NewPublisher(&testTransport{}, logr.Discard(), Source("cluster-1"), 1)
+	publisher.Publish(notify.Event{Type: notify.ExitNodeChanged, Policy: "egress-web"})
+	// The queue is full, the event is dropped without blocking
+	publisher.Publish(notify.Event{Type: notify.ExitNodeChanged, Policy: "egress-db"})
+	if len(publisher.queue) != 1 {
+		t.Fatalf("the queue has %d events, expected 1", len(publisher.queue))
+	}
+	if event := <-publisher.queue; event.Subject != "egress-web" || event.Source != "/cilium-haegress-operator/cluster-1" {
+		t.Errorf("the queued event is %+v", event)
+	}
+}
@@ -0,0 +1,165 @@
+package readonly
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Verbs of the changes, the writes of a subresource are reported as <verb>/<subresource>
+const (
+	verbCreate     = "Create"
+	verbUpdate     = "Update"
+	verbPatch      = "Patch"
+	verbDelete     = "Delete"
+	verbDeleteAll  = "DeleteAllOf"
+	verbAttachIP   = "AttachIP"
+	verbAllocateIP = "AllocateIP"
+	verbReleaseIP  = "ReleaseIP"
+)
+
+// NewClient returns the NewClient function of the Manager creating a client that reads
+// from the API server and records the writes in the report
+func (r *Report) NewClient(config *rest.Config, options client.Options) (client.Client, error) {
+	c, err := client.New(config, options)
+	if err != nil {
+		return nil, err
+	}
+	return r.Client(c), nil
+}
+
+// Client wraps the client, the writes are recorded in the report instead of being sent
+func (r *Report) Client(c client.Client) client.Client {
+	return &readOnlyClient{Client: c, report: r}
+}
+
+type readOnlyClient struct {
+	client.Client
+	report *Report
+}
+
+func (c *readOnlyClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	c.record(verbCreate, obj, marshal(obj))
+	return nil
+}
+
+func (c *readOnlyClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.record(verbUpdate, obj, marshal(obj))
+	return nil
+}
+
+func (c *readOnlyClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	data, _ := patch.Data(obj)
+	c.record(verbPatch, obj, data)
+	return nil
+}
+
+func (c *readOnlyClient) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.record(verbDelete, obj, nil)
+	return nil
+}
+
+func (c *readOnlyClient) DeleteAllOf(_ context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	c.record(verbDeleteAll, obj, nil)
+	return nil
+}
+
+func (c *readOnlyClient) Status() client.SubResourceWriter {
+	return &readOnlyWriter{client: c, subResource: "status"}
+}
+
+func (c *readOnlyClient) SubResource(subResource string) client.SubResourceClient {
+	return &readOnlySubResourceClient{
+		SubResourceReader: c.Client.SubResource(subResource),
+		readOnlyWriter:    readOnlyWriter{client: c, subResource: subResource},
+	}
+}
+
+// record adds the write of the object to the report, with the kind from the scheme
+func (c *readOnlyClient) record(verb string, obj client.Object, data []byte) {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	c.report.Record(verb, kind, obj.GetNamespace(), obj.GetName(), data)
+}
+
+// readOnlyWriter records the writes of a subresource
+type readOnlyWriter struct {
+	client      *readOnlyClient
+	subResource string
+}
+
+func (w *readOnlyWriter) Create(_ context.Context, obj client.Object, subResource client.Object, _ ...client.SubResourceCreateOption) error {
+	w.client.record(verbCreate+"/"+w.subResource, obj, marshal(subResource))
+	return nil
+}
+
+func (w *readOnlyWriter) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	w.client.record(verbUpdate+"/"+w.subResource, obj, marshal(obj))
+	return nil
+}
+
+func (w *readOnlyWriter) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.SubResourcePatchOption) error {
+	data, _ := patch.Data(obj)
+	w.client.record(verbPatch+"/"+w.subResource, obj, data)
+	return nil
+}
+
+type readOnlySubResourceClient struct {
+	client.SubResourceReader
+	readOnlyWriter
+}
+
+// Mover wraps the mover of a cloud provider, the IPs are not moved
+func (r *Report) Mover(mover provider.IPMover) provider.IPMover {
+	return &readOnlyMover{IPMover: mover, report: r}
+}
+
+type readOnlyMover struct {
+	provider.IPMover
+	report *Report
+}
+
+func (m *readOnlyMover) Attach(_ context.Context, service *corev1.Service, ip string, node *corev1.Node) error {
+	data, _ := json.Marshal(map[string]string{"ip": ip, "node": node.Name})
+	m.report.Record(verbAttachIP, m.Name(), service.Namespace, service.Name, data)
+	return nil
+}
+
+// Allocator wraps an external IPAM: an IP can't be allocated without changing the IPAM,
+// so the allocations fail with ErrReadOnly, and the IPs are not released
+func (r *Report) Allocator(allocator ipam.Allocator) ipam.Allocator {
+	return &readOnlyAllocator{Allocator: allocator, report: r}
+}
+
+type readOnlyAllocator struct {
+	ipam.Allocator
+	report *Report
+}
+
+func (a *readOnlyAllocator) Allocate(_ context.Context, request ipam.Request) (string, error) {
+	data, _ := json.Marshal(request)
+	a.report.Record(verbAllocateIP, a.Name(), request.Namespace, request.Policy, data)
+	return "", ErrReadOnly
+}
+
+func (a *readOnlyAllocator) Release(_ context.Context, request ipam.Request, ip string) error {
+	data, _ := json.Marshal(map[string]string{"ip": ip})
+	a.report.Record(verbReleaseIP, a.Name(), request.Namespace, request.Policy, data)
+	return nil
+}
+
+// marshal returns the JSON of the object without the managed fields
+func marshal(obj client.Object) []byte {
+	copied := obj.DeepCopyObject().(client.Object)
+	copied.SetManagedFields(nil)
+	data, _ := json.Marshal(copied)
+	return data
+}
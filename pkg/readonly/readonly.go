@@ -0,0 +1,163 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package readonly runs the operator with read-only permissions: the writes of the
+// controllers, and the calls changing the clouds and the IPAMs, are not sent but recorded
+// in a report of what the operator would change, exported as metrics, logs and JSON.
+package readonly
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Path is the path the report is served on
+const Path = "/readonlyz"
+
+// maxPatchSize limits the content of a patch kept in the report
+const maxPatchSize = 1024
+
+// ErrReadOnly is returned by the calls that can't be simulated, e.g. the allocation of an
+// IP from an external IPAM
+var ErrReadOnly = errors.New("not allowed in read-only mode")
+
+var (
+	suppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_readonly_suppressed_writes_total",
+			Help: "Number of writes not sent in read-only mode, by verb and kind",
+		},
+		[]string{"verb", "kind"},
+	)
+
+	pending = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_readonly_pending_objects",
+			Help: "Number of objects the operator would change in read-only mode, by kind",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(suppressed, pending)
+}
+
+// Change is a write the operator would make, the repeated ones are counted once
+type Change struct {
+	Verb      string `json:"verb"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Patch is the patch, or the object, that would be written, truncated
+	Patch     string    `json:"patch,omitempty"`
+	Count     int       `json:"count"`
+	FirstTime time.Time `json:"firstTime"`
+	LastTime  time.Time `json:"lastTime"`
+}
+
+// Report is the set of the changes the operator would make, served as JSON. A change
+// attempted at every reconciliation is reported once, with the time of the last attempt.
+type Report struct {
+	Log logr.Logger
+
+	mu      sync.Mutex
+	changes map[string]*Change
+}
+
+// Record adds a change to the report
+func (r *Report) Record(verb, kind, namespace, name string, patch []byte) {
+	if len(patch) > maxPatchSize {
+		patch = append(patch[:maxPatchSize:maxPatchSize], []byte("...")...)
+	}
+	now := time.Now()
+	key := verb + "/" + kind + "/" + namespace + "/" + name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.changes == nil {
+		r.changes = make(map[string]*Change)
+	}
+	change, ok := r.changes[key]
+	if !ok {
+		change = &Change{Verb: verb, Kind: kind, Namespace: namespace, Name: name, FirstTime: now}
+		r.changes[key] = change
+	}
+	change.Count++
+	change.LastTime = now
+	change.Patch = string(patch)
+
+	suppressed.WithLabelValues(verb, kind).Inc()
+	pending.Reset()
+	for kind, count := range r.countsLocked() {
+		pending.WithLabelValues(kind).Set(float64(count))
+	}
+	// The changes attempted again at every reconciliation are logged once
+	log := r.Log.V(1)
+	if !ok {
+		log = r.Log
+	}
+	log.Info("Read-only mode, change not applied", "verb", verb, "kind", kind, "namespace", namespace, "name", name,
+		"patch", string(patch))
+}
+
+// Changes returns the changes of the report, the most recent first
+func (r *Report) Changes() []Change {
+	r.mu.Lock()
+	changes := make([]Change, 0, len(r.changes))
+	for _, change := range r.changes {
+		changes = append(changes, *change)
+	}
+	r.mu.Unlock()
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].LastTime.After(changes[j].LastTime)
+	})
+	return changes
+}
+
+// ServeHTTP serves the changes of the report as JSON
+func (r *Report) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r.Changes()); err != nil {
+		r.Log.Error(err, "unable to write the read-only report")
+	}
+}
+
+// countsLocked returns the number of distinct objects with a change, by kind
+func (r *Report) countsLocked() map[string]int {
+	objects := map[string]map[string]bool{}
+	for _, change := range r.changes {
+		if objects[change.Kind] == nil {
+			objects[change.Kind] = map[string]bool{}
+		}
+		objects[change.Kind][change.Namespace+"/"+change.Name] = true
+	}
+	counts := make(map[string]int, len(objects))
+	for kind, names := range objects {
+		counts[kind] = len(names)
+	}
+	return counts
+}
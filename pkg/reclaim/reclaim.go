@@ -0,0 +1,313 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reclaim holds the egress IPs allocated from an IPAM to the deleted policies for a
+// grace period before they are released, so an IP allowed by the external firewalls for a
+// tenant is not handed to another tenant as soon as the policy is deleted. The held IPs are
+// kept in a ConfigMap, one key per policy, and released by the Releaser once their grace
+// period ends, or earlier with haegressctl reclaim. A policy recreated with the same name
+// meanwhile gets its IP back from the IPAM.
+package reclaim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultConfigMapName is the default name of the ConfigMap of the held egress IPs
+const DefaultConfigMapName = "haegress-reclaimable-ips"
+
+// checkInterval is the time between two scans of the held egress IPs
+const checkInterval = 30 * time.Second
+
+// annotationsPrefix selects the annotations of the policy kept with the held IP, the ones
+// read by the allocators on release
+const annotationsPrefix = "cilium.angeloxx.ch/"
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;patch
+
+var (
+	reclaimable = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_reclaimable_ips",
+			Help: "Egress IPs of deleted policies held before they are released to their IPAM, at the last scan",
+		},
+	)
+
+	reclaimed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "haegress_reclaimable_ips_total",
+			Help: "Held egress IPs by outcome: released to their IPAM, reclaimed by a policy recreated with the same name, or dropped when their IPAM is not configured anymore",
+		},
+		[]string{"outcome"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(reclaimable, reclaimed)
+}
+
+// Entry is an egress IP held for a deleted policy
+type Entry struct {
+	Policy string `json:"policy"`
+	IP     string `json:"ip"`
+	// IPAM is the name of the allocator the IP is released to
+	IPAM      string      `json:"ipam"`
+	HeldAt    metav1.Time `json:"heldAt"`
+	ReleaseAt metav1.Time `json:"releaseAt"`
+	// Request is the request of the release, its Annotations are kept apart
+	Request     ipam.Request      `json:"request"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Store keeps the held egress IPs in a ConfigMap, one key per policy with the JSON entry as
+// value
+type Store struct {
+	client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader        client.Reader
+	Namespace     string
+	ConfigMapName string
+	// GracePeriod is the time the egress IPs are held
+	GracePeriod time.Duration
+}
+
+// Hold keeps the egress IP allocated by the IPAM to the deleted policy for the grace period
+func (s *Store) Hold(ctx context.Context, allocator string, request ipam.Request, ip string) (Entry, error) {
+	now := time.Now().UTC()
+	entry := Entry{
+		Policy:    request.Policy,
+		IP:        ip,
+		IPAM:      allocator,
+		HeldAt:    metav1.NewTime(now),
+		ReleaseAt: metav1.NewTime(now.Add(s.GracePeriod)),
+		Request:   request,
+	}
+	for key, value := range request.Annotations {
+		if strings.HasPrefix(key, annotationsPrefix) {
+			if entry.Annotations == nil {
+				entry.Annotations = map[string]string{}
+			}
+			entry.Annotations[key] = value
+		}
+	}
+	return entry, s.save(ctx, entry)
+}
+
+// List returns the held egress IPs, in policy order
+func (s *Store) List(ctx context.Context) ([]Entry, error) {
+	configMap := &corev1.ConfigMap{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Name: s.ConfigMapName, Namespace: s.Namespace}, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(configMap.Data))
+	for key, value := range configMap.Data {
+		var entry Entry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("invalid entry %s in ConfigMap %s: %w", key, s.ConfigMapName, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Policy < entries[j].Policy
+	})
+	return entries, nil
+}
+
+// Get returns the egress IP held for the policy, false when none is held
+func (s *Store) Get(ctx context.Context, policy string) (Entry, bool, error) {
+	entries, err := s.List(ctx)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.Policy == policy {
+			return entry, true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// ReleaseNow ends the grace period of the egress IP held for the policy, it is released
+// at the next scan of the operator
+func (s *Store) ReleaseNow(ctx context.Context, policy string) (Entry, error) {
+	entry, ok, err := s.Get(ctx, policy)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !ok {
+		return Entry{}, fmt.Errorf("no egress IP is held for the policy %s", policy)
+	}
+	entry.ReleaseAt = metav1.NewTime(time.Now().UTC())
+	return entry, s.save(ctx, entry)
+}
+
+// Forget removes the egress IP held for the policy
+func (s *Store) Forget(ctx context.Context, policy string) error {
+	err := s.patch(ctx, map[string]interface{}{policy: nil})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *Store) save(ctx context.Context, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = s.patch(ctx, map[string]interface{}{entry.Policy: string(data)})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: s.ConfigMapName, Namespace: s.Namespace},
+		Data:       map[string]string{entry.Policy: string(data)},
+	}
+	return s.Create(ctx, configMap)
+}
+
+// patch merges the data in the ConfigMap
+func (s *Store) patch(ctx context.Context, data map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.ConfigMapName, Namespace: s.Namespace}}
+	return s.Patch(ctx, configMap, client.RawPatch(types.MergePatchType, patch))
+}
+
+// Releaser releases the held egress IPs whose grace period ended, and forgets the ones of
+// the policies recreated with the same name, that got them back from the IPAM
+type Releaser struct {
+	client.Client
+	Log        logr.Logger
+	Store      *Store
+	Allocators *ipam.Registry
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+}
+
+// SetupWithManager registers the releaser as a runnable of the Manager.
+func (r *Releaser) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(r)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica releases the IPs
+// of its own policies
+func (r *Releaser) NeedLeaderElection() bool {
+	return r.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (r *Releaser) Start(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.releaseAll(ctx); err != nil {
+				r.Log.Error(err, "unable to release the held egress IPs")
+			}
+		}
+	}
+}
+
+// releaseAll releases the held egress IPs due, and exports the ones still held
+func (r *Releaser) releaseAll(ctx context.Context) error {
+	entries, err := r.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	held := 0
+	for _, entry := range entries {
+		if !r.Sharder.Owns(entry.Policy, entry.Request.Labels) {
+			continue
+		}
+		done, err := r.release(ctx, entry)
+		if err != nil {
+			r.Log.Error(err, "unable to release the held egress IP", "policy", entry.Policy, "IP", entry.IP, "IPAM", entry.IPAM)
+		}
+		if !done {
+			held++
+		}
+	}
+	reclaimable.Set(float64(held))
+	return nil
+}
+
+// release releases the egress IP when its grace period ended, and returns true when it is
+// not held anymore
+func (r *Releaser) release(ctx context.Context, entry Entry) (bool, error) {
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	err := r.Get(ctx, client.ObjectKey{Name: entry.Policy}, policy)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil && policy.DeletionTimestamp.IsZero() && policy.Status.IPAddress == entry.IP {
+		// The IPAM returned the same IP to the same policy, that owns it again
+		if err := r.Store.Forget(ctx, entry.Policy); err != nil {
+			return false, err
+		}
+		r.Log.Info("Held egress IP reclaimed by the recreated policy", "policy", entry.Policy, "IP", entry.IP)
+		reclaimed.WithLabelValues("reclaimed").Inc()
+		return true, nil
+	}
+	if time.Now().Before(entry.ReleaseAt.Time) {
+		return false, nil
+	}
+
+	allocator, err := r.Allocators.Get(entry.IPAM)
+	if err != nil {
+		// The IPAM is not configured anymore, the IP would be held forever
+		r.Log.Error(err, "unable to release the held egress IP, release it manually", "policy", entry.Policy, "IP", entry.IP, "IPAM", entry.IPAM)
+		reclaimed.WithLabelValues("dropped").Inc()
+		return true, r.Store.Forget(ctx, entry.Policy)
+	}
+	request := entry.Request
+	request.Annotations = entry.Annotations
+	if err := allocator.Release(ctx, request, entry.IP); err != nil {
+		return false, err
+	}
+	if err := r.Store.Forget(ctx, entry.Policy); err != nil {
+		return false, err
+	}
+	r.Log.Info("Released the held egress IP to the external IPAM", "policy", entry.Policy, "IP", entry.IP, "IPAM", entry.IPAM)
+	reclaimed.WithLabelValues("released").Inc()
+	return true, nil
+}
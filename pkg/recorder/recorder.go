@@ -0,0 +1,183 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recorder limits the Kubernetes events emitted by the controllers. During a
+// mass failover, when a node dying moves hundreds of egress IPs, the events beyond the
+// burst are aggregated in a single summary event for every reason.
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// maxSummaryObjects is the number of objects named in a summary event
+const maxSummaryObjects = 10
+
+// Aggregator is an EventRecorder that emits at most Burst events of the same type and
+// reason in every window, the others are summarized at the end of the window in one
+// event on the last involved object. A zero Window disables the aggregation.
+type Aggregator struct {
+	Recorder record.EventRecorder
+	Log      logr.Logger
+	Burst    int
+	Window   time.Duration
+
+	lock    sync.Mutex
+	emitted map[eventKey]int
+	pending map[eventKey]*summary
+}
+
+type eventKey struct {
+	eventType string
+	reason    string
+}
+
+type summary struct {
+	last    runtime.Object
+	message string
+	objects []string
+	count   int
+}
+
+// SetupWithManager registers the aggregator as a runnable of the Manager, that flushes
+// the summaries. The events are emitted by the leader only.
+func (a *Aggregator) SetupWithManager(mgr ctrl.Manager) error {
+	if a.Window <= 0 {
+		return nil
+	}
+	return mgr.Add(a)
+}
+
+// Event implements record.EventRecorder
+func (a *Aggregator) Event(object runtime.Object, eventtype, reason, message string) {
+	if a.allow(object, eventtype, reason, message) {
+		a.Recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf implements record.EventRecorder
+func (a *Aggregator) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	a.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf implements record.EventRecorder, the annotations of the summarized
+// events are lost
+func (a *Aggregator) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if a.allow(object, eventtype, reason, message) {
+		a.Recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+	}
+}
+
+// allow counts the event and returns true when it is within the burst
+func (a *Aggregator) allow(object runtime.Object, eventtype, reason, message string) bool {
+	if a.Window <= 0 {
+		return true
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.emitted == nil {
+		a.emitted = make(map[eventKey]int)
+		a.pending = make(map[eventKey]*summary)
+	}
+
+	key := eventKey{eventType: eventtype, reason: reason}
+	if a.emitted[key] < a.Burst {
+		a.emitted[key]++
+		return true
+	}
+	pending, ok := a.pending[key]
+	if !ok {
+		pending = &summary{}
+		a.pending[key] = pending
+	}
+	pending.last = object
+	pending.message = message
+	pending.count++
+	if len(pending.objects) < maxSummaryObjects {
+		pending.objects = append(pending.objects, describe(object))
+	}
+	return false
+}
+
+// Start implements manager.Runnable and emits the summaries at the end of every window
+func (a *Aggregator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(a.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+func (a *Aggregator) flush() {
+	a.lock.Lock()
+	pending := a.pending
+	a.emitted = make(map[eventKey]int)
+	a.pending = make(map[eventKey]*summary)
+	a.lock.Unlock()
+
+	keys := make([]eventKey, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].reason < keys[j].reason
+	})
+	for _, key := range keys {
+		summary := pending[key]
+		objects := strings.Join(summary.objects, ", ")
+		if summary.count > len(summary.objects) {
+			objects = fmt.Sprintf("%s and %d more", objects, summary.count-len(summary.objects))
+		}
+		a.Log.Info("Summarized the events beyond the burst", "reason", key.reason, "count", summary.count)
+		a.Recorder.Event(summary.last, key.eventType, key.reason,
+			fmt.Sprintf("%d more %s events in the last %s on %s, last one: %s",
+				summary.count, key.reason, a.Window, objects, summary.message))
+	}
+}
+
+// describe returns kind/namespace/name of the object
+func describe(object runtime.Object) string {
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	if kind == "" {
+		kind = reflect.Indirect(reflect.ValueOf(object)).Type().Name()
+	}
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return kind
+	}
+	if accessor.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", kind, accessor.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", kind, accessor.GetNamespace(), accessor.GetName())
+}
@@ -0,0 +1,192 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/routes/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// GNMI programs the OpenConfig static routes with gNMI Set, the values are encoded as
+// JSON_IETF
+type GNMI struct {
+	Router *Router
+
+	lock   sync.Mutex
+	client gnmi.GNMIClient
+}
+
+func (g *GNMI) Routes(ctx context.Context) (map[string]string, error) {
+	client, err := g.dial()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Get(g.outgoing(ctx), &gnmi.GetRequest{
+		Path:     []*gnmi.Path{g.staticRoutesPath()},
+		Type:     gnmi.GetRequest_CONFIG,
+		Encoding: gnmi.Encoding_JSON_IETF,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gNMI Get on %s failed: %w", g.Router.Address, err)
+	}
+	routes := map[string]string{}
+	for _, notification := range response.Notification {
+		for _, update := range notification.Update {
+			value := update.GetVal().GetJsonIetfVal()
+			if value == nil {
+				value = update.GetVal().GetJsonVal()
+			}
+			if value == nil {
+				continue
+			}
+			var decoded any
+			if err := json.Unmarshal(value, &decoded); err != nil {
+				return nil, fmt.Errorf("invalid static routes from %s: %w", g.Router.Address, err)
+			}
+			for prefix, nextHop := range ownedStaticRoutes(decoded) {
+				routes[prefix] = nextHop
+			}
+		}
+	}
+	return routes, nil
+}
+
+func (g *GNMI) Replace(ctx context.Context, route Route) error {
+	value, err := json.Marshal(staticRouteJSON(route))
+	if err != nil {
+		return err
+	}
+	return g.set(ctx, &gnmi.SetRequest{
+		Replace: []*gnmi.Update{{
+			Path: g.staticRoutePath(route.Prefix),
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: value}},
+		}},
+	})
+}
+
+func (g *GNMI) Delete(ctx context.Context, prefix string) error {
+	return g.set(ctx, &gnmi.SetRequest{Delete: []*gnmi.Path{g.staticRoutePath(prefix)}})
+}
+
+// staticRoutesPath returns the path of the static routes of the STATIC protocol
+func (g *GNMI) staticRoutesPath() *gnmi.Path {
+	return &gnmi.Path{Elem: []*gnmi.PathElem{
+		{Name: "network-instances"},
+		{Name: "network-instance", Key: map[string]string{"name": g.Router.NetworkInstance}},
+		{Name: "protocols"},
+		{Name: "protocol", Key: map[string]string{"identifier": "STATIC", "name": g.Router.StaticProtocol}},
+		{Name: "static-routes"},
+	}}
+}
+
+// staticRoutePath returns the path of the static route of the prefix
+func (g *GNMI) staticRoutePath(prefix string) *gnmi.Path {
+	path := g.staticRoutesPath()
+	path.Elem = append(path.Elem, &gnmi.PathElem{Name: "static", Key: map[string]string{"prefix": prefix}})
+	return path
+}
+
+func (g *GNMI) set(ctx context.Context, request *gnmi.SetRequest) error {
+	client, err := g.dial()
+	if err != nil {
+		return err
+	}
+	if _, err := client.Set(g.outgoing(ctx), request); err != nil {
+		return fmt.Errorf("gNMI Set on %s failed: %w", g.Router.Address, err)
+	}
+	return nil
+}
+
+// outgoing adds the credentials of the router to the metadata of the call
+func (g *GNMI) outgoing(ctx context.Context) context.Context {
+	if g.Router.Username == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "username", g.Router.Username, "password", g.Router.password)
+}
+
+func (g *GNMI) dial() (gnmi.GNMIClient, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.client != nil {
+		return g.client, nil
+	}
+	tlsConfig, err := g.Router.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	address := g.Router.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "57400")
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the router %s: %w", address, err)
+	}
+	g.client = gnmi.NewGNMIClient(conn)
+	return g.client, nil
+}
+
+// ownedStaticRoutes returns the next hop of the static routes with the next hop of the
+// operator, by prefix, from the JSON of the static routes or of a single static route.
+// The module names of the JSON_IETF members are ignored.
+func ownedStaticRoutes(value any) map[string]string {
+	routes := map[string]string{}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return routes
+	}
+	object = unqualified(object)
+	if staticRoutes, ok := object["static-routes"].(map[string]any); ok {
+		object = unqualified(staticRoutes)
+	}
+	statics, ok := object["static"].([]any)
+	if !ok {
+		if _, ok := object["prefix"]; !ok {
+			return routes
+		}
+		statics = []any{object}
+	}
+	for _, item := range statics {
+		static, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		static = unqualified(static)
+		prefix, _ := static["prefix"].(string)
+		nextHops, _ := static["next-hops"].(map[string]any)
+		entries, _ := unqualified(nextHops)["next-hop"].([]any)
+		for _, entry := range entries {
+			nextHop, ok := entry.(map[string]any)
+			if !ok {
+				continue
+			}
+			nextHop = unqualified(nextHop)
+			config, _ := nextHop["config"].(map[string]any)
+			config = unqualified(config)
+			if index, _ := nextHop["index"].(string); prefix != "" && index == NextHopIndex {
+				routes[prefix], _ = config["next-hop"].(string)
+			}
+		}
+	}
+	return routes
+}
+
+// unqualified returns the members of the JSON_IETF object without their module name
+func unqualified(object map[string]any) map[string]any {
+	members := make(map[string]any, len(object))
+	for name, value := range object {
+		if index := strings.Index(name, ":"); index >= 0 {
+			name = name[index+1:]
+		}
+		members[name] = value
+	}
+	return members
+}
@@ -0,0 +1,99 @@
+// Package gnmi holds the gNMI messages and the Get and Set RPCs of the gNMI service,
+// generated from gnmi.proto, a subset of the OpenConfig gnmi.proto with its package and
+// field numbers.
+package gnmi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative gnmi.proto
+
+const (
+	getMethod = "/gnmi.gNMI/Get"
+	setMethod = "/gnmi.gNMI/Set"
+)
+
+// GNMIClient calls the Get and Set RPCs of a gNMI target
+type GNMIClient interface {
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+}
+
+type gNMIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewGNMIClient returns the gNMI client of the connection
+func NewGNMIClient(cc grpc.ClientConnInterface) GNMIClient {
+	return &gNMIClient{cc: cc}
+}
+
+func (c *gNMIClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := &GetResponse{}
+	if err := c.cc.Invoke(ctx, getMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gNMIClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	out := &SetResponse{}
+	if err := c.cc.Invoke(ctx, setMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GNMIServer is a gNMI target serving Get and Set, like the fake routers of the tests
+type GNMIServer interface {
+	Get(ctx context.Context, in *GetRequest) (*GetResponse, error)
+	Set(ctx context.Context, in *SetRequest) (*SetResponse, error)
+}
+
+// RegisterGNMIServer registers the gNMI service of srv on the gRPC server
+func RegisterGNMIServer(s grpc.ServiceRegistrar, srv GNMIServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "gnmi.gNMI",
+	HandlerType: (*GNMIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := &GetRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(GNMIServer).Get(ctx, req.(*GetRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: getMethod}, handler)
+			},
+		},
+		{
+			MethodName: "Set",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := &SetRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(GNMIServer).Set(ctx, req.(*SetRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: setMethod}, handler)
+			},
+		},
+	},
+	Metadata: "gnmi.proto",
+}
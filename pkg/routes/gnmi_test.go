@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/routes/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testGNMIServer is a gNMI target keeping the OpenConfig static routes in memory
+type testGNMIServer struct {
+	lock sync.Mutex
+	// statics are the JSON_IETF static routes, by prefix
+	statics  map[string]any
+	requests []*gnmi.SetRequest
+}
+
+func (s *testGNMIServer) authorized(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("username")) != 1 || md.Get("username")[0] != "haegress" || md.Get("password")[0] != "secret" {
+		return status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	return nil
+}
+
+func (s *testGNMIServer) Get(ctx context.Context, in *gnmi.GetRequest) (*gnmi.GetResponse, error) {
+	if err := s.authorized(ctx); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	statics := []any{}
+	for _, static := range s.statics {
+		statics = append(statics, static)
+	}
+	value, err := json.Marshal(map[string]any{"openconfig-network-instance:static": statics})
+	if err != nil {
+		return nil, err
+	}
+	return &gnmi.GetResponse{Notification: []*gnmi.Notification{{Update: []*gnmi.Update{{
+		Path: in.Path[0],
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: value}},
+	}}}}}, nil
+}
+
+func (s *testGNMIServer) Set(ctx context.Context, in *gnmi.SetRequest) (*gnmi.SetResponse, error) {
+	if err := s.authorized(ctx); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, proto.Clone(in).(*gnmi.SetRequest))
+	for _, path := range in.Delete {
+		delete(s.statics, path.Elem[len(path.Elem)-1].Key["prefix"])
+	}
+	for _, update := range in.Replace {
+		static := map[string]any{}
+		if err := json.Unmarshal(update.Val.GetJsonIetfVal(), &static); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s.statics[update.Path.Elem[len(update.Path.Elem)-1].Key["prefix"]] = static
+	}
+	return &gnmi.SetResponse{}, nil
+}
+
+func startTestGNMIServer(t *testing.T, server *testGNMIServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})))
+	gnmi.RegisterGNMIServer(grpcServer, server)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+func TestGNMI(t *testing.T) {
+	ctx := context.Background()
+	server := &testGNMIServer{statics: map[string]any{
+		// A static route configured by the network team
+		"0.0.0.0/0": map[string]any{
+			"prefix": "0.0.0.0/0",
+			"next-hops": map[string]any{"next-hop": []any{map[string]any{
+				"index": "uplink", "config": map[string]any{"index": "uplink", "next-hop": "192.168.0.254"},
+			}}},
+		},
+	}}
+	programmer := &GNMI{Router: testRouter(ProtocolGNMI, startTestGNMIServer(t, server))}
+
+	if err := programmer.Replace(ctx, Route{Prefix: "10.0.0.7/32", NextHop: "192.168.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	request := server.requests[0]
+	if len(request.Replace) != 1 || len(request.Delete) != 0 || request.Replace[0].Val.GetJsonIetfVal() == nil {
+		t.Fatalf("the route was set with %v", request)
+	}
+	elems := []string{}
+	for _, elem := range request.Replace[0].Path.Elem {
+		elems = append(elems, elem.Name)
+	}
+	if expected := []string{"network-instances", "network-instance", "protocols", "protocol", "static-routes", "static"}; !reflect.DeepEqual(elems, expected) {
+		t.Errorf("the route was set on the path %v", elems)
+	}
+	if key := request.Replace[0].Path.Elem[3].Key; key["identifier"] != "STATIC" || key["name"] != "STATIC" {
+		t.Errorf("the route was set on the protocol %v", key)
+	}
+
+	// Only the routes with the next hop of the operator are read
+	routes, err := programmer.Routes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"10.0.0.7/32": "192.168.0.1"}; !reflect.DeepEqual(routes, expected) {
+		t.Errorf("the routes of the operator are %v, expected %v", routes, expected)
+	}
+
+	if err := programmer.Delete(ctx, "10.0.0.7/32"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.statics["10.0.0.7/32"]; ok || len(server.statics) != 1 {
+		t.Errorf("the router has the routes %v after the deletion", server.statics)
+	}
+
+	// The credentials are sent in the metadata of the calls
+	programmer.Router.password = "wrong"
+	if err := programmer.Delete(ctx, "10.0.0.7/32"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("the call with the wrong password returned %v", err)
+	}
+}
+
+func TestOwnedStaticRoutes(t *testing.T) {
+	nextHops := func(index, nextHop string) map[string]any {
+		return map[string]any{"next-hop": []any{map[string]any{
+			"index": index, "config": map[string]any{"index": index, "next-hop": nextHop},
+		}}}
+	}
+	tests := []struct {
+		name     string
+		value    any
+		expected map[string]string
+	}{
+		{
+			name: "static routes",
+			value: map[string]any{"static": []any{
+				map[string]any{"prefix": "10.0.0.7/32", "next-hops": nextHops(NextHopIndex, "192.168.0.1")},
+				map[string]any{"prefix": "0.0.0.0/0", "next-hops": nextHops("uplink", "192.168.0.254")},
+			}},
+			expected: map[string]string{"10.0.0.7/32": "192.168.0.1"},
+		},
+		{
+			name: "module names",
+			value: map[string]any{"openconfig-network-instance:static-routes": map[string]any{
+				"openconfig-network-instance:static": []any{map[string]any{
+					"prefix":                                "2001:db8::7/128",
+					"openconfig-network-instance:next-hops": nextHops(NextHopIndex, "2001:db8::1"),
+				}},
+			}},
+			expected: map[string]string{"2001:db8::7/128": "2001:db8::1"},
+		},
+		{
+			name:     "single static route",
+			value:    map[string]any{"prefix": "10.0.0.7/32", "next-hops": nextHops(NextHopIndex, "192.168.0.1")},
+			expected: map[string]string{"10.0.0.7/32": "192.168.0.1"},
+		},
+		{
+			name:     "no static routes",
+			value:    map[string]any{},
+			expected: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The values are decoded from JSON as the ones of the routers
+			data, err := json.Marshal(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value any
+			if err := json.Unmarshal(data, &value); err != nil {
+				t.Fatal(err)
+			}
+			if routes := ownedStaticRoutes(value); !reflect.DeepEqual(routes, test.expected) {
+				t.Errorf("the routes are %v, expected %v", routes, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// netconfEOM is the end of message marker of the base:1.0 framing, used by the hellos
+	netconfEOM = "]]>]]>"
+	// netconfBase11 is the capability of the chunked framing, required by RFC 7589
+	netconfBase11 = "urn:ietf:params:netconf:base:1.1"
+	netconfNS     = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	// maxNETCONFMessage limits the size of a message read from the router
+	maxNETCONFMessage = 64 << 20
+)
+
+const netconfHello = `<?xml version="1.0" encoding="UTF-8"?>
+<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities>` +
+	`<capability>urn:ietf:params:netconf:base:1.0</capability>` +
+	`<capability>urn:ietf:params:netconf:base:1.1</capability></capabilities></hello>`
+
+// NETCONF programs the OpenConfig static routes with NETCONF edit-config over TLS
+// (RFC 7589). Every change opens a new session, that uses the chunked framing of
+// base:1.1 when the router supports it.
+type NETCONF struct {
+	Router *Router
+
+	messageID atomic.Uint64
+}
+
+func (n *NETCONF) Routes(ctx context.Context) (map[string]string, error) {
+	filter := n.staticRoutes("")
+	session, err := n.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+
+	reply, err := session.rpc(fmt.Sprintf(`<get-config><source><%s/></source><filter type="subtree">%s</filter></get-config>`,
+		n.Router.Datastore, filter))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Statics []struct {
+			Prefix   string `xml:"prefix"`
+			NextHops []struct {
+				Index   string `xml:"index"`
+				NextHop string `xml:"config>next-hop"`
+			} `xml:"next-hops>next-hop"`
+		} `xml:"network-instances>network-instance>protocols>protocol>static-routes>static"`
+	}
+	if err := xml.Unmarshal([]byte("<data>"+reply.Data.Inner+"</data>"), &data); err != nil {
+		return nil, fmt.Errorf("invalid static routes from %s: %w", n.Router.Address, err)
+	}
+	routes := map[string]string{}
+	for _, static := range data.Statics {
+		for _, nextHop := range static.NextHops {
+			if nextHop.Index == NextHopIndex {
+				routes[static.Prefix] = nextHop.NextHop
+			}
+		}
+	}
+	return routes, nil
+}
+
+func (n *NETCONF) Replace(ctx context.Context, route Route) error {
+	static := fmt.Sprintf(`<static nc:operation="replace"><prefix>%s</prefix><config><prefix>%s</prefix></config>`+
+		`<next-hops><next-hop><index>%s</index><config><index>%s</index><next-hop>%s</next-hop></config></next-hop></next-hops></static>`,
+		escape(route.Prefix), escape(route.Prefix), NextHopIndex, NextHopIndex, escape(route.NextHop))
+	return n.edit(ctx, static)
+}
+
+func (n *NETCONF) Delete(ctx context.Context, prefix string) error {
+	return n.edit(ctx, fmt.Sprintf(`<static nc:operation="remove"><prefix>%s</prefix></static>`, escape(prefix)))
+}
+
+// staticRoutes returns the static routes container of the OpenConfig STATIC protocol,
+// with the given content
+func (n *NETCONF) staticRoutes(content string) string {
+	return fmt.Sprintf(`<network-instances xmlns="http://openconfig.net/yang/network-instance" xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0">`+
+		`<network-instance><name>%s</name><protocols><protocol>`+
+		`<identifier xmlns:oc-pol-types="http://openconfig.net/yang/policy-types">oc-pol-types:STATIC</identifier><name>%s</name>`+
+		`<static-routes>%s</static-routes></protocol></protocols></network-instance></network-instances>`,
+		escape(n.Router.NetworkInstance), escape(n.Router.StaticProtocol), content)
+}
+
+// edit changes the given static route of the OpenConfig STATIC protocol
+func (n *NETCONF) edit(ctx context.Context, static string) error {
+	session, err := n.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	operations := []string{fmt.Sprintf(`<edit-config><target><%s/></target><config>%s</config></edit-config>`,
+		n.Router.Datastore, n.staticRoutes(static))}
+	if n.Router.Datastore == "candidate" {
+		operations = append(operations, "<commit/>")
+	}
+	for _, operation := range operations {
+		reply, err := session.rpc(operation)
+		if err != nil {
+			return err
+		}
+		if reply.OK == nil {
+			return fmt.Errorf("NETCONF operation not confirmed by %s", n.Router.Address)
+		}
+	}
+	return nil
+}
+
+// netconfSession is an open NETCONF session after the hello exchange
+type netconfSession struct {
+	netconf *NETCONF
+	conn    net.Conn
+	reader  *bufio.Reader
+	// chunked is set when both peers support base:1.1, the messages after the hellos use
+	// the chunked framing (RFC 6242)
+	chunked bool
+}
+
+// netconfHelloMessage is the hello of the router
+type netconfHelloMessage struct {
+	XMLName      xml.Name `xml:"hello"`
+	Capabilities []string `xml:"capabilities>capability"`
+}
+
+// rpcReply is the reply to an rpc, with the data of a get-config
+type rpcReply struct {
+	XMLName   xml.Name   `xml:"rpc-reply"`
+	MessageID string     `xml:"message-id,attr"`
+	OK        *struct{}  `xml:"ok"`
+	Errors    []rpcError `xml:"rpc-error"`
+	Data      struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"data"`
+}
+
+type rpcError struct {
+	Type     string `xml:"error-type"`
+	Tag      string `xml:"error-tag"`
+	Severity string `xml:"error-severity"`
+	Message  string `xml:"error-message"`
+}
+
+func (e rpcError) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s: %s", e.Type, e.Tag, strings.TrimSpace(e.Message)))
+}
+
+func (n *NETCONF) open(ctx context.Context) (*netconfSession, error) {
+	tlsConfig, err := n.Router.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	address := n.Router.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "6513")
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: 10 * time.Second}, Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the router %s: %w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	session := &netconfSession{netconf: n, conn: conn, reader: bufio.NewReader(conn)}
+	message, err := session.read()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no NETCONF hello from %s: %w", address, err)
+	}
+	hello := &netconfHelloMessage{}
+	if err := xml.Unmarshal(message, hello); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid NETCONF hello from %s: %w", address, err)
+	}
+	if err := session.write(netconfHello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, capability := range hello.Capabilities {
+		if strings.TrimSpace(capability) == netconfBase11 {
+			session.chunked = true
+		}
+	}
+	return session, nil
+}
+
+// rpc sends the operation and returns the reply, an error when it has errors
+func (s *netconfSession) rpc(operation string) (*rpcReply, error) {
+	id := strconv.FormatUint(s.netconf.messageID.Add(1), 10)
+	if err := s.write(fmt.Sprintf(`<rpc message-id="%s" xmlns="%s">%s</rpc>`, id, netconfNS, operation)); err != nil {
+		return nil, err
+	}
+	message, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	reply := &rpcReply{}
+	if err := xml.Unmarshal(message, reply); err != nil {
+		return nil, fmt.Errorf("invalid NETCONF reply from %s: %w", s.netconf.Router.Address, err)
+	}
+	if reply.MessageID != id {
+		return nil, fmt.Errorf("NETCONF reply from %s to the message %s, expected %s", s.netconf.Router.Address, reply.MessageID, id)
+	}
+	failures := []string{}
+	for _, rpcError := range reply.Errors {
+		if rpcError.Severity != "warning" {
+			failures = append(failures, rpcError.String())
+		}
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("NETCONF operation failed on %s: %s", s.netconf.Router.Address, strings.Join(failures, ", "))
+	}
+	return reply, nil
+}
+
+func (s *netconfSession) write(message string) error {
+	if s.chunked {
+		_, err := fmt.Fprintf(s.conn, "\n#%d\n%s\n##\n", len(message), message)
+		return err
+	}
+	_, err := fmt.Fprintf(s.conn, "%s\n%s\n", message, netconfEOM)
+	return err
+}
+
+// read returns the next message, without its framing
+func (s *netconfSession) read() ([]byte, error) {
+	if s.chunked {
+		return readChunked(s.reader)
+	}
+	return readEOM(s.reader)
+}
+
+// readEOM reads a message of the base:1.0 framing, ended by the end of message marker
+func readEOM(reader *bufio.Reader) ([]byte, error) {
+	var message bytes.Buffer
+	for {
+		data, err := reader.ReadBytes('>')
+		message.Write(data)
+		if bytes.HasSuffix(message.Bytes(), []byte(netconfEOM)) {
+			return message.Bytes()[:message.Len()-len(netconfEOM)], nil
+		}
+		if message.Len() > maxNETCONFMessage {
+			return nil, fmt.Errorf("NETCONF message larger than %d bytes", maxNETCONFMessage)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readChunked reads a message of the chunked framing: a sequence of "\n#<size>\n<data>"
+// chunks ended by "\n##\n". The line feeds left after the hello are skipped.
+func readChunked(reader *bufio.Reader) ([]byte, error) {
+	var message bytes.Buffer
+	for {
+		lineFeeds := 0
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == '#' && lineFeeds > 0 {
+				break
+			}
+			if b != '\n' || (lineFeeds > 0 && message.Len() > 0) {
+				return nil, fmt.Errorf("invalid NETCONF chunk header")
+			}
+			lineFeeds++
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "#" {
+			return message.Bytes(), nil
+		}
+		size, err := strconv.ParseUint(line, 10, 32)
+		if err != nil || size == 0 || line[0] == '0' {
+			return nil, fmt.Errorf("invalid NETCONF chunk size %q", line)
+		}
+		if message.Len()+int(size) > maxNETCONFMessage {
+			return nil, fmt.Errorf("NETCONF message larger than %d bytes", maxNETCONFMessage)
+		}
+		if _, err := io.CopyN(&message, reader, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *netconfSession) close() {
+	_ = s.write(fmt.Sprintf(`<rpc message-id="%d" xmlns="%s"><close-session/></rpc>`, s.netconf.messageID.Add(1), netconfNS))
+	s.conn.Close()
+}
+
+func escape(value string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -0,0 +1,269 @@
+package routes
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReadChunked(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		fails    bool
+	}{
+		{
+			name:     "single chunk",
+			input:    "\n#6\n<ok/>\n\n##\n",
+			expected: "<ok/>\n",
+		},
+		{
+			name:     "message split in chunks",
+			input:    "\n#4\n<rpc\n#17\n-reply><ok/></rpc\n#7\n-reply>\n##\n",
+			expected: "<rpc-reply><ok/></rpc-reply>",
+		},
+		{
+			name:     "chunk with the end of message marker",
+			input:    "\n#12\n]]>]]>\n##\n#x\n##\n",
+			expected: "]]>]]>\n##\n#x",
+		},
+		{
+			name:  "base:1.0 framing",
+			input: "<rpc-reply><ok/></rpc-reply>]]>]]>",
+			fails: true,
+		},
+		{
+			name:  "zero chunk size",
+			input: "\n#0\n\n##\n",
+			fails: true,
+		},
+		{
+			name:  "truncated chunk",
+			input: "\n#100\n<rpc-reply/>",
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := readChunked(bufio.NewReader(strings.NewReader(test.input)))
+			if test.fails {
+				if err == nil {
+					t.Errorf("the message %q was read", message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != test.expected {
+				t.Errorf("the message is %q, expected %q", message, test.expected)
+			}
+		})
+	}
+}
+
+func TestReadEOM(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("<hello/>\n]]>]]>\n<rpc-reply/>]]>]]>"))
+	for _, expected := range []string{"<hello/>\n", "\n<rpc-reply/>"} {
+		message, err := readEOM(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != expected {
+			t.Errorf("the message is %q, expected %q", message, expected)
+		}
+	}
+}
+
+// testNETCONFServer is a NETCONF over TLS server keeping the static routes in memory
+type testNETCONFServer struct {
+	// base11 is set when the server supports the chunked framing
+	base11 bool
+	// fail replies with an rpc-error to the edit-config
+	fail bool
+
+	lock       sync.Mutex
+	routes     map[string]string
+	operations []string
+}
+
+// testRPC is an rpc received by the server
+type testRPC struct {
+	MessageID  string    `xml:"message-id,attr"`
+	GetConfig  *struct{} `xml:"get-config"`
+	Commit     *struct{} `xml:"commit"`
+	Close      *struct{} `xml:"close-session"`
+	EditConfig *struct {
+		Target struct {
+			Running   *struct{} `xml:"running"`
+			Candidate *struct{} `xml:"candidate"`
+		} `xml:"target"`
+		Statics []struct {
+			Operation string `xml:"operation,attr"`
+			Prefix    string `xml:"prefix"`
+			NextHop   string `xml:"next-hops>next-hop>config>next-hop"`
+		} `xml:"config>network-instances>network-instance>protocols>protocol>static-routes>static"`
+	} `xml:"edit-config"`
+}
+
+func (s *testNETCONFServer) start(t *testing.T) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (s *testNETCONFServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	capabilities := "<capability>urn:ietf:params:netconf:base:1.0</capability>"
+	if s.base11 {
+		capabilities += "<capability>urn:ietf:params:netconf:base:1.1</capability>"
+	}
+	fmt.Fprintf(conn, `<hello xmlns="%s"><capabilities>%s</capabilities><session-id>1</session-id></hello>]]>]]>`, netconfNS, capabilities)
+
+	reader := bufio.NewReader(conn)
+	message, err := readEOM(reader)
+	if err != nil {
+		t.Errorf("no hello from the client: %v", err)
+		return
+	}
+	hello := &netconfHelloMessage{}
+	if err := xml.Unmarshal(message, hello); err != nil {
+		t.Errorf("invalid hello from the client: %v", err)
+		return
+	}
+	session := &netconfSession{conn: conn, reader: reader}
+	for _, capability := range hello.Capabilities {
+		session.chunked = session.chunked || (s.base11 && capability == netconfBase11)
+	}
+
+	for {
+		message, err := session.read()
+		if err != nil {
+			return
+		}
+		rpc := &testRPC{}
+		if err := xml.Unmarshal(message, rpc); err != nil {
+			t.Errorf("invalid rpc %q: %v", message, err)
+			return
+		}
+		reply := "<ok/>"
+		s.lock.Lock()
+		switch {
+		case rpc.GetConfig != nil:
+			s.operations = append(s.operations, "get-config")
+			statics := ""
+			for prefix, nextHop := range s.routes {
+				statics += fmt.Sprintf("<static><prefix>%s</prefix><next-hops><next-hop><index>%s</index>"+
+					"<config><index>%s</index><next-hop>%s</next-hop></config></next-hop></next-hops></static>",
+					prefix, NextHopIndex, NextHopIndex, nextHop)
+			}
+			statics += "<static><prefix>0.0.0.0/0</prefix><next-hops><next-hop><index>uplink</index>" +
+				"<config><next-hop>192.168.0.254</next-hop></config></next-hop></next-hops></static>"
+			reply = `<data><network-instances xmlns="http://openconfig.net/yang/network-instance"><network-instance><name>default</name>` +
+				`<protocols><protocol><static-routes>` + statics + `</static-routes></protocol></protocols></network-instance></network-instances></data>`
+		case rpc.EditConfig != nil && s.fail:
+			s.operations = append(s.operations, "edit-config")
+			reply = "<rpc-error><error-type>application</error-type><error-tag>invalid-value</error-tag>" +
+				"<error-severity>error</error-severity><error-message>invalid next hop</error-message></rpc-error>"
+		case rpc.EditConfig != nil:
+			datastore := "running"
+			if rpc.EditConfig.Target.Candidate != nil {
+				datastore = "candidate"
+			}
+			s.operations = append(s.operations, "edit-config "+datastore)
+			for _, static := range rpc.EditConfig.Statics {
+				if static.Operation == "remove" {
+					delete(s.routes, static.Prefix)
+				} else {
+					s.routes[static.Prefix] = static.NextHop
+				}
+			}
+		case rpc.Commit != nil:
+			s.operations = append(s.operations, "commit")
+		case rpc.Close != nil:
+			s.operations = append(s.operations, "close-session")
+		}
+		s.lock.Unlock()
+		if err := session.write(fmt.Sprintf(`<rpc-reply message-id="%s" xmlns="%s">%s</rpc-reply>`, rpc.MessageID, netconfNS, reply)); err != nil {
+			return
+		}
+		if rpc.Close != nil {
+			return
+		}
+	}
+}
+
+func TestNETCONF(t *testing.T) {
+	for _, base11 := range []bool{true, false} {
+		t.Run(fmt.Sprintf("base11=%t", base11), func(t *testing.T) {
+			ctx := context.Background()
+			server := &testNETCONFServer{base11: base11, routes: map[string]string{"10.0.0.9/32": "192.168.0.3"}}
+			router := testRouter(ProtocolNETCONF, server.start(t))
+			router.Datastore = "candidate"
+			programmer := &NETCONF{Router: router}
+
+			routes, err := programmer.Routes(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := map[string]string{"10.0.0.9/32": "192.168.0.3"}; !reflect.DeepEqual(routes, expected) {
+				t.Errorf("the routes of the operator are %v, expected %v", routes, expected)
+			}
+			if err := programmer.Replace(ctx, Route{Prefix: "10.0.0.7/32", NextHop: "192.168.0.1"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := programmer.Delete(ctx, "10.0.0.9/32"); err != nil {
+				t.Fatal(err)
+			}
+
+			server.lock.Lock()
+			defer server.lock.Unlock()
+			if expected := map[string]string{"10.0.0.7/32": "192.168.0.1"}; !reflect.DeepEqual(server.routes, expected) {
+				t.Errorf("the router has the routes %v, expected %v", server.routes, expected)
+			}
+			// The sessions are closed in background
+			operations := []string{}
+			for _, operation := range server.operations {
+				if operation != "close-session" {
+					operations = append(operations, operation)
+				}
+			}
+			expected := []string{"get-config", "edit-config candidate", "commit", "edit-config candidate", "commit"}
+			if !reflect.DeepEqual(operations, expected) {
+				t.Errorf("the router received %v, expected %v", operations, expected)
+			}
+		})
+	}
+}
+
+func TestNETCONFError(t *testing.T) {
+	server := &testNETCONFServer{base11: true, fail: true, routes: map[string]string{}}
+	programmer := &NETCONF{Router: testRouter(ProtocolNETCONF, server.start(t))}
+	err := programmer.Replace(context.Background(), Route{Prefix: "10.0.0.7/32", NextHop: "192.168.0.1"})
+	if err == nil || !strings.Contains(err.Error(), "invalid-value: invalid next hop") {
+		t.Errorf("the failed edit-config returned %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package routes programs, on the upstream routers, a /32 (or /128) static route for
+// every egress IP toward its exit node, for the L3 fabrics where the egress IP cannot
+// be announced by the nodes with ARP or BGP.
+package routes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Router protocols
+const (
+	ProtocolGNMI    = "gnmi"
+	ProtocolNETCONF = "netconf"
+)
+
+// NextHopIndex is the index of the next hop of the routes programmed by the operator
+const NextHopIndex = "haegress"
+
+// Route is a static route toward an exit node
+type Route struct {
+	// Prefix is the egress IP with a host prefix length
+	Prefix  string
+	NextHop string
+}
+
+// Programmer installs and removes the static routes on a router
+type Programmer interface {
+	// Routes returns the next hop of the routes of the operator on the router, the ones
+	// with the NextHopIndex next hop, by prefix
+	Routes(ctx context.Context) (map[string]string, error)
+	Replace(ctx context.Context, route Route) error
+	Delete(ctx context.Context, prefix string) error
+}
+
+// Router is an upstream router programmed by the operator
+type Router struct {
+	Name string `json:"name"`
+	// Protocol is gnmi or netconf, NETCONF is used over TLS (RFC 7589)
+	Protocol string `json:"protocol"`
+	// Address is host:port, the default port is 57400 for gNMI and 6513 for NETCONF
+	Address      string `json:"address"`
+	Username     string `json:"username,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	// CAFile verifies the router certificate, the system CAs are used when empty
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate, required by NETCONF over TLS
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// NetworkInstance is the OpenConfig network instance of the routes, default when empty
+	NetworkInstance string `json:"networkInstance,omitempty"`
+	// StaticProtocol is the name of the OpenConfig STATIC protocol instance, STATIC when empty
+	StaticProtocol string `json:"staticProtocol,omitempty"`
+	// Datastore is the NETCONF datastore edited, running or candidate (committed after
+	// every change), running when empty
+	Datastore string `json:"datastore,omitempty"`
+
+	password   string
+	programmer Programmer
+	// programmed are the routes installed on the router, by prefix, read from the router
+	// when nil
+	programmed map[string]string
+}
+
+// Config is the content of the route injection configuration file
+type Config struct {
+	Routers []Router `json:"routers"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the routers
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for i := range config.Routers {
+		router := &config.Routers[i]
+		if router.NetworkInstance == "" {
+			router.NetworkInstance = "default"
+		}
+		if router.StaticProtocol == "" {
+			router.StaticProtocol = "STATIC"
+		}
+		if router.Datastore == "" {
+			router.Datastore = "running"
+		}
+		if router.Datastore != "running" && router.Datastore != "candidate" {
+			return nil, fmt.Errorf("unsupported datastore %q of the router %q, valid datastores are running and candidate", router.Datastore, router.Name)
+		}
+		if router.PasswordFile != "" {
+			password, err := os.ReadFile(router.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			router.password = strings.TrimSpace(string(password))
+		}
+		switch router.Protocol {
+		case ProtocolGNMI:
+			router.programmer = &GNMI{Router: router}
+		case ProtocolNETCONF:
+			router.programmer = &NETCONF{Router: router}
+		default:
+			return nil, fmt.Errorf("unknown protocol %q of the router %q, valid protocols are gnmi and netconf", router.Protocol, router.Name)
+		}
+	}
+	return config, nil
+}
+
+// tlsConfig returns the TLS configuration used to connect to the router
+func (r *Router) tlsConfig() (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(r.Address)
+	if err != nil {
+		host = r.Address
+	}
+	config := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: r.InsecureSkipVerify, //nolint:gosec // explicitly requested for lab routers
+	}
+	if r.CAFile != "" {
+		ca, err := os.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate found in %s", r.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if r.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// Injector keeps the static routes of the routers in sync with the egress IPs and their
+// exit nodes. It checks the mappings periodically and immediately after an exit node
+// change, when it is registered as notify.Sink.
+type Injector struct {
+	client.Client
+	Log    logr.Logger
+	Config *Config
+
+	DefaultNamespace string
+	IntervalSeconds  int
+	// ReadOnly, when set, receives the changes of the routes instead of the routers, like
+	// readonly.Report.Record
+	ReadOnly func(verb, kind, namespace, name string, data []byte)
+
+	trigger chan struct{}
+}
+
+// SetupWithManager registers the injector as a leader-only runnable of the Manager.
+func (i *Injector) SetupWithManager(mgr ctrl.Manager) error {
+	i.trigger = make(chan struct{}, 1)
+	return mgr.Add(i)
+}
+
+// Publish implements notify.Sink, the routes are updated as soon as an egress IP moves
+func (i *Injector) Publish(event notify.Event) {
+	if event.Type != notify.ExitNodeChanged && event.Type != notify.EgressIPAssigned {
+		return
+	}
+	select {
+	case i.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (i *Injector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(i.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-i.trigger:
+		}
+		if err := i.sync(ctx); err != nil {
+			i.Log.Error(err, "unable to build the egress routes")
+		}
+	}
+}
+
+func (i *Injector) sync(ctx context.Context) error {
+	desired, unknown, err := i.routes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for r := range i.Config.Routers {
+		router := &i.Config.Routers[r]
+		// The routes installed by the previous leaders are read from the router, so the
+		// ones of the policies deleted meanwhile are removed
+		if router.programmed == nil {
+			programmed, err := router.programmer.Routes(ctx)
+			if err != nil {
+				i.Log.Error(err, "unable to read the egress routes", "router", router.Name)
+				continue
+			}
+			router.programmed = programmed
+		}
+		for prefix, nextHop := range desired {
+			if router.programmed[prefix] == nextHop {
+				continue
+			}
+			if err := i.replace(ctx, router, Route{Prefix: prefix, NextHop: nextHop}); err != nil {
+				i.Log.Error(err, "unable to program the egress route", "router", router.Name, "prefix", prefix, "nextHop", nextHop)
+				continue
+			}
+			i.Log.Info("Programmed the egress route", "router", router.Name, "prefix", prefix, "nextHop", nextHop)
+			router.programmed[prefix] = nextHop
+		}
+		for prefix := range router.programmed {
+			if _, ok := desired[prefix]; ok || unknown[prefix] {
+				continue
+			}
+			if err := i.delete(ctx, router, prefix); err != nil {
+				i.Log.Error(err, "unable to remove the egress route", "router", router.Name, "prefix", prefix)
+				continue
+			}
+			i.Log.Info("Removed the egress route", "router", router.Name, "prefix", prefix)
+			delete(router.programmed, prefix)
+		}
+	}
+	return nil
+}
+
+// replace programs the route on the router, or reports it in read-only mode
+func (i *Injector) replace(ctx context.Context, router *Router, route Route) error {
+	if i.ReadOnly != nil {
+		i.ReadOnly("Replace", "Route", router.Name, route.Prefix, []byte(route.NextHop))
+		return nil
+	}
+	return router.programmer.Replace(ctx, route)
+}
+
+// delete removes the route of the prefix from the router, or reports it in read-only mode
+func (i *Injector) delete(ctx context.Context, router *Router, prefix string) error {
+	if i.ReadOnly != nil {
+		i.ReadOnly("Delete", "Route", router.Name, prefix, nil)
+		return nil
+	}
+	return router.programmer.Delete(ctx, prefix)
+}
+
+// routes returns the next hop of every egress IP with an exit node, by prefix, and the
+// prefixes whose exit node is not found, e.g. deleted during a failover, whose routes
+// are left as they are
+func (i *Injector) routes(ctx context.Context) (map[string]string, map[string]bool, error) {
+	mappings, err := mapping.List(ctx, i.Client, i.DefaultNamespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The exit node is the hostname label of the node, not its name
+	nodeList := &corev1.NodeList{}
+	if err := i.List(ctx, nodeList); err != nil {
+		return nil, nil, err
+	}
+	nodes := make(map[string]*corev1.Node, len(nodeList.Items))
+	for n := range nodeList.Items {
+		if hostname := nodeList.Items[n].Labels[haegressip.NodeNameAnnotation]; hostname != "" {
+			nodes[hostname] = &nodeList.Items[n]
+		}
+	}
+
+	routes := map[string]string{}
+	unknown := map[string]bool{}
+	for _, m := range mappings {
+		ip := net.ParseIP(m.EgressIP)
+		if ip == nil || m.ExitNode == "" {
+			continue
+		}
+		prefix := m.EgressIP + "/128"
+		if ip.To4() != nil {
+			prefix = m.EgressIP + "/32"
+		}
+		node, ok := nodes[m.ExitNode]
+		if !ok {
+			i.Log.Info("The exit node is not found, the egress route is not changed", "policy", m.Policy, "node", m.ExitNode)
+			unknown[prefix] = true
+			continue
+		}
+		nextHop := nodeAddress(node, ip.To4() != nil)
+		if nextHop == "" {
+			i.Log.Info("The exit node has no internal address of the egress IP family", "policy", m.Policy, "node", m.ExitNode)
+			continue
+		}
+		routes[prefix] = nextHop
+	}
+	return routes, unknown, nil
+}
+
+// nodeAddress returns the first internal address of the node of the given family
+func nodeAddress(node *corev1.Node, ipv4 bool) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type != corev1.NodeInternalIP {
+			continue
+		}
+		ip := net.ParseIP(address.Address)
+		if ip != nil && (ip.To4() != nil) == ipv4 {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// staticRouteJSON is the OpenConfig static route, shared by gNMI and NETCONF
+func staticRouteJSON(route Route) map[string]any {
+	return map[string]any{
+		"prefix": route.Prefix,
+		"config": map[string]any{"prefix": route.Prefix},
+		"next-hops": map[string]any{
+			"next-hop": []any{
+				map[string]any{
+					"index": NextHopIndex,
+					"config": map[string]any{
+						"index":    NextHopIndex,
+						"next-hop": route.NextHop,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testProgrammer is a router holding the routes in memory
+type testProgrammer struct {
+	lock   sync.Mutex
+	routes map[string]string
+	reads  int
+}
+
+func (p *testProgrammer) Routes(_ context.Context) (map[string]string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reads++
+	routes := map[string]string{}
+	for prefix, nextHop := range p.routes {
+		routes[prefix] = nextHop
+	}
+	return routes, nil
+}
+
+func (p *testProgrammer) Replace(_ context.Context, route Route) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.routes[route.Prefix] = route.NextHop
+	return nil
+}
+
+func (p *testProgrammer) Delete(_ context.Context, prefix string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.routes, prefix)
+	return nil
+}
+
+func testNode(name, hostname, address string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{haegressip.NodeNameAnnotation: hostname}},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+	}
+}
+
+func testPolicy(name, ip, exitNode string) *haegressv2.HAEgressGatewayPolicy {
+	policy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	policy.Status.IPAddress = ip
+	policy.Status.ExitNode = exitNode
+	return policy
+}
+
+func newTestInjector(t *testing.T, programmer Programmer, objects ...client.Object) *Injector {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &Injector{
+		Client:           fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:              logr.Discard(),
+		Config:           &Config{Routers: []Router{{Name: "spine-1", programmer: programmer}}},
+		DefaultNamespace: "egress-system",
+	}
+}
+
+func TestInjectorSync(t *testing.T) {
+	ctx := context.Background()
+	// The route of a policy deleted while another replica was the leader
+	router := &testProgrammer{routes: map[string]string{"10.0.0.9/32": "192.168.0.3"}}
+	injector := newTestInjector(t, router,
+		// The node name is not its hostname label
+		testNode("worker-1.example.com", "worker-1", "192.168.0.1"),
+		testNode("worker-2.example.com", "worker-2", "192.168.0.2"),
+		testPolicy("egress-web", "10.0.0.7", "worker-1"),
+		testPolicy("egress-db", "2001:db8::7", "worker-2"),
+		testPolicy("egress-pending", "10.0.0.8", ""),
+	)
+
+	if err := injector.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The node has no IPv6 address, the route of the IPv6 egress IP is not programmed
+	if expected := map[string]string{"10.0.0.7/32": "192.168.0.1"}; !reflect.DeepEqual(router.routes, expected) {
+		t.Errorf("the router has the routes %v, expected %v", router.routes, expected)
+	}
+
+	// The exit node is deleted during a failover, its route stays until the new exit node
+	// is known, while the other routes are still programmed
+	if err := injector.Delete(ctx, testNode("worker-1.example.com", "worker-1", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := injector.Create(ctx, testPolicy("egress-api", "10.0.0.10", "worker-2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := injector.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"10.0.0.7/32": "192.168.0.1", "10.0.0.10/32": "192.168.0.2"}; !reflect.DeepEqual(router.routes, expected) {
+		t.Errorf("the router has the routes %v after the deletion of the exit node, expected %v", router.routes, expected)
+	}
+	if router.reads != 1 {
+		t.Errorf("the routes were read %d times from the router, expected once", router.reads)
+	}
+}
+
+func TestInjectorReadOnly(t *testing.T) {
+	router := &testProgrammer{routes: map[string]string{"10.0.0.9/32": "192.168.0.3"}}
+	injector := newTestInjector(t, router,
+		testNode("worker-1", "worker-1", "192.168.0.1"),
+		testPolicy("egress-web", "10.0.0.7", "worker-1"),
+	)
+	changes := []string{}
+	injector.ReadOnly = func(verb, kind, namespace, name string, data []byte) {
+		changes = append(changes, verb+" "+kind+" "+namespace+" "+name+" "+string(data))
+	}
+	if err := injector.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(router.routes) != 1 || router.routes["10.0.0.9/32"] != "192.168.0.3" {
+		t.Errorf("the router was changed in read-only mode: %v", router.routes)
+	}
+	expected := []string{"Replace Route spine-1 10.0.0.7/32 192.168.0.1", "Delete Route spine-1 10.0.0.9/32 "}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("the report received %q, expected %q", changes, expected)
+	}
+}
+
+// testCertificate returns a self-signed certificate of 127.0.0.1
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "router"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testRouter returns the router of a test server listening on the address
+func testRouter(protocol, address string) *Router {
+	return &Router{
+		Name:               "spine-1",
+		Protocol:           protocol,
+		Address:            address,
+		Username:           "haegress",
+		password:           "secret",
+		InsecureSkipVerify: true,
+		NetworkInstance:    "default",
+		StaticProtocol:     "STATIC",
+		Datastore:          "running",
+	}
+}
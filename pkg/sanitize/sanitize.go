@@ -0,0 +1,248 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sanitize validates the values that end up in the label selectors and in the
+// patches of the CiliumEgressGatewayPolicies: the node names reported by the providers or
+// read from the node labels, the namespace names and the annotations set by the users.
+// A malformed value is rejected before it can produce an invalid patch, or a nodeSelector
+// selecting more nodes than expected.
+package sanitize
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxInterfaceNameLength is the maximum length of a Linux network interface name
+const maxInterfaceNameLength = 15
+
+// NodeName returns an error when the name can't be used as value of the hostname label
+// in a nodeSelector
+func NodeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty node name")
+	}
+	problems := validation.IsDNS1123Subdomain(name)
+	problems = append(problems, validation.IsValidLabelValue(name)...)
+	return invalid("node name", name, problems)
+}
+
+// NodeNames returns an error when the list is empty or when a name is invalid, an empty
+// In requirement is rejected by the API server
+func NodeNames(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("empty list of node names")
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if err := NodeName(name); err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("node name %q repeated", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// NamespaceName returns an error when the name is not a valid namespace name
+func NamespaceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty namespace name")
+	}
+	return invalid("namespace name", name, validation.IsDNS1123Label(name))
+}
+
+// NamespaceNames returns an error when a name of the list is invalid
+func NamespaceNames(names []string) error {
+	for _, name := range names {
+		if err := NamespaceName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InterfaceName returns an error when the name is not a valid network interface name, or
+// auto
+func InterfaceName(name string) error {
+	if name == haegressip.EgressInterfaceAuto {
+		return nil
+	}
+	var problems []string
+	if name == "" {
+		problems = append(problems, "must not be empty")
+	}
+	if len(name) > maxInterfaceNameLength {
+		problems = append(problems, fmt.Sprintf("must be no more than %d characters", maxInterfaceNameLength))
+	}
+	if name == "." || name == ".." {
+		problems = append(problems, "must not be . or ..")
+	}
+	if strings.ContainsAny(name, "/:\"\\") || strings.IndexFunc(name, isSpaceOrControl) >= 0 {
+		problems = append(problems, "must not contain /, :, quotes, backslashes, spaces or control characters")
+	}
+	return invalid("interface name", name, problems)
+}
+
+// IPFamilyPolicy returns an error when the value is not an ipFamilyPolicy of the Services
+func IPFamilyPolicy(value string) error {
+	switch corev1.IPFamilyPolicy(value) {
+	case corev1.IPFamilyPolicySingleStack, corev1.IPFamilyPolicyPreferDualStack, corev1.IPFamilyPolicyRequireDualStack:
+		return nil
+	}
+	return fmt.Errorf("invalid ipFamilyPolicy %q: must be %s, %s or %s", value,
+		corev1.IPFamilyPolicySingleStack, corev1.IPFamilyPolicyPreferDualStack, corev1.IPFamilyPolicyRequireDualStack)
+}
+
+// IPFamilies returns an error when the value is not a comma separated list of one or two
+// distinct IP families, the first one is the primary family of the Service
+func IPFamilies(value string) error {
+	families := strings.Split(value, ",")
+	if len(families) > 2 {
+		return fmt.Errorf("invalid IP families %q: at most two families", value)
+	}
+	seen := map[string]bool{}
+	for _, family := range families {
+		family = strings.TrimSpace(family)
+		if family != string(corev1.IPv4Protocol) && family != string(corev1.IPv6Protocol) {
+			return fmt.Errorf("invalid IP families %q: %q must be %s or %s", value, family, corev1.IPv4Protocol, corev1.IPv6Protocol)
+		}
+		if seen[family] {
+			return fmt.Errorf("invalid IP families %q: %s repeated", value, family)
+		}
+		seen[family] = true
+	}
+	return nil
+}
+
+// FQDN returns an error when the name is not a DNS name that can be resolved, wildcards
+// are not allowed
+func FQDN(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" {
+		return fmt.Errorf("empty FQDN")
+	}
+	problems := validation.IsDNS1123Subdomain(strings.ToLower(trimmed))
+	if !strings.Contains(trimmed, ".") {
+		problems = append(problems, "must contain at least one dot")
+	}
+	return invalid("FQDN", name, problems)
+}
+
+// FQDNs returns an error when a name of the list is invalid
+func FQDNs(names []string) error {
+	for _, name := range names {
+		if err := FQDN(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProviderReference returns an error when the reference is not feed:name, with the feed
+// a valid feed name
+func ProviderReference(reference string) error {
+	feed, name, found := strings.Cut(reference, ":")
+	var problems []string
+	if !found || name == "" {
+		problems = append(problems, "must be feed:name")
+	}
+	problems = append(problems, validation.IsDNS1123Label(feed)...)
+	if strings.IndexFunc(name, isControl) >= 0 {
+		problems = append(problems, "must not contain control characters")
+	}
+	return invalid("provider reference", reference, problems)
+}
+
+// ProviderReferences returns an error when a reference of the list is invalid
+func ProviderReferences(references []string) error {
+	for _, reference := range references {
+		if err := ProviderReference(reference); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadBalancerClass returns an error when the class is not a label-style name, as
+// required by the API server
+func LoadBalancerClass(class string) error {
+	return invalid("load balancer class", class, validation.IsQualifiedName(class))
+}
+
+// annotations are the validators of the annotations of a policy used in the selectors and
+// in the patches of the generated objects
+var annotations = map[string]func(string) error{
+	haegressip.HAEgressGatewayPolicyNamespace: NamespaceName,
+	haegressip.EgressInterfaceAnnotation:      InterfaceName,
+	haegressip.PreferredExitNodeAnnotation:    NodeName,
+	haegressip.StaticExitNodeAnnotation:       NodeName,
+	haegressip.StaticEgressIPAnnotation:       ipAddress,
+	haegressip.IPFamilyPolicyAnnotation:       IPFamilyPolicy,
+	haegressip.IPFamiliesAnnotation:           IPFamilies,
+	haegressip.LoadBalancerClassAnnotation:    LoadBalancerClass,
+}
+
+// PolicyAnnotations returns an error for the first invalid annotation, in name order. An
+// empty annotation is ignored, as the operator considers it unset.
+func PolicyAnnotations(policyAnnotations map[string]string) error {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := policyAnnotations[key]
+		if value == "" {
+			continue
+		}
+		if err := annotations[key](value); err != nil {
+			return fmt.Errorf("annotation %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func ipAddress(ip string) error {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return r <= ' ' || r == 0x7f
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
+
+// invalid returns the error of the problems found in the value, nil without problems
+func invalid(field, value string, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q: %s", field, value, strings.Join(problems, "; "))
+}
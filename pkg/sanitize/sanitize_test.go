@@ -0,0 +1,146 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+)
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		valid    []string
+		invalid  []string
+	}{
+		{
+			name:     "node name",
+			validate: NodeName,
+			valid:    []string{"worker-1", "ip-10-0-1-7.eu-west-1.compute.internal"},
+			invalid:  []string{"", "Worker-1", "worker-1,worker-2", "worker 1", strings.Repeat("a", 64)},
+		},
+		{
+			name:     "namespace name",
+			validate: NamespaceName,
+			valid:    []string{"egress-system"},
+			invalid:  []string{"", "egress.system", "Egress", strings.Repeat("a", 64)},
+		},
+		{
+			name:     "interface name",
+			validate: InterfaceName,
+			valid:    []string{"auto", "eth1", "bond0.100", "ens5f0np0"},
+			invalid:  []string{"", ".", "..", "eth1/2", "eth 1", "eth\"1", "eth:1", "averyverylongname"},
+		},
+		{
+			name:     "ipFamilyPolicy",
+			validate: IPFamilyPolicy,
+			valid:    []string{"SingleStack", "PreferDualStack", "RequireDualStack"},
+			invalid:  []string{"", "singlestack", "DualStack"},
+		},
+		{
+			name:     "IP families",
+			validate: IPFamilies,
+			valid:    []string{"IPv4", "IPv6", "IPv6, IPv4"},
+			invalid:  []string{"", "IPv4,IPv4", "IPv4,IPv6,IPv4", "ipv4"},
+		},
+		{
+			name:     "FQDN",
+			validate: FQDN,
+			valid:    []string{"api.example.com", "API.Example.com."},
+			invalid:  []string{"", ".", "localhost", "*.example.com", "api..example.com"},
+		},
+		{
+			name:     "provider reference",
+			validate: ProviderReference,
+			valid:    []string{"aws:ip-ranges", "github:hooks"},
+			invalid:  []string{"", "aws", "aws:", "AWS:ip-ranges", "aws:ip\nranges"},
+		},
+		{
+			name:     "load balancer class",
+			validate: LoadBalancerClass,
+			valid:    []string{"kube-vip.io/kube-vip-class", "io.cilium/l2-announcer"},
+			invalid:  []string{"", "kube vip", "/class"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, value := range test.valid {
+				if err := test.validate(value); err != nil {
+					t.Errorf("%q was rejected: %v", value, err)
+				}
+			}
+			for _, value := range test.invalid {
+				if err := test.validate(value); err == nil {
+					t.Errorf("%q was accepted", value)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		fails bool
+	}{
+		{names: []string{"worker-1", "worker-2"}},
+		{names: []string{}, fails: true},
+		{names: []string{"worker-1", "worker-1"}, fails: true},
+		{names: []string{"worker-1", "Worker-2"}, fails: true},
+	}
+	for _, test := range tests {
+		if err := NodeNames(test.names); (err != nil) != test.fails {
+			t.Errorf("the node names %q returned %v", test.names, err)
+		}
+	}
+}
+
+func TestPolicyAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name: "valid annotations",
+			annotations: map[string]string{
+				haegressip.EgressInterfaceAnnotation: "auto",
+				haegressip.StaticEgressIPAnnotation:  "2001:db8::7",
+				haegressip.StaticExitNodeAnnotation:  "worker-1",
+				"example.com/unrelated":              "any value",
+			},
+		},
+		{
+			name:        "empty annotations are unset",
+			annotations: map[string]string{haegressip.StaticExitNodeAnnotation: ""},
+		},
+		{
+			name:        "invalid static egress IP",
+			annotations: map[string]string{haegressip.StaticEgressIPAnnotation: "10.0.0.300"},
+			expected:    haegressip.StaticEgressIPAnnotation,
+		},
+		{
+			name: "the first invalid annotation in name order",
+			annotations: map[string]string{
+				haegressip.StaticExitNodeAnnotation:  "worker-1,worker-2",
+				haegressip.EgressInterfaceAnnotation: "eth 1",
+			},
+			expected: haegressip.EgressInterfaceAnnotation,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := PolicyAnnotations(test.annotations)
+			if test.expected == "" {
+				if err != nil {
+					t.Errorf("the annotations were rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), "annotation "+test.expected+":") {
+				t.Errorf("the annotations returned %v, expected an error of %s", err, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package servicenow records the egress IPs, with their owning policy, namespaces and
+// cluster, as configuration items in the ServiceNow CMDB.
+package servicenow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ServiceNow install_status values
+const (
+	InstallStatusInstalled = "1"
+	InstallStatusRetired   = "7"
+)
+
+// record holds the managed fields of a CI, every CI of the operator has a correlation_id
+// <cluster>/<policy>
+type record struct {
+	SysID            string `json:"sys_id,omitempty"`
+	CorrelationID    string `json:"correlation_id"`
+	Name             string `json:"name"`
+	IPAddress        string `json:"ip_address"`
+	ShortDescription string `json:"short_description"`
+	InstallStatus    string `json:"install_status"`
+}
+
+// Syncer periodically reconciles the CIs of the ServiceNow table with the egress IP
+// mappings: a CI is created for every assigned egress IP, updated when the mapping
+// changes and retired when the policy is deleted.
+type Syncer struct {
+	client.Client
+	Log logr.Logger
+
+	// URL is the instance URL, like https://example.service-now.com
+	URL        string
+	Username   string
+	Password   string
+	Table      string
+	HTTPClient *http.Client
+
+	// Cluster is the Cilium cluster name, "default" when empty
+	Cluster          string
+	DefaultNamespace string
+	IntervalSeconds  int
+	// ReadOnly, when set, receives the changes of the CIs instead of ServiceNow, like
+	// readonly.Report.Record. The CIs are still read.
+	ReadOnly func(verb, kind, namespace, name string, data []byte)
+}
+
+// SetupWithManager registers the syncer as a leader-only runnable of the Manager.
+func (s *Syncer) SetupWithManager(mgr ctrl.Manager) error {
+	if s.Cluster == "" {
+		s.Cluster = "default"
+	}
+	return mgr.Add(s)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Syncer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(s.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sync(ctx); err != nil {
+			s.Log.Error(err, "unable to synchronize the egress IPs with ServiceNow", "table", s.Table)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) sync(ctx context.Context) error {
+	mappings, err := mapping.List(ctx, s.Client, s.DefaultNamespace)
+	if err != nil {
+		return err
+	}
+	records, err := s.records(ctx)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	for _, m := range mappings {
+		if m.EgressIP == "" {
+			continue
+		}
+		wanted := s.record(m)
+		desired[wanted.CorrelationID] = true
+		current, ok := records[wanted.CorrelationID]
+		switch {
+		case !ok:
+			s.Log.Info("Creating the ServiceNow CI", "policy", m.Policy, "egressIP", m.EgressIP)
+			err = s.do(ctx, http.MethodPost, s.tableURL(""), wanted.CorrelationID, wanted)
+		case current.Name != wanted.Name || current.IPAddress != wanted.IPAddress ||
+			current.ShortDescription != wanted.ShortDescription || current.InstallStatus != wanted.InstallStatus:
+			s.Log.Info("Updating the ServiceNow CI", "policy", m.Policy, "egressIP", m.EgressIP, "sysID", current.SysID)
+			err = s.do(ctx, http.MethodPatch, s.tableURL(current.SysID), wanted.CorrelationID, wanted)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for correlationID, current := range records {
+		if desired[correlationID] || current.InstallStatus == InstallStatusRetired {
+			continue
+		}
+		s.Log.Info("Retiring the ServiceNow CI", "correlationID", correlationID, "egressIP", current.IPAddress, "sysID", current.SysID)
+		if err := s.do(ctx, http.MethodPatch, s.tableURL(current.SysID), correlationID, map[string]string{
+			"install_status": InstallStatusRetired,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record returns the desired CI of the mapping
+func (s *Syncer) record(m mapping.Mapping) record {
+	return record{
+		CorrelationID: fmt.Sprintf("%s/%s", s.Cluster, m.Policy),
+		Name:          fmt.Sprintf("haegress-%s-%s", s.Cluster, m.Policy),
+		IPAddress:     m.EgressIP,
+		ShortDescription: fmt.Sprintf("Egress IP of the HAEgressGatewayPolicy %s of the cluster %s, used by the namespaces %s",
+			m.Policy, s.Cluster, strings.Join(m.Namespaces, ", ")),
+		InstallStatus: InstallStatusInstalled,
+	}
+}
+
+// records returns the CIs of the cluster, by correlation_id
+func (s *Syncer) records(ctx context.Context) (map[string]record, error) {
+	query := url.Values{}
+	query.Set("sysparm_query", fmt.Sprintf("correlation_idSTARTSWITH%s/", s.Cluster))
+	query.Set("sysparm_fields", "sys_id,correlation_id,name,ip_address,short_description,install_status")
+	query.Set("sysparm_exclude_reference_link", "true")
+	query.Set("sysparm_limit", "10000")
+
+	var result struct {
+		Result []record `json:"result"`
+	}
+	if err := s.request(ctx, http.MethodGet, s.tableURL("")+"?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	records := make(map[string]record, len(result.Result))
+	for _, r := range result.Result {
+		records[r.CorrelationID] = r
+	}
+	return records, nil
+}
+
+func (s *Syncer) tableURL(sysID string) string {
+	endpoint := fmt.Sprintf("%s/api/now/table/%s", strings.TrimSuffix(s.URL, "/"), url.PathEscape(s.Table))
+	if sysID != "" {
+		endpoint += "/" + url.PathEscape(sysID)
+	}
+	return endpoint
+}
+
+// do changes the CI with the given correlation_id
+func (s *Syncer) do(ctx context.Context, method, endpoint, correlationID string, body any) error {
+	if s.ReadOnly != nil {
+		data, _ := json.Marshal(body)
+		s.ReadOnly(method, "ServiceNowCI", s.Table, correlationID, data)
+		return nil
+	}
+	return s.request(ctx, method, endpoint, body, nil)
+}
+
+func (s *Syncer) request(ctx context.Context, method, endpoint string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.Username, s.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ServiceNow %s %s failed with status %d: %s", method, s.Table, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,375 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shard splits the policies between the operator replicas. Every policy belongs
+// to a shard, chosen by the hash of its name or by the shard label, and every shard is
+// owned by the replica holding its Lease, so the replicas reconcile their own policies
+// in parallel instead of waiting for a single leader.
+package shard
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Label assigns a policy, and its Service, to the given shard instead of the hash of
+// its name
+const Label = "cilium.angeloxx.ch/shard"
+
+// leasePrefix is the prefix of the name of the shard Leases
+const leasePrefix = "cilium-haegress-operator-shard-"
+
+// memberPrefix is the prefix of the name of the membership Leases, one for every replica
+const memberPrefix = "cilium-haegress-operator-member-"
+
+// memberLabel marks the membership Leases, listed to count the live replicas
+const memberLabel = "cilium.angeloxx.ch/shard-member"
+
+// Sharder holds the Leases of the shards owned by the replica. The shards are balanced
+// between the live replicas, the ones renewing their membership Lease: a replica takes the
+// free or expired shards up to its fair share, and releases the shards above it, so the
+// others can take them. A nil Sharder owns every policy.
+type Sharder struct {
+	// Client writes the Leases and Reader reads them, it should be a non-cached reader
+	Client   client.Client
+	Reader   client.Reader
+	Log      logr.Logger
+	Shards   int
+	Identity string
+	// Namespace of the shard Leases
+	Namespace     string
+	LeaseDuration time.Duration
+
+	lock    sync.RWMutex
+	owned   map[int]time.Time
+	changed chan struct{}
+}
+
+// SetupWithManager registers the sharder as a runnable of the Manager, on every replica
+func (s *Sharder) SetupWithManager(mgr ctrl.Manager) error {
+	s.changed = make(chan struct{}, 1)
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection returns false, every replica owns some shards
+func (s *Sharder) NeedLeaderElection() bool {
+	return false
+}
+
+// Shard returns the shard of the policy with the given name and labels
+func (s *Sharder) Shard(name string, labels map[string]string) int {
+	if value, ok := labels[Label]; ok {
+		if shard, err := strconv.Atoi(value); err == nil && shard >= 0 && shard < s.Shards {
+			return shard
+		}
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return int(hash.Sum32() % uint32(s.Shards))
+}
+
+// Owns returns true when the replica owns the shard of the policy
+func (s *Sharder) Owns(name string, labels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	shard := s.Shard(name, labels)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	// Stop reconciling before the Lease expires, in case it could not be renewed
+	renewed, ok := s.owned[shard]
+	return ok && time.Since(renewed) < s.LeaseDuration*2/3
+}
+
+// Owned returns the shards owned by the replica
+func (s *Sharder) Owned() []int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	shards := make([]int, 0, len(s.owned))
+	for shard := range s.owned {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// Changed is signalled when the replica takes new shards, whose policies must be
+// reconciled
+func (s *Sharder) Changed() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.changed
+}
+
+// Start implements manager.Runnable and balances the shards until the context is
+// cancelled, then releases the owned shards
+func (s *Sharder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	s.balance(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			s.releaseAll()
+			s.leave()
+			return nil
+		case <-ticker.C:
+			s.balance(ctx)
+		}
+	}
+}
+
+func (s *Sharder) balance(ctx context.Context) {
+	// The replicas holding no shard count too, so the shards move to the new replicas
+	replicas, err := s.members(ctx)
+	if err != nil {
+		s.Log.Error(err, "unable to count the replicas")
+		return
+	}
+
+	leases := make([]*coordinationv1.Lease, s.Shards)
+	for shard := 0; shard < s.Shards; shard++ {
+		lease := &coordinationv1.Lease{}
+		err := s.Reader.Get(ctx, types.NamespacedName{Name: leaseName(shard), Namespace: s.Namespace}, lease)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			s.Log.Error(err, "unable to read the shard Lease", "shard", shard)
+			return
+		}
+		leases[shard] = lease
+	}
+	// The fair share, rounded up so every shard has an owner
+	share := (s.Shards + replicas - 1) / replicas
+
+	held := 0
+	for shard, lease := range leases {
+		if lease != nil && holder(lease) == s.Identity && !expired(lease) {
+			if held >= share {
+				s.release(ctx, shard, lease)
+				continue
+			}
+			if s.renew(ctx, shard, lease) {
+				held++
+			}
+		}
+	}
+	gained := false
+	for shard, lease := range leases {
+		if held >= share {
+			break
+		}
+		if lease == nil || expired(lease) {
+			if s.renew(ctx, shard, lease) {
+				s.Log.Info("Acquired the shard", "shard", shard, "previousHolder", holder(lease))
+				held++
+				gained = true
+			}
+		}
+	}
+	if gained {
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// renew creates, takes or renews the Lease of the shard
+func (s *Sharder) renew(ctx context.Context, shard int, lease *coordinationv1.Lease) bool {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(s.LeaseDuration.Seconds())
+	var err error
+	if lease == nil {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName(shard), Namespace: s.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		err = s.Client.Create(ctx, lease)
+	} else {
+		lease = lease.DeepCopy()
+		if holder(lease) != s.Identity {
+			lease.Spec.HolderIdentity = &s.Identity
+			lease.Spec.AcquireTime = &now
+			transitions := int32(0)
+			if lease.Spec.LeaseTransitions != nil {
+				transitions = *lease.Spec.LeaseTransitions
+			}
+			transitions++
+			lease.Spec.LeaseTransitions = &transitions
+		}
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &now
+		// The update fails on conflict when another replica changed the Lease
+		err = s.Client.Update(ctx, lease)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owned == nil {
+		s.owned = make(map[int]time.Time)
+	}
+	if err != nil {
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			s.Log.Error(err, "unable to hold the shard Lease", "shard", shard)
+		}
+		delete(s.owned, shard)
+		return false
+	}
+	s.owned[shard] = now.Time
+	return true
+}
+
+// release gives the shard up, clearing the holder of its Lease
+func (s *Sharder) release(ctx context.Context, shard int, lease *coordinationv1.Lease) {
+	s.lock.Lock()
+	delete(s.owned, shard)
+	s.lock.Unlock()
+
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	if err := s.Client.Update(ctx, lease); err != nil {
+		s.Log.Error(err, "unable to release the shard Lease", "shard", shard)
+		return
+	}
+	s.Log.Info("Released the shard above the fair share", "shard", shard)
+}
+
+// releaseAll gives every owned shard up on shutdown, so the other replicas take them
+// without waiting for the Leases to expire
+func (s *Sharder) releaseAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, shard := range s.Owned() {
+		lease := &coordinationv1.Lease{}
+		if err := s.Reader.Get(ctx, types.NamespacedName{Name: leaseName(shard), Namespace: s.Namespace}, lease); err != nil {
+			continue
+		}
+		if holder(lease) == s.Identity {
+			s.release(ctx, shard, lease)
+		}
+	}
+}
+
+// members renews the membership Lease of the replica and returns the number of live
+// replicas, the ones whose membership Lease did not expire
+func (s *Sharder) members(ctx context.Context) (int, error) {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(s.LeaseDuration.Seconds())
+	member := &coordinationv1.Lease{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Name: s.memberName(), Namespace: s.Namespace}, member)
+	switch {
+	case apierrors.IsNotFound(err):
+		member = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.memberName(),
+				Namespace: s.Namespace,
+				Labels:    map[string]string{memberLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		err = s.Client.Create(ctx, member)
+	case err == nil:
+		member.Spec.HolderIdentity = &s.Identity
+		member.Spec.LeaseDurationSeconds = &seconds
+		member.Spec.RenewTime = &now
+		err = s.Client.Update(ctx, member)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	members := &coordinationv1.LeaseList{}
+	if err := s.Reader.List(ctx, members, client.InNamespace(s.Namespace), client.MatchingLabels{memberLabel: "true"}); err != nil {
+		return 0, err
+	}
+	replicas := map[string]bool{s.Identity: true}
+	for i := range members.Items {
+		if !expired(&members.Items[i]) {
+			replicas[holder(&members.Items[i])] = true
+		}
+	}
+	return len(replicas), nil
+}
+
+// leave deletes the membership Lease on shutdown, so the other replicas share the shards
+// without waiting for it to expire
+func (s *Sharder) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	member := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: s.memberName(), Namespace: s.Namespace}}
+	if err := s.Client.Delete(ctx, member); err != nil && !apierrors.IsNotFound(err) {
+		s.Log.Error(err, "unable to delete the membership Lease")
+	}
+}
+
+// memberName returns the name of the membership Lease of the replica, the hash of its
+// identity when the identity is not a valid name
+func (s *Sharder) memberName() string {
+	name := memberPrefix + s.Identity
+	if len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(s.Identity))
+	return fmt.Sprintf("%s%08x", memberPrefix, hash.Sum32())
+}
+
+func leaseName(shard int) string {
+	return fmt.Sprintf("%s%d", leasePrefix, shard)
+}
+
+func holder(lease *coordinationv1.Lease) string {
+	if lease == nil || lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// expired returns true when the Lease has no holder or was not renewed in time
+func expired(lease *coordinationv1.Lease) bool {
+	if holder(lease) == "" || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return time.Since(lease.Spec.RenewTime.Time) > time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second
+}
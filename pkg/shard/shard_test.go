@@ -0,0 +1,121 @@
+package shard
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testShards = 6
+
+func newTestSharder(c client.Client, identity string) *Sharder {
+	return &Sharder{
+		Client:        c,
+		Reader:        c,
+		Log:           logr.Discard(),
+		Shards:        testShards,
+		Identity:      identity,
+		Namespace:     "egress-system",
+		LeaseDuration: 15 * time.Second,
+		changed:       make(chan struct{}, 1),
+	}
+}
+
+// owners returns the holders of the shard Leases
+func owners(t *testing.T, c client.Client) map[string]int {
+	t.Helper()
+	owners := map[string]int{}
+	for shard := 0; shard < testShards; shard++ {
+		lease := &coordinationv1.Lease{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: leaseName(shard), Namespace: "egress-system"}, lease); err != nil {
+			t.Fatal(err)
+		}
+		if !expired(lease) {
+			owners[holder(lease)]++
+		}
+	}
+	return owners
+}
+
+func TestBalanceScaleOut(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	first := newTestSharder(c, "operator-0")
+	second := newTestSharder(c, "operator-1")
+
+	// A single replica holds every shard
+	first.balance(ctx)
+	if owned := first.Owned(); len(owned) != testShards {
+		t.Fatalf("the only replica owns the shards %v", owned)
+	}
+
+	// The second replica holds no shard, it is counted by its membership Lease and the
+	// first one releases the shards above its share
+	for i := 0; i < 2; i++ {
+		second.balance(ctx)
+		first.balance(ctx)
+	}
+	if expected := map[string]int{"operator-0": testShards / 2, "operator-1": testShards / 2}; !reflect.DeepEqual(owners(t, c), expected) {
+		t.Errorf("the shards are held by %v, expected %v", owners(t, c), expected)
+	}
+	if len(first.Owned()) != testShards/2 || len(second.Owned()) != testShards/2 {
+		t.Errorf("the replicas own the shards %v and %v", first.Owned(), second.Owned())
+	}
+	for shard := 0; shard < testShards; shard++ {
+		labels := map[string]string{Label: strconv.Itoa(shard)}
+		if first.Owns("egress", labels) == second.Owns("egress", labels) {
+			t.Errorf("the shard %d is owned by both replicas or by none", shard)
+		}
+	}
+
+	// The balanced shards stay where they are
+	first.balance(ctx)
+	second.balance(ctx)
+	if expected := map[string]int{"operator-0": testShards / 2, "operator-1": testShards / 2}; !reflect.DeepEqual(owners(t, c), expected) {
+		t.Errorf("the shards moved to %v once balanced", owners(t, c))
+	}
+
+	// The shards of the replica that stops go back to the other one
+	second.releaseAll()
+	second.leave()
+	first.balance(ctx)
+	if owned := first.Owned(); len(owned) != testShards {
+		t.Errorf("the last replica owns the shards %v", owned)
+	}
+}
+
+func TestBalanceExpiredMember(t *testing.T) {
+	ctx := context.Background()
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	seconds := int32(15)
+	identity := "operator-1"
+	// A replica that stopped without deleting its membership Lease
+	c := fake.NewClientBuilder().WithObjects(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: memberPrefix + identity, Namespace: "egress-system", Labels: map[string]string{memberLabel: "true"}},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &identity, LeaseDurationSeconds: &seconds, RenewTime: &renewed},
+	}).Build()
+	sharder := newTestSharder(c, "operator-0")
+	sharder.balance(ctx)
+	if owned := sharder.Owned(); len(owned) != testShards {
+		t.Errorf("the replica owns the shards %v, the expired member was counted", owned)
+	}
+}
+
+func TestMemberName(t *testing.T) {
+	if name := (&Sharder{Identity: "operator-7d9f-x2"}).memberName(); name != memberPrefix+"operator-7d9f-x2" {
+		t.Errorf("the membership Lease is %s", name)
+	}
+	name := (&Sharder{Identity: "Operator_0"}).memberName()
+	if name == memberPrefix+"Operator_0" || len(name) != len(memberPrefix)+8 {
+		t.Errorf("the membership Lease of an invalid identity is %s", name)
+	}
+}
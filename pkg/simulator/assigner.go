@@ -0,0 +1,117 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// assigner plays kube-vip: it assigns an IP to every egress Service and announces it
+// from one of the simulated nodes, writing the kube-vip annotation. Its writes use a
+// client not counted in the API calls of the operator.
+type assigner struct {
+	// Reader is the cache of the operator, Writer a direct client
+	Reader client.Reader
+	Writer client.Client
+	Log    logr.Logger
+	Nodes  int
+
+	lock     sync.Mutex
+	services map[string]int
+	shift    int
+}
+
+// NeedLeaderElection returns false, the assigner is not part of the operator
+func (a *assigner) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and assigns the Services until the context is
+// cancelled
+func (a *assigner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.assign(ctx)
+		}
+	}
+}
+
+func (a *assigner) assign(ctx context.Context) {
+	var services corev1.ServiceList
+	if err := a.Reader.List(ctx, &services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		a.Log.Error(err, "unable to list the Services")
+		return
+	}
+	for _, service := range services.Items {
+		index := a.index(service.Namespace + "/" + service.Name)
+		if len(service.Status.LoadBalancer.Ingress) == 0 {
+			patch := client.MergeFrom(service.DeepCopy())
+			service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: ipFor(index)}}
+			if err := a.Writer.Status().Patch(ctx, &service, patch); err != nil {
+				a.Log.Error(err, "unable to assign the IP", "service", service.Name)
+				continue
+			}
+		}
+		node := a.nodeFor(index)
+		if service.Annotations[haegressip.KubeVIPVipHostAnnotation] != node {
+			patch := client.MergeFrom(service.DeepCopy())
+			if service.Annotations == nil {
+				service.Annotations = make(map[string]string)
+			}
+			service.Annotations[haegressip.KubeVIPVipHostAnnotation] = node
+			if err := a.Writer.Patch(ctx, &service, patch); err != nil {
+				a.Log.Error(err, "unable to announce the IP", "service", service.Name)
+			}
+		}
+	}
+}
+
+// failover moves every IP to the next node
+func (a *assigner) failover() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.shift++
+}
+
+// exitNode returns the node announcing the IP of the Service
+func (a *assigner) exitNode(namespace, name string) string {
+	return a.nodeFor(a.index(namespace + "/" + name))
+}
+
+// index returns the sequence number of the Service, given when it is first seen
+func (a *assigner) index(key string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.services == nil {
+		a.services = make(map[string]int)
+	}
+	index, ok := a.services[key]
+	if !ok {
+		index = len(a.services)
+		a.services[key] = index
+	}
+	return index
+}
+
+func (a *assigner) nodeFor(index int) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return fmt.Sprintf("sim-node-%d", (index+a.shift)%a.Nodes)
+}
+
+// ipFor returns the IP of the Service with the given sequence number, from 10.0.0.0/8
+func ipFor(index int) string {
+	index++
+	return fmt.Sprintf("10.%d.%d.%d", (index>>16)&0xff, (index>>8)&0xff, index&0xff)
+}
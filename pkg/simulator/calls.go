@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// callCounter counts the requests sent to the API server, by verb and resource
+type callCounter struct {
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// wrap is used as the WrapTransport of the rest.Config of the operator
+func (c *callCounter) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c.lock.Lock()
+		if c.calls == nil {
+			c.calls = make(map[string]int)
+		}
+		c.calls[requestKind(req)]++
+		c.lock.Unlock()
+		return rt.RoundTrip(req)
+	})
+}
+
+// snapshot returns a copy of the current counts
+func (c *callCounter) snapshot() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	calls := make(map[string]int, len(c.calls))
+	for kind, count := range c.calls {
+		calls[kind] = count
+	}
+	return calls
+}
+
+// since returns the counts after the given snapshot
+func (c *callCounter) since(previous map[string]int) map[string]int {
+	calls := c.snapshot()
+	for kind, count := range previous {
+		calls[kind] -= count
+		if calls[kind] == 0 {
+			delete(calls, kind)
+		}
+	}
+	return calls
+}
+
+// requestKind returns the verb and the resource of the request, e.g. "patch
+// ciliumegressgatewaypolicies" or "update services/status"
+func requestKind(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// Skip /api/v1 and /apis/<group>/<version>
+	switch {
+	case len(segments) >= 2 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) >= 3 && segments[0] == "apis":
+		segments = segments[3:]
+	default:
+		return strings.ToLower(req.Method) + " " + req.URL.Path
+	}
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		segments = segments[2:]
+	}
+	if len(segments) == 0 {
+		return strings.ToLower(req.Method) + " discovery"
+	}
+
+	resource := segments[0]
+	named := len(segments) > 1
+	if len(segments) > 2 {
+		resource += "/" + segments[2]
+	}
+	verb := strings.ToLower(req.Method)
+	switch req.Method {
+	case http.MethodGet:
+		switch {
+		case req.URL.Query().Get("watch") == "true":
+			verb = "watch"
+		case named:
+			verb = "get"
+		default:
+			verb = "list"
+		}
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	}
+	return verb + " " + resource
+}
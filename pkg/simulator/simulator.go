@@ -0,0 +1,456 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulator measures the controllers at scale: it starts an envtest API server,
+// creates thousands of HAEgressGatewayPolicies assigned by a fake kube-vip, and reports
+// the reconcile throughput, the convergence time and the API calls of the operator, so
+// the performance changes can be validated before rolling them out.
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/controllers"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/batch"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/ipam"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// egressNamespace is the namespace of the simulated egress Services
+const egressNamespace = "egress-system"
+
+// Options of a simulation
+type Options struct {
+	Log logr.Logger
+	// CRDDirectory contains the CRD of the HAEgressGatewayPolicies, config/crd/bases
+	CRDDirectory string
+	Policies     int
+	Nodes        int
+	// Workers is the MaxConcurrentReconciles of the controllers
+	Workers int
+	// QPS and Burst of the client of the operator
+	QPS   float32
+	Burst int
+	// PatchWorkers apply the patches in bulk, zero to apply them in the reconciliations
+	PatchWorkers int
+	// Failover moves every egress IP to another node once converged, and measures the
+	// convergence again
+	Failover bool
+	Timeout  time.Duration
+}
+
+// Phase is the measure of the convergence after the creation or the failover
+type Phase struct {
+	Duration            time.Duration      `json:"duration"`
+	Reconciles          map[string]float64 `json:"reconciles"`
+	ReconcilesPerSecond float64            `json:"reconcilesPerSecond"`
+	APICalls            map[string]int     `json:"apiCalls"`
+	APICallsTotal       int                `json:"apiCallsTotal"`
+}
+
+// Report is the result of a simulation
+type Report struct {
+	Policies int    `json:"policies"`
+	Nodes    int    `json:"nodes"`
+	Workers  int    `json:"workers"`
+	Creation Phase  `json:"creation"`
+	Failover *Phase `json:"failover,omitempty"`
+}
+
+// Run starts the API server and the controllers, and measures the convergence of the
+// policies. The envtest binaries are found with KUBEBUILDER_ASSETS, as for the tests.
+func Run(ctx context.Context, options Options) (*Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{options.CRDDirectory},
+		CRDs:                  []*apiextensionsv1.CustomResourceDefinition{ciliumEgressGatewayPolicyCRD()},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := env.Start()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the API server: %w", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			options.Log.Error(err, "unable to stop the API server")
+		}
+	}()
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
+	utilruntime.Must(haegressv2.AddToScheme(scheme))
+
+	// The objects of the simulation are written with a direct client, only the calls of
+	// the operator are counted
+	direct, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	if err := direct.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: egressNamespace}}); err != nil {
+		return nil, err
+	}
+
+	calls := &callCounter{}
+	operatorConfig := rest.CopyConfig(config)
+	operatorConfig.QPS = options.QPS
+	operatorConfig.Burst = options.Burst
+	operatorConfig.Wrap(calls.wrap)
+
+	serviceSelector, err := labels.Parse(haegressip.HAEgressGatewayPolicyName)
+	if err != nil {
+		return nil, err
+	}
+	mgr, err := ctrl.NewManager(operatorConfig, ctrl.Options{
+		Scheme:                 scheme,
+		Cache:                  haegressiputil.CacheOptions(serviceSelector, "kube-system", nil, haegressiputil.NamespaceScope{}),
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		return nil, err
+	}
+	vip, err := setupControllers(mgr, options)
+	if err != nil {
+		return nil, err
+	}
+	vip.Writer = direct
+	vip.Log = options.Log.WithName("assigner")
+	if err := mgr.Add(vip); err != nil {
+		return nil, err
+	}
+
+	managerCtx, stopManager := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := mgr.Start(managerCtx); err != nil {
+			options.Log.Error(err, "unable to run the controllers")
+			cancel()
+		}
+	}()
+	defer func() {
+		stopManager()
+		wg.Wait()
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		return nil, fmt.Errorf("unable to sync the cache")
+	}
+
+	report := &Report{Policies: options.Policies, Nodes: options.Nodes, Workers: options.Workers}
+
+	options.Log.Info("Creating the policies", "policies", options.Policies)
+	previousCalls, previousReconciles := calls.snapshot(), reconciles()
+	start := time.Now()
+	if err := createPolicies(ctx, direct, options.Policies); err != nil {
+		return nil, err
+	}
+	if err := waitConverged(ctx, direct, vip, options); err != nil {
+		return nil, err
+	}
+	report.Creation = measure(time.Since(start), calls.since(previousCalls), previousReconciles)
+	options.Log.Info("Policies converged", "duration", report.Creation.Duration)
+
+	if options.Failover {
+		options.Log.Info("Moving every egress IP to another node")
+		previousCalls, previousReconciles = calls.snapshot(), reconciles()
+		start = time.Now()
+		vip.failover()
+		if err := waitConverged(ctx, direct, vip, options); err != nil {
+			return nil, err
+		}
+		failover := measure(time.Since(start), calls.since(previousCalls), previousReconciles)
+		report.Failover = &failover
+		options.Log.Info("Failover converged", "duration", failover.Duration)
+	}
+
+	return report, nil
+}
+
+// setupControllers sets up the controllers as the operator does with the kube-vip
+// provider, and returns the fake kube-vip
+func setupControllers(mgr ctrl.Manager, options Options) (*assigner, error) {
+	providers, err := provider.NewRegistry(provider.KubeVIPName, &provider.KubeVIP{LoadBalancerClass: "kube-vip.io/kube-vip-class"})
+	if err != nil {
+		return nil, err
+	}
+	allocators, err := ipam.NewRegistry("")
+	if err != nil {
+		return nil, err
+	}
+	syncOptions := haegressiputil.SyncOptions{Providers: providers}
+	if options.PatchWorkers > 0 {
+		syncOptions.Patcher = &batch.Patcher{
+			Client:  mgr.GetClient(),
+			Log:     options.Log.WithName("batch"),
+			Workers: options.PatchWorkers,
+			QPS:     float64(options.QPS),
+			Burst:   options.PatchWorkers,
+		}
+		if err := syncOptions.Patcher.SetupWithManager(mgr); err != nil {
+			return nil, err
+		}
+	}
+	recorder := mgr.GetEventRecorderFor("cilium-haegress-operator")
+
+	if err := (&controllers.HAEgressGatewayPolicyReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     options.Log.WithName("controllers").WithName("HAEgressGatewayPolicy"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                recorder,
+		EgressNamespace:         egressNamespace,
+		SyncOptions:             syncOptions,
+		Allocators:              allocators,
+		MaxConcurrentReconciles: options.Workers,
+	}).SetupWithManager(mgr); err != nil {
+		return nil, err
+	}
+	if err := (&controllers.ServicesController{
+		Client:                  mgr.GetClient(),
+		Log:                     options.Log.WithName("controllers").WithName("Services"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                recorder,
+		CiliumNamespace:         "kube-system",
+		EgressNamespace:         egressNamespace,
+		SyncOptions:             syncOptions,
+		MaxConcurrentReconciles: options.Workers,
+	}).SetupWithManager(mgr); err != nil {
+		return nil, err
+	}
+	return &assigner{Reader: mgr.GetClient(), Nodes: options.Nodes}, nil
+}
+
+// createPolicies creates the policies with a few concurrent writers
+func createPolicies(ctx context.Context, c client.Client, count int) error {
+	const writers = 20
+	errs := make(chan error, writers)
+	for writer := 0; writer < writers; writer++ {
+		go func(writer int) {
+			for i := writer; i < count; i += writers {
+				if err := c.Create(ctx, policy(i)); err != nil {
+					errs <- fmt.Errorf("unable to create the policy %d: %w", i, err)
+					return
+				}
+			}
+			errs <- nil
+		}(writer)
+	}
+	for writer := 0; writer < writers; writer++ {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func policy(index int) *haegressv2.HAEgressGatewayPolicy {
+	name := fmt.Sprintf("sim-%05d", index)
+	return &haegressv2.HAEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: haegressv2.HAEgressGatewayPolicySpec{CiliumEgressGatewayPolicySpec: ciliumv2.CiliumEgressGatewayPolicySpec{
+			Selectors: []ciliumv2.EgressRule{{
+				PodSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{"app": name}},
+			}},
+			DestinationCIDRs: []ciliumv2.IPv4CIDR{"0.0.0.0/0"},
+			EgressGateway: &ciliumv2.EgressGateway{
+				NodeSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{haegressip.NodeNameAnnotation: "none"}},
+			},
+		}},
+	}
+}
+
+// waitConverged waits until every policy has its egress IP and the exit node announced
+// by the fake kube-vip, in its status and in its CiliumEgressGatewayPolicy
+func waitConverged(ctx context.Context, c client.Client, vip *assigner, options Options) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("policies not converged: %w", ctx.Err())
+		case <-ticker.C:
+		}
+
+		converged, err := countConverged(ctx, c, vip)
+		if err != nil {
+			return err
+		}
+		if converged == options.Policies {
+			return nil
+		}
+		if time.Since(lastLog) > 10*time.Second {
+			options.Log.Info("Waiting for the convergence", "converged", converged, "policies", options.Policies)
+			lastLog = time.Now()
+		}
+	}
+}
+
+func countConverged(ctx context.Context, c client.Client, vip *assigner) (int, error) {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return 0, err
+	}
+	var cegps ciliumv2.CiliumEgressGatewayPolicyList
+	if err := c.List(ctx, &cegps); err != nil {
+		return 0, err
+	}
+	byName := make(map[string]*ciliumv2.CiliumEgressGatewayPolicy, len(cegps.Items))
+	for i := range cegps.Items {
+		byName[cegps.Items[i].Name] = &cegps.Items[i]
+	}
+
+	converged := 0
+	for _, policy := range policies.Items {
+		exitNode := vip.exitNode(egressNamespace, policy.Name)
+		if policy.Status.IPAddress == "" || policy.Status.ExitNode != exitNode {
+			continue
+		}
+		cegp, ok := byName[egressNamespace+"-"+policy.Name]
+		if !ok || cegp.Spec.EgressGateway == nil || cegp.Spec.EgressGateway.NodeSelector == nil {
+			continue
+		}
+		if cegp.Spec.EgressGateway.EgressIP == policy.Status.IPAddress &&
+			string(cegp.Spec.EgressGateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation]) == exitNode {
+			converged++
+		}
+	}
+	return converged, nil
+}
+
+// reconciles returns the reconciliations of every controller, from the controller-runtime
+// metrics
+func reconciles() map[string]float64 {
+	counts := map[string]float64{}
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return counts
+	}
+	for _, family := range families {
+		if family.GetName() != "controller_runtime_reconcile_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "controller" {
+					counts[label.GetValue()] += metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func measure(duration time.Duration, calls map[string]int, previousReconciles map[string]float64) Phase {
+	phase := Phase{Duration: duration, Reconciles: reconciles(), APICalls: calls}
+	total := 0.0
+	for controller, count := range phase.Reconciles {
+		phase.Reconciles[controller] = count - previousReconciles[controller]
+		total += phase.Reconciles[controller]
+	}
+	if duration > 0 {
+		phase.ReconcilesPerSecond = total / duration.Seconds()
+	}
+	for _, count := range calls {
+		phase.APICallsTotal += count
+	}
+	return phase
+}
+
+// Print writes the report in a human readable form
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Policies: %d, nodes: %d, workers: %d\n", r.Policies, r.Nodes, r.Workers)
+	r.Creation.print(w, "Creation")
+	if r.Failover != nil {
+		r.Failover.print(w, "Failover")
+	}
+}
+
+func (p *Phase) print(w io.Writer, name string) {
+	fmt.Fprintf(w, "\n%s converged in %s, %.1f reconciles/s, %d API calls\n", name, p.Duration.Round(time.Millisecond), p.ReconcilesPerSecond, p.APICallsTotal)
+	for _, controller := range sortedKeys(p.Reconciles) {
+		fmt.Fprintf(w, "  reconciles %-30s %8.0f\n", controller, p.Reconciles[controller])
+	}
+	for _, kind := range sortedKeys(p.APICalls) {
+		fmt.Fprintf(w, "  %-41s %8d\n", kind, p.APICalls[kind])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ciliumEgressGatewayPolicyCRD returns a minimal CRD of the CiliumEgressGatewayPolicies,
+// without the validation of the Cilium one
+func ciliumEgressGatewayPolicyCRD() *apiextensionsv1.CustomResourceDefinition {
+	preserveUnknownFields := true
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "ciliumegressgatewaypolicies.cilium.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "cilium.io",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "ciliumegressgatewaypolicies",
+				Singular: "ciliumegressgatewaypolicy",
+				Kind:     "CiliumEgressGatewayPolicy",
+				ListKind: "CiliumEgressGatewayPolicyList",
+			},
+			Scope: apiextensionsv1.ClusterScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v2",
+				Served:  true,
+				Storage: true,
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type:                   "object",
+						XPreserveUnknownFields: &preserveUnknownFields,
+					},
+				},
+			}},
+		},
+	}
+}
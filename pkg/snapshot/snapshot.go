@@ -0,0 +1,285 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot persists the assignments of the policies to their egress IP and exit
+// node in a ConfigMap, and uses them after a restart of the operator to verify first the
+// assignments that most likely changed while it was down.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// Staleness of an assignment of the snapshot, the highest is verified first
+const (
+	// Current assignments match the snapshot, they are verified by the routine resync
+	Current = iota
+	// Missing assignments are of the policies created after the snapshot
+	Missing
+	// Changed assignments have a policy status or an IP of the Service different from the snapshot
+	Changed
+	// NodeLost assignments have an exit node removed or not ready
+	NodeLost
+)
+
+// +kubebuilder:rbac:groups="",namespace=egress-system,resources=configmaps,verbs=get;create;patch
+
+// Entry is the assignment of a policy, stored in the key of the policy name
+type Entry struct {
+	EgressIP string `json:"ip,omitempty"`
+	ExitNode string `json:"node,omitempty"`
+}
+
+// Snapshotter writes the snapshot every IntervalSeconds and, when started, queues to
+// Recovery the Services of the stale assignments, the most stale first and then by the
+// priority of the policies. Every policy is a key of the ConfigMap written with a merge
+// patch, so in sharding mode the replicas write the keys of the policies they own without
+// conflicts, and recover the policies of the shards they take.
+type Snapshotter struct {
+	client.Client
+	// Reader is used to read the ConfigMap without caching every ConfigMap of the cluster
+	Reader client.Reader
+	Log    logr.Logger
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+
+	// Namespace of the ConfigMap, and default namespace of the Services
+	Namespace       string
+	ConfigMapName   string
+	IntervalSeconds int
+	Recovery        chan<- event.GenericEvent
+
+	// written is the content of the last patch, to skip the unchanged ones
+	written map[string]string
+	// recovered are the shards whose policies were already recovered
+	recovered map[int]bool
+}
+
+// SetupWithManager registers the snapshotter as a runnable of the Manager.
+func (s *Snapshotter) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica reconciles its
+// own policies
+func (s *Snapshotter) NeedLeaderElection() bool {
+	return s.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Snapshotter) Start(ctx context.Context) error {
+	s.recovered = make(map[int]bool)
+	s.recoverNew(ctx)
+
+	ticker := time.NewTicker(time.Duration(s.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if s.Sharder != nil {
+				s.recoverNew(ctx)
+			}
+			if err := s.write(ctx); err != nil {
+				s.Log.Error(err, "unable to write the assignment snapshot", "ConfigMap", s.ConfigMapName)
+			}
+		}
+	}
+}
+
+// recoverNew recovers the policies not recovered yet: every policy at startup, the ones of
+// the shards taken since the last recovery in sharding mode
+func (s *Snapshotter) recoverNew(ctx context.Context) {
+	var shards map[int]bool
+	if s.Sharder != nil {
+		shards = make(map[int]bool)
+		for _, shard := range s.Sharder.Owned() {
+			shards[shard] = !s.recovered[shard]
+		}
+		s.recovered = make(map[int]bool, len(shards))
+		taken := false
+		for shard, recover := range shards {
+			s.recovered[shard] = true
+			taken = taken || recover
+		}
+		if !taken {
+			return
+		}
+	}
+	if err := s.recover(ctx, shards); err != nil {
+		s.Log.Error(err, "unable to read the assignment snapshot, the policies are verified by the routine resync", "ConfigMap", s.ConfigMapName)
+	}
+}
+
+// recover compares the snapshot with the current state and queues the Services of the
+// stale assignments of the policies of the given shards, nil for every policy
+func (s *Snapshotter) recover(ctx context.Context, shards map[int]bool) error {
+	configMap := &corev1.ConfigMap{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Name: s.ConfigMapName, Namespace: s.Namespace}, configMap)
+	if apierrors.IsNotFound(err) {
+		s.Log.Info("No assignment snapshot found, it is written from now on", "ConfigMap", s.ConfigMapName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.written = configMap.Data
+
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := s.List(ctx, &policies); err != nil {
+		return err
+	}
+	haegressiputil.SortByPriority(policies.Items)
+	type stale struct {
+		service   types.NamespacedName
+		staleness int
+	}
+	var queue []stale
+	counts := map[int]int{}
+	for _, policy := range policies.Items {
+		if shards != nil && !shards[s.Sharder.Shard(policy.Name, policy.Labels)] {
+			continue
+		}
+		var entry *Entry
+		if data, ok := configMap.Data[policy.Name]; ok {
+			entry = &Entry{}
+			if err := json.Unmarshal([]byte(data), entry); err != nil {
+				s.Log.V(1).Info("Ignoring the invalid snapshot entry", "policy", policy.Name, "error", err.Error())
+				entry = nil
+			}
+		}
+		service := types.NamespacedName{Namespace: haegressiputil.ServiceNamespace(&policy, s.Namespace), Name: policy.Name}
+		staleness := s.staleness(ctx, &policy, service, entry)
+		counts[staleness]++
+		if staleness > Current {
+			queue = append(queue, stale{service: service, staleness: staleness})
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].staleness > queue[j].staleness
+	})
+
+	s.Log.Info("Verifying first the stale assignments of the snapshot", "nodeLost", counts[NodeLost],
+		"changed", counts[Changed], "missing", counts[Missing], "current", counts[Current])
+	for _, item := range queue {
+		object := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: item.service.Namespace, Name: item.service.Name}}
+		select {
+		case s.Recovery <- event.GenericEvent{Object: object}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// staleness returns how likely the assignment of the snapshot no longer holds
+func (s *Snapshotter) staleness(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, service types.NamespacedName, entry *Entry) int {
+	if entry == nil {
+		return Missing
+	}
+	if entry.ExitNode != "" {
+		node := &corev1.Node{}
+		if err := s.Get(ctx, types.NamespacedName{Name: entry.ExitNode}, node); err != nil || !haegressiputil.IsNodeReady(node) {
+			return NodeLost
+		}
+	}
+	if policy.Status.IPAddress != entry.EgressIP || policy.Status.ExitNode != entry.ExitNode {
+		return Changed
+	}
+	current := &corev1.Service{}
+	if err := s.Get(ctx, service, current); err != nil {
+		return Changed
+	}
+	ingress := current.Status.LoadBalancer.Ingress
+	if len(ingress) == 0 || ingress[0].IP != entry.EgressIP {
+		return Changed
+	}
+	return Current
+}
+
+// write patches the keys of the changed assignments and removes the keys of the deleted
+// policies
+func (s *Snapshotter) write(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := s.List(ctx, &policies); err != nil {
+		return err
+	}
+
+	current := make(map[string]string, len(policies.Items))
+	data := map[string]interface{}{}
+	for _, policy := range policies.Items {
+		if !s.Sharder.Owns(policy.Name, policy.Labels) {
+			continue
+		}
+		entry, err := json.Marshal(Entry{
+			EgressIP: policy.Status.IPAddress,
+			ExitNode: policy.Status.ExitNode,
+		})
+		if err != nil {
+			return err
+		}
+		current[policy.Name] = string(entry)
+		if s.written[policy.Name] != string(entry) {
+			data[policy.Name] = string(entry)
+		}
+	}
+	// Every replica removes the keys of the deleted policies, not only of the owned ones
+	exists := make(map[string]bool, len(policies.Items))
+	for _, policy := range policies.Items {
+		exists[policy.Name] = true
+	}
+	for name := range s.written {
+		if !exists[name] {
+			data[name] = nil
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.ConfigMapName, Namespace: s.Namespace}}
+	err = s.Patch(ctx, configMap, client.RawPatch(types.MergePatchType, patch))
+	if apierrors.IsNotFound(err) {
+		s.Log.Info("Creating the assignment snapshot ConfigMap", "ConfigMap", s.ConfigMapName)
+		configMap.Data = current
+		err = s.Create(ctx, configMap)
+	}
+	if err != nil {
+		return err
+	}
+	s.Log.V(1).Info("Updated the assignment snapshot", "ConfigMap", s.ConfigMapName, "changed", len(data))
+	s.written = configMap.Data
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package startup delays the readiness of a replica until it has caught up: the caches
+// are synced and, once the replica serves the controllers, every policy was reconciled
+// once. A new leader reports Ready only when the egress assignments it serves reflect
+// the cluster, so the load balancers don't route the API and kubectl traffic to it before.
+package startup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var recoveryDuration = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "haegress_startup_recovery_seconds",
+		Help: "Time from the start of the controllers to the end of the first reconciliation pass of every policy",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(recoveryDuration)
+}
+
+// Barrier is the "recovery" readiness check: it fails until the caches are synced and,
+// after the controllers start, until every policy existing at that time was reconciled
+// or Timeout expired. A nil Barrier records nothing.
+type Barrier struct {
+	Client client.Reader
+	Log    logr.Logger
+	// Timeout bounds the first pass, so a policy that can't be reconciled doesn't keep the
+	// replica not ready forever
+	Timeout time.Duration
+	// LeaderElection waits for the leadership before the first pass, the controllers
+	// start on every replica in sharding mode
+	LeaderElection bool
+
+	elected   <-chan struct{}
+	synced    func(ctx context.Context) bool
+	cacheDone atomic.Bool
+	serving   atomic.Bool
+	recovered atomic.Bool
+
+	lock sync.Mutex
+	// reconciled holds the policies reconciled since the controllers started, pending the
+	// ones still to be reconciled once the policies are listed
+	reconciled map[string]bool
+	pending    map[string]bool
+	done       chan struct{}
+}
+
+// SetupWithManager registers the readiness check and the runnable that follows the first
+// pass, on every replica.
+func (b *Barrier) SetupWithManager(mgr ctrl.Manager) error {
+	b.elected = mgr.Elected()
+	b.synced = mgr.GetCache().WaitForCacheSync
+	b.reconciled = make(map[string]bool)
+	b.done = make(chan struct{})
+	if err := mgr.AddReadyzCheck("recovery", b.Check); err != nil {
+		return err
+	}
+	return mgr.Add(b)
+}
+
+// NeedLeaderElection returns false because the standby replicas report their readiness too
+func (b *Barrier) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and waits for the end of the first pass.
+func (b *Barrier) Start(ctx context.Context) error {
+	if !b.synced(ctx) {
+		return nil
+	}
+	b.cacheDone.Store(true)
+	if b.LeaderElection {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.elected:
+		}
+	}
+	start := time.Now()
+	b.serving.Store(true)
+
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := b.Client.List(ctx, &policies); err != nil {
+		// Without the list the pass can't be followed, the timeout still applies
+		b.Log.Error(err, "unable to list the policies of the first reconciliation pass")
+	}
+	b.lock.Lock()
+	b.pending = make(map[string]bool)
+	for i := range policies.Items {
+		if !b.reconciled[policies.Items[i].Name] {
+			b.pending[policies.Items[i].Name] = true
+		}
+	}
+	b.reconciled = nil
+	b.closeIfDone()
+	b.lock.Unlock()
+	b.Log.Info("Waiting for the first reconciliation pass", "policies", len(policies.Items))
+
+	timeout := time.NewTimer(b.Timeout)
+	defer timeout.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-b.done:
+		b.Log.Info("First reconciliation pass completed, the replica is ready", "duration", time.Since(start).Round(time.Millisecond).String())
+	case <-timeout.C:
+		b.lock.Lock()
+		pending := len(b.pending)
+		b.lock.Unlock()
+		b.Log.Info("First reconciliation pass not completed in time, the replica is ready anyway", "timeout", b.Timeout.String(), "pending", pending)
+	}
+	recoveryDuration.Set(time.Since(start).Seconds())
+	b.recovered.Store(true)
+	return nil
+}
+
+// Reconciled records the reconciliation of the policy, whatever its outcome
+func (b *Barrier) Reconciled(policy string) {
+	if b == nil || b.recovered.Load() {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.pending == nil {
+		if b.reconciled != nil {
+			b.reconciled[policy] = true
+		}
+		return
+	}
+	delete(b.pending, policy)
+	b.closeIfDone()
+}
+
+// closeIfDone ends the pass when no policy is pending, called with the lock held
+func (b *Barrier) closeIfDone() {
+	if len(b.pending) > 0 {
+		return
+	}
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
+
+// Check implements healthz.Checker and fails until the replica caught up
+func (b *Barrier) Check(_ *http.Request) error {
+	switch {
+	case !b.cacheDone.Load():
+		return errors.New("waiting for the caches to sync")
+	case b.serving.Load() && !b.recovered.Load():
+		b.lock.Lock()
+		pending := len(b.pending)
+		b.lock.Unlock()
+		return fmt.Errorf("first reconciliation pass in progress, %d policies pending", pending)
+	}
+	return nil
+}
+
+// Recovered returns true once the replica serving the controllers completed the first
+// pass, always true without a Barrier
+func (b *Barrier) Recovered() bool {
+	return b == nil || b.recovered.Load()
+}
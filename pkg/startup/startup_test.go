@@ -0,0 +1,146 @@
+package startup
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestBarrier returns a Barrier with the policies, set up as by SetupWithManager
+func newTestBarrier(t *testing.T, timeout time.Duration, policies ...string) (*Barrier, chan struct{}) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objects := []client.Object{}
+	for _, policy := range policies {
+		objects = append(objects, &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: policy}})
+	}
+	elected := make(chan struct{})
+	return &Barrier{
+		Client:     fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:        logr.Discard(),
+		Timeout:    timeout,
+		elected:    elected,
+		synced:     func(context.Context) bool { return true },
+		reconciled: make(map[string]bool),
+		done:       make(chan struct{}),
+	}, elected
+}
+
+// waitFor waits until the condition is true, failing the test after a while
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []string
+		// before are reconciled before the policies are listed, after once the pass started
+		before, after  []string
+		leaderElection bool
+		timeout        time.Duration
+	}{
+		{
+			name:    "no policies",
+			timeout: time.Minute,
+		},
+		{
+			name:     "every policy reconciled",
+			policies: []string{"egress-web", "egress-db", "egress-api"},
+			before:   []string{"egress-web"},
+			after:    []string{"egress-db", "egress-api"},
+			timeout:  time.Minute,
+		},
+		{
+			name:           "leader election",
+			policies:       []string{"egress-web", "egress-db", "egress-api"},
+			after:          []string{"egress-web", "egress-db", "egress-api"},
+			leaderElection: true,
+			timeout:        time.Minute,
+		},
+		{
+			name:     "timeout",
+			policies: []string{"egress-web", "egress-db", "egress-api"},
+			after:    []string{"egress-web"},
+			timeout:  200 * time.Millisecond,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			barrier, elected := newTestBarrier(t, test.timeout, test.policies...)
+			barrier.LeaderElection = test.leaderElection
+			if err := barrier.Check(nil); err == nil || err.Error() != "waiting for the caches to sync" {
+				t.Errorf("the check before the cache sync returned %v", err)
+			}
+			for _, policy := range test.before {
+				barrier.Reconciled(policy)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				if err := barrier.Start(ctx); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			if test.leaderElection {
+				// The standby replica is ready, the pass starts with the leadership
+				waitFor(t, "the cache sync", barrier.cacheDone.Load)
+				if err := barrier.Check(nil); err != nil || barrier.Recovered() {
+					t.Errorf("the standby replica returned %v, recovered %t", err, barrier.Recovered())
+				}
+				close(elected)
+			}
+			if len(test.policies) > 0 {
+				waitFor(t, "the list of the policies", func() bool {
+					barrier.lock.Lock()
+					defer barrier.lock.Unlock()
+					return barrier.pending != nil
+				})
+			}
+			if len(test.after) > 0 {
+				if err := barrier.Check(nil); err == nil || !strings.Contains(err.Error(), "first reconciliation pass in progress") {
+					t.Errorf("the check during the pass returned %v", err)
+				}
+			}
+			for _, policy := range test.after {
+				barrier.Reconciled(policy)
+			}
+			<-stopped
+			if !barrier.Recovered() {
+				t.Errorf("the replica did not recover")
+			}
+			if err := barrier.Check(nil); err != nil {
+				t.Errorf("the check after the pass returned %v", err)
+			}
+		})
+	}
+}
+
+func TestNilBarrier(t *testing.T) {
+	var barrier *Barrier
+	barrier.Reconciled("egress-web")
+	if !barrier.Recovered() {
+		t.Errorf("the nil barrier is not recovered")
+	}
+}
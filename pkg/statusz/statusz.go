@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statusz serves a JSON dump of the in-memory view of the controllers, to
+// diagnose why a policy has not converged.
+package statusz
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/preflight"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Path is the path the handler is served on
+const Path = "/statusz"
+
+// Status is the dump served by the handler
+type Status struct {
+	Time time.Time `json:"time"`
+	// Leader is false on the standby replicas, whose view is not updated by the controllers
+	Leader bool `json:"leader"`
+	// Shards are the shards owned by the replica in sharding mode
+	Shards    []int           `json:"shards,omitempty"`
+	Cilium    CiliumStatus    `json:"cilium"`
+	Providers ProvidersStatus `json:"providers"`
+	Policies  []PolicyStatus  `json:"policies"`
+}
+
+// CiliumStatus is the result of the last Cilium preflight check
+type CiliumStatus struct {
+	preflight.Features
+	Error string `json:"error,omitempty"`
+}
+
+// ProvidersStatus lists the configured providers and the number of policies using them
+type ProvidersStatus struct {
+	Default    string         `json:"default"`
+	Registered []string       `json:"registered"`
+	Policies   map[string]int `json:"policies"`
+}
+
+// PolicyStatus is the view of a policy: the status read from the cache and the state
+// kept in memory by the controllers
+type PolicyStatus struct {
+	Name             string    `json:"name"`
+	ServiceNamespace string    `json:"serviceNamespace"`
+	Provider         string    `json:"provider,omitempty"`
+	ProviderError    string    `json:"providerError,omitempty"`
+	Phase            string    `json:"phase"`
+	EgressIP         string    `json:"egressIP,omitempty"`
+	ExitNode         string    `json:"exitNode,omitempty"`
+	LastModifiedTime time.Time `json:"lastModifiedTime,omitempty"`
+	haegressmetrics.PolicyState
+}
+
+// Handler serves the Status, GET /statusz returns every policy and
+// GET /statusz?policy=<name> only the given one
+type Handler struct {
+	Client           client.Reader
+	Log              logr.Logger
+	Providers        *provider.Registry
+	Leadership       *haegressmetrics.Leadership
+	Sharder          *shard.Sharder
+	Cilium           *preflight.Checker
+	DefaultNamespace string
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := h.Client.List(r.Context(), &policies); err != nil {
+		h.Log.Error(err, "unable to list the HAEgressGatewayPolicies for the statusz endpoint")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	status := Status{
+		Time:   time.Now().UTC(),
+		Leader: h.Leadership != nil && h.Leadership.Serving(),
+		Providers: ProvidersStatus{
+			Default:    h.Providers.Default(),
+			Registered: h.Providers.Names(),
+			Policies:   make(map[string]int),
+		},
+		Policies: []PolicyStatus{},
+	}
+	if h.Sharder != nil {
+		status.Shards = h.Sharder.Owned()
+	}
+	if h.Cilium != nil {
+		status.Cilium.Features = h.Cilium.Features()
+		if err := h.Cilium.Err(); err != nil {
+			status.Cilium.Error = err.Error()
+		}
+	}
+
+	name := r.URL.Query().Get("policy")
+	states := haegressmetrics.PolicyStates()
+	for _, policy := range policies.Items {
+		policyStatus := PolicyStatus{
+			Name:             policy.Name,
+			ServiceNamespace: haegressiputil.ServiceNamespace(&policy, h.DefaultNamespace),
+			Phase:            haegressmetrics.PolicyPhase(&policy),
+			EgressIP:         policy.Status.IPAddress,
+			ExitNode:         policy.Status.ExitNode,
+			LastModifiedTime: policy.Status.LastModifiedTime.Time,
+			PolicyState:      states[policy.Name],
+		}
+		if vipProvider, err := h.Providers.ForPolicy(&policy); err != nil {
+			policyStatus.ProviderError = err.Error()
+		} else {
+			policyStatus.Provider = vipProvider.Name()
+			status.Providers.Policies[vipProvider.Name()]++
+		}
+		if name == "" || name == policy.Name {
+			status.Policies = append(status.Policies, policyStatus)
+		}
+	}
+	if name != "" && len(status.Policies) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(status)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stream streams the egress change events to the gRPC clients, that can
+// resume a stream from the last event they received.
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+)
+
+var (
+	// ErrTokenExpired is returned when the resume token is older than the retained events
+	ErrTokenExpired = errors.New("resume token expired, the events are no longer retained")
+	// ErrEpochMismatch is returned when the resume token was issued by another leader, whose
+	// sequence numbers are unrelated to the ones of the Broker
+	ErrEpochMismatch = errors.New("resume token issued by another leader, the events are no longer retained")
+	// ErrInvalidToken is returned when the resume token is malformed
+	ErrInvalidToken = errors.New("invalid resume token")
+)
+
+// Entry is an event with its sequence number, the resume token is the epoch of the Broker
+// followed by the sequence number
+type Entry struct {
+	Sequence uint64
+	Event    notify.Event
+}
+
+// Subscription receives the live events, it is dropped when its buffer is full
+type Subscription struct {
+	events  chan Entry
+	dropped chan struct{}
+}
+
+// Broker retains the last events and fans them out to the subscribers. Publishing never
+// blocks: a subscriber that does not keep up is disconnected, and can resume from the
+// last event it received while it is retained.
+type Broker struct {
+	// Epoch identifies the leader issuing the tokens, e.g. its start time: the sequence
+	// numbers restart from one with every leader
+	Epoch string
+	// History is the number of retained events
+	History int
+	// Buffer is the number of events queued for a subscriber
+	Buffer int
+
+	lock        sync.Mutex
+	sequence    uint64
+	history     []Entry
+	subscribers map[*Subscription]struct{}
+}
+
+// Publish implements notify.Sink
+func (b *Broker) Publish(event notify.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sequence++
+	entry := Entry{Sequence: b.sequence, Event: event}
+	b.history = append(b.history, entry)
+	if len(b.history) > b.History {
+		b.history = b.history[len(b.history)-b.History:]
+	}
+	for s := range b.subscribers {
+		select {
+		case s.events <- entry:
+		default:
+			close(s.dropped)
+			delete(b.subscribers, s)
+		}
+	}
+}
+
+// Token returns the resume token of the entry
+func (b *Broker) Token(entry Entry) string {
+	return b.Epoch + ":" + strconv.FormatUint(entry.Sequence, 10)
+}
+
+// Subscribe returns the retained events after the one of the resume token, none for an
+// empty token, and registers a subscriber for the next ones. The returned function
+// unregisters it.
+func (b *Broker) Subscribe(token string) ([]Entry, *Subscription, func(), error) {
+	after := uint64(0)
+	if token != "" {
+		epoch, sequence, found := strings.Cut(token, ":")
+		if !found {
+			return nil, nil, nil, fmt.Errorf("%w %q", ErrInvalidToken, token)
+		}
+		var err error
+		if after, err = strconv.ParseUint(sequence, 10, 64); err != nil || after == 0 {
+			return nil, nil, nil, fmt.Errorf("%w %q", ErrInvalidToken, token)
+		}
+		if epoch != b.Epoch {
+			return nil, nil, nil, ErrEpochMismatch
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	replay := []Entry{}
+	if after > 0 {
+		if after > b.sequence {
+			return nil, nil, nil, fmt.Errorf("%w %q, the last event is %d", ErrInvalidToken, token, b.sequence)
+		}
+		if after < b.sequence && (len(b.history) == 0 || b.history[0].Sequence > after+1) {
+			return nil, nil, nil, ErrTokenExpired
+		}
+		for _, entry := range b.history {
+			if entry.Sequence > after {
+				replay = append(replay, entry)
+			}
+		}
+	}
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[*Subscription]struct{})
+	}
+	s := &Subscription{
+		events:  make(chan Entry, b.Buffer),
+		dropped: make(chan struct{}),
+	}
+	b.subscribers[s] = struct{}{}
+	return replay, s, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscribers, s)
+	}, nil
+}
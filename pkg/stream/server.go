@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// The service is defined with the well-known protobuf types, so no generated code is
+// needed by the clients:
+//
+//	service EgressEvents {
+//	  // Watch streams the egress change events, the request is the resume token of the
+//	  // last received event, empty to receive only the new events
+//	  rpc Watch(google.protobuf.StringValue) returns (stream google.protobuf.Struct);
+//	}
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "haegress.v1.EgressEvents",
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "haegress/v1/events.proto",
+}
+
+// Server serves the egress change events published in the Broker
+type Server struct {
+	Broker      *Broker
+	Log         logr.Logger
+	BindAddress string
+}
+
+// SetupWithManager registers the server as a leader-only runnable of the Manager, the
+// events are generated by the leader.
+func (s *Server) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&serviceDesc, s)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	s.Log.Info("Starting the egress events stream", "address", s.BindAddress)
+	return server.Serve(listener)
+}
+
+func watchHandler(srv any, stream grpc.ServerStream) error {
+	request := &wrapperspb.StringValue{}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return srv.(*Server).watch(request.GetValue(), stream)
+}
+
+func (s *Server) watch(token string, stream grpc.ServerStream) error {
+	replay, subscriber, cancel, err := s.Broker.Subscribe(token)
+	if errors.Is(err, ErrInvalidToken) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	defer cancel()
+
+	for _, entry := range replay {
+		if err := s.send(stream, entry); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-subscriber.dropped:
+			return status.Error(codes.ResourceExhausted, "the client is too slow, resume the stream from the last received token")
+		case entry := <-subscriber.events:
+			if err := s.send(stream, entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) send(stream grpc.ServerStream, entry Entry) error {
+	message, err := structpb.NewStruct(map[string]any{
+		"resumeToken":      s.Broker.Token(entry),
+		"type":             string(entry.Event.Type),
+		"policy":           entry.Event.Policy,
+		"cluster":          entry.Event.Cluster,
+		"egressIP":         entry.Event.EgressIP,
+		"exitNode":         entry.Event.ExitNode,
+		"previousExitNode": entry.Event.PreviousExitNode,
+		"message":          entry.Event.Message,
+		"time":             entry.Event.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(message)
+}
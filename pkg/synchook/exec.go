@@ -0,0 +1,39 @@
+package synchook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ExecHook runs a plugin with the Payload, as JSON, on its standard input. The plugin
+// must exit with a zero code when the external system is in sync.
+type ExecHook struct {
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+func (h *ExecHook) run(ctx context.Context, payload *Payload) error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("no command configured")
+	}
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = os.Environ()
+	for name, value := range h.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
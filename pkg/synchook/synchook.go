@@ -0,0 +1,248 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package synchook runs the sync hooks, external programs, HTTP endpoints or templated
+// manifests, whenever the mapping of the egress IPs to the source identities changes,
+// to keep external systems like the enterprise firewalls in lockstep with the cluster.
+package synchook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Entry is an egress IP with the identity of the pods using it
+type Entry struct {
+	EgressIP string `json:"egressIP"`
+	Policy   string `json:"policy"`
+	// Namespaces are the namespaces of the pods whose traffic leaves with the egress IP
+	Namespaces []string `json:"namespaces"`
+}
+
+// Payload is passed to every hook, it holds the whole desired state, so the hooks can
+// be idempotent, and the changes since the last successful run of the hook
+type Payload struct {
+	Cluster string    `json:"cluster,omitempty"`
+	Entries []Entry   `json:"entries"`
+	Added   []Entry   `json:"added"`
+	Removed []Entry   `json:"removed"`
+	Time    time.Time `json:"time"`
+}
+
+// Hook is a sync hook, exactly one of Exec, HTTP and Template must be set
+type Hook struct {
+	Name     string        `json:"name"`
+	Exec     *ExecHook     `json:"exec,omitempty"`
+	HTTP     *HTTPHook     `json:"http,omitempty"`
+	Template *TemplateHook `json:"template,omitempty"`
+	// TimeoutSeconds limits a single run of the hook, 60 seconds when zero
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	runner runner
+	// synced is the state successfully pushed by the hook
+	synced []Entry
+}
+
+// runner is implemented by every kind of hook
+type runner interface {
+	run(ctx context.Context, payload *Payload) error
+}
+
+// Config is the content of the sync hooks configuration file
+type Config struct {
+	Hooks []Hook `json:"hooks"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the sync hooks
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for i := range config.Hooks {
+		hook := &config.Hooks[i]
+		kinds := 0
+		if hook.Exec != nil {
+			kinds++
+			hook.runner = hook.Exec
+		}
+		if hook.HTTP != nil {
+			kinds++
+			hook.runner = hook.HTTP
+		}
+		if hook.Template != nil {
+			kinds++
+			if err := hook.Template.parse(hook.Name); err != nil {
+				return nil, fmt.Errorf("invalid template of the hook %q: %w", hook.Name, err)
+			}
+			hook.runner = hook.Template
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("the hook %q must set exactly one of exec, http and template", hook.Name)
+		}
+		if hook.TimeoutSeconds == 0 {
+			hook.TimeoutSeconds = 60
+		}
+	}
+	return config, nil
+}
+
+// Runner periodically builds the egress IP mappings and runs the hooks whose last
+// successful run pushed a different state. A failed hook is run again at the next
+// interval.
+type Runner struct {
+	client.Client
+	Log    logr.Logger
+	Config *Config
+
+	Cluster          string
+	DefaultNamespace string
+	IntervalSeconds  int
+	// ReadOnly, when set, receives the payloads of the exec and HTTP hooks instead of the
+	// hooks, like readonly.Report.Record. The template hooks write with the client.
+	ReadOnly func(verb, kind, namespace, name string, data []byte)
+}
+
+// SetupWithManager registers the runner as a leader-only runnable of the Manager.
+func (r *Runner) SetupWithManager(mgr ctrl.Manager) error {
+	for i := range r.Config.Hooks {
+		if template := r.Config.Hooks[i].Template; template != nil {
+			template.client = mgr.GetClient()
+		}
+	}
+	return mgr.Add(r)
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (r *Runner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(r.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.sync(ctx); err != nil {
+				r.Log.Error(err, "unable to build the egress mappings for the sync hooks")
+			}
+		}
+	}
+}
+
+func (r *Runner) sync(ctx context.Context) error {
+	mappings, err := mapping.List(ctx, r.Client, r.DefaultNamespace)
+	if err != nil {
+		return err
+	}
+	entries := Entries(mappings)
+
+	for i := range r.Config.Hooks {
+		hook := &r.Config.Hooks[i]
+		if hook.synced != nil && reflect.DeepEqual(hook.synced, entries) {
+			continue
+		}
+		added, removed := diff(hook.synced, entries)
+		payload := &Payload{
+			Cluster: r.Cluster,
+			Entries: entries,
+			Added:   added,
+			Removed: removed,
+			Time:    time.Now().UTC(),
+		}
+		if r.ReadOnly != nil && hook.Template == nil {
+			data, _ := json.Marshal(payload)
+			r.ReadOnly("Run", "SyncHook", "", hook.Name, data)
+			hook.synced = entries
+			continue
+		}
+		hookCtx, cancel := context.WithTimeout(ctx, time.Duration(hook.TimeoutSeconds)*time.Second)
+		err := hook.runner.run(hookCtx, payload)
+		cancel()
+		if err != nil {
+			r.Log.Error(err, "sync hook failed", "hook", hook.Name)
+			continue
+		}
+		r.Log.Info("Sync hook completed", "hook", hook.Name, "added", len(added), "removed", len(removed))
+		hook.synced = entries
+	}
+	return nil
+}
+
+// Entries returns the assigned egress IPs of the mappings, sorted by IP
+func Entries(mappings []mapping.Mapping) []Entry {
+	entries := []Entry{}
+	for _, m := range mappings {
+		if m.EgressIP == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			EgressIP:   m.EgressIP,
+			Policy:     m.Policy,
+			Namespaces: m.Namespaces,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EgressIP != entries[j].EgressIP {
+			return entries[i].EgressIP < entries[j].EgressIP
+		}
+		return entries[i].Policy < entries[j].Policy
+	})
+	return entries
+}
+
+// diff returns the entries added and removed from previous to current, a changed entry
+// is both removed and added
+func diff(previous, current []Entry) ([]Entry, []Entry) {
+	key := func(e Entry) string {
+		data, _ := json.Marshal(e)
+		return string(data)
+	}
+	previousKeys := map[string]bool{}
+	for _, e := range previous {
+		previousKeys[key(e)] = true
+	}
+	currentKeys := map[string]bool{}
+	added := []Entry{}
+	for _, e := range current {
+		currentKeys[key(e)] = true
+		if !previousKeys[key(e)] {
+			added = append(added, e)
+		}
+	}
+	removed := []Entry{}
+	for _, e := range previous {
+		if !currentKeys[key(e)] {
+			removed = append(removed, e)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,313 @@
+package synchook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/mapping"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		timeout int
+		fails   string
+	}{
+		{
+			name:    "exec hook with the default timeout",
+			config:  "hooks:\n- name: firewall\n  exec:\n    command: [/bin/sync-firewall]\n",
+			timeout: 60,
+		},
+		{
+			name:    "HTTP hook",
+			config:  "hooks:\n- name: ipam\n  http:\n    url: https://ipam.example.com/egress\n  timeoutSeconds: 5\n",
+			timeout: 5,
+		},
+		{
+			name:    "template hook",
+			config:  "hooks:\n- name: rules\n  template:\n    template: \"{{ range .Entries }}{{ .EgressIP }}{{ end }}\"\n",
+			timeout: 60,
+		},
+		{
+			name:   "hook without a kind",
+			config: "hooks:\n- name: firewall\n",
+			fails:  "must set exactly one of exec, http and template",
+		},
+		{
+			name:   "hook with two kinds",
+			config: "hooks:\n- name: firewall\n  exec:\n    command: [/bin/sync-firewall]\n  http:\n    url: https://ipam.example.com\n",
+			fails:  "must set exactly one of exec, http and template",
+		},
+		{
+			name:   "invalid template",
+			config: "hooks:\n- name: rules\n  template:\n    template: \"{{ range .Entries }}\"\n",
+			fails:  "invalid template of the hook \"rules\"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "synchooks.yaml")
+			if err := os.WriteFile(path, []byte(test.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if test.fails != "" {
+				if err == nil || !strings.Contains(err.Error(), test.fails) {
+					t.Errorf("the configuration returned %v, expected %q", err, test.fails)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			hook := config.Hooks[0]
+			if hook.runner == nil || hook.TimeoutSeconds != test.timeout {
+				t.Errorf("the hook has the runner %v and the timeout %d, expected %d", hook.runner, hook.TimeoutSeconds, test.timeout)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	web := Entry{EgressIP: "10.0.0.7", Policy: "egress-web", Namespaces: []string{"web"}}
+	db := Entry{EgressIP: "10.0.0.8", Policy: "egress-db", Namespaces: []string{"db"}}
+	movedWeb := Entry{EgressIP: "10.0.0.9", Policy: "egress-web", Namespaces: []string{"web"}}
+	tests := []struct {
+		name              string
+		previous, current []Entry
+		added, removed    []Entry
+	}{
+		{
+			name:    "first run",
+			current: []Entry{web, db},
+			added:   []Entry{web, db},
+			removed: []Entry{},
+		},
+		{
+			name:     "unchanged",
+			previous: []Entry{web, db},
+			current:  []Entry{web, db},
+			added:    []Entry{},
+			removed:  []Entry{},
+		},
+		{
+			name:     "changed egress IP",
+			previous: []Entry{web, db},
+			current:  []Entry{db, movedWeb},
+			added:    []Entry{movedWeb},
+			removed:  []Entry{web},
+		},
+		{
+			name:     "deleted policy",
+			previous: []Entry{web, db},
+			current:  []Entry{db},
+			added:    []Entry{},
+			removed:  []Entry{web},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed := diff(test.previous, test.current)
+			if !reflect.DeepEqual(added, test.added) || !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("the diff added %v and removed %v, expected %v and %v", added, removed, test.added, test.removed)
+			}
+		})
+	}
+}
+
+func TestEntries(t *testing.T) {
+	entries := Entries([]mapping.Mapping{
+		{Policy: "egress-web", EgressIP: "10.0.0.8"},
+		{Policy: "egress-pending"},
+		{Policy: "egress-db", EgressIP: "10.0.0.7"},
+		{Policy: "egress-api", EgressIP: "10.0.0.8"},
+	})
+	expected := []Entry{
+		{EgressIP: "10.0.0.7", Policy: "egress-db"},
+		{EgressIP: "10.0.0.8", Policy: "egress-api"},
+		{EgressIP: "10.0.0.8", Policy: "egress-web"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("the entries are %v, expected %v", entries, expected)
+	}
+}
+
+func TestHTTPHook(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		fails  bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "rejected", status: http.StatusConflict, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received *Payload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("the hook sent %s with the headers %v", r.Method, r.Header)
+				}
+				body, _ := io.ReadAll(r.Body)
+				received = &Payload{}
+				if err := json.Unmarshal(body, received); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte("rule conflict\n"))
+			}))
+			defer server.Close()
+
+			hook := &HTTPHook{URL: server.URL, Method: http.MethodPut, Headers: map[string]string{"Authorization": "Bearer token"}}
+			payload := &Payload{Cluster: "prod", Entries: []Entry{{EgressIP: "10.0.0.7", Policy: "egress-web"}}}
+			err := hook.run(context.Background(), payload)
+			if test.fails {
+				if err == nil || !strings.Contains(err.Error(), "status 409: rule conflict") {
+					t.Errorf("the rejected payload returned %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received == nil || received.Cluster != "prod" || len(received.Entries) != 1 {
+				t.Errorf("the endpoint received %v", received)
+			}
+		})
+	}
+}
+
+func TestTemplateHook(t *testing.T) {
+	applied := []string{}
+	c := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(_ context.Context, _ client.WithWatch, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+			object := obj.(*unstructured.Unstructured)
+			applied = append(applied, string(patch.Type())+" "+object.GetKind()+" "+object.GetName())
+			return nil
+		},
+	}).Build()
+	hook := &TemplateHook{Template: `{{ range .Entries }}
+---
+apiVersion: firewall.example.com/v1
+kind: AddressObject
+metadata:
+  name: {{ .Policy }}
+spec:
+  address: {{ .EgressIP }}
+{{ end }}---
+`, client: c}
+	if err := hook.parse("rules"); err != nil {
+		t.Fatal(err)
+	}
+	payload := &Payload{Entries: []Entry{{EgressIP: "10.0.0.7", Policy: "egress-web"}, {EgressIP: "10.0.0.8", Policy: "egress-db"}}}
+	if err := hook.run(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"application/apply-patch+yaml AddressObject egress-web", "application/apply-patch+yaml AddressObject egress-db"}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("the hook applied %v, expected %v", applied, expected)
+	}
+}
+
+// testHook counts the runs of a hook, failing the runs while fail is set
+type testHook struct {
+	runs int
+	fail bool
+}
+
+func (h *testHook) run(_ context.Context, _ *Payload) error {
+	h.runs++
+	if h.fail {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func TestRunnerSync(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := haegressv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "egress-web"}}
+	policy.Spec.Selectors = []ciliumv2.EgressRule{{PodSelector: &slimv1.LabelSelector{
+		MatchLabels: map[string]slimv1.MatchLabelsValue{"io.kubernetes.pod.namespace": "web"},
+	}}}
+	policy.Status.IPAddress = "10.0.0.7"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).Build()
+
+	failing := &testHook{fail: true}
+	healthy := &testHook{}
+	runner := &Runner{
+		Client: c,
+		Log:    logr.Discard(),
+		Config: &Config{Hooks: []Hook{
+			{Name: "failing", TimeoutSeconds: 60, runner: failing},
+			{Name: "healthy", TimeoutSeconds: 60, runner: healthy},
+		}},
+		DefaultNamespace: "egress-system",
+	}
+
+	// The failed hook is run again at every interval, until it succeeds
+	for i := 0; i < 2; i++ {
+		if err := runner.sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failing.runs != 2 || healthy.runs != 1 {
+		t.Errorf("the failing hook ran %d times and the healthy one %d times, expected 2 and 1", failing.runs, healthy.runs)
+	}
+	expected := []Entry{{EgressIP: "10.0.0.7", Policy: "egress-web", Namespaces: []string{"web"}}}
+	if !reflect.DeepEqual(runner.Config.Hooks[1].synced, expected) {
+		t.Errorf("the healthy hook synced %v, expected %v", runner.Config.Hooks[1].synced, expected)
+	}
+
+	// In read-only mode the payloads are reported instead of running the hooks
+	policy.Status.IPAddress = "10.0.0.9"
+	if err := c.Update(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]*Payload{}
+	runner.ReadOnly = func(verb, kind, namespace, name string, data []byte) {
+		if verb != "Run" || kind != "SyncHook" {
+			t.Errorf("the report received %s %s", verb, kind)
+		}
+		payloads[name] = &Payload{}
+		if err := json.Unmarshal(data, payloads[name]); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := runner.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if failing.runs != 2 || healthy.runs != 1 {
+		t.Errorf("the hooks ran in read-only mode")
+	}
+	payload := payloads["healthy"]
+	if payload == nil || len(payload.Added) != 1 || payload.Added[0].EgressIP != "10.0.0.9" || len(payload.Removed) != 1 {
+		t.Errorf("the report of the healthy hook is %v", payload)
+	}
+}
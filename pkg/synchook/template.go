@@ -0,0 +1,68 @@
+package synchook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldOwner is the field manager of the objects applied by the template hooks
+const FieldOwner = "cilium-haegress-operator-synchook"
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// TemplateHook renders a text/template, executed with the Payload, into one or more
+// YAML manifests and applies them with server-side apply, for example as the custom
+// resources of a firewall operator. The operator needs the RBAC permissions to patch
+// the rendered kinds.
+type TemplateHook struct {
+	Template string `json:"template"`
+
+	template *template.Template
+	client   client.Client
+}
+
+var templateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"lower":   strings.ToLower,
+	"replace": strings.ReplaceAll,
+}
+
+func (h *TemplateHook) parse(name string) error {
+	parsed, err := template.New(name).Funcs(templateFuncs).Parse(h.Template)
+	if err != nil {
+		return err
+	}
+	h.template = parsed
+	return nil
+}
+
+func (h *TemplateHook) run(ctx context.Context, payload *Payload) error {
+	var rendered bytes.Buffer
+	if err := h.template.Execute(&rendered, payload); err != nil {
+		return err
+	}
+	for _, document := range documentSeparator.Split(rendered.String(), -1) {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		object := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(document), &object.Object); err != nil {
+			return err
+		}
+		if len(object.Object) == 0 {
+			continue
+		}
+		if err := h.client.Patch(ctx, object, client.Apply, client.FieldOwner(FieldOwner), client.ForceOwnership); err != nil {
+			return fmt.Errorf("unable to apply %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+	}
+	return nil
+}
@@ -6,10 +6,121 @@ const (
 	HAEgressGatewayPolicyNamespace       = "cilium.angeloxx.ch/haegressgatewaypolicy-namespace"
 	HAEgressGatewayPolicyName            = "cilium.angeloxx.ch/haegressgatewaypolicy-name"
 	NodeNameAnnotation                   = "kubernetes.io/hostname"
-	EventEgressUpdateReason              = "Updated"
 	KubeVIPVipHostAnnotation             = "kube-vip.io/vipHost"
 	KubernetesServiceProxyNameAnnotation = "service.kubernetes.io/service-proxy-name"
+	EgressInterfaceAnnotation            = "cilium.angeloxx.ch/egress-interface"
+	EgressInterfaceAuto                  = "auto"
+	EgressInterfacesAnnotation           = "cilium.angeloxx.ch/egress-interfaces"
+	EgressGatewayGroupSizeAnnotation     = "cilium.angeloxx.ch/egress-gateway-group-size"
+	ClusterMeshLocalOnlyAnnotation       = "cilium.angeloxx.ch/clustermesh-local-only"
+	ClusterNameAnnotation                = "cilium.angeloxx.ch/cluster-name"
+	CiliumClusterLabel                   = "io.cilium.k8s.policy.cluster"
+	PodNamespaceLabel                    = "io.kubernetes.pod.namespace"
+	ProviderAnnotation                   = "cilium.angeloxx.ch/provider"
+	StaticEgressIPAnnotation             = "cilium.angeloxx.ch/egress-ip"
+	StaticExitNodeAnnotation             = "cilium.angeloxx.ch/exit-node"
+	CiliumL2AnnounceLeasePrefix          = "cilium-l2announce-"
+	KubeVIPServiceLeasePrefix            = "kubevip-"
+	MetalLBServiceNameLabel              = "metallb.io/service-name"
+	MetalLBServiceNamespaceLabel         = "metallb.io/service-namespace"
+	KubeVIPLoadBalancerIPsAnnotation     = "kube-vip.io/loadbalancerIPs"
+	CiliumLBIPAMIPsAnnotation            = "io.cilium/lb-ipam-ips"
+	MetalLBLoadBalancerIPsAnnotation     = "metallb.universe.tf/loadBalancerIPs"
+	IPAMAnnotation                       = "cilium.angeloxx.ch/ipam"
+	IPAMNone                             = "none"
+	InfobloxNetworkAnnotation            = "cilium.angeloxx.ch/infoblox-network"
+	AWSEIPAllocationIDAnnotation         = "cilium.angeloxx.ch/aws-eip-allocation-id"
+	OpenStackFloatingIPAnnotation        = "cilium.angeloxx.ch/openstack-floating-ip"
+	IPAMAllocatedIPAnnotation            = "cilium.angeloxx.ch/ipam-allocated-ip"
+	IPAMAllocatorAnnotation              = "cilium.angeloxx.ch/ipam-allocator"
+	IPAMReleaseFinalizer                 = "cilium.angeloxx.ch/ipam-release"
+	ServiceProtectionFinalizer           = "cilium.angeloxx.ch/egress-service-protection"
+	DrainFinalizer                       = "cilium.angeloxx.ch/drain"
+	DrainingSinceAnnotation              = "cilium.angeloxx.ch/draining-since"
+	AllowEgressDeletionAnnotation        = "cilium.angeloxx.ch/allow-egress-deletion"
+	PausedAnnotation                     = "cilium.angeloxx.ch/paused"
+	PreferredExitNodeAnnotation          = "cilium.angeloxx.ch/preferred-exit-node"
+	EgressDrainedNodeLabel               = "cilium.angeloxx.ch/egress-drained"
+	DisruptionGroupAnnotation            = "cilium.angeloxx.ch/disruption-group"
+	SkipChildrenAnnotation               = "cilium.angeloxx.ch/skip-children"
+	DrillAnnotation                      = "cilium.angeloxx.ch/drill"
+	DrillAvoidNodeAnnotation             = "cilium.angeloxx.ch/drill-avoid-node"
+	ManagedMetadataAnnotation            = "cilium.angeloxx.ch/managed-metadata"
+	FieldManager                         = "cilium-haegress-operator"
+	IPFamilyPolicyAnnotation             = "cilium.angeloxx.ch/ip-family-policy"
+	IPFamiliesAnnotation                 = "cilium.angeloxx.ch/ip-families"
+	EgressCapacityAnnotation             = "cilium.angeloxx.ch/egress-capacity"
+	TargetKindAnnotation                 = "cilium.angeloxx.ch/target-kind"
+	MaxGatewayNodesAnnotation            = "cilium.angeloxx.ch/max-gateway-nodes"
+	LoadBalancerClassAnnotation          = "cilium.angeloxx.ch/load-balancer-class"
+	FanOutNamespaceLabel                 = "cilium.angeloxx.ch/fan-out-namespace"
+	AllowListGroupLabel                  = "cilium.angeloxx.ch/tenant"
+	// The defaults of the policies of the Service namespace, in the annotations of the
+	// Namespace
+	DefaultProviderAnnotation          = "cilium.angeloxx.ch/default-provider"
+	DefaultIPAMAnnotation              = "cilium.angeloxx.ch/default-ipam"
+	DefaultLoadBalancerClassAnnotation = "cilium.angeloxx.ch/default-load-balancer-class"
+	DefaultEligibleNodesAnnotation     = "cilium.angeloxx.ch/default-eligible-nodes"
 
 	LeaseCheckRequeueAfter                 = 10 * time.Second
 	HAEgressGatewayPolicyChcekRequeueAfter = 10 * time.Second
+	// ProvisioningStalledAfter is the time a Service can wait for its egress IP before the
+	// ProvisioningStalled event
+	ProvisioningStalledAfter = 2 * time.Minute
+)
+
+// Reasons of the Kubernetes events recorded by the operator, documented in the README.
+// The events about a change of a policy carry the policy, the field and the old and new
+// values in their annotations.
+const (
+	// Created and Updated are the generated objects created or updated from the policy
+	EventCreatedReason = "Created"
+	EventUpdatedReason = "Updated"
+	// IPAssigned is the egress IP assigned by the provider applied to the policy
+	EventIPAssignedReason = "IPAssigned"
+	// ExitNodeChanged is the exit node reported by the provider applied to the policy
+	EventExitNodeChangedReason = "ExitNodeChanged"
+	// ConflictDetected is a generated object with the name of another object, not managed
+	EventConflictDetectedReason = "ConflictDetected"
+	// ProvisioningStalled is a Service still without egress IP after ProvisioningStalledAfter
+	EventProvisioningStalledReason = "ProvisioningStalled"
+	// DriftCorrected is a generated object changed outside of the operator and corrected
+	EventDriftCorrectedReason = "DriftCorrected"
+	// The other reasons of the events, see the README
+	EventEgressIPNotRoutableReason       = "EgressIPNotRoutable"
+	EventEgressInterfaceUnknownReason    = "EgressInterfaceUnknown"
+	EventIPAMAllocatedReason             = "IPAMAllocated"
+	EventIPAMFailedReason                = "IPAMFailed"
+	EventNamespaceNotWatchedReason       = "NamespaceNotWatched"
+	EventBindingRestoredReason           = "BindingRestored"
+	EventBindingNotRestoredReason        = "BindingNotRestored"
+	EventSourceNamespaceNotAllowedReason = "SourceNamespaceNotAllowed"
+	EventInvalidValueReason              = "InvalidValue"
+	EventNamespaceNotAllowedReason       = "NamespaceNotAllowed"
+	EventNamespaceTerminatingReason      = "NamespaceTerminating"
+	EventChildNotFoundReason             = "ChildNotFound"
+	EventRetargetedReason                = "Retargeted"
+	EventFQDNUnresolvedReason            = "FQDNUnresolved"
+	EventProviderRangesUnresolvedReason  = "ProviderRangesUnresolved"
+	EventInconsistentStateReason         = "InconsistentState"
+	EventInconsistencyRepairedReason     = "InconsistencyRepaired"
+	EventNoEligibleNodeReason            = "NoEligibleNode"
+	EventCiliumFeatureMissingReason      = "CiliumFeatureMissing"
+	EventStaleClaimReason                = "StaleClaim"
+	EventOrphanNodeSelectorReason        = "OrphanNodeSelector"
+	EventDrillStartedReason              = "DrillStarted"
+	EventDrillPassedReason               = "DrillPassed"
+	EventDrillFailedReason               = "DrillFailed"
+	EventSelectorTooBroadReason          = "SelectorTooBroad"
+	EventIPReclaimableReason             = "IPReclaimable"
+	EventFanOutRemovedReason             = "FanOutRemoved"
+	EventConflictingControllerReason     = "ConflictingController"
+	EventDrainingReason                  = "Draining"
+)
+
+// Annotations of the events, the policy is in the HAEgressGatewayPolicyName annotation
+const (
+	EventFieldAnnotation    = "cilium.angeloxx.ch/field"
+	EventOldValueAnnotation = "cilium.angeloxx.ch/old-value"
+	EventNewValueAnnotation = "cilium.angeloxx.ch/new-value"
 )
@@ -0,0 +1,204 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vipclaim tracks how recently the holder of the VIP of every kube-vip policy
+// renewed its claim, the Lease of the kube-vip per-Service leader election. A kube-vip pod
+// that silently died keeps its node in the CiliumEgressGatewayPolicy, and the routing is
+// stale until the next failure: the age of the claim is exported, and the policies whose
+// claim is older than a threshold get the Stale condition.
+package vipclaim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/shard"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ConditionStale is True when the holder of the VIP did not renew its claim within
+	// the threshold
+	ConditionStale = "Stale"
+	// ReasonClaimExpired is the reason of the Stale condition True
+	ReasonClaimExpired = "ClaimExpired"
+	// ReasonClaimRenewed is the reason of the Stale condition False
+	ReasonClaimRenewed = "ClaimRenewed"
+)
+
+var (
+	claimAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "haegress_kubevip_claim_age_seconds",
+			Help: "Time since the holder of the VIP of the kube-vip policy renewed the Lease of the per-Service leader election",
+		},
+		[]string{"policy", "egress_ip", "exit_node"},
+	)
+
+	stalePolicies = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "haegress_kubevip_stale_claims",
+			Help: "kube-vip policies whose VIP claim is older than the stale threshold",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(claimAge, stalePolicies)
+}
+
+// Monitor reads every IntervalSeconds the renewal of the VIP claim of the kube-vip policies
+// owned by the replica, and sets their Stale condition when it is older than StaleSeconds
+type Monitor struct {
+	client.Client
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	Providers *provider.Registry
+	// Sharder, in sharding mode, selects the policies owned by the replica
+	Sharder *shard.Sharder
+	// EgressNamespace is the default namespace of the Services
+	EgressNamespace string
+	IntervalSeconds int
+	StaleSeconds    int
+}
+
+// SetupWithManager registers the monitor as a runnable of the Manager.
+func (m *Monitor) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(m)
+}
+
+// NeedLeaderElection returns false in sharding mode, where every replica monitors its own
+// policies
+func (m *Monitor) NeedLeaderElection() bool {
+	return m.Sharder == nil
+}
+
+// Start implements manager.Runnable and blocks until the context is cancelled.
+func (m *Monitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(m.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.checkAll(ctx); err != nil {
+				m.Log.Error(err, "unable to check the VIP claims of the kube-vip policies")
+			}
+		}
+	}
+}
+
+// checkAll exports the age of the claim of every policy and updates their Stale condition
+func (m *Monitor) checkAll(ctx context.Context) error {
+	var policies haegressv2.HAEgressGatewayPolicyList
+	if err := m.List(ctx, &policies); err != nil {
+		return err
+	}
+	// The policies deleted or moved to another provider are not exported anymore
+	claimAge.Reset()
+	stale := 0
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		age, ok, err := m.age(ctx, policy)
+		if err != nil {
+			m.Log.V(1).Info("Unable to read the VIP claim of the policy", "policy", policy.Name, "error", err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+		claimAge.WithLabelValues(policy.Name, policy.Status.IPAddress, policy.Status.ExitNode).Set(age.Seconds())
+		expired := age > time.Duration(m.StaleSeconds)*time.Second
+		if expired {
+			stale++
+		}
+		if err := m.setStale(ctx, policy, age, expired); err != nil {
+			m.Log.Error(err, "unable to update the Stale condition of the policy", "policy", policy.Name)
+		}
+	}
+	stalePolicies.Set(float64(stale))
+	return nil
+}
+
+// age returns the time since the holder of the VIP of the policy renewed its claim, false
+// when the policy is not monitored or has no Lease
+func (m *Monitor) age(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (time.Duration, bool, error) {
+	if !m.Sharder.Owns(policy.Name, policy.Labels) || !policy.DeletionTimestamp.IsZero() ||
+		haegressiputil.IsPaused(policy) || policy.Status.ExitNode == "" {
+		return 0, false, nil
+	}
+	vipProvider, err := m.Providers.ForPolicy(policy)
+	if err != nil {
+		return 0, false, nil
+	}
+	kubeVIP, ok := vipProvider.(*provider.KubeVIP)
+	if !ok {
+		return 0, false, nil
+	}
+	serviceNamespace := haegressiputil.ServiceNamespace(policy, m.EgressNamespace)
+	service := &corev1.Service{}
+	if err := m.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: serviceNamespace}, service); err != nil {
+		return 0, false, client.IgnoreNotFound(err)
+	}
+	renewTime, ok, err := kubeVIP.RenewTime(ctx, service)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return time.Since(renewTime), true, nil
+}
+
+// setStale updates the Stale condition of the policy, with a warning event when the claim
+// expires
+func (m *Monitor) setStale(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, age time.Duration, expired bool) error {
+	condition := metav1.Condition{
+		Type:               ConditionStale,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonClaimRenewed,
+		Message:            fmt.Sprintf("The node %s renews the claim of the VIP", policy.Status.ExitNode),
+		ObservedGeneration: policy.Generation,
+	}
+	if expired {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonClaimExpired
+		condition.Message = fmt.Sprintf("The node %s did not renew the claim of the VIP for more than %ds, kube-vip may be dead",
+			policy.Status.ExitNode, m.StaleSeconds)
+	}
+	wasExpired := meta.IsStatusConditionTrue(policy.Status.Conditions, ConditionStale)
+	patch := client.MergeFrom(policy.DeepCopy())
+	if !meta.SetStatusCondition(&policy.Status.Conditions, condition) {
+		return nil
+	}
+	if expired && !wasExpired {
+		m.Log.Info("VIP claim not renewed, the policy is stale", "policy", policy.Name, "node", policy.Status.ExitNode, "age", age.Round(time.Second).String())
+		m.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventStaleClaimReason, condition.Message)
+	}
+	return m.Status().Patch(ctx, policy, patch)
+}
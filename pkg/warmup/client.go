@@ -0,0 +1,105 @@
+package warmup
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Client wraps the client of the controllers, waiting for the Ramp before every write.
+// During the warm-up the updates and the patches are first sent as dry-run: when the
+// API server does not change the object the write is skipped and does not consume the
+// rate of the Ramp.
+func (r *Ramp) Client(c client.Client) client.Client {
+	if r == nil {
+		return c
+	}
+	return &rampClient{Client: c, ramp: r}
+}
+
+type rampClient struct {
+	client.Client
+	ramp *Ramp
+}
+
+func (c *rampClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *rampClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *rampClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.ramp.WarmingUp() && obj.GetResourceVersion() != "" {
+		dryRun := obj.DeepCopyObject().(client.Object)
+		if err := c.Client.Update(ctx, dryRun, append(opts, client.DryRunAll)...); err == nil && c.noop(obj, dryRun) {
+			return nil
+		}
+	}
+	if err := c.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *rampClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if c.ramp.WarmingUp() && obj.GetResourceVersion() != "" {
+		dryRun := obj.DeepCopyObject().(client.Object)
+		if err := c.Client.Patch(ctx, dryRun, patch, append(opts, client.DryRunAll)...); err == nil && c.noop(obj, dryRun) {
+			return nil
+		}
+	}
+	if err := c.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *rampClient) Status() client.SubResourceWriter {
+	return &rampStatusWriter{SubResourceWriter: c.Client.Status(), ramp: c.ramp}
+}
+
+// noop returns true when the dry-run left the object unchanged, the API server does not
+// bump the resourceVersion of a write without changes
+func (c *rampClient) noop(obj, dryRun client.Object) bool {
+	if dryRun.GetResourceVersion() != obj.GetResourceVersion() {
+		return false
+	}
+	c.ramp.noopSkipped()
+	return true
+}
+
+// rampStatusWriter waits for the Ramp before every status write, the status is updated
+// only when it changes so no dry-run is needed
+type rampStatusWriter struct {
+	client.SubResourceWriter
+	ramp *Ramp
+}
+
+func (w *rampStatusWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if err := w.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return w.SubResourceWriter.Create(ctx, obj, subResource, opts...)
+}
+
+func (w *rampStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if err := w.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return w.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+func (w *rampStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	if err := w.ramp.Wait(ctx); err != nil {
+		return err
+	}
+	return w.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+}
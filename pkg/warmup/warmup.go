@@ -0,0 +1,119 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package warmup smooths the burst of writes done by the controllers when a replica
+// becomes the leader and reconciles every policy at once, so a leader restart does not
+// slam the API server and the VIP providers.
+package warmup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// rampSteps is the number of steps the write rate is raised in during the warm-up
+const rampSteps = 10
+
+// Ramp limits the writes of the controllers during the warm-up: the rate starts from
+// InitialQPS and grows linearly up to MaxQPS in Duration, then the writes are no more
+// limited by the Ramp. A nil Ramp never limits the writes.
+type Ramp struct {
+	Log        logr.Logger
+	Duration   time.Duration
+	InitialQPS float64
+	MaxQPS     float64
+	// AllReplicas starts the warm-up on every replica, when the controllers do not wait
+	// for the leader election
+	AllReplicas bool
+
+	lock    sync.RWMutex
+	limiter *rate.Limiter
+	skipped int
+}
+
+// SetupWithManager registers the ramp as a runnable of the Manager, started with the
+// controllers
+func (r *Ramp) SetupWithManager(mgr ctrl.Manager) error {
+	r.limiter = rate.NewLimiter(rate.Limit(r.InitialQPS), 1)
+	return mgr.Add(r)
+}
+
+// NeedLeaderElection returns true unless the warm-up runs on every replica
+func (r *Ramp) NeedLeaderElection() bool {
+	return !r.AllReplicas
+}
+
+// Start implements manager.Runnable and raises the write rate until the end of the
+// warm-up
+func (r *Ramp) Start(ctx context.Context) error {
+	r.Log.Info("Warm-up started", "duration", r.Duration, "initialQPS", r.InitialQPS, "maxQPS", r.MaxQPS)
+
+	ticker := time.NewTicker(r.Duration / rampSteps)
+	defer ticker.Stop()
+	for step := 1; step <= rampSteps; step++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			qps := r.InitialQPS + (r.MaxQPS-r.InitialQPS)*float64(step)/rampSteps
+			r.limiter.SetLimit(rate.Limit(qps))
+		}
+	}
+
+	r.lock.Lock()
+	r.limiter = nil
+	skipped := r.skipped
+	r.lock.Unlock()
+	r.Log.Info("Warm-up completed", "skippedNoopWrites", skipped)
+	return nil
+}
+
+// WarmingUp returns true until the end of the warm-up, also before it starts, while the
+// replica waits for the leader election
+func (r *Ramp) WarmingUp() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.limiter != nil
+}
+
+// Wait blocks until the write can be done
+func (r *Ramp) Wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	limiter := r.limiter
+	r.lock.RUnlock()
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
+
+// noopSkipped counts a write skipped because the dry-run did not change the object
+func (r *Ramp) noopSkipped() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.skipped++
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package whatif predicts, without changing anything, what happens to the egress IPs
+// when a node is lost: the policies that move, the node where they likely land and the
+// expected convergence time, to rehearse the maintenance windows.
+package whatif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Outcome of a policy affected by the loss of the node
+const (
+	// OutcomeMoved policies move their egress IP to another node
+	OutcomeMoved = "Moved"
+	// OutcomeStranded policies have no other eligible node, the egress traffic stops
+	OutcomeStranded = "Stranded"
+	// OutcomeManual policies use the static provider, they stay on the lost node until
+	// the exit node annotation is changed
+	OutcomeManual = "Manual"
+	// OutcomePaused policies keep their CiliumEgressGatewayPolicy until resumed
+	OutcomePaused = "Paused"
+	// OutcomeStandbyReplaced policies lose a standby node of their gateway group, replaced
+	// by the next eligible node
+	OutcomeStandbyReplaced = "StandbyReplaced"
+)
+
+// DefaultNodeMonitorGracePeriod is the default --node-monitor-grace-period of the
+// kube-controller-manager
+const DefaultNodeMonitorGracePeriod = 40 * time.Second
+
+// ErrNodeNotFound is returned when no node has the hostname of the lost node
+var ErrNodeNotFound = errors.New("no node with the " + haegressip.NodeNameAnnotation + " label")
+
+// patchesPerMove are the patches of a moved policy: the nodeSelector of the
+// CiliumEgressGatewayPolicy and the status of the policy
+const patchesPerMove = 2
+
+// Options describe the operator and the cluster the prediction is made for
+type Options struct {
+	// DefaultProvider and DefaultNamespace are the provider and the Service namespace of
+	// the policies without the annotations
+	DefaultProvider  string
+	DefaultNamespace string
+	// PatchQPS is the rate of the patches of the operator
+	PatchQPS float64
+	// NodeMonitorGracePeriod is the time before a lost node is marked NotReady, the failure
+	// detection of the cloud providers
+	NodeMonitorGracePeriod time.Duration
+	// Detection is the failure detection time of the load balancer providers, by name
+	Detection map[string]time.Duration
+}
+
+// DefaultDetection returns the failure detection time of the load balancer providers with
+// their default settings
+func DefaultDetection() map[string]time.Duration {
+	return map[string]time.Duration{
+		// vip_leaseduration of the per-Service election
+		provider.KubeVIPName: 5 * time.Second,
+		// l2-announcements-lease-duration, then the lease is polled by the operator
+		provider.CiliumLBIPAMName: 15*time.Second + haegressip.LeaseCheckRequeueAfter,
+		// memberlist failure detection of the speakers
+		provider.MetalLBName: 10 * time.Second,
+	}
+}
+
+// Move is the predicted outcome of a policy
+type Move struct {
+	Policy   string `json:"policy"`
+	EgressIP string `json:"egressIP,omitempty"`
+	Provider string `json:"provider"`
+	Outcome  string `json:"outcome"`
+	// To is the node where the egress IP, or the standby, likely lands
+	To string `json:"to,omitempty"`
+	// Candidates is the number of eligible nodes
+	Candidates int    `json:"candidates"`
+	Reason     string `json:"reason"`
+	// DetectionSeconds is the time before the failure is detected by the provider
+	DetectionSeconds float64 `json:"detectionSeconds,omitempty"`
+}
+
+// Result is the prediction of the loss of a node
+type Result struct {
+	Node  string `json:"node"`
+	Moves []Move `json:"moves"`
+	// Load is the number of egress IPs per node after the failover
+	Load map[string]int `json:"load"`
+	// ConvergenceSeconds is the expected time until every moved policy is patched
+	ConvergenceSeconds float64 `json:"convergenceSeconds"`
+}
+
+// Simulate predicts the effect of the loss of the node with the given hostname label.
+// The cloud providers choose the node as the operator does; the load balancers elect it
+// on their own, so the least loaded eligible node is reported as the likely one.
+func Simulate(ctx context.Context, c client.Reader, node string, options Options) (*Result, error) {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+	found := false
+	for _, item := range nodes.Items {
+		found = found || item.Labels[haegressip.NodeNameAnnotation] == node
+	}
+	if !found {
+		return nil, fmt.Errorf("%w %s", ErrNodeNotFound, node)
+	}
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	result := &Result{Node: node, Moves: []Move{}, Load: map[string]int{}}
+	for _, policy := range policies.Items {
+		if policy.Status.ExitNode != "" && policy.Status.ExitNode != node {
+			result.Load[policy.Status.ExitNode]++
+		}
+	}
+
+	var detection time.Duration
+	moved := 0
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		name := policy.Annotation(haegressip.ProviderAnnotation)
+		if name == "" {
+			name = options.DefaultProvider
+		}
+		move := Move{Policy: policy.Name, EgressIP: policy.Status.IPAddress, Provider: name}
+
+		if policy.Status.ExitNode != node {
+			group, err := gatewayGroup(ctx, c, policy, options.DefaultNamespace)
+			if err != nil {
+				return nil, err
+			}
+			if !contains(group, node) {
+				continue
+			}
+			eligible, err := candidates(policy, nodes.Items, node, false)
+			if err != nil {
+				return nil, err
+			}
+			move.Outcome = OutcomeStandbyReplaced
+			move.Candidates = len(eligible)
+			move.Reason = "the node is a standby of the gateway group, the exit node does not change"
+			// The standby nodes are chosen in name order
+			for _, candidate := range eligible {
+				if !contains(group, candidate) {
+					move.To = candidate
+					break
+				}
+			}
+			result.Moves = append(result.Moves, move)
+			continue
+		}
+
+		if haegressiputil.IsPaused(policy) {
+			move.Outcome = OutcomePaused
+			move.Reason = "the policy is paused, its CiliumEgressGatewayPolicy keeps selecting the lost node"
+			result.Moves = append(result.Moves, move)
+			continue
+		}
+		if name == provider.StaticName {
+			move.Outcome = OutcomeManual
+			move.Reason = "the exit node is set with the " + haegressip.StaticExitNodeAnnotation + " annotation"
+			result.Moves = append(result.Moves, move)
+			continue
+		}
+
+		cloud := provider.IsCloud(name)
+		eligible, err := candidates(policy, nodes.Items, node, cloud)
+		if err != nil {
+			return nil, err
+		}
+		move.Candidates = len(eligible)
+		if len(eligible) == 0 {
+			move.Outcome = OutcomeStranded
+			move.Reason = "no other Ready node matches the nodeSelector of the policy"
+			result.Moves = append(result.Moves, move)
+			continue
+		}
+
+		move.Outcome = OutcomeMoved
+		if cloud {
+			move.To = eligible[0]
+			move.Reason = "first eligible node in name order, chosen by the operator"
+			if preferred := policy.Annotations[haegressip.PreferredExitNodeAnnotation]; contains(eligible, preferred) {
+				move.To = preferred
+				move.Reason = "preferred exit node of the policy"
+			}
+			move.DetectionSeconds = (options.NodeMonitorGracePeriod + haegressip.LeaseCheckRequeueAfter).Seconds()
+		} else {
+			move.To = leastLoaded(eligible, result.Load)
+			move.Reason = fmt.Sprintf("elected by %s, the least loaded of the eligible nodes is the likely one", name)
+			move.DetectionSeconds = options.Detection[name].Seconds()
+		}
+		result.Load[move.To]++
+		if d := time.Duration(move.DetectionSeconds * float64(time.Second)); d > detection {
+			detection = d
+		}
+		moved++
+		result.Moves = append(result.Moves, move)
+	}
+
+	if moved > 0 {
+		patching := 0.0
+		if options.PatchQPS > 0 {
+			patching = math.Ceil(float64(moved*patchesPerMove) / options.PatchQPS)
+		}
+		result.ConvergenceSeconds = detection.Seconds() + patching
+	}
+	return result, nil
+}
+
+// candidates returns the hostnames of the Ready nodes, other than the lost one, matching
+// the nodeSelector of the policy and not drained, in name order. The cloud providers also
+// need the providerID of the node.
+func candidates(policy *haegressv2.HAEgressGatewayPolicy, nodes []corev1.Node, lost string, cloud bool) ([]string, error) {
+	selector, err := haegressiputil.PolicyNodeSelector(policy)
+	if err != nil {
+		return nil, err
+	}
+	var eligible []string
+	for _, node := range nodes {
+		hostname := node.Labels[haegressip.NodeNameAnnotation]
+		if hostname == "" || hostname == lost || !selector.Matches(labels.Set(node.Labels)) ||
+			!haegressiputil.IsNodeReady(&node) || haegressiputil.IsNodeDrained(&node) {
+			continue
+		}
+		if cloud && node.Spec.ProviderID == "" {
+			continue
+		}
+		eligible = append(eligible, hostname)
+	}
+	sort.Strings(eligible)
+	return eligible, nil
+}
+
+// gatewayGroup returns the nodes of the gateway group of the policy, nil when the group
+// mode is not enabled
+func gatewayGroup(ctx context.Context, c client.Reader, policy *haegressv2.HAEgressGatewayPolicy, defaultNamespace string) ([]string, error) {
+	if haegressiputil.GatewayGroupSize(policy) < 2 {
+		return nil, nil
+	}
+	namespace := haegressiputil.ServiceNamespace(policy, defaultNamespace)
+	cegp := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s", namespace, policy.Name)}, cegp); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if cegp.Spec.EgressGateway == nil {
+		return nil, nil
+	}
+	return haegressiputil.GatewayGroupFromSelector(cegp.Spec.EgressGateway.NodeSelector), nil
+}
+
+// leastLoaded returns the node with the fewest egress IPs, the first in name order on ties
+func leastLoaded(nodes []string, load map[string]int) string {
+	best := nodes[0]
+	for _, node := range nodes[1:] {
+		if load[node] < load[best] {
+			best = node
+		}
+	}
+	return best
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceScope restricts the namespaces of the Services watched by the operator
+type NamespaceScope struct {
+	// Watch are the only namespaces watched, empty for every namespace
+	Watch []string
+	// Exclude are the namespaces never watched
+	Exclude []string
+	// Services are the only namespaces where the Services are created, empty for every
+	// watched namespace
+	Services []string
+}
+
+// Includes returns true when the Services of the namespace are watched
+func (s NamespaceScope) Includes(namespace string) bool {
+	for _, excluded := range s.Exclude {
+		if namespace == excluded {
+			return false
+		}
+	}
+	if len(s.Watch) == 0 {
+		return true
+	}
+	for _, watched := range s.Watch {
+		if namespace == watched {
+			return true
+		}
+	}
+	return false
+}
+
+// Writable returns true when the Services of the namespace can be created
+func (s NamespaceScope) Writable(namespace string) bool {
+	if !s.Includes(namespace) {
+		return false
+	}
+	if len(s.Services) == 0 {
+		return true
+	}
+	for _, writable := range s.Services {
+		if namespace == writable {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheOptions restricts the cache of the Manager to the objects the operator needs:
+// the Services matching serviceSelector, nil to cache every Service, of the namespaces
+// of the scope, and the Leases of the Cilium namespace and of leaseNamespaces, with
+// cache.AllNamespaces for every namespace. The managed fields, never read, are dropped
+// from every object, like the container images from the Nodes.
+func CacheOptions(serviceSelector labels.Selector, ciliumNamespace string, leaseNamespaces []string, scope NamespaceScope) cache.Options {
+	leases := map[string]cache.Config{ciliumNamespace: {}}
+	for _, namespace := range leaseNamespaces {
+		leases[namespace] = cache.Config{}
+	}
+	options := cache.Options{
+		DefaultTransform: stripManagedFields,
+		ByObject: map[client.Object]cache.ByObject{
+			&coordinationv1.Lease{}: {
+				Namespaces: leases,
+			},
+			&corev1.Node{}: {
+				Transform: stripNode,
+			},
+		},
+	}
+	services := cache.ByObject{}
+	if serviceSelector != nil && !serviceSelector.Empty() {
+		services.Label = serviceSelector
+	}
+	// With the watched namespaces, the Services are listed in every namespace, so the
+	// operator needs only a Role in each of them
+	if len(scope.Watch) > 0 {
+		services.Namespaces = make(map[string]cache.Config)
+		for _, namespace := range scope.Watch {
+			services.Namespaces[namespace] = cache.Config{}
+		}
+	}
+	if len(scope.Exclude) > 0 {
+		selectors := make([]fields.Selector, 0, len(scope.Exclude))
+		for _, namespace := range scope.Exclude {
+			selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+		}
+		services.Field = fields.AndSelectors(selectors...)
+	}
+	if services.Label != nil || services.Namespaces != nil || services.Field != nil {
+		options.ByObject[&corev1.Service{}] = services
+	}
+	return options
+}
+
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// stripNode keeps only the metadata and the conditions needed by the gateway groups
+func stripNode(obj interface{}) (interface{}, error) {
+	if node, ok := obj.(*corev1.Node); ok {
+		node.ManagedFields = nil
+		node.Status.Images = nil
+		node.Status.VolumesAttached = nil
+		node.Status.VolumesInUse = nil
+	}
+	return obj, nil
+}
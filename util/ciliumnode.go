@@ -0,0 +1,159 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/node/addressing"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeNetwork is a subnet directly attached to a node. Interface is empty when
+// the device connected to the subnet is not known.
+type NodeNetwork struct {
+	Interface string
+	Prefix    netip.Prefix
+}
+
+// NodeEgressInterfaces parses the egress interfaces annotation of a node, a comma
+// separated list of <MAC address or CIDR>=<device> entries. The keys are returned in
+// their canonical form, as used by CiliumNodeNetworks.
+func NodeEgressInterfaces(node *corev1.Node) (map[string]string, error) {
+	devices := map[string]string{}
+	value := strings.TrimSpace(node.Annotations[haegressip.EgressInterfacesAnnotation])
+	if value == "" {
+		return devices, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		key, device, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		key, device = strings.TrimSpace(key), strings.TrimSpace(device)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q, expected <MAC address or CIDR>=<device>", haegressip.EgressInterfacesAnnotation, entry)
+		}
+		if device == haegressip.EgressInterfaceAuto {
+			return nil, fmt.Errorf("invalid device of %s: must not be %s", key, haegressip.EgressInterfaceAuto)
+		}
+		if err := sanitize.InterfaceName(device); err != nil {
+			return nil, fmt.Errorf("invalid device of %s: %w", key, err)
+		}
+		if mac, err := net.ParseMAC(key); err == nil {
+			devices[mac.String()] = device
+		} else if prefix, err := netip.ParsePrefix(key); err == nil {
+			devices[prefix.Masked().String()] = device
+		} else {
+			return nil, fmt.Errorf("invalid %s entry %q: %s is not a MAC address or a CIDR", haegressip.EgressInterfacesAnnotation, entry, key)
+		}
+	}
+	return devices, nil
+}
+
+// CiliumNodeNetworks returns the subnets attached to a node, as reported by the
+// CiliumNode resource. Subnets are taken from the ENI and Azure IPAM status when
+// available, while the node addresses are expanded with the given prefix length
+// (IPv4 only, zero to ignore them). The devices, by MAC address or subnet, name
+// the interfaces, that are left empty when the device is not known.
+func CiliumNodeNetworks(node *ciliumv2.CiliumNode, devices map[string]string, prefixLength int) []NodeNetwork {
+	networks := []NodeNetwork{}
+	device := func(mac string, prefix netip.Prefix) string {
+		if parsed, err := net.ParseMAC(mac); err == nil {
+			if name, ok := devices[parsed.String()]; ok {
+				return name
+			}
+		}
+		return devices[prefix.String()]
+	}
+
+	eniIDs := make([]string, 0, len(node.Status.ENI.ENIs))
+	for id := range node.Status.ENI.ENIs {
+		eniIDs = append(eniIDs, id)
+	}
+	sort.Strings(eniIDs)
+	for _, id := range eniIDs {
+		eni := node.Status.ENI.ENIs[id]
+		if prefix, err := netip.ParsePrefix(eni.Subnet.CIDR); err == nil {
+			networks = append(networks, NodeNetwork{
+				Interface: device(eni.MAC, prefix.Masked()),
+				Prefix:    prefix.Masked(),
+			})
+		}
+	}
+
+	for _, azureInterface := range node.Status.Azure.Interfaces {
+		if prefix, err := netip.ParsePrefix(azureInterface.CIDR); err == nil {
+			networks = append(networks, NodeNetwork{
+				Interface: device(azureInterface.MAC, prefix.Masked()),
+				Prefix:    prefix.Masked(),
+			})
+		}
+	}
+
+	if prefixLength > 0 {
+		for _, address := range node.Spec.Addresses {
+			if address.Type != addressing.NodeInternalIP && address.Type != addressing.NodeExternalIP {
+				continue
+			}
+			ip, err := netip.ParseAddr(address.IP)
+			if err != nil || !ip.Is4() {
+				continue
+			}
+			if prefix, err := ip.Prefix(prefixLength); err == nil {
+				networks = append(networks, NodeNetwork{Interface: devices[prefix.String()], Prefix: prefix})
+			}
+		}
+	}
+
+	return networks
+}
+
+// FindNodeNetwork returns the first network containing the given IP address
+func FindNodeNetwork(networks []NodeNetwork, ip string) (NodeNetwork, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return NodeNetwork{}, false
+	}
+	for _, network := range networks {
+		if network.Prefix.Contains(addr) {
+			return network, true
+		}
+	}
+	return NodeNetwork{}, false
+}
+
+// LookupNodeNetwork fetches the node with the given hostname label and its CiliumNode,
+// named as the node, and returns the network that contains the IP address. The third
+// return value is false when the CiliumNode does not report any network, so the
+// address cannot be validated.
+func LookupNodeNetwork(ctx context.Context, r client.Client, hostname string, ip string, prefixLength int) (NodeNetwork, bool, bool, error) {
+	var nodeList corev1.NodeList
+	if err := r.List(ctx, &nodeList, client.MatchingLabels{haegressip.NodeNameAnnotation: hostname}); err != nil {
+		return NodeNetwork{}, false, false, err
+	}
+	if len(nodeList.Items) == 0 {
+		return NodeNetwork{}, false, false, apierrors.NewNotFound(corev1.Resource("nodes"), hostname)
+	}
+	node := &nodeList.Items[0]
+	devices, err := NodeEgressInterfaces(node)
+	if err != nil {
+		return NodeNetwork{}, false, false, err
+	}
+	ciliumNode := &ciliumv2.CiliumNode{}
+	if err := r.Get(ctx, types.NamespacedName{Name: node.Name}, ciliumNode); err != nil {
+		return NodeNetwork{}, false, false, err
+	}
+	networks := CiliumNodeNetworks(ciliumNode, devices, prefixLength)
+	if len(networks) == 0 {
+		return NodeNetwork{}, false, false, nil
+	}
+	network, found := FindNodeNetwork(networks, ip)
+	return network, found, true, nil
+}
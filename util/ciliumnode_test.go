@@ -0,0 +1,208 @@
+package util
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	eniTypes "github.com/cilium/cilium/pkg/aws/eni/types"
+	azureTypes "github.com/cilium/cilium/pkg/azure/types"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/node/addressing"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeEgressInterfaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		expected   map[string]string
+		fails      bool
+	}{
+		{
+			name:     "no annotation",
+			expected: map[string]string{},
+		},
+		{
+			name:       "MAC addresses and subnets",
+			annotation: "02:5E:2A:10:4B:C1=ens6, 10.0.2.7/24 = ens7",
+			expected:   map[string]string{"02:5e:2a:10:4b:c1": "ens6", "10.0.2.0/24": "ens7"},
+		},
+		{
+			name:       "missing device",
+			annotation: "10.0.2.0/24",
+			fails:      true,
+		},
+		{
+			name:       "auto device",
+			annotation: "10.0.2.0/24=auto",
+			fails:      true,
+		},
+		{
+			name:       "invalid device",
+			annotation: "10.0.2.0/24=ens 7",
+			fails:      true,
+		},
+		{
+			name:       "invalid key",
+			annotation: "ens6=ens7",
+			fails:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{haegressip.EgressInterfacesAnnotation: test.annotation},
+			}}
+			devices, err := NodeEgressInterfaces(node)
+			if test.fails {
+				if err == nil {
+					t.Errorf("the annotation %q was accepted: %v", test.annotation, devices)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(devices, test.expected) {
+				t.Errorf("the devices are %v, expected %v", devices, test.expected)
+			}
+		})
+	}
+}
+
+func testCiliumNode(name string) *ciliumv2.CiliumNode {
+	node := &ciliumv2.CiliumNode{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	node.Status.ENI.ENIs = map[string]eniTypes.ENI{
+		"eni-2": {Number: 1, MAC: "02:5e:2a:10:4b:c2", Subnet: eniTypes.AwsSubnet{CIDR: "10.0.2.0/24"}},
+		"eni-1": {Number: 0, MAC: "02:5e:2a:10:4b:c1", Subnet: eniTypes.AwsSubnet{CIDR: "10.0.1.0/24"}},
+	}
+	node.Status.Azure.Interfaces = []azureTypes.AzureInterface{{MAC: "00-0D-3A-10-4B-C3", CIDR: "10.1.0.0/16"}}
+	node.Spec.Addresses = []ciliumv2.NodeAddress{
+		{Type: addressing.NodeInternalIP, IP: "192.168.10.5"},
+		{Type: addressing.NodeInternalIP, IP: "2001:db8::5"},
+		{Type: addressing.NodeHostName, IP: "192.168.20.5"},
+	}
+	return node
+}
+
+func TestCiliumNodeNetworks(t *testing.T) {
+	tests := []struct {
+		name         string
+		devices      map[string]string
+		prefixLength int
+		expected     []NodeNetwork
+	}{
+		{
+			name: "unknown devices",
+			expected: []NodeNetwork{
+				{Prefix: netip.MustParsePrefix("10.0.1.0/24")},
+				{Prefix: netip.MustParsePrefix("10.0.2.0/24")},
+				{Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+			},
+		},
+		{
+			name: "devices by MAC address and subnet",
+			devices: map[string]string{
+				"02:5e:2a:10:4b:c2": "ens6",
+				"00:0d:3a:10:4b:c3": "eth1",
+				"10.0.1.0/24":       "ens5",
+				"192.168.10.0/24":   "bond0",
+			},
+			prefixLength: 24,
+			expected: []NodeNetwork{
+				{Interface: "ens5", Prefix: netip.MustParsePrefix("10.0.1.0/24")},
+				{Interface: "ens6", Prefix: netip.MustParsePrefix("10.0.2.0/24")},
+				{Interface: "eth1", Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+				{Interface: "bond0", Prefix: netip.MustParsePrefix("192.168.10.0/24")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks := CiliumNodeNetworks(testCiliumNode("worker-1"), test.devices, test.prefixLength)
+			if !reflect.DeepEqual(networks, test.expected) {
+				t.Errorf("the networks are %v, expected %v", networks, test.expected)
+			}
+		})
+	}
+}
+
+func TestFindNodeNetwork(t *testing.T) {
+	networks := []NodeNetwork{
+		{Interface: "ens5", Prefix: netip.MustParsePrefix("10.0.0.0/16")},
+		{Interface: "ens6", Prefix: netip.MustParsePrefix("10.0.2.0/24")},
+	}
+	tests := []struct {
+		ip       string
+		expected string
+		found    bool
+	}{
+		{ip: "10.0.2.7", expected: "ens5", found: true},
+		{ip: "10.1.0.7"},
+		{ip: "invalid"},
+	}
+	for _, test := range tests {
+		network, found := FindNodeNetwork(networks, test.ip)
+		if found != test.found || network.Interface != test.expected {
+			t.Errorf("the network of %s is %v (%t), expected %q", test.ip, network, found, test.expected)
+		}
+	}
+}
+
+func TestLookupNodeNetwork(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := ciliumv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	// The node name is not its hostname label
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "worker-1.example.com",
+		Labels:      map[string]string{haegressip.NodeNameAnnotation: "worker-1"},
+		Annotations: map[string]string{haegressip.EgressInterfacesAnnotation: "02:5e:2a:10:4b:c2=ens6"},
+	}}
+	invalid := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "worker-2",
+		Labels:      map[string]string{haegressip.NodeNameAnnotation: "worker-2"},
+		Annotations: map[string]string{haegressip.EgressInterfacesAnnotation: "ens6"},
+	}}
+	empty := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "worker-3",
+		Labels: map[string]string{haegressip.NodeNameAnnotation: "worker-3"},
+	}}
+	r := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, invalid, empty,
+		testCiliumNode("worker-1.example.com"), testCiliumNode("worker-2"),
+		&ciliumv2.CiliumNode{ObjectMeta: metav1.ObjectMeta{Name: "worker-3"}}).Build()
+	ctx := context.Background()
+
+	network, found, validated, err := LookupNodeNetwork(ctx, r, "worker-1", "10.0.2.7", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NodeNetwork{Interface: "ens6", Prefix: netip.MustParsePrefix("10.0.2.0/24")}
+	if !found || !validated || network != expected {
+		t.Errorf("the network of 10.0.2.7 is %v (found %t, validated %t), expected %v", network, found, validated, expected)
+	}
+	if _, found, validated, err := LookupNodeNetwork(ctx, r, "worker-1", "172.16.0.7", 0); err != nil || found || !validated {
+		t.Errorf("the unreachable IP returned found %t, validated %t, %v", found, validated, err)
+	}
+	if _, _, validated, err := LookupNodeNetwork(ctx, r, "worker-3", "10.0.2.7", 0); err != nil || validated {
+		t.Errorf("the CiliumNode without networks returned validated %t, %v", validated, err)
+	}
+	if _, _, _, err := LookupNodeNetwork(ctx, r, "worker-2", "10.0.2.7", 0); err == nil {
+		t.Error("the invalid annotation was accepted")
+	}
+	if _, _, _, err := LookupNodeNetwork(ctx, r, "worker-4", "10.0.2.7", 0); !apierrors.IsNotFound(err) {
+		t.Errorf("the missing node returned %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+)
+
+// RestrictSelectorsToCluster adds the Cilium cluster label to the pod selector of every
+// rule, so that only the endpoints of the given cluster are selected
+func RestrictSelectorsToCluster(selectors []ciliumv2.EgressRule, clusterName string) []ciliumv2.EgressRule {
+	restricted := make([]ciliumv2.EgressRule, 0, len(selectors))
+	for _, selector := range selectors {
+		rule := *selector.DeepCopy()
+		if rule.PodSelector == nil {
+			rule.PodSelector = &slimv1.LabelSelector{}
+		}
+		if rule.PodSelector.MatchLabels == nil {
+			rule.PodSelector.MatchLabels = make(map[string]slimv1.MatchLabelsValue)
+		}
+		rule.PodSelector.MatchLabels[haegressip.CiliumClusterLabel] = clusterName
+		restricted = append(restricted, rule)
+	}
+	return restricted
+}
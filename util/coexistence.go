@@ -0,0 +1,52 @@
+package util
+
+import (
+	"context"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/coexistence"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReportConflict updates the ConflictingController condition of the policy when the
+// verdict of the guard changed, with a warning event and a notification when the writes
+// of its objects are paused
+func ReportConflict(ctx context.Context, c client.Client, logger logr.Logger, recorder record.EventRecorder, options SyncOptions, policy *v2.HAEgressGatewayPolicy, verdict coexistence.Verdict) {
+	if !verdict.Changed {
+		return
+	}
+	if verdict.Conflict != nil {
+		message := verdict.Conflict.Message()
+		logger.Info("Another controller reverts the objects of the policy, backing off", "HAEgressGatewayPolicy", policy.Name,
+			"object", verdict.Conflict.Name, "manager", verdict.Conflict.Manager, "fields", verdict.Conflict.Fields,
+			"backoff", verdict.Conflict.Backoff.String())
+		recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventConflictingControllerReason, message)
+		options.Notifier.Notify(notify.Event{
+			Type:     notify.ConflictingController,
+			Policy:   policy.Name,
+			EgressIP: policy.Status.IPAddress,
+			ExitNode: policy.Status.ExitNode,
+			Message:  message,
+		})
+	} else {
+		logger.Info("The objects of the policy are not reverted anymore", "HAEgressGatewayPolicy", policy.Name)
+	}
+	current := &v2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), current); err != nil {
+		logger.Error(err, "unable to update the ConflictingController condition of the policy", "HAEgressGatewayPolicy", policy.Name)
+		return
+	}
+	patch := client.MergeFrom(current.DeepCopy())
+	if !meta.SetStatusCondition(&current.Status.Conditions, verdict.Condition(current.Generation)) {
+		return
+	}
+	if err := c.Status().Patch(ctx, current, patch); err != nil {
+		logger.Error(err, "unable to update the ConflictingController condition of the policy", "HAEgressGatewayPolicy", policy.Name)
+	}
+}
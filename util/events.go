@@ -0,0 +1,23 @@
+package util
+
+import (
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+)
+
+// EventAnnotations returns the annotations of an event about a change of the field of the
+// policy, so the event pipelines read the values without parsing the message. The empty
+// values are left out.
+func EventAnnotations(policy, field, oldValue, newValue string) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range map[string]string{
+		haegressip.HAEgressGatewayPolicyName: policy,
+		haegressip.EventFieldAnnotation:      field,
+		haegressip.EventOldValueAnnotation:   oldValue,
+		haegressip.EventNewValueAnnotation:   newValue,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
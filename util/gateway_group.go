@@ -0,0 +1,164 @@
+package util
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GatewayGroupSize returns the number of gateway nodes requested by the policy,
+// one (the exit node only) when the group mode is not enabled. The group mode needs the
+// interface mode: the egressIP is held only by the exit node, and a Cilium choosing
+// another node of the group would drop the traffic.
+func GatewayGroupSize(policy *v2.HAEgressGatewayPolicy) int {
+	if policy.Annotations[haegressip.EgressInterfaceAnnotation] == "" {
+		return 1
+	}
+	return requestedGroupSize(policy)
+}
+
+// GatewayGroupIgnored returns true when the policy requests a gateway group without the
+// interface mode, so only the exit node is selected
+func GatewayGroupIgnored(policy *v2.HAEgressGatewayPolicy) bool {
+	return requestedGroupSize(policy) > 1 && policy.Annotations[haegressip.EgressInterfaceAnnotation] == ""
+}
+
+func requestedGroupSize(policy *v2.HAEgressGatewayPolicy) int {
+	size, err := strconv.Atoi(policy.Annotations[haegressip.EgressGatewayGroupSizeAnnotation])
+	if err != nil || size < 1 {
+		return 1
+	}
+	return size
+}
+
+// GatewayGroupMembers returns the nodes of the gateway group of a policy: the exit
+// node that holds the VIP followed by the standby nodes, chosen in name order among
+// the Ready nodes matching the nodeSelector of the HAEgressGatewayPolicy.
+func GatewayGroupMembers(ctx context.Context, r client.Client, policy *v2.HAEgressGatewayPolicy, exitNode string, size int) ([]string, error) {
+	members := []string{exitNode}
+
+	listOptions := []client.ListOption{}
+	if nodeSelector := policy.EgressNodeSelector(); nodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(toLabelSelector(nodeSelector))
+		if err != nil {
+			return members, err
+		}
+		listOptions = append(listOptions, client.MatchingLabelsSelector{Selector: selector})
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes, listOptions...); err != nil {
+		return members, err
+	}
+	sort.Slice(nodes.Items, func(i, j int) bool {
+		return nodes.Items[i].Name < nodes.Items[j].Name
+	})
+
+	for _, node := range nodes.Items {
+		if len(members) >= size {
+			break
+		}
+		hostname := node.Labels[haegressip.NodeNameAnnotation]
+		if hostname == exitNode || sanitize.NodeName(hostname) != nil || !IsNodeReady(&node) || IsNodeDrained(&node) {
+			continue
+		}
+		members = append(members, hostname)
+	}
+	return members, nil
+}
+
+// GatewayGroupFromSelector returns the gateway group currently configured in a nodeSelector
+func GatewayGroupFromSelector(selector *slimv1.LabelSelector) []string {
+	if selector == nil {
+		return nil
+	}
+	for _, expression := range selector.MatchExpressions {
+		if expression.Key == haegressip.NodeNameAnnotation && expression.Operator == slimv1.LabelSelectorOpIn {
+			return expression.Values
+		}
+	}
+	return nil
+}
+
+// gatewayNodeSelector returns the nodeSelector of the CiliumEgressGatewayPolicy: the one of
+// the HAEgressGatewayPolicy without the hostname, that selects the exit node or, in group
+// mode, all the members of the group
+func gatewayNodeSelector(policy *v2.HAEgressGatewayPolicy, nodes []string, group bool) *slimv1.LabelSelector {
+	selector := &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{}}
+	if nodeSelector := policy.EgressNodeSelector(); nodeSelector != nil {
+		for key, value := range nodeSelector.MatchLabels {
+			if key != haegressip.NodeNameAnnotation {
+				selector.MatchLabels[key] = value
+			}
+		}
+		for _, expression := range nodeSelector.MatchExpressions {
+			if expression.Key != haegressip.NodeNameAnnotation {
+				selector.MatchExpressions = append(selector.MatchExpressions, *expression.DeepCopy())
+			}
+		}
+	}
+	if group {
+		selector.MatchExpressions = append(selector.MatchExpressions, slimv1.LabelSelectorRequirement{
+			Key:      haegressip.NodeNameAnnotation,
+			Operator: slimv1.LabelSelectorOpIn,
+			Values:   append([]string(nil), nodes...),
+		})
+	} else {
+		selector.MatchLabels[haegressip.NodeNameAnnotation] = nodes[0]
+	}
+	if len(selector.MatchLabels) == 0 {
+		selector.MatchLabels = nil
+	}
+	return selector
+}
+
+// PolicyNodeSelector returns the selector of the nodes eligible as exit nodes by the
+// nodeSelector of the policy, without the hostname set by the operator
+func PolicyNodeSelector(policy *v2.HAEgressGatewayPolicy) (labels.Selector, error) {
+	nodeSelector := policy.EgressNodeSelector()
+	if nodeSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(toLabelSelector(nodeSelector))
+}
+
+func toLabelSelector(selector *slimv1.LabelSelector) *metav1.LabelSelector {
+	labelSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{},
+	}
+	for key, value := range selector.MatchLabels {
+		if key != haegressip.NodeNameAnnotation {
+			labelSelector.MatchLabels[key] = value
+		}
+	}
+	for _, expression := range selector.MatchExpressions {
+		if expression.Key == haegressip.NodeNameAnnotation {
+			continue
+		}
+		labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      expression.Key,
+			Operator: metav1.LabelSelectorOperator(expression.Operator),
+			Values:   expression.Values,
+		})
+	}
+	return labelSelector
+}
+
+// IsNodeReady returns true when the Ready condition of the node is True
+func IsNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IPFamilyOptions configures the ipFamilyPolicy and the ipFamilies of the generated Services
+type IPFamilyOptions struct {
+	// Policy and Families are the defaults, overridden by the annotations of the policies,
+	// nil and empty to keep the defaults of the API server
+	Policy   *corev1.IPFamilyPolicy
+	Families []corev1.IPFamily
+	// Supported are the IP families of the Services of the cluster, discovered at startup,
+	// empty when unknown
+	Supported []corev1.IPFamily
+}
+
+// ParseIPFamilies returns the IP families of a comma separated list, the format is
+// validated by sanitize.IPFamilies
+func ParseIPFamilies(value string) []corev1.IPFamily {
+	var families []corev1.IPFamily
+	for _, family := range strings.Split(value, ",") {
+		if family = strings.TrimSpace(family); family != "" {
+			families = append(families, corev1.IPFamily(family))
+		}
+	}
+	return families
+}
+
+// ForPolicy returns the ipFamilyPolicy and the ipFamilies of the Service of the policy, an
+// error when the cluster can't provide them
+func (o IPFamilyOptions) ForPolicy(policy *v2.HAEgressGatewayPolicy) (*corev1.IPFamilyPolicy, []corev1.IPFamily, error) {
+	familyPolicy := o.Policy
+	families := o.Families
+	if value := policy.Annotations[haegressip.IPFamilyPolicyAnnotation]; value != "" {
+		annotated := corev1.IPFamilyPolicy(value)
+		familyPolicy = &annotated
+	}
+	if value := policy.Annotations[haegressip.IPFamiliesAnnotation]; value != "" {
+		families = ParseIPFamilies(value)
+	}
+	return familyPolicy, families, o.Check(familyPolicy, families)
+}
+
+// Check returns an error when the ipFamilyPolicy and the ipFamilies are inconsistent, or
+// not supported by the cluster
+func (o IPFamilyOptions) Check(familyPolicy *corev1.IPFamilyPolicy, families []corev1.IPFamily) error {
+	if familyPolicy != nil && *familyPolicy == corev1.IPFamilyPolicySingleStack && len(families) > 1 {
+		return fmt.Errorf("ipFamilyPolicy %s allows a single IP family, got %v", *familyPolicy, families)
+	}
+	if len(o.Supported) == 0 {
+		return nil
+	}
+	if familyPolicy != nil && *familyPolicy == corev1.IPFamilyPolicyRequireDualStack && len(o.Supported) < 2 {
+		return fmt.Errorf("ipFamilyPolicy %s requires a dual-stack cluster, the Services of the cluster support only %v",
+			*familyPolicy, o.Supported)
+	}
+	for _, family := range families {
+		if !o.supports(family) {
+			return fmt.Errorf("IP family %s not supported, the Services of the cluster support only %v", family, o.Supported)
+		}
+	}
+	return nil
+}
+
+func (o IPFamilyOptions) supports(family corev1.IPFamily) bool {
+	for _, supported := range o.Supported {
+		if family == supported {
+			return true
+		}
+	}
+	return false
+}
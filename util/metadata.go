@@ -0,0 +1,240 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations set on the generated objects when the tracking metadata is passed through,
+// so that Argo CD shows them in the tree of the application without pruning them or
+// reporting the application OutOfSync
+const (
+	argoCDCompareOptionsAnnotation = "argocd.argoproj.io/compare-options"
+	argoCDSyncOptionsAnnotation    = "argocd.argoproj.io/sync-options"
+)
+
+// gitOpsAnnotationPrefixes are the prefixes of the annotations driving the GitOps tools:
+// they apply to the policy synced from Git, never to the objects generated from it
+var gitOpsAnnotationPrefixes = []string{
+	"argocd.argoproj.io/",
+	"kustomize.toolkit.fluxcd.io/",
+	"helm.toolkit.fluxcd.io/",
+}
+
+// gitOpsLabelPrefixes are the prefixes of the labels set by Flux on the objects it applies
+var gitOpsLabelPrefixes = []string{
+	"kustomize.toolkit.fluxcd.io/",
+	"helm.toolkit.fluxcd.io/",
+}
+
+// trackingAnnotation is the annotation of the Argo CD annotation-based tracking
+const trackingAnnotation = "argocd.argoproj.io/tracking-id"
+
+// volatileAnnotations change at every apply or rollout of the policy, and would rewrite
+// the generated objects every time, they are never copied
+var volatileAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"kubectl.kubernetes.io/restartedAt",
+	"meta.helm.sh/release-name",
+	"meta.helm.sh/release-namespace",
+}
+
+// MetadataOptions selects the labels and annotations of the policies copied on the
+// generated CiliumEgressGatewayPolicies and Services
+type MetadataOptions struct {
+	// TrackingLabels are the labels used by the GitOps tools to track the objects they
+	// own, like the Argo CD instance label, not copied unless TrackingPassthrough is set
+	TrackingLabels []string
+	// TrackingPassthrough copies the tracking labels and annotations, marked so that Argo
+	// CD neither prunes the generated objects nor reports them as OutOfSync
+	TrackingPassthrough bool
+}
+
+// GeneratedMetadata returns the labels and the annotations of the objects generated from
+// the policy. The tracking metadata would make the GitOps tools consider the generated
+// objects as their own, and prune them or fight with the operator over their content.
+func (o MetadataOptions) GeneratedMetadata(policy *v2.HAEgressGatewayPolicy) (map[string]string, map[string]string) {
+	labels := make(map[string]string, len(policy.Labels))
+	for key, value := range policy.Labels {
+		if o.isTrackingLabel(key) && !o.TrackingPassthrough {
+			continue
+		}
+		labels[key] = value
+	}
+	annotations := make(map[string]string, len(policy.Annotations))
+	for key, value := range policy.Annotations {
+		if isVolatileAnnotation(key) {
+			continue
+		}
+		if key == trackingAnnotation && o.TrackingPassthrough {
+			annotations[key] = value
+			continue
+		}
+		if hasPrefix(key, gitOpsAnnotationPrefixes) || key == haegressip.SkipChildrenAnnotation ||
+			key == haegressip.ManagedMetadataAnnotation {
+			continue
+		}
+		annotations[key] = value
+	}
+	if o.TrackingPassthrough && (annotations[trackingAnnotation] != "" || o.hasTrackingLabel(labels)) {
+		annotations[argoCDCompareOptionsAnnotation] = "IgnoreExtraneous"
+		annotations[argoCDSyncOptionsAnnotation] = "Prune=false"
+	}
+	// The keys copied from the policy are recorded on the generated objects, so they are
+	// removed from them once removed from the policy
+	annotations[haegressip.ManagedMetadataAnnotation] = managedMetadata{
+		Labels:      SortedKeys(labels),
+		Annotations: SortedKeys(annotations),
+	}.String()
+	return labels, annotations
+}
+
+// managedMetadata are the keys of the labels and annotations copied from the policy on a
+// generated object, the value of its managed metadata annotation
+type managedMetadata struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+func (m managedMetadata) String() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// MetadataDrift applies to found the labels and annotations of desired, and removes the
+// ones copied from the policy at a previous reconciliation and not desired anymore, listed
+// in the managed metadata annotation. The labels and annotations added by other
+// controllers are kept. It returns a summary of the differences, in order.
+func MetadataDrift(found, desired metav1.Object) []string {
+	drift := []string{}
+	// An object generated before the keys were recorded, or with an invalid record, only
+	// gets the desired keys
+	previous := managedMetadata{}
+	_ = json.Unmarshal([]byte(found.GetAnnotations()[haegressip.ManagedMetadataAnnotation]), &previous)
+
+	labels := found.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for _, key := range SortedKeys(desired.GetLabels()) {
+		if value := desired.GetLabels()[key]; labels[key] != value {
+			drift = append(drift, fmt.Sprintf("label %s: %q -> %q", key, labels[key], value))
+			labels[key] = value
+		}
+	}
+	for _, key := range previous.Labels {
+		if _, ok := desired.GetLabels()[key]; ok {
+			continue
+		}
+		if value, ok := labels[key]; ok {
+			drift = append(drift, fmt.Sprintf("label %s: %q -> removed", key, value))
+			delete(labels, key)
+		}
+	}
+	found.SetLabels(labels)
+
+	annotations := found.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range SortedKeys(desired.GetAnnotations()) {
+		if value := desired.GetAnnotations()[key]; annotations[key] != value {
+			drift = append(drift, fmt.Sprintf("annotation %s: %q -> %q", key, annotations[key], value))
+			annotations[key] = value
+		}
+	}
+	for _, key := range previous.Annotations {
+		if _, ok := desired.GetAnnotations()[key]; ok || key == haegressip.ManagedMetadataAnnotation {
+			continue
+		}
+		if value, ok := annotations[key]; ok {
+			drift = append(drift, fmt.Sprintf("annotation %s: %q -> removed", key, value))
+			delete(annotations, key)
+		}
+	}
+	found.SetAnnotations(annotations)
+	return drift
+}
+
+// StaleMetadata removes from the generated object the tracking labels and annotations,
+// and the volatile annotations, copied from the policy before they were filtered, and
+// returns the removed keys in order
+func (o MetadataOptions) StaleMetadata(obj metav1.Object) []string {
+	removed := []string{}
+	labels := obj.GetLabels()
+	for _, key := range SortedKeys(labels) {
+		if o.isTrackingLabel(key) && !o.TrackingPassthrough {
+			delete(labels, key)
+			removed = append(removed, "label "+key)
+		}
+	}
+	annotations := obj.GetAnnotations()
+	for _, key := range SortedKeys(annotations) {
+		gitOps := hasPrefix(key, gitOpsAnnotationPrefixes) || key == haegressip.SkipChildrenAnnotation
+		if isVolatileAnnotation(key) || (gitOps && !o.TrackingPassthrough) {
+			delete(annotations, key)
+			removed = append(removed, "annotation "+key)
+		}
+	}
+	return removed
+}
+
+func (o MetadataOptions) isTrackingLabel(key string) bool {
+	for _, label := range o.TrackingLabels {
+		if key == label {
+			return true
+		}
+	}
+	return hasPrefix(key, gitOpsLabelPrefixes)
+}
+
+func (o MetadataOptions) hasTrackingLabel(labels map[string]string) bool {
+	for key := range labels {
+		if o.isTrackingLabel(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipsChildren returns true when the CiliumEgressGatewayPolicy and the Service of the
+// policy are owned by Git: the operator neither creates nor updates them, it only patches
+// the egress IP and the gateway node of the CiliumEgressGatewayPolicy
+func SkipsChildren(policy *v2.HAEgressGatewayPolicy) bool {
+	return policy.Annotations[haegressip.SkipChildrenAnnotation] == "true"
+}
+
+// SortedKeys returns the keys of the map in order, so the objects and the messages built
+// from a map are the same at every reconciliation
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isVolatileAnnotation(key string) bool {
+	for _, annotation := range volatileAnnotations {
+		if key == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
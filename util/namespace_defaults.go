@@ -0,0 +1,72 @@
+package util
+
+import (
+	"fmt"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceDefaults are the annotations of the Namespaces with the defaults of the
+// annotations of the policies
+var namespaceDefaults = map[string]string{
+	haegressip.DefaultProviderAnnotation:          haegressip.ProviderAnnotation,
+	haegressip.DefaultIPAMAnnotation:              haegressip.IPAMAnnotation,
+	haegressip.DefaultLoadBalancerClassAnnotation: haegressip.LoadBalancerClassAnnotation,
+}
+
+// IsNamespaceDefault returns true for the annotations of the Namespaces with the defaults
+// of the policies
+func IsNamespaceDefault(annotation string) bool {
+	_, ok := namespaceDefaults[annotation]
+	return ok || annotation == haegressip.DefaultEligibleNodesAnnotation
+}
+
+// NamespaceDefaults returns the defaults of the policies in the annotations of the
+// namespace, keyed by the annotation of the policy they stand for, or
+// v2.EligibleNodesDefault for the nodeSelector
+func NamespaceDefaults(namespace *corev1.Namespace) (map[string]string, error) {
+	defaults := map[string]string{}
+	for namespaceAnnotation, policyAnnotation := range namespaceDefaults {
+		if value := namespace.Annotations[namespaceAnnotation]; value != "" {
+			defaults[policyAnnotation] = value
+		}
+	}
+	if value := namespace.Annotations[haegressip.DefaultEligibleNodesAnnotation]; value != "" {
+		selector, err := metav1.ParseToLabelSelector(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of the namespace %s: %w", haegressip.DefaultEligibleNodesAnnotation, namespace.Name, err)
+		}
+		defaults[v2.EligibleNodesDefault] = metav1.FormatLabelSelector(selector)
+	}
+	return defaults, nil
+}
+
+// UsedNamespaceDefaults returns the defaults used by the policy, the ones of the values it
+// does not set: the provider, the IPAM, the load balancer class and, when its nodeSelector
+// is empty, the eligible nodes. A value set on the policy always wins.
+func UsedNamespaceDefaults(policy *v2.HAEgressGatewayPolicy, defaults map[string]string) map[string]string {
+	used := map[string]string{}
+	for key, value := range defaults {
+		if !setByPolicy(policy, key) {
+			used[key] = value
+		}
+	}
+	return used
+}
+
+// setByPolicy returns true when the policy sets the annotation, or its own nodeSelector for
+// the eligible nodes
+func setByPolicy(policy *v2.HAEgressGatewayPolicy, key string) bool {
+	if key != v2.EligibleNodesDefault {
+		_, ok := policy.Annotations[key]
+		return ok
+	}
+	if policy.Spec.EgressGateway == nil || policy.Spec.EgressGateway.NodeSelector == nil {
+		return false
+	}
+	selector := toLabelSelector(policy.Spec.EgressGateway.NodeSelector)
+	return len(selector.MatchLabels) > 0 || len(selector.MatchExpressions) > 0
+}
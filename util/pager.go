@@ -0,0 +1,82 @@
+package util
+
+import (
+	"context"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultListPageSize is the number of objects returned by every page of the Pager
+const DefaultListPageSize = 500
+
+// Pager lists the objects from the API server in pages, so the full lists of the
+// periodic sweeps stay within the API priority and fairness budget on large clusters.
+// The first page is requested not older than the previous full list, so the API server
+// can serve it from its watch cache, the next pages continue from the same snapshot.
+// Use a Pager for every sweep, it remembers the resourceVersion of the last list.
+type Pager struct {
+	// Reader should be a non-cached reader, the cache does not support continue tokens
+	Reader   client.Reader
+	PageSize int64
+
+	lock            sync.Mutex
+	resourceVersion string
+}
+
+// List calls page for every page of objects matching opts, list is filled with the
+// objects of the page
+func (p *Pager) List(ctx context.Context, list client.ObjectList, page func() error, opts ...client.ListOption) error {
+	pageSize := p.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultListPageSize
+	}
+
+	p.lock.Lock()
+	resourceVersion := p.resourceVersion
+	p.lock.Unlock()
+
+	listOptions := append([]client.ListOption{client.Limit(pageSize)}, opts...)
+	firstPage := listOptions
+	if resourceVersion != "" {
+		firstPage = append(firstPage, &client.ListOptions{Raw: &metav1.ListOptions{
+			ResourceVersion:      resourceVersion,
+			ResourceVersionMatch: metav1.ResourceVersionMatchNotOlderThan,
+		}})
+	}
+
+	continueToken := ""
+	for {
+		pageOptions := firstPage
+		if continueToken != "" {
+			// The continue token carries the resourceVersion of the first page
+			pageOptions = append(listOptions, client.Continue(continueToken))
+		}
+		if err := p.Reader.List(ctx, list, pageOptions...); err != nil {
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				// Start the next list from the current state
+				p.lock.Lock()
+				p.resourceVersion = ""
+				p.lock.Unlock()
+			}
+			return err
+		}
+		if continueToken == "" {
+			resourceVersion = list.GetResourceVersion()
+		}
+		if err := page(); err != nil {
+			return err
+		}
+		continueToken = list.GetContinue()
+		if continueToken == "" {
+			break
+		}
+	}
+
+	p.lock.Lock()
+	p.resourceVersion = resourceVersion
+	p.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"sort"
+
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+)
+
+// SortByPriority orders the policies by decreasing spec.priority and then by name, so the
+// work queued for many policies at once handles the critical ones first
+func SortByPriority(policies []v2.HAEgressGatewayPolicy) {
+	sort.SliceStable(policies, func(i, j int) bool {
+		return HigherPriority(&policies[i], &policies[j])
+	})
+}
+
+// HigherPriority returns true when the policy a is handled before the policy b
+func HigherPriority(a, b *v2.HAEgressGatewayPolicy) bool {
+	if a.Spec.Priority != b.Spec.Priority {
+		return a.Spec.Priority > b.Spec.Priority
+	}
+	return a.Name < b.Name
+}
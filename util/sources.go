@@ -0,0 +1,80 @@
+package util
+
+import (
+	"sort"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+)
+
+// SourceNamespaces restricts the namespaces of the pods the policies can select
+type SourceNamespaces struct {
+	// Allowed are the only namespaces whose pods can be selected, empty for every namespace
+	Allowed []string
+	// Denied are the namespaces whose pods are never selected
+	Denied []string
+}
+
+// IsRestricted returns true when the source namespaces are restricted
+func (s SourceNamespaces) IsRestricted() bool {
+	return len(s.Allowed) > 0 || len(s.Denied) > 0
+}
+
+// Allows returns true when the pods of the namespace can be selected
+func (s SourceNamespaces) Allows(namespace string) bool {
+	for _, denied := range s.Denied {
+		if namespace == denied {
+			return false
+		}
+	}
+	if len(s.Allowed) == 0 {
+		return true
+	}
+	for _, allowed := range s.Allowed {
+		if namespace == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictSelectorsToNamespaces adds the requirements on the namespace of the pods to the
+// pod selector of every rule, so that Cilium selects only the pods of the allowed
+// namespaces whatever the selectors of the policy and the labels of the namespaces
+func RestrictSelectorsToNamespaces(selectors []ciliumv2.EgressRule, sources SourceNamespaces) []ciliumv2.EgressRule {
+	if !sources.IsRestricted() {
+		return selectors
+	}
+	var requirements []slimv1.LabelSelectorRequirement
+	if len(sources.Allowed) > 0 {
+		requirements = append(requirements, slimv1.LabelSelectorRequirement{
+			Key:      haegressip.PodNamespaceLabel,
+			Operator: slimv1.LabelSelectorOpIn,
+			Values:   sortedCopy(sources.Allowed),
+		})
+	}
+	if len(sources.Denied) > 0 {
+		requirements = append(requirements, slimv1.LabelSelectorRequirement{
+			Key:      haegressip.PodNamespaceLabel,
+			Operator: slimv1.LabelSelectorOpNotIn,
+			Values:   sortedCopy(sources.Denied),
+		})
+	}
+	restricted := make([]ciliumv2.EgressRule, 0, len(selectors))
+	for _, selector := range selectors {
+		rule := *selector.DeepCopy()
+		if rule.PodSelector == nil {
+			rule.PodSelector = &slimv1.LabelSelector{}
+		}
+		rule.PodSelector.MatchExpressions = append(rule.PodSelector.MatchExpressions, requirements...)
+		restricted = append(restricted, rule)
+	}
+	return restricted
+}
+
+func sortedCopy(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -2,9 +2,18 @@ package util
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/audit"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/batch"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/coexistence"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/dampening"
+	haegressmetrics "github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/notify"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/sanitize"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -13,9 +22,45 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"time"
 )
 
-func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, service corev1.Service, ciliumEgressGatewayPolicy ciliumv2.CiliumEgressGatewayPolicy) (ctrl.Result, error) {
+// SyncOptions are the operator-wide settings used while synchronizing a Service with
+// its CiliumEgressGatewayPolicy
+type SyncOptions struct {
+	// EgressSubnetPrefixLength is used to derive the node subnets from the node
+	// addresses when validating the egress IP, zero to disable it
+	EgressSubnetPrefixLength int
+	// Providers resolves the VIP provider of each policy, kube-vip is used when nil
+	Providers *provider.Registry
+	// Notifier sends the egress IP changes to the external endpoints, nil to disable it
+	Notifier *notify.Notifier
+	// Auditor records the egressIP and nodeSelector changes, nil to disable it
+	Auditor *audit.Auditor
+	// Namespaces are the namespaces of the Services watched by the operator
+	Namespaces NamespaceScope
+	// Patcher applies the patches of the CiliumEgressGatewayPolicies and of the policy
+	// statuses in bulk, nil to apply them immediately
+	Patcher *batch.Patcher
+	// Dampener delays the patches of a CiliumEgressGatewayPolicy following another one
+	// within its minimum interval, nil to disable it
+	Dampener *dampening.Dampener
+	// Guard pauses the writes of the CiliumEgressGatewayPolicies reverted by another
+	// controller, nil to disable it
+	Guard *coexistence.Guard
+}
+
+// providerFor returns the VIP provider used by the policy
+func (o SyncOptions) providerFor(policy *v2.HAEgressGatewayPolicy) (provider.Provider, error) {
+	if o.Providers == nil {
+		return &provider.KubeVIP{}, nil
+	}
+	return o.Providers.ForPolicy(policy)
+}
+
+func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, options SyncOptions, service corev1.Service, ciliumEgressGatewayPolicy ciliumv2.CiliumEgressGatewayPolicy) (ctrl.Result, error) {
 
 	// Get the parent HAEgressGatewayPolicy from the ciliumEgressGatewayPolicy
 	haEgressGatewayPolicy := &v2.HAEgressGatewayPolicy{}
@@ -29,74 +74,472 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 			break
 		}
 	}
+	// The CiliumEgressGatewayPolicy owned by Git has no owner, the policy is the one of the
+	// Service
+	if haEgressGatewayPolicy.Name == "" && service.Labels[haegressip.HAEgressGatewayPolicyName] != "" {
+		if err := r.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, haEgressGatewayPolicy); err != nil {
+			logger.Error(err, "unable to fetch the HAEgressGatewayPolicy, check RBAC permissions")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// A paused policy keeps its CiliumEgressGatewayPolicy as it is
+	if IsPaused(haEgressGatewayPolicy) {
+		logger.V(1).Info("HAEgressGatewayPolicy paused, ignoring.")
+		return ctrl.Result{}, nil
+	}
+
+	// The annotations are reported by the HAEgressGatewayPolicy controller
+	if err := sanitize.PolicyAnnotations(haEgressGatewayPolicy.Annotations); err != nil {
+		logger.V(1).Info("Invalid annotation of HAEgressGatewayPolicy, ignoring.", "error", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	vipProvider, err := options.providerFor(haEgressGatewayPolicy)
+	if err != nil {
+		logger.Error(err, "unable to select the VIP provider of the HAEgressGatewayPolicy")
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "provider_config", err)
+		return ctrl.Result{}, nil
+	}
+	// Providers whose state is not reflected on the Service must be polled
+	var pollResult ctrl.Result
+	if poller, ok := vipProvider.(provider.Poller); ok {
+		pollResult.RequeueAfter = poller.PollInterval()
+	}
+
+	egressIP, err := vipProvider.EgressIP(ctx, &service)
+	if err != nil {
+		logger.Error(err, "unable to get the egress IP from the provider", "provider", vipProvider.Name())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "provider_egress_ip", err)
+		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
+	}
+	// The providers moving the egress IP move it to the exit node first
+	var currentHost string
+	if ensurer, ok := vipProvider.(provider.Ensurer); ok {
+		currentHost, err = ensurer.Ensure(ctx, &service)
+	} else {
+		currentHost, err = vipProvider.ExitNode(ctx, &service)
+	}
+	if err != nil {
+		logger.Error(err, "unable to get the exit node from the provider", "provider", vipProvider.Name())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "provider_exit_node", err)
+		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
+	}
+
+	// Another controller reverting the CiliumEgressGatewayPolicy is not fought
+	verdict := options.Guard.Check(ctx, &ciliumEgressGatewayPolicy, coexistence.EgressIP, coexistence.NodeSelector, coexistence.Interface)
+	ReportConflict(ctx, r, logger, recorder, options, haEgressGatewayPolicy, verdict)
+	if verdict.Wait > 0 {
+		logger.V(1).Info("CiliumEgressGatewayPolicy reverted by another controller, not written", "after", verdict.Wait.String())
+		return ctrl.Result{RequeueAfter: verdict.Wait}, nil
+	}
+	if verdict.Recheck > 0 && (pollResult.RequeueAfter == 0 || pollResult.RequeueAfter > verdict.Recheck) {
+		pollResult.RequeueAfter = verdict.Recheck
+	}
 
 	policyHost := string(ciliumEgressGatewayPolicy.Spec.EgressGateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation])
-	currentHost := string(service.Annotations[haegressip.KubeVIPVipHostAnnotation])
-
-	if len(service.Status.LoadBalancer.Ingress) > 0 {
-		// Fetch updated version of the object in order to avoid to update with stale data
-		var ciliumEgressGatewayPolicyUpdated = ciliumv2.CiliumEgressGatewayPolicy{}
-		if err := r.Get(ctx, types.NamespacedName{Name: ciliumEgressGatewayPolicy.Name, Namespace: ciliumEgressGatewayPolicy.Namespace}, &ciliumEgressGatewayPolicyUpdated); err != nil {
-			logger.Error(err, "unable to fetch the CiliumEgressGatewayPolicy, during refresh before the update")
-			return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
-		}
-		if ciliumEgressGatewayPolicyUpdated.Spec.EgressGateway.EgressIP != service.Status.LoadBalancer.Ingress[0].IP {
-			ciliumEgressGatewayPolicyUpdated.Spec.EgressGateway.EgressIP = service.Status.LoadBalancer.Ingress[0].IP
-			if err := r.Update(ctx, &ciliumEgressGatewayPolicyUpdated); err != nil {
-				logger.Error(err, "unable to update the CiliumEgressGatewayPolicy with new assigned IP, retry later")
+	policyInterface := ciliumEgressGatewayPolicy.Spec.EgressGateway.Interface
+	interfaceMode := haEgressGatewayPolicy.Annotations[haegressip.EgressInterfaceAnnotation] != ""
+
+	if egressIP != "" {
+		// In interface mode Cilium uses the address of the interface, egressIP must stay empty.
+		// Only the egressIP is patched, so the object given by the caller, that may come from
+		// a stale cache or from a Create, does not need to be read again
+		if !interfaceMode && ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP != egressIP {
+			previousEgressIP := ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP
+			haegressmetrics.AssignmentDetected(haEgressGatewayPolicy.Name, egressIP)
+			// The first egress IP is never delayed
+			if wait := options.Dampener.Wait(haEgressGatewayPolicy.Name, haegressmetrics.FieldEgressIP, previousEgressIP == ""); wait > 0 {
+				logger.Info("CiliumEgressGatewayPolicy patched recently, the new egress IP is applied later", "LoadBalancerIP", egressIP, "after", wait.String())
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+			setEgressIP := func(egressGateway *ciliumv2.EgressGateway) {
+				egressGateway.EgressIP = egressIP
+			}
+			patch, err := egressGatewayPatch(&ciliumEgressGatewayPolicy, setEgressIP)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			err = batch.Apply(ctx, options.Patcher, r, batch.Change{
+				Object: &ciliumEgressGatewayPolicy,
+				Patch:  patch,
+				Done: func(err error) {
+					if err != nil {
+						logger.Error(err, "unable to update the CiliumEgressGatewayPolicy with new assigned IP, retry later")
+						haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "cegp_update", err)
+						return
+					}
+					logger.Info("Updated CiliumEgressGatewayPolicy with LoadBalancerIP", "LoadBalancerIP", egressIP)
+					options.Guard.Written(updatedEgressGateway(&ciliumEgressGatewayPolicy, setEgressIP), coexistence.EgressIP)
+					options.Dampener.Patched(haEgressGatewayPolicy.Name)
+					haegressmetrics.CEGPPatched(haegressmetrics.FieldEgressIP)
+					haegressmetrics.AssignmentApplied(vipProvider.Name(), haEgressGatewayPolicy.Name, egressIP)
+					recorder.AnnotatedEventf(haEgressGatewayPolicy,
+						EventAnnotations(haEgressGatewayPolicy.Name, haegressmetrics.FieldEgressIP, previousEgressIP, egressIP),
+						corev1.EventTypeNormal, haegressip.EventIPAssignedReason,
+						"Egress IP %s assigned by the %s provider to the Service %s/%s", egressIP, vipProvider.Name(), service.Namespace, service.Name)
+					options.Auditor.Record(audit.Record{
+						Action:   audit.ActionEgressIP,
+						Resource: "CiliumEgressGatewayPolicy/" + ciliumEgressGatewayPolicy.Name,
+						Policy:   haEgressGatewayPolicy.Name,
+						Old:      previousEgressIP,
+						New:      egressIP,
+						Reason: fmt.Sprintf("egress IP assigned by the %s provider to the Service %s/%s",
+							vipProvider.Name(), service.Namespace, service.Name),
+					})
+				},
+			})
+			if err != nil {
 				return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
 			}
-			logger.Info("Updated CiliumEgressGatewayPolicy with LoadBalancerIP", "LoadBalancerIP", service.Status.LoadBalancer.Ingress[0].IP)
-
 		}
-		if haEgressGatewayPolicy.Status.IPAddress != service.Status.LoadBalancer.Ingress[0].IP {
-			haEgressGatewayPolicy.Status.IPAddress = service.Status.LoadBalancer.Ingress[0].IP
+		if haEgressGatewayPolicy.Status.IPAddress != egressIP {
+			haEgressGatewayPolicy.Status.IPAddress = egressIP
 			haEgressGatewayPolicy.Status.LastModifiedTime = metav1.Now()
-			if err := r.Status().Update(ctx, haEgressGatewayPolicy); err != nil {
-				logger.Error(err, "unable to update the HAEgressGatewayPolicy with new assigned IP")
+			_ = batch.Apply(ctx, options.Patcher, r, statusChange(haEgressGatewayPolicy, func(err error) {
+				if err != nil {
+					logger.Error(err, "unable to update the HAEgressGatewayPolicy with new assigned IP")
+					haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "status_update", err)
+				}
+			}))
+			options.Notifier.Notify(notify.Event{
+				Type:     notify.EgressIPAssigned,
+				Policy:   haEgressGatewayPolicy.Name,
+				EgressIP: egressIP,
+				ExitNode: currentHost,
+				Message:  fmt.Sprintf("Egress IP %s assigned to HAEgressGatewayPolicy %s", egressIP, haEgressGatewayPolicy.Name),
+			})
+		}
+	}
+
+	// The egress IP of each family is reported on its own, so a family not assigned yet does
+	// not hold back the other one
+	ipv4Address, ipv6Address := provider.FamilyIPs(&service, egressIP)
+	if haEgressGatewayPolicy.Status.IPv4Address != ipv4Address || haEgressGatewayPolicy.Status.IPv6Address != ipv6Address {
+		haEgressGatewayPolicy.Status.IPv4Address = ipv4Address
+		haEgressGatewayPolicy.Status.IPv6Address = ipv6Address
+		_ = batch.Apply(ctx, options.Patcher, r, statusChange(haEgressGatewayPolicy, func(err error) {
+			if err != nil {
+				logger.Error(err, "unable to update the HAEgressGatewayPolicy with the egress IPs of each family")
+				haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "status_update", err)
 			}
+		}))
+	}
+
+	// A Service never getting its egress IP, e.g. with the address pool of the provider
+	// exhausted, would otherwise wait silently
+	if egressIP == "" && !service.CreationTimestamp.IsZero() {
+		waiting := time.Since(service.CreationTimestamp.Time)
+		if waiting >= haegressip.ProvisioningStalledAfter {
+			recorder.AnnotatedEventf(haEgressGatewayPolicy,
+				EventAnnotations(haEgressGatewayPolicy.Name, haegressmetrics.FieldEgressIP, "", ""),
+				corev1.EventTypeWarning, haegressip.EventProvisioningStalledReason,
+				"The %s provider did not assign an egress IP to the Service %s/%s within %s", vipProvider.Name(), service.Namespace, service.Name,
+				haegressip.ProvisioningStalledAfter)
+		} else if remaining := haegressip.ProvisioningStalledAfter - waiting; pollResult.RequeueAfter == 0 || pollResult.RequeueAfter > remaining {
+			pollResult.RequeueAfter = remaining
 		}
 	}
 
 	if currentHost == "" {
 		logger.V(1).Info(fmt.Sprintf("Service is still not assigned, ignoring."))
-		return ctrl.Result{}, nil
+		return pollResult, nil
+	}
+	// The exit node is read from annotations and labels any user with access to the Service
+	// or to the nodes can set, and ends up in the nodeSelector
+	if err := sanitize.NodeName(currentHost); err != nil {
+		logger.Info("Invalid exit node reported by the provider, ignoring.", "provider", vipProvider.Name(), "error", err.Error())
+		haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "invalid_value", err)
+		recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason,
+			fmt.Sprintf("Exit node reported by the %s provider not applied: %s", vipProvider.Name(), err))
+		return pollResult, nil
 	}
 
-	if haEgressGatewayPolicy.Status.ExitNode != currentHost {
+	exitNodeChanged := haEgressGatewayPolicy.Status.ExitNode != currentHost
+	if exitNodeChanged {
+		options.Notifier.Notify(notify.Event{
+			Type:             notify.ExitNodeChanged,
+			Policy:           haEgressGatewayPolicy.Name,
+			EgressIP:         egressIP,
+			ExitNode:         currentHost,
+			PreviousExitNode: haEgressGatewayPolicy.Status.ExitNode,
+			Message: fmt.Sprintf("Egress IP %s of HAEgressGatewayPolicy %s moved from node %q to node %q",
+				egressIP, haEgressGatewayPolicy.Name, haEgressGatewayPolicy.Status.ExitNode, currentHost),
+		})
 		haEgressGatewayPolicy.Status.ExitNode = currentHost
 		haEgressGatewayPolicy.Status.LastModifiedTime = metav1.Now()
-		if err := r.Status().Update(ctx, haEgressGatewayPolicy); err != nil {
-			logger.Error(err, "unable to update the HAEgressGatewayPolicy with new assigned exitNode")
+		// With the batch patcher, this change is combined with the egress IP one
+		_ = batch.Apply(ctx, options.Patcher, r, statusChange(haEgressGatewayPolicy, func(err error) {
+			if err != nil {
+				logger.Error(err, "unable to update the HAEgressGatewayPolicy with new assigned exitNode")
+				haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "status_update", err)
+			}
+		}))
+	}
+
+	// Check that the egress IP belongs to a network attached to the exit node and, if requested,
+	// use the interface attached to that network
+	currentInterface := policyInterface
+	if interfaceMode {
+		currentInterface = haEgressGatewayPolicy.Annotations[haegressip.EgressInterfaceAnnotation]
+	}
+	if egressIP != "" {
+		network, found, validated, err := LookupNodeNetwork(ctx, r, currentHost, egressIP, options.EgressSubnetPrefixLength)
+		if err != nil {
+			logger.V(1).Info("Unable to fetch the networks of the exit node, egress IP not validated", "node", currentHost, "error", err.Error())
+		} else if validated && !found {
+			logger.Info("Egress IP does not belong to any network attached to the exit node", "EgressIP", egressIP, "node", currentHost)
+			recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning,
+				haegressip.EventEgressIPNotRoutableReason,
+				fmt.Sprintf("Egress IP %s is not part of any network attached to node %s", egressIP, currentHost))
+			if exitNodeChanged {
+				options.Notifier.Notify(notify.Event{
+					Type:     notify.Degraded,
+					Policy:   haEgressGatewayPolicy.Name,
+					EgressIP: egressIP,
+					ExitNode: currentHost,
+					Message:  fmt.Sprintf("Egress IP %s is not part of any network attached to node %s", egressIP, currentHost),
+				})
+			}
+		} else if found && currentInterface == haegressip.EgressInterfaceAuto {
+			if network.Interface != "" {
+				currentInterface = network.Interface
+			} else if exitNodeChanged {
+				logger.Info("Egress interface of the exit node not known", "network", network.Prefix.String(), "node", currentHost)
+				recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventEgressInterfaceUnknownReason,
+					fmt.Sprintf("The device of network %s of node %s is not known, set it in the %s annotation of the node",
+						network.Prefix, currentHost, haegressip.EgressInterfacesAnnotation))
+			}
+		}
+	}
+	if currentInterface == haegressip.EgressInterfaceAuto {
+		// The interface could not be derived from the node, keep the current one
+		currentInterface = policyInterface
+	}
+	if currentInterface != "" && currentInterface != policyInterface {
+		if err := sanitize.InterfaceName(currentInterface); err != nil {
+			logger.Info("Invalid egress interface, ignoring.", "error", err.Error())
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "invalid_value", err)
+			recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventInvalidValueReason,
+				fmt.Sprintf("Egress interface not applied: %s", err))
+			return pollResult, nil
 		}
 	}
 
-	if policyHost == currentHost {
-		logger.V(1).Info(fmt.Sprintf("EgressGatewayPolicy already configured as expected with host %s, ignoring.", currentHost))
-		return ctrl.Result{}, nil
+	previousNodes := []string{policyHost}
+	currentNodes := []string{currentHost}
+	group := false
+	if groupSize := GatewayGroupSize(haEgressGatewayPolicy); groupSize > 1 {
+		// Group mode, the nodeSelector matches the exit node and a set of standby nodes
+		members, err := GatewayGroupMembers(ctx, r, haEgressGatewayPolicy, currentHost, groupSize)
+		if err != nil {
+			logger.Error(err, "unable to list the nodes of the gateway group, check RBAC permissions")
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "gateway_group", err)
+			return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
+		}
+		if err := sanitize.NodeNames(members); err != nil {
+			logger.Info("Invalid gateway group, ignoring.", "error", err.Error())
+			haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "invalid_value", err)
+			return pollResult, nil
+		}
+		previousNodes = GatewayGroupFromSelector(ciliumEgressGatewayPolicy.Spec.EgressGateway.NodeSelector)
+		currentNodes = members
+		group = true
 	}
 
-	logger.V(0).Info(fmt.Sprintf("EgressGatewayPolicy should be updated from %s to %s.", policyHost, currentHost))
+	// The patch is the difference with the nodeSelector of the HAEgressGatewayPolicy selecting
+	// the current nodes, so the keys not expected anymore are removed
+	setNodes := func(egressGateway *ciliumv2.EgressGateway) {
+		egressGateway.NodeSelector = gatewayNodeSelector(haEgressGatewayPolicy, currentNodes, group)
+		if currentInterface != "" {
+			// Cilium does not accept both interface and egressIP
+			egressGateway.Interface = currentInterface
+			egressGateway.EgressIP = ""
+		}
+	}
+	patch, err := egressGatewayPatch(&ciliumEgressGatewayPolicy, setNodes)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if patch == nil {
+		logger.V(1).Info(fmt.Sprintf("EgressGatewayPolicy already configured as expected with nodes %v, ignoring.", currentNodes))
+		return pollResult, nil
+	}
+	nodesChanged := !slices.Equal(previousNodes, currentNodes) || policyInterface != currentInterface
+	if nodesChanged {
+		logger.V(0).Info(fmt.Sprintf("EgressGatewayPolicy should be updated from %v to %v.", previousNodes, currentNodes))
+		haegressmetrics.FailoverDetected(haEgressGatewayPolicy.Name, currentHost)
+		// A move away from nodes that are not Ready anymore is a failover, never delayed
+		if options.Dampener != nil {
+			lost, err := nodesLost(ctx, r, previousNodes)
+			if err != nil {
+				logger.V(1).Info("Unable to check the readiness of the previous nodes, the patch is not delayed", "error", err.Error())
+				lost = true
+			}
+			if wait := options.Dampener.Wait(haEgressGatewayPolicy.Name, haegressmetrics.FieldNodeSelector, lost); wait > 0 {
+				logger.Info(fmt.Sprintf("CiliumEgressGatewayPolicy patched recently, the nodes %v are applied later", currentNodes), "after", wait.String())
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+	}
+	logger.V(0).Info(fmt.Sprintf("Patching cilium egress gateway policy %s with host %s", ciliumEgressGatewayPolicy.Name, currentHost))
+	err = batch.Apply(ctx, options.Patcher, r, batch.Change{
+		Object: &ciliumEgressGatewayPolicy,
+		Patch:  patch,
+		Done: func(err error) {
+			if err != nil {
+				logger.V(0).Info(fmt.Sprintf("Unable to patch cilium egress gateway policy %s", ciliumEgressGatewayPolicy.Name))
+				haegressmetrics.ReconcileError(haegressmetrics.ControllerServices, haEgressGatewayPolicy.Name, "cegp_patch", err)
+				return
+			}
+			haegressmetrics.CEGPPatched(haegressmetrics.FieldNodeSelector)
+			if currentInterface != "" {
+				options.Guard.Written(updatedEgressGateway(&ciliumEgressGatewayPolicy, setNodes), coexistence.NodeSelector, coexistence.Interface, coexistence.EgressIP)
+			} else {
+				options.Guard.Written(updatedEgressGateway(&ciliumEgressGatewayPolicy, setNodes), coexistence.NodeSelector)
+			}
+			if !nodesChanged {
+				logger.Info("Removed the stale keys of the nodeSelector of the CiliumEgressGatewayPolicy")
+				return
+			}
+			options.Dampener.Patched(haEgressGatewayPolicy.Name)
+			haegressmetrics.FailoverApplied(vipProvider.Name(), haEgressGatewayPolicy.Name, currentHost)
+			options.Auditor.Record(audit.Record{
+				Action:   audit.ActionNodeSelector,
+				Resource: "CiliumEgressGatewayPolicy/" + ciliumEgressGatewayPolicy.Name,
+				Policy:   haEgressGatewayPolicy.Name,
+				Old:      describeGateway(previousNodes, policyInterface),
+				New:      describeGateway(currentNodes, currentInterface),
+				Reason: fmt.Sprintf("exit node %s reported by the %s provider for the Service %s/%s",
+					currentHost, vipProvider.Name(), service.Namespace, service.Name),
+			})
 
-	// Modify egressPolicy nodeSelector to match the service
-	patchData := fmt.Sprintf(`{"spec":{"egressGateway":{"nodeSelector":{"matchLabels":{"%s":"%s"}}}}}`, haegressip.NodeNameAnnotation, currentHost)
+			annotations := EventAnnotations(haEgressGatewayPolicy.Name, haegressmetrics.FieldNodeSelector,
+				describeGateway(previousNodes, policyInterface), describeGateway(currentNodes, currentInterface))
+			recorder.AnnotatedEventf(haEgressGatewayPolicy, annotations, corev1.EventTypeNormal,
+				haegressip.EventExitNodeChangedReason,
+				"Exit node moved to %s, reported by the %s provider for the Service %s/%s",
+				currentHost, vipProvider.Name(), service.Namespace, service.Name)
 
-	logger.V(0).Info(fmt.Sprintf("Patching cilium egress gateway policy %s with host %s", ciliumEgressGatewayPolicy.Name, currentHost))
-	if err := r.Patch(ctx, &ciliumEgressGatewayPolicy, client.RawPatch(types.MergePatchType, []byte(patchData))); err != nil {
-		logger.V(0).Info(fmt.Sprintf("Unable to patch cilium egress gateway policy %s", ciliumEgressGatewayPolicy.Name))
+			recorder.AnnotatedEventf(&ciliumEgressGatewayPolicy, annotations, corev1.EventTypeNormal,
+				haegressip.EventExitNodeChangedReason,
+				"Updated with new nodeSelector %s=%s by %s/%s service",
+				haegressip.NodeNameAnnotation, currentHost,
+				service.Namespace, service.Name)
+
+			recorder.AnnotatedEventf(&service, annotations, corev1.EventTypeNormal,
+				haegressip.EventExitNodeChangedReason,
+				"Updated CiliumEgressGatewayPolicy %s with new nodeSelector %s=%s",
+				ciliumEgressGatewayPolicy.Name,
+				haegressip.NodeNameAnnotation, currentHost)
+		},
+	})
+	if err != nil {
 		return ctrl.Result{RequeueAfter: haegressip.LeaseCheckRequeueAfter}, err
 	}
+	return pollResult, nil
+}
 
-	recorder.Event(&ciliumEgressGatewayPolicy, "Normal",
-		haegressip.EventEgressUpdateReason,
-		fmt.Sprintf("Updated with new nodeSelector %s=%s by %s/%s service",
-			haegressip.NodeNameAnnotation, currentHost,
-			service.Namespace, service.Name))
+// updatedEgressGateway returns a copy of the policy with the update applied to its
+// egressGateway, the values written by a patch
+func updatedEgressGateway(policy *ciliumv2.CiliumEgressGatewayPolicy, update func(*ciliumv2.EgressGateway)) *ciliumv2.CiliumEgressGatewayPolicy {
+	updated := policy.DeepCopy()
+	if updated.Spec.EgressGateway == nil {
+		updated.Spec.EgressGateway = &ciliumv2.EgressGateway{}
+	}
+	update(updated.Spec.EgressGateway)
+	return updated
+}
 
-	recorder.Event(&service, "Normal",
-		haegressip.EventEgressUpdateReason,
-		fmt.Sprintf("Updated CiliumEgressGatewayPolicy %s with new nodeSelector %s=%s",
-			ciliumEgressGatewayPolicy.Name,
-			haegressip.NodeNameAnnotation, currentHost))
-	return ctrl.Result{}, nil
+// egressGatewayPatch returns the merge patch applying the update to the egressGateway of the
+// policy, nil when it changes nothing. The patch is computed from the typed objects, so the
+// values are escaped and the map keys removed by the update are removed by the patch.
+func egressGatewayPatch(policy *ciliumv2.CiliumEgressGatewayPolicy, update func(*ciliumv2.EgressGateway)) (map[string]interface{}, error) {
+	original := &ciliumv2.CiliumEgressGatewayPolicy{}
+	original.Spec.EgressGateway = &ciliumv2.EgressGateway{}
+	if policy.Spec.EgressGateway != nil {
+		original.Spec.EgressGateway = policy.Spec.EgressGateway.DeepCopy()
+	}
+	modified := original.DeepCopy()
+	update(modified.Spec.EgressGateway)
+
+	data, err := client.MergeFrom(original).Data(modified)
+	if err != nil {
+		return nil, err
+	}
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return patch, nil
+}
+
+// ServiceNamespace returns the namespace of the Service of the policy: the one of its
+// annotation, or the default egress namespace
+func ServiceNamespace(policy metav1.Object, defaultNamespace string) string {
+	if namespace := policy.GetAnnotations()[haegressip.HAEgressGatewayPolicyNamespace]; namespace != "" {
+		return namespace
+	}
+	return defaultNamespace
+}
+
+// IsPaused returns true when the reconciliation of the policy is paused by its annotation
+func IsPaused(policy *v2.HAEgressGatewayPolicy) bool {
+	return policy.Annotations[haegressip.PausedAnnotation] == "true"
+}
+
+// IsNodeDrained returns true when the node is drained of the egress IPs by its label
+func IsNodeDrained(node *corev1.Node) bool {
+	return node.Labels[haegressip.EgressDrainedNodeLabel] == "true"
+}
+
+// nodesLost returns true when none of the nodes, by hostname, is Ready anymore
+func nodesLost(ctx context.Context, r client.Client, nodes []string) (bool, error) {
+	for _, name := range nodes {
+		if name == "" {
+			continue
+		}
+		var nodeList corev1.NodeList
+		if err := r.List(ctx, &nodeList, client.MatchingLabels{haegressip.NodeNameAnnotation: name}); err != nil {
+			return false, err
+		}
+		for i := range nodeList.Items {
+			if IsNodeReady(&nodeList.Items[i]) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// statusChange returns the patch of the status of the policy, with the required fields
+func statusChange(policy *v2.HAEgressGatewayPolicy, done func(error)) batch.Change {
+	return batch.Change{
+		Object: policy,
+		Status: true,
+		Patch: map[string]interface{}{
+			"status": map[string]interface{}{
+				"serviceCreated":   policy.Status.ServiceCreated,
+				"policyCreated":    policy.Status.PolicyCreated,
+				"exitNode":         policy.Status.ExitNode,
+				"ipAddress":        policy.Status.IPAddress,
+				"ipv4Address":      policy.Status.IPv4Address,
+				"ipv6Address":      policy.Status.IPv6Address,
+				"lastModifiedTime": policy.Status.LastModifiedTime,
+			},
+		},
+		Done: done,
+	}
+}
+
+// describeGateway returns the audited description of the nodeSelector and interface
+func describeGateway(nodes []string, egressInterface string) string {
+	description := fmt.Sprintf("%s=%s", haegressip.NodeNameAnnotation, strings.Join(nodes, ","))
+	if egressInterface != "" {
+		description += " interface=" + egressInterface
+	}
+	return description
 }
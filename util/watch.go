@@ -0,0 +1,114 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"io"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
+	"time"
+)
+
+// WatchBackoff delays the restart of the watches of the cache that failed, on top of the
+// backoff of client-go (from 800ms to 30s), doubling the delay from Initial to Max at
+// every consecutive error. A watch without errors for twice Max restarts from Initial.
+type WatchBackoff struct {
+	Log     logr.Logger
+	Initial time.Duration
+	Max     time.Duration
+
+	lock    sync.Mutex
+	delays  map[string]time.Duration
+	last    map[string]time.Time
+	stopped chan struct{}
+}
+
+// errorHandlerSetter is implemented by the informers of a single namespace or of the whole
+// cluster, the informers of several namespaces keep the default handler
+type errorHandlerSetter interface {
+	SetWatchErrorHandler(handler toolscache.WatchErrorHandler) error
+}
+
+// SetupWithManager sets the watch error handler of the informers of the objects, before
+// the cache starts them, and registers the backoff as a runnable of the Manager to stop
+// the pending delays on shutdown
+func (b *WatchBackoff) SetupWithManager(mgr ctrl.Manager, objects ...client.Object) error {
+	b.delays = make(map[string]time.Duration)
+	b.last = make(map[string]time.Time)
+	b.stopped = make(chan struct{})
+	for _, object := range objects {
+		informer, err := mgr.GetCache().GetInformer(context.Background(), object)
+		if err != nil {
+			return err
+		}
+		setter, ok := informer.(errorHandlerSetter)
+		if !ok {
+			b.Log.V(1).Info("Informer without a watch error handler, using the default backoff", "type", typeName(object))
+			continue
+		}
+		if err := setter.SetWatchErrorHandler(b.handler(typeName(object))); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(b)
+}
+
+// NeedLeaderElection returns false, the cache runs on every replica
+func (b *WatchBackoff) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable and releases the pending delays when the context is
+// cancelled
+func (b *WatchBackoff) Start(ctx context.Context) error {
+	<-ctx.Done()
+	close(b.stopped)
+	return nil
+}
+
+// handler returns the watch error handler of the informer of the given type. The
+// reflector calls it before restarting the watch, so sleeping in it delays the restart.
+func (b *WatchBackoff) handler(name string) toolscache.WatchErrorHandler {
+	return func(r *toolscache.Reflector, err error) {
+		toolscache.DefaultWatchErrorHandler(r, err)
+		// A watch closed by the API server or expired is restarted immediately
+		if errors.Is(err, io.EOF) || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			return
+		}
+		delay := b.next(name)
+		b.Log.V(1).Info("Watch failed, delaying the restart", "type", name, "delay", delay.String(), "error", err.Error())
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-b.stopped:
+		}
+	}
+}
+
+// next returns the delay after a new error of the informer of the given type
+func (b *WatchBackoff) next(name string) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	delay := b.delays[name] * 2
+	if delay == 0 || now.Sub(b.last[name]) > 2*b.Max {
+		delay = b.Initial
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	b.delays[name] = delay
+	b.last[name] = now
+	return wait.Jitter(delay, 0.1)
+}
+
+func typeName(object client.Object) string {
+	return fmt.Sprintf("%T", object)
+}